@@ -4,15 +4,17 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"streamflow/internal/config"
+	"streamflow/internal/logger"
 	"streamflow/internal/server"
 	"syscall"
 	"time"
 )
 
-func gracefulShutdown(fiberServer *server.FiberServer, done chan bool) {
+func gracefulShutdown(fiberServer *server.FiberServer, timeout time.Duration, done chan bool) {
     // Create context that listens for the interrupt signal from the OS.
     ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
     defer stop()
@@ -20,18 +22,18 @@ func gracefulShutdown(fiberServer *server.FiberServer, done chan bool) {
     // Listen for the interrupt signal.
     <-ctx.Done()
 
-    log.Println("shutting down gracefully, press Ctrl+C again to force")
+    slog.Info("shutting down gracefully, press Ctrl+C again to force")
     stop() // Allow Ctrl+C to force shutdown
 
-    // The context is used to inform the server it has 5 seconds to finish
-    // the request it is currently handling
-    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    // Subsystems get the configured timeout to finish their work, e.g. the requests being
+    // handled and recordings being published
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
     defer cancel()
     if err := fiberServer.ShutdownWithContext(ctx); err != nil {
-        log.Printf("Server forced to shutdown with error: %v", err)
+        slog.Error("server forced to shutdown", "error", err)
     }
 
-    log.Println("Server exiting")
+    slog.Info("server exiting")
 
     // Notify the main goroutine that the shutdown is complete
     done <- true
@@ -55,6 +57,9 @@ func main() {
     if err := cfg.Validate(); err != nil {
         log.Fatalf("Invalid configuration: %v", err)
     }
+
+    // Switch to structured JSON logs now the log level is known
+    logger.Init(logger.New(os.Stderr, cfg.Server.LogLevel))
     
     // Log configuration (be careful not to log secrets in production)
     log.Printf("Server starting on %s:%d", cfg.Server.Host, cfg.Server.Port)
@@ -68,6 +73,13 @@ func main() {
 
     server.RegisterFiberRoutes()
 
+    // Reload the rate limits and CORS origins on SIGHUP
+    configFile := os.Getenv("CONFIG_FILE")
+    if configFile == "" {
+        configFile = ".env"
+    }
+    config.Watch(context.Background(), configFile, cfg, server.ApplyConfig)
+
     // Create a done channel to signal when the shutdown is complete
     done := make(chan bool, 1)
 
@@ -76,14 +88,23 @@ func main() {
         addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
         err := server.Listen(addr)
         if err != nil {
-            panic(fmt.Sprintf("http server error: %s", err))
+            slog.Error("http server error", "addr", addr, "error", err)
+            os.Exit(1)
         }
     }()
 
+    if cfg.Livestream.RTMPAddr != "" {
+        go func() {
+            if err := server.ListenRTMP(cfg.Livestream.RTMPAddr); err != nil {
+                slog.Error("RTMP ingest server error", "addr", cfg.Livestream.RTMPAddr, "error", err)
+            }
+        }()
+    }
+
     // Run graceful shutdown in a separate goroutine
-    go gracefulShutdown(server, done)
+    go gracefulShutdown(server, cfg.Server.ShutdownTimeout, done)
 
     // Wait for the graceful shutdown to complete
     <-done
-    log.Println("Graceful shutdown complete.")
+    slog.Info("graceful shutdown complete")
 }
\ No newline at end of file
@@ -0,0 +1,101 @@
+// Package apperr defines the kinds of errors services and handlers return. Each kind maps
+// to an HTTP status and a stable code clients can rely on, so every error response has
+// the same shape.
+package apperr
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Error kinds. Match them with errors.Is.
+var (
+	ErrValidation       = errors.New("validation failed")
+	ErrUnauthorized     = errors.New("unauthorized")
+	ErrForbidden        = errors.New("forbidden")
+	ErrNotFound         = errors.New("not found")
+	ErrConflict         = errors.New("conflict")
+	ErrTooLarge         = errors.New("payload too large")
+	ErrRangeUnsatisfied = errors.New("range not satisfiable")
+	ErrRateLimited      = errors.New("too many requests")
+	ErrInternal         = errors.New("internal error")
+	ErrUnavailable      = errors.New("service unavailable")
+)
+
+// kinds maps each error kind to its status and code
+var kinds = []struct {
+	kind   error
+	status int
+	code   string
+}{
+	{ErrValidation, http.StatusBadRequest, "validation_failed"},
+	{ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+	{ErrForbidden, http.StatusForbidden, "forbidden"},
+	{ErrNotFound, http.StatusNotFound, "not_found"},
+	{ErrConflict, http.StatusConflict, "conflict"},
+	{ErrTooLarge, http.StatusRequestEntityTooLarge, "payload_too_large"},
+	{ErrRangeUnsatisfied, http.StatusRequestedRangeNotSatisfiable, "range_not_satisfiable"},
+	{ErrRateLimited, http.StatusTooManyRequests, "rate_limited"},
+	{ErrInternal, http.StatusInternalServerError, "internal_error"},
+	{ErrUnavailable, http.StatusServiceUnavailable, "unavailable"},
+}
+
+// Error is an error of one kind with a message that is safe to show to clients
+type Error struct {
+	Kind    error
+	Message string
+}
+
+// New creates an error of the given kind
+func New(kind error, message string) *Error {
+	return &Error{Kind: kind, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// Validation reports a request that is malformed or breaks a rule
+func Validation(message string) error { return New(ErrValidation, message) }
+
+// Unauthorized reports a request without valid credentials
+func Unauthorized(message string) error { return New(ErrUnauthorized, message) }
+
+// Forbidden reports a request for something the caller may not access
+func Forbidden(message string) error { return New(ErrForbidden, message) }
+
+// NotFound reports a missing resource
+func NotFound(message string) error { return New(ErrNotFound, message) }
+
+// Conflict reports a request that clashes with the current state
+func Conflict(message string) error { return New(ErrConflict, message) }
+
+// TooLarge reports a request body over the allowed size
+func TooLarge(message string) error { return New(ErrTooLarge, message) }
+
+// Internal reports a failure on our side. The message is shown to clients, so it must
+// not include the underlying error.
+func Internal(message string) error { return New(ErrInternal, message) }
+
+// Unavailable reports a request we are too busy to handle right now
+func Unavailable(message string) error { return New(ErrUnavailable, message) }
+
+// Classify returns the status and code of err's kind. ok is false if err has no kind.
+func Classify(err error) (status int, code string, ok bool) {
+	for _, k := range kinds {
+		if errors.Is(err, k.kind) {
+			return k.status, k.code, true
+		}
+	}
+	return http.StatusInternalServerError, "internal_error", false
+}
+
+// StatusOf returns the HTTP status for err, 500 if it has no kind
+func StatusOf(err error) int {
+	status, _, _ := Classify(err)
+	return status
+}
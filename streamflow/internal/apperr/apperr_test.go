@@ -0,0 +1,70 @@
+package apperr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"streamflow/internal/logger"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		code    string
+		message string
+	}{
+		{"kind", NotFound("Video not found"), 404, "not_found", "Video not found"},
+		{"wrapped kind", fmt.Errorf("loading: %w", Forbidden("Access denied")), 403, "forbidden", "Access denied"},
+		{"bare kind", fmt.Errorf("name taken: %w", ErrConflict), 409, "conflict", "name taken: conflict"},
+		{"internal hides cause", fmt.Errorf("db down: %w", ErrInternal), 500, "internal_error", "Internal server error"},
+		{"unavailable", Unavailable("Too many uploads"), 503, "unavailable", "Too many uploads"},
+		{"fiber 404", fiber.ErrNotFound, 404, "not_found", "Not Found"},
+		{"fiber 405", fiber.ErrMethodNotAllowed, 405, "method_not_allowed", "Method Not Allowed"},
+		{"unknown", errors.New("connection refused"), 500, "internal_error", "Internal server error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, resp := Describe(tt.err)
+			if status != tt.status || resp.Code != tt.code || resp.Message != tt.message {
+				t.Errorf("Describe() = %d %+v, want %d %q %q", status, resp, tt.status, tt.code, tt.message)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: Handler})
+	app.Use(logger.Middleware(logger.New(io.Discard, "error")))
+	app.Get("/video", func(c *fiber.Ctx) error {
+		return Validation("Invalid video ID")
+	})
+
+	req := httptest.NewRequest("GET", "/video", nil)
+	req.Header.Set(logger.RequestIDHeader, "req-1")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("Status = %d, want 400", resp.StatusCode)
+	}
+
+	var body struct {
+		Error ErrorResponse `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	want := ErrorResponse{Code: "validation_failed", Message: "Invalid video ID", RequestID: "req-1"}
+	if body.Error != want {
+		t.Errorf("Error response = %+v, want %+v", body.Error, want)
+	}
+}
@@ -0,0 +1,62 @@
+package apperr
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"streamflow/internal/logger"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrorResponse is the body of every error response, sent as {"error": ErrorResponse}
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Describe returns the status and response for err. Errors with a kind get the kind's
+// status and code, and the message of the *Error carrying it. Fiber errors, such as the 404 and 405 of
+// unknown routes, keep their status. Anything else is a 500 that doesn't reveal the error.
+func Describe(err error) (int, ErrorResponse) {
+	if status, code, ok := Classify(err); ok {
+		var appErr *Error
+		switch {
+		case errors.As(err, &appErr):
+			return status, ErrorResponse{Code: code, Message: appErr.Message}
+		case status < http.StatusInternalServerError:
+			return status, ErrorResponse{Code: code, Message: err.Error()}
+		}
+		return status, ErrorResponse{Code: code, Message: "Internal server error"}
+	}
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code, ErrorResponse{Code: codeForStatus(fiberErr.Code), Message: fiberErr.Message}
+	}
+
+	return http.StatusInternalServerError, ErrorResponse{Code: "internal_error", Message: "Internal server error"}
+}
+
+// Handler is a fiber error handler writing err as an ErrorResponse tagged with the request ID
+func Handler(c *fiber.Ctx, err error) error {
+	status, resp := Describe(err)
+	resp.RequestID = logger.RequestID(c.UserContext())
+	return c.Status(status).JSON(fiber.Map{"error": resp})
+}
+
+// codeForStatus returns the code of the kind with the given status, or the status text
+// in snake case for statuses no kind uses
+func codeForStatus(status int) string {
+	for _, k := range kinds {
+		if k.status == status {
+			return k.code
+		}
+	}
+	if text := http.StatusText(status); text != "" {
+		return strings.ToLower(strings.NewReplacer(" ", "_", "-", "_", "'", "").Replace(text))
+	}
+	return "error"
+}
@@ -0,0 +1,194 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionLogin           = "user.login"
+	ActionLoginFailed     = "user.login_failed"
+	ActionPasswordReset   = "user.password_reset"
+	ActionRoleChange      = "user.role_change"
+	ActionSessionRevoke   = "user.session_revoke" // A user signed out one of their devices
+	ActionVideoDelete     = "video.delete"
+	ActionVideoReplace    = "video.replace" // The video's file was swapped for a new upload
+	ActionStreamKeyRotate = "livestream.rotate_key"
+	ActionChatPurge       = "livestream.chat_purge" // A user's messages in a stream were removed
+	ActionAdmin           = "admin.request"
+)
+
+// Target types of the records
+const (
+	TargetUser    = "user"
+	TargetEmail   = "email" // Failed logins, which may not match a user
+	TargetVideo   = "video"
+	TargetStream  = "livestream"
+	TargetSession = "session"
+	TargetRoute   = "route" // Admin requests, identified by method and path
+)
+
+const (
+	// DefaultPageSize is the number of records returned when no limit is given
+	DefaultPageSize = 50
+	// MaxPageSize caps how many records a single query can return
+	MaxPageSize = 200
+)
+
+// ErrInvalidCursor is returned when a pagination cursor isn't a record ID
+var ErrInvalidCursor = errors.New("invalid audit cursor")
+
+// Entry is an action to record. The service stamps it with an ID and the time.
+type Entry struct {
+	Actor      primitive.ObjectID // Zero when nobody is authenticated, as for failed logins
+	Action     string
+	TargetType string
+	TargetID   string
+	IP         string
+}
+
+// Record is an entry of the audit log. Records are only ever inserted.
+type Record struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	Actor      primitive.ObjectID `bson:"actor,omitempty" json:"actor,omitempty"`
+	Action     string             `bson:"action" json:"action"`
+	TargetType string             `bson:"target_type" json:"target_type"`
+	TargetID   string             `bson:"target_id" json:"target_id"`
+	IP         string             `bson:"ip" json:"ip"`
+	Timestamp  time.Time          `bson:"timestamp" json:"timestamp"`
+}
+
+// Filter selects records. Zero fields match every record.
+type Filter struct {
+	Actor  primitive.ObjectID
+	Action string
+	Since  time.Time // Inclusive
+	Until  time.Time // Exclusive
+	Before string    // Cursor from a previous page
+	Limit  int
+}
+
+// RecordPage is one page of the audit log, newest first.
+// NextCursor is empty when there are no older records.
+type RecordPage struct {
+	Records    []*Record `json:"records"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// AuditService writes and queries the audit log. It has no way to change or delete a
+// record, so the log is append-only. A nil service records nothing, so callers don't need
+// to check whether auditing is set up.
+type AuditService struct {
+	collection *mongo.Collection
+}
+
+// NewAuditService creates the audit log service and its indexes
+func NewAuditService(db *mongo.Database) *AuditService {
+	s := &AuditService{
+		collection: db.Collection("audit_log"),
+	}
+	s.createIndexes()
+	return s
+}
+
+// Record appends an entry to the audit log, timestamped now
+func (s *AuditService) Record(ctx context.Context, entry Entry) error {
+	if s == nil {
+		return nil
+	}
+	record := &Record{
+		ID:         primitive.NewObjectID(),
+		Actor:      entry.Actor,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		IP:         entry.IP,
+		Timestamp:  time.Now(),
+	}
+	if _, err := s.collection.InsertOne(ctx, record); err != nil {
+		return fmt.Errorf("failed to record %s: %w", entry.Action, err)
+	}
+	return nil
+}
+
+// TryRecord records an entry and logs a failure rather than returning it, for actions
+// that have already happened and shouldn't fail because the log is unavailable
+func (s *AuditService) TryRecord(ctx context.Context, entry Entry) {
+	if err := s.Record(ctx, entry); err != nil {
+		log.Printf("Audit: %v", err)
+	}
+}
+
+// Query returns the records matching the filter, newest first
+func (s *AuditService) Query(ctx context.Context, f Filter) (*RecordPage, error) {
+	if f.Limit <= 0 {
+		f.Limit = DefaultPageSize
+	}
+	if f.Limit > MaxPageSize {
+		f.Limit = MaxPageSize
+	}
+
+	filter := bson.M{}
+	if !f.Actor.IsZero() {
+		filter["actor"] = f.Actor
+	}
+	if f.Action != "" {
+		filter["action"] = f.Action
+	}
+	timestamp := bson.M{}
+	if !f.Since.IsZero() {
+		timestamp["$gte"] = f.Since
+	}
+	if !f.Until.IsZero() {
+		timestamp["$lt"] = f.Until
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+	if f.Before != "" {
+		beforeID, err := primitive.ObjectIDFromHex(f.Before)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		filter["_id"] = bson.M{"$lt": beforeID}
+	}
+
+	// IDs are created in time order, so sorting by them keeps the cursor stable when
+	// records share a timestamp
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetLimit(int64(f.Limit) + 1)
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	records := []*Record{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode audit records: %w", err)
+	}
+
+	page := &RecordPage{Records: records}
+	if len(records) > f.Limit {
+		page.Records = records[:f.Limit]
+		page.NextCursor = page.Records[f.Limit-1].ID.Hex()
+	}
+	return page, nil
+}
+
+func (s *AuditService) createIndexes() {
+	ctx := context.Background()
+	s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "action", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "timestamp", Value: -1}}},
+	})
+}
@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"streamflow/internal/apperr"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AuditHandler struct {
+	auditService *AuditService
+}
+
+func NewAuditHandler(auditService *AuditService) *AuditHandler {
+	return &AuditHandler{auditService: auditService}
+}
+
+// RequestEntry builds an entry for an action taken in a request, by its authenticated user
+// if there is one
+func RequestEntry(c *fiber.Ctx, action, targetType, targetID string) Entry {
+	var actor primitive.ObjectID
+	if userID, ok := c.Locals("user_id").(string); ok {
+		actor, _ = primitive.ObjectIDFromHex(userID)
+	}
+	return Entry{
+		Actor:      actor,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		IP:         c.IP(),
+	}
+}
+
+// ListRecords pages through the audit log newest first using ?before=<cursor>&limit=N,
+// optionally filtered by ?actor=<user ID>, ?action= and an RFC 3339 ?since= and ?until=
+func (h *AuditHandler) ListRecords(c *fiber.Ctx) error {
+	var filter Filter
+	var err error
+	if actor := c.Query("actor"); actor != "" {
+		if filter.Actor, err = primitive.ObjectIDFromHex(actor); err != nil {
+			return apperr.Validation("Invalid actor ID")
+		}
+	}
+	filter.Action = c.Query("action")
+	if since := c.Query("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339Nano, since); err != nil {
+			return apperr.Validation("Invalid since time")
+		}
+	}
+	if until := c.Query("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339Nano, until); err != nil {
+			return apperr.Validation("Invalid until time")
+		}
+	}
+	filter.Before = c.Query("before")
+	filter.Limit, _ = strconv.Atoi(c.Query("limit", strconv.Itoa(DefaultPageSize)))
+
+	page, err := h.auditService.Query(c.UserContext(), filter)
+	if errors.Is(err, ErrInvalidCursor) {
+		return apperr.Validation("Invalid cursor")
+	}
+	if err != nil {
+		return apperr.Internal("could not fetch audit log")
+	}
+	return c.Status(fiber.StatusOK).JSON(page)
+}
@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"streamflow/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var testAuditService *AuditService
+var testDbService database.Service
+
+func TestMain(m *testing.M) {
+	log.Printf("=== AUDIT SERVICE DATABASE TESTS ===")
+
+	originalDbName := os.Getenv("DB_NAME")
+	os.Setenv("DB_NAME", "test_streamflow_audit")
+
+	if os.Getenv("DB_URI") == "" {
+		log.Printf("ERROR: DB_URI not set. Please set DB_URI in your .env file")
+		os.Exit(1)
+	}
+
+	testDbService = database.New()
+	testAuditService = NewAuditService(testDbService.GetDatabase())
+
+	code := m.Run()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	testDbService.GetDatabase().Drop(ctx)
+	testDbService.Close()
+
+	if originalDbName != "" {
+		os.Setenv("DB_NAME", originalDbName)
+	}
+
+	os.Exit(code)
+}
+
+func TestAuditService_RecordAndQuery(t *testing.T) {
+	ctx := context.Background()
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+	start := time.Now()
+
+	entries := []Entry{
+		{Actor: alice, Action: ActionLogin, TargetType: TargetUser, TargetID: alice.Hex(), IP: "10.0.0.1"},
+		{Actor: alice, Action: ActionVideoDelete, TargetType: TargetVideo, TargetID: primitive.NewObjectID().Hex(), IP: "10.0.0.1"},
+		{Actor: bob, Action: ActionStreamKeyRotate, TargetType: TargetStream, TargetID: primitive.NewObjectID().Hex(), IP: "10.0.0.2"},
+		{Action: ActionLoginFailed, TargetType: TargetEmail, TargetID: "nobody@example.com", IP: "10.0.0.3"},
+		{Actor: alice, Action: ActionVideoDelete, TargetType: TargetVideo, TargetID: primitive.NewObjectID().Hex(), IP: "10.0.0.1"},
+	}
+	for _, entry := range entries {
+		if err := testAuditService.Record(ctx, entry); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	t.Run("Filters by actor, newest first", func(t *testing.T) {
+		page, err := testAuditService.Query(ctx, Filter{Actor: alice})
+		if err != nil {
+			t.Fatalf("Query() error = %v", err)
+		}
+		if len(page.Records) != 3 {
+			t.Fatalf("Query() returned %d records, want 3", len(page.Records))
+		}
+		if page.Records[0].TargetID != entries[4].TargetID || page.Records[2].Action != ActionLogin {
+			t.Errorf("Query() records are not newest first")
+		}
+		for _, record := range page.Records {
+			if record.Actor != alice || record.Timestamp.Before(start.Truncate(time.Millisecond)) {
+				t.Errorf("Query() returned record %+v", record)
+			}
+		}
+	})
+
+	t.Run("Filters by action and time", func(t *testing.T) {
+		page, err := testAuditService.Query(ctx, Filter{Action: ActionVideoDelete, Since: start.Add(-time.Second)})
+		if err != nil {
+			t.Fatalf("Query() error = %v", err)
+		}
+		if len(page.Records) != 2 {
+			t.Errorf("Query() returned %d video deletions, want 2", len(page.Records))
+		}
+
+		page, err = testAuditService.Query(ctx, Filter{Action: ActionVideoDelete, Until: start.Add(-time.Second)})
+		if err != nil {
+			t.Fatalf("Query() error = %v", err)
+		}
+		if len(page.Records) != 0 {
+			t.Errorf("Query() returned %d records before the test started, want 0", len(page.Records))
+		}
+	})
+
+	t.Run("Failed logins have no actor", func(t *testing.T) {
+		page, err := testAuditService.Query(ctx, Filter{Action: ActionLoginFailed})
+		if err != nil {
+			t.Fatalf("Query() error = %v", err)
+		}
+		if len(page.Records) != 1 || !page.Records[0].Actor.IsZero() || page.Records[0].TargetID != "nobody@example.com" {
+			t.Errorf("Query() failed logins = %+v", page.Records)
+		}
+	})
+
+	t.Run("Pages with a cursor", func(t *testing.T) {
+		first, err := testAuditService.Query(ctx, Filter{Actor: alice, Limit: 2})
+		if err != nil {
+			t.Fatalf("Query() error = %v", err)
+		}
+		if len(first.Records) != 2 || first.NextCursor == "" {
+			t.Fatalf("first page has %d records and cursor %q", len(first.Records), first.NextCursor)
+		}
+		second, err := testAuditService.Query(ctx, Filter{Actor: alice, Limit: 2, Before: first.NextCursor})
+		if err != nil {
+			t.Fatalf("Query() error = %v", err)
+		}
+		if len(second.Records) != 1 || second.NextCursor != "" || second.Records[0].Action != ActionLogin {
+			t.Errorf("second page = %+v, cursor %q", second.Records, second.NextCursor)
+		}
+
+		if _, err := testAuditService.Query(ctx, Filter{Before: "not-a-cursor"}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Query() with a bad cursor error = %v, want ErrInvalidCursor", err)
+		}
+	})
+
+	t.Run("A nil service records nothing", func(t *testing.T) {
+		var s *AuditService
+		if err := s.Record(ctx, entries[0]); err != nil {
+			t.Errorf("Record() on a nil service error = %v", err)
+		}
+	})
+}
@@ -0,0 +1,163 @@
+// Package cache keeps the results of read-heavy queries for a short time, so bursts of
+// identical requests share one database query.
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Cache stores encoded values by key until they expire. Values are bytes so the cache
+// can live outside the process, e.g. in Redis. Implementations must be safe for
+// concurrent use.
+type Cache interface {
+	// Get returns the value stored under key, if it hasn't expired
+	Get(key string) ([]byte, bool)
+	// Set stores value under key for ttl
+	Set(key string, value []byte, ttl time.Duration)
+	// Delete removes the value stored under key
+	Delete(key string)
+}
+
+// sweepThreshold is how many entries a Memory cache holds before Set clears out the
+// expired ones
+const sweepThreshold = 1024
+
+type entry struct {
+	value   []byte
+	expires time.Time
+}
+
+// Memory is a Cache kept in process memory
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]entry
+	now     func() time.Time // Replaced in tests
+}
+
+// NewMemory creates an empty in-memory cache
+func NewMemory() *Memory {
+	return &Memory{entries: make(map[string]entry), now: time.Now}
+}
+
+func (m *Memory) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !m.now().Before(e.expires) {
+		delete(m.entries, key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (m *Memory) Set(key string, value []byte, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if len(m.entries) >= sweepThreshold {
+		for k, e := range m.entries {
+			if !now.Before(e.expires) {
+				delete(m.entries, k)
+			}
+		}
+	}
+	m.entries[key] = entry{value: value, expires: now.Add(ttl)}
+}
+
+func (m *Memory) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+}
+
+// call is a load in progress that concurrent callers wait for
+type call struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// Loader fills a Cache on misses. Concurrent misses for the same key share a single
+// load, so an expiring entry doesn't send every waiting request to the database.
+type Loader struct {
+	cache Cache
+	ttl   time.Duration
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// NewLoader creates a loader keeping results in c for ttl. A ttl of zero or less turns
+// caching off.
+func NewLoader(c Cache, ttl time.Duration) *Loader {
+	return &Loader{cache: c, ttl: ttl, calls: make(map[string]*call)}
+}
+
+// Fetch returns the value cached under key, or the result of load, which is cached when
+// it succeeds. Values are JSON encoded, so each caller gets its own copy and a cached
+// value sent as a response looks exactly like a fresh one; fields hidden from JSON are
+// not kept. A nil or disabled loader always calls load.
+func Fetch[T any](l *Loader, key string, load func() (T, error)) (T, error) {
+	var value T
+	if l == nil || l.ttl <= 0 {
+		return load()
+	}
+
+	data, err := l.load(key, func() ([]byte, error) {
+		loaded, err := load()
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(loaded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode cached value: %w", err)
+		}
+		return data, nil
+	})
+	if err != nil {
+		return value, err
+	}
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return value, fmt.Errorf("failed to decode cached value: %w", err)
+	}
+	return value, nil
+}
+
+// load returns the cached bytes for key, running load once for all concurrent misses.
+// Errors are passed to every waiting caller but not cached.
+func (l *Loader) load(key string, load func() ([]byte, error)) ([]byte, error) {
+	if data, ok := l.cache.Get(key); ok {
+		return data, nil
+	}
+
+	l.mu.Lock()
+	if c, ok := l.calls[key]; ok {
+		l.mu.Unlock()
+		<-c.done
+		return c.value, c.err
+	}
+	c := &call{done: make(chan struct{})}
+	l.calls[key] = c
+	l.mu.Unlock()
+
+	c.value, c.err = load()
+	if c.err == nil {
+		l.cache.Set(key, c.value, l.ttl)
+	}
+
+	l.mu.Lock()
+	delete(l.calls, key)
+	l.mu.Unlock()
+	close(c.done)
+
+	return c.value, c.err
+}
@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemory_Expiry(t *testing.T) {
+	now := time.Now()
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+
+	m.Set("key", []byte("value"), time.Minute)
+	if got, ok := m.Get("key"); !ok || string(got) != "value" {
+		t.Fatalf("Get() = %q, %v, want value", got, ok)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := m.Get("key"); ok {
+		t.Error("Get() returned an expired value")
+	}
+
+	m.Set("key", []byte("value"), time.Minute)
+	m.Delete("key")
+	if _, ok := m.Get("key"); ok {
+		t.Error("Get() returned a deleted value")
+	}
+}
+
+func TestMemory_SweepsExpired(t *testing.T) {
+	now := time.Now()
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+
+	for i := 0; i < sweepThreshold; i++ {
+		m.Set(string(rune(i)), nil, time.Second)
+	}
+	now = now.Add(time.Second)
+	m.Set("fresh", nil, time.Second)
+
+	if len(m.entries) != 1 {
+		t.Errorf("Memory holds %d entries after a sweep, want 1", len(m.entries))
+	}
+}
+
+type item struct {
+	Name string
+	Tags []string
+}
+
+func TestFetch(t *testing.T) {
+	t.Run("CachesResults", func(t *testing.T) {
+		l := NewLoader(NewMemory(), time.Minute)
+		loads := 0
+		load := func() ([]*item, error) {
+			loads++
+			return []*item{{Name: "a", Tags: []string{}}}, nil
+		}
+
+		for i := 0; i < 3; i++ {
+			got, err := Fetch(l, "items", load)
+			if err != nil {
+				t.Fatalf("Fetch() unexpected error = %v", err)
+			}
+			if len(got) != 1 || got[0].Name != "a" || got[0].Tags == nil {
+				t.Errorf("Fetch() = %+v, want the loaded items", got)
+			}
+		}
+		if loads != 1 {
+			t.Errorf("load ran %d times, want 1", loads)
+		}
+	})
+
+	t.Run("CopiesValues", func(t *testing.T) {
+		l := NewLoader(NewMemory(), time.Minute)
+		load := func() (*item, error) { return &item{Name: "a"}, nil }
+
+		first, _ := Fetch(l, "item", load)
+		first.Name = "changed"
+		second, _ := Fetch(l, "item", load)
+		if second.Name != "a" {
+			t.Errorf("Fetch() = %q after the caller changed its copy, want a", second.Name)
+		}
+	})
+
+	t.Run("KeepsEmptySlices", func(t *testing.T) {
+		l := NewLoader(NewMemory(), time.Minute)
+		for _, want := range [][]*item{nil, {}} {
+			key := "nil"
+			if want != nil {
+				key = "empty"
+			}
+			load := func() ([]*item, error) { return want, nil }
+			Fetch(l, key, load)
+			got, err := Fetch(l, key, load)
+			if err != nil || (got == nil) != (want == nil) {
+				t.Errorf("Fetch() = %#v, %v, want %#v", got, err, want)
+			}
+		}
+	})
+
+	t.Run("DoesNotCacheErrors", func(t *testing.T) {
+		l := NewLoader(NewMemory(), time.Minute)
+		errLoad := errors.New("database down")
+		loads := 0
+		load := func() (int, error) {
+			loads++
+			if loads == 1 {
+				return 0, errLoad
+			}
+			return 42, nil
+		}
+
+		if _, err := Fetch(l, "answer", load); !errors.Is(err, errLoad) {
+			t.Errorf("Fetch() error = %v, want the load error", err)
+		}
+		if got, err := Fetch(l, "answer", load); err != nil || got != 42 {
+			t.Errorf("Fetch() after an error = %d, %v, want 42", got, err)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		for name, l := range map[string]*Loader{"nil": nil, "zero ttl": NewLoader(NewMemory(), 0)} {
+			loads := 0
+			load := func() (int, error) { loads++; return loads, nil }
+			Fetch(l, "key", load)
+			if got, _ := Fetch(l, "key", load); got != 2 {
+				t.Errorf("%s loader: Fetch() = %d, want a fresh load", name, got)
+			}
+		}
+	})
+
+	t.Run("SingleFlight", func(t *testing.T) {
+		l := NewLoader(NewMemory(), time.Minute)
+		var loads atomic.Int32
+		release := make(chan struct{})
+		load := func() (int, error) {
+			loads.Add(1)
+			<-release
+			return 7, nil
+		}
+
+		const callers = 20
+		var wg sync.WaitGroup
+		results := make(chan int, callers)
+		for i := 0; i < callers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				got, err := Fetch(l, "slow", load)
+				if err != nil {
+					t.Errorf("Fetch() unexpected error = %v", err)
+				}
+				results <- got
+			}()
+		}
+
+		// Let the callers pile up behind the first load
+		for loads.Load() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		close(results)
+
+		if n := loads.Load(); n != 1 {
+			t.Errorf("load ran %d times for concurrent callers, want 1", n)
+		}
+		for got := range results {
+			if got != 7 {
+				t.Errorf("Fetch() = %d, want 7", got)
+			}
+		}
+	})
+}
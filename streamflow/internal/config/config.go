@@ -1,8 +1,12 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -17,6 +21,12 @@ type Config struct {
 	JWT JWTConfig `json:"jwt"`
 	Video VideoConfig `json:"video"`
 	Security SecurityConfig `json:"security"`
+	Livestream LivestreamConfig `json:"livestream"`
+	Webhook WebhookConfig `json:"webhook"`
+	Limits LimitsConfig `json:"limits"`
+	TwoFactor TwoFactorConfig `json:"two_factor"`
+	Cache CacheConfig `json:"cache"`
+	Redis RedisConfig `json:"redis"`
 }
 
 type ServerConfig struct {
@@ -25,6 +35,8 @@ type ServerConfig struct {
     ReadTimeout  time.Duration `json:"read_timeout"`
     WriteTimeout time.Duration `json:"write_timeout"`
     IdleTimeout  time.Duration `json:"idle_timeout"`
+    LogLevel     string        `json:"log_level"` // debug, info, warn or error
+    ShutdownTimeout time.Duration `json:"shutdown_timeout"` // How long to wait for subsystems to stop
 }
 
 type DatabaseConfig struct {
@@ -34,12 +46,21 @@ type DatabaseConfig struct {
     Username string `json:"username"`
     Password string `json:"password"`
     URI      string `json:"uri"` // Full connection URI
+    ConnectTimeout         time.Duration `json:"connect_timeout"`          // Per connection attempt
+    ServerSelectionTimeout time.Duration `json:"server_selection_timeout"` // How long to look for a usable server
+    ConnectRetries         int           `json:"connect_retries"`          // Extra attempts after a transient failure
+    RetryBackoff           time.Duration `json:"retry_backoff"`            // Wait before the first retry, doubled each time
+    MaxRetryBackoff        time.Duration `json:"max_retry_backoff"`        // Cap on the wait between retries
+    MaxPoolSize            int           `json:"max_pool_size"`            // Connections open at most; 0 keeps the connection string's, or the driver default of 100
+    MinPoolSize            int           `json:"min_pool_size"`            // Connections kept open even when idle
+    MaxConnIdleTime        time.Duration `json:"max_conn_idle_time"`       // Idle connections are closed after this; 0 keeps them
 }
 
 type JWTConfig struct {
 	SecretKey     string        `json:"secret_key"`
     Expiration    time.Duration `json:"expiration"`
     RefreshExpiration time.Duration `json:"refresh_expiration"`
+    SessionCookie bool `json:"session_cookie"` // Also give browser clients their token in an HttpOnly cookie
 }
 
 type VideoConfig struct {
@@ -47,12 +68,110 @@ type VideoConfig struct {
     ProcessedPath string `json:"processed_path"`
     MaxFileSize   int64  `json:"max_file_size"` // in bytes
     AllowedTypes  []string `json:"allowed_types"`
+    ThumbnailAt   string `json:"thumbnail_at"` // "10%" of the duration or a fixed "5s"
+    DeletedRetention time.Duration `json:"deleted_retention"` // How long soft-deleted videos are kept before purging
+    IdempotencyWindow time.Duration `json:"idempotency_window"` // How long upload Idempotency-Key headers are remembered
+    Storage StorageConfig `json:"storage"` // Where original video files are kept
+    Processing ProcessingConfig `json:"processing"` // Background transcoding of uploads
+    TranscodeProfiles []TranscodeProfile `json:"transcode_profiles"` // HLS ladder; empty uses the built-in 480p/720p/1080p one
+    MaxConcurrentUploads int `json:"max_concurrent_uploads"` // Uploads received at once by this instance, apart from processing workers; 0 for no limit
+    UploadQueueTimeout time.Duration `json:"upload_queue_timeout"` // How long an upload over the limit waits for a slot; 0 rejects it at once
+    RequireFFmpeg bool `json:"require_ffmpeg"` // Report the instance not ready when ffmpeg is missing
+}
+
+// TranscodeProfile is one rung of the HLS ladder uploads are transcoded to
+type TranscodeProfile struct {
+	Name    string `json:"name"`    // Names the variant playlist and segment files, e.g. "360p"
+	Height  int    `json:"height"`  // Output height in pixels; must be even
+	Bitrate int    `json:"bitrate"` // Video bitrate in kbps
+}
+
+type ProcessingConfig struct {
+	Workers         int           `json:"workers"`           // Jobs processed at once by this instance; 0 processes none
+	MaxAttempts     int           `json:"max_attempts"`      // Attempts before a job and its video are marked failed
+	RetryBackoff    time.Duration `json:"retry_backoff"`     // Delay before the first retry, doubled for each further one
+	MaxRetryBackoff time.Duration `json:"max_retry_backoff"` // Upper bound of the retry delay
+	PollInterval    time.Duration `json:"poll_interval"`     // How often idle workers look for jobs queued by other instances
+	JobLease        time.Duration `json:"job_lease"`         // How long a job stays claimed after its worker stops renewing it
+}
+
+type StorageConfig struct {
+	Backend         string        `json:"backend"`    // "gridfs" (default), "local" or "s3"
+	LocalPath       string        `json:"local_path"` // Directory used by the local backend
+	S3Bucket        string        `json:"s3_bucket"`
+	S3Region        string        `json:"s3_region"`
+	S3Endpoint      string        `json:"s3_endpoint"` // Custom endpoint for S3-compatible services; empty uses AWS
+	S3AccessKey     string        `json:"-"`           // Empty uses the default AWS credential chain
+	S3SecretKey     string        `json:"-"`
+	S3UsePathStyle  bool          `json:"s3_use_path_style"`
+	S3PresignExpiry time.Duration `json:"s3_presign_expiry"`
 }
 
 type SecurityConfig struct {
 	CORSOrigins []string `json:"cors_origins"`
     RateLimit   int      `json:"rate_limit"`
     RateWindow  time.Duration `json:"rate_window"`
+    // Stricter per-IP limit on login and registration to slow down brute forcing
+    AuthRateLimit  int           `json:"auth_rate_limit"`
+    AuthRateWindow time.Duration `json:"auth_rate_window"`
+    // Locks logins to an email for LoginLockout after LoginMaxAttempts failures within
+    // LoginAttemptWindow. Read at startup only; zero attempts disables lockouts.
+    LoginMaxAttempts   int           `json:"login_max_attempts"`
+    LoginAttemptWindow time.Duration `json:"login_attempt_window"`
+    LoginLockout       time.Duration `json:"login_lockout"`
+    // bcrypt cost of new password hashes. Older hashes with a lower cost are upgraded
+    // when their user logs in.
+    BcryptCost int `json:"bcrypt_cost"`
+}
+
+type LivestreamConfig struct {
+	AllowedVideoCodecs []string `json:"allowed_video_codecs"` // e.g. h264
+	AllowedAudioCodecs []string `json:"allowed_audio_codecs"` // e.g. aac
+	CodecPolicy        string   `json:"codec_policy"`         // "strict" rejects other codecs, "lenient" accepts other audio codecs for transcoding
+	RTMPAddr           string   `json:"rtmp_addr"`            // RTMP ingest listen address; empty disables ingest
+	Categories         []string `json:"categories"`           // Categories streamers can pick from
+	PreviewInterval    time.Duration `json:"preview_interval"`   // How often live preview frames are captured; 0 disables them
+	RecordStreams      bool     `json:"record_streams"`       // Record published streams with ffmpeg and publish each recording as a video when its stream stops
+	ICEServers         []string `json:"ice_servers"`          // STUN and TURN URLs offered to WebRTC viewers
+	MaxChatMessageLength int    `json:"max_chat_message_length"` // Longest chat message, in characters
+	TruncateChatMessages bool   `json:"truncate_chat_messages"`  // Cut longer messages short instead of rejecting them
+	ICEUsername        string   `json:"-"`                    // Credentials of the TURN servers
+	ICECredential      string   `json:"-"`
+}
+
+type WebhookConfig struct {
+	URLs       []string `json:"urls"`
+	Secret     string   `json:"secret"`
+	MaxRetries int      `json:"max_retries"` // Retries after the first delivery attempt
+	DeadLetterPath string `json:"dead_letter_path"` // Deliveries that exhaust their retries are appended here
+}
+
+// TwoFactorConfig configures TOTP two-factor authentication
+type TwoFactorConfig struct {
+	Issuer        string `json:"issuer"`         // Shown in authenticator apps
+	EncryptionKey string `json:"-"`              // Encrypts TOTP secrets at rest
+	Skew          int    `json:"skew"`           // Accepted clock drift in 30s steps either side
+}
+
+// CacheConfig configures the cache of popular and trending listings
+type CacheConfig struct {
+	TTL time.Duration `json:"ttl"` // How long listings are reused; 0 disables the cache
+}
+
+// RedisConfig configures the Redis server shared by instances for live viewer counts
+// and chat. Without an address both stay local to each instance.
+type RedisConfig struct {
+	Addr     string `json:"addr"` // host:port; empty disables Redis
+	Password string `json:"-"`
+	DB       int    `json:"db"`
+	TLS      bool   `json:"tls"` // Connect over TLS, verifying the server's certificate
+}
+
+// LimitsConfig holds per-user limits. A zero value means unlimited.
+type LimitsConfig struct {
+	StorageQuotaBytes    int64 `json:"storage_quota_bytes"`
+	MaxVideos            int   `json:"max_videos"`
+	MaxConcurrentStreams int   `json:"max_concurrent_streams"`
 }
 
 //loads config from environment variables and .env file
@@ -71,6 +190,10 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("failed to load jwt config: %w", err)
 	}
 
+	if err := config.loadTwoFactorConfig(); err != nil {
+		return nil, fmt.Errorf("failed to load two-factor config: %w", err)
+	}
+
 	if err := config.loadVideoConfig(); err != nil {
 		return nil, fmt.Errorf("failed to load video config: %w", err)
 	}
@@ -79,6 +202,26 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("failed to load security config: %w", err)
 	}
 
+	if err := config.loadLivestreamConfig(); err != nil {
+		return nil, fmt.Errorf("failed to load livestream config: %w", err)
+	}
+
+	if err := config.loadWebhookConfig(); err != nil {
+		return nil, fmt.Errorf("failed to load webhook config: %w", err)
+	}
+
+	if err := config.loadLimitsConfig(); err != nil {
+		return nil, fmt.Errorf("failed to load limits config: %w", err)
+	}
+
+	if err := config.loadCacheConfig(); err != nil {
+		return nil, fmt.Errorf("failed to load cache config: %w", err)
+	}
+
+	if err := config.loadRedisConfig(); err != nil {
+		return nil, fmt.Errorf("failed to load redis config: %w", err)
+	}
+
 	return config, nil
 
 }
@@ -96,6 +239,8 @@ func (c *Config) loadServerConfig() error {
 		ReadTimeout:  getDurationEnv("READ_TIMEOUT", 10*time.Second),
 		WriteTimeout: getDurationEnv("WRITE_TIMEOUT", 10*time.Second),
 		IdleTimeout:  getDurationEnv("IDLE_TIMEOUT", 10*time.Second),
+		LogLevel:     getEnv("LOG_LEVEL", "info"),
+		ShutdownTimeout: getDurationEnv("SHUTDOWN_TIMEOUT", 30*time.Second),
 	}
 	return nil
 }
@@ -107,9 +252,20 @@ func (c *Config) loadDatabaseConfig() error {
         Name:     getEnv("DB_NAME", "streamflow"),
         Username: getEnv("DB_USERNAME", ""),
         Password: getEnv("DB_PASSWORD", ""),
+		ConnectTimeout:         getDurationEnv("DB_CONNECT_TIMEOUT", 10*time.Second),
+		ServerSelectionTimeout: getDurationEnv("DB_SERVER_SELECTION_TIMEOUT", 10*time.Second),
+		ConnectRetries:         getIntEnv("DB_CONNECT_RETRIES", 5),
+		RetryBackoff:           getDurationEnv("DB_RETRY_BACKOFF", 500*time.Millisecond),
+		MaxRetryBackoff:        getDurationEnv("DB_MAX_RETRY_BACKOFF", 10*time.Second),
+		MaxPoolSize:            getIntEnv("DB_MAX_POOL_SIZE", 0), // 0 keeps the connection string's maxPoolSize
+		MinPoolSize:            getIntEnv("DB_MIN_POOL_SIZE", 0),
+		MaxConnIdleTime:        getDurationEnv("DB_MAX_CONN_IDLE_TIME", 0),
 	}
 
-	if c.Database.Username != "" && c.Database.Password != ""{
+	if uri := getEnv("DB_URI", ""); uri != "" {
+		// The connection string the database connects with takes precedence
+		c.Database.URI = uri
+	} else if c.Database.Username != "" && c.Database.Password != ""{
 		c.Database.URI = fmt.Sprintf("mongodb://%s:%s@%s:%s", c.Database.Username, c.Database.Password, c.Database.Host, c.Database.Port)
 	} else {
 		//no auth probs remove this 
@@ -129,27 +285,155 @@ func (c *Config) loadJWTConfig() error {
         SecretKey:        secretKey,
         Expiration:       getDurationEnv("JWT_EXPIRATION", 24*time.Hour),
         RefreshExpiration: getDurationEnv("JWT_REFRESH_EXPIRATION", 7*24*time.Hour),
+        SessionCookie:    getEnv("JWT_SESSION_COOKIE", "false") == "true",
     }
 
 	return nil
 }
 
+func (c *Config) loadTwoFactorConfig() error {
+	c.TwoFactor = TwoFactorConfig{
+		Issuer:        getEnv("TOTP_ISSUER", "StreamFlow"),
+		EncryptionKey: getEnv("TOTP_ENCRYPTION_KEY", c.JWT.SecretKey),
+		Skew:          getIntEnv("TOTP_SKEW", 1),
+	}
+
+	if c.TwoFactor.Skew < 0 {
+		return fmt.Errorf("TOTP_SKEW must not be negative")
+	}
+
+	return nil
+}
+
 func (c *Config) loadVideoConfig() error {
 	c.Video = VideoConfig {
 		UploadPath:    getEnv("VIDEO_UPLOAD_PATH", "storage/uploads"),
         ProcessedPath: getEnv("VIDEO_PROCESSED_PATH", "storage/processed"),
         MaxFileSize:   getInt64Env("VIDEO_MAX_FILE_SIZE", 100*1024*1024), // 100MB default
         AllowedTypes:  []string{"video/mp4", "video/avi", "video/mov", "video/mkv"},
+        ThumbnailAt:   getEnv("VIDEO_THUMBNAIL_AT", "10%"),
+        DeletedRetention: getDurationEnv("VIDEO_DELETED_RETENTION", 30*24*time.Hour),
+        IdempotencyWindow: getDurationEnv("VIDEO_IDEMPOTENCY_WINDOW", 24*time.Hour),
+        Storage: StorageConfig{
+            Backend:         strings.ToLower(getEnv("STORAGE_BACKEND", "gridfs")),
+            LocalPath:       getEnv("STORAGE_LOCAL_PATH", "storage/videos"),
+            S3Bucket:        getEnv("S3_BUCKET", ""),
+            S3Region:        getEnv("S3_REGION", "us-east-1"),
+            S3Endpoint:      getEnv("S3_ENDPOINT", ""),
+            S3AccessKey:     getEnv("S3_ACCESS_KEY_ID", ""),
+            S3SecretKey:     getEnv("S3_SECRET_ACCESS_KEY", ""),
+            S3UsePathStyle:  getEnv("S3_USE_PATH_STYLE", "false") == "true",
+            S3PresignExpiry: getDurationEnv("S3_PRESIGN_EXPIRY", 15*time.Minute),
+        },
+        Processing: ProcessingConfig{
+            Workers:         getIntEnv("VIDEO_PROCESSING_WORKERS", 2),
+            MaxAttempts:     getIntEnv("VIDEO_PROCESSING_MAX_ATTEMPTS", 3),
+            RetryBackoff:    getDurationEnv("VIDEO_PROCESSING_RETRY_BACKOFF", 30*time.Second),
+            MaxRetryBackoff: getDurationEnv("VIDEO_PROCESSING_MAX_RETRY_BACKOFF", 10*time.Minute),
+            PollInterval:    getDurationEnv("VIDEO_PROCESSING_POLL_INTERVAL", 5*time.Second),
+            JobLease:        getDurationEnv("VIDEO_PROCESSING_JOB_LEASE", 2*time.Minute),
+        },
+        MaxConcurrentUploads: getIntEnv("VIDEO_MAX_CONCURRENT_UPLOADS", 4),
+        UploadQueueTimeout:   getDurationEnv("VIDEO_UPLOAD_QUEUE_TIMEOUT", 0),
+        RequireFFmpeg:        getEnv("FFMPEG_REQUIRED", "false") == "true",
+	}
+
+	processing := c.Video.Processing
+	if processing.Workers < 0 || processing.MaxAttempts < 1 {
+		return fmt.Errorf("VIDEO_PROCESSING_WORKERS must not be negative and VIDEO_PROCESSING_MAX_ATTEMPTS must be at least 1")
+	}
+	if processing.RetryBackoff <= 0 || processing.MaxRetryBackoff < processing.RetryBackoff ||
+		processing.PollInterval <= 0 || processing.JobLease <= 0 {
+		return fmt.Errorf("invalid video processing timings")
+	}
+	if c.Video.IdempotencyWindow <= 0 {
+		return fmt.Errorf("VIDEO_IDEMPOTENCY_WINDOW must be positive")
+	}
+	if c.Video.MaxConcurrentUploads < 0 || c.Video.UploadQueueTimeout < 0 {
+		return fmt.Errorf("VIDEO_MAX_CONCURRENT_UPLOADS and VIDEO_UPLOAD_QUEUE_TIMEOUT must not be negative")
+	}
+
+	profiles, err := parseTranscodeProfiles(getEnv("VIDEO_TRANSCODE_PROFILES", ""))
+	if err != nil {
+		return fmt.Errorf("invalid VIDEO_TRANSCODE_PROFILES: %w", err)
+	}
+	c.Video.TranscodeProfiles = profiles
+
+	switch c.Video.Storage.Backend {
+	case "gridfs", "local":
+	case "s3":
+		if c.Video.Storage.S3Bucket == "" {
+			return fmt.Errorf("S3_BUCKET is required when STORAGE_BACKEND is s3")
+		}
+	default:
+		return fmt.Errorf("invalid storage backend: %s", c.Video.Storage.Backend)
 	}
 	return nil
 }
 
+// parseTranscodeProfiles parses a comma-separated list of name:height:bitrate rungs,
+// e.g. "360p:360:800,720p:720:2800", with the bitrate in kbps. An empty list is valid.
+func parseTranscodeProfiles(value string) ([]TranscodeProfile, error) {
+	var profiles []TranscodeProfile
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("profile %q: want name:height:bitrate", item)
+		}
+		name := strings.TrimSpace(parts[0])
+		if !validProfileName(name) {
+			return nil, fmt.Errorf("profile %q: name must be letters, digits, '-' or '_'", item)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("profile %q: duplicate name", item)
+		}
+		seen[name] = true
+
+		height, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || height <= 0 || height%2 != 0 {
+			return nil, fmt.Errorf("profile %q: height must be a positive even number", item)
+		}
+		bitrate, err := strconv.Atoi(strings.TrimSpace(parts[2]))
+		if err != nil || bitrate <= 0 {
+			return nil, fmt.Errorf("profile %q: bitrate must be a positive number of kbps", item)
+		}
+		profiles = append(profiles, TranscodeProfile{Name: name, Height: height, Bitrate: bitrate})
+	}
+	return profiles, nil
+}
+
+// validProfileName accepts names safe to use in file names
+func validProfileName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
 func (c *Config) loadSecurityConfig() error {
 	corsOriginsStr := getEnv("CORS_ORIGINS", "*")
 	var corsOrigins []string
 	if corsOriginsStr != "*" {
 		for _, origin := range strings.Split(corsOriginsStr, ",") {
-			corsOrigins = append(corsOrigins, strings.TrimSpace(origin))
+			origin = strings.TrimSpace(origin)
+			if origin == "" {
+				continue
+			}
+			if !validCORSOrigin(origin) {
+				return fmt.Errorf("invalid CORS origin %q: want \"*\" or scheme://host[:port]", origin)
+			}
+			corsOrigins = append(corsOrigins, origin)
 		}
 	} else {
 		corsOrigins = []string{"*"}
@@ -159,6 +443,133 @@ func (c *Config) loadSecurityConfig() error {
 		CORSOrigins: corsOrigins,
 		RateLimit:   getIntEnv("RATE_LIMIT", 100),
 		RateWindow:  getDurationEnv("RATE_WINDOW", 1*time.Minute),
+		AuthRateLimit:  getIntEnv("AUTH_RATE_LIMIT", 10),
+		AuthRateWindow: getDurationEnv("AUTH_RATE_WINDOW", 1*time.Minute),
+		LoginMaxAttempts:   getIntEnv("LOGIN_MAX_ATTEMPTS", 5),
+		LoginAttemptWindow: getDurationEnv("LOGIN_ATTEMPT_WINDOW", 15*time.Minute),
+		LoginLockout:       getDurationEnv("LOGIN_LOCKOUT", 15*time.Minute),
+		BcryptCost:         getIntEnv("BCRYPT_COST", 10),
+	}
+
+	security := c.Security
+	if security.LoginMaxAttempts < 0 {
+		return fmt.Errorf("LOGIN_MAX_ATTEMPTS must not be negative")
+	}
+	if security.LoginMaxAttempts > 0 && (security.LoginAttemptWindow <= 0 || security.LoginLockout <= 0) {
+		return fmt.Errorf("LOGIN_ATTEMPT_WINDOW and LOGIN_LOCKOUT must be positive")
+	}
+	// The range bcrypt accepts
+	if security.BcryptCost < 4 || security.BcryptCost > 31 {
+		return fmt.Errorf("BCRYPT_COST must be between 4 and 31")
+	}
+
+	return nil
+}
+
+func (c *Config) loadLivestreamConfig() error {
+	policy := strings.ToLower(getEnv("INGEST_CODEC_POLICY", "strict"))
+	if policy != "strict" && policy != "lenient" {
+		return fmt.Errorf("invalid ingest codec policy: %s", policy)
+	}
+
+	c.Livestream = LivestreamConfig{
+		AllowedVideoCodecs: getListEnv("INGEST_VIDEO_CODECS", []string{"h264"}),
+		AllowedAudioCodecs: getListEnv("INGEST_AUDIO_CODECS", []string{"aac"}),
+		CodecPolicy:        policy,
+		RTMPAddr:           getEnv("RTMP_ADDR", ":1935"),
+		Categories: getListEnv("STREAM_CATEGORIES", []string{
+			"gaming", "music", "art", "talk", "education", "sports", "technology",
+		}),
+		PreviewInterval: getDurationEnv("STREAM_PREVIEW_INTERVAL", 10*time.Second),
+		RecordStreams:   getEnv("STREAM_RECORDING", "false") == "true",
+		ICEServers:      getListEnv("WEBRTC_ICE_SERVERS", []string{"stun:stun.l.google.com:19302"}),
+		ICEUsername:     getEnv("WEBRTC_ICE_USERNAME", ""),
+		ICECredential:   getEnv("WEBRTC_ICE_CREDENTIAL", ""),
+		MaxChatMessageLength: getIntEnv("CHAT_MAX_MESSAGE_LENGTH", 500),
+		TruncateChatMessages: getEnv("CHAT_TRUNCATE_MESSAGES", "false") == "true",
+	}
+	if c.Livestream.PreviewInterval < 0 {
+		return fmt.Errorf("invalid stream preview interval: %s", c.Livestream.PreviewInterval)
+	}
+	if c.Livestream.MaxChatMessageLength < 1 {
+		return fmt.Errorf("invalid chat message length: %d", c.Livestream.MaxChatMessageLength)
+	}
+	if err := validateICEServers(c.Livestream); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateICEServers checks that every ICE server is a STUN or TURN URL, and that
+// credentials are set for TURN servers, which require them
+func validateICEServers(cfg LivestreamConfig) error {
+	for _, url := range cfg.ICEServers {
+		scheme, _, _ := strings.Cut(url, ":")
+		switch scheme {
+		case "stun", "stuns":
+		case "turn", "turns":
+			if cfg.ICEUsername == "" || cfg.ICECredential == "" {
+				return fmt.Errorf("WEBRTC_ICE_USERNAME and WEBRTC_ICE_CREDENTIAL are required for TURN server %s", url)
+			}
+		default:
+			return fmt.Errorf("invalid ICE server %q: must be a stun: or turn: URL", url)
+		}
+	}
+	return nil
+}
+
+func (c *Config) loadWebhookConfig() error {
+	c.Webhook = WebhookConfig{
+		URLs:       getListEnv("WEBHOOK_URLS", nil),
+		Secret:     getEnv("WEBHOOK_SECRET", ""),
+		MaxRetries: getIntEnv("WEBHOOK_MAX_RETRIES", 3),
+		DeadLetterPath: getEnv("WEBHOOK_DEAD_LETTER_PATH", "./storage/webhooks/dead_letter.log"),
+	}
+
+	if len(c.Webhook.URLs) > 0 && c.Webhook.Secret == "" {
+		return fmt.Errorf("WEBHOOK_SECRET is required when WEBHOOK_URLS is set")
+	}
+
+	return nil
+}
+
+func (c *Config) loadLimitsConfig() error {
+	c.Limits = LimitsConfig{
+		StorageQuotaBytes:    getInt64Env("USER_STORAGE_QUOTA", 5*1024*1024*1024), // 5GB default
+		MaxVideos:            getIntEnv("USER_MAX_VIDEOS", 100),
+		MaxConcurrentStreams: getIntEnv("USER_MAX_CONCURRENT_STREAMS", 1),
+	}
+
+	if c.Limits.StorageQuotaBytes < 0 || c.Limits.MaxVideos < 0 || c.Limits.MaxConcurrentStreams < 0 {
+		return fmt.Errorf("user limits must not be negative")
+	}
+
+	return nil
+}
+
+func (c *Config) loadCacheConfig() error {
+	c.Cache = CacheConfig{
+		TTL: getDurationEnv("CACHE_TTL", 30*time.Second),
+	}
+
+	if c.Cache.TTL < 0 {
+		return fmt.Errorf("cache TTL must not be negative")
+	}
+
+	return nil
+}
+
+func (c *Config) loadRedisConfig() error {
+	c.Redis = RedisConfig{
+		Addr:     getEnv("REDIS_ADDR", ""),
+		Password: getEnv("REDIS_PASSWORD", ""),
+		DB:       getIntEnv("REDIS_DB", 0),
+		TLS:      getEnv("REDIS_TLS", "false") == "true",
+	}
+
+	if c.Redis.DB < 0 {
+		return fmt.Errorf("REDIS_DB must not be negative")
 	}
 
 	return nil
@@ -189,6 +600,19 @@ func getInt64Env(key string, defaultValue int64) int64 {
 	return defaultValue
 }
 
+func getListEnv(key string, defaultValue []string) []string {
+	if value := os.Getenv(key); value != "" {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	return defaultValue
+}
+
 func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	if value := os.Getenv(key); value != "" {
 		if duration, err := time.ParseDuration(value); err == nil {
@@ -198,19 +622,102 @@ func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	return defaultValue
 }
 
+// validCORSOrigin accepts an origin of the form scheme://host[:port]
+func validCORSOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" || strings.Contains(u.Host, "*") {
+		return false
+	}
+	return (u.Path == "" || u.Path == "/") && u.RawQuery == "" && u.Fragment == "" && u.User == nil
+}
+
+// minJWTSecretLength is the shortest JWT secret accepted, the key size of HS256
+const minJWTSecretLength = 32
+
+// Validate checks the settings the server can't run without. It reports every problem
+// found, joined into one error, rather than only the first.
 func (c *Config) Validate() error {
+	var errs []error
 	if c.Server.Port <= 0 || c.Server.Port > 65535 {
-		return fmt.Errorf("invalid port: %d", c.Server.Port)
+		errs = append(errs, fmt.Errorf("invalid port: %d", c.Server.Port))
 	}
 	if c.Database.Host == "" {
-		return fmt.Errorf("database host is required")
+		errs = append(errs, fmt.Errorf("database host is required"))
+	}
+	if err := validateDatabaseURI(c.Database.URI); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Database.MaxPoolSize < 0 || c.Database.MinPoolSize < 0 || c.Database.MaxConnIdleTime < 0 {
+		errs = append(errs, fmt.Errorf("database pool settings must not be negative"))
+	} else if c.Database.MaxPoolSize > 0 && c.Database.MinPoolSize > c.Database.MaxPoolSize {
+		errs = append(errs, fmt.Errorf("database min pool size %d exceeds max pool size %d", c.Database.MinPoolSize, c.Database.MaxPoolSize))
 	}
 	if c.JWT.SecretKey == "" {
-		return fmt.Errorf("jwt secret key is required")
+		errs = append(errs, fmt.Errorf("jwt secret key is required"))
+	} else if len(c.JWT.SecretKey) < minJWTSecretLength {
+		errs = append(errs, fmt.Errorf("jwt secret key must be at least %d bytes, got %d", minJWTSecretLength, len(c.JWT.SecretKey)))
+	}
+	if c.Video.MaxFileSize <= 0 {
+		errs = append(errs, fmt.Errorf("video max file size must be positive: %d", c.Video.MaxFileSize))
 	}
 	if c.Video.UploadPath == "" {
-		return fmt.Errorf("video upload path is required")
+		errs = append(errs, fmt.Errorf("video upload path is required"))
+	} else if err := checkWritable(c.Video.UploadPath); err != nil {
+		errs = append(errs, fmt.Errorf("video upload path is not writable: %w", err))
+	}
+	if len(c.Video.AllowedTypes) == 0 {
+		errs = append(errs, fmt.Errorf("at least one allowed video type is required"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateDatabaseURI checks that uri is a MongoDB connection string
+func validateDatabaseURI(uri string) error {
+	if uri == "" {
+		return fmt.Errorf("database URI is required")
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		// The error would repeat the URI and with it any password
+		return fmt.Errorf("database URI is malformed")
+	}
+	if u.Scheme != "mongodb" && u.Scheme != "mongodb+srv" {
+		return fmt.Errorf("database URI scheme must be mongodb or mongodb+srv, got %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("database URI has no host")
 	}
-	
 	return nil
+}
+
+// checkWritable reports whether files can be created in the directory at path. The
+// directory is created on first use, so if it doesn't exist yet the closest existing
+// parent is checked instead.
+func checkWritable(path string) error {
+	dir := filepath.Clean(path)
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		dir = parent
+	}
+
+	f, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
 }
\ No newline at end of file
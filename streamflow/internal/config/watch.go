@@ -0,0 +1,117 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/joho/godotenv"
+)
+
+// Watch reloads the configuration from path each time the process receives SIGHUP, until
+// ctx is done, and passes every valid result to onChange. path is a .env file, or a JSON
+// file whose fields override the environment when it ends in .json.
+//
+// Secrets are only read at startup: the JWT key, database credentials and URI, and the
+// encryption and signing keys. A changed secret is logged and the current value kept.
+// An invalid file is logged and ignored. Watch returns once it is listening for SIGHUP.
+func Watch(ctx context.Context, path string, current *Config, onChange func(*Config)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+			}
+
+			cfg, err := Reload(path, current)
+			if err != nil {
+				log.Printf("Config reload from %s failed, keeping the current config: %v", path, err)
+				continue
+			}
+			log.Printf("Config reloaded from %s", path)
+			current = cfg
+			onChange(cfg)
+		}
+	}()
+}
+
+// Reload reads the configuration from path as Watch does, keeping current's secrets
+func Reload(path string, current *Config) (*Config, error) {
+	var cfg *Config
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		cfg, err = loadJSONConfig(path)
+	} else {
+		// Values in the file replace those in the environment, as they were set from it
+		if err := godotenv.Overload(path); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		cfg, err = LoadConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range cfg.keepSecrets(current) {
+		log.Printf("Config reload: %s changed but is only read at startup; restart to apply it", name)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadJSONConfig loads the configuration from the environment and applies the JSON
+// file at path over it
+func loadJSONConfig(path string) (*Config, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// keepSecrets restores current's secrets in c and returns the names of those that differed
+func (c *Config) keepSecrets(current *Config) []string {
+	secrets := []struct {
+		name         string
+		value, prior *string
+	}{
+		{"JWT_SECRET", &c.JWT.SecretKey, &current.JWT.SecretKey},
+		{"database URI", &c.Database.URI, &current.Database.URI},
+		{"DB_USERNAME", &c.Database.Username, &current.Database.Username},
+		{"DB_PASSWORD", &c.Database.Password, &current.Database.Password},
+		{"TOTP_ENCRYPTION_KEY", &c.TwoFactor.EncryptionKey, &current.TwoFactor.EncryptionKey},
+		{"WEBHOOK_SECRET", &c.Webhook.Secret, &current.Webhook.Secret},
+		{"REDIS_PASSWORD", &c.Redis.Password, &current.Redis.Password},
+		{"S3_ACCESS_KEY_ID", &c.Video.Storage.S3AccessKey, &current.Video.Storage.S3AccessKey},
+		{"S3_SECRET_ACCESS_KEY", &c.Video.Storage.S3SecretKey, &current.Video.Storage.S3SecretKey},
+	}
+
+	var changed []string
+	for _, secret := range secrets {
+		if *secret.value != *secret.prior {
+			changed = append(changed, secret.name)
+			*secret.value = *secret.prior
+		}
+	}
+	return changed
+}
@@ -2,25 +2,68 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sync/atomic"
 	"time"
 
+	"streamflow/internal/config"
+
 	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// Connection defaults for settings left zero in config.DatabaseConfig
+const (
+	defaultConnectTimeout         = 10 * time.Second
+	defaultServerSelectionTimeout = 10 * time.Second
+	defaultRetryBackoff           = 500 * time.Millisecond
+	defaultMaxRetryBackoff        = 10 * time.Second
+	defaultMaxPoolSize            = 100 // The driver's own default, only used for logging
 )
 
+// ErrMissingURI is returned when the DB_URI environment variable is not set
+var ErrMissingURI = errors.New("DB_URI environment variable is not set")
+
 type Service interface {
 	Health() map[string]string
+	// Check pings the database and verifies the required collections exist
+	Check(ctx context.Context, requiredCollections []string) HealthReport
 	GetDatabase() *mongo.Database
 	Close() error
 }
 
+// Database states reported by Check
+const (
+	StatusConnected          = "connected"
+	StatusUnreachable        = "unreachable"
+	StatusMissingCollections = "missing_collections"
+)
+
+// HealthReport is the result of Check
+type HealthReport struct {
+	Status             string   `json:"status"`
+	PingLatencyMs      float64  `json:"ping_latency_ms"`
+	OpenConnections    int64    `json:"open_connections"`
+	MissingCollections []string `json:"missing_collections,omitempty"`
+	Error              string   `json:"error,omitempty"`
+}
+
+// Ready reports whether the database can serve requests
+func (r HealthReport) Ready() bool {
+	return r.Status == StatusConnected
+}
+
 type service struct {
-	db *mongo.Client
+	db        *mongo.Client
+	openConns *atomic.Int64 // Pooled connections currently open
 }
 
 func init() {
@@ -36,7 +79,146 @@ func init() {
 	}
 }
 
+// New connects with the default timeouts and no retries, exiting the process if the
+// database can't be reached
 func New() Service {
+	return NewWithConfig(config.DatabaseConfig{})
+}
+
+// NewWithConfig connects using cfg's timeouts and retries, exiting the process if the
+// database can't be reached
+func NewWithConfig(cfg config.DatabaseConfig) Service {
+	s, err := NewWithContext(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	return s
+}
+
+// NewWithContext connects to the database at DB_URI. Transient failures such as timeouts
+// and network errors are retried up to cfg.ConnectRetries times with exponential backoff.
+// ctx bounds the whole attempt, retries included.
+func NewWithContext(ctx context.Context, cfg config.DatabaseConfig) (Service, error) {
+	uri, err := resolveURI()
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := cfg.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	maxBackoff := cfg.MaxRetryBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxRetryBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		openConns := new(atomic.Int64)
+		client, err := connect(ctx, uri, cfg, openConns)
+		if err == nil {
+			log.Printf("Successfully connected to MongoDB using DB_URI from environment")
+			return &service{db: client, openConns: openConns}, nil
+		}
+		if attempt >= cfg.ConnectRetries || !isTransient(err) {
+			return nil, err
+		}
+
+		log.Printf("MongoDB connection attempt %d failed, retrying in %v: %v", attempt+1, backoff, err)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w (last error: %v)", ctx.Err(), err)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// connect creates a client and pings the primary, disconnecting again if the ping fails.
+// openConns tracks the number of open pooled connections.
+func connect(ctx context.Context, uri string, cfg config.DatabaseConfig, openConns *atomic.Int64) (*mongo.Client, error) {
+	connectTimeout := cfg.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+	selectionTimeout := cfg.ServerSelectionTimeout
+	if selectionTimeout <= 0 {
+		selectionTimeout = defaultServerSelectionTimeout
+	}
+
+	// Use the SetServerAPIOptions() method to set the version of the Stable API on the client
+	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
+	opts := options.Client().ApplyURI(uri).
+		SetServerAPIOptions(serverAPI).
+		SetConnectTimeout(connectTimeout).
+		SetServerSelectionTimeout(selectionTimeout).
+		SetPoolMonitor(&event.PoolMonitor{
+			Event: func(e *event.PoolEvent) {
+				switch e.Type {
+				case event.ConnectionCreated:
+					openConns.Add(1)
+				case event.ConnectionClosed:
+					openConns.Add(-1)
+				}
+			},
+		})
+
+	applyPoolSettings(opts, cfg)
+
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MongoDB client: %w", err)
+	}
+
+	// Send a ping to confirm a successful connection
+	pingCtx, cancel := context.WithTimeout(ctx, selectionTimeout)
+	defer cancel()
+
+	if err := client.Ping(pingCtx, readpref.Primary()); err != nil {
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("failed to ping MongoDB: %w", err)
+	}
+
+	maxIdle := "none"
+	if opts.MaxConnIdleTime != nil && *opts.MaxConnIdleTime > 0 {
+		maxIdle = opts.MaxConnIdleTime.String()
+	}
+	log.Printf("MongoDB connection pool: max size %d, min size %d, max idle time %s",
+		valueOr(opts.MaxPoolSize, defaultMaxPoolSize), valueOr(opts.MinPoolSize, 0), maxIdle)
+	return client, nil
+}
+
+// applyPoolSettings sizes the connection pool as configured. Settings left zero in cfg
+// keep those of the connection string, or else the driver's defaults.
+func applyPoolSettings(opts *options.ClientOptions, cfg config.DatabaseConfig) {
+	if cfg.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(uint64(cfg.MaxPoolSize))
+	}
+	if cfg.MinPoolSize > 0 {
+		opts.SetMinPoolSize(uint64(cfg.MinPoolSize))
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		opts.SetMaxConnIdleTime(cfg.MaxConnIdleTime)
+	}
+}
+
+// valueOr returns *p, or def if p is nil
+func valueOr[T any](p *T, def T) T {
+	if p == nil {
+		return def
+	}
+	return *p
+}
+
+// isTransient reports whether a connection error may go away on its own, e.g. while
+// the database is still starting
+func isTransient(err error) bool {
+	return mongo.IsTimeout(err) || mongo.IsNetworkError(err) ||
+		errors.As(err, &topology.ServerSelectionError{})
+}
+
+// resolveURI returns DB_URI, loading it from a .env file if it isn't set
+func resolveURI() (string, error) {
 	uri := os.Getenv("DB_URI")
 	if uri == "" {
 		// Try to find .env file in common locations
@@ -54,33 +236,10 @@ func New() Service {
 		if uri == "" {
 			log.Printf("Current working directory: %s", getCurrentDir())
 			log.Printf("Checked for .env in: %v", envPaths)
-			log.Fatal("You must set your 'DB_URI' environment variable. Make sure .env file is in the correct location.")
+			return "", fmt.Errorf("%w: make sure the .env file is in the correct location", ErrMissingURI)
 		}
 	}
-
-	// Use the SetServerAPIOptions() method to set the version of the Stable API on the client
-	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
-	opts := options.Client().ApplyURI(uri).SetServerAPIOptions(serverAPI)
-
-	// Create a new client and connect to the server
-	client, err := mongo.Connect(context.TODO(), opts)
-	if err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
-	}
-
-	// Send a ping to confirm a successful connection
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	if err := client.Ping(ctx, readpref.Primary()); err != nil {
-		log.Fatalf("Failed to ping MongoDB: %v", err)
-	}
-
-	fmt.Printf("Successfully connected to MongoDB using DB_URI from environment!\n")
-
-	return &service{
-		db: client,
-	}
+	return uri, nil
 }
 
 func getCurrentDir() string {
@@ -110,6 +269,44 @@ func (s *service) Health() map[string]string {
 	}
 }
 
+func (s *service) Check(ctx context.Context, requiredCollections []string) HealthReport {
+	report := HealthReport{OpenConnections: s.openConns.Load()}
+
+	start := time.Now()
+	err := s.db.Ping(ctx, readpref.Primary())
+	report.PingLatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		report.Status = StatusUnreachable
+		report.Error = err.Error()
+		return report
+	}
+
+	if len(requiredCollections) > 0 {
+		names, err := s.GetDatabase().ListCollectionNames(ctx, bson.M{})
+		if err != nil {
+			report.Status = StatusUnreachable
+			report.Error = fmt.Sprintf("failed to list collections: %v", err)
+			return report
+		}
+		existing := make(map[string]bool, len(names))
+		for _, name := range names {
+			existing[name] = true
+		}
+		for _, name := range requiredCollections {
+			if !existing[name] {
+				report.MissingCollections = append(report.MissingCollections, name)
+			}
+		}
+		if len(report.MissingCollections) > 0 {
+			report.Status = StatusMissingCollections
+			return report
+		}
+	}
+
+	report.Status = StatusConnected
+	return report
+}
+
 func (s *service) GetDatabase() *mongo.Database {
 	dbName := os.Getenv("DB_NAME")
 	if dbName == "" {
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -9,6 +10,8 @@ import (
 	"testing"
 	"time"
 
+	"streamflow/internal/config"
+
 	"go.mongodb.org/mongo-driver/bson"
 )
 
@@ -239,17 +242,50 @@ func TestConnectionTimeout(t *testing.T) {
 	// Test with invalid connection string (should cause timeout)
 	os.Setenv("DB_URI", "mongodb://invalid-host:27017/test")
 	os.Setenv("DB_NAME", "test_timeout")
-	
-	// This should fail during New() call
-	defer func() {
-		if r := recover(); r == nil {
-			t.Error("Expected New() to fail with invalid connection string")
-		} else {
-			t.Logf("Correctly handled invalid connection: %v", r)
+
+	cfg := config.DatabaseConfig{
+		ConnectTimeout:         500 * time.Millisecond,
+		ServerSelectionTimeout: 500 * time.Millisecond,
+		ConnectRetries:         2,
+		RetryBackoff:           50 * time.Millisecond,
+	}
+
+	start := time.Now()
+	srv, err := NewWithContext(context.Background(), cfg)
+	if err == nil {
+		srv.Close()
+		t.Fatal("Expected NewWithContext() to fail with invalid connection string")
+	}
+	t.Logf("Correctly handled invalid connection after %v: %v", time.Since(start), err)
+
+	// The retries stop once the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	cfg.ConnectRetries = 100
+	start = time.Now()
+	if _, err := NewWithContext(ctx, cfg); err == nil {
+		t.Fatal("Expected NewWithContext() to fail with invalid connection string")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("NewWithContext() kept retrying for %v after its context expired", elapsed)
+	}
+}
+
+func TestNewWithContextMissingURI(t *testing.T) {
+	originalURI := os.Getenv("DB_URI")
+	defer os.Setenv("DB_URI", originalURI)
+	os.Setenv("DB_URI", "")
+
+	// A .env file next to the tests would supply the URI
+	for _, path := range []string{".env", "../.env", "../../.env"} {
+		if _, err := os.Stat(path); err == nil {
+			t.Skipf("%s provides DB_URI", path)
 		}
-	}()
-	
-	New() // This should panic due to connection failure
+	}
+
+	if _, err := NewWithContext(context.Background(), config.DatabaseConfig{}); !errors.Is(err, ErrMissingURI) {
+		t.Errorf("NewWithContext() error = %v, want ErrMissingURI", err)
+	}
 }
 
 func TestHealthCheckUnderLoad(t *testing.T) {
@@ -960,3 +996,94 @@ func BenchmarkConcurrentOperations(b *testing.B) {
 	// Clean up
 	testCollection.DeleteMany(ctx, bson.M{"benchmark": true})
 }
+
+func TestCheck(t *testing.T) {
+	srv := New()
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := srv.GetDatabase().Collection("health_check_test")
+	if _, err := collection.InsertOne(ctx, bson.M{"ok": true}); err != nil {
+		t.Fatalf("Failed to create collection: %v", err)
+	}
+	defer collection.Drop(ctx)
+
+	report := srv.Check(ctx, []string{"health_check_test"})
+	if !report.Ready() {
+		t.Fatalf("Check() = %+v, want ready", report)
+	}
+	if report.PingLatencyMs <= 0 {
+		t.Errorf("PingLatencyMs = %v, want > 0", report.PingLatencyMs)
+	}
+	if report.OpenConnections <= 0 {
+		t.Errorf("OpenConnections = %d, want > 0", report.OpenConnections)
+	}
+
+	report = srv.Check(ctx, []string{"health_check_test", "no_such_collection"})
+	if report.Status != StatusMissingCollections || len(report.MissingCollections) != 1 || report.MissingCollections[0] != "no_such_collection" {
+		t.Errorf("Check() with a missing collection = %+v", report)
+	}
+
+	srv.Close()
+	if report := srv.Check(ctx, nil); report.Ready() {
+		t.Error("Check() after Close should not be ready")
+	}
+}
+
+func TestConfiguredConnectionPool(t *testing.T) {
+	const maxPoolSize = 4
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	srv, err := NewWithContext(ctx, config.DatabaseConfig{
+		MaxPoolSize:     maxPoolSize,
+		MinPoolSize:     1,
+		MaxConnIdleTime: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("NewWithContext() error = %v", err)
+	}
+	defer srv.Close()
+
+	// Many more concurrent operations than connections wait for one instead of failing
+	collection := srv.GetDatabase().Collection("test_configured_pool")
+	defer collection.Drop(context.Background())
+
+	const workers, opsPerWorker = 50, 4
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		go func(worker int) {
+			for j := 0; j < opsPerWorker; j++ {
+				if _, err := collection.InsertOne(ctx, bson.M{"worker": worker, "op": j}); err != nil {
+					errs <- fmt.Errorf("worker %d insert %d: %w", worker, j, err)
+					return
+				}
+				if _, err := collection.CountDocuments(ctx, bson.M{"worker": worker}); err != nil {
+					errs <- fmt.Errorf("worker %d count %d: %w", worker, j, err)
+					return
+				}
+				// The driver's monitoring connections aren't pooled, so they aren't counted
+				if open := srv.Check(ctx, nil).OpenConnections; open > maxPoolSize {
+					errs <- fmt.Errorf("%d connections open, want at most %d", open, maxPoolSize)
+					return
+				}
+			}
+			errs <- nil
+		}(i)
+	}
+	for i := 0; i < workers; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Concurrent operation failed: %v", err)
+		}
+	}
+
+	count, err := collection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		t.Fatalf("CountDocuments() error = %v", err)
+	}
+	if count != workers*opsPerWorker {
+		t.Errorf("Inserted %d documents, want %d", count, workers*opsPerWorker)
+	}
+}
@@ -0,0 +1,102 @@
+// Package lifecycle shuts the application's subsystems down in a fixed order.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// timeoutGrace is how long a hook may keep running once the shutdown deadline has passed,
+// so hooks that react to ctx promptly can still finish in order
+const timeoutGrace = time.Second
+
+// ShutdownFunc stops a subsystem. It should return once the subsystem has stopped or
+// ctx is done, whichever comes first.
+type ShutdownFunc func(ctx context.Context) error
+
+type hook struct {
+	name     string
+	shutdown ShutdownFunc
+}
+
+// Manager runs registered shutdown hooks in registration order
+type Manager struct {
+	mu    sync.Mutex
+	hooks []hook
+}
+
+// NewManager creates a manager with no hooks
+func NewManager() *Manager {
+	return &Manager{}
+}
+
+// Register adds a hook that runs after every hook registered before it
+func (m *Manager) Register(name string, fn ShutdownFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, hook{name: name, shutdown: fn})
+}
+
+// Shutdown runs every hook in order within ctx's deadline. A hook that is still running
+// when ctx is done is logged and abandoned. Later hooks still run so they can release
+// what they can without waiting, e.g. closing the database. The errors of all hooks
+// that failed or timed out are returned joined.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	hooks := append([]hook(nil), m.hooks...)
+	m.mu.Unlock()
+
+	var errs []error
+	for _, h := range hooks {
+		start := time.Now()
+		if err := run(ctx, h); err != nil {
+			log.Printf("Shutdown: %s failed after %v: %v", h.name, time.Since(start).Round(time.Millisecond), err)
+			errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+			continue
+		}
+		log.Printf("Shutdown: %s stopped in %v", h.name, time.Since(start).Round(time.Millisecond))
+	}
+	return errors.Join(errs...)
+}
+
+// run calls the hook, returning ctx's error if the hook doesn't return in time
+func run(ctx context.Context, h hook) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- h.shutdown(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		timer := time.NewTimer(timeoutGrace)
+		defer timer.Stop()
+		select {
+		case err := <-done:
+			return err
+		case <-timer.C:
+			return fmt.Errorf("timed out: %w", ctx.Err())
+		}
+	}
+}
+
+// Wait waits for wg, giving up when ctx is done
+func Wait(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
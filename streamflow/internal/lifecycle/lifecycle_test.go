@@ -0,0 +1,87 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestManager_Shutdown(t *testing.T) {
+	m := NewManager()
+	var mu sync.Mutex
+	var ran []string
+	record := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		ran = append(ran, name)
+	}
+
+	errFailed := errors.New("failed")
+	m.Register("first", func(ctx context.Context) error {
+		record("first")
+		return nil
+	})
+	m.Register("failing", func(ctx context.Context) error {
+		record("failing")
+		return errFailed
+	})
+	m.Register("last", func(ctx context.Context) error {
+		record("last")
+		return nil
+	})
+
+	err := m.Shutdown(context.Background())
+	if !errors.Is(err, errFailed) {
+		t.Errorf("Shutdown() error = %v, want it to wrap the failing hook's error", err)
+	}
+	if want := []string{"first", "failing", "last"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("Hooks ran in order %v, want %v", ran, want)
+	}
+}
+
+func TestManager_ShutdownTimeout(t *testing.T) {
+	m := NewManager()
+	release := make(chan struct{})
+	defer close(release)
+
+	// Ignores ctx, so it is abandoned once the grace period is over
+	m.Register("stuck", func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	closed := false
+	m.Register("database", func(ctx context.Context) error {
+		closed = true
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := m.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() error = %v, want deadline exceeded", err)
+	}
+	if !closed {
+		t.Error("Hooks after a timed out hook should still run")
+	}
+}
+
+func TestWait(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := Wait(ctx, &wg); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() error = %v, want deadline exceeded", err)
+	}
+
+	wg.Done()
+	if err := Wait(context.Background(), &wg); err != nil {
+		t.Errorf("Wait() unexpected error = %v", err)
+	}
+}
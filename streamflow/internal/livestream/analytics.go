@@ -0,0 +1,101 @@
+package livestream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrStreamNotFound = errors.New("stream not found")
+	ErrNotStreamOwner = errors.New("not the stream owner")
+)
+
+// GetStreamAnalytics summarizes a stream for its owner from the viewer samples recorded
+// while it was live and its chat history
+func (s *LivestreamService) GetStreamAnalytics(ctx context.Context, streamID, ownerID primitive.ObjectID) (*StreamAnalytics, error) {
+	var stream Livestream
+	if err := s.livestreamCollection.FindOne(ctx, bson.M{"_id": streamID}).Decode(&stream); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrStreamNotFound
+		}
+		return nil, err
+	}
+	if stream.UserID != ownerID {
+		return nil, ErrNotStreamOwner
+	}
+	s.applyLiveViewerCounts(&stream)
+
+	analytics := &StreamAnalytics{
+		StreamID:    streamID,
+		ViewerCount: stream.ViewerCount,
+		PeakViewers: max(stream.PeakViewerCount, stream.ViewerCount),
+	}
+
+	samplePipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"stream_id": streamID}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     nil,
+			"peak":    bson.M{"$max": "$viewer_count"},
+			"average": bson.M{"$avg": "$viewer_count"},
+		}}},
+	}
+	var samples struct {
+		Peak    int     `bson:"peak"`
+		Average float64 `bson:"average"`
+	}
+	if err := aggregateOne(ctx, s.sampleCollection, samplePipeline, &samples); err != nil {
+		return nil, fmt.Errorf("failed to aggregate viewer samples: %w", err)
+	}
+	analytics.PeakViewers = max(analytics.PeakViewers, samples.Peak)
+	analytics.AverageViewers = samples.Average
+
+	chatPipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"stream_id": streamID}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      nil,
+			"messages": bson.M{"$sum": 1},
+			"chatters": bson.M{"$addToSet": "$user_id"},
+		}}},
+		{{Key: "$project", Value: bson.M{"messages": 1, "chatters": bson.M{"$size": "$chatters"}}}},
+	}
+	var chat struct {
+		Messages int `bson:"messages"`
+		Chatters int `bson:"chatters"`
+	}
+	if err := aggregateOne(ctx, s.chatCollection, chatPipeline, &chat); err != nil {
+		return nil, fmt.Errorf("failed to aggregate chat: %w", err)
+	}
+	analytics.MessageCount = chat.Messages
+	analytics.UniqueChatters = chat.Chatters
+
+	if stream.StartedAt != nil {
+		end := time.Now()
+		if stream.EndedAt != nil {
+			end = *stream.EndedAt
+		}
+		analytics.DurationSeconds = end.Sub(*stream.StartedAt).Seconds()
+	}
+
+	return analytics, nil
+}
+
+// aggregateOne decodes the single result of a grouping pipeline into out, leaving out
+// untouched when there was nothing to group
+func aggregateOne(ctx context.Context, collection *mongo.Collection, pipeline mongo.Pipeline, out interface{}) error {
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	if cursor.Next(ctx) {
+		return cursor.Decode(out)
+	}
+	return cursor.Err()
+}
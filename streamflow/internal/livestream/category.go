@@ -0,0 +1,98 @@
+package livestream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"streamflow/internal/tags"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidCategory is returned for a category that isn't in the configured allow-list
+var ErrInvalidCategory = errors.New("invalid stream category")
+
+// CategoryCount is a stream category with the number of streams live in it
+type CategoryCount struct {
+	Category  string `json:"category" bson:"_id"`
+	LiveCount int    `json:"live_count" bson:"live_count"`
+}
+
+// GetStreamsByCategory returns live streams in the given category, most watched first
+func (s *LivestreamService) GetStreamsByCategory(ctx context.Context, category string) ([]*Livestream, error) {
+	category, err := s.validateCategory(category)
+	if err != nil {
+		return nil, err
+	}
+	if category == "" {
+		return nil, fmt.Errorf("%w: category is required", ErrInvalidCategory)
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "viewer_count", Value: -1}})
+	cursor, err := s.livestreamCollection.Find(ctx, bson.M{"status": StreamStatusLive, "category": category}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	streams := []*Livestream{}
+	if err := cursor.All(ctx, &streams); err != nil {
+		return nil, err
+	}
+	s.applyLiveViewerCounts(streams...)
+	return streams, nil
+}
+
+// ListCategories returns every allowed category with how many streams are live in it,
+// busiest first. Categories with nothing live are included with a count of zero.
+func (s *LivestreamService) ListCategories(ctx context.Context) ([]CategoryCount, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"status": StreamStatusLive, "category": bson.M{"$in": s.categories}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$category", "live_count": bson.M{"$sum": 1}}}},
+	}
+
+	cursor, err := s.livestreamCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var live []CategoryCount
+	if err := cursor.All(ctx, &live); err != nil {
+		return nil, err
+	}
+	liveCounts := make(map[string]int, len(live))
+	for _, count := range live {
+		liveCounts[count.Category] = count.LiveCount
+	}
+
+	categories := make([]CategoryCount, 0, len(s.categories))
+	for _, category := range s.categories {
+		categories = append(categories, CategoryCount{Category: category, LiveCount: liveCounts[category]})
+	}
+	// Stable so categories with equal counts keep their configured order
+	sort.SliceStable(categories, func(i, j int) bool {
+		return categories[i].LiveCount > categories[j].LiveCount
+	})
+
+	return categories, nil
+}
+
+// validateCategory normalizes the category and checks it against the allow-list.
+// An empty category is allowed and leaves the stream uncategorized.
+func (s *LivestreamService) validateCategory(category string) (string, error) {
+	category = tags.Normalize(category)
+	if category == "" {
+		return "", nil
+	}
+	for _, allowed := range s.categories {
+		if category == allowed {
+			return category, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrInvalidCategory, category)
+}
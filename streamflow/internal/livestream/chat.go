@@ -1,11 +1,22 @@
 package livestream
 
 import (
+	"errors"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const (
+	// DefaultChatPageSize is the number of chat messages returned when no limit is given
+	DefaultChatPageSize = 50
+	// MaxChatPageSize caps how many chat messages a single request can return
+	MaxChatPageSize = 200
+)
+
+// ErrInvalidChatCursor is returned when a pagination cursor doesn't match a message in the stream
+var ErrInvalidChatCursor = errors.New("invalid chat cursor")
+
 type ChatMessage struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty"`
 	StreamID  primitive.ObjectID `bson:"stream_id"`
@@ -15,3 +26,10 @@ type ChatMessage struct {
 	CreatedAt time.Time          `bson:"created_at"`
 	UpdatedAt time.Time          `bson:"updated_at"`
 }
+
+// ChatMessagePage is one page of a stream's chat history, newest first.
+// NextCursor is empty when there are no older messages.
+type ChatMessagePage struct {
+	Messages   []*ChatMessage `json:"messages"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
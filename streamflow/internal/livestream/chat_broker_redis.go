@@ -0,0 +1,81 @@
+package livestream
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"streamflow/internal/redis"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// redisChatPrefix starts the name of each stream's chat channel
+	redisChatPrefix = "chat:"
+	// redisResubscribeDelay is the wait before subscribing again after losing the connection
+	redisResubscribeDelay = time.Second
+)
+
+// RedisChatBroker publishes chat on a Redis channel per stream, so each instance pushes
+// every message to the viewers connected to it
+type RedisChatBroker struct {
+	client *redis.Client
+}
+
+// NewRedisChatBroker creates a chat broker on client
+func NewRedisChatBroker(client *redis.Client) *RedisChatBroker {
+	return &RedisChatBroker{client: client}
+}
+
+// Publish sends a message on the stream's channel
+func (b *RedisChatBroker) Publish(ctx context.Context, streamID primitive.ObjectID, message []byte) error {
+	if _, err := b.client.Do(ctx, "PUBLISH", redisChatPrefix+streamID.Hex(), message); err != nil {
+		return fmt.Errorf("failed to publish chat message to redis: %w", err)
+	}
+	return nil
+}
+
+// Subscribe passes the messages of every stream's channel to deliver until ctx is done.
+// A lost connection is re-established; messages published meanwhile are not delivered.
+func (b *RedisChatBroker) Subscribe(ctx context.Context, deliver func(streamID primitive.ObjectID, message []byte)) {
+	go func() {
+		for {
+			if err := b.receive(ctx, deliver); err != nil && ctx.Err() == nil {
+				log.Printf("Chat subscription to redis failed, resubscribing: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(redisResubscribeDelay):
+			}
+		}
+	}()
+}
+
+// receive delivers messages until the subscription fails or ctx is done
+func (b *RedisChatBroker) receive(ctx context.Context, deliver func(streamID primitive.ObjectID, message []byte)) error {
+	sub, err := b.client.PSubscribe(ctx, redisChatPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+	// Closing the subscription unblocks Receive
+	stop := context.AfterFunc(ctx, func() { sub.Close() })
+	defer stop()
+
+	for {
+		msg, err := sub.Receive()
+		if err != nil {
+			return err
+		}
+		streamID, err := primitive.ObjectIDFromHex(strings.TrimPrefix(msg.Channel, redisChatPrefix))
+		if err != nil {
+			continue
+		}
+		deliver(streamID, msg.Payload)
+	}
+}
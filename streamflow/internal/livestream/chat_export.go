@@ -0,0 +1,160 @@
+package livestream
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Chat export formats
+const (
+	ChatExportJSON = "json"
+	ChatExportCSV  = "csv"
+)
+
+// chatExportBatchSize is how many messages are fetched from the database at a time
+const chatExportBatchSize = 500
+
+// ErrInvalidExportFormat is returned for an export format other than ChatExportJSON or ChatExportCSV
+var ErrInvalidExportFormat = errors.New("export format must be json or csv")
+
+// ChatExport writes a stream's whole chat history, oldest first. Messages are read from
+// a database cursor as they are written, so histories of any size use little memory.
+type ChatExport struct {
+	service  *LivestreamService
+	streamID primitive.ObjectID
+	format   string
+}
+
+// ExportMessages prepares an export of a stream's chat for its owner in format, which
+// defaults to ChatExportJSON. Nothing is read until the export is written.
+func (s *LivestreamService) ExportMessages(ctx context.Context, streamID, ownerID primitive.ObjectID, format string) (*ChatExport, error) {
+	format = strings.ToLower(format)
+	if format == "" {
+		format = ChatExportJSON
+	}
+	if format != ChatExportJSON && format != ChatExportCSV {
+		return nil, ErrInvalidExportFormat
+	}
+	if err := s.checkOwner(ctx, streamID, ownerID); err != nil {
+		return nil, err
+	}
+	return &ChatExport{service: s, streamID: streamID, format: format}, nil
+}
+
+// ContentType returns the MIME type of the export
+func (e *ChatExport) ContentType() string {
+	if e.format == ChatExportCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/json"
+}
+
+// Filename returns the name to save the export under
+func (e *ChatExport) Filename() string {
+	return fmt.Sprintf("chat-%s.%s", e.streamID.Hex(), e.format)
+}
+
+// Write writes the export to w. An error part way leaves w holding a truncated export.
+func (e *ChatExport) Write(ctx context.Context, w io.Writer) error {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetBatchSize(chatExportBatchSize)
+	cursor, err := e.service.chatCollection.Find(ctx, bson.M{"stream_id": e.streamID}, opts)
+	if err != nil {
+		return fmt.Errorf("failed to read chat: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	next := func() (*ChatMessage, error) {
+		if !cursor.Next(ctx) {
+			return nil, cursor.Err()
+		}
+		var message ChatMessage
+		if err := cursor.Decode(&message); err != nil {
+			return nil, fmt.Errorf("failed to decode chat message: %w", err)
+		}
+		return &message, nil
+	}
+	if e.format == ChatExportCSV {
+		return writeChatCSV(w, next)
+	}
+	return writeChatJSON(w, next)
+}
+
+// writeChatJSON writes messages as a JSON array, one message at a time. next returns nil
+// after the last message.
+func writeChatJSON(w io.Writer, next func() (*ChatMessage, error)) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	for first := true; ; first = false {
+		message, err := next()
+		if err != nil {
+			return err
+		}
+		if message == nil {
+			break
+		}
+		data, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		if !first {
+			data = append([]byte(","), data...)
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]")
+	return err
+}
+
+// writeChatCSV writes messages as CSV with a header row. next returns nil after the
+// last message.
+func writeChatCSV(w io.Writer, next func() (*ChatMessage, error)) error {
+	out := csv.NewWriter(w)
+	if err := out.Write([]string{"id", "created_at", "user_id", "user_name", "message"}); err != nil {
+		return err
+	}
+	for {
+		message, err := next()
+		if err != nil {
+			return err
+		}
+		if message == nil {
+			break
+		}
+		err = out.Write([]string{
+			message.ID.Hex(),
+			message.CreatedAt.UTC().Format(time.RFC3339Nano),
+			message.UserID.Hex(),
+			csvSafe(message.UserName),
+			csvSafe(message.Message),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// csvSafe stops a spreadsheet opening the export from running a chat message as a
+// formula, by prefixing values that start like one with a quote
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,220 @@
+package livestream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultMaxChatMessageLength bounds the length of a single chat message, in characters,
+// when the config doesn't set a limit
+const DefaultMaxChatMessageLength = 500
+
+// chatPublishTimeout bounds handing a chat message to the broker
+const chatPublishTimeout = 5 * time.Second
+
+var (
+	// ErrInvalidChatMessage is returned for a chat message that can't be sent
+	ErrInvalidChatMessage = errors.New("invalid chat message")
+	// ErrEmptyMessage is returned for a chat message that is empty or only whitespace
+	ErrEmptyMessage = fmt.Errorf("%w: message is empty", ErrInvalidChatMessage)
+	// ErrMessageTooLong is returned for a chat message over the length limit, unless
+	// messages are configured to be truncated
+	ErrMessageTooLong = fmt.Errorf("%w: message is too long", ErrInvalidChatMessage)
+)
+
+// ChatEvent is the payload of a "chat_message" pushed to stream viewers
+type ChatEvent struct {
+	StreamID  string    `json:"stream_id"`
+	UserID    string    `json:"user_id"`
+	UserName  string    `json:"user_name"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChatBroker carries chat messages to the hubs that push them to viewers. The default
+// broker delivers to this instance's hub only; RedisChatBroker reaches every instance.
+type ChatBroker interface {
+	// Publish sends a message to everyone watching the stream
+	Publish(ctx context.Context, streamID primitive.ObjectID, message []byte) error
+	// Subscribe passes every published message to deliver, in the background, until ctx is done
+	Subscribe(ctx context.Context, deliver func(streamID primitive.ObjectID, message []byte))
+}
+
+// localChatBroker hands messages straight to the subscribed hub
+type localChatBroker struct {
+	deliver func(streamID primitive.ObjectID, message []byte)
+}
+
+func (b *localChatBroker) Publish(ctx context.Context, streamID primitive.ObjectID, message []byte) error {
+	b.deliver(streamID, message)
+	return nil
+}
+
+func (b *localChatBroker) Subscribe(ctx context.Context, deliver func(streamID primitive.ObjectID, message []byte)) {
+	b.deliver = deliver
+}
+
+// ChatHub tracks the WebSocket clients watching each stream and pushes chat to them
+type ChatHub struct {
+	mu                sync.Mutex
+	rooms             map[primitive.ObjectID]map[*Client]struct{}
+	livestreamService *LivestreamService
+	broker            ChatBroker
+	stopBroker        context.CancelFunc // Ends the hub's broker subscription
+}
+
+// NewChatHub creates a chat hub that persists messages through the livestream service.
+// Messages only reach viewers connected to this instance until SetBroker is called.
+func NewChatHub(ls *LivestreamService) *ChatHub {
+	hub := &ChatHub{
+		rooms:             make(map[primitive.ObjectID]map[*Client]struct{}),
+		livestreamService: ls,
+	}
+	hub.SetBroker(&localChatBroker{})
+	return hub
+}
+
+// SetBroker makes the hub publish chat through broker and push what it receives from it
+// to local clients. It must be called before the hub is used.
+func (h *ChatHub) SetBroker(broker ChatBroker) {
+	if h.stopBroker != nil {
+		h.stopBroker()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h.broker = broker
+	h.stopBroker = cancel
+	broker.Subscribe(ctx, h.Broadcast)
+}
+
+// Subscribe adds the client to its stream's room
+func (h *ChatHub) Subscribe(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	room, ok := h.rooms[c.streamID]
+	if !ok {
+		room = make(map[*Client]struct{})
+		h.rooms[c.streamID] = room
+	}
+	room[c] = struct{}{}
+}
+
+// Unsubscribe removes the client and closes its send channel, which stops its write pump.
+// It is safe to call more than once.
+func (h *ChatHub) Unsubscribe(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeLocked(c)
+}
+
+// Send queues a message for a single client. Clients that can't keep up are dropped.
+func (h *ChatHub) Send(c *Client, message []byte) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.rooms[c.streamID][c]; !ok {
+		return false
+	}
+	return h.sendLocked(c, message)
+}
+
+// Broadcast queues a message for every client watching the stream
+func (h *ChatHub) Broadcast(streamID primitive.ObjectID, message []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.rooms[streamID] {
+		h.sendLocked(c, message)
+	}
+}
+
+// Publish persists a chat message and pushes it to everyone watching the stream, on every
+// instance the broker reaches
+func (h *ChatHub) Publish(streamID, userID primitive.ObjectID, userName, text string) error {
+	chatMessage, err := h.livestreamService.sendChatMessage(streamID, userID, userName, text)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(ChatEvent{
+		StreamID:  streamID.Hex(),
+		UserID:    userID.Hex(),
+		UserName:  userName,
+		Message:   chatMessage.Message,
+		CreatedAt: chatMessage.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	message, err := json.Marshal(WebSocketMessage{Type: "chat_message", Payload: payload})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), chatPublishTimeout)
+	defer cancel()
+	if err := h.broker.Publish(ctx, streamID, message); err != nil {
+		// The message is saved, so at least show it to the viewers on this instance
+		log.Printf("Failed to publish chat message for stream %s: %v", streamID.Hex(), err)
+		h.Broadcast(streamID, message)
+	}
+	return nil
+}
+
+// SubscriberCount returns how many clients are watching the stream's chat
+func (h *ChatHub) SubscriberCount(streamID primitive.ObjectID) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.rooms[streamID])
+}
+
+// Close ends the broker subscription and disconnects every client. Closing a client's send channel stops its write pump,
+// which closes the connection and ends its read pump.
+func (h *ChatHub) Close() {
+	if h == nil {
+		return
+	}
+	h.stopBroker()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, room := range h.rooms {
+		for c := range room {
+			h.removeLocked(c)
+		}
+	}
+}
+
+func (h *ChatHub) sendLocked(c *Client, message []byte) bool {
+	select {
+	case c.send <- message:
+		return true
+	default:
+		log.Printf("WebSocket: dropping slow client (UserID: %s)", c.userID.Hex())
+		h.removeLocked(c)
+		return false
+	}
+}
+
+func (h *ChatHub) removeLocked(c *Client) {
+	room, ok := h.rooms[c.streamID]
+	if !ok {
+		return
+	}
+	if _, ok := room[c]; !ok {
+		return
+	}
+	delete(room, c)
+	close(c.send)
+	if len(room) == 0 {
+		delete(h.rooms, c.streamID)
+	}
+}
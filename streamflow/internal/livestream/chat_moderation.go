@@ -0,0 +1,68 @@
+package livestream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetMessagesByUser returns every message a user sent in a stream's chat, oldest first,
+// for the stream's owner or an admin reviewing the user
+func (s *LivestreamService) GetMessagesByUser(ctx context.Context, streamID, userID, moderatorID primitive.ObjectID) ([]*ChatMessage, error) {
+	if err := s.checkModerator(ctx, streamID, moderatorID); err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.chatCollection.Find(ctx, bson.M{"stream_id": streamID, "user_id": userID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chat: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	messages := []*ChatMessage{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, fmt.Errorf("failed to decode chat messages: %w", err)
+	}
+	return messages, nil
+}
+
+// BulkDeleteUserMessages removes every message a user sent in a stream's chat and
+// returns how many there were. Like GetMessagesByUser, only the stream's owner or an
+// admin may do so.
+func (s *LivestreamService) BulkDeleteUserMessages(ctx context.Context, streamID, userID, moderatorID primitive.ObjectID) (int64, error) {
+	if err := s.checkModerator(ctx, streamID, moderatorID); err != nil {
+		return 0, err
+	}
+
+	result, err := s.chatCollection.DeleteMany(ctx, bson.M{"stream_id": streamID, "user_id": userID})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete chat messages: %w", err)
+	}
+	return result.DeletedCount, nil
+}
+
+// checkModerator returns nil if moderatorID owns the stream or is an admin, or the error
+// of checkOwner otherwise
+func (s *LivestreamService) checkModerator(ctx context.Context, streamID, moderatorID primitive.ObjectID) error {
+	err := s.checkOwner(ctx, streamID, moderatorID)
+	if !errors.Is(err, ErrNotStreamOwner) || s.userService == nil {
+		return err
+	}
+
+	user, lookupErr := s.userService.GetUserByID(ctx, moderatorID)
+	switch {
+	case errors.Is(lookupErr, mongo.ErrNoDocuments):
+		return err
+	case lookupErr != nil:
+		return fmt.Errorf("failed to find user: %w", lookupErr)
+	case user.IsAdmin():
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,159 @@
+package livestream
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"streamflow/internal/config"
+)
+
+type CodecPolicy string
+
+const (
+	// CodecPolicyStrict rejects ingests whose codecs are not in the allow-list
+	CodecPolicyStrict CodecPolicy = "strict"
+	// CodecPolicyLenient accepts audio codecs outside the allow-list for server-side
+	// transcoding. Video is forwarded to viewers as-is and can't be transcoded, so a video
+	// codec outside the allow-list is rejected under either policy.
+	CodecPolicyLenient CodecPolicy = "lenient"
+)
+
+var ErrUnsupportedCodec = errors.New("unsupported codec")
+
+// IngestCodecs describes the codecs announced by an encoder
+type IngestCodecs struct {
+	Video string
+	Audio string
+}
+
+// CodecValidator checks incoming ingest codecs against a configured allow-list
+type CodecValidator struct {
+	allowedVideo map[string]bool
+	allowedAudio map[string]bool
+	policy       CodecPolicy
+}
+
+// NewCodecValidator creates a codec validator from the livestream configuration
+func NewCodecValidator(cfg config.LivestreamConfig) *CodecValidator {
+	v := &CodecValidator{
+		allowedVideo: make(map[string]bool),
+		allowedAudio: make(map[string]bool),
+		policy:       CodecPolicy(cfg.CodecPolicy),
+	}
+	if v.policy != CodecPolicyLenient {
+		v.policy = CodecPolicyStrict
+	}
+	for _, codec := range cfg.AllowedVideoCodecs {
+		v.allowedVideo[normalizeCodec(codec)] = true
+	}
+	for _, codec := range cfg.AllowedAudioCodecs {
+		v.allowedAudio[normalizeCodec(codec)] = true
+	}
+	return v
+}
+
+// Validate checks the announced codecs. It returns whether the audio needs to be
+// transcoded server-side, or ErrUnsupportedCodec when the ingest is rejected. A video
+// codec outside the allow-list is always rejected; the policy only decides about audio.
+// Codecs that were not announced are not judged.
+func (v *CodecValidator) Validate(codecs IngestCodecs) (transcodeAudio bool, err error) {
+	var problems []string
+
+	video := normalizeCodec(codecs.Video)
+	if video != "" && len(v.allowedVideo) > 0 && !v.allowedVideo[video] {
+		problems = append(problems, fmt.Sprintf("video codec %s is not allowed (allowed: %s)", video, joinCodecs(v.allowedVideo)))
+	}
+
+	audio := normalizeCodec(codecs.Audio)
+	if audio != "" && len(v.allowedAudio) > 0 && !v.allowedAudio[audio] {
+		if v.policy == CodecPolicyLenient {
+			transcodeAudio = true
+		} else {
+			problems = append(problems, fmt.Sprintf("audio codec %s is not allowed (allowed: %s)", audio, joinCodecs(v.allowedAudio)))
+		}
+	}
+
+	if len(problems) > 0 {
+		return false, fmt.Errorf("%w: %s", ErrUnsupportedCodec, strings.Join(problems, "; "))
+	}
+	return transcodeAudio, nil
+}
+
+// flvVideoCodecs maps FLV video codec IDs to codec names
+var flvVideoCodecs = map[int]string{
+	2:  "h263",
+	3:  "screenvideo",
+	4:  "vp6",
+	5:  "vp6a",
+	6:  "screenvideo2",
+	7:  "h264",
+	12: "hevc",
+	13: "av1",
+}
+
+// flvAudioCodecs maps FLV sound format IDs to codec names
+var flvAudioCodecs = map[int]string{
+	0:  "pcm",
+	1:  "adpcm",
+	2:  "mp3",
+	3:  "pcm",
+	4:  "nellymoser",
+	5:  "nellymoser",
+	6:  "nellymoser",
+	7:  "g711a",
+	8:  "g711u",
+	10: "aac",
+	11: "speex",
+	14: "mp3",
+}
+
+// IngestCodecsFromMetadata reads the codecs announced in an RTMP onMetaData object.
+// Encoders send either numeric FLV codec IDs or FourCC strings.
+func IngestCodecsFromMetadata(metadata map[string]interface{}) IngestCodecs {
+	return IngestCodecs{
+		Video: codecFromMetadataValue(metadata["videocodecid"], flvVideoCodecs),
+		Audio: codecFromMetadataValue(metadata["audiocodecid"], flvAudioCodecs),
+	}
+}
+
+func codecFromMetadataValue(value interface{}, ids map[int]string) string {
+	switch v := value.(type) {
+	case float64:
+		if name, ok := ids[int(v)]; ok {
+			return name
+		}
+		return fmt.Sprintf("unknown(%d)", int(v))
+	case string:
+		return normalizeCodec(v)
+	}
+	return ""
+}
+
+// normalizeCodec maps common codec aliases to a single canonical name
+func normalizeCodec(codec string) string {
+	codec = strings.ToLower(strings.TrimSpace(codec))
+	switch codec {
+	case "avc", "avc1", "h.264":
+		return "h264"
+	case "hvc1", "hev1", "h265", "h.265":
+		return "hevc"
+	case "mp4a":
+		return "aac"
+	case ".mp3":
+		return "mp3"
+	case "av01":
+		return "av1"
+	}
+	return codec
+}
+
+func joinCodecs(codecs map[string]bool) string {
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
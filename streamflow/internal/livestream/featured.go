@@ -0,0 +1,68 @@
+package livestream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaxFeaturedStreams caps the streams GetFeaturedStreams lists
+const MaxFeaturedStreams = 50
+
+// SetFeatured features a stream (admin only). Featuring it again moves it to the end of
+// the list. A stream that isn't live yet is listed once it goes live.
+func (s *LivestreamService) SetFeatured(ctx context.Context, streamID primitive.ObjectID) (*Livestream, error) {
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"featured": true, "featured_at": now, "updated_at": now}}
+	return s.updateFeatured(ctx, streamID, update)
+}
+
+// UnsetFeatured takes a stream off the featured list (admin only)
+func (s *LivestreamService) UnsetFeatured(ctx context.Context, streamID primitive.ObjectID) (*Livestream, error) {
+	update := bson.M{
+		"$set":   bson.M{"updated_at": time.Now()},
+		"$unset": bson.M{"featured": "", "featured_at": ""},
+	}
+	return s.updateFeatured(ctx, streamID, update)
+}
+
+// updateFeatured applies update to a stream and returns it as updated
+func (s *LivestreamService) updateFeatured(ctx context.Context, streamID primitive.ObjectID, update bson.M) (*Livestream, error) {
+	var stream Livestream
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.livestreamCollection.FindOneAndUpdate(ctx, bson.M{"_id": streamID}, update, opts).Decode(&stream)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrStreamNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update stream: %w", err)
+	}
+	s.applyLiveViewerCounts(&stream)
+	return &stream, nil
+}
+
+// GetFeaturedStreams returns the featured streams that are live, in the order they were
+// featured. Featured streams drop off the list when they end.
+func (s *LivestreamService) GetFeaturedStreams(ctx context.Context) ([]*Livestream, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "featured_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(MaxFeaturedStreams)
+	cursor, err := s.livestreamCollection.Find(ctx, bson.M{"featured": true, "status": StreamStatusLive}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list featured streams: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	streams := []*Livestream{}
+	if err := cursor.All(ctx, &streams); err != nil {
+		return nil, fmt.Errorf("failed to decode featured streams: %w", err)
+	}
+	s.applyLiveViewerCounts(streams...)
+	return streams, nil
+}
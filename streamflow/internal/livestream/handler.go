@@ -1,120 +1,264 @@
 package livestream
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"strconv"
+	"time"
+
+	"streamflow/internal/apperr"
+	"streamflow/internal/audit"
+	"streamflow/internal/tags"
+	"streamflow/internal/video"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
+	"github.com/pion/webrtc/v3"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type LivestreamHandler struct {
 	livestreamService *LivestreamService
+	audit             *audit.AuditService
+	webRTC            *WebRTCManager // Nil when WebRTC playback is unavailable
+	streamManager     *StreamManager
 }
 
 func NewLivestreamHandler(livestreamService *LivestreamService) *LivestreamHandler {
 	return &LivestreamHandler{livestreamService: livestreamService}
 }
 
+// SetWebRTCManager enables WebRTC playback through the manager
+func (h *LivestreamHandler) SetWebRTCManager(wm *WebRTCManager) {
+	h.webRTC = wm
+}
+
+// SetStreamManager lets the handler clean up the tracks of streams it stops for admins
+func (h *LivestreamHandler) SetStreamManager(sm *StreamManager) {
+	h.streamManager = sm
+}
+
+// SetAuditLog makes the handler record stream key rotations in the audit log
+func (h *LivestreamHandler) SetAuditLog(a *audit.AuditService) {
+	h.audit = a
+}
+
 func (h *LivestreamHandler) StartStream(c *fiber.Ctx) error {
 	userIDStr, ok := c.Locals("user_id").(string)
 	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Unauthorized",
-		})
+		return apperr.Unauthorized("Unauthorized")
 	}
 	
 	userID, err := primitive.ObjectIDFromHex(userIDStr)
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid user ID",
-		})
+		return apperr.Unauthorized("Invalid user ID")
 	}
 	var req StartStreamRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
+		return apperr.Validation("Invalid request body")
 	}
 
 	stream, err := h.livestreamService.StartStream(userID, req)
+	if detailsErr := streamDetailsError(err); detailsErr != nil {
+		return detailsErr
+	}
+	if errors.Is(err, ErrInvalidCategory) {
+		return apperr.Validation(err.Error())
+	}
+	if errors.Is(err, ErrStreamLimitReached) {
+		return apperr.Forbidden(err.Error())
+	}
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to start stream",
-		})
+		return apperr.Internal("Failed to start stream")
 	}
 
 	return c.Status(fiber.StatusOK).JSON(stream)
 }
 
+// GetActiveStream returns the user's live stream, including its stream key, so a client
+// can resume the current session
+func (h *LivestreamHandler) GetActiveStream(c *fiber.Ctx) error {
+	userIDStr, ok := c.Locals("user_id").(string)
+	if !ok {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		return apperr.Unauthorized("Invalid user ID")
+	}
+
+	stream, err := h.livestreamService.GetActiveStream(c.UserContext(), userID)
+	if err != nil {
+		return apperr.Internal("Failed to get active stream")
+	}
+	if stream == nil {
+		return apperr.NotFound("No active stream")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(stream)
+}
+
+// streamDetailsError translates an invalid title or description into a 400 naming the
+// field. It returns nil for any other error.
+func streamDetailsError(err error) error {
+	switch {
+	case errors.Is(err, ErrStreamTitleRequired):
+		return apperr.Validation("Title is required")
+	case errors.Is(err, ErrStreamTitleTooLong):
+		return apperr.Validation(fmt.Sprintf("Title must be at most %d characters", MaxStreamTitleLength))
+	case errors.Is(err, ErrStreamDescriptionTooLong):
+		return apperr.Validation(fmt.Sprintf("Description must be at most %d characters", MaxStreamDescriptionLength))
+	case errors.Is(err, tags.ErrTooMany):
+		return apperr.Validation(err.Error())
+	}
+	return nil
+}
+
 func (h *LivestreamHandler) StopStream(c *fiber.Ctx) error {
 	userIDStr, ok := c.Locals("user_id").(string)
 	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Unauthorized",
-		})
+		return apperr.Unauthorized("Unauthorized")
 	}
 	
 	userID, err := primitive.ObjectIDFromHex(userIDStr)
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid user ID",
-		})
+		return apperr.Unauthorized("Invalid user ID")
 	}
 
 	streamID, err := primitive.ObjectIDFromHex(c.Params("id"))
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid stream ID",
-		})
+		return apperr.Validation("Invalid stream ID")
 	}
 	_, err = h.livestreamService.StopStream(userID, streamID)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to stop stream",
-		})
+	switch {
+	case errors.Is(err, ErrStreamNotFound):
+		return apperr.NotFound("Stream not found")
+	case errors.Is(err, ErrNotStreamOwner):
+		return apperr.Forbidden("Only the stream owner can stop it")
+	case err != nil:
+		return apperr.Internal("Failed to stop stream")
 	}
 	return c.SendStatus(fiber.StatusNoContent)
 
 }
 
+// StopAllStreamsRequest gives the reason all streams are being stopped
+type StopAllStreamsRequest struct {
+	Reason string `json:"reason"`
+}
+
+// AdminStopAllStreams stops every live stream, for maintenance (admin only). Streams that
+// fail to stop are listed in the response rather than failing the request.
+func (h *LivestreamHandler) AdminStopAllStreams(c *fiber.Ctx) error {
+	var req StopAllStreamsRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return apperr.Validation("Invalid request body")
+		}
+	}
+	if req.Reason == "" {
+		req.Reason = "maintenance"
+	}
+
+	stopAll := h.livestreamService.StopAllStreams
+	if h.streamManager != nil {
+		stopAll = h.streamManager.StopAllStreams
+	}
+	result, err := stopAll(c.UserContext(), req.Reason)
+	if err != nil {
+		log.Printf("Failed to stop all streams: %v", err)
+		return apperr.Internal("Failed to stop streams")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(result)
+}
+
 func (h *LivestreamHandler) GetStreamStatus(c *fiber.Ctx) error {
 	streamID, err := primitive.ObjectIDFromHex(c.Params("id"))
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid stream ID",
-		})
+		return apperr.Validation("Invalid stream ID")
 	}
 
 	status, err := h.livestreamService.GetStreamStatus(streamID)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to get stream status",
-		})
+		return apperr.Internal("Failed to get stream status")
+	}
+
+	return c.JSON(status.Public())
+}
+
+// ScheduleStream handles requests to announce a stream ahead of time
+func (h *LivestreamHandler) ScheduleStream(c *fiber.Ctx) error {
+	userIDStr, ok := c.Locals("user_id").(string)
+	if !ok {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		return apperr.Unauthorized("Invalid user ID")
+	}
+
+	var req ScheduleStreamRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.Validation("Invalid request body")
+	}
+
+	stream, err := h.livestreamService.ScheduleStream(userID, req.StartStreamRequest, req.StartAt)
+	if detailsErr := streamDetailsError(err); detailsErr != nil {
+		return detailsErr
+	}
+	if errors.Is(err, ErrScheduleInPast) || errors.Is(err, ErrInvalidCategory) {
+		return apperr.Validation(err.Error())
+	}
+	if err != nil {
+		return apperr.Internal("Failed to schedule stream")
 	}
 
-	return c.JSON(status)
+	return c.Status(fiber.StatusCreated).JSON(stream)
+}
+
+// GetUpcomingStreams handles requests to list scheduled streams, soonest first
+func (h *LivestreamHandler) GetUpcomingStreams(c *fiber.Ctx) error {
+	limit, _ := strconv.Atoi(c.Query("limit", "10"))
+	if limit <= 0 || limit > 50 {
+		limit = 10
+	}
+
+	streams, err := h.livestreamService.GetUpcomingStreams(c.UserContext(), limit)
+	if err != nil {
+		return apperr.Internal("could not fetch upcoming streams")
+	}
+	return c.Status(fiber.StatusOK).JSON(PublicStreams(streams))
 }
 
 // ListStreams handles requests to list all currently live streams.
 func (h *LivestreamHandler) ListStreams(c *fiber.Ctx) error {
 	streams, err := h.livestreamService.ListStreams()
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not fetch streams"})
+		return apperr.Internal("could not fetch streams")
 	}
-	return c.Status(fiber.StatusOK).JSON(streams)
+	return c.Status(fiber.StatusOK).JSON(PublicStreams(streams))
 }
 
-// GetStream handles requests for a single stream's details.
+// GetStream handles requests for a single stream's details, as shown to viewers.
 func (h *LivestreamHandler) GetStream(c *fiber.Ctx) error {
 	streamID, err := primitive.ObjectIDFromHex(c.Params("id"))
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid stream ID"})
+		return apperr.Validation("invalid stream ID")
 	}
 
-	stream, err := h.livestreamService.GetStreamStatus(streamID)
+	stream, err := h.livestreamService.GetStreamByID(c.UserContext(), streamID)
+	if errors.Is(err, ErrStreamNotFound) {
+		return apperr.NotFound("stream not found")
+	}
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "stream not found"})
+		return apperr.Internal("could not fetch stream")
 	}
 	return c.Status(fiber.StatusOK).JSON(stream)
 }
@@ -124,9 +268,9 @@ func (h *LivestreamHandler) SearchStreams(c *fiber.Ctx) error {
 	query := c.Query("q")
 	streams, err := h.livestreamService.SearchStreams(query)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not perform search"})
+		return apperr.Internal("could not perform search")
 	}
-	return c.Status(fiber.StatusOK).JSON(streams)
+	return c.Status(fiber.StatusOK).JSON(PublicStreams(streams))
 }
 
 // GetPopularStreams handles requests to get streams ordered by viewer count
@@ -138,9 +282,486 @@ func (h *LivestreamHandler) GetPopularStreams(c *fiber.Ctx) error {
 	
 	streams, err := h.livestreamService.GetPopularStreams(limit)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not fetch popular streams"})
+		return apperr.Internal("could not fetch popular streams")
+	}
+	return c.Status(fiber.StatusOK).JSON(PublicStreams(streams))
+}
+
+// GetFeaturedStreams lists the live streams editors picked, in the order they were featured
+func (h *LivestreamHandler) GetFeaturedStreams(c *fiber.Ctx) error {
+	streams, err := h.livestreamService.GetFeaturedStreams(c.UserContext())
+	if err != nil {
+		log.Printf("Failed to get featured streams: %v", err)
+		return apperr.Internal("could not fetch featured streams")
+	}
+	return c.JSON(PublicStreams(streams))
+}
+
+// AdminFeatureStream adds a stream to the featured list (admin only)
+func (h *LivestreamHandler) AdminFeatureStream(c *fiber.Ctx) error {
+	return h.updateFeatured(c, h.livestreamService.SetFeatured)
+}
+
+// AdminUnfeatureStream takes a stream off the featured list (admin only)
+func (h *LivestreamHandler) AdminUnfeatureStream(c *fiber.Ctx) error {
+	return h.updateFeatured(c, h.livestreamService.UnsetFeatured)
+}
+
+// updateFeatured applies update to the stream of the route and responds with it
+func (h *LivestreamHandler) updateFeatured(c *fiber.Ctx, update func(context.Context, primitive.ObjectID) (*Livestream, error)) error {
+	streamID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid stream ID")
+	}
+
+	stream, err := update(c.UserContext(), streamID)
+	if errors.Is(err, ErrStreamNotFound) {
+		return apperr.NotFound("Stream not found")
+	}
+	if err != nil {
+		log.Printf("Failed to update featured stream %s: %v", streamID.Hex(), err)
+		return apperr.Internal("could not update featured stream")
+	}
+	return c.JSON(stream.Public())
+}
+
+// SetStreamTags replaces the tags on one of the authenticated user's streams
+func (h *LivestreamHandler) SetStreamTags(c *fiber.Ctx) error {
+	userIDStr, ok := c.Locals("user_id").(string)
+	if !ok {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		return apperr.Unauthorized("Invalid user ID")
+	}
+
+	streamID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid stream ID")
+	}
+
+	var req SetStreamTagsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.Validation("Invalid request body")
+	}
+
+	streamTags, err := h.livestreamService.SetStreamTags(c.UserContext(), userID, streamID, req.Tags)
+	switch {
+	case errors.Is(err, tags.ErrTooMany):
+		return apperr.Validation(err.Error())
+	case errors.Is(err, ErrStreamNotFound):
+		return apperr.NotFound("Stream not found")
+	case errors.Is(err, ErrNotStreamOwner):
+		return apperr.Forbidden("Only the stream owner can set its tags")
+	case err != nil:
+		return apperr.Internal("Failed to set stream tags")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"tags": streamTags})
+}
+
+// GetFollowedLiveStreams handles requests for the live streams of users the caller follows
+func (h *LivestreamHandler) GetFollowedLiveStreams(c *fiber.Ctx) error {
+	userIDStr, ok := c.Locals("user_id").(string)
+	if !ok {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	viewerID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		return apperr.Unauthorized("Invalid user ID")
+	}
+
+	streams, err := h.livestreamService.GetFollowedLiveStreams(c.UserContext(), viewerID)
+	if err != nil {
+		return apperr.Internal("could not fetch followed streams")
+	}
+	return c.Status(fiber.StatusOK).JSON(PublicStreams(streams))
+}
+
+// ListStreamsByTag handles requests to list live streams with a given tag
+func (h *LivestreamHandler) ListStreamsByTag(c *fiber.Ctx) error {
+	streams, err := h.livestreamService.ListStreamsByTag(c.UserContext(), c.Params("tag"))
+	if err != nil {
+		return apperr.Internal("could not fetch streams")
+	}
+	return c.Status(fiber.StatusOK).JSON(PublicStreams(streams))
+}
+
+// GetStreamsByCategory handles requests to list live streams in a category
+func (h *LivestreamHandler) GetStreamsByCategory(c *fiber.Ctx) error {
+	streams, err := h.livestreamService.GetStreamsByCategory(c.UserContext(), c.Params("category"))
+	if errors.Is(err, ErrInvalidCategory) {
+		return apperr.Validation(err.Error())
+	}
+	if err != nil {
+		return apperr.Internal("could not fetch streams")
+	}
+	return c.Status(fiber.StatusOK).JSON(PublicStreams(streams))
+}
+
+// ListCategories handles requests to list stream categories with their live counts
+func (h *LivestreamHandler) ListCategories(c *fiber.Ctx) error {
+	categories, err := h.livestreamService.ListCategories(c.UserContext())
+	if err != nil {
+		return apperr.Internal("could not fetch categories")
+	}
+	return c.Status(fiber.StatusOK).JSON(categories)
+}
+
+// GetMessages returns a stream's chat history. By default it pages newest-first using
+// ?before=<cursor>&limit=N; ?since=<RFC 3339 time> instead returns the messages sent after
+// that time, oldest first, for clients catching up after a reconnect.
+func (h *LivestreamHandler) GetMessages(c *fiber.Ctx) error {
+	streamID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid stream ID")
+	}
+
+	if since := c.Query("since"); since != "" {
+		afterTime, err := time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			return apperr.Validation("Invalid since time")
+		}
+		messages, err := h.livestreamService.GetMessagesSince(c.UserContext(), streamID, afterTime)
+		if err != nil {
+			return apperr.Internal("could not fetch messages")
+		}
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"messages": messages})
+	}
+
+	var beforeID primitive.ObjectID
+	if before := c.Query("before"); before != "" {
+		beforeID, err = primitive.ObjectIDFromHex(before)
+		if err != nil {
+			return apperr.Validation("Invalid cursor")
+		}
+	}
+	limit, _ := strconv.Atoi(c.Query("limit", strconv.Itoa(DefaultChatPageSize)))
+
+	page, err := h.livestreamService.GetMessagesPaginated(c.UserContext(), streamID, beforeID, limit)
+	if errors.Is(err, ErrInvalidChatCursor) {
+		return apperr.Validation("Invalid cursor")
+	}
+	if err != nil {
+		return apperr.Internal("could not fetch messages")
+	}
+	return c.Status(fiber.StatusOK).JSON(page)
+}
+
+// ExportMessages downloads a stream's whole chat history for its owner, oldest first, as
+// ?format=json (the default) or ?format=csv. The export is streamed as it is read.
+func (h *LivestreamHandler) ExportMessages(c *fiber.Ctx) error {
+	userIDStr, ok := c.Locals("user_id").(string)
+	if !ok {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		return apperr.Unauthorized("Invalid user ID")
+	}
+
+	streamID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid stream ID")
+	}
+
+	export, err := h.livestreamService.ExportMessages(c.UserContext(), streamID, userID, c.Query("format"))
+	switch {
+	case errors.Is(err, ErrInvalidExportFormat):
+		return apperr.Validation(err.Error())
+	case errors.Is(err, ErrStreamNotFound):
+		return apperr.NotFound("Stream not found")
+	case errors.Is(err, ErrNotStreamOwner):
+		return apperr.Forbidden("Only the stream owner can export its chat")
+	case err != nil:
+		return apperr.Internal("could not export chat")
+	}
+
+	c.Attachment(export.Filename())
+	c.Set(fiber.HeaderContentType, export.ContentType())
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// The status is already sent, so a failure can only cut the export short
+		if err := export.Write(context.Background(), w); err != nil {
+			log.Printf("Chat export of stream %s failed: %v", streamID.Hex(), err)
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+// GetMessagesByUser returns every message a user sent in a stream's chat, oldest first,
+// for the stream's owner or an admin
+func (h *LivestreamHandler) GetMessagesByUser(c *fiber.Ctx) error {
+	moderatorID, streamID, userID, err := chatModerationParams(c)
+	if err != nil {
+		return err
+	}
+
+	messages, err := h.livestreamService.GetMessagesByUser(c.UserContext(), streamID, userID, moderatorID)
+	switch {
+	case errors.Is(err, ErrStreamNotFound):
+		return apperr.NotFound("Stream not found")
+	case errors.Is(err, ErrNotStreamOwner):
+		return apperr.Forbidden("Only the stream owner or an admin can review its chat")
+	case err != nil:
+		return apperr.Internal("could not fetch messages")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"messages": messages})
+}
+
+// DeleteMessagesByUser removes every message of a user from a stream's chat, for the
+// stream's owner or an admin
+func (h *LivestreamHandler) DeleteMessagesByUser(c *fiber.Ctx) error {
+	moderatorID, streamID, userID, err := chatModerationParams(c)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := h.livestreamService.BulkDeleteUserMessages(c.UserContext(), streamID, userID, moderatorID)
+	switch {
+	case errors.Is(err, ErrStreamNotFound):
+		return apperr.NotFound("Stream not found")
+	case errors.Is(err, ErrNotStreamOwner):
+		return apperr.Forbidden("Only the stream owner or an admin can moderate its chat")
+	case err != nil:
+		return apperr.Internal("could not delete messages")
+	}
+	h.audit.TryRecord(c.UserContext(), audit.RequestEntry(c, audit.ActionChatPurge, audit.TargetUser, userID.Hex()))
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"deleted": deleted})
+}
+
+// chatModerationParams reads the caller and the stream and user IDs of the routes
+// moderating one user's messages
+func chatModerationParams(c *fiber.Ctx) (moderatorID, streamID, userID primitive.ObjectID, err error) {
+	userIDStr, ok := c.Locals("user_id").(string)
+	if !ok {
+		return moderatorID, streamID, userID, apperr.Unauthorized("Unauthorized")
+	}
+	if moderatorID, err = primitive.ObjectIDFromHex(userIDStr); err != nil {
+		return moderatorID, streamID, userID, apperr.Unauthorized("Invalid user ID")
+	}
+	if streamID, err = primitive.ObjectIDFromHex(c.Params("id")); err != nil {
+		return moderatorID, streamID, userID, apperr.Validation("Invalid stream ID")
+	}
+	if userID, err = primitive.ObjectIDFromHex(c.Params("userID")); err != nil {
+		return moderatorID, streamID, userID, apperr.Validation("Invalid user ID")
+	}
+	return moderatorID, streamID, userID, nil
+}
+
+// GetStreamRecording returns the video recorded from a stream
+func (h *LivestreamHandler) GetStreamRecording(c *fiber.Ctx) error {
+	streamID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid stream ID")
+	}
+
+	// Private recordings are only shown to their owner
+	requesterID := primitive.NilObjectID
+	if userIDStr, ok := c.Locals("user_id").(string); ok {
+		requesterID, _ = primitive.ObjectIDFromHex(userIDStr)
+	}
+
+	vod, err := h.livestreamService.GetStreamRecording(c.UserContext(), streamID, requesterID)
+	if errors.Is(err, video.ErrNotFound) {
+		return apperr.NotFound("Recording not found")
+	}
+	if err != nil {
+		return apperr.Internal("could not fetch recording")
+	}
+	return c.Status(fiber.StatusOK).JSON(vod)
+}
+
+// GetStreamPreview serves the latest preview frame of a stream
+func (h *LivestreamHandler) GetStreamPreview(c *fiber.Ctx) error {
+	streamID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid stream ID")
+	}
+
+	preview, err := h.livestreamService.ReadStreamPreview(c.UserContext(), streamID)
+	switch {
+	case errors.Is(err, ErrStreamNotFound):
+		return apperr.NotFound("Stream not found")
+	case errors.Is(err, ErrNoPreview):
+		return apperr.NotFound("Preview not available")
+	case err != nil:
+		return apperr.Internal("could not fetch preview")
+	}
+
+	// The frame is replaced every few seconds while the stream is live
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Content-Type", "image/jpeg")
+	return c.Send(preview)
+}
+
+// GetStreamAnalytics returns a stream's analytics to its owner
+func (h *LivestreamHandler) GetStreamAnalytics(c *fiber.Ctx) error {
+	userIDStr, ok := c.Locals("user_id").(string)
+	if !ok {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		return apperr.Unauthorized("Invalid user ID")
+	}
+
+	streamID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid stream ID")
+	}
+
+	analytics, err := h.livestreamService.GetStreamAnalytics(c.UserContext(), streamID, userID)
+	switch {
+	case errors.Is(err, ErrStreamNotFound):
+		return apperr.NotFound("Stream not found")
+	case errors.Is(err, ErrNotStreamOwner):
+		return apperr.Forbidden("Only the stream owner can view its analytics")
+	case err != nil:
+		return apperr.Internal("could not fetch analytics")
+	}
+	return c.Status(fiber.StatusOK).JSON(analytics)
+}
+
+// GetViewerTimeline returns the viewer count of one of the caller's streams over time,
+// in buckets of ?bucket= seconds
+func (h *LivestreamHandler) GetViewerTimeline(c *fiber.Ctx) error {
+	userIDStr, ok := c.Locals("user_id").(string)
+	if !ok {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		return apperr.Unauthorized("Invalid user ID")
+	}
+
+	streamID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid stream ID")
+	}
+
+	bucket, err := strconv.Atoi(c.Query("bucket", strconv.Itoa(DefaultTimelineBucket)))
+	if err != nil {
+		return apperr.Validation("Invalid bucket")
+	}
+
+	timeline, err := h.livestreamService.GetViewerTimeline(c.UserContext(), streamID, userID, bucket)
+	switch {
+	case errors.Is(err, ErrInvalidTimelineBucket):
+		return apperr.Validation(err.Error())
+	case errors.Is(err, ErrStreamNotFound):
+		return apperr.NotFound("Stream not found")
+	case errors.Is(err, ErrNotStreamOwner):
+		return apperr.Forbidden("Only the stream owner can view its timeline")
+	case err != nil:
+		return apperr.Internal("could not fetch timeline")
+	}
+	return c.Status(fiber.StatusOK).JSON(timeline)
+}
+
+// DeleteStream deletes one of the caller's streams once it has been stopped
+func (h *LivestreamHandler) DeleteStream(c *fiber.Ctx) error {
+	userIDStr, ok := c.Locals("user_id").(string)
+	if !ok {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		return apperr.Unauthorized("Invalid user ID")
+	}
+
+	streamID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid stream ID")
+	}
+
+	err = h.livestreamService.DeleteStream(c.UserContext(), streamID, userID)
+	switch {
+	case errors.Is(err, ErrStreamNotFound):
+		return apperr.NotFound("Stream not found")
+	case errors.Is(err, ErrNotStreamOwner):
+		return apperr.Forbidden("Only the stream owner can delete it")
+	case errors.Is(err, ErrStreamLive):
+		return apperr.Conflict("Stop the stream before deleting it")
+	case err != nil:
+		return apperr.Internal("could not delete stream")
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RotateStreamKey replaces the stream key of one of the caller's streams
+func (h *LivestreamHandler) RotateStreamKey(c *fiber.Ctx) error {
+	userIDStr, ok := c.Locals("user_id").(string)
+	if !ok {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		return apperr.Unauthorized("Invalid user ID")
+	}
+
+	streamID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid stream ID")
+	}
+
+	stream, err := h.livestreamService.RotateStreamKey(c.UserContext(), streamID, userID)
+	switch {
+	case errors.Is(err, ErrStreamNotFound):
+		return apperr.NotFound("Stream not found")
+	case errors.Is(err, ErrNotStreamOwner):
+		return apperr.Forbidden("Only the stream owner can rotate its key")
+	case err != nil:
+		return apperr.Internal("could not rotate stream key")
+	}
+	h.audit.TryRecord(c.UserContext(), audit.RequestEntry(c, audit.ActionStreamKeyRotate, audit.TargetStream, streamID.Hex()))
+	return c.Status(fiber.StatusOK).JSON(stream)
+}
+
+// WatchStream answers a WebRTC offer to watch a live stream with low latency. The answer
+// holds every ICE candidate, so no further signalling is needed; the viewer is counted
+// while the connection is up.
+func (h *LivestreamHandler) WatchStream(c *fiber.Ctx) error {
+	if h.webRTC == nil {
+		return apperr.Internal("WebRTC playback is unavailable")
+	}
+
+	streamID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid stream ID")
+	}
+
+	var offer webrtc.SessionDescription
+	if err := c.BodyParser(&offer); err != nil || offer.Type != webrtc.SDPTypeOffer || offer.SDP == "" {
+		return apperr.Validation("Request body must be an SDP offer")
+	}
+
+	stream, err := h.livestreamService.GetStreamStatus(streamID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return apperr.NotFound("Stream not found")
+	}
+	if err != nil {
+		return apperr.Internal("could not fetch stream")
+	}
+
+	_, answer, err := h.webRTC.Watch(c.UserContext(), offer, stream.StreamKey)
+	switch {
+	case errors.Is(err, ErrStreamNotPublishing):
+		return apperr.Conflict("Stream is not live")
+	case errors.Is(err, ErrInvalidOffer):
+		return apperr.Validation(err.Error())
+	case err != nil:
+		return apperr.Internal("could not answer offer")
 	}
-	return c.Status(fiber.StatusOK).JSON(streams)
+	return c.Status(fiber.StatusOK).JSON(answer)
 }
 
 // HandleWebSocket is the handler for upgrading connections to WebSocket.
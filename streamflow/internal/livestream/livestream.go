@@ -12,6 +12,8 @@ const (
 	StreamStatusOffline StreamStatus = "OFFLINE"
 	StreamStatusLive    StreamStatus = "LIVE"
 	StreamStatusEnded   StreamStatus = "ENDED"
+	// StreamStatusScheduled streams are announced ahead of time and go live on first publish
+	StreamStatusScheduled StreamStatus = "SCHEDULED"
 )
 
 type Livestream struct {
@@ -24,15 +26,93 @@ type Livestream struct {
 	ViewerCount        int                `bson:"viewer_count"`
 	PeakViewerCount    int                `bson:"peak_viewer_count"`
 	AverageViewerCount int                `bson:"average_viewer_count"`
+	Tags               []string           `bson:"tags"`
+	Category           string             `bson:"category,omitempty"`
+	ScheduledFor       *time.Time         `bson:"scheduled_for,omitempty"`
 	StartedAt          *time.Time         `bson:"started_at,omitempty"`
 	EndedAt            *time.Time         `bson:"ended_at,omitempty"`
 	CreatedAt          time.Time          `bson:"created_at"`
 	UpdatedAt          time.Time          `bson:"updated_at"`
+	// StreamThumbnailPath is the latest preview frame, refreshed while the stream is published
+	StreamThumbnailPath string `bson:"stream_thumbnail_path,omitempty"`
+	// Featured streams are picked by editors and listed by GetFeaturedStreams while live
+	Featured   bool       `bson:"featured,omitempty"`
+	FeaturedAt *time.Time `bson:"featured_at,omitempty"`
+}
+
+// PublicStream is a stream as shown to viewers. It leaves out the StreamKey, which lets
+// anyone holding it publish to the stream.
+type PublicStream struct {
+	ID                 primitive.ObjectID
+	UserID             primitive.ObjectID
+	Title              string
+	Description        string
+	Status             StreamStatus
+	ViewerCount        int
+	PeakViewerCount    int
+	AverageViewerCount int
+	Tags               []string
+	Category           string
+	ScheduledFor       *time.Time
+	StartedAt          *time.Time
+	EndedAt            *time.Time
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	Featured           bool
+	FeaturedAt         *time.Time
+}
+
+// Public returns the stream without its stream key
+func (l *Livestream) Public() PublicStream {
+	return PublicStream{
+		ID:                 l.ID,
+		UserID:             l.UserID,
+		Title:              l.Title,
+		Description:        l.Description,
+		Status:             l.Status,
+		ViewerCount:        l.ViewerCount,
+		PeakViewerCount:    l.PeakViewerCount,
+		AverageViewerCount: l.AverageViewerCount,
+		Tags:               l.Tags,
+		Category:           l.Category,
+		ScheduledFor:       l.ScheduledFor,
+		StartedAt:          l.StartedAt,
+		EndedAt:            l.EndedAt,
+		CreatedAt:          l.CreatedAt,
+		UpdatedAt:          l.UpdatedAt,
+		Featured:           l.Featured,
+		FeaturedAt:         l.FeaturedAt,
+	}
+}
+
+// PublicStreams returns the public view of each stream
+func PublicStreams(streams []*Livestream) []PublicStream {
+	if streams == nil {
+		return nil
+	}
+	public := make([]PublicStream, len(streams))
+	for i, stream := range streams {
+		public[i] = stream.Public()
+	}
+	return public
 }
 
 type StartStreamRequest struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	Category    string   `json:"category"`
+	Resume      bool     `json:"resume"` // Return the user's live stream, if any, rather than start another
+}
+
+// ScheduleStreamRequest announces a stream that will start at StartAt
+type ScheduleStreamRequest struct {
+	StartStreamRequest
+	StartAt time.Time `json:"start_at"`
+}
+
+type SetStreamTagsRequest struct {
+	Tags []string `json:"tags"`
 }
 
 type ChatCollection struct {
@@ -52,11 +132,21 @@ type Recording struct {
 	UpdatedAt time.Time          `bson:"updated_at"`
 }
 
+// StreamAnalytics summarizes a stream for its owner
 type StreamAnalytics struct {
-	StreamID       primitive.ObjectID `bson:"stream_id"`
-	ViewerCount    int                `bson:"viewer_count"`
-	ChatCount      int                `bson:"chat_count"`
-	Duration       time.Duration      `bson:"duration"`
-	PeakViewers    int                `bson:"peak_viewers"`
-	AverageViewers int                `bson:"average_viewers"`
+	StreamID        primitive.ObjectID `json:"stream_id"`
+	ViewerCount     int                `json:"viewer_count"` // Last known viewer count
+	PeakViewers     int                `json:"peak_viewers"`
+	AverageViewers  float64            `json:"average_viewers"`
+	UniqueChatters  int                `json:"unique_chatters"`
+	MessageCount    int                `json:"message_count"`
+	DurationSeconds float64            `json:"duration_seconds"` // So far, for a live stream
+}
+
+// ViewerSample is the viewer count of a stream at one point in time
+type ViewerSample struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	StreamID    primitive.ObjectID `bson:"stream_id"`
+	ViewerCount int64              `bson:"viewer_count"`
+	Ts          time.Time          `bson:"ts"`
 }
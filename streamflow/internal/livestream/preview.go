@@ -0,0 +1,162 @@
+package livestream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// previewCaptureTimeout bounds the ffmpeg run decoding a single preview frame
+const previewCaptureTimeout = 10 * time.Second
+
+var (
+	// ErrNoPreview is returned when no preview frame has been captured for a stream
+	ErrNoPreview = errors.New("stream has no preview")
+	// ErrNoKeyframe is returned when a stream hasn't sent a keyframe to capture yet
+	ErrNoKeyframe = errors.New("no keyframe received")
+)
+
+// liveFrame is the latest keyframe of a stream being published
+type liveFrame struct {
+	streamID primitive.ObjectID
+	data     []byte // Annex-B access unit, starting with the SPS and PPS
+}
+
+// setKeyframe keeps data as the latest keyframe of the stream published with streamKey
+func (r *RecorderService) setKeyframe(streamKey string, streamID primitive.ObjectID, data []byte) {
+	r.framesMu.Lock()
+	defer r.framesMu.Unlock()
+	r.frames[streamKey] = &liveFrame{streamID: streamID, data: append([]byte(nil), data...)}
+}
+
+// forgetKeyframe drops the keyframe kept for a stream that stopped publishing
+func (r *RecorderService) forgetKeyframe(streamKey string) {
+	r.framesMu.Lock()
+	defer r.framesMu.Unlock()
+	delete(r.frames, streamKey)
+}
+
+// CaptureStreamFrame decodes the latest keyframe of the stream being published with
+// streamKey into a JPEG and returns the path of the stream's preview file. The file is
+// replaced atomically, so readers never see a partly written image. ErrNoKeyframe is
+// returned until the encoder has sent a keyframe.
+func (r *RecorderService) CaptureStreamFrame(streamKey string) (string, error) {
+	r.framesMu.RLock()
+	frame, ok := r.frames[streamKey]
+	r.framesMu.RUnlock()
+	if !ok {
+		return "", ErrNoKeyframe
+	}
+
+	path := r.previewPath(frame.streamID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create preview directory: %w", err)
+	}
+	tmpPath := path + ".tmp"
+
+	ctx, cancel := context.WithTimeout(context.Background(), previewCaptureTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-hide_banner", "-loglevel", "error",
+		"-f", "h264", "-i", "pipe:0",
+		"-frames:v", "1",
+		"-q:v", "3",
+		"-f", "image2", "-y", tmpPath,
+	)
+	cmd.Stdin = bytes.NewReader(frame.data)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to capture frame: %w: %s", err, bytes.TrimSpace(output))
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to store preview: %w", err)
+	}
+	return path, nil
+}
+
+// previewPath is where the preview frame of a stream is kept. It is named after the
+// stream ID rather than the stream key, which must not end up in file names.
+func (r *RecorderService) previewPath(streamID primitive.ObjectID) string {
+	return filepath.Join(r.storagePath, "previews", fmt.Sprintf("stream_%s.jpg", streamID.Hex()))
+}
+
+// removePreview deletes the preview frame of a stream, which may not exist
+func (r *RecorderService) removePreview(streamID primitive.ObjectID) {
+	path := r.previewPath(streamID)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove stream preview %s: %v", path, err)
+	}
+}
+
+// refreshStreamPreview captures a new preview frame of a live stream and records its path
+// on the stream
+func (s *LivestreamService) refreshStreamPreview(ctx context.Context, streamKey string, streamID primitive.ObjectID) {
+	path, err := s.recorderService.CaptureStreamFrame(streamKey)
+	if errors.Is(err, ErrNoKeyframe) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to capture preview of stream %s: %v", streamID.Hex(), err)
+		return
+	}
+
+	// The path only changes on the first capture, later ones replace the file
+	_, err = s.livestreamCollection.UpdateOne(ctx,
+		bson.M{"_id": streamID, "stream_thumbnail_path": bson.M{"$ne": path}},
+		bson.M{"$set": bson.M{"stream_thumbnail_path": path}},
+	)
+	if err != nil {
+		log.Printf("Failed to save preview path of stream %s: %v", streamID.Hex(), err)
+	}
+}
+
+// ReadStreamPreview returns the latest preview frame of a stream as a JPEG
+func (s *LivestreamService) ReadStreamPreview(ctx context.Context, streamID primitive.ObjectID) ([]byte, error) {
+	var stream Livestream
+	opts := options.FindOne().SetProjection(bson.M{"stream_thumbnail_path": 1})
+	if err := s.livestreamCollection.FindOne(ctx, bson.M{"_id": streamID}, opts).Decode(&stream); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrStreamNotFound
+		}
+		return nil, fmt.Errorf("failed to find stream: %w", err)
+	}
+	if stream.StreamThumbnailPath == "" {
+		return nil, ErrNoPreview
+	}
+
+	data, err := os.ReadFile(stream.StreamThumbnailPath)
+	if os.IsNotExist(err) {
+		return nil, ErrNoPreview
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stream preview: %w", err)
+	}
+	return data, nil
+}
+
+// isKeyframe reports whether an Annex-B access unit contains an IDR slice
+func isKeyframe(data []byte) bool {
+	for i := 0; i+3 < len(data); i++ {
+		if data[i] == 0 && data[i+1] == 0 && data[i+2] == 1 {
+			if data[i+3]&0x1f == 5 {
+				return true
+			}
+			i += 2
+		}
+	}
+	return false
+}
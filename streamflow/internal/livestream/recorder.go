@@ -1,10 +1,17 @@
 package livestream
 
 import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"sync"
 	"time"
 
+	flvtag "github.com/yutopp/go-flv/tag"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -15,6 +22,8 @@ type RecorderService struct {
 	recordings           map[string]*RecorderSession
 	recordingsCollection *mongo.Collection
 	mu                   sync.RWMutex
+	frames               map[string]*liveFrame // Latest keyframe by stream key, for previews
+	framesMu             sync.RWMutex
 }
 
 type RecorderSession struct {
@@ -23,4 +32,118 @@ type RecorderSession struct {
 	StartTime   time.Time          `bson:"start_time"`
 	IsRecording bool               `bson:"is_recording"`
 	Process     *exec.Cmd          `bson:"-"`
+	ingest      *ingestRecording   // Set when the recording is fed by the ingest
+}
+
+// flvHeader starts an FLV stream with audio and video, followed by the size of the
+// non-existent tag before the first one
+var flvHeader = []byte{'F', 'L', 'V', 0x01, 0x05, 0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x00}
+
+// ingestRecording is the input of an ffmpeg process recording a published stream. The
+// ingest writes the stream's FLV tags to it as they arrive.
+type ingestRecording struct {
+	mu    sync.Mutex
+	input io.WriteCloser // nil once closed
+}
+
+// writeTag writes an FLV tag with the given payload. A failed write closes the input, so
+// a recording whose ffmpeg process died reports only the first failure.
+func (i *ingestRecording) writeTag(tagType flvtag.TagType, timestamp uint32, data []byte) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.input == nil {
+		return nil
+	}
+
+	tag := make([]byte, 11, 11+len(data)+4)
+	tag[0] = byte(tagType)
+	tag[1], tag[2], tag[3] = byte(len(data)>>16), byte(len(data)>>8), byte(len(data))
+	tag[4], tag[5], tag[6] = byte(timestamp>>16), byte(timestamp>>8), byte(timestamp)
+	tag[7] = byte(timestamp >> 24)
+	tag = append(tag, data...)
+	tag = binary.BigEndian.AppendUint32(tag, uint32(11+len(data)))
+
+	if _, err := i.input.Write(tag); err != nil {
+		i.input.Close()
+		i.input = nil
+		return err
+	}
+	return nil
+}
+
+// close ends the input, which makes ffmpeg finish the file
+func (i *ingestRecording) close() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.input != nil {
+		i.input.Close()
+		i.input = nil
+	}
+}
+
+// startIngestRecording starts recording a published stream to an MP4 file. ffmpeg remuxes
+// the FLV tags passed to writeRecordingTag without transcoding them. A stream that is
+// already being recorded, e.g. one published again before its stop, keeps its recording.
+func (r *RecorderService) startIngestRecording(streamID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.recordings[streamID.Hex()]; exists {
+		return nil
+	}
+	if err := os.MkdirAll(r.storagePath, 0755); err != nil {
+		return fmt.Errorf("failed to create recording directory: %w", err)
+	}
+
+	outputPath := filepath.Join(r.storagePath,
+		fmt.Sprintf("stream_%s_%s.mp4", streamID.Hex(), time.Now().Format("20060102_150405")))
+
+	cmd := exec.Command("ffmpeg",
+		"-f", "flv",
+		"-i", "pipe:0",
+		"-c", "copy",
+		"-f", "mp4",
+		"-movflags", "frag_keyframe+empty_moov",
+		"-y", outputPath,
+	)
+	cmd.Stderr = os.Stderr
+	input, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open ffmpeg input: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	if _, err := input.Write(flvHeader); err != nil {
+		input.Close()
+		cmd.Wait()
+		return fmt.Errorf("failed to start recording: %w", err)
+	}
+
+	r.recordings[streamID.Hex()] = &RecorderSession{
+		StreamID:    streamID,
+		OutputPath:  outputPath,
+		StartTime:   time.Now(),
+		IsRecording: true,
+		Process:     cmd,
+		ingest:      &ingestRecording{input: input},
+	}
+	return nil
+}
+
+// writeRecordingTag adds an FLV tag of a published stream to its recording, if the
+// stream is being recorded from the ingest
+func (r *RecorderService) writeRecordingTag(streamID primitive.ObjectID, tagType flvtag.TagType, timestamp uint32, data []byte) {
+	r.mu.RLock()
+	session, exists := r.recordings[streamID.Hex()]
+	r.mu.RUnlock()
+	if !exists || session.ingest == nil {
+		return
+	}
+
+	if err := session.ingest.writeTag(tagType, timestamp, data); err != nil {
+		slog.Error("failed to write to recording, the rest of the stream isn't recorded", "stream_id", streamID.Hex(), "error", err)
+	}
 }
@@ -0,0 +1,95 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errInvalidAVC = errors.New("invalid AVC data")
+
+// annexBStartCode prefixes every NAL unit in an Annex-B byte stream
+var annexBStartCode = []byte{0x00, 0x00, 0x00, 0x01}
+
+// avcConfig holds the parts of an AVCDecoderConfigurationRecord needed to convert the
+// length-prefixed NAL units RTMP carries into the Annex-B format WebRTC expects
+type avcConfig struct {
+	lengthSize int // Size in bytes of each NAL unit length prefix
+	sps        [][]byte
+	pps        [][]byte
+}
+
+// parseAVCConfig parses an AVCDecoderConfigurationRecord (ISO/IEC 14496-15 5.2.4.1)
+func parseAVCConfig(data []byte) (avcConfig, error) {
+	if len(data) < 6 {
+		return avcConfig{}, errInvalidAVC
+	}
+
+	config := avcConfig{lengthSize: int(data[4]&0x03) + 1}
+
+	pos := 5
+	var err error
+	config.sps, pos, err = readParameterSets(data, pos, int(data[pos]&0x1f))
+	if err != nil {
+		return avcConfig{}, err
+	}
+	if pos >= len(data) {
+		return avcConfig{}, errInvalidAVC
+	}
+	config.pps, _, err = readParameterSets(data, pos, int(data[pos]))
+	if err != nil {
+		return avcConfig{}, err
+	}
+
+	return config, nil
+}
+
+// readParameterSets reads count 16-bit length-prefixed sets following the count byte at pos
+func readParameterSets(data []byte, pos, count int) ([][]byte, int, error) {
+	pos++ // Skip the count byte
+	sets := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		if pos+2 > len(data) {
+			return nil, 0, errInvalidAVC
+		}
+		size := int(binary.BigEndian.Uint16(data[pos:]))
+		pos += 2
+		if pos+size > len(data) {
+			return nil, 0, errInvalidAVC
+		}
+		sets = append(sets, data[pos:pos+size])
+		pos += size
+	}
+	return sets, pos, nil
+}
+
+// annexB converts length-prefixed NAL units to an Annex-B access unit. Keyframes are
+// preceded by the SPS and PPS so viewers can start decoding from any keyframe.
+func (c avcConfig) annexB(data []byte, keyframe bool) ([]byte, error) {
+	var out []byte
+	if keyframe {
+		for _, set := range c.sps {
+			out = append(append(out, annexBStartCode...), set...)
+		}
+		for _, set := range c.pps {
+			out = append(append(out, annexBStartCode...), set...)
+		}
+	}
+
+	for pos := 0; pos < len(data); {
+		if pos+c.lengthSize > len(data) {
+			return nil, errInvalidAVC
+		}
+		size := 0
+		for _, b := range data[pos : pos+c.lengthSize] {
+			size = size<<8 | int(b)
+		}
+		pos += c.lengthSize
+		if size > len(data)-pos {
+			return nil, errInvalidAVC
+		}
+		out = append(append(out, annexBStartCode...), data[pos:pos+size]...)
+		pos += size
+	}
+
+	return out, nil
+}
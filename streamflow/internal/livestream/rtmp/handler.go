@@ -0,0 +1,245 @@
+package rtmp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"streamflow/internal/livestream"
+
+	flvtag "github.com/yutopp/go-flv/tag"
+	gortmp "github.com/yutopp/go-rtmp"
+	rtmpmsg "github.com/yutopp/go-rtmp/message"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	errInvalidStreamKey = errors.New("invalid stream key")
+	errStreamStopped    = errors.New("stream was stopped")
+)
+
+// defaultFrameDuration is used for the first video frame, before a timestamp delta is known
+const defaultFrameDuration = 33 * time.Millisecond
+
+// keyCheckInterval is how often a running publish checks that its stream key is still
+// valid, so rotating a leaked key disconnects whoever is publishing with it
+const keyCheckInterval = 15 * time.Second
+
+// publishHandler handles a single RTMP connection. Only publishing is supported.
+type publishHandler struct {
+	gortmp.DefaultHandler
+	server *Server
+
+	streamKey    string
+	streamID     primitive.ObjectID
+	published    bool
+	keyCheckedAt time.Time
+
+	avc           avcConfig
+	lastVideoTime uint32
+	haveVideoTime bool
+	warnedAudio   bool
+}
+
+func newPublishHandler(s *Server) *publishHandler {
+	return &publishHandler{server: s}
+}
+
+// OnPublish authenticates the publishing name against the stream keys of live streams
+func (h *publishHandler) OnPublish(_ *gortmp.StreamContext, timestamp uint32, cmd *rtmpmsg.NetStreamPublish) error {
+	streamKey := parseStreamKey(cmd.PublishingName)
+	if streamKey == "" {
+		return fmt.Errorf("publishing name is required")
+	}
+
+	stream, err := h.server.livestreamService.GetStreamByKey(streamKey)
+	if err != nil {
+		log.Printf("RTMP publish rejected: unknown stream key")
+		return errInvalidStreamKey
+	}
+	// Scheduled streams are promoted to live once publishing starts
+	if stream.Status != livestream.StreamStatusLive && stream.Status != livestream.StreamStatusScheduled {
+		log.Printf("RTMP publish rejected: stream %s is %s", stream.ID.Hex(), stream.Status)
+		return fmt.Errorf("stream is not live")
+	}
+	if video, _ := h.server.streamManager.GetStreamTracks(streamKey); video != nil {
+		log.Printf("RTMP publish rejected: stream %s is already being published", stream.ID.Hex())
+		return fmt.Errorf("stream is already being published")
+	}
+
+	h.streamKey = streamKey
+	h.streamID = stream.ID
+	h.published = true
+	h.keyCheckedAt = time.Now()
+	h.server.streamManager.HandleStreamStart(streamKey, stream.ID)
+	log.Printf("RTMP publish started for stream %s", stream.ID.Hex())
+
+	return nil
+}
+
+// OnSetDataFrame checks the codecs the encoder announces in onMetaData
+func (h *publishHandler) OnSetDataFrame(timestamp uint32, data *rtmpmsg.NetStreamSetDataFrame) error {
+	if h.server.codecValidator == nil {
+		return nil
+	}
+
+	var script flvtag.ScriptData
+	if err := flvtag.DecodeScriptData(bytes.NewReader(data.Payload), &script); err != nil {
+		return fmt.Errorf("failed to decode script data: %w", err)
+	}
+
+	metadata, ok := script.Objects["onMetaData"]
+	if !ok {
+		return nil
+	}
+
+	// Audio accepted for transcoding is dropped by OnAudio like any other audio
+	if _, err := h.server.codecValidator.Validate(livestream.IngestCodecsFromMetadata(metadata)); err != nil {
+		log.Printf("RTMP ingest rejected: %v", err)
+		return fmt.Errorf("ingest rejected: %w", err)
+	}
+
+	return nil
+}
+
+// OnVideo records the video as received, and converts H.264 packets to Annex-B access
+// units and writes them to the video track
+func (h *publishHandler) OnVideo(timestamp uint32, payload io.Reader) error {
+	if !h.published {
+		return nil
+	}
+	if err := h.checkStreamKey(time.Now()); err != nil {
+		return err
+	}
+
+	raw, err := io.ReadAll(payload)
+	if err != nil {
+		return fmt.Errorf("failed to read video data: %w", err)
+	}
+	h.server.streamManager.WriteRecordingTag(h.streamKey, flvtag.TagTypeVideo, timestamp, raw)
+
+	var video flvtag.VideoData
+	if err := flvtag.DecodeVideoData(bytes.NewReader(raw), &video); err != nil {
+		return fmt.Errorf("failed to decode video data: %w", err)
+	}
+	if video.CodecID != flvtag.CodecIDAVC {
+		return fmt.Errorf("unsupported video codec id %d", video.CodecID)
+	}
+
+	data, err := io.ReadAll(video.Data)
+	if err != nil {
+		return fmt.Errorf("failed to read video data: %w", err)
+	}
+
+	switch video.AVCPacketType {
+	case flvtag.AVCPacketTypeSequenceHeader:
+		config, err := parseAVCConfig(data)
+		if err != nil {
+			return err
+		}
+		h.avc = config
+		return nil
+	case flvtag.AVCPacketTypeNALU:
+		// Wait for the sequence header before forwarding frames
+		if h.avc.lengthSize == 0 {
+			return nil
+		}
+		keyframe := video.FrameType == flvtag.FrameTypeKeyFrame
+		sample, err := h.avc.annexB(data, keyframe)
+		if err != nil {
+			return err
+		}
+		if len(sample) == 0 {
+			return nil
+		}
+		if err := h.server.streamManager.WriteVideoSample(h.streamKey, sample, h.frameDuration(timestamp)); err != nil {
+			log.Printf("RTMP: failed to write video sample for %s: %v", h.streamKey, err)
+		}
+	}
+
+	return nil
+}
+
+// OnAudio records the audio but doesn't forward it. RTMP encoders send AAC while the
+// WebRTC audio track carries Opus, so audio can't be forwarded without transcoding.
+func (h *publishHandler) OnAudio(timestamp uint32, payload io.Reader) error {
+	if !h.published {
+		return nil
+	}
+	if !h.warnedAudio {
+		log.Printf("RTMP: audio for %s is not forwarded to WebRTC viewers", h.streamKey)
+		h.warnedAudio = true
+	}
+
+	raw, err := io.ReadAll(payload)
+	if err != nil {
+		return fmt.Errorf("failed to read audio data: %w", err)
+	}
+	h.server.streamManager.WriteRecordingTag(h.streamKey, flvtag.TagTypeAudio, timestamp, raw)
+	return nil
+}
+
+// OnClose ends the stream if this connection was publishing it
+func (h *publishHandler) OnClose() {
+	if !h.published {
+		return
+	}
+	h.published = false
+	h.server.streamManager.HandleStreamEnd(h.streamKey)
+	log.Printf("RTMP publish ended for stream key %s", maskStreamKey(h.streamKey))
+}
+
+// checkStreamKey looks the stream key up again once keyCheckInterval has passed since the
+// last check. The publish is ended if the key was rotated, or its stream deleted or
+// stopped. A failed lookup doesn't end it, so a database hiccup doesn't cut streams off.
+func (h *publishHandler) checkStreamKey(now time.Time) error {
+	if now.Sub(h.keyCheckedAt) < keyCheckInterval {
+		return nil
+	}
+	h.keyCheckedAt = now
+
+	stream, err := h.server.livestreamService.GetStreamByKey(h.streamKey)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Printf("RTMP: failed to check stream key of stream %s: %v", h.streamID.Hex(), err)
+		return nil
+	}
+	if err != nil || stream.ID != h.streamID {
+		log.Printf("RTMP publish of stream %s ended: stream key is no longer valid", h.streamID.Hex())
+		return errInvalidStreamKey
+	}
+	if stream.Status == livestream.StreamStatusEnded {
+		log.Printf("RTMP publish of stream %s ended: stream was stopped", h.streamID.Hex())
+		return errStreamStopped
+	}
+	return nil
+}
+
+// frameDuration is the time since the previous video frame
+func (h *publishHandler) frameDuration(timestamp uint32) time.Duration {
+	duration := defaultFrameDuration
+	if h.haveVideoTime && timestamp > h.lastVideoTime {
+		duration = time.Duration(timestamp-h.lastVideoTime) * time.Millisecond
+	}
+	h.lastVideoTime = timestamp
+	h.haveVideoTime = true
+	return duration
+}
+
+// parseStreamKey strips any query string encoders append to the publishing name
+func parseStreamKey(publishingName string) string {
+	key, _, _ := strings.Cut(publishingName, "?")
+	return strings.TrimSpace(key)
+}
+
+// maskStreamKey hides all but the start of a stream key for logging
+func maskStreamKey(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return key[:4] + "****"
+}
@@ -0,0 +1,115 @@
+// Package rtmp accepts RTMP publishes from streaming software such as OBS and feeds
+// them into the livestream stream manager.
+package rtmp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"streamflow/internal/lifecycle"
+	"streamflow/internal/livestream"
+
+	gortmp "github.com/yutopp/go-rtmp"
+)
+
+// Server is the RTMP ingest endpoint. Publishers authenticate with their stream key as
+// the publishing name, e.g. rtmp://host:1935/live/<stream key>.
+type Server struct {
+	livestreamService *livestream.LivestreamService
+	streamManager     *livestream.StreamManager
+	codecValidator    *livestream.CodecValidator
+	srv               *gortmp.Server
+
+	mu    sync.Mutex
+	conns map[*trackedConn]struct{}
+	wg    sync.WaitGroup // Connections whose handler hasn't finished
+}
+
+// NewServer creates an RTMP ingest server. The codec validator may be nil to accept any codec.
+func NewServer(ls *livestream.LivestreamService, sm *livestream.StreamManager, codecValidator *livestream.CodecValidator) *Server {
+	s := &Server{
+		livestreamService: ls,
+		streamManager:     sm,
+		codecValidator:    codecValidator,
+		conns:             make(map[*trackedConn]struct{}),
+	}
+	s.srv = gortmp.NewServer(&gortmp.ServerConfig{
+		OnConnect: func(conn net.Conn) (io.ReadWriteCloser, *gortmp.ConnConfig) {
+			return s.track(conn), &gortmp.ConnConfig{
+				Handler: newPublishHandler(s),
+				ControlState: gortmp.StreamControlStateConfig{
+					DefaultBandwidthWindowSize: 6 * 1024 * 1024 / 8,
+				},
+			}
+		},
+	})
+	return s
+}
+
+// ListenAndServe accepts RTMP connections on addr until Close is called
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("RTMP ingest listening on %s", addr)
+
+	if err := s.srv.Serve(listener); err != nil && !errors.Is(err, gortmp.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// Close stops accepting connections
+func (s *Server) Close() error {
+	return s.srv.Close()
+}
+
+// Shutdown stops accepting connections and disconnects every publisher. It returns once
+// their streams have been ended, or when ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.Close(); err != nil {
+		log.Printf("Error closing RTMP listener: %v", err)
+	}
+
+	s.mu.Lock()
+	for c := range s.conns {
+		// The failed read makes go-rtmp close the connection, which runs OnClose
+		c.Conn.Close()
+	}
+	s.mu.Unlock()
+
+	return lifecycle.Wait(ctx, &s.wg)
+}
+
+func (s *Server) track(conn net.Conn) *trackedConn {
+	c := &trackedConn{Conn: conn, server: s}
+	s.mu.Lock()
+	s.conns[c] = struct{}{}
+	s.wg.Add(1)
+	s.mu.Unlock()
+	return c
+}
+
+// trackedConn removes itself from the server once go-rtmp closes it, which happens after
+// the connection's handler has run OnClose
+type trackedConn struct {
+	net.Conn
+	server *Server
+	once   sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.server.mu.Lock()
+		delete(c.server.conns, c)
+		c.server.mu.Unlock()
+		c.server.wg.Done()
+	})
+	return err
+}
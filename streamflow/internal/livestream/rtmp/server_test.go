@@ -0,0 +1,158 @@
+package rtmp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"streamflow/internal/config"
+	"streamflow/internal/livestream"
+
+	"github.com/yutopp/go-amf0"
+	flvtag "github.com/yutopp/go-flv/tag"
+	rtmpmsg "github.com/yutopp/go-rtmp/message"
+)
+
+// testAVCConfig is an AVCDecoderConfigurationRecord with 4-byte lengths, one SPS and one PPS
+var testAVCConfig = []byte{
+	0x01, 0x64, 0x00, 0x1f, 0xff, // version, profile, compatibility, level, length size
+	0xe1, 0x00, 0x03, 0x67, 0x64, 0x1f, // one SPS
+	0x01, 0x00, 0x02, 0x68, 0xee, // one PPS
+}
+
+func TestParseAVCConfig(t *testing.T) {
+	config, err := parseAVCConfig(testAVCConfig)
+	if err != nil {
+		t.Fatalf("parseAVCConfig() unexpected error = %v", err)
+	}
+	if config.lengthSize != 4 {
+		t.Errorf("lengthSize = %d, want 4", config.lengthSize)
+	}
+	if len(config.sps) != 1 || !bytes.Equal(config.sps[0], []byte{0x67, 0x64, 0x1f}) {
+		t.Errorf("sps = %x", config.sps)
+	}
+	if len(config.pps) != 1 || !bytes.Equal(config.pps[0], []byte{0x68, 0xee}) {
+		t.Errorf("pps = %x", config.pps)
+	}
+
+	for _, truncated := range [][]byte{nil, testAVCConfig[:5], testAVCConfig[:9], testAVCConfig[:12]} {
+		if _, err := parseAVCConfig(truncated); err == nil {
+			t.Errorf("parseAVCConfig(%x) should fail", truncated)
+		}
+	}
+}
+
+func TestAVCConfig_AnnexB(t *testing.T) {
+	config, err := parseAVCConfig(testAVCConfig)
+	if err != nil {
+		t.Fatalf("parseAVCConfig() unexpected error = %v", err)
+	}
+
+	// Two NAL units with 4-byte length prefixes
+	packet := []byte{0x00, 0x00, 0x00, 0x02, 0x41, 0x9a, 0x00, 0x00, 0x00, 0x01, 0x06}
+
+	t.Run("Inter frame", func(t *testing.T) {
+		got, err := config.annexB(packet, false)
+		if err != nil {
+			t.Fatalf("annexB() unexpected error = %v", err)
+		}
+		want := []byte{0, 0, 0, 1, 0x41, 0x9a, 0, 0, 0, 1, 0x06}
+		if !bytes.Equal(got, want) {
+			t.Errorf("annexB() = %x, want %x", got, want)
+		}
+	})
+
+	t.Run("Keyframe carries parameter sets", func(t *testing.T) {
+		got, err := config.annexB(packet, true)
+		if err != nil {
+			t.Fatalf("annexB() unexpected error = %v", err)
+		}
+		want := []byte{
+			0, 0, 0, 1, 0x67, 0x64, 0x1f,
+			0, 0, 0, 1, 0x68, 0xee,
+			0, 0, 0, 1, 0x41, 0x9a,
+			0, 0, 0, 1, 0x06,
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("annexB() = %x, want %x", got, want)
+		}
+	})
+
+	t.Run("Truncated NAL unit", func(t *testing.T) {
+		if _, err := config.annexB(packet[:5], false); err == nil {
+			t.Error("annexB() should fail on a truncated NAL unit")
+		}
+	})
+}
+
+func TestParseStreamKey(t *testing.T) {
+	tests := map[string]string{
+		"abc123":           "abc123",
+		"abc123?token=xyz": "abc123",
+		" abc123 ":         "abc123",
+		"":                 "",
+		"?only=query":      "",
+	}
+	for name, want := range tests {
+		if got := parseStreamKey(name); got != want {
+			t.Errorf("parseStreamKey(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestPublishHandler_FrameDuration(t *testing.T) {
+	h := &publishHandler{}
+	if got := h.frameDuration(1000); got != defaultFrameDuration {
+		t.Errorf("First frame duration = %v, want %v", got, defaultFrameDuration)
+	}
+	if got := h.frameDuration(1040); got != 40*time.Millisecond {
+		t.Errorf("Frame duration = %v, want 40ms", got)
+	}
+	// Timestamps that go backwards fall back to the default
+	if got := h.frameDuration(1000); got != defaultFrameDuration {
+		t.Errorf("Frame duration after rewind = %v, want %v", got, defaultFrameDuration)
+	}
+}
+
+func TestPublishHandler_OnSetDataFrame(t *testing.T) {
+	lenient := livestream.NewCodecValidator(config.LivestreamConfig{
+		AllowedVideoCodecs: []string{"h264"},
+		AllowedAudioCodecs: []string{"aac"},
+		CodecPolicy:        string(livestream.CodecPolicyLenient),
+	})
+	h := newPublishHandler(&Server{codecValidator: lenient})
+
+	// metadataFrame encodes an onMetaData script tag the way encoders send it
+	metadataFrame := func(videoCodecID, audioCodecID float64) *rtmpmsg.NetStreamSetDataFrame {
+		var payload bytes.Buffer
+		script := flvtag.ScriptData{Objects: map[string]amf0.ECMAArray{
+			"onMetaData": {"videocodecid": videoCodecID, "audiocodecid": audioCodecID},
+		}}
+		if err := flvtag.EncodeScriptData(&payload, &script); err != nil {
+			t.Fatalf("Failed to encode metadata: %v", err)
+		}
+		return &rtmpmsg.NetStreamSetDataFrame{Payload: payload.Bytes()}
+	}
+
+	tests := []struct {
+		name    string
+		frame   *rtmpmsg.NetStreamSetDataFrame
+		wantErr bool
+	}{
+		{"allowed codecs", metadataFrame(7, 10), false},
+		{"audio needing transcoding", metadataFrame(7, 2), false},
+		{"video outside the allow-list", metadataFrame(12, 10), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := h.OnSetDataFrame(0, tt.frame)
+			if tt.wantErr && !errors.Is(err, livestream.ErrUnsupportedCodec) {
+				t.Errorf("OnSetDataFrame() error = %v, want ErrUnsupportedCodec", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("OnSetDataFrame() unexpected error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package livestream
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// scheduledStreamPromoteInterval is how often scheduled streams are checked for a publish
+const scheduledStreamPromoteInterval = 10 * time.Second
+
+// ScheduledStreamPromoter flips scheduled streams to live once their streamer starts
+// publishing to them
+type ScheduledStreamPromoter struct {
+	livestreamService *LivestreamService
+	streamManager     *StreamManager
+}
+
+// NewScheduledStreamPromoter creates a promoter that watches the stream manager for publishes
+func NewScheduledStreamPromoter(ls *LivestreamService, sm *StreamManager) *ScheduledStreamPromoter {
+	return &ScheduledStreamPromoter{
+		livestreamService: ls,
+		streamManager:     sm,
+	}
+}
+
+// PromoteOnce promotes every scheduled stream that is currently being published
+func (p *ScheduledStreamPromoter) PromoteOnce(ctx context.Context) (int, error) {
+	return p.livestreamService.PromoteScheduledStreams(ctx, p.streamManager.PublishingStreamKeys())
+}
+
+// Start runs PromoteOnce periodically in the background until ctx is done
+func (p *ScheduledStreamPromoter) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(scheduledStreamPromoteInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			promoted, err := p.PromoteOnce(ctx)
+			if err != nil {
+				log.Printf("Scheduled stream promotion failed: %v", err)
+				continue
+			}
+			if promoted > 0 {
+				log.Printf("Promoted %d scheduled streams to live", promoted)
+			}
+		}
+	}()
+}
@@ -3,11 +3,26 @@ package livestream
 import (
 	"context"
 	"crypto/rand"
-	"encoding/hex"
+	"encoding/base32"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
+	"sort"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"streamflow/internal/cache"
+	"streamflow/internal/config"
+	"streamflow/internal/lifecycle"
+	"streamflow/internal/logger"
+	"streamflow/internal/tags"
+	"streamflow/internal/users"
+	"streamflow/internal/video"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -19,43 +34,503 @@ type LivestreamService struct {
 	livestreamCollection *mongo.Collection
 	chatCollection       *mongo.Collection
 	recorderService      *RecorderService
+	videoService         *video.VideoService
+	userService          *users.UserService
+	viewers              ViewerCounts
+	sampleCollection     *mongo.Collection      // Viewer counts over time, for analytics
+	slotCollection       *mongo.Collection      // Concurrent stream slots held by live streams
+	categories           []string               // Allowed stream categories, in display order
+	previewInterval      time.Duration          // How often preview frames are captured, 0 disables them
+	recordStreams        bool                   // Record published streams, publishing each recording as a video when its stream stops
+	maxConcurrentStreams int                    // Live streams a user may have at once, 0 for no limit
+	maxChatLength        int                    // Longest chat message, in characters
+	truncateChat         bool                   // Cut chat messages over maxChatLength short instead of rejecting them
+	results              *cache.Loader          // Caches popular stream listings; nil disables caching
+	newStreamKey         func() (string, error) // Replaced in tests to force key clashes
+	workers              context.Context        // Done when Shutdown stops the background workers
+	stopWorkers          context.CancelFunc
+	tasks                sync.WaitGroup // Background work Shutdown waits for
 }
 
-// NewLiveStreamService creates a new livestream service with database collections
-func NewLiveStreamService(db *mongo.Database) *LivestreamService {
-	return &LivestreamService{
+const (
+	// MaxStreamTags caps how many tags a stream can carry
+	MaxStreamTags = tags.MaxCount
+	// MaxStreamTagLength caps the length of a single tag
+	MaxStreamTagLength = tags.MaxLength
+	// MaxStreamTitleLength caps the length of a stream title, in characters
+	MaxStreamTitleLength = 100
+	// MaxStreamDescriptionLength caps the length of a stream description, in characters
+	MaxStreamDescriptionLength = 5000
+	// MaxPopularStreamsLimit caps how many popular streams can be requested at once
+	MaxPopularStreamsLimit = 50
+)
+
+var (
+	// ErrNoActiveRecording is returned when a stream is not being recorded
+	ErrNoActiveRecording = errors.New("no active recording")
+	// ErrScheduleInPast is returned when a stream is scheduled to start in the past
+	ErrScheduleInPast = errors.New("scheduled start time must be in the future")
+	// ErrStreamLive is returned when deleting a stream that hasn't been stopped
+	ErrStreamLive = errors.New("stream is live")
+	// ErrStreamLimitReached is returned when starting a stream would exceed the user's
+	// concurrent stream limit
+	ErrStreamLimitReached = errors.New("concurrent stream limit reached")
+	// ErrStreamTitleRequired is returned when a stream's title is empty or only whitespace
+	ErrStreamTitleRequired = errors.New("title is required")
+	// ErrStreamTitleTooLong is returned for a title over MaxStreamTitleLength
+	ErrStreamTitleTooLong = fmt.Errorf("title must be at most %d characters", MaxStreamTitleLength)
+	// ErrStreamDescriptionTooLong is returned for a description over MaxStreamDescriptionLength
+	ErrStreamDescriptionTooLong = fmt.Errorf("description must be at most %d characters", MaxStreamDescriptionLength)
+	// ErrInvalidUpdate is returned by UpdateStream for a field that can't be updated or an
+	// invalid value
+	ErrInvalidUpdate = errors.New("invalid stream update")
+)
+
+const (
+	// streamKeySize is the number of random bytes in a stream key (160 bits)
+	streamKeySize = 20
+	// maxStreamKeyAttempts bounds the retries after a stream key clashes with another stream's
+	maxStreamKeyAttempts = 3
+)
+
+var streamKeyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewLiveStreamService creates a new livestream service with database collections.
+// Finished recordings are published as videos through videoService, which may be nil
+// to discard them. userService provides follow data for the following feed.
+func NewLiveStreamService(db *mongo.Database, videoService *video.VideoService, userService *users.UserService, cfg config.LivestreamConfig) *LivestreamService {
+	service := &LivestreamService{
 		livestreamCollection: db.Collection("livestreams"),
 		chatCollection:       db.Collection("chat_messages"),
 		recorderService:      NewRecorderService("./storage/recordings", db),
+		videoService:         videoService,
+		userService:          userService,
+		viewers:              NewViewerTracker(db.Collection("livestreams"), db.Collection("stream_samples")),
+		sampleCollection:     db.Collection("stream_samples"),
+		slotCollection:       db.Collection("stream_slots"),
+		categories:           tags.NormalizeAll(cfg.Categories),
+		previewInterval:      cfg.PreviewInterval,
+		recordStreams:        cfg.RecordStreams,
+		maxChatLength:        cfg.MaxChatMessageLength,
+		truncateChat:         cfg.TruncateChatMessages,
+		newStreamKey:         generateStreamKey,
+	}
+	if service.maxChatLength <= 0 {
+		service.maxChatLength = DefaultMaxChatMessageLength
+	}
+
+	service.createIndexes()
+	ctx, cancel := context.WithCancel(context.Background())
+	service.workers = ctx
+	service.stopWorkers = cancel
+	service.viewers.Start(ctx, viewerFlushInterval)
+
+	return service
+}
+
+// createIndexes creates the indexes used by stream discovery queries
+func (s *LivestreamService) createIndexes() {
+	// Tag discovery only looks at live streams
+	tagIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "tags", Value: 1}},
+	}
+
+	// Chat history is paged newest-first within a stream; _id breaks ties between
+	// messages sent in the same millisecond
+	chatIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "stream_id", Value: 1}, {Key: "created_at", Value: 1}, {Key: "_id", Value: 1}},
+	}
+
+	// Moderators review and remove one user's messages in a stream
+	chatUserIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "stream_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}},
+	}
+
+	// Category browsing only looks at live streams
+	categoryIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "category", Value: 1}},
+	}
+
+	// Upcoming streams are listed soonest first
+	scheduleIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "scheduled_for", Value: 1}},
+	}
+
+	// Featured streams are listed in the order they were picked, while live
+	featuredIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "featured", Value: 1}, {Key: "status", Value: 1}, {Key: "featured_at", Value: 1}},
+		Options: options.Index().SetPartialFilterExpression(bson.M{"featured": true}),
 	}
+
+	// Publishers are authenticated by stream key, so no two streams may share one
+	streamKeyIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "stream_key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+
+	// Ignore errors as the index might already exist
+	s.livestreamCollection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{tagIndex, categoryIndex, scheduleIndex, featuredIndex, streamKeyIndex})
+	s.chatCollection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{chatIndex, chatUserIndex})
+
+	// Analytics read a stream's samples in order
+	sampleIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "stream_id", Value: 1}, {Key: "ts", Value: 1}},
+	}
+	s.sampleCollection.Indexes().CreateOne(context.Background(), sampleIndex)
+
+	s.createSlotIndexes()
+}
+
+// SetViewerCounts replaces the in-memory viewer tracker, e.g. with a RedisViewerTracker
+// shared by all instances. It must be called before the service is used.
+func (s *LivestreamService) SetViewerCounts(viewers ViewerCounts) {
+	s.viewers = viewers
+	viewers.Start(s.workers, viewerFlushInterval)
+}
+
+// SetResultCache makes the service cache its popular stream listings in results
+func (s *LivestreamService) SetResultCache(results *cache.Loader) {
+	s.results = results
+}
+
+// SetMaxConcurrentStreams limits how many live streams a user may have at once. Zero,
+// the default, means no limit.
+func (s *LivestreamService) SetMaxConcurrentStreams(limit int) {
+	s.maxConcurrentStreams = limit
 }
 
-// StartStream creates a new livestream entry in the database
+// StartStream creates a new livestream entry in the database. ErrStreamLimitReached is
+// returned if the user already has as many live streams as allowed, even when several
+// are started at once; scheduled streams don't count until they go live. With req.Resume set, the user's live stream is returned
+// as it is when they have one, so a repeated start doesn't create a duplicate.
 func (s *LivestreamService) StartStream(userID primitive.ObjectID, req StartStreamRequest) (*Livestream, error) {
-	streamKey := generateStreamKey()
+	if req.Resume {
+		active, err := s.GetActiveStream(context.Background(), userID)
+		if err != nil {
+			return nil, err
+		}
+		if active != nil {
+			return active, nil
+		}
+	}
+
+	req, err := validateStreamDetails(req)
+	if err != nil {
+		return nil, err
+	}
+	category, err := s.validateCategory(req.Category)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	streamID := primitive.NewObjectID()
+	if err := s.claimStreamSlot(ctx, userID, streamID); err != nil {
+		return nil, err
+	}
+
 	now := time.Now()
 	livestream := &Livestream{
-		ID:          primitive.NewObjectID(),
+		ID:          streamID,
 		UserID:      userID,
 		Title:       req.Title,
 		Description: req.Description,
 		Status:      StreamStatusLive,
-		StreamKey:   streamKey,
+		Tags:        req.Tags,
+		Category:    category,
 		ViewerCount: 0,
 		StartedAt:   &now,
 		CreatedAt:   now,
 		UpdatedAt:   now,
 	}
-	_, err := s.livestreamCollection.InsertOne(context.Background(), livestream)
+	if err := s.insertWithStreamKey(ctx, livestream); err != nil {
+		s.releaseStreamSlot(ctx, streamID)
+		return nil, err
+	}
+
+	return livestream, nil
+}
+
+// ScheduleStream announces a stream that starts at startAt. The stream gets its stream
+// key straight away so the streamer can set up their encoder, and goes live when they
+// first publish to it.
+func (s *LivestreamService) ScheduleStream(userID primitive.ObjectID, req StartStreamRequest, startAt time.Time) (*Livestream, error) {
+	now := time.Now()
+	if !startAt.After(now) {
+		return nil, ErrScheduleInPast
+	}
+	req, err := validateStreamDetails(req)
+	if err != nil {
+		return nil, err
+	}
+	category, err := s.validateCategory(req.Category)
 	if err != nil {
 		return nil, err
 	}
 
+	livestream := &Livestream{
+		ID:           primitive.NewObjectID(),
+		UserID:       userID,
+		Title:        req.Title,
+		Description:  req.Description,
+		Status:       StreamStatusScheduled,
+		Tags:         req.Tags,
+		Category:     category,
+		ScheduledFor: &startAt,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := s.insertWithStreamKey(context.Background(), livestream); err != nil {
+		return nil, err
+	}
+
 	return livestream, nil
 }
 
-// StopStream updates a livestream status to ended
+// insertWithStreamKey gives the stream a new stream key and inserts it. Keys are random
+// enough that a clash is practically impossible, but should the unique index reject one
+// anyway the insert is retried with another key.
+func (s *LivestreamService) insertWithStreamKey(ctx context.Context, livestream *Livestream) error {
+	var err error
+	for attempt := 0; attempt < maxStreamKeyAttempts; attempt++ {
+		if livestream.StreamKey, err = s.newStreamKey(); err != nil {
+			return err
+		}
+		if _, err = s.livestreamCollection.InsertOne(ctx, livestream); !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to generate a unique stream key: %w", err)
+}
+
+// GetUpcomingStreams returns scheduled streams that haven't started, soonest first
+func (s *LivestreamService) GetUpcomingStreams(ctx context.Context, limit int) ([]*Livestream, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "scheduled_for", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := s.livestreamCollection.Find(ctx, bson.M{"status": StreamStatusScheduled}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	streams := []*Livestream{}
+	if err := cursor.All(ctx, &streams); err != nil {
+		return nil, err
+	}
+	return streams, nil
+}
+
+// PromoteScheduledStreams takes the scheduled streams whose keys are being published to
+// live and returns how many were promoted. A stream whose user already has as many live
+// streams as allowed stays scheduled, and is promoted on a later run once a slot frees up.
+func (s *LivestreamService) PromoteScheduledStreams(ctx context.Context, publishingKeys []string) (int, error) {
+	if len(publishingKeys) == 0 {
+		return 0, nil
+	}
+
+	opts := options.Find().SetProjection(bson.M{"_id": 1, "user_id": 1})
+	cursor, err := s.livestreamCollection.Find(ctx,
+		bson.M{"status": StreamStatusScheduled, "stream_key": bson.M{"$in": publishingKeys}}, opts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find scheduled streams: %w", err)
+	}
+	var streams []Livestream
+	if err := cursor.All(ctx, &streams); err != nil {
+		return 0, fmt.Errorf("failed to find scheduled streams: %w", err)
+	}
+
+	promoted := 0
+	for _, stream := range streams {
+		if err := s.claimStreamSlot(ctx, stream.UserID, stream.ID); err != nil {
+			if !errors.Is(err, ErrStreamLimitReached) {
+				return promoted, err
+			}
+			logger.FromContext(ctx).Info("scheduled stream stays scheduled", "stream_id", stream.ID.Hex(), "error", err)
+			continue
+		}
+
+		now := time.Now()
+		result, err := s.livestreamCollection.UpdateOne(ctx,
+			bson.M{"_id": stream.ID, "status": StreamStatusScheduled},
+			bson.M{"$set": bson.M{
+				"status":     StreamStatusLive,
+				"started_at": now,
+				"updated_at": now,
+			}})
+		if err != nil {
+			s.releaseStreamSlot(ctx, stream.ID)
+			return promoted, fmt.Errorf("failed to promote scheduled streams: %w", err)
+		}
+		if result.ModifiedCount == 0 {
+			// Promoted or deleted meanwhile
+			continue
+		}
+		promoted++
+	}
+
+	return promoted, nil
+}
+
+// StopStream ends a stream owned by userID and publishes its recording, if one is running,
+// as a video. Ending the stream and saving the video happen in one transaction, so if
+// publishing fails the stream stays live and its recording is kept for another attempt.
+// On a standalone MongoDB, which has no transactions, the steps run one after another
+// and the status change is undone on failure.
 func (s *LivestreamService) StopStream(userID primitive.ObjectID, streamID primitive.ObjectID) (*Livestream, error) {
+	ctx := context.Background()
+
+	var stream Livestream
+	err := s.livestreamCollection.FindOne(ctx, bson.M{"_id": streamID}).Decode(&stream)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrStreamNotFound
+		}
+		return nil, fmt.Errorf("failed to stop stream: %w", err)
+	}
+	if stream.UserID != userID {
+		return nil, ErrNotStreamOwner
+	}
+
+	if err := s.stopStream(ctx, &stream); err != nil {
+		return nil, err
+	}
+	return &stream, nil
+}
+
+// StopAllResult reports the outcome of StopAllStreams
+type StopAllResult struct {
+	Stopped   int                  `json:"stopped"`
+	Failed    int                  `json:"failed"`
+	StreamIDs []primitive.ObjectID `json:"stream_ids"` // Streams that were stopped
+	Errors    []StopStreamError    `json:"errors"`
+	stopped   []*Livestream
+}
+
+// StopStreamError is a stream StopAllStreams failed to stop
+type StopStreamError struct {
+	StreamID primitive.ObjectID `json:"stream_id"`
+	Error    string             `json:"error"`
+}
+
+// StopAllStreams stops every live stream the way StopStream does, for maintenance. A
+// stream that fails to stop is reported in the result and doesn't keep the others from
+// being stopped. Only a failure to list the live streams is returned as an error.
+func (s *LivestreamService) StopAllStreams(ctx context.Context, reason string) (*StopAllResult, error) {
+	cursor, err := s.livestreamCollection.Find(ctx, bson.M{"status": StreamStatusLive})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list live streams: %w", err)
+	}
+	streams := []*Livestream{}
+	if err := cursor.All(ctx, &streams); err != nil {
+		return nil, fmt.Errorf("failed to list live streams: %w", err)
+	}
+
+	logger.FromContext(ctx).Info("stopping live streams", "count", len(streams), "reason", reason)
+	result := &StopAllResult{StreamIDs: []primitive.ObjectID{}, Errors: []StopStreamError{}}
+	for _, stream := range streams {
+		if err := s.stopStream(ctx, stream); err != nil {
+			logger.FromContext(ctx).Error("failed to stop stream", "stream_id", stream.ID.Hex(), "error", err)
+			result.Failed++
+			result.Errors = append(result.Errors, StopStreamError{StreamID: stream.ID, Error: err.Error()})
+			continue
+		}
+		result.Stopped++
+		result.StreamIDs = append(result.StreamIDs, stream.ID)
+		result.stopped = append(result.stopped, stream)
+	}
+	return result, nil
+}
+
+// stopStream ends a stream and publishes its recording as described for StopStream,
+// updating stream to its ended state
+func (s *LivestreamService) stopStream(ctx context.Context, stream *Livestream) error {
+	streamID := stream.ID
+	recording, err := s.recorderService.stopRecording(streamID)
+	if err != nil {
+		if !errors.Is(err, ErrNoActiveRecording) {
+			logger.FromContext(ctx).Error("failed to stop recording", "stream_id", streamID.Hex(), "error", err)
+		}
+		recording = nil
+	}
+
+	// Probing and uploading the recording can take long, so it happens before the
+	// transaction, which only writes the stream and video documents
+	var vod *video.Video
+	if recording != nil && s.videoService != nil {
+		vod, err = s.videoService.StoreRecording(ctx, recording.OutputPath, stream.Title, stream.Description, stream.UserID, streamID)
+		if err != nil {
+			// ffmpeg has already finished the file, so stopping the stream again can publish it
+			s.recorderService.restore(recording)
+			return fmt.Errorf("failed to publish recording: %w", err)
+		}
+	}
+
+	if err := s.endStreamWithRecording(ctx, stream, vod); err != nil {
+		// Aborting rolled back the video document but not the files it refers to
+		s.discardRecordingVideo(vod)
+		if recording != nil {
+			s.recorderService.restore(recording)
+		}
+		return err
+	}
+
+	s.releaseStreamSlot(ctx, streamID)
+
+	if vod != nil {
+		s.videoService.NotifyRecordingPublished(vod)
+		// The recording now lives in video storage
+		if err := os.Remove(recording.OutputPath); err != nil {
+			logger.FromContext(ctx).Warn("failed to remove recording file", "path", recording.OutputPath, "error", err)
+		}
+		logger.FromContext(ctx).Info("published stream recording", "stream_id", streamID.Hex(), "video_id", vod.ID.Hex())
+	}
+
+	// Persist the final viewer count now that the stream is over
+	if err := s.viewers.Reconcile(ctx, streamID); err != nil {
+		logger.FromContext(ctx).Error("failed to reconcile viewer count", "stream_id", streamID.Hex(), "error", err)
+	}
+
+	now := time.Now()
+	stream.Status = StreamStatusEnded
+	stream.EndedAt = &now
+	stream.UpdatedAt = now
+	return nil
+}
+
+// endStreamWithRecording runs endStream in a transaction, falling back to
+// endStreamSequentially when the server doesn't support transactions. The transaction
+// only writes documents, so retrying it on a transient error is cheap and has no side
+// effects.
+func (s *LivestreamService) endStreamWithRecording(ctx context.Context, stream *Livestream, vod *video.Video) error {
+	session, err := s.livestreamCollection.Database().Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, s.endStream(sc, stream, vod)
+	})
+	if transactionsUnsupported(err) {
+		return s.endStreamSequentially(ctx, stream, vod)
+	}
+	return err
+}
+
+// endStreamSequentially runs endStream without a transaction and restores the stream's
+// previous state if it fails
+func (s *LivestreamService) endStreamSequentially(ctx context.Context, stream *Livestream, vod *video.Video) error {
+	if err := s.endStream(ctx, stream, vod); err != nil {
+		if undoErr := s.restoreStreamState(ctx, stream); undoErr != nil {
+			logger.FromContext(ctx).Error("failed to restore stream after a failed stop", "stream_id", stream.ID.Hex(), "error", undoErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// endStream marks the stream ended and saves the video of its recording, stored
+// beforehand with StoreRecording. vod is nil when there is no recording to publish.
+func (s *LivestreamService) endStream(ctx context.Context, stream *Livestream, vod *video.Video) error {
 	now := time.Now()
 	update := bson.M{
 		"$set": bson.M{
@@ -64,18 +539,122 @@ func (s *LivestreamService) StopStream(userID primitive.ObjectID, streamID primi
 			"updated_at": now,
 		},
 	}
-	result, err := s.livestreamCollection.UpdateOne(context.Background(),
-		bson.M{"_id": streamID, "user_id": userID},
+	result, err := s.livestreamCollection.UpdateOne(ctx,
+		bson.M{"_id": stream.ID, "user_id": stream.UserID},
 		update)
 	if err != nil {
-		return nil, fmt.Errorf("failed to stop stream: %w", err)
+		return fmt.Errorf("failed to stop stream: %w", err)
 	}
-
 	if result.MatchedCount == 0 {
-		return nil, fmt.Errorf("stream not found or unauthorized")
+		// Deleted since it was loaded
+		return ErrStreamNotFound
+	}
+
+	if vod == nil {
+		return nil
+	}
+	if err := s.videoService.InsertRecordingVideo(ctx, vod); err != nil {
+		return fmt.Errorf("failed to publish recording: %w", err)
 	}
+	return nil
+}
 
-	return nil, nil
+// restoreStreamState undoes endStream's status change
+func (s *LivestreamService) restoreStreamState(ctx context.Context, stream *Livestream) error {
+	update := bson.M{"$set": bson.M{"status": stream.Status, "updated_at": stream.UpdatedAt}}
+	if stream.EndedAt != nil {
+		update["$set"].(bson.M)["ended_at"] = stream.EndedAt
+	} else {
+		update["$unset"] = bson.M{"ended_at": ""}
+	}
+	_, err := s.livestreamCollection.UpdateOne(ctx, bson.M{"_id": stream.ID}, update)
+	return err
+}
+
+// discardRecordingVideo removes a video published by a stop that didn't go through
+func (s *LivestreamService) discardRecordingVideo(vod *video.Video) {
+	if vod == nil {
+		return
+	}
+	if err := s.videoService.DiscardVideo(context.Background(), vod); err != nil {
+		slog.Error("failed to discard video of a failed stream stop", "video_id", vod.ID.Hex(), "error", err)
+	}
+}
+
+// transactionsUnsupported reports whether err means the server can't run transactions,
+// as is the case for a standalone MongoDB
+func transactionsUnsupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == 20 { // IllegalOperation
+		return true
+	}
+	return strings.Contains(err.Error(), "Transaction numbers are only allowed")
+}
+
+// endPublishedStream stops a stream whose publisher went away the way StopStream does,
+// publishing its recording in the same step. A stream that was already stopped, e.g.
+// through the API, is left alone. A failed stop leaves the stream live with its
+// recording kept, as for StopStream.
+func (s *LivestreamService) endPublishedStream(ctx context.Context, streamID primitive.ObjectID) {
+	stream, err := s.GetStreamStatus(streamID)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to load stream to stop it", "stream_id", streamID.Hex(), "error", err)
+		return
+	}
+	if stream.Status != StreamStatusLive {
+		return
+	}
+	if err := s.stopStream(ctx, stream); err != nil {
+		logger.FromContext(ctx).Error("failed to stop stream", "stream_id", streamID.Hex(), "error", err)
+	}
+}
+
+// goTracked runs fn in the background. Shutdown waits for it, so fn may keep using the
+// database while the server stops.
+func (s *LivestreamService) goTracked(fn func()) {
+	s.tasks.Add(1)
+	go func() {
+		defer s.tasks.Done()
+		fn()
+	}()
+}
+
+// Shutdown stops the background workers and stops every stream still being recorded,
+// publishing each recording as a video. It waits for background work to finish, until
+// ctx is done, and then writes the final viewer counts.
+func (s *LivestreamService) Shutdown(ctx context.Context) error {
+	s.stopWorkers()
+
+	for _, streamID := range s.recorderService.activeStreams() {
+		logger.FromContext(ctx).Info("stopping recorded stream for shutdown", "stream_id", streamID.Hex())
+		s.goTracked(func() { s.endPublishedStream(context.Background(), streamID) })
+	}
+
+	waitErr := lifecycle.Wait(ctx, &s.tasks)
+	if err := s.viewers.Flush(ctx); err != nil {
+		return errors.Join(waitErr, fmt.Errorf("failed to flush viewer counts: %w", err))
+	}
+	return waitErr
+}
+
+// GetStreamRecording returns the video recorded from a stream, if requesterID may see it.
+// video.ErrNotFound is returned if the stream wasn't recorded, its recording is still
+// being published or the recording is private to another user.
+func (s *LivestreamService) GetStreamRecording(ctx context.Context, streamID, requesterID primitive.ObjectID) (*video.Video, error) {
+	if s.videoService == nil {
+		return nil, video.ErrNotFound
+	}
+	vod, err := s.videoService.GetVideoBySourceStream(ctx, streamID)
+	if err != nil {
+		return nil, err
+	}
+	if !vod.VisibleTo(requesterID) {
+		return nil, video.ErrNotFound
+	}
+	return vod, nil
 }
 
 // GetStreamStatus retrieves the current status of a livestream
@@ -84,10 +663,28 @@ func (s *LivestreamService) GetStreamStatus(streamID primitive.ObjectID) (*Lives
 	if err := s.livestreamCollection.FindOne(context.Background(), bson.M{"_id": streamID}).Decode(&livestream); err != nil {
 		return nil, err
 	}
+	s.applyLiveViewerCounts(livestream)
 
 	return livestream, nil
 }
 
+// GetStreamByID returns the public view of a stream, for viewers. The stream key is not
+// read from the database at all.
+func (s *LivestreamService) GetStreamByID(ctx context.Context, streamID primitive.ObjectID) (*PublicStream, error) {
+	var livestream Livestream
+	opts := options.FindOne().SetProjection(bson.M{"stream_key": 0})
+	if err := s.livestreamCollection.FindOne(ctx, bson.M{"_id": streamID}, opts).Decode(&livestream); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrStreamNotFound
+		}
+		return nil, err
+	}
+	s.applyLiveViewerCounts(&livestream)
+
+	public := livestream.Public()
+	return &public, nil
+}
+
 // ListStreams returns all currently live streams
 func (s *LivestreamService) ListStreams() ([]*Livestream, error) {
 	cursor, err := s.livestreamCollection.Find(context.Background(), bson.M{"status": StreamStatusLive})
@@ -100,6 +697,7 @@ func (s *LivestreamService) ListStreams() ([]*Livestream, error) {
 	if err := cursor.All(context.Background(), &streams); err != nil {
 		return streams, nil
 	}
+	s.applyLiveViewerCounts(streams...)
 
 	return streams, nil
 }
@@ -119,6 +717,80 @@ func (s *LivestreamService) GetMessages(streamID primitive.ObjectID) ([]*ChatMes
 	return messages, nil
 }
 
+// GetMessagesPaginated returns a page of a stream's chat history, newest first. Pass the
+// page's NextCursor as beforeID to fetch the messages sent before it; a zero beforeID
+// starts from the most recent message.
+func (s *LivestreamService) GetMessagesPaginated(ctx context.Context, streamID, beforeID primitive.ObjectID, limit int) (*ChatMessagePage, error) {
+	if limit <= 0 {
+		limit = DefaultChatPageSize
+	}
+	if limit > MaxChatPageSize {
+		limit = MaxChatPageSize
+	}
+
+	filter := bson.M{"stream_id": streamID}
+	if !beforeID.IsZero() {
+		var cursorMessage ChatMessage
+		err := s.chatCollection.FindOne(ctx, bson.M{"_id": beforeID, "stream_id": streamID}).Decode(&cursorMessage)
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInvalidChatCursor
+		}
+		if err != nil {
+			return nil, err
+		}
+		filter["$or"] = bson.A{
+			bson.M{"created_at": bson.M{"$lt": cursorMessage.CreatedAt}},
+			bson.M{"created_at": cursorMessage.CreatedAt, "_id": bson.M{"$lt": beforeID}},
+		}
+	}
+
+	// Fetch one extra message to find out whether there is another page
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit + 1))
+	cursor, err := s.chatCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	messages := []*ChatMessage{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	page := &ChatMessagePage{Messages: messages}
+	if len(messages) > limit {
+		page.Messages = messages[:limit]
+		page.NextCursor = page.Messages[limit-1].ID.Hex()
+	}
+	return page, nil
+}
+
+// GetMessagesSince returns the messages sent after afterTime, oldest first, so a client
+// that reconnects can catch up on what it missed. At most MaxChatPageSize messages are
+// returned; clients that were away longer should fall back to GetMessagesPaginated.
+func (s *LivestreamService) GetMessagesSince(ctx context.Context, streamID primitive.ObjectID, afterTime time.Time) ([]*ChatMessage, error) {
+	filter := bson.M{
+		"stream_id":  streamID,
+		"created_at": bson.M{"$gt": afterTime},
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(MaxChatPageSize)
+	cursor, err := s.chatCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	messages := []*ChatMessage{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
 // SaveChatMessage persists a chat message to the database
 func (s *LivestreamService) SaveChatMessage(message *ChatMessage) error {
 	_, err := s.chatCollection.InsertOne(context.Background(), message)
@@ -128,8 +800,27 @@ func (s *LivestreamService) SaveChatMessage(message *ChatMessage) error {
 	return nil
 }
 
-// SendChatMessage creates and saves a new chat message
+// SendChatMessage creates and saves a new chat message. Surrounding whitespace is trimmed;
+// ErrEmptyMessage is returned if nothing is left, and ErrMessageTooLong if the message is
+// over the configured length, unless the service truncates long messages instead.
 func (s *LivestreamService) SendChatMessage(streamID primitive.ObjectID, userID primitive.ObjectID, userName, message string) error {
+	_, err := s.sendChatMessage(streamID, userID, userName, message)
+	return err
+}
+
+// sendChatMessage is SendChatMessage, returning the message as it was saved
+func (s *LivestreamService) sendChatMessage(streamID primitive.ObjectID, userID primitive.ObjectID, userName, message string) (*ChatMessage, error) {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return nil, ErrEmptyMessage
+	}
+	if utf8.RuneCountInString(message) > s.maxChatLength {
+		if !s.truncateChat {
+			return nil, fmt.Errorf("%w: at most %d characters", ErrMessageTooLong, s.maxChatLength)
+		}
+		message = truncateChatMessage(message, s.maxChatLength)
+	}
+
 	chatMessage := &ChatMessage{
 		ID:        primitive.NewObjectID(),
 		StreamID:  streamID,
@@ -142,16 +833,32 @@ func (s *LivestreamService) SendChatMessage(streamID primitive.ObjectID, userID
 
 	err := s.SaveChatMessage(chatMessage)
 	if err != nil {
-		return fmt.Errorf("failed to send chat message: %w", err)
+		return nil, fmt.Errorf("failed to send chat message: %w", err)
 	}
-	return nil
+	return chatMessage, nil
+}
+
+// truncateChatMessage cuts a message down to limit characters, dropping the whitespace
+// the cut leaves at its end
+func truncateChatMessage(message string, limit int) string {
+	runes := 0
+	for i := range message {
+		if runes == limit {
+			return strings.TrimRightFunc(message[:i], unicode.IsSpace)
+		}
+		runes++
+	}
+	return message
 }
 
-// generateStreamKey creates a unique stream key for RTMP authentication
-func generateStreamKey() string {
-	bytes := make([]byte, 16)
-	rand.Read(bytes)
-	return hex.EncodeToString(bytes)
+// generateStreamKey creates a random stream key for RTMP authentication: streamKeySize
+// bytes from crypto/rand, base32 encoded so it is easy to copy into an encoder
+func generateStreamKey() (string, error) {
+	key := make([]byte, streamKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate stream key: %w", err)
+	}
+	return streamKeyEncoding.EncodeToString(key), nil
 }
 
 // NewRecorderService creates a new recorder service for video recording
@@ -159,6 +866,7 @@ func NewRecorderService(storagePath string, db *mongo.Database) *RecorderService
 	return &RecorderService{
 		storagePath:          storagePath,
 		recordings:           make(map[string]*RecorderSession),
+		frames:               make(map[string]*liveFrame),
 		recordingsCollection: db.Collection("recordings"),
 	}
 }
@@ -199,23 +907,53 @@ func (r *RecorderService) StartRecording(streamID primitive.ObjectID, rtmpURL st
 
 // StopRecording gracefully stops the FFmpeg recording process
 func (r *RecorderService) StopRecording(streamID primitive.ObjectID) error {
+	_, err := r.stopRecording(streamID)
+	return err
+}
+
+// stopRecording stops the recording and returns its session once the output file is complete
+func (r *RecorderService) stopRecording(streamID primitive.ObjectID) (*RecorderSession, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	session, exists := r.recordings[streamID.Hex()]
 	if !exists {
-		return fmt.Errorf("no active recording for stream %s", streamID.Hex())
+		return nil, fmt.Errorf("%w for stream %s", ErrNoActiveRecording, streamID.Hex())
 	}
 
-	if session.Process != nil && session.Process.Process != nil {
+	if session.ingest != nil {
+		// ffmpeg finishes the file once its input ends
+		session.ingest.close()
+	} else if session.Process != nil && session.Process.Process != nil {
 		session.Process.Process.Signal(os.Interrupt)
+	}
+	if session.Process != nil && session.Process.Process != nil {
 		session.Process.Wait()
 	}
 
 	session.IsRecording = false
 	delete(r.recordings, streamID.Hex())
 
-	return nil
+	return session, nil
+}
+
+// restore puts back the session of a recording that was stopped but couldn't be published
+func (r *RecorderService) restore(session *RecorderSession) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recordings[session.StreamID.Hex()] = session
+}
+
+// activeStreams returns the IDs of the streams being recorded
+func (r *RecorderService) activeStreams() []primitive.ObjectID {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := make([]primitive.ObjectID, 0, len(r.recordings))
+	for _, session := range r.recordings {
+		ids = append(ids, session.StreamID)
+	}
+	return ids
 }
 
 // GetRecordingStatus returns the current recording session status
@@ -241,13 +979,27 @@ func (s *LivestreamService) GetStreamByKey(streamKey string) (*Livestream, error
 	return &livestream, nil
 }
 
-// UpdateStream updates stream metadata
+// updatableStreamStatuses are the statuses UpdateStream may set. Scheduling a stream needs
+// a start time, so it only happens through ScheduleStream.
+var updatableStreamStatuses = map[StreamStatus]bool{
+	StreamStatusOffline: true,
+	StreamStatusLive:    true,
+	StreamStatusEnded:   true,
+}
+
+// UpdateStream sets the title, description, category or status of a stream. Only the
+// given fields are written, so concurrent updates of different fields don't overwrite
+// each other. ErrInvalidUpdate is returned for any other field, such as the stream key,
+// or a value of the wrong type or an unknown status.
 func (s *LivestreamService) UpdateStream(streamID primitive.ObjectID, updates map[string]interface{}) error {
-	updates["updatedAt"] = time.Now()
-	update := bson.M{"$set": updates}
+	set, err := s.streamUpdate(updates)
+	if err != nil {
+		return err
+	}
+	set["updated_at"] = time.Now()
 
 	result, err := s.livestreamCollection.UpdateOne(context.Background(),
-		bson.M{"_id": streamID}, update)
+		bson.M{"_id": streamID}, bson.M{"$set": set})
 	if err != nil {
 		return fmt.Errorf("failed to update stream: %w", err)
 	}
@@ -259,6 +1011,174 @@ func (s *LivestreamService) UpdateStream(streamID primitive.ObjectID, updates ma
 	return nil
 }
 
+// streamUpdate validates the fields of an UpdateStream call and returns them as stored
+func (s *LivestreamService) streamUpdate(updates map[string]interface{}) (bson.M, error) {
+	if len(updates) == 0 {
+		return nil, fmt.Errorf("%w: no fields given", ErrInvalidUpdate)
+	}
+
+	set := bson.M{}
+	for field, value := range updates {
+		switch field {
+		case "title":
+			title, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: title must be a string", ErrInvalidUpdate)
+			}
+			title = strings.TrimSpace(title)
+			if title == "" {
+				return nil, ErrStreamTitleRequired
+			}
+			if utf8.RuneCountInString(title) > MaxStreamTitleLength {
+				return nil, ErrStreamTitleTooLong
+			}
+			set["title"] = title
+		case "description":
+			description, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: description must be a string", ErrInvalidUpdate)
+			}
+			if utf8.RuneCountInString(description) > MaxStreamDescriptionLength {
+				return nil, ErrStreamDescriptionTooLong
+			}
+			set["description"] = description
+		case "category":
+			category, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: category must be a string", ErrInvalidUpdate)
+			}
+			category, err := s.validateCategory(category)
+			if err != nil {
+				return nil, err
+			}
+			set["category"] = category
+		case "status":
+			var status StreamStatus
+			switch v := value.(type) {
+			case StreamStatus:
+				status = v
+			case string:
+				status = StreamStatus(v)
+			}
+			if !updatableStreamStatuses[status] {
+				return nil, fmt.Errorf("%w: status can't be set to %v", ErrInvalidUpdate, value)
+			}
+			set["status"] = status
+		default:
+			return nil, fmt.Errorf("%w: %s can't be updated", ErrInvalidUpdate, field)
+		}
+	}
+	return set, nil
+}
+
+// SetStreamTags replaces the tags on one of the user's streams and returns the stored tags
+func (s *LivestreamService) SetStreamTags(ctx context.Context, userID, streamID primitive.ObjectID, streamTags []string) ([]string, error) {
+	normalized, err := tags.Parse(streamTags)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.livestreamCollection.UpdateOne(ctx,
+		bson.M{"_id": streamID, "user_id": userID},
+		bson.M{"$set": bson.M{"tags": normalized, "updated_at": time.Now()}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set stream tags: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		if err := s.checkOwner(ctx, streamID, userID); err != nil {
+			return nil, err
+		}
+		// Deleted since the update
+		return nil, ErrStreamNotFound
+	}
+
+	return normalized, nil
+}
+
+// ListStreamsByTag returns live streams carrying the given tag, most watched first
+func (s *LivestreamService) ListStreamsByTag(ctx context.Context, tag string) ([]*Livestream, error) {
+	streams := []*Livestream{}
+
+	tag = tags.Normalize(tag)
+	if tag == "" {
+		return streams, nil
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "viewer_count", Value: -1}})
+	cursor, err := s.livestreamCollection.Find(ctx, bson.M{"status": StreamStatusLive, "tags": tag}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &streams); err != nil {
+		return nil, err
+	}
+	s.applyLiveViewerCounts(streams...)
+	return streams, nil
+}
+
+// GetFollowedLiveStreams returns the live streams of users the viewer follows, most
+// watched first. A viewer who follows nobody gets an empty list.
+func (s *LivestreamService) GetFollowedLiveStreams(ctx context.Context, viewerID primitive.ObjectID) ([]*Livestream, error) {
+	streams := []*Livestream{}
+	if s.userService == nil {
+		return streams, nil
+	}
+
+	following, err := s.userService.GetFollowing(ctx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(following) == 0 {
+		return streams, nil
+	}
+
+	userIDs := make([]primitive.ObjectID, 0, len(following))
+	for _, follow := range following {
+		userIDs = append(userIDs, follow.TargetID)
+	}
+
+	cursor, err := s.livestreamCollection.Find(ctx, bson.M{"status": StreamStatusLive, "user_id": bson.M{"$in": userIDs}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &streams); err != nil {
+		return nil, err
+	}
+
+	// Sort on the live counts rather than the last flushed ones
+	s.applyLiveViewerCounts(streams...)
+	sort.SliceStable(streams, func(i, j int) bool {
+		return streams[i].ViewerCount > streams[j].ViewerCount
+	})
+	return streams, nil
+}
+
+// validateStreamDetails checks the title, description and tags of a new stream, returning
+// the request with the title trimmed and the tags normalized
+func validateStreamDetails(req StartStreamRequest) (StartStreamRequest, error) {
+	req.Title = strings.TrimSpace(req.Title)
+	if req.Title == "" {
+		return req, ErrStreamTitleRequired
+	}
+	if utf8.RuneCountInString(req.Title) > MaxStreamTitleLength {
+		return req, ErrStreamTitleTooLong
+	}
+	if utf8.RuneCountInString(req.Description) > MaxStreamDescriptionLength {
+		return req, ErrStreamDescriptionTooLong
+	}
+	normalized, err := tags.Parse(req.Tags)
+	if err != nil {
+		return req, err
+	}
+	req.Tags = normalized
+	return req, nil
+}
+
 // GetUserStreams returns all streams created by a specific user
 func (s *LivestreamService) GetUserStreams(userID primitive.ObjectID) ([]*Livestream, error) {
 	cursor, err := s.livestreamCollection.Find(context.Background(), bson.M{"user_id": userID})
@@ -271,59 +1191,196 @@ func (s *LivestreamService) GetUserStreams(userID primitive.ObjectID) ([]*Livest
 	if err := cursor.All(context.Background(), &streams); err != nil {
 		return nil, err
 	}
+	s.applyLiveViewerCounts(streams...)
 	return streams, nil
 }
 
-// DeleteStream removes a stream from the database
-func (s *LivestreamService) DeleteStream(streamID primitive.ObjectID) error {
-	result, err := s.livestreamCollection.DeleteOne(context.Background(), bson.M{"_id": streamID})
+// GetActiveStream returns the user's live stream, the most recently started if they have
+// several, or nil if they have none. The stream key is included so the streamer can
+// resume publishing.
+func (s *LivestreamService) GetActiveStream(ctx context.Context, userID primitive.ObjectID) (*Livestream, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "started_at", Value: -1}})
+	var stream Livestream
+	err := s.livestreamCollection.FindOne(ctx, bson.M{"user_id": userID, "status": StreamStatusLive}, opts).Decode(&stream)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to delete stream: %w", err)
+		return nil, fmt.Errorf("failed to find active stream: %w", err)
+	}
+	s.applyLiveViewerCounts(&stream)
+	return &stream, nil
+}
+
+// CountActiveStreams returns the number of streams the user currently has live
+func (s *LivestreamService) CountActiveStreams(userID primitive.ObjectID) (int64, error) {
+	return s.livestreamCollection.CountDocuments(context.Background(), bson.M{"user_id": userID, "status": StreamStatusLive})
+}
+
+// StreamCounts is the number of streams on the platform
+type StreamCounts struct {
+	Live  int64 `json:"live"`
+	Total int64 `json:"total"` // Every stream, whatever its status
+}
+
+// CountStreams returns how many streams are live and how many there are in all
+func (s *LivestreamService) CountStreams(ctx context.Context) (*StreamCounts, error) {
+	live, err := s.livestreamCollection.CountDocuments(ctx, bson.M{"status": StreamStatusLive})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count live streams: %w", err)
 	}
+	total, err := s.livestreamCollection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count streams: %w", err)
+	}
+	return &StreamCounts{Live: live, Total: total}, nil
+}
 
+// DeleteStream deletes one of the owner's streams along with its chat history, viewer
+// samples and recording files. A live stream has to be stopped first; ErrStreamLive is
+// returned for it. Videos published from the stream's recording are kept.
+func (s *LivestreamService) DeleteStream(ctx context.Context, streamID, ownerID primitive.ObjectID) error {
+	// Matching on the status makes sure a scheduled stream that goes live meanwhile is kept
+	result, err := s.livestreamCollection.DeleteOne(ctx, bson.M{
+		"_id":     streamID,
+		"user_id": ownerID,
+		"status":  bson.M{"$ne": StreamStatusLive},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete stream: %w", err)
+	}
 	if result.DeletedCount == 0 {
-		return fmt.Errorf("stream not found")
+		return s.deleteRefusal(ctx, streamID, ownerID)
 	}
 
+	s.deleteStreamData(ctx, streamID)
 	return nil
 }
 
-// AddViewer increments the viewer count for a stream
-func (s *LivestreamService) AddViewer(streamID primitive.ObjectID) error {
-	update := bson.M{"$inc": bson.M{"viewer_count": 1}}
+// deleteRefusal explains why DeleteStream didn't match the stream
+func (s *LivestreamService) deleteRefusal(ctx context.Context, streamID, ownerID primitive.ObjectID) error {
+	if err := s.checkOwner(ctx, streamID, ownerID); err != nil {
+		return err
+	}
+	return ErrStreamLive
+}
 
-	result, err := s.livestreamCollection.UpdateOne(context.Background(),
-		bson.M{"_id": streamID}, update)
-	if err != nil {
-		return fmt.Errorf("failed to add viewer: %w", err)
+// checkOwner returns ErrStreamNotFound or ErrNotStreamOwner unless ownerID owns the stream
+func (s *LivestreamService) checkOwner(ctx context.Context, streamID, ownerID primitive.ObjectID) error {
+	var stream Livestream
+	opts := options.FindOne().SetProjection(bson.M{"user_id": 1})
+	if err := s.livestreamCollection.FindOne(ctx, bson.M{"_id": streamID}, opts).Decode(&stream); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrStreamNotFound
+		}
+		return fmt.Errorf("failed to find stream: %w", err)
+	}
+	if stream.UserID != ownerID {
+		return ErrNotStreamOwner
 	}
+	return nil
+}
 
-	if result.MatchedCount == 0 {
-		return fmt.Errorf("stream not found")
+// RotateStreamKey gives one of the owner's streams a new stream key and returns the
+// updated stream. The old key stops working at once: new publishes with it are refused,
+// and a publish already using it is disconnected at its next key check.
+func (s *LivestreamService) RotateStreamKey(ctx context.Context, streamID, ownerID primitive.ObjectID) (*Livestream, error) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var stream Livestream
+	var err error
+	for attempt := 0; attempt < maxStreamKeyAttempts; attempt++ {
+		var key string
+		if key, err = s.newStreamKey(); err != nil {
+			return nil, err
+		}
+		update := bson.M{"$set": bson.M{"stream_key": key, "updated_at": time.Now()}}
+		err = s.livestreamCollection.FindOneAndUpdate(ctx, bson.M{"_id": streamID, "user_id": ownerID}, update, opts).Decode(&stream)
+		if !mongo.IsDuplicateKeyError(err) {
+			break
+		}
 	}
+	if err == mongo.ErrNoDocuments {
+		if err := s.checkOwner(ctx, streamID, ownerID); err != nil {
+			return nil, err
+		}
+		return nil, ErrStreamNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate stream key: %w", err)
+	}
+	s.applyLiveViewerCounts(&stream)
 
-	return nil
+	return &stream, nil
 }
 
-// RemoveViewer decrements the viewer count for a stream
-func (s *LivestreamService) RemoveViewer(streamID primitive.ObjectID) error {
-	update := bson.M{"$inc": bson.M{"viewer_count": -1}}
+// deleteStreamData removes everything kept about a deleted stream. The stream itself is
+// already gone, so failures are logged and cleanup carries on.
+func (s *LivestreamService) deleteStreamData(ctx context.Context, streamID primitive.ObjectID) {
+	s.viewers.Forget(streamID)
+	s.releaseStreamSlot(ctx, streamID)
+	s.recorderService.removePreview(streamID)
 
-	result, err := s.livestreamCollection.UpdateOne(context.Background(),
-		bson.M{"_id": streamID}, update)
+	if _, err := s.chatCollection.DeleteMany(ctx, bson.M{"stream_id": streamID}); err != nil {
+		logger.FromContext(ctx).Error("failed to delete chat messages", "stream_id", streamID.Hex(), "error", err)
+	}
+	if _, err := s.sampleCollection.DeleteMany(ctx, bson.M{"stream_id": streamID}); err != nil {
+		logger.FromContext(ctx).Error("failed to delete viewer samples", "stream_id", streamID.Hex(), "error", err)
+	}
+
+	// A recording whose publishing failed is still held by the recorder
+	if session, err := s.recorderService.stopRecording(streamID); err == nil {
+		removeRecordingFile(session.OutputPath)
+	}
+	recordings, err := s.GetStreamRecordings(streamID)
 	if err != nil {
-		return fmt.Errorf("failed to remove viewer: %w", err)
+		logger.FromContext(ctx).Error("failed to find recordings", "stream_id", streamID.Hex(), "error", err)
+	}
+	for _, recording := range recordings {
+		removeRecordingFile(recording.FilePath)
 	}
+	if _, err := s.recorderService.recordingsCollection.DeleteMany(ctx, bson.M{"stream_id": streamID}); err != nil {
+		logger.FromContext(ctx).Error("failed to delete recordings", "stream_id", streamID.Hex(), "error", err)
+	}
+}
 
-	if result.MatchedCount == 0 {
-		return fmt.Errorf("stream not found")
+// removeRecordingFile deletes a recording file, which may already be gone
+func removeRecordingFile(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		slog.Warn("failed to remove recording file", "path", path, "error", err)
+	}
+}
+
+// AddViewer increments the live viewer count for a stream and raises its peak when the
+// count exceeds it. Both are kept by the viewer tracker and synced to the database in the
+// background.
+func (s *LivestreamService) AddViewer(streamID primitive.ObjectID) error {
+	if _, err := s.viewers.Add(context.Background(), streamID); err != nil {
+		return fmt.Errorf("failed to add viewer: %w", err)
 	}
+	return nil
+}
 
+// RemoveViewer decrements the live viewer count for a stream. The count stops at zero,
+// in which case ErrNoViewers is returned and nothing changes.
+func (s *LivestreamService) RemoveViewer(streamID primitive.ObjectID) error {
+	_, err := s.viewers.Remove(context.Background(), streamID)
+	if errors.Is(err, ErrNoViewers) {
+		return ErrNoViewers
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove viewer: %w", err)
+	}
 	return nil
 }
 
-// GetViewerCount returns the current viewer count for a stream
+// GetViewerCount returns the current viewer count for a stream, preferring the live
+// tracked count over the last value synced to the database
 func (s *LivestreamService) GetViewerCount(streamID primitive.ObjectID) (int, error) {
+	if count, ok := s.viewers.Count(streamID); ok {
+		return int(count), nil
+	}
+
 	var livestream Livestream
 	err := s.livestreamCollection.FindOne(context.Background(), bson.M{"_id": streamID}).Decode(&livestream)
 	if err != nil {
@@ -333,6 +1390,23 @@ func (s *LivestreamService) GetViewerCount(streamID primitive.ObjectID) (int, er
 	return livestream.ViewerCount, nil
 }
 
+// FlushViewerCounts writes live viewer counts to the database immediately
+func (s *LivestreamService) FlushViewerCounts(ctx context.Context) error {
+	return s.viewers.Flush(ctx)
+}
+
+// applyLiveViewerCounts replaces the stored viewer counts with the live ones where tracked
+func (s *LivestreamService) applyLiveViewerCounts(streams ...*Livestream) {
+	for _, stream := range streams {
+		if count, ok := s.viewers.Count(stream.ID); ok {
+			stream.ViewerCount = int(count)
+		}
+		if peak, ok := s.viewers.Peak(stream.ID); ok {
+			stream.PeakViewerCount = max(stream.PeakViewerCount, int(peak))
+		}
+	}
+}
+
 // SearchStreams finds streams matching the search query
 func (s *LivestreamService) SearchStreams(query string) ([]*Livestream, error) {
 	filter := bson.M{
@@ -355,11 +1429,35 @@ func (s *LivestreamService) SearchStreams(query string) ([]*Livestream, error) {
 	if err := cursor.All(context.Background(), &streams); err != nil {
 		return nil, err
 	}
+	s.applyLiveViewerCounts(streams...)
 	return streams, nil
 }
 
-// GetPopularStreams returns streams ordered by viewer count
+// GetPopularStreams returns streams ordered by viewer count. Ordering uses the counts last
+// flushed to the database, so it can lag live counts by up to viewerFlushInterval plus
+// the result cache's TTL.
 func (s *LivestreamService) GetPopularStreams(limit int) ([]*Livestream, error) {
+	// Clamped before building the cache key, so arbitrary limits can't fill the cache
+	if limit < 1 {
+		limit = 10
+	}
+	if limit > MaxPopularStreamsLimit {
+		limit = MaxPopularStreamsLimit
+	}
+
+	streams, err := cache.Fetch(s.results, fmt.Sprintf("streams:popular:%d", limit), func() ([]*Livestream, error) {
+		return s.popularStreams(limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Live counts change every second, so they are applied after the cache
+	s.applyLiveViewerCounts(streams...)
+	return streams, nil
+}
+
+// popularStreams queries the streams for GetPopularStreams
+func (s *LivestreamService) popularStreams(limit int) ([]*Livestream, error) {
 	opts := options.Find().SetSort(bson.D{{Key: "viewer_count", Value: -1}}).SetLimit(int64(limit))
 
 	cursor, err := s.livestreamCollection.Find(context.Background(), bson.M{"status": StreamStatusLive}, opts)
@@ -415,34 +1513,3 @@ func (s *LivestreamService) DeleteRecording(recordingID primitive.ObjectID) erro
 
 	return nil
 }
-
-// GetStreamAnalytics returns analytics data for a stream
-func (s *LivestreamService) GetStreamAnalytics(streamID primitive.ObjectID) (*StreamAnalytics, error) {
-	stream, err := s.GetStreamStatus(streamID)
-	if err != nil {
-		return nil, err
-	}
-
-	// Get chat message count
-	chatCount, err := s.chatCollection.CountDocuments(context.Background(), bson.M{"stream_id": streamID})
-	if err != nil {
-		return nil, err
-	}
-
-	// Get recording duration if stream has ended
-	var duration time.Duration
-	if stream.Status == StreamStatusEnded && stream.StartedAt != nil && stream.EndedAt != nil {
-		duration = stream.EndedAt.Sub(*stream.StartedAt)
-	}
-
-	analytics := &StreamAnalytics{
-		StreamID:       streamID,
-		ViewerCount:    stream.ViewerCount,
-		ChatCount:      int(chatCount),
-		Duration:       duration,
-		PeakViewers:    stream.PeakViewerCount,
-		AverageViewers: stream.AverageViewerCount,
-	}
-
-	return analytics, nil
-}
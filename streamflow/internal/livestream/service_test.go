@@ -1,23 +1,45 @@
 package livestream
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
+	"unicode/utf8"
 
+	"streamflow/internal/config"
 	"streamflow/internal/database"
-
+	"streamflow/internal/tags"
+	"streamflow/internal/users"
+	"streamflow/internal/video"
+	"streamflow/internal/webhooks"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/yutopp/go-flv"
+	flvtag "github.com/yutopp/go-flv/tag"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var testLivestreamService *LivestreamService
+var testVideoService *video.VideoService
+var testUserService *users.UserService
 var testUserID primitive.ObjectID
 var testDbService database.Service
 
@@ -40,7 +62,11 @@ func TestMain(m *testing.M) {
 
 	// Initialize test database service
 	testDbService = database.New()
-	testLivestreamService = NewLiveStreamService(testDbService.GetDatabase())
+	testVideoService = video.NewVideoService(testDbService.GetDatabase(), config.VideoConfig{})
+	testUserService = users.NewUserService(testDbService.GetDatabase(), config.TwoFactorConfig{EncryptionKey: "test-totp-key"})
+	testLivestreamService = NewLiveStreamService(testDbService.GetDatabase(), testVideoService, testUserService, config.LivestreamConfig{
+		Categories: []string{"gaming", "music", "art"},
+	})
 	testUserID = primitive.NewObjectID()
 
 	code := m.Run()
@@ -60,6 +86,121 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
+// Test that StartStream and ScheduleStream reject invalid titles and descriptions
+func TestLivestreamService_StartStreamValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     StartStreamRequest
+		wantErr error
+	}{
+		{"empty title", StartStreamRequest{Title: ""}, ErrStreamTitleRequired},
+		{"whitespace title", StartStreamRequest{Title: "  \t\n "}, ErrStreamTitleRequired},
+		{"title too long", StartStreamRequest{Title: strings.Repeat("a", MaxStreamTitleLength+1)}, ErrStreamTitleTooLong},
+		{"description too long", StartStreamRequest{Title: "Valid", Description: strings.Repeat("d", MaxStreamDescriptionLength+1)}, ErrStreamDescriptionTooLong},
+		{"title at limit", StartStreamRequest{Title: strings.Repeat("é", MaxStreamTitleLength)}, nil},
+		{"description at limit", StartStreamRequest{Title: "Valid", Description: strings.Repeat("d", MaxStreamDescriptionLength)}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A fresh user each time, so the concurrent stream limit never interferes
+			userID := primitive.NewObjectID()
+
+			stream, err := testLivestreamService.StartStream(userID, tt.req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("StartStream() error = %v, want %v", err, tt.wantErr)
+			}
+			if stream != nil {
+				defer testLivestreamService.livestreamCollection.DeleteOne(context.Background(), bson.M{"_id": stream.ID})
+			}
+
+			scheduled, err := testLivestreamService.ScheduleStream(userID, tt.req, time.Now().Add(time.Hour))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ScheduleStream() error = %v, want %v", err, tt.wantErr)
+			}
+			if scheduled != nil {
+				defer testLivestreamService.livestreamCollection.DeleteOne(context.Background(), bson.M{"_id": scheduled.ID})
+			}
+		})
+	}
+
+	// Surrounding whitespace is trimmed from the stored title
+	stream, err := testLivestreamService.StartStream(primitive.NewObjectID(), StartStreamRequest{Title: "  Padded title  "})
+	if err != nil {
+		t.Fatalf("StartStream() unexpected error = %v", err)
+	}
+	defer testLivestreamService.livestreamCollection.DeleteOne(context.Background(), bson.M{"_id": stream.ID})
+	if stream.Title != "Padded title" {
+		t.Errorf("StartStream() title = %q, want it trimmed", stream.Title)
+	}
+}
+
+// Test finding a user's live stream and resuming it instead of starting a duplicate
+func TestLivestreamService_GetActiveStream(t *testing.T) {
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	t.Run("no active stream", func(t *testing.T) {
+		active, err := testLivestreamService.GetActiveStream(ctx, userID)
+		if err != nil || active != nil {
+			t.Fatalf("GetActiveStream() = %v, %v, want nil", active, err)
+		}
+
+		// Scheduled streams aren't live
+		scheduled, err := testLivestreamService.ScheduleStream(userID, StartStreamRequest{Title: "Later"}, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("ScheduleStream() unexpected error = %v", err)
+		}
+		defer testLivestreamService.livestreamCollection.DeleteOne(ctx, bson.M{"_id": scheduled.ID})
+		if active, err := testLivestreamService.GetActiveStream(ctx, userID); err != nil || active != nil {
+			t.Errorf("GetActiveStream() with a scheduled stream = %v, %v, want nil", active, err)
+		}
+
+		// Resuming without a live stream starts one
+		started, err := testLivestreamService.StartStream(userID, StartStreamRequest{Title: "Fresh", Resume: true})
+		if err != nil {
+			t.Fatalf("StartStream() with resume unexpected error = %v", err)
+		}
+		defer testLivestreamService.livestreamCollection.DeleteOne(ctx, bson.M{"_id": started.ID})
+		if started.ID == scheduled.ID || started.Status != StreamStatusLive {
+			t.Errorf("StartStream() with resume = %+v, want a new live stream", started)
+		}
+		testLivestreamService.StopStream(userID, started.ID)
+	})
+
+	t.Run("already active", func(t *testing.T) {
+		stream, err := testLivestreamService.StartStream(userID, StartStreamRequest{Title: "Current " + generateTestSuffix()})
+		if err != nil {
+			t.Fatalf("StartStream() unexpected error = %v", err)
+		}
+		defer testLivestreamService.livestreamCollection.DeleteOne(ctx, bson.M{"_id": stream.ID})
+
+		active, err := testLivestreamService.GetActiveStream(ctx, userID)
+		if err != nil {
+			t.Fatalf("GetActiveStream() unexpected error = %v", err)
+		}
+		if active == nil || active.ID != stream.ID || active.StreamKey != stream.StreamKey {
+			t.Fatalf("GetActiveStream() = %+v, want stream %s with its key", active, stream.ID.Hex())
+		}
+
+		// A repeated start with resume returns the same stream, even without details
+		resumed, err := testLivestreamService.StartStream(userID, StartStreamRequest{Resume: true})
+		if err != nil {
+			t.Fatalf("StartStream() with resume unexpected error = %v", err)
+		}
+		if resumed.ID != stream.ID {
+			t.Errorf("StartStream() with resume = %s, want the active stream %s", resumed.ID.Hex(), stream.ID.Hex())
+		}
+		if live, _ := testLivestreamService.CountActiveStreams(userID); live != 1 {
+			t.Errorf("CountActiveStreams() = %d, want 1", live)
+		}
+
+		if other, err := testLivestreamService.GetActiveStream(ctx, primitive.NewObjectID()); err != nil || other != nil {
+			t.Errorf("GetActiveStream() for another user = %v, %v, want nil", other, err)
+		}
+	})
+}
+
 func TestLivestreamService_StartStream(t *testing.T) {
 	t.Log("Testing stream creation with real database")
 
@@ -153,25 +294,24 @@ func TestLivestreamService_StopStream(t *testing.T) {
 		name     string
 		userID   primitive.ObjectID
 		streamID primitive.ObjectID
-		wantErr  bool
+		wantErr  error
 	}{
 		{
 			name:     "valid stream stop",
 			userID:   testUserID,
 			streamID: stream.ID,
-			wantErr:  false,
 		},
 		{
 			name:     "unauthorized user",
 			userID:   primitive.NewObjectID(),
 			streamID: stream.ID,
-			wantErr:  true,
+			wantErr:  ErrNotStreamOwner,
 		},
 		{
 			name:     "non-existent stream",
 			userID:   testUserID,
 			streamID: primitive.NewObjectID(),
-			wantErr:  true,
+			wantErr:  ErrStreamNotFound,
 		},
 	}
 
@@ -179,11 +319,9 @@ func TestLivestreamService_StopStream(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			_, err := testLivestreamService.StopStream(tt.userID, tt.streamID)
 
-			if tt.wantErr {
-				if err == nil {
-					t.Errorf("StopStream() expected error, got nil")
-				} else {
-					t.Logf("Correctly handled invalid stop request: %v", err)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("StopStream() error = %v, want %v", err, tt.wantErr)
 				}
 				return
 			}
@@ -490,6 +628,13 @@ func generateTestSuffix() string {
 	return time.Now().Format("20060102150405")
 }
 
+// removeTestStream deletes a test stream and its data whatever its status
+func removeTestStream(streamID primitive.ObjectID) {
+	ctx := context.Background()
+	testLivestreamService.livestreamCollection.DeleteOne(ctx, bson.M{"_id": streamID})
+	testLivestreamService.deleteStreamData(ctx, streamID)
+}
+
 // ===== EXTENSIVE ADDITIONAL TESTS FOR COMPREHENSIVE COVERAGE =====
 
 // TestLivestreamService_StreamLifecycleManagement tests complex stream lifecycle scenarios
@@ -775,13 +920,13 @@ func TestLivestreamService_ChatSystemComprehensive(t *testing.T) {
 				name:     "empty message",
 				message:  "",
 				userName: "testuser",
-				wantErr:  false, // Should handle empty messages gracefully
+				wantErr:  true, // Empty messages aren't stored
 			},
 			{
 				name:     "long message",
 				message:  strings.Repeat("a", 1000),
 				userName: "testuser",
-				wantErr:  false,
+				wantErr:  true, // Over the default limit
 			},
 			{
 				name:     "special characters",
@@ -974,6 +1119,10 @@ func TestLivestreamService_SearchAndDiscovery(t *testing.T) {
 			testLivestreamService.AddViewer(createdStreams[i].ID)
 		}
 	}
+	// Popularity is ranked on the counts synced to the database
+	if err := testLivestreamService.FlushViewerCounts(context.Background()); err != nil {
+		t.Fatalf("Failed to flush viewer counts: %v", err)
+	}
 
 	t.Run("SearchByTitle", func(t *testing.T) {
 		searchQueries := []struct {
@@ -1185,34 +1334,133 @@ func TestLivestreamService_UserStreamManagement(t *testing.T) {
 	})
 
 	t.Run("UserStreamLimits", func(t *testing.T) {
-		// Test creating many streams for one user
-		maxStreams := 10
-		userID := user3ID
-		createdStreams := make([]*Livestream, 0, maxStreams)
+		maxStreams := 3
+		testLivestreamService.SetMaxConcurrentStreams(maxStreams)
+		defer testLivestreamService.SetMaxConcurrentStreams(0)
+		userID := primitive.NewObjectID()
+
+		// A scheduled stream doesn't count against the limit
+		scheduled, err := testLivestreamService.ScheduleStream(userID, StartStreamRequest{
+			Title: "Limit Test Scheduled " + generateTestSuffix(),
+		}, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("Failed to schedule stream: %v", err)
+		}
+		defer removeTestStream(scheduled.ID)
 
+		createdStreams := make([]*Livestream, 0, maxStreams)
 		for i := 0; i < maxStreams; i++ {
 			stream, err := testLivestreamService.StartStream(userID, StartStreamRequest{
 				Title:       fmt.Sprintf("Limit Test Stream %d %s", i+1, generateTestSuffix()),
 				Description: fmt.Sprintf("Testing stream limits - stream %d", i+1),
 			})
 			if err != nil {
-				t.Errorf("Failed to create stream %d: %v", i+1, err)
-			} else {
-				createdStreams = append(createdStreams, stream)
+				t.Fatalf("Failed to create stream %d within the limit: %v", i+1, err)
 			}
+			defer removeTestStream(stream.ID)
+			createdStreams = append(createdStreams, stream)
 		}
 
-		// Verify all streams were created
-		userStreams, err := testLivestreamService.GetUserStreams(userID)
+		_, err = testLivestreamService.StartStream(userID, StartStreamRequest{Title: "Over The Limit"})
+		if !errors.Is(err, ErrStreamLimitReached) {
+			t.Fatalf("StartStream() over the limit error = %v, want ErrStreamLimitReached", err)
+		}
+
+		// Other users have their own limit
+		other, err := testLivestreamService.StartStream(primitive.NewObjectID(), StartStreamRequest{Title: "Other User Stream"})
+		if err != nil {
+			t.Errorf("StartStream() for another user unexpected error = %v", err)
+		} else {
+			defer removeTestStream(other.ID)
+		}
+
+		// Stopping a stream frees up its slot
+		if _, err := testLivestreamService.StopStream(userID, createdStreams[0].ID); err != nil {
+			t.Fatalf("StopStream() unexpected error = %v", err)
+		}
+		stream, err := testLivestreamService.StartStream(userID, StartStreamRequest{Title: "After Stop"})
+		if err != nil {
+			t.Fatalf("StartStream() after stopping a stream unexpected error = %v", err)
+		}
+		defer removeTestStream(stream.ID)
+	})
+
+	t.Run("ConcurrentStartsRespectLimit", func(t *testing.T) {
+		maxStreams := 2
+		testLivestreamService.SetMaxConcurrentStreams(maxStreams)
+		defer testLivestreamService.SetMaxConcurrentStreams(0)
+		userID := primitive.NewObjectID()
+
+		var (
+			mu      sync.Mutex
+			started []*Livestream
+			wg      sync.WaitGroup
+		)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				stream, err := testLivestreamService.StartStream(userID, StartStreamRequest{
+					Title: fmt.Sprintf("Concurrent Limit %d %s", i, generateTestSuffix()),
+				})
+				if err != nil {
+					if !errors.Is(err, ErrStreamLimitReached) {
+						t.Errorf("StartStream() unexpected error = %v", err)
+					}
+					return
+				}
+				mu.Lock()
+				started = append(started, stream)
+				mu.Unlock()
+			}(i)
+		}
+		wg.Wait()
+		for _, stream := range started {
+			defer removeTestStream(stream.ID)
+		}
+
+		if len(started) != maxStreams {
+			t.Errorf("concurrent StartStream() started %d streams, want %d", len(started), maxStreams)
+		}
+	})
+
+	t.Run("PromotionRespectsLimit", func(t *testing.T) {
+		testLivestreamService.SetMaxConcurrentStreams(1)
+		defer testLivestreamService.SetMaxConcurrentStreams(0)
+		userID := primitive.NewObjectID()
+
+		live, err := testLivestreamService.StartStream(userID, StartStreamRequest{Title: "Live " + generateTestSuffix()})
 		if err != nil {
-			t.Errorf("Failed to get user streams: %v", err)
+			t.Fatalf("StartStream() unexpected error = %v", err)
 		}
+		defer removeTestStream(live.ID)
+		scheduled, err := testLivestreamService.ScheduleStream(userID, StartStreamRequest{
+			Title: "Scheduled " + generateTestSuffix(),
+		}, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("ScheduleStream() unexpected error = %v", err)
+		}
+		defer removeTestStream(scheduled.ID)
 
-		if len(userStreams) < maxStreams {
-			t.Errorf("Expected at least %d streams for user, got %d", maxStreams, len(userStreams))
+		ctx := context.Background()
+		promoted, err := testLivestreamService.PromoteScheduledStreams(ctx, []string{scheduled.StreamKey})
+		if err != nil {
+			t.Fatalf("PromoteScheduledStreams() unexpected error = %v", err)
+		}
+		if promoted != 0 {
+			t.Errorf("PromoteScheduledStreams() over the limit promoted %d streams, want 0", promoted)
 		}
 
-		t.Logf("Successfully tested user stream limits with %d streams", len(createdStreams))
+		if _, err := testLivestreamService.StopStream(userID, live.ID); err != nil {
+			t.Fatalf("StopStream() unexpected error = %v", err)
+		}
+		promoted, err = testLivestreamService.PromoteScheduledStreams(ctx, []string{scheduled.StreamKey})
+		if err != nil {
+			t.Fatalf("PromoteScheduledStreams() unexpected error = %v", err)
+		}
+		if promoted != 1 {
+			t.Errorf("PromoteScheduledStreams() after a stop promoted %d streams, want 1", promoted)
+		}
 	})
 }
 
@@ -1473,13 +1721,20 @@ func TestLivestreamService_DatabaseConsistencyAdvanced(t *testing.T) {
 			t.Logf("Viewer count with inconsistent data: %d", count)
 		}
 
-		// Attempt recovery
+		// Viewer counts can't be set through UpdateStream, so recover in the database
 		err = testLivestreamService.UpdateStream(stream.ID, map[string]interface{}{
-			"viewer_count": 5, // Restore correct count
+			"viewer_count": 5,
 		})
+		if !errors.Is(err, ErrInvalidUpdate) {
+			t.Errorf("UpdateStream() of viewer_count error = %v, want ErrInvalidUpdate", err)
+		}
+		_, err = testLivestreamService.livestreamCollection.UpdateOne(ctx,
+			bson.M{"_id": stream.ID},
+			bson.M{"$set": bson.M{"viewer_count": 5}}) // Restore correct count
 		if err != nil {
 			t.Errorf("Failed to recover from inconsistent state: %v", err)
 		}
+		testLivestreamService.viewers.Forget(stream.ID)
 
 		// Verify recovery
 		recoveredCount, err := testLivestreamService.GetViewerCount(stream.ID)
@@ -1618,7 +1873,8 @@ func TestLivestreamService_PerformanceAndStress(t *testing.T) {
 					err = testLivestreamService.RemoveViewer(stream.ID)
 				}
 				
-				if err == nil {
+				// Leaving an empty stream is a no-op rather than a failure
+				if err == nil || errors.Is(err, ErrNoViewers) {
 					atomic.AddInt32(&successCount, 1)
 				}
 			}(i)
@@ -1929,7 +2185,7 @@ func TestLivestreamService_ErrorHandlingAndRecovery(t *testing.T) {
 
 // TestLivestreamService_StreamManagerIntegration tests integration with StreamManager
 func TestLivestreamService_StreamManagerIntegration(t *testing.T) {
-	streamManager := NewStreamManager(testLivestreamService)
+	streamManager := NewStreamManager(testLivestreamService, nil)
 
 	t.Run("StreamManagerBasicOperations", func(t *testing.T) {
 		// Create a test stream
@@ -2301,3 +2557,2312 @@ func TestLivestreamService_ComplexWorkflows(t *testing.T) {
 		t.Logf("Successfully completed multi-user interaction workflow with %d users", len(users))
 	})
 }
+
+func TestLivestreamService_IngestCodecValidation(t *testing.T) {
+	allowList := config.LivestreamConfig{
+		AllowedVideoCodecs: []string{"h264"},
+		AllowedAudioCodecs: []string{"aac"},
+	}
+
+	tests := []struct {
+		name          string
+		policy        string
+		metadata      map[string]interface{}
+		wantErr       bool
+		wantTranscode bool
+	}{
+		{
+			name:     "allowed numeric codec IDs",
+			policy:   "strict",
+			metadata: map[string]interface{}{"videocodecid": float64(7), "audiocodecid": float64(10)},
+		},
+		{
+			name:     "allowed FourCC codecs",
+			policy:   "strict",
+			metadata: map[string]interface{}{"videocodecid": "avc1", "audiocodecid": "mp4a"},
+		},
+		{
+			name:     "disallowed codec rejected under strict policy",
+			policy:   "strict",
+			metadata: map[string]interface{}{"videocodecid": float64(12), "audiocodecid": float64(2)},
+			wantErr:  true,
+		},
+		{
+			name:     "disallowed video codec rejected under lenient policy",
+			policy:   "lenient",
+			metadata: map[string]interface{}{"videocodecid": float64(12), "audiocodecid": float64(10)},
+			wantErr:  true,
+		},
+		{
+			name:          "disallowed audio codec accepted for transcode under lenient policy",
+			policy:        "lenient",
+			metadata:      map[string]interface{}{"videocodecid": float64(7), "audiocodecid": float64(2)},
+			wantTranscode: true,
+		},
+		{
+			name:     "missing codec metadata is not judged",
+			policy:   "strict",
+			metadata: map[string]interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := allowList
+			cfg.CodecPolicy = tt.policy
+			validator := NewCodecValidator(cfg)
+
+			transcode, err := validator.Validate(IngestCodecsFromMetadata(tt.metadata))
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnsupportedCodec) {
+					t.Fatalf("Validate() error = %v, want ErrUnsupportedCodec", err)
+				}
+				if !strings.Contains(err.Error(), "hevc") {
+					t.Errorf("Validate() error should name the rejected codec, got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate() unexpected error = %v", err)
+			}
+			if transcode != tt.wantTranscode {
+				t.Errorf("Validate() transcode = %v, want %v", transcode, tt.wantTranscode)
+			}
+		})
+	}
+}
+
+// TestLivestreamService_LifecycleWebhooks tests that stream start/end fire signed webhooks
+func TestLivestreamService_LifecycleWebhooks(t *testing.T) {
+	const secret = "test-webhook-secret"
+
+	type delivery struct {
+		event    StreamEvent
+		verified bool
+	}
+	deliveries := make(chan delivery, 4)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var event StreamEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		deliveries <- delivery{
+			event:    event,
+			verified: webhooks.Verify(secret, body, r.Header.Get(webhooks.SignatureHeader)),
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	streamManager := NewStreamManager(testLivestreamService, webhooks.NewWebhookDispatcher(webhooks.NewClient([]string{receiver.URL}, secret, 3), ""))
+
+	stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Webhook Test " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test stream: %v", err)
+	}
+	defer removeTestStream(stream.ID)
+
+	expectEvent := func(want string) {
+		t.Helper()
+		select {
+		case d := <-deliveries:
+			if d.event.Event != want {
+				t.Errorf("Expected event %s, got %s", want, d.event.Event)
+			}
+			if !d.verified {
+				t.Errorf("Webhook %s has an invalid signature", d.event.Event)
+			}
+			if d.event.StreamID != stream.ID.Hex() {
+				t.Errorf("Expected stream_id %s, got %s", stream.ID.Hex(), d.event.StreamID)
+			}
+			if d.event.UserID != testUserID.Hex() {
+				t.Errorf("Expected user_id %s, got %s", testUserID.Hex(), d.event.UserID)
+			}
+			if d.event.Ts.IsZero() {
+				t.Error("Expected ts to be set")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s webhook", want)
+		}
+	}
+
+	streamManager.HandleStreamStart(stream.StreamKey, stream.ID)
+	expectEvent(EventStreamStarted)
+
+	streamManager.HandleStreamEnd(stream.StreamKey)
+	expectEvent(EventStreamEnded)
+}
+
+// TestLivestreamService_StreamTags tests tag normalization and tag-based discovery
+func TestLivestreamService_StreamTags(t *testing.T) {
+	ctx := context.Background()
+
+	live, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Tagged Live Stream " + generateTestSuffix(),
+		Tags:  []string{"Speedrun", " #speedrun ", "retro", ""},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create live stream: %v", err)
+	}
+	defer removeTestStream(live.ID)
+
+	if len(live.Tags) != 2 || live.Tags[0] != "speedrun" || live.Tags[1] != "retro" {
+		t.Errorf("Expected normalized tags [speedrun retro], got %v", live.Tags)
+	}
+
+	ended, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Tagged Ended Stream " + generateTestSuffix(),
+		Tags:  []string{"speedrun"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create ended stream: %v", err)
+	}
+	defer removeTestStream(ended.ID)
+
+	if _, err := testLivestreamService.StopStream(testUserID, ended.ID); err != nil {
+		t.Fatalf("Failed to stop stream: %v", err)
+	}
+
+	streams, err := testLivestreamService.ListStreamsByTag(ctx, "SpeedRun")
+	if err != nil {
+		t.Fatalf("ListStreamsByTag() unexpected error = %v", err)
+	}
+
+	foundLive := false
+	for _, s := range streams {
+		if s.ID == live.ID {
+			foundLive = true
+		}
+		if s.ID == ended.ID {
+			t.Error("Ended stream should not be listed under its tag")
+		}
+	}
+	if !foundLive {
+		t.Error("Live stream tagged speedrun should be listed under that tag")
+	}
+
+	t.Run("SetStreamTags", func(t *testing.T) {
+		many := make([]string, 0, MaxStreamTags+5)
+		for i := 0; i < MaxStreamTags+5; i++ {
+			many = append(many, fmt.Sprintf("tag%d", i))
+		}
+
+		// Too many tags are rejected rather than cut short
+		if _, err := testLivestreamService.SetStreamTags(ctx, testUserID, live.ID, many); !errors.Is(err, tags.ErrTooMany) {
+			t.Errorf("SetStreamTags() with %d tags error = %v, want tags.ErrTooMany", len(many), err)
+		}
+		if _, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{Title: "Too Many Tags", Tags: many}); !errors.Is(err, tags.ErrTooMany) {
+			t.Errorf("StartStream() with %d tags error = %v, want tags.ErrTooMany", len(many), err)
+		}
+
+		stored, err := testLivestreamService.GetStreamStatus(live.ID)
+		if err != nil {
+			t.Fatalf("Failed to get stream: %v", err)
+		}
+		if len(stored.Tags) != 2 {
+			t.Errorf("Rejected tags replaced the stored ones: %v", stored.Tags)
+		}
+
+		set, err := testLivestreamService.SetStreamTags(ctx, testUserID, live.ID, many[:MaxStreamTags])
+		if err != nil {
+			t.Fatalf("SetStreamTags() unexpected error = %v", err)
+		}
+		if len(set) != MaxStreamTags {
+			t.Errorf("SetStreamTags() stored %d tags, want %d", len(set), MaxStreamTags)
+		}
+		stored, err = testLivestreamService.GetStreamStatus(live.ID)
+		if err != nil {
+			t.Fatalf("Failed to get stream: %v", err)
+		}
+		if len(stored.Tags) != MaxStreamTags || stored.Tags[0] != "tag0" {
+			t.Errorf("Expected stored tags to be replaced, got %v", stored.Tags)
+		}
+
+		if _, err := testLivestreamService.SetStreamTags(ctx, primitive.NewObjectID(), live.ID, []string{"x"}); !errors.Is(err, ErrNotStreamOwner) {
+			t.Errorf("SetStreamTags() by another user error = %v, want ErrNotStreamOwner", err)
+		}
+		if _, err := testLivestreamService.SetStreamTags(ctx, testUserID, primitive.NewObjectID(), []string{"x"}); !errors.Is(err, ErrStreamNotFound) {
+			t.Errorf("SetStreamTags() of an unknown stream error = %v, want ErrStreamNotFound", err)
+		}
+	})
+}
+
+// Test realtime chat fan-out
+func TestLivestreamService_ChatHub(t *testing.T) {
+	stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Chat Hub Stream " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(stream.ID)
+
+	hub := NewChatHub(testLivestreamService)
+	newClient := func(streamID primitive.ObjectID, buffer int) *Client {
+		client := &Client{send: make(chan []byte, buffer), streamID: streamID}
+		hub.Subscribe(client)
+		return client
+	}
+
+	viewerA := newClient(stream.ID, 8)
+	viewerB := newClient(stream.ID, 8)
+	elsewhere := newClient(primitive.NewObjectID(), 8)
+
+	if got := hub.SubscriberCount(stream.ID); got != 2 {
+		t.Errorf("SubscriberCount() = %d, want 2", got)
+	}
+
+	if err := hub.Publish(stream.ID, testUserID, "streamer", "  hello chat  "); err != nil {
+		t.Fatalf("Publish() unexpected error = %v", err)
+	}
+
+	for name, viewer := range map[string]*Client{"A": viewerA, "B": viewerB} {
+		select {
+		case raw := <-viewer.send:
+			var msg WebSocketMessage
+			var event ChatEvent
+			if err := json.Unmarshal(raw, &msg); err != nil || json.Unmarshal(msg.Payload, &event) != nil {
+				t.Fatalf("Viewer %s received malformed message: %s", name, raw)
+			}
+			if msg.Type != "chat_message" || event.Message != "hello chat" || event.UserName != "streamer" {
+				t.Errorf("Viewer %s received %s %+v", name, msg.Type, event)
+			}
+		default:
+			t.Errorf("Viewer %s did not receive the message", name)
+		}
+	}
+	select {
+	case raw := <-elsewhere.send:
+		t.Errorf("Viewer of another stream received %s", raw)
+	default:
+	}
+
+	messages, err := testLivestreamService.GetMessages(stream.ID)
+	if err != nil {
+		t.Fatalf("GetMessages() unexpected error = %v", err)
+	}
+	if len(messages) != 1 || messages[0].Message != "hello chat" {
+		t.Errorf("Persisted messages = %v, want the published message", messages)
+	}
+
+	t.Run("Invalid messages are rejected", func(t *testing.T) {
+		for _, text := range []string{"   ", strings.Repeat("x", DefaultMaxChatMessageLength+1)} {
+			if err := hub.Publish(stream.ID, testUserID, "streamer", text); !errors.Is(err, ErrInvalidChatMessage) {
+				t.Errorf("Publish(%d chars) error = %v, want ErrInvalidChatMessage", len(text), err)
+			}
+		}
+	})
+
+	t.Run("Slow clients are dropped", func(t *testing.T) {
+		slow := newClient(stream.ID, 0)
+		hub.Broadcast(stream.ID, []byte("ping"))
+		if _, open := <-slow.send; open {
+			t.Error("Slow client's send channel should be closed")
+		}
+		if hub.Send(slow, []byte("again")) {
+			t.Error("Send() to a dropped client should fail")
+		}
+	})
+
+	t.Run("Unsubscribe cleans up", func(t *testing.T) {
+		hub.Unsubscribe(viewerA)
+		hub.Unsubscribe(viewerA) // Must not panic on a second call
+		hub.Unsubscribe(viewerB)
+		if got := hub.SubscriberCount(stream.ID); got != 0 {
+			t.Errorf("SubscriberCount() after unsubscribe = %d, want 0", got)
+		}
+		for _, viewer := range []*Client{viewerA, viewerB} {
+			for range viewer.send {
+				// Drain buffered messages; the loop ends once the channel is closed
+			}
+		}
+	})
+}
+
+// Test chat history paging and reconnect catch-up
+func TestLivestreamService_ChatPagination(t *testing.T) {
+	stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Chat Pagination Stream " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(stream.ID)
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		if err := testLivestreamService.SendChatMessage(stream.ID, testUserID, "viewer", fmt.Sprintf("message %d", i)); err != nil {
+			t.Fatalf("SendChatMessage() unexpected error = %v", err)
+		}
+		// Keep timestamps distinct at MongoDB's millisecond precision
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	first, err := testLivestreamService.GetMessagesPaginated(ctx, stream.ID, primitive.NilObjectID, 2)
+	if err != nil {
+		t.Fatalf("GetMessagesPaginated() unexpected error = %v", err)
+	}
+	if len(first.Messages) != 2 || first.Messages[0].Message != "message 4" || first.Messages[1].Message != "message 3" {
+		t.Fatalf("First page = %v, want messages 4 and 3", first.Messages)
+	}
+	if first.NextCursor == "" {
+		t.Fatal("First page should have a next cursor")
+	}
+
+	// Walk the remaining pages and make sure every message is seen exactly once
+	seen := []string{first.Messages[0].Message, first.Messages[1].Message}
+	cursor := first.NextCursor
+	for cursor != "" {
+		beforeID, _ := primitive.ObjectIDFromHex(cursor)
+		page, err := testLivestreamService.GetMessagesPaginated(ctx, stream.ID, beforeID, 2)
+		if err != nil {
+			t.Fatalf("GetMessagesPaginated(%s) unexpected error = %v", cursor, err)
+		}
+		for _, message := range page.Messages {
+			seen = append(seen, message.Message)
+		}
+		cursor = page.NextCursor
+	}
+	want := []string{"message 4", "message 3", "message 2", "message 1", "message 0"}
+	if strings.Join(seen, ",") != strings.Join(want, ",") {
+		t.Errorf("Paged messages = %v, want %v", seen, want)
+	}
+
+	t.Run("Unknown cursor", func(t *testing.T) {
+		_, err := testLivestreamService.GetMessagesPaginated(ctx, stream.ID, primitive.NewObjectID(), 2)
+		if !errors.Is(err, ErrInvalidChatCursor) {
+			t.Errorf("GetMessagesPaginated() error = %v, want ErrInvalidChatCursor", err)
+		}
+	})
+
+	t.Run("Catch up since a message", func(t *testing.T) {
+		messages, err := testLivestreamService.GetMessagesSince(ctx, stream.ID, first.Messages[1].CreatedAt)
+		if err != nil {
+			t.Fatalf("GetMessagesSince() unexpected error = %v", err)
+		}
+		if len(messages) != 1 || messages[0].Message != "message 4" {
+			t.Errorf("GetMessagesSince() = %v, want only message 4", messages)
+		}
+	})
+}
+
+// Test that live viewer counts are kept in memory and synced to the database
+func TestLivestreamService_ViewerTracker(t *testing.T) {
+	stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Viewer Tracker Stream " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(stream.ID)
+
+	ctx := context.Background()
+	storedCount := func() int {
+		var stored Livestream
+		if err := testLivestreamService.livestreamCollection.FindOne(ctx, bson.M{"_id": stream.ID}).Decode(&stored); err != nil {
+			t.Fatalf("Failed to read stream: %v", err)
+		}
+		return stored.ViewerCount
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := testLivestreamService.AddViewer(stream.ID); err != nil {
+				t.Errorf("AddViewer() unexpected error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count, _ := testLivestreamService.GetViewerCount(stream.ID); count != 100 {
+		t.Errorf("Live viewer count = %d, want 100", count)
+	}
+
+	t.Run("Flush writes live counts", func(t *testing.T) {
+		if err := testLivestreamService.FlushViewerCounts(ctx); err != nil {
+			t.Fatalf("FlushViewerCounts() unexpected error = %v", err)
+		}
+		if got := storedCount(); got != 100 {
+			t.Errorf("Stored viewer count = %d, want 100", got)
+		}
+	})
+
+	t.Run("Count never goes negative", func(t *testing.T) {
+		for i := 0; i < 150; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				testLivestreamService.RemoveViewer(stream.ID)
+			}()
+		}
+		wg.Wait()
+
+		if count, _ := testLivestreamService.GetViewerCount(stream.ID); count != 0 {
+			t.Errorf("Viewer count after over-removing = %d, want 0", count)
+		}
+	})
+
+	t.Run("Stopping reconciles the count", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			testLivestreamService.AddViewer(stream.ID)
+		}
+		if _, err := testLivestreamService.StopStream(testUserID, stream.ID); err != nil {
+			t.Fatalf("StopStream() unexpected error = %v", err)
+		}
+		if got := storedCount(); got != 3 {
+			t.Errorf("Stored viewer count after stop = %d, want 3", got)
+		}
+		if _, tracked := testLivestreamService.viewers.Count(stream.ID); tracked {
+			t.Error("Ended stream should no longer be tracked in memory")
+		}
+	})
+}
+
+// Test that removing viewers from an empty stream never takes the count below zero
+func TestLivestreamService_RemoveViewerFloor(t *testing.T) {
+	stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Viewer Floor Stream " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(stream.ID)
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := testLivestreamService.AddViewer(stream.ID); err != nil {
+			t.Fatalf("AddViewer() unexpected error = %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	var noViewers int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := testLivestreamService.RemoveViewer(stream.ID)
+			if errors.Is(err, ErrNoViewers) {
+				atomic.AddInt32(&noViewers, 1)
+			} else if err != nil {
+				t.Errorf("RemoveViewer() unexpected error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if noViewers != 18 {
+		t.Errorf("ErrNoViewers returned %d times, want 18", noViewers)
+	}
+	if count, _ := testLivestreamService.GetViewerCount(stream.ID); count != 0 {
+		t.Errorf("Live viewer count = %d, want 0", count)
+	}
+
+	if err := testLivestreamService.FlushViewerCounts(ctx); err != nil {
+		t.Fatalf("FlushViewerCounts() unexpected error = %v", err)
+	}
+	var stored Livestream
+	if err := testLivestreamService.livestreamCollection.FindOne(ctx, bson.M{"_id": stream.ID}).Decode(&stored); err != nil {
+		t.Fatalf("Failed to read stream: %v", err)
+	}
+	if stored.ViewerCount != 0 {
+		t.Errorf("Stored viewer count = %d, want 0", stored.ViewerCount)
+	}
+
+	t.Run("Negative stored count is clamped", func(t *testing.T) {
+		// A count corrupted outside the service must not leak back out
+		testLivestreamService.viewers.Forget(stream.ID)
+		if _, err := testLivestreamService.livestreamCollection.UpdateOne(ctx,
+			bson.M{"_id": stream.ID}, bson.M{"$set": bson.M{"viewer_count": -3}}); err != nil {
+			t.Fatalf("Failed to corrupt viewer count: %v", err)
+		}
+
+		if err := testLivestreamService.RemoveViewer(stream.ID); !errors.Is(err, ErrNoViewers) {
+			t.Errorf("RemoveViewer() error = %v, want ErrNoViewers", err)
+		}
+		if count, _ := testLivestreamService.GetViewerCount(stream.ID); count != 0 {
+			t.Errorf("Viewer count = %d, want 0", count)
+		}
+	})
+}
+
+func TestLivestreamService_PeakViewerCount(t *testing.T) {
+	stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Peak Viewers Stream " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(stream.ID)
+
+	ctx := context.Background()
+	// Ramp up to 3 viewers, down to 1 and back up to 2
+	for _, step := range []int{1, 1, 1, -1, -1, 1} {
+		if step > 0 {
+			err = testLivestreamService.AddViewer(stream.ID)
+		} else {
+			err = testLivestreamService.RemoveViewer(stream.ID)
+		}
+		if err != nil {
+			t.Fatalf("Viewer change %d unexpected error = %v", step, err)
+		}
+	}
+
+	status, err := testLivestreamService.GetStreamStatus(stream.ID)
+	if err != nil {
+		t.Fatalf("GetStreamStatus() unexpected error = %v", err)
+	}
+	if status.ViewerCount != 2 || status.PeakViewerCount != 3 {
+		t.Errorf("Viewers = %d, peak = %d, want 2 and 3", status.ViewerCount, status.PeakViewerCount)
+	}
+
+	if err := testLivestreamService.FlushViewerCounts(ctx); err != nil {
+		t.Fatalf("FlushViewerCounts() unexpected error = %v", err)
+	}
+	var stored Livestream
+	if err := testLivestreamService.livestreamCollection.FindOne(ctx, bson.M{"_id": stream.ID}).Decode(&stored); err != nil {
+		t.Fatalf("Failed to read stream: %v", err)
+	}
+	if stored.ViewerCount != 2 || stored.PeakViewerCount != 3 {
+		t.Errorf("Stored viewers = %d, peak = %d, want 2 and 3", stored.ViewerCount, stored.PeakViewerCount)
+	}
+
+	t.Run("Stored peak is never lowered", func(t *testing.T) {
+		// Another instance may have seen more viewers
+		testLivestreamService.viewers.Forget(stream.ID)
+		if _, err := testLivestreamService.livestreamCollection.UpdateOne(ctx,
+			bson.M{"_id": stream.ID}, bson.M{"$set": bson.M{"peak_viewer_count": 10}}); err != nil {
+			t.Fatalf("Failed to raise peak: %v", err)
+		}
+		if err := testLivestreamService.AddViewer(stream.ID); err != nil {
+			t.Fatalf("AddViewer() unexpected error = %v", err)
+		}
+		if err := testLivestreamService.FlushViewerCounts(ctx); err != nil {
+			t.Fatalf("FlushViewerCounts() unexpected error = %v", err)
+		}
+
+		status, err := testLivestreamService.GetStreamStatus(stream.ID)
+		if err != nil {
+			t.Fatalf("GetStreamStatus() unexpected error = %v", err)
+		}
+		if status.PeakViewerCount != 10 {
+			t.Errorf("Peak = %d, want 10", status.PeakViewerCount)
+		}
+	})
+}
+
+// Test that stopping a recorded stream publishes its recording as a video
+func TestLivestreamService_RecordingToVOD(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("No recording running", func(t *testing.T) {
+		stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+			Title: "Unrecorded Stream " + generateTestSuffix(),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create stream: %v", err)
+		}
+		defer removeTestStream(stream.ID)
+
+		if _, err := testLivestreamService.StopStream(testUserID, stream.ID); err != nil {
+			t.Fatalf("StopStream() unexpected error = %v", err)
+		}
+		if _, err := testLivestreamService.GetStreamRecording(ctx, stream.ID, testUserID); !errors.Is(err, video.ErrNotFound) {
+			t.Errorf("GetStreamRecording() error = %v, want video.ErrNotFound", err)
+		}
+	})
+
+	t.Run("Recording becomes a video", func(t *testing.T) {
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			t.Skip("ffmpeg not available")
+		}
+
+		stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+			Title:       "Recorded Stream " + generateTestSuffix(),
+			Description: "Recorded for VOD",
+		})
+		if err != nil {
+			t.Fatalf("Failed to create stream: %v", err)
+		}
+		defer removeTestStream(stream.ID)
+
+		// Stand in for a finished ffmpeg recording
+		outputPath := filepath.Join(t.TempDir(), "recording.mp4")
+		cmd := exec.Command("ffmpeg",
+			"-f", "lavfi", "-i", "color=c=black:s=16x16:r=1",
+			"-t", "2",
+			"-c:v", "libx264", "-pix_fmt", "yuv420p",
+			"-y", outputPath)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Failed to generate test recording: %v - %s", err, out)
+		}
+		recorder := testLivestreamService.recorderService
+		recorder.mu.Lock()
+		recorder.recordings[stream.ID.Hex()] = &RecorderSession{
+			StreamID:    stream.ID,
+			OutputPath:  outputPath,
+			StartTime:   time.Now(),
+			IsRecording: true,
+		}
+		recorder.mu.Unlock()
+
+		stopped, err := testLivestreamService.StopStream(testUserID, stream.ID)
+		if err != nil {
+			t.Fatalf("StopStream() unexpected error = %v", err)
+		}
+		if stopped.Status != StreamStatusEnded || stopped.EndedAt == nil {
+			t.Errorf("Stopped stream status = %s, ended_at = %v", stopped.Status, stopped.EndedAt)
+		}
+
+		// The recording is published before StopStream returns
+		vod, err := testLivestreamService.GetStreamRecording(ctx, stream.ID, testUserID)
+		if err != nil {
+			t.Fatalf("Recording was not published as a video: %v", err)
+		}
+
+		if vod.Status != video.StatusCompleted {
+			t.Errorf("Video status = %s, want %s", vod.Status, video.StatusCompleted)
+		}
+		if vod.UserID != testUserID || vod.Title != stream.Title {
+			t.Errorf("Video owner/title = %s/%q, want %s/%q", vod.UserID.Hex(), vod.Title, testUserID.Hex(), stream.Title)
+		}
+		if vod.SourceStreamID == nil || *vod.SourceStreamID != stream.ID {
+			t.Errorf("Video SourceStreamID = %v, want %s", vod.SourceStreamID, stream.ID.Hex())
+		}
+		if vod.Metadata.Duration <= 0 {
+			t.Errorf("Video duration = %v, want probed duration", vod.Metadata.Duration)
+		}
+
+		// A private recording is hidden from everyone but its owner
+		if _, err := testLivestreamService.videoService.UpdateVideo(ctx, vod.ID, testUserID, video.UpdateVideoRequest{Visibility: video.VisibilityPrivate}); err != nil {
+			t.Fatalf("Failed to make the recording private: %v", err)
+		}
+		if _, err := testLivestreamService.GetStreamRecording(ctx, stream.ID, primitive.NewObjectID()); !errors.Is(err, video.ErrNotFound) {
+			t.Errorf("GetStreamRecording() of a private recording by another user error = %v, want video.ErrNotFound", err)
+		}
+		if _, err := testLivestreamService.GetStreamRecording(ctx, stream.ID, testUserID); err != nil {
+			t.Errorf("GetStreamRecording() of a private recording by its owner error = %v", err)
+		}
+		if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+			t.Error("Recording file should be removed once published")
+		}
+	})
+}
+
+// TestLivestreamService_IngestRecording tests recording published streams from their FLV tags
+func TestLivestreamService_IngestRecording(t *testing.T) {
+	ctx := context.Background()
+	streamManager := NewStreamManager(testLivestreamService, nil)
+
+	t.Run("Tags are written as FLV", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "ingest.flv")
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+		if _, err := file.Write(flvHeader); err != nil {
+			t.Fatalf("Failed to write header: %v", err)
+		}
+		ingest := &ingestRecording{input: file}
+		// A keyframe AVC NAL unit packet, with a timestamp using the extended byte
+		payload := []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x65}
+		if err := ingest.writeTag(flvtag.TagTypeVideo, 0x01020304, payload); err != nil {
+			t.Fatalf("writeTag() unexpected error = %v", err)
+		}
+		ingest.close()
+		if err := ingest.writeTag(flvtag.TagTypeVideo, 0, payload); err != nil {
+			t.Errorf("writeTag() after close error = %v, want it ignored", err)
+		}
+
+		recorded, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("Failed to open file: %v", err)
+		}
+		defer recorded.Close()
+		decoder, err := flv.NewDecoder(recorded)
+		if err != nil {
+			t.Fatalf("Recording has an invalid FLV header: %v", err)
+		}
+		var tag flvtag.FlvTag
+		if err := decoder.Decode(&tag); err != nil {
+			t.Fatalf("Decode() unexpected error = %v", err)
+		}
+		defer tag.Close()
+		videoData, ok := tag.Data.(*flvtag.VideoData)
+		if tag.TagType != flvtag.TagTypeVideo || tag.Timestamp != 0x01020304 || !ok || videoData.FrameType != flvtag.FrameTypeKeyFrame {
+			t.Errorf("Decoded tag = %+v, want the keyframe written", tag)
+		}
+	})
+
+	t.Run("Not recorded when disabled", func(t *testing.T) {
+		stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+			Title: "Unrecorded Stream " + generateTestSuffix(),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create stream: %v", err)
+		}
+		defer removeTestStream(stream.ID)
+
+		streamManager.HandleStreamStart(stream.StreamKey, stream.ID)
+		defer streamManager.HandleStreamEnd(stream.StreamKey)
+		if _, err := testLivestreamService.recorderService.GetRecordingStatus(stream.ID); err == nil {
+			t.Error("Stream is recorded with recording disabled")
+		}
+	})
+
+	t.Run("Published stream becomes a video", func(t *testing.T) {
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			t.Skip("ffmpeg not available")
+		}
+		testLivestreamService.recordStreams = true
+		defer func() { testLivestreamService.recordStreams = false }()
+
+		stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+			Title: "Ingest Recorded Stream " + generateTestSuffix(),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create stream: %v", err)
+		}
+		defer removeTestStream(stream.ID)
+
+		// Stand in for an encoder with an FLV file of what it would publish
+		source := filepath.Join(t.TempDir(), "publish.flv")
+		cmd := exec.Command("ffmpeg",
+			"-f", "lavfi", "-i", "color=c=black:s=16x16:r=10",
+			"-t", "2",
+			"-c:v", "libx264", "-pix_fmt", "yuv420p",
+			"-f", "flv", "-y", source)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Failed to generate test stream: %v - %s", err, out)
+		}
+		data, err := os.ReadFile(source)
+		if err != nil {
+			t.Fatalf("Failed to read test stream: %v", err)
+		}
+
+		streamManager.HandleStreamStart(stream.StreamKey, stream.ID)
+		defer streamManager.HandleStreamEnd(stream.StreamKey)
+		if _, err := testLivestreamService.recorderService.GetRecordingStatus(stream.ID); err != nil {
+			t.Fatalf("Recording was not started: %v", err)
+		}
+
+		// Replay the FLV tags after the header
+		for rest := data[13:]; len(rest) >= 11; {
+			size := int(rest[1])<<16 | int(rest[2])<<8 | int(rest[3])
+			timestamp := uint32(rest[7])<<24 | uint32(rest[4])<<16 | uint32(rest[5])<<8 | uint32(rest[6])
+			if tagType := flvtag.TagType(rest[0]); tagType == flvtag.TagTypeVideo || tagType == flvtag.TagTypeAudio {
+				streamManager.WriteRecordingTag(stream.StreamKey, tagType, timestamp, rest[11:11+size])
+			}
+			rest = rest[11+size+4:]
+		}
+
+		if _, err := testLivestreamService.StopStream(testUserID, stream.ID); err != nil {
+			t.Fatalf("StopStream() unexpected error = %v", err)
+		}
+		vod, err := testLivestreamService.GetStreamRecording(ctx, stream.ID, testUserID)
+		if err != nil {
+			t.Fatalf("Recording was not published as a video: %v", err)
+		}
+		if vod.Metadata.Duration <= 0 {
+			t.Errorf("Video duration = %v, want probed duration", vod.Metadata.Duration)
+		}
+	})
+}
+
+// Test that a stop whose recording can't be published leaves the stream untouched
+func TestLivestreamService_StopStreamAtomic(t *testing.T) {
+	ctx := context.Background()
+
+	stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Atomic Stop " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(stream.ID)
+
+	// A recording whose file is missing fails to publish after the status update
+	recorder := testLivestreamService.recorderService
+	recorder.mu.Lock()
+	recorder.recordings[stream.ID.Hex()] = &RecorderSession{
+		StreamID:    stream.ID,
+		OutputPath:  filepath.Join(t.TempDir(), "missing.mp4"),
+		StartTime:   time.Now(),
+		IsRecording: true,
+	}
+	recorder.mu.Unlock()
+	defer recorder.StopRecording(stream.ID)
+
+	if _, err := testLivestreamService.StopStream(testUserID, stream.ID); err == nil {
+		t.Fatal("StopStream() expected error for an unpublishable recording")
+	}
+
+	stored, err := testLivestreamService.GetStreamStatus(stream.ID)
+	if err != nil {
+		t.Fatalf("GetStreamStatus() unexpected error = %v", err)
+	}
+	if stored.Status != StreamStatusLive {
+		t.Errorf("Stream status = %s, want %s", stored.Status, StreamStatusLive)
+	}
+	if stored.EndedAt != nil {
+		t.Errorf("Stream ended_at = %v, want unset", stored.EndedAt)
+	}
+	if _, err := testLivestreamService.GetStreamRecording(ctx, stream.ID, testUserID); !errors.Is(err, video.ErrNotFound) {
+		t.Errorf("GetStreamRecording() error = %v, want video.ErrNotFound", err)
+	}
+	// The recording is kept so the stop can be retried
+	if _, err := recorder.GetRecordingStatus(stream.ID); err != nil {
+		t.Errorf("Recording session should be kept after a failed stop: %v", err)
+	}
+}
+
+// Test that a publish ending stops the stream the way StopStream does
+func TestLivestreamService_PublishEndStopsStream(t *testing.T) {
+	ctx := context.Background()
+	streamManager := NewStreamManager(testLivestreamService, nil)
+
+	t.Run("Stream is stopped", func(t *testing.T) {
+		stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+			Title: "Publish End " + generateTestSuffix(),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create stream: %v", err)
+		}
+		defer removeTestStream(stream.ID)
+
+		streamManager.HandleStreamStart(stream.StreamKey, stream.ID)
+		streamManager.HandleStreamEnd(stream.StreamKey)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			stored, err := testLivestreamService.GetStreamStatus(stream.ID)
+			if err != nil {
+				t.Fatalf("GetStreamStatus() unexpected error = %v", err)
+			}
+			if stored.Status == StreamStatusEnded && stored.EndedAt != nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Stream status = %s after the publish ended, want %s", stored.Status, StreamStatusEnded)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	})
+
+	t.Run("Failed stop leaves the stream live", func(t *testing.T) {
+		stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+			Title: "Publish End Failure " + generateTestSuffix(),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create stream: %v", err)
+		}
+		defer removeTestStream(stream.ID)
+
+		recorder := testLivestreamService.recorderService
+		recorder.mu.Lock()
+		recorder.recordings[stream.ID.Hex()] = &RecorderSession{
+			StreamID:    stream.ID,
+			OutputPath:  filepath.Join(t.TempDir(), "missing.mp4"),
+			StartTime:   time.Now(),
+			IsRecording: true,
+		}
+		recorder.mu.Unlock()
+		defer recorder.StopRecording(stream.ID)
+
+		testLivestreamService.endPublishedStream(ctx, stream.ID)
+
+		stored, err := testLivestreamService.GetStreamStatus(stream.ID)
+		if err != nil {
+			t.Fatalf("GetStreamStatus() unexpected error = %v", err)
+		}
+		if stored.Status != StreamStatusLive || stored.EndedAt != nil {
+			t.Errorf("Stream status = %s, ended_at = %v, want it still live", stored.Status, stored.EndedAt)
+		}
+		if _, err := testLivestreamService.GetStreamRecording(ctx, stream.ID, testUserID); !errors.Is(err, video.ErrNotFound) {
+			t.Errorf("GetStreamRecording() error = %v, want video.ErrNotFound", err)
+		}
+		if _, err := recorder.GetRecordingStatus(stream.ID); err != nil {
+			t.Errorf("Recording session should be kept after a failed stop: %v", err)
+		}
+	})
+}
+
+func TestLivestreamService_ScheduleStream(t *testing.T) {
+	ctx := context.Background()
+	suffix := generateTestSuffix()
+
+	t.Run("rejects start time in the past", func(t *testing.T) {
+		_, err := testLivestreamService.ScheduleStream(testUserID, StartStreamRequest{Title: "Past " + suffix}, time.Now().Add(-time.Minute))
+		if !errors.Is(err, ErrScheduleInPast) {
+			t.Errorf("ScheduleStream() error = %v, want %v", err, ErrScheduleInPast)
+		}
+	})
+
+	later, err := testLivestreamService.ScheduleStream(testUserID, StartStreamRequest{Title: "Later " + suffix}, time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("ScheduleStream() unexpected error = %v", err)
+	}
+	defer removeTestStream(later.ID)
+
+	sooner, err := testLivestreamService.ScheduleStream(testUserID, StartStreamRequest{Title: "Sooner " + suffix}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("ScheduleStream() unexpected error = %v", err)
+	}
+	defer removeTestStream(sooner.ID)
+
+	t.Run("scheduled streams have not started", func(t *testing.T) {
+		stream, err := testLivestreamService.GetStreamStatus(sooner.ID)
+		if err != nil {
+			t.Fatalf("GetStreamStatus() unexpected error = %v", err)
+		}
+		if stream.Status != StreamStatusScheduled {
+			t.Errorf("Status = %s, want %s", stream.Status, StreamStatusScheduled)
+		}
+		if stream.StartedAt != nil {
+			t.Errorf("StartedAt = %v, want nil", stream.StartedAt)
+		}
+		if stream.StreamKey == "" {
+			t.Error("Scheduled stream should have a stream key")
+		}
+	})
+
+	t.Run("upcoming streams are ordered by start time", func(t *testing.T) {
+		streams, err := testLivestreamService.GetUpcomingStreams(ctx, 1000)
+		if err != nil {
+			t.Fatalf("GetUpcomingStreams() unexpected error = %v", err)
+		}
+
+		soonerIndex, laterIndex := -1, -1
+		for i, stream := range streams {
+			if stream.Status != StreamStatusScheduled {
+				t.Errorf("Upcoming stream %s has status %s", stream.ID.Hex(), stream.Status)
+			}
+			switch stream.ID {
+			case sooner.ID:
+				soonerIndex = i
+			case later.ID:
+				laterIndex = i
+			}
+		}
+		if soonerIndex == -1 || laterIndex == -1 {
+			t.Fatalf("Upcoming streams missing scheduled streams (sooner=%d, later=%d)", soonerIndex, laterIndex)
+		}
+		if soonerIndex > laterIndex {
+			t.Errorf("Sooner stream listed at %d after later stream at %d", soonerIndex, laterIndex)
+		}
+	})
+
+	t.Run("publishing promotes the stream to live", func(t *testing.T) {
+		streamManager := NewStreamManager(testLivestreamService, nil)
+		promoter := NewScheduledStreamPromoter(testLivestreamService, streamManager)
+
+		promoted, err := promoter.PromoteOnce(ctx)
+		if err != nil {
+			t.Fatalf("PromoteOnce() unexpected error = %v", err)
+		}
+		if promoted != 0 {
+			t.Errorf("PromoteOnce() = %d with nothing publishing, want 0", promoted)
+		}
+
+		streamManager.HandleStreamStart(sooner.StreamKey, sooner.ID)
+		defer streamManager.HandleStreamEnd(sooner.StreamKey)
+
+		promoted, err = promoter.PromoteOnce(ctx)
+		if err != nil {
+			t.Fatalf("PromoteOnce() unexpected error = %v", err)
+		}
+		if promoted != 1 {
+			t.Errorf("PromoteOnce() = %d, want 1", promoted)
+		}
+
+		stream, err := testLivestreamService.GetStreamStatus(sooner.ID)
+		if err != nil {
+			t.Fatalf("GetStreamStatus() unexpected error = %v", err)
+		}
+		if stream.Status != StreamStatusLive {
+			t.Errorf("Status = %s, want %s", stream.Status, StreamStatusLive)
+		}
+		if stream.StartedAt == nil {
+			t.Error("StartedAt should be set once the stream goes live")
+		}
+
+		stream, err = testLivestreamService.GetStreamStatus(later.ID)
+		if err != nil {
+			t.Fatalf("GetStreamStatus() unexpected error = %v", err)
+		}
+		if stream.Status != StreamStatusScheduled {
+			t.Errorf("Unpublished stream status = %s, want %s", stream.Status, StreamStatusScheduled)
+		}
+	})
+}
+
+func TestLivestreamService_Categories(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("rejects categories outside the allow-list", func(t *testing.T) {
+		_, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{Title: "Cooking", Category: "cooking"})
+		if !errors.Is(err, ErrInvalidCategory) {
+			t.Errorf("StartStream() error = %v, want %v", err, ErrInvalidCategory)
+		}
+		if _, err := testLivestreamService.GetStreamsByCategory(ctx, "cooking"); !errors.Is(err, ErrInvalidCategory) {
+			t.Errorf("GetStreamsByCategory() error = %v, want %v", err, ErrInvalidCategory)
+		}
+	})
+
+	before, err := testLivestreamService.ListCategories(ctx)
+	if err != nil {
+		t.Fatalf("ListCategories() unexpected error = %v", err)
+	}
+	beforeCounts := make(map[string]int)
+	for _, category := range before {
+		beforeCounts[category.Category] = category.LiveCount
+	}
+	if len(before) != 3 {
+		t.Errorf("ListCategories() returned %d categories, want 3", len(before))
+	}
+
+	var gaming []*Livestream
+	for i := 0; i < 2; i++ {
+		stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{Title: "Gaming", Category: " Gaming "})
+		if err != nil {
+			t.Fatalf("StartStream() unexpected error = %v", err)
+		}
+		defer removeTestStream(stream.ID)
+		gaming = append(gaming, stream)
+	}
+	if gaming[0].Category != "gaming" {
+		t.Errorf("Category = %q, want normalized %q", gaming[0].Category, "gaming")
+	}
+
+	music, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{Title: "Music", Category: "music"})
+	if err != nil {
+		t.Fatalf("StartStream() unexpected error = %v", err)
+	}
+	defer removeTestStream(music.ID)
+
+	// Ended streams don't count towards their category
+	if _, err := testLivestreamService.StopStream(testUserID, gaming[1].ID); err != nil {
+		t.Fatalf("StopStream() unexpected error = %v", err)
+	}
+
+	t.Run("lists live streams in a category", func(t *testing.T) {
+		streams, err := testLivestreamService.GetStreamsByCategory(ctx, "gaming")
+		if err != nil {
+			t.Fatalf("GetStreamsByCategory() unexpected error = %v", err)
+		}
+		found := make(map[primitive.ObjectID]bool)
+		for _, stream := range streams {
+			if stream.Category != "gaming" || stream.Status != StreamStatusLive {
+				t.Errorf("Stream %s has category %q and status %s", stream.ID.Hex(), stream.Category, stream.Status)
+			}
+			found[stream.ID] = true
+		}
+		if !found[gaming[0].ID] {
+			t.Error("Live gaming stream missing from category")
+		}
+		if found[gaming[1].ID] || found[music.ID] {
+			t.Error("Category listing includes ended or other-category streams")
+		}
+	})
+
+	t.Run("counts live streams per category", func(t *testing.T) {
+		categories, err := testLivestreamService.ListCategories(ctx)
+		if err != nil {
+			t.Fatalf("ListCategories() unexpected error = %v", err)
+		}
+		counts := make(map[string]int)
+		for i, category := range categories {
+			counts[category.Category] = category.LiveCount
+			if i > 0 && categories[i-1].LiveCount < category.LiveCount {
+				t.Errorf("Categories not ordered by live count: %v", categories)
+			}
+		}
+		if counts["gaming"] != beforeCounts["gaming"]+1 {
+			t.Errorf("gaming live count = %d, want %d", counts["gaming"], beforeCounts["gaming"]+1)
+		}
+		if counts["music"] != beforeCounts["music"]+1 {
+			t.Errorf("music live count = %d, want %d", counts["music"], beforeCounts["music"]+1)
+		}
+		if _, ok := counts["art"]; !ok {
+			t.Error("Categories with nothing live should still be listed")
+		}
+	})
+}
+
+func TestLivestreamService_GetFollowedLiveStreams(t *testing.T) {
+	ctx := context.Background()
+
+	newUser := func(prefix string) primitive.ObjectID {
+		suffix := generateTestSuffix()
+		user, err := testUserService.CreateUser(ctx, users.CreateUserRequest{
+			UserName: prefix + "_" + suffix,
+			Email:    prefix + "_" + suffix + "@example.com",
+			Password: "password123",
+		})
+		if err != nil {
+			t.Fatalf("Failed to create test user: %v", err)
+		}
+		return user.ID
+	}
+	viewer := newUser("viewer")
+	followed := newUser("followed")
+	other := newUser("other")
+
+	t.Run("following nobody returns an empty list", func(t *testing.T) {
+		streams, err := testLivestreamService.GetFollowedLiveStreams(ctx, viewer)
+		if err != nil {
+			t.Fatalf("GetFollowedLiveStreams() unexpected error = %v", err)
+		}
+		if streams == nil || len(streams) != 0 {
+			t.Errorf("GetFollowedLiveStreams() = %v, want empty list", streams)
+		}
+	})
+
+	if _, err := testUserService.FollowUser(ctx, viewer, followed); err != nil {
+		t.Fatalf("FollowUser() unexpected error = %v", err)
+	}
+
+	quiet, err := testLivestreamService.StartStream(followed, StartStreamRequest{Title: "Quiet"})
+	if err != nil {
+		t.Fatalf("StartStream() unexpected error = %v", err)
+	}
+	defer removeTestStream(quiet.ID)
+
+	busy, err := testLivestreamService.StartStream(followed, StartStreamRequest{Title: "Busy"})
+	if err != nil {
+		t.Fatalf("StartStream() unexpected error = %v", err)
+	}
+	defer removeTestStream(busy.ID)
+	for i := 0; i < 3; i++ {
+		if err := testLivestreamService.AddViewer(busy.ID); err != nil {
+			t.Fatalf("AddViewer() unexpected error = %v", err)
+		}
+	}
+
+	ended, err := testLivestreamService.StartStream(followed, StartStreamRequest{Title: "Ended"})
+	if err != nil {
+		t.Fatalf("StartStream() unexpected error = %v", err)
+	}
+	defer removeTestStream(ended.ID)
+	if _, err := testLivestreamService.StopStream(followed, ended.ID); err != nil {
+		t.Fatalf("StopStream() unexpected error = %v", err)
+	}
+
+	unfollowed, err := testLivestreamService.StartStream(other, StartStreamRequest{Title: "Unfollowed"})
+	if err != nil {
+		t.Fatalf("StartStream() unexpected error = %v", err)
+	}
+	defer removeTestStream(unfollowed.ID)
+
+	t.Run("lists live streams of followed users by viewer count", func(t *testing.T) {
+		streams, err := testLivestreamService.GetFollowedLiveStreams(ctx, viewer)
+		if err != nil {
+			t.Fatalf("GetFollowedLiveStreams() unexpected error = %v", err)
+		}
+		if len(streams) != 2 {
+			t.Fatalf("GetFollowedLiveStreams() returned %d streams, want 2", len(streams))
+		}
+		if streams[0].ID != busy.ID || streams[1].ID != quiet.ID {
+			t.Errorf("GetFollowedLiveStreams() order = %s, %s, want busy then quiet", streams[0].Title, streams[1].Title)
+		}
+		if streams[0].ViewerCount != 3 {
+			t.Errorf("ViewerCount = %d, want 3", streams[0].ViewerCount)
+		}
+	})
+}
+
+func TestLivestreamService_GetStreamAnalytics(t *testing.T) {
+	ctx := context.Background()
+
+	stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Analytics Stream " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(stream.ID)
+
+	// Viewer counts of 1, 3 and 2 at three sample points
+	for _, change := range []int{1, 2, -1} {
+		for i := 0; i < change; i++ {
+			testLivestreamService.AddViewer(stream.ID)
+		}
+		for i := 0; i > change; i-- {
+			testLivestreamService.RemoveViewer(stream.ID)
+		}
+		if err := testLivestreamService.viewers.Sample(ctx); err != nil {
+			t.Fatalf("Sample() unexpected error = %v", err)
+		}
+	}
+
+	chatter1, chatter2 := primitive.NewObjectID(), primitive.NewObjectID()
+	for _, userID := range []primitive.ObjectID{chatter1, chatter2, chatter1} {
+		if err := testLivestreamService.SendChatMessage(stream.ID, userID, "chatter", "hi"); err != nil {
+			t.Fatalf("SendChatMessage() unexpected error = %v", err)
+		}
+	}
+
+	if _, err := testLivestreamService.GetStreamAnalytics(ctx, stream.ID, primitive.NewObjectID()); !errors.Is(err, ErrNotStreamOwner) {
+		t.Errorf("GetStreamAnalytics() by another user error = %v, want ErrNotStreamOwner", err)
+	}
+	if _, err := testLivestreamService.GetStreamAnalytics(ctx, primitive.NewObjectID(), testUserID); !errors.Is(err, ErrStreamNotFound) {
+		t.Errorf("GetStreamAnalytics() of unknown stream error = %v, want ErrStreamNotFound", err)
+	}
+
+	analytics, err := testLivestreamService.GetStreamAnalytics(ctx, stream.ID, testUserID)
+	if err != nil {
+		t.Fatalf("GetStreamAnalytics() unexpected error = %v", err)
+	}
+	if analytics.PeakViewers != 3 {
+		t.Errorf("PeakViewers = %d, want 3", analytics.PeakViewers)
+	}
+	// The background sampler may add a sample of its own, so only bound the average
+	if analytics.AverageViewers < 1 || analytics.AverageViewers > 3 {
+		t.Errorf("AverageViewers = %v, want between 1 and 3", analytics.AverageViewers)
+	}
+	if analytics.MessageCount != 3 || analytics.UniqueChatters != 2 {
+		t.Errorf("MessageCount/UniqueChatters = %d/%d, want 3/2", analytics.MessageCount, analytics.UniqueChatters)
+	}
+	if analytics.DurationSeconds <= 0 {
+		t.Errorf("DurationSeconds = %v, want > 0", analytics.DurationSeconds)
+	}
+}
+
+func TestLivestreamService_GetStreamByID(t *testing.T) {
+	ctx := context.Background()
+
+	stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Public Stream " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(stream.ID)
+	testLivestreamService.AddViewer(stream.ID)
+	defer testLivestreamService.RemoveViewer(stream.ID)
+
+	public, err := testLivestreamService.GetStreamByID(ctx, stream.ID)
+	if err != nil {
+		t.Fatalf("GetStreamByID() unexpected error = %v", err)
+	}
+	if public.ID != stream.ID || public.Title != stream.Title || public.Status != StreamStatusLive {
+		t.Errorf("GetStreamByID() = %+v, want stream %s", public, stream.ID.Hex())
+	}
+	if public.ViewerCount != 1 {
+		t.Errorf("ViewerCount = %d, want 1", public.ViewerCount)
+	}
+
+	data, err := json.Marshal(public)
+	if err != nil {
+		t.Fatalf("Failed to marshal stream: %v", err)
+	}
+	if strings.Contains(string(data), "StreamKey") || strings.Contains(string(data), stream.StreamKey) {
+		t.Errorf("Public stream JSON contains the stream key: %s", data)
+	}
+
+	if _, err := testLivestreamService.GetStreamByID(ctx, primitive.NewObjectID()); !errors.Is(err, ErrStreamNotFound) {
+		t.Errorf("GetStreamByID() of unknown stream error = %v, want ErrStreamNotFound", err)
+	}
+}
+
+func TestLivestreamService_DeleteStream(t *testing.T) {
+	ctx := context.Background()
+
+	stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Delete Stream " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(stream.ID)
+
+	testLivestreamService.AddViewer(stream.ID)
+	if err := testLivestreamService.viewers.Sample(ctx); err != nil {
+		t.Fatalf("Sample() unexpected error = %v", err)
+	}
+	if err := testLivestreamService.SendChatMessage(stream.ID, testUserID, "streamer", "bye"); err != nil {
+		t.Fatalf("SendChatMessage() unexpected error = %v", err)
+	}
+	recordingPath := filepath.Join(t.TempDir(), "recording.mp4")
+	if err := os.WriteFile(recordingPath, []byte("recording"), 0644); err != nil {
+		t.Fatalf("Failed to write recording: %v", err)
+	}
+	_, err = testLivestreamService.recorderService.recordingsCollection.InsertOne(ctx, Recording{
+		ID:        primitive.NewObjectID(),
+		StreamID:  stream.ID,
+		FilePath:  recordingPath,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to insert recording: %v", err)
+	}
+
+	t.Run("LiveStreamRefused", func(t *testing.T) {
+		err := testLivestreamService.DeleteStream(ctx, stream.ID, testUserID)
+		if !errors.Is(err, ErrStreamLive) {
+			t.Fatalf("DeleteStream() of a live stream error = %v, want ErrStreamLive", err)
+		}
+		if _, err := testLivestreamService.GetStreamStatus(stream.ID); err != nil {
+			t.Errorf("Live stream was deleted: %v", err)
+		}
+	})
+
+	if _, err := testLivestreamService.StopStream(testUserID, stream.ID); err != nil {
+		t.Fatalf("StopStream() unexpected error = %v", err)
+	}
+
+	t.Run("OwnerOnly", func(t *testing.T) {
+		err := testLivestreamService.DeleteStream(ctx, stream.ID, primitive.NewObjectID())
+		if !errors.Is(err, ErrNotStreamOwner) {
+			t.Errorf("DeleteStream() by another user error = %v, want ErrNotStreamOwner", err)
+		}
+		err = testLivestreamService.DeleteStream(ctx, primitive.NewObjectID(), testUserID)
+		if !errors.Is(err, ErrStreamNotFound) {
+			t.Errorf("DeleteStream() of unknown stream error = %v, want ErrStreamNotFound", err)
+		}
+	})
+
+	t.Run("RemovesStreamData", func(t *testing.T) {
+		if err := testLivestreamService.DeleteStream(ctx, stream.ID, testUserID); err != nil {
+			t.Fatalf("DeleteStream() unexpected error = %v", err)
+		}
+
+		if _, err := testLivestreamService.GetStreamStatus(stream.ID); err != mongo.ErrNoDocuments {
+			t.Errorf("GetStreamStatus() after delete error = %v, want ErrNoDocuments", err)
+		}
+		collections := map[string]*mongo.Collection{
+			"chat messages":  testLivestreamService.chatCollection,
+			"viewer samples": testLivestreamService.sampleCollection,
+			"recordings":     testLivestreamService.recorderService.recordingsCollection,
+		}
+		for name, collection := range collections {
+			count, err := collection.CountDocuments(ctx, bson.M{"stream_id": stream.ID})
+			if err != nil {
+				t.Fatalf("Failed to count %s: %v", name, err)
+			}
+			if count != 0 {
+				t.Errorf("%d %s left after delete", count, name)
+			}
+		}
+		if _, err := os.Stat(recordingPath); !os.IsNotExist(err) {
+			t.Errorf("Recording file still exists: %v", err)
+		}
+	})
+}
+
+func TestLivestreamService_RotateStreamKey(t *testing.T) {
+	ctx := context.Background()
+
+	stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Rotate Key Stream " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(stream.ID)
+	oldKey := stream.StreamKey
+
+	if _, err := testLivestreamService.RotateStreamKey(ctx, stream.ID, primitive.NewObjectID()); !errors.Is(err, ErrNotStreamOwner) {
+		t.Errorf("RotateStreamKey() by another user error = %v, want ErrNotStreamOwner", err)
+	}
+	if _, err := testLivestreamService.RotateStreamKey(ctx, primitive.NewObjectID(), testUserID); !errors.Is(err, ErrStreamNotFound) {
+		t.Errorf("RotateStreamKey() of unknown stream error = %v, want ErrStreamNotFound", err)
+	}
+
+	rotated, err := testLivestreamService.RotateStreamKey(ctx, stream.ID, testUserID)
+	if err != nil {
+		t.Fatalf("RotateStreamKey() unexpected error = %v", err)
+	}
+	if rotated.StreamKey == "" || rotated.StreamKey == oldKey {
+		t.Errorf("Rotated key = %q, want a new key", rotated.StreamKey)
+	}
+
+	if _, err := testLivestreamService.GetStreamByKey(oldKey); err != mongo.ErrNoDocuments {
+		t.Errorf("GetStreamByKey() with the old key error = %v, want ErrNoDocuments", err)
+	}
+	found, err := testLivestreamService.GetStreamByKey(rotated.StreamKey)
+	if err != nil {
+		t.Fatalf("GetStreamByKey() with the new key unexpected error = %v", err)
+	}
+	if found.ID != stream.ID {
+		t.Errorf("GetStreamByKey() = stream %s, want %s", found.ID.Hex(), stream.ID.Hex())
+	}
+
+	t.Run("UniqueKeys", func(t *testing.T) {
+		duplicate := &Livestream{
+			ID:        primitive.NewObjectID(),
+			UserID:    testUserID,
+			Title:     "Duplicate Key Stream",
+			Status:    StreamStatusOffline,
+			StreamKey: rotated.StreamKey,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+		_, err := testLivestreamService.livestreamCollection.InsertOne(ctx, duplicate)
+		if err == nil {
+			removeTestStream(duplicate.ID)
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			t.Errorf("Inserting a stream with a taken key error = %v, want a duplicate key error", err)
+		}
+	})
+}
+
+func TestIsKeyframe(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"idr after parameter sets", []byte{0, 0, 0, 1, 0x67, 0x42, 0, 0, 0, 1, 0x68, 0xce, 0, 0, 0, 1, 0x65, 0x88}, true},
+		{"three byte start code", []byte{0, 0, 1, 0x65, 0x88}, true},
+		{"non-idr slice", []byte{0, 0, 0, 1, 0x41, 0x9a}, false},
+		{"parameter sets only", []byte{0, 0, 0, 1, 0x67, 0x42, 0, 0, 0, 1, 0x68, 0xce}, false},
+		{"truncated", []byte{0, 0, 1}, false},
+		{"empty", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isKeyframe(tt.data); got != tt.want {
+				t.Errorf("isKeyframe() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLivestreamService_StreamPreview(t *testing.T) {
+	ctx := context.Background()
+
+	stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Preview Stream " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(stream.ID)
+
+	if _, err := testLivestreamService.ReadStreamPreview(ctx, stream.ID); !errors.Is(err, ErrNoPreview) {
+		t.Errorf("ReadStreamPreview() before a capture error = %v, want ErrNoPreview", err)
+	}
+	if _, err := testLivestreamService.recorderService.CaptureStreamFrame(stream.StreamKey); !errors.Is(err, ErrNoKeyframe) {
+		t.Errorf("CaptureStreamFrame() without a keyframe error = %v, want ErrNoKeyframe", err)
+	}
+	if _, err := testLivestreamService.ReadStreamPreview(ctx, primitive.NewObjectID()); !errors.Is(err, ErrStreamNotFound) {
+		t.Errorf("ReadStreamPreview() of unknown stream error = %v, want ErrStreamNotFound", err)
+	}
+
+	// Stand in for a captured frame
+	path := testLivestreamService.recorderService.previewPath(stream.ID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create preview directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("jpeg"), 0644); err != nil {
+		t.Fatalf("Failed to write preview: %v", err)
+	}
+	if _, err := testLivestreamService.livestreamCollection.UpdateByID(ctx, stream.ID, bson.M{"$set": bson.M{"stream_thumbnail_path": path}}); err != nil {
+		t.Fatalf("Failed to set preview path: %v", err)
+	}
+
+	preview, err := testLivestreamService.ReadStreamPreview(ctx, stream.ID)
+	if err != nil || string(preview) != "jpeg" {
+		t.Errorf("ReadStreamPreview() = %q, %v, want the preview file", preview, err)
+	}
+
+	if _, err := testLivestreamService.StopStream(testUserID, stream.ID); err != nil {
+		t.Fatalf("StopStream() unexpected error = %v", err)
+	}
+	if err := testLivestreamService.DeleteStream(ctx, stream.ID, testUserID); err != nil {
+		t.Fatalf("DeleteStream() unexpected error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Preview file still exists after DeleteStream(): %v", err)
+	}
+}
+
+func TestGenerateStreamKey(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		key, err := generateStreamKey()
+		if err != nil {
+			t.Fatalf("generateStreamKey() unexpected error = %v", err)
+		}
+		decoded, err := streamKeyEncoding.DecodeString(key)
+		if err != nil {
+			t.Fatalf("Stream key %q is not base32: %v", key, err)
+		}
+		if len(decoded)*8 < 128 {
+			t.Errorf("Stream key has %d bits of entropy, want at least 128", len(decoded)*8)
+		}
+		if seen[key] {
+			t.Errorf("generateStreamKey() returned %q twice", key)
+		}
+		seen[key] = true
+	}
+}
+
+func TestLivestreamService_StreamKeyCollision(t *testing.T) {
+	ctx := context.Background()
+
+	taken, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Taken Key Stream " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(taken.ID)
+
+	// The first key generated clashes with the existing stream's
+	var calls int
+	testLivestreamService.newStreamKey = func() (string, error) {
+		calls++
+		if calls == 1 {
+			return taken.StreamKey, nil
+		}
+		return generateStreamKey()
+	}
+	defer func() { testLivestreamService.newStreamKey = generateStreamKey }()
+
+	stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Colliding Key Stream " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("StartStream() after a key collision unexpected error = %v", err)
+	}
+	defer removeTestStream(stream.ID)
+
+	if calls != 2 {
+		t.Errorf("Stream key generated %d times, want 2", calls)
+	}
+	if stream.StreamKey == taken.StreamKey {
+		t.Error("StartStream() reused a taken stream key")
+	}
+	found, err := testLivestreamService.GetStreamByKey(stream.StreamKey)
+	if err != nil || found.ID != stream.ID {
+		t.Errorf("GetStreamByKey() = %v, %v, want stream %s", found, err, stream.ID.Hex())
+	}
+
+	t.Run("GivesUp", func(t *testing.T) {
+		testLivestreamService.newStreamKey = func() (string, error) {
+			return taken.StreamKey, nil
+		}
+		_, err := testLivestreamService.ScheduleStream(testUserID, StartStreamRequest{Title: "Always Colliding"}, time.Now().Add(time.Hour))
+		if !mongo.IsDuplicateKeyError(err) {
+			t.Errorf("ScheduleStream() with only taken keys error = %v, want a duplicate key error", err)
+		}
+		count, _ := testLivestreamService.livestreamCollection.CountDocuments(ctx, bson.M{"stream_key": taken.StreamKey})
+		if count != 1 {
+			t.Errorf("%d streams share the taken key, want 1", count)
+		}
+	})
+}
+
+func TestLivestreamService_ExportMessages(t *testing.T) {
+	ctx := context.Background()
+
+	stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Chat Export Stream " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(stream.ID)
+
+	sent := []string{"first", "=HYPERLINK(\"http://example.com\")", "with, a comma", "last"}
+	for _, msg := range sent {
+		if err := testLivestreamService.SendChatMessage(stream.ID, testUserID, "streamer", msg); err != nil {
+			t.Fatalf("SendChatMessage() unexpected error = %v", err)
+		}
+		time.Sleep(2 * time.Millisecond) // Keep the send order in created_at
+	}
+
+	t.Run("OwnerOnly", func(t *testing.T) {
+		if _, err := testLivestreamService.ExportMessages(ctx, stream.ID, primitive.NewObjectID(), ChatExportJSON); !errors.Is(err, ErrNotStreamOwner) {
+			t.Errorf("ExportMessages() by another user error = %v, want ErrNotStreamOwner", err)
+		}
+		if _, err := testLivestreamService.ExportMessages(ctx, primitive.NewObjectID(), testUserID, ChatExportJSON); !errors.Is(err, ErrStreamNotFound) {
+			t.Errorf("ExportMessages() of unknown stream error = %v, want ErrStreamNotFound", err)
+		}
+		if _, err := testLivestreamService.ExportMessages(ctx, stream.ID, testUserID, "xml"); !errors.Is(err, ErrInvalidExportFormat) {
+			t.Errorf("ExportMessages() as xml error = %v, want ErrInvalidExportFormat", err)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		export, err := testLivestreamService.ExportMessages(ctx, stream.ID, testUserID, "")
+		if err != nil {
+			t.Fatalf("ExportMessages() unexpected error = %v", err)
+		}
+		if export.ContentType() != "application/json" || !strings.HasSuffix(export.Filename(), ".json") {
+			t.Errorf("Default export is %s named %s, want JSON", export.ContentType(), export.Filename())
+		}
+		var buf bytes.Buffer
+		if err := export.Write(ctx, &buf); err != nil {
+			t.Fatalf("Write() unexpected error = %v", err)
+		}
+		var messages []ChatMessage
+		if err := json.Unmarshal(buf.Bytes(), &messages); err != nil {
+			t.Fatalf("Export is not valid JSON: %v", err)
+		}
+		if len(messages) != len(sent) {
+			t.Fatalf("Export has %d messages, want %d", len(messages), len(sent))
+		}
+		for i, message := range messages {
+			if message.Message != sent[i] {
+				t.Errorf("Message %d = %q, want %q", i, message.Message, sent[i])
+			}
+		}
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		export, err := testLivestreamService.ExportMessages(ctx, stream.ID, testUserID, "CSV")
+		if err != nil {
+			t.Fatalf("ExportMessages() unexpected error = %v", err)
+		}
+		var buf bytes.Buffer
+		if err := export.Write(ctx, &buf); err != nil {
+			t.Fatalf("Write() unexpected error = %v", err)
+		}
+		rows, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatalf("Export is not valid CSV: %v", err)
+		}
+		if len(rows) != len(sent)+1 || rows[0][4] != "message" {
+			t.Fatalf("Export has %d rows with header %v, want %d rows", len(rows), rows[0], len(sent)+1)
+		}
+		if rows[1][4] != "first" || rows[3][4] != "with, a comma" || rows[4][4] != "last" {
+			t.Errorf("Export messages = %v", rows[1:])
+		}
+		if rows[2][4] != "'"+sent[1] {
+			t.Errorf("Formula message exported as %q, want it quoted", rows[2][4])
+		}
+	})
+
+	t.Run("EmptyChat", func(t *testing.T) {
+		quiet, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+			Title: "Quiet Stream " + generateTestSuffix(),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create stream: %v", err)
+		}
+		defer removeTestStream(quiet.ID)
+
+		export, err := testLivestreamService.ExportMessages(ctx, quiet.ID, testUserID, ChatExportJSON)
+		if err != nil {
+			t.Fatalf("ExportMessages() unexpected error = %v", err)
+		}
+		var buf bytes.Buffer
+		if err := export.Write(ctx, &buf); err != nil {
+			t.Fatalf("Write() unexpected error = %v", err)
+		}
+		if buf.String() != "[]" {
+			t.Errorf("Empty export = %q, want []", buf.String())
+		}
+	})
+}
+
+func TestLivestreamService_WebRTCPlayback(t *testing.T) {
+	ctx := context.Background()
+	streamManager := NewStreamManager(testLivestreamService, nil)
+	webRTCManager, err := NewWebRTCManager(streamManager, config.LivestreamConfig{})
+	if err != nil {
+		t.Fatalf("NewWebRTCManager() unexpected error = %v", err)
+	}
+
+	stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "WebRTC Playback Stream " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(stream.ID)
+
+	// viewerOffer creates a receive-only viewer and its complete offer
+	viewerOffer := func(t *testing.T) (*webrtc.PeerConnection, webrtc.SessionDescription) {
+		viewer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Fatalf("Failed to create viewer: %v", err)
+		}
+		t.Cleanup(func() { viewer.Close() })
+		for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+			if _, err := viewer.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+				t.Fatalf("Failed to add transceiver: %v", err)
+			}
+		}
+		offer, err := viewer.CreateOffer(nil)
+		if err != nil {
+			t.Fatalf("Failed to create offer: %v", err)
+		}
+		gathered := webrtc.GatheringCompletePromise(viewer)
+		if err := viewer.SetLocalDescription(offer); err != nil {
+			t.Fatalf("Failed to set offer: %v", err)
+		}
+		<-gathered
+		return viewer, *viewer.LocalDescription()
+	}
+
+	waitFor := func(t *testing.T, what string, done func() bool) {
+		deadline := time.Now().Add(10 * time.Second)
+		for !done() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s", what)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	t.Run("OfflineStreamRefused", func(t *testing.T) {
+		_, offer := viewerOffer(t)
+		if _, _, err := webRTCManager.Watch(ctx, offer, stream.StreamKey); !errors.Is(err, ErrStreamNotPublishing) {
+			t.Errorf("Watch() before publishing error = %v, want ErrStreamNotPublishing", err)
+		}
+	})
+
+	streamManager.HandleStreamStart(stream.StreamKey, stream.ID)
+	defer streamManager.HandleStreamEnd(stream.StreamKey)
+
+	t.Run("InvalidOffer", func(t *testing.T) {
+		offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: "not sdp"}
+		if _, _, err := webRTCManager.Watch(ctx, offer, stream.StreamKey); !errors.Is(err, ErrInvalidOffer) {
+			t.Errorf("Watch() with a bad offer error = %v, want ErrInvalidOffer", err)
+		}
+		if count := webRTCManager.ConnectionCount(stream.StreamKey); count != 0 {
+			t.Errorf("%d connections kept after a bad offer, want 0", count)
+		}
+	})
+
+	t.Run("ViewerCountedWhileConnected", func(t *testing.T) {
+		viewer, offer := viewerOffer(t)
+		_, answer, err := webRTCManager.Watch(ctx, offer, stream.StreamKey)
+		if err != nil {
+			t.Fatalf("Watch() unexpected error = %v", err)
+		}
+		if answer.Type != webrtc.SDPTypeAnswer || !strings.Contains(answer.SDP, "a=candidate") {
+			t.Fatalf("Watch() answer = %+v, want an answer with candidates", answer)
+		}
+		if err := viewer.SetRemoteDescription(*answer); err != nil {
+			t.Fatalf("Failed to set answer: %v", err)
+		}
+
+		waitFor(t, "the viewer to be counted", func() bool {
+			count, err := testLivestreamService.GetViewerCount(stream.ID)
+			return err == nil && count == 1
+		})
+
+		viewer.Close()
+		waitFor(t, "the viewer to leave", func() bool {
+			count, err := testLivestreamService.GetViewerCount(stream.ID)
+			return err == nil && count == 0
+		})
+	})
+
+	t.Run("ClosedWhenStreamEnds", func(t *testing.T) {
+		viewer, offer := viewerOffer(t)
+		_, answer, err := webRTCManager.Watch(ctx, offer, stream.StreamKey)
+		if err != nil {
+			t.Fatalf("Watch() unexpected error = %v", err)
+		}
+		if err := viewer.SetRemoteDescription(*answer); err != nil {
+			t.Fatalf("Failed to set answer: %v", err)
+		}
+		waitFor(t, "the viewer to connect", func() bool {
+			return viewer.ConnectionState() == webrtc.PeerConnectionStateConnected
+		})
+
+		streamManager.HandleStreamEnd(stream.StreamKey)
+		waitFor(t, "the connection to close", func() bool {
+			return webRTCManager.ConnectionCount(stream.StreamKey) == 0
+		})
+	})
+}
+
+func TestLivestreamService_StopAllStreams(t *testing.T) {
+	ctx := context.Background()
+	streamManager := NewStreamManager(testLivestreamService, nil)
+
+	published, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Stop All Published " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(published.ID)
+	streamManager.HandleStreamStart(published.StreamKey, published.ID)
+
+	idle, err := testLivestreamService.StartStream(primitive.NewObjectID(), StartStreamRequest{
+		Title: "Stop All Idle " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(idle.ID)
+
+	// A recording whose file is missing can't be published, so this stream fails to stop
+	failing, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Stop All Failing " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(failing.ID)
+	recorder := testLivestreamService.recorderService
+	recorder.mu.Lock()
+	recorder.recordings[failing.ID.Hex()] = &RecorderSession{
+		StreamID:    failing.ID,
+		OutputPath:  filepath.Join(t.TempDir(), "missing.mp4"),
+		StartTime:   time.Now(),
+		IsRecording: true,
+	}
+	recorder.mu.Unlock()
+	defer recorder.StopRecording(failing.ID)
+
+	result, err := streamManager.StopAllStreams(ctx, "test maintenance")
+	if err != nil {
+		t.Fatalf("StopAllStreams() unexpected error = %v", err)
+	}
+	stoppedIDs := map[primitive.ObjectID]bool{}
+	for _, id := range result.StreamIDs {
+		stoppedIDs[id] = true
+	}
+	if !stoppedIDs[published.ID] || !stoppedIDs[idle.ID] || stoppedIDs[failing.ID] {
+		t.Errorf("StopAllStreams() stopped %v, want %s and %s but not %s", result.StreamIDs, published.ID.Hex(), idle.ID.Hex(), failing.ID.Hex())
+	}
+	if result.Stopped != len(result.StreamIDs) || result.Failed != len(result.Errors) {
+		t.Errorf("StopAllStreams() counts %d stopped, %d failed don't match %+v", result.Stopped, result.Failed, result)
+	}
+	if result.Failed != 1 || result.Errors[0].StreamID != failing.ID || result.Errors[0].Error == "" {
+		t.Errorf("StopAllStreams() errors = %+v, want one for %s", result.Errors, failing.ID.Hex())
+	}
+
+	for _, id := range []primitive.ObjectID{published.ID, idle.ID} {
+		stored, err := testLivestreamService.GetStreamStatus(id)
+		if err != nil {
+			t.Fatalf("GetStreamStatus() unexpected error = %v", err)
+		}
+		if stored.Status != StreamStatusEnded || stored.EndedAt == nil {
+			t.Errorf("Stream %s status = %s, ended_at = %v, want ended", id.Hex(), stored.Status, stored.EndedAt)
+		}
+	}
+	if videoTrack, _ := streamManager.GetStreamTracks(published.StreamKey); videoTrack != nil {
+		t.Error("Tracks of a stopped stream should be cleaned up")
+	}
+	stored, err := testLivestreamService.GetStreamStatus(failing.ID)
+	if err != nil {
+		t.Fatalf("GetStreamStatus() unexpected error = %v", err)
+	}
+	if stored.Status != StreamStatusLive {
+		t.Errorf("Stream that failed to stop has status %s, want %s", stored.Status, StreamStatusLive)
+	}
+
+	// Remove the failing stream's recording so nothing is left live
+	recorder.StopRecording(failing.ID)
+	if _, err := testLivestreamService.StopAllStreams(ctx, "test maintenance"); err != nil {
+		t.Fatalf("StopAllStreams() unexpected error = %v", err)
+	}
+	result, err = testLivestreamService.StopAllStreams(ctx, "test maintenance")
+	if err != nil {
+		t.Fatalf("StopAllStreams() with no live streams unexpected error = %v", err)
+	}
+	if result.Stopped != 0 || result.Failed != 0 {
+		t.Errorf("StopAllStreams() with no live streams = %+v, want nothing stopped", result)
+	}
+}
+
+func TestLivestreamService_UpdateStreamWhitelist(t *testing.T) {
+	ctx := context.Background()
+
+	stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Update Whitelist " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(stream.ID)
+
+	t.Run("RejectsProtectedFields", func(t *testing.T) {
+		rejected := []map[string]interface{}{
+			{"stream_key": "stolen"},
+			{"user_id": primitive.NewObjectID()},
+			{"viewer_count": 1000},
+			{"title": "Fine", "started_at": time.Now()},
+			{"status": "CORRUPTED"},
+			{"status": StreamStatusScheduled},
+			{"title": 42},
+			{},
+		}
+		for _, updates := range rejected {
+			if err := testLivestreamService.UpdateStream(stream.ID, updates); !errors.Is(err, ErrInvalidUpdate) {
+				t.Errorf("UpdateStream(%v) error = %v, want ErrInvalidUpdate", updates, err)
+			}
+		}
+
+		var stored Livestream
+		if err := testLivestreamService.livestreamCollection.FindOne(ctx, bson.M{"_id": stream.ID}).Decode(&stored); err != nil {
+			t.Fatalf("Failed to find stream: %v", err)
+		}
+		if stored.StreamKey != stream.StreamKey || stored.UserID != testUserID || stored.Title != stream.Title || stored.Status != StreamStatusLive {
+			t.Errorf("Rejected updates changed the stream: %+v", stored)
+		}
+	})
+
+	t.Run("ValidatesValues", func(t *testing.T) {
+		if err := testLivestreamService.UpdateStream(stream.ID, map[string]interface{}{"title": "  "}); !errors.Is(err, ErrStreamTitleRequired) {
+			t.Errorf("UpdateStream() with a blank title error = %v, want ErrStreamTitleRequired", err)
+		}
+		if err := testLivestreamService.UpdateStream(stream.ID, map[string]interface{}{"category": "not-a-category"}); !errors.Is(err, ErrInvalidCategory) {
+			t.Errorf("UpdateStream() with an unknown category error = %v, want ErrInvalidCategory", err)
+		}
+	})
+
+	t.Run("SetsOnlyGivenFields", func(t *testing.T) {
+		before := time.Now().Add(-time.Second)
+		if err := testLivestreamService.UpdateStream(stream.ID, map[string]interface{}{"title": "  Renamed  "}); err != nil {
+			t.Fatalf("UpdateStream() unexpected error = %v", err)
+		}
+		if err := testLivestreamService.UpdateStream(stream.ID, map[string]interface{}{"description": "New description"}); err != nil {
+			t.Fatalf("UpdateStream() unexpected error = %v", err)
+		}
+
+		var stored Livestream
+		if err := testLivestreamService.livestreamCollection.FindOne(ctx, bson.M{"_id": stream.ID}).Decode(&stored); err != nil {
+			t.Fatalf("Failed to find stream: %v", err)
+		}
+		if stored.Title != "Renamed" || stored.Description != "New description" {
+			t.Errorf("Stream title = %q, description = %q, want both updates kept", stored.Title, stored.Description)
+		}
+		if stored.UpdatedAt.Before(before) {
+			t.Errorf("Stream updated_at = %v, want it bumped", stored.UpdatedAt)
+		}
+	})
+}
+
+func TestLivestreamService_GetViewerTimeline(t *testing.T) {
+	ctx := context.Background()
+
+	// A scheduled stream isn't tracked, so the background sampler leaves it alone
+	stream, err := testLivestreamService.ScheduleStream(testUserID, StartStreamRequest{
+		Title: "Timeline Stream " + generateTestSuffix(),
+	}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(stream.ID)
+
+	t.Run("No samples is an empty series", func(t *testing.T) {
+		timeline, err := testLivestreamService.GetViewerTimeline(ctx, stream.ID, testUserID, 0)
+		if err != nil {
+			t.Fatalf("GetViewerTimeline() unexpected error = %v", err)
+		}
+		if timeline.Points == nil || len(timeline.Points) != 0 || timeline.BucketSeconds != DefaultTimelineBucket {
+			t.Errorf("GetViewerTimeline() = %+v, want no points in %ds buckets", timeline, DefaultTimelineBucket)
+		}
+	})
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	samples := []struct {
+		after time.Duration
+		count int64
+	}{
+		{0, 2}, {10 * time.Second, 4}, {70 * time.Second, 5}, {190 * time.Second, 1},
+	}
+	var docs []interface{}
+	for _, sample := range samples {
+		docs = append(docs, ViewerSample{StreamID: stream.ID, ViewerCount: sample.count, Ts: start.Add(sample.after)})
+	}
+	if _, err := testLivestreamService.sampleCollection.InsertMany(ctx, docs); err != nil {
+		t.Fatalf("Failed to insert samples: %v", err)
+	}
+
+	t.Run("Samples are bucketed", func(t *testing.T) {
+		timeline, err := testLivestreamService.GetViewerTimeline(ctx, stream.ID, testUserID, 60)
+		if err != nil {
+			t.Fatalf("GetViewerTimeline() unexpected error = %v", err)
+		}
+		// The bucket from 120s to 180s has no samples and is left out
+		want := []TimelinePoint{
+			{Ts: start, AverageViewers: 3, PeakViewers: 4},
+			{Ts: start.Add(60 * time.Second), AverageViewers: 5, PeakViewers: 5},
+			{Ts: start.Add(180 * time.Second), AverageViewers: 1, PeakViewers: 1},
+		}
+		if len(timeline.Points) != len(want) {
+			t.Fatalf("GetViewerTimeline() = %+v, want %+v", timeline.Points, want)
+		}
+		for i, point := range timeline.Points {
+			if !point.Ts.Equal(want[i].Ts) || point.AverageViewers != want[i].AverageViewers || point.PeakViewers != want[i].PeakViewers {
+				t.Errorf("Point %d = %+v, want %+v", i, point, want[i])
+			}
+		}
+	})
+
+	t.Run("Long streams are capped", func(t *testing.T) {
+		late := ViewerSample{StreamID: stream.ID, ViewerCount: 3, Ts: start.Add(-10 * MaxTimelinePoints * time.Second)}
+		if _, err := testLivestreamService.sampleCollection.InsertOne(ctx, late); err != nil {
+			t.Fatalf("Failed to insert sample: %v", err)
+		}
+		timeline, err := testLivestreamService.GetViewerTimeline(ctx, stream.ID, testUserID, 1)
+		if err != nil {
+			t.Fatalf("GetViewerTimeline() unexpected error = %v", err)
+		}
+		if timeline.BucketSeconds <= 1 || len(timeline.Points) > MaxTimelinePoints {
+			t.Errorf("GetViewerTimeline() = %d points in %ds buckets, want at most %d wider ones",
+				len(timeline.Points), timeline.BucketSeconds, MaxTimelinePoints)
+		}
+		if len(timeline.Points) == 0 || !timeline.Points[0].Ts.Equal(late.Ts) {
+			t.Errorf("GetViewerTimeline() doesn't start at the first sample: %+v", timeline.Points)
+		}
+	})
+
+	t.Run("Rejected requests", func(t *testing.T) {
+		for _, bucket := range []int{-1, MaxTimelineBucket + 1} {
+			if _, err := testLivestreamService.GetViewerTimeline(ctx, stream.ID, testUserID, bucket); !errors.Is(err, ErrInvalidTimelineBucket) {
+				t.Errorf("GetViewerTimeline() with bucket %d error = %v, want ErrInvalidTimelineBucket", bucket, err)
+			}
+		}
+		if _, err := testLivestreamService.GetViewerTimeline(ctx, stream.ID, primitive.NewObjectID(), 60); !errors.Is(err, ErrNotStreamOwner) {
+			t.Errorf("GetViewerTimeline() by another user error = %v, want ErrNotStreamOwner", err)
+		}
+		if _, err := testLivestreamService.GetViewerTimeline(ctx, primitive.NewObjectID(), testUserID, 60); !errors.Is(err, ErrStreamNotFound) {
+			t.Errorf("GetViewerTimeline() of unknown stream error = %v, want ErrStreamNotFound", err)
+		}
+	})
+}
+
+func TestLivestreamService_ModerateUserMessages(t *testing.T) {
+	ctx := context.Background()
+
+	stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{
+		Title: "Moderated Stream " + generateTestSuffix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream: %v", err)
+	}
+	defer removeTestStream(stream.ID)
+
+	newUser := func(prefix string) primitive.ObjectID {
+		suffix := generateTestSuffix()
+		user, err := testUserService.CreateUser(ctx, users.CreateUserRequest{
+			UserName: prefix + "_" + suffix,
+			Email:    prefix + "_" + suffix + "@example.com",
+			Password: "password123",
+		})
+		if err != nil {
+			t.Fatalf("Failed to create test user: %v", err)
+		}
+		return user.ID
+	}
+	spammer, bystander, admin := newUser("spammer"), newUser("bystander"), newUser("admin")
+	if _, err := testDbService.GetDatabase().Collection("users").UpdateByID(ctx, admin, bson.M{"$set": bson.M{"role": users.RoleAdmin}}); err != nil {
+		t.Fatalf("Failed to make user an admin: %v", err)
+	}
+
+	for _, text := range []string{"spam 1", "spam 2", "spam 3"} {
+		if err := testLivestreamService.SendChatMessage(stream.ID, spammer, "spammer", text); err != nil {
+			t.Fatalf("SendChatMessage() unexpected error = %v", err)
+		}
+		if err := testLivestreamService.SendChatMessage(stream.ID, bystander, "bystander", "hi"); err != nil {
+			t.Fatalf("SendChatMessage() unexpected error = %v", err)
+		}
+	}
+
+	t.Run("Owner and admins review a user's messages", func(t *testing.T) {
+		for _, moderator := range []primitive.ObjectID{testUserID, admin} {
+			messages, err := testLivestreamService.GetMessagesByUser(ctx, stream.ID, spammer, moderator)
+			if err != nil {
+				t.Fatalf("GetMessagesByUser() unexpected error = %v", err)
+			}
+			if len(messages) != 3 {
+				t.Fatalf("GetMessagesByUser() returned %d messages, want 3", len(messages))
+			}
+			for i, message := range messages {
+				if message.UserID != spammer || message.Message != fmt.Sprintf("spam %d", i+1) {
+					t.Errorf("Message %d = %+v, want spam %d from the spammer", i, message, i+1)
+				}
+			}
+		}
+
+		messages, err := testLivestreamService.GetMessagesByUser(ctx, stream.ID, primitive.NewObjectID(), testUserID)
+		if err != nil || messages == nil || len(messages) != 0 {
+			t.Errorf("GetMessagesByUser() of a silent user = %v, %v, want an empty list", messages, err)
+		}
+	})
+
+	t.Run("Others can't moderate", func(t *testing.T) {
+		if _, err := testLivestreamService.GetMessagesByUser(ctx, stream.ID, spammer, bystander); !errors.Is(err, ErrNotStreamOwner) {
+			t.Errorf("GetMessagesByUser() by a viewer error = %v, want ErrNotStreamOwner", err)
+		}
+		if _, err := testLivestreamService.BulkDeleteUserMessages(ctx, stream.ID, spammer, spammer); !errors.Is(err, ErrNotStreamOwner) {
+			t.Errorf("BulkDeleteUserMessages() by the spammer error = %v, want ErrNotStreamOwner", err)
+		}
+		if _, err := testLivestreamService.GetMessagesByUser(ctx, primitive.NewObjectID(), spammer, testUserID); !errors.Is(err, ErrStreamNotFound) {
+			t.Errorf("GetMessagesByUser() of unknown stream error = %v, want ErrStreamNotFound", err)
+		}
+	})
+
+	t.Run("Bulk delete only removes the user's messages", func(t *testing.T) {
+		deleted, err := testLivestreamService.BulkDeleteUserMessages(ctx, stream.ID, spammer, admin)
+		if err != nil {
+			t.Fatalf("BulkDeleteUserMessages() unexpected error = %v", err)
+		}
+		if deleted != 3 {
+			t.Errorf("BulkDeleteUserMessages() = %d, want 3", deleted)
+		}
+
+		messages, err := testLivestreamService.GetMessages(stream.ID)
+		if err != nil {
+			t.Fatalf("GetMessages() unexpected error = %v", err)
+		}
+		if len(messages) != 3 {
+			t.Errorf("%d messages left, want the bystander's 3", len(messages))
+		}
+		for _, message := range messages {
+			if message.UserID != bystander {
+				t.Errorf("Message %+v of the spammer was kept", message)
+			}
+		}
+	})
+}
+
+func TestLivestreamService_ChatMessageLength(t *testing.T) {
+	stream, err := testLivestreamService.StartStream(testUserID, StartStreamRequest{Title: "Chat Length " + generateTestSuffix()})
+	if err != nil {
+		t.Fatalf("StartStream() unexpected error = %v", err)
+	}
+	chatUserID := primitive.NewObjectID()
+	send := func(text string) error {
+		return testLivestreamService.SendChatMessage(stream.ID, chatUserID, "chatter", text)
+	}
+
+	t.Run("Over the limit", func(t *testing.T) {
+		for _, text := range []string{
+			strings.Repeat("a", DefaultMaxChatMessageLength+1),
+			strings.Repeat("é", DefaultMaxChatMessageLength+1),
+		} {
+			if err := send(text); !errors.Is(err, ErrMessageTooLong) || !errors.Is(err, ErrInvalidChatMessage) {
+				t.Errorf("SendChatMessage(%d characters) error = %v, want ErrMessageTooLong", utf8.RuneCountInString(text), err)
+			}
+		}
+	})
+
+	t.Run("Whitespace only", func(t *testing.T) {
+		for _, text := range []string{"", "   ", "\n\t "} {
+			if err := send(text); !errors.Is(err, ErrEmptyMessage) {
+				t.Errorf("SendChatMessage(%q) error = %v, want ErrEmptyMessage", text, err)
+			}
+		}
+	})
+
+	t.Run("At the limit", func(t *testing.T) {
+		// Characters are counted, not bytes, and surrounding whitespace is trimmed first
+		atLimit := strings.Repeat("é", DefaultMaxChatMessageLength)
+		if err := send("  " + atLimit + "\n"); err != nil {
+			t.Fatalf("SendChatMessage(%d characters) unexpected error = %v", DefaultMaxChatMessageLength, err)
+		}
+		messages, err := testLivestreamService.GetMessagesByUser(context.Background(), stream.ID, chatUserID, testUserID)
+		if err != nil {
+			t.Fatalf("GetMessagesByUser() unexpected error = %v", err)
+		}
+		if len(messages) != 1 || messages[0].Message != atLimit {
+			t.Errorf("Saved messages = %v, want only the trimmed message", messages)
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		testLivestreamService.truncateChat = true
+		defer func() { testLivestreamService.truncateChat = false }()
+
+		text := strings.Repeat("a", DefaultMaxChatMessageLength-1) + " bcd"
+		chatMessage, err := testLivestreamService.sendChatMessage(stream.ID, chatUserID, "chatter", text)
+		if err != nil {
+			t.Fatalf("sendChatMessage() unexpected error = %v", err)
+		}
+		if want := strings.Repeat("a", DefaultMaxChatMessageLength-1); chatMessage.Message != want {
+			t.Errorf("Truncated message has %d characters, want %d", len(chatMessage.Message), len(want))
+		}
+	})
+}
+
+func TestLivestreamService_FeaturedStreams(t *testing.T) {
+	ctx := context.Background()
+	start := func(title string) *Livestream {
+		stream, err := testLivestreamService.StartStream(primitive.NewObjectID(), StartStreamRequest{Title: title + " " + generateTestSuffix()})
+		if err != nil {
+			t.Fatalf("StartStream() unexpected error = %v", err)
+		}
+		return stream
+	}
+	first, second := start("Featured first"), start("Featured second")
+	start("Not featured")
+	scheduled, err := testLivestreamService.ScheduleStream(primitive.NewObjectID(), StartStreamRequest{Title: "Featured later"}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("ScheduleStream() unexpected error = %v", err)
+	}
+
+	for _, stream := range []*Livestream{first, second, scheduled} {
+		featured, err := testLivestreamService.SetFeatured(ctx, stream.ID)
+		if err != nil {
+			t.Fatalf("SetFeatured() unexpected error = %v", err)
+		}
+		if !featured.Featured || featured.FeaturedAt == nil {
+			t.Errorf("SetFeatured() = featured %v at %v, want it featured now", featured.Featured, featured.FeaturedAt)
+		}
+	}
+	if _, err := testLivestreamService.SetFeatured(ctx, primitive.NewObjectID()); !errors.Is(err, ErrStreamNotFound) {
+		t.Errorf("SetFeatured() of a missing stream error = %v, want ErrStreamNotFound", err)
+	}
+
+	featuredIDs := func() []primitive.ObjectID {
+		streams, err := testLivestreamService.GetFeaturedStreams(ctx)
+		if err != nil {
+			t.Fatalf("GetFeaturedStreams() unexpected error = %v", err)
+		}
+		ids := []primitive.ObjectID{}
+		for _, stream := range streams {
+			ids = append(ids, stream.ID)
+		}
+		return ids
+	}
+
+	// Only live streams are listed, in the order they were featured
+	if got, want := featuredIDs(), []primitive.ObjectID{first.ID, second.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetFeaturedStreams() = %v, want %v", got, want)
+	}
+
+	// Featuring a stream again moves it to the end
+	if _, err := testLivestreamService.SetFeatured(ctx, first.ID); err != nil {
+		t.Fatalf("SetFeatured() unexpected error = %v", err)
+	}
+	if got, want := featuredIDs(), []primitive.ObjectID{second.ID, first.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetFeaturedStreams() after featuring again = %v, want %v", got, want)
+	}
+
+	// Streams that end drop off, as do unfeatured ones
+	if _, err := testLivestreamService.StopStream(second.UserID, second.ID); err != nil {
+		t.Fatalf("StopStream() unexpected error = %v", err)
+	}
+	if got, want := featuredIDs(), []primitive.ObjectID{first.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetFeaturedStreams() after stopping = %v, want %v", got, want)
+	}
+	unfeatured, err := testLivestreamService.UnsetFeatured(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("UnsetFeatured() unexpected error = %v", err)
+	}
+	if unfeatured.Featured || unfeatured.FeaturedAt != nil {
+		t.Errorf("UnsetFeatured() = featured %v at %v, want it unfeatured", unfeatured.Featured, unfeatured.FeaturedAt)
+	}
+	if got := featuredIDs(); len(got) != 0 {
+		t.Errorf("GetFeaturedStreams() after unfeaturing = %v, want none", got)
+	}
+}
+
@@ -1,12 +1,16 @@
 package livestream
 
 import (
+	"context"
 	"log"
 	"sync"
 	"time"
 
+	"streamflow/internal/webhooks"
+
 	"github.com/pion/webrtc/v3"
 	"github.com/pion/webrtc/v3/pkg/media"
+	flvtag "github.com/yutopp/go-flv/tag"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -19,23 +23,42 @@ type ActiveStream struct {
 	LastActivity time.Time
 	VideoTrack   *webrtc.TrackLocalStaticSample
 	AudioTrack   *webrtc.TrackLocalStaticSample
+	stopPreview  chan struct{} // Closed to stop refreshing the preview frame
 }
 
+// StreamEvent is the webhook payload sent on stream lifecycle changes.
+type StreamEvent = webhooks.Event
+
+const (
+	EventStreamStarted = webhooks.EventStreamStarted
+	EventStreamEnded   = webhooks.EventStreamEnded
+)
+
 // StreamManager orchestrates all active livestreaming sessions.
 type StreamManager struct {
 	livestreamService *LivestreamService
+	webhooks          *webhooks.WebhookDispatcher
 	activeStreams     map[string]*ActiveStream
+	endHooks          []func(streamKey string) // Run when a stream ends
 	mu                sync.RWMutex
 }
 
-// NewStreamManager creates a new stream manager.
-func NewStreamManager(ls *LivestreamService) *StreamManager {
+// NewStreamManager creates a new stream manager. The webhook dispatcher may be nil.
+func NewStreamManager(ls *LivestreamService, hooks *webhooks.WebhookDispatcher) *StreamManager {
 	return &StreamManager{
 		livestreamService: ls,
+		webhooks:          hooks,
 		activeStreams:     make(map[string]*ActiveStream),
 	}
 }
 
+// OnStreamEnd registers fn to run in the background whenever a stream ends
+func (sm *StreamManager) OnStreamEnd(fn func(streamKey string)) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.endHooks = append(sm.endHooks, fn)
+}
+
 // HandleStreamStart initializes stream management for a new publishing stream.
 func (sm *StreamManager) HandleStreamStart(streamKey string, streamID primitive.ObjectID) {
 	sm.mu.Lock()
@@ -54,6 +77,12 @@ func (sm *StreamManager) HandleStreamStart(streamKey string, streamID primitive.
 		return
 	}
 
+	// A stream that is started again replaces the old entry, whose preview refresh has to
+	// stop or its goroutine would run until shutdown
+	if previous, exists := sm.activeStreams[streamKey]; exists && previous.stopPreview != nil {
+		close(previous.stopPreview)
+	}
+
 	sm.activeStreams[streamKey] = &ActiveStream{
 		StreamID:     streamID,
 		StreamKey:    streamKey,
@@ -62,8 +91,20 @@ func (sm *StreamManager) HandleStreamStart(streamKey string, streamID primitive.
 		VideoTrack:   videoTrack,
 		AudioTrack:   audioTrack,
 	}
+	if interval := sm.livestreamService.previewInterval; interval > 0 {
+		stop := make(chan struct{})
+		sm.activeStreams[streamKey].stopPreview = stop
+		go sm.refreshPreviews(streamKey, streamID, interval, stop)
+	}
+	if sm.livestreamService.recordStreams {
+		if err := sm.livestreamService.recorderService.startIngestRecording(streamID); err != nil {
+			log.Printf("StreamManager: Failed to start recording stream %s: %v", streamKey, err)
+		}
+	}
 
 	log.Printf("StreamManager: Started and now managing stream %s", streamKey)
+
+	sm.livestreamService.goTracked(func() { sm.notifyLifecycle(EventStreamStarted, streamID) })
 }
 
 // HandleStreamEnd orchestrates cleanup when a stream stops.
@@ -74,14 +115,73 @@ func (sm *StreamManager) HandleStreamEnd(streamKey string) {
 	log.Printf("StreamManager: Handling end for stream key: %s", streamKey)
 
 	if stream, exists := sm.activeStreams[streamKey]; exists {
-		// Stop the recording.
-		go sm.livestreamService.recorderService.StopRecording(stream.StreamID)
+		if stream.stopPreview != nil {
+			close(stream.stopPreview)
+		}
+		sm.livestreamService.recorderService.forgetKeyframe(streamKey)
+		// End the stream and publish its recording as a video.
+		sm.livestreamService.goTracked(func() { sm.livestreamService.endPublishedStream(context.Background(), stream.StreamID) })
 		// Remove from active management.
 		delete(sm.activeStreams, streamKey)
 		log.Printf("StreamManager: Stopped and cleaned up stream %s", streamKey)
+
+		sm.livestreamService.goTracked(func() { sm.notifyLifecycle(EventStreamEnded, stream.StreamID) })
+		for _, hook := range sm.endHooks {
+			sm.livestreamService.goTracked(func() { hook(streamKey) })
+		}
 	}
 }
 
+// StopAllStreams stops every live stream through the livestream service and cleans up the
+// tracks, previews and viewer connections of those that were being published
+func (sm *StreamManager) StopAllStreams(ctx context.Context, reason string) (*StopAllResult, error) {
+	result, err := sm.livestreamService.StopAllStreams(ctx, reason)
+	if err != nil {
+		return nil, err
+	}
+	for _, stream := range result.stopped {
+		sm.HandleStreamEnd(stream.StreamKey)
+	}
+	return result, nil
+}
+
+// refreshPreviews captures a preview frame of the stream every interval until stop is
+// closed. The last frame is kept after the stream ends.
+func (sm *StreamManager) refreshPreviews(streamKey string, streamID primitive.ObjectID, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			sm.livestreamService.refreshStreamPreview(context.Background(), streamKey, streamID)
+		}
+	}
+}
+
+// notifyLifecycle fires a stream lifecycle webhook. It looks up the stream owner,
+// so it must not be called with sm.mu held.
+func (sm *StreamManager) notifyLifecycle(event string, streamID primitive.ObjectID) {
+	if sm.webhooks == nil {
+		return
+	}
+
+	stream, err := sm.livestreamService.GetStreamStatus(streamID)
+	if err != nil {
+		log.Printf("StreamManager: Failed to load stream %s for %s webhook: %v", streamID.Hex(), event, err)
+		return
+	}
+
+	sm.webhooks.Dispatch(StreamEvent{
+		Event:    event,
+		StreamID: streamID.Hex(),
+		UserID:   stream.UserID.Hex(),
+		Ts:       time.Now(),
+	})
+}
+
 // HandleViewerJoin updates the viewer count when a viewer starts watching.
 func (sm *StreamManager) HandleViewerJoin(streamKey string) {
 	sm.mu.Lock()
@@ -106,6 +206,18 @@ func (sm *StreamManager) HandleViewerLeave(streamKey string) {
 	}
 }
 
+// PublishingStreamKeys returns the keys of all streams currently being published
+func (sm *StreamManager) PublishingStreamKeys() []string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	keys := make([]string, 0, len(sm.activeStreams))
+	for key := range sm.activeStreams {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
 // GetStreamTracks returns the active video and audio tracks for a given stream key.
 func (sm *StreamManager) GetStreamTracks(streamKey string) (*webrtc.TrackLocalStaticSample, *webrtc.TrackLocalStaticSample) {
 	sm.mu.RLock()
@@ -123,11 +235,26 @@ func (sm *StreamManager) WriteVideoSample(streamKey string, data []byte, duratio
 	defer sm.mu.RUnlock()
 
 	if stream, exists := sm.activeStreams[streamKey]; exists {
+		if isKeyframe(data) {
+			sm.livestreamService.recorderService.setKeyframe(streamKey, stream.StreamID, data)
+		}
 		return stream.VideoTrack.WriteSample(media.Sample{Data: data, Duration: duration})
 	}
 	return nil
 }
 
+// WriteRecordingTag adds an FLV tag received from the publisher to the stream's recording,
+// if it is being recorded
+func (sm *StreamManager) WriteRecordingTag(streamKey string, tagType flvtag.TagType, timestamp uint32, data []byte) {
+	sm.mu.RLock()
+	stream, exists := sm.activeStreams[streamKey]
+	sm.mu.RUnlock()
+
+	if exists {
+		sm.livestreamService.recorderService.writeRecordingTag(stream.StreamID, tagType, timestamp, data)
+	}
+}
+
 // WriteAudioSample writes an audio sample to the stream.
 func (sm *StreamManager) WriteAudioSample(streamKey string, data []byte, duration time.Duration) error {
 	sm.mu.RLock()
@@ -0,0 +1,123 @@
+package livestream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"streamflow/internal/logger"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// staleSlotAge is how old a slot must be before it can be taken over from a stream that
+// isn't live, so a slot claimed by a stream that is still being started is left alone
+const staleSlotAge = time.Minute
+
+// streamSlot is one of a user's concurrent stream slots, held by a live stream. A unique
+// index on the user and slot number keeps a user from holding more slots than the limit,
+// however many streams are started at once.
+type streamSlot struct {
+	StreamID  primitive.ObjectID `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	Slot      int                `bson:"slot"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+// createSlotIndexes creates the index that makes each slot number unique per user
+func (s *LivestreamService) createSlotIndexes() {
+	s.slotCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "slot", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+}
+
+// claimStreamSlot takes a free concurrent stream slot of the user for a stream about to
+// go live. ErrStreamLimitReached is returned if all of them are held. A stream that
+// already holds a slot keeps it. Without a limit nothing is claimed.
+func (s *LivestreamService) claimStreamSlot(ctx context.Context, userID, streamID primitive.ObjectID) error {
+	if s.maxConcurrentStreams <= 0 {
+		return nil
+	}
+
+	for slot := 0; slot < s.maxConcurrentStreams; slot++ {
+		claimed, err := s.tryClaimSlot(ctx, userID, streamID, slot)
+		if err != nil {
+			return err
+		}
+		if claimed {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %d streams live", ErrStreamLimitReached, s.maxConcurrentStreams)
+}
+
+// tryClaimSlot claims one slot number, taking it over if its stream has stopped without
+// releasing it
+func (s *LivestreamService) tryClaimSlot(ctx context.Context, userID, streamID primitive.ObjectID, slot int) (bool, error) {
+	claim := streamSlot{StreamID: streamID, UserID: userID, Slot: slot, CreatedAt: time.Now()}
+	_, err := s.slotCollection.InsertOne(ctx, claim)
+	if err == nil {
+		return true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return false, fmt.Errorf("failed to claim stream slot: %w", err)
+	}
+
+	var holder streamSlot
+	err = s.slotCollection.FindOne(ctx, bson.M{"user_id": userID, "slot": slot}).Decode(&holder)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// The duplicate was the stream's own slot, or the slot was just released
+		return s.holdsSlot(ctx, streamID)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up stream slot: %w", err)
+	}
+	if holder.StreamID == streamID {
+		return true, nil
+	}
+	if time.Since(holder.CreatedAt) < staleSlotAge {
+		return false, nil
+	}
+
+	live, err := s.livestreamCollection.CountDocuments(ctx, bson.M{"_id": holder.StreamID, "status": StreamStatusLive})
+	if err != nil {
+		return false, fmt.Errorf("failed to check stream slot: %w", err)
+	}
+	if live > 0 {
+		return false, nil
+	}
+
+	// Only the stale claim is removed, so two requests can't both take the slot over
+	if _, err := s.slotCollection.DeleteOne(ctx, bson.M{"_id": holder.StreamID, "slot": slot}); err != nil {
+		return false, fmt.Errorf("failed to release stale stream slot: %w", err)
+	}
+	logger.FromContext(ctx).Info("released stream slot of a stream that isn't live", "slot", slot, "user_id", userID.Hex(), "stream_id", holder.StreamID.Hex())
+	if _, err := s.slotCollection.InsertOne(ctx, claim); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return s.holdsSlot(ctx, streamID)
+		}
+		return false, fmt.Errorf("failed to claim stream slot: %w", err)
+	}
+	return true, nil
+}
+
+// holdsSlot reports whether the stream holds a slot
+func (s *LivestreamService) holdsSlot(ctx context.Context, streamID primitive.ObjectID) (bool, error) {
+	count, err := s.slotCollection.CountDocuments(ctx, bson.M{"_id": streamID})
+	if err != nil {
+		return false, fmt.Errorf("failed to look up stream slot: %w", err)
+	}
+	return count > 0, nil
+}
+
+// releaseStreamSlot frees the slot of a stream that is no longer live
+func (s *LivestreamService) releaseStreamSlot(ctx context.Context, streamID primitive.ObjectID) {
+	if _, err := s.slotCollection.DeleteOne(ctx, bson.M{"_id": streamID}); err != nil {
+		logger.FromContext(ctx).Error("failed to release stream slot", "stream_id", streamID.Hex(), "error", err)
+	}
+}
@@ -0,0 +1,123 @@
+package livestream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// DefaultTimelineBucket is the width in seconds of the points of a viewer timeline
+	DefaultTimelineBucket = 60
+	// MaxTimelineBucket is the widest bucket that can be asked for, one day
+	MaxTimelineBucket = 24 * 60 * 60
+	// MaxTimelinePoints caps the points of a timeline. Buckets are widened for streams
+	// that would have more.
+	MaxTimelinePoints = 500
+)
+
+// ErrInvalidTimelineBucket is returned for a bucket width outside 1 to MaxTimelineBucket seconds
+var ErrInvalidTimelineBucket = errors.New("timeline bucket must be between 1 and 86400 seconds")
+
+// ViewerTimeline is a stream's viewer count over time, for charting
+type ViewerTimeline struct {
+	StreamID      primitive.ObjectID `json:"stream_id"`
+	BucketSeconds int                `json:"bucket_seconds"` // May be wider than asked for, to stay within MaxTimelinePoints
+	Points        []TimelinePoint    `json:"points"`         // Oldest first; empty when the stream has no samples
+}
+
+// TimelinePoint summarizes the viewer samples of one bucket. Buckets without samples are
+// left out.
+type TimelinePoint struct {
+	Ts             time.Time `json:"ts"` // Start of the bucket
+	AverageViewers float64   `json:"average_viewers"`
+	PeakViewers    int64     `json:"peak_viewers"`
+}
+
+// GetViewerTimeline downsamples the viewer samples of a stream into buckets of
+// bucketSeconds, which defaults to DefaultTimelineBucket. Only the owner can see it.
+func (s *LivestreamService) GetViewerTimeline(ctx context.Context, streamID, ownerID primitive.ObjectID, bucketSeconds int) (*ViewerTimeline, error) {
+	if bucketSeconds == 0 {
+		bucketSeconds = DefaultTimelineBucket
+	}
+	if bucketSeconds < 0 || bucketSeconds > MaxTimelineBucket {
+		return nil, ErrInvalidTimelineBucket
+	}
+	if err := s.checkOwner(ctx, streamID, ownerID); err != nil {
+		return nil, err
+	}
+
+	timeline := &ViewerTimeline{StreamID: streamID, BucketSeconds: bucketSeconds, Points: []TimelinePoint{}}
+	first, last, err := s.sampleSpan(ctx, streamID)
+	if err != nil {
+		return nil, err
+	}
+	if first.IsZero() {
+		return timeline, nil
+	}
+
+	// Buckets start at the first sample, so a span shorter than MaxTimelinePoints buckets
+	// fits in as many points
+	minBucket := int(last.Sub(first)/(time.Duration(MaxTimelinePoints-1)*time.Second)) + 1
+	timeline.BucketSeconds = max(bucketSeconds, minBucket)
+	bucketMs := int64(timeline.BucketSeconds) * 1000
+
+	offset := bson.M{"$subtract": bson.A{"$ts", first}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"stream_id": streamID}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     bson.M{"$subtract": bson.A{offset, bson.M{"$mod": bson.A{offset, bucketMs}}}},
+			"average": bson.M{"$avg": "$viewer_count"},
+			"peak":    bson.M{"$max": "$viewer_count"},
+		}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$limit", Value: MaxTimelinePoints}},
+	}
+	cursor, err := s.sampleCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate viewer samples: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var bucket struct {
+			Offset  int64   `bson:"_id"` // Milliseconds after the first sample
+			Average float64 `bson:"average"`
+			Peak    int64   `bson:"peak"`
+		}
+		if err := cursor.Decode(&bucket); err != nil {
+			return nil, fmt.Errorf("failed to decode viewer samples: %w", err)
+		}
+		timeline.Points = append(timeline.Points, TimelinePoint{
+			Ts:             first.Add(time.Duration(bucket.Offset) * time.Millisecond),
+			AverageViewers: bucket.Average,
+			PeakViewers:    bucket.Peak,
+		})
+	}
+	return timeline, cursor.Err()
+}
+
+// sampleSpan returns the times of the first and last viewer samples of a stream, which
+// are zero if it has none
+func (s *LivestreamService) sampleSpan(ctx context.Context, streamID primitive.ObjectID) (time.Time, time.Time, error) {
+	var span [2]time.Time
+	for i, order := range []int{1, -1} {
+		var sample ViewerSample
+		opts := options.FindOne().SetSort(bson.M{"ts": order}).SetProjection(bson.M{"ts": 1})
+		err := s.sampleCollection.FindOne(ctx, bson.M{"stream_id": streamID}, opts).Decode(&sample)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return time.Time{}, time.Time{}, nil
+		}
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("failed to read viewer samples: %w", err)
+		}
+		span[i] = sample.Ts
+	}
+	return span[0], span[1], nil
+}
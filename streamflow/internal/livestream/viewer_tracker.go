@@ -0,0 +1,294 @@
+package livestream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// viewerFlushInterval is how often in-memory viewer counts are written back to MongoDB
+const viewerFlushInterval = 5 * time.Second
+
+// ErrNoViewers is returned when removing a viewer from a stream that has none
+var ErrNoViewers = errors.New("stream has no viewers")
+
+// ViewerCounts tracks live viewer counts between database syncs. ViewerTracker keeps
+// them in the memory of this instance; RedisViewerTracker shares them between instances.
+type ViewerCounts interface {
+	// Add records a viewer joining the stream and returns the new count
+	Add(ctx context.Context, streamID primitive.ObjectID) (int64, error)
+	// Remove records a viewer leaving the stream and returns the new count, or
+	// ErrNoViewers if the stream has none
+	Remove(ctx context.Context, streamID primitive.ObjectID) (int64, error)
+	// Count returns the live count for the stream. ok is false if it isn't tracked.
+	Count(streamID primitive.ObjectID) (count int64, ok bool)
+	// Peak returns the highest count the stream has reached. ok is false if it isn't tracked.
+	Peak(streamID primitive.ObjectID) (peak int64, ok bool)
+	// Flush writes the tracked counts to the database
+	Flush(ctx context.Context) error
+	// Sample records the current count of every tracked stream
+	Sample(ctx context.Context) error
+	// Reconcile writes the stream's final count to the database and stops tracking it
+	Reconcile(ctx context.Context, streamID primitive.ObjectID) error
+	// Forget stops tracking the stream without writing its count
+	Forget(streamID primitive.ObjectID)
+	// Start flushes and samples counts every interval in the background until ctx is done
+	Start(ctx context.Context, interval time.Duration)
+}
+
+// viewerCounter is the live viewer count of a single stream and the highest count it
+// has reached
+type viewerCounter struct {
+	count atomic.Int64
+	peak  atomic.Int64
+	dirty atomic.Bool
+}
+
+// raisePeak makes count the peak if it is higher than the current one
+func (c *viewerCounter) raisePeak(count int64) {
+	for {
+		peak := c.peak.Load()
+		if count <= peak || c.peak.CompareAndSwap(peak, count) {
+			return
+		}
+	}
+}
+
+// ViewerTracker keeps live viewer counts in memory so joins and leaves don't each cost a
+// database write. Counts are seeded from the stream document on first use and written
+// back periodically by Flush. Sample records the counts over time for analytics.
+type ViewerTracker struct {
+	mu         sync.RWMutex
+	counters   map[primitive.ObjectID]*viewerCounter
+	collection *mongo.Collection
+	samples    *mongo.Collection
+}
+
+// NewViewerTracker creates a tracker that syncs counts to the livestreams collection and
+// records samples in the samples collection
+func NewViewerTracker(collection, samples *mongo.Collection) *ViewerTracker {
+	return &ViewerTracker{
+		counters:   make(map[primitive.ObjectID]*viewerCounter),
+		collection: collection,
+		samples:    samples,
+	}
+}
+
+// Add records a viewer joining the stream and returns the new count
+func (t *ViewerTracker) Add(ctx context.Context, streamID primitive.ObjectID) (int64, error) {
+	counter, err := t.counter(ctx, streamID)
+	if err != nil {
+		return 0, err
+	}
+	count := counter.count.Add(1)
+	counter.raisePeak(count)
+	counter.dirty.Store(true)
+	return count, nil
+}
+
+// Remove records a viewer leaving the stream and returns the new count. The count never
+// drops below zero; removing from an empty stream returns ErrNoViewers.
+func (t *ViewerTracker) Remove(ctx context.Context, streamID primitive.ObjectID) (int64, error) {
+	counter, err := t.counter(ctx, streamID)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		current := counter.count.Load()
+		if current <= 0 {
+			return 0, ErrNoViewers
+		}
+		if counter.count.CompareAndSwap(current, current-1) {
+			counter.dirty.Store(true)
+			return current - 1, nil
+		}
+	}
+}
+
+// Count returns the live count for the stream. ok is false if the stream isn't tracked.
+func (t *ViewerTracker) Count(streamID primitive.ObjectID) (count int64, ok bool) {
+	t.mu.RLock()
+	counter, ok := t.counters[streamID]
+	t.mu.RUnlock()
+	if !ok {
+		return 0, false
+	}
+	return counter.count.Load(), true
+}
+
+// Peak returns the highest count the stream has reached. ok is false if the stream isn't tracked.
+func (t *ViewerTracker) Peak(streamID primitive.ObjectID) (peak int64, ok bool) {
+	t.mu.RLock()
+	counter, ok := t.counters[streamID]
+	t.mu.RUnlock()
+	if !ok {
+		return 0, false
+	}
+	return counter.peak.Load(), true
+}
+
+// Flush writes every count that changed since the last flush to the database
+func (t *ViewerTracker) Flush(ctx context.Context) error {
+	t.mu.RLock()
+	dirty := make(map[primitive.ObjectID]*viewerCounter)
+	for streamID, counter := range t.counters {
+		if counter.dirty.Load() {
+			dirty[streamID] = counter
+		}
+	}
+	t.mu.RUnlock()
+
+	var firstErr error
+	for streamID, counter := range dirty {
+		// Clear the flag before reading so a concurrent change is picked up next time
+		counter.dirty.Store(false)
+		if err := t.write(ctx, streamID, counter.count.Load(), counter.peak.Load()); err != nil {
+			counter.dirty.Store(true)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// Sample records the current count of every tracked stream
+func (t *ViewerTracker) Sample(ctx context.Context) error {
+	now := time.Now()
+	t.mu.RLock()
+	docs := make([]interface{}, 0, len(t.counters))
+	for streamID, counter := range t.counters {
+		docs = append(docs, ViewerSample{StreamID: streamID, ViewerCount: counter.count.Load(), Ts: now})
+	}
+	t.mu.RUnlock()
+
+	return insertViewerSamples(ctx, t.samples, docs)
+}
+
+// Reconcile writes the stream's final count to the database and stops tracking it.
+// It is called when a stream ends.
+func (t *ViewerTracker) Reconcile(ctx context.Context, streamID primitive.ObjectID) error {
+	t.mu.Lock()
+	counter, ok := t.counters[streamID]
+	delete(t.counters, streamID)
+	t.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return t.write(ctx, streamID, counter.count.Load(), counter.peak.Load())
+}
+
+// Forget drops the in-memory count without writing it, so the next read or change
+// reloads it from the database
+func (t *ViewerTracker) Forget(streamID primitive.ObjectID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.counters, streamID)
+}
+
+// Start flushes and samples counts every interval in the background until ctx is done
+func (t *ViewerTracker) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := t.Flush(ctx); err != nil {
+				log.Printf("Viewer count flush failed: %v", err)
+			}
+			if err := t.Sample(ctx); err != nil {
+				log.Printf("Viewer count sampling failed: %v", err)
+			}
+		}
+	}()
+}
+
+// counter returns the stream's counter, seeding it from the database the first time
+func (t *ViewerTracker) counter(ctx context.Context, streamID primitive.ObjectID) (*viewerCounter, error) {
+	t.mu.RLock()
+	counter, ok := t.counters[streamID]
+	t.mu.RUnlock()
+	if ok {
+		return counter, nil
+	}
+
+	count, peak, err := readViewerCount(ctx, t.collection, streamID)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Another caller may have seeded the counter while we were reading
+	if counter, ok := t.counters[streamID]; ok {
+		return counter, nil
+	}
+	counter = &viewerCounter{}
+	counter.count.Store(count)
+	counter.peak.Store(peak)
+	t.counters[streamID] = counter
+	return counter, nil
+}
+
+func (t *ViewerTracker) write(ctx context.Context, streamID primitive.ObjectID, count, peak int64) error {
+	return writeViewerCount(ctx, t.collection, streamID, count, peak)
+}
+
+// readViewerCount returns the viewer count and peak stored on a stream, which seed a
+// tracked count
+func readViewerCount(ctx context.Context, collection *mongo.Collection, streamID primitive.ObjectID) (count, peak int64, err error) {
+	var stream Livestream
+	opts := options.FindOne().SetProjection(bson.M{"viewer_count": 1, "peak_viewer_count": 1})
+	if err := collection.FindOne(ctx, bson.M{"_id": streamID}, opts).Decode(&stream); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return 0, 0, fmt.Errorf("stream not found")
+		}
+		return 0, 0, err
+	}
+	return int64(max(stream.ViewerCount, 0)), int64(max(stream.PeakViewerCount, stream.ViewerCount, 0)), nil
+}
+
+// writeViewerCount stores the count, clamped at zero by the database so no writer can
+// leave a negative count behind. The stored peak is compared with peak in the update
+// itself, so it only ever rises, whoever writes last.
+func writeViewerCount(ctx context.Context, collection *mongo.Collection, streamID primitive.ObjectID, count, peak int64) error {
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"viewer_count":      bson.M{"$max": bson.A{count, 0}},
+			"peak_viewer_count": bson.M{"$max": bson.A{"$peak_viewer_count", peak}},
+		}}},
+	}
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": streamID}, update)
+	if err != nil {
+		return fmt.Errorf("failed to sync viewer count: %w", err)
+	}
+	return nil
+}
+
+// insertViewerSamples records viewer samples for analytics
+func insertViewerSamples(ctx context.Context, samples *mongo.Collection, docs []interface{}) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	if _, err := samples.InsertMany(ctx, docs); err != nil {
+		return fmt.Errorf("failed to record viewer samples: %w", err)
+	}
+	return nil
+}
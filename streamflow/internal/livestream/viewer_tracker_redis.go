@@ -0,0 +1,314 @@
+package livestream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"streamflow/internal/redis"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// redisTimeout bounds the Redis commands of calls that don't take a context
+const redisTimeout = 2 * time.Second
+
+// redisStreamsKey is the set of streams whose counts are kept in Redis
+const redisStreamsKey = "viewers:streams"
+
+// redisWorkerLockKey is held by the instance flushing and sampling counts for an interval
+const redisWorkerLockKey = "viewers:worker"
+
+// addViewerScript increments the count and raises the peak to it in one step
+const addViewerScript = `
+local count = redis.call('INCR', KEYS[1])
+if count > tonumber(redis.call('GET', KEYS[2]) or '0') then
+	redis.call('SET', KEYS[2], count)
+end
+return count`
+
+// removeViewerScript decrements the count unless it is already zero, returning -1 then
+const removeViewerScript = `
+local count = tonumber(redis.call('GET', KEYS[1]) or '0')
+if count <= 0 then
+	return -1
+end
+return redis.call('DECR', KEYS[1])`
+
+// RedisViewerTracker keeps live viewer counts in Redis, so all instances share them. Counts
+// are seeded from the stream document on first use, and one instance at a time writes them
+// back and samples them.
+type RedisViewerTracker struct {
+	client     *redis.Client
+	collection *mongo.Collection
+	samples    *mongo.Collection
+	instance   string // Identifies this instance as the holder of the worker lock
+}
+
+// NewRedisViewerTracker creates a tracker keeping counts in Redis that syncs them to the
+// livestreams collection and records samples in the samples collection
+func NewRedisViewerTracker(client *redis.Client, collection, samples *mongo.Collection) *RedisViewerTracker {
+	return &RedisViewerTracker{
+		client:     client,
+		collection: collection,
+		samples:    samples,
+		instance:   primitive.NewObjectID().Hex(),
+	}
+}
+
+// Add records a viewer joining the stream and returns the new count
+func (t *RedisViewerTracker) Add(ctx context.Context, streamID primitive.ObjectID) (int64, error) {
+	if err := t.seed(ctx, streamID); err != nil {
+		return 0, err
+	}
+	countKey, peakKey := viewerKeys(streamID)
+	count, err := t.client.Int(ctx, "EVAL", addViewerScript, 2, countKey, peakKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add viewer in redis: %w", err)
+	}
+	return count, nil
+}
+
+// Remove records a viewer leaving the stream and returns the new count. The count never
+// drops below zero; removing from an empty stream returns ErrNoViewers.
+func (t *RedisViewerTracker) Remove(ctx context.Context, streamID primitive.ObjectID) (int64, error) {
+	if err := t.seed(ctx, streamID); err != nil {
+		return 0, err
+	}
+	countKey, _ := viewerKeys(streamID)
+	count, err := t.client.Int(ctx, "EVAL", removeViewerScript, 1, countKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove viewer in redis: %w", err)
+	}
+	if count < 0 {
+		return 0, ErrNoViewers
+	}
+	return count, nil
+}
+
+// Count returns the live count for the stream. ok is false if the stream isn't tracked or
+// Redis can't be reached, so callers fall back to the stored count.
+func (t *RedisViewerTracker) Count(streamID primitive.ObjectID) (count int64, ok bool) {
+	countKey, _ := viewerKeys(streamID)
+	return t.get(countKey)
+}
+
+// Peak returns the highest count the stream has reached. ok is false if the stream isn't
+// tracked or Redis can't be reached.
+func (t *RedisViewerTracker) Peak(streamID primitive.ObjectID) (peak int64, ok bool) {
+	_, peakKey := viewerKeys(streamID)
+	return t.get(peakKey)
+}
+
+// Flush writes the count of every tracked stream to the database
+func (t *RedisViewerTracker) Flush(ctx context.Context) error {
+	counts, err := t.counts(ctx)
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for streamID, c := range counts {
+		if err := writeViewerCount(ctx, t.collection, streamID, c.count, c.peak); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Sample records the current count of every tracked stream
+func (t *RedisViewerTracker) Sample(ctx context.Context) error {
+	counts, err := t.counts(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	docs := make([]interface{}, 0, len(counts))
+	for streamID, c := range counts {
+		docs = append(docs, ViewerSample{StreamID: streamID, ViewerCount: c.count, Ts: now})
+	}
+	return insertViewerSamples(ctx, t.samples, docs)
+}
+
+// Reconcile writes the stream's final count to the database and stops tracking it.
+// It is called when a stream ends.
+func (t *RedisViewerTracker) Reconcile(ctx context.Context, streamID primitive.ObjectID) error {
+	countKey, peakKey := viewerKeys(streamID)
+	reply, err := t.client.Do(ctx, "MGET", countKey, peakKey)
+	if err != nil {
+		return fmt.Errorf("failed to read viewer count from redis: %w", err)
+	}
+	c, tracked := parseViewerCount(reply)
+
+	if err := t.forget(ctx, streamID); err != nil {
+		return err
+	}
+	if !tracked {
+		return nil
+	}
+	return writeViewerCount(ctx, t.collection, streamID, c.count, c.peak)
+}
+
+// Forget drops the count from Redis without writing it, so the next read or change
+// reloads it from the database
+func (t *RedisViewerTracker) Forget(streamID primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := t.forget(ctx, streamID); err != nil {
+		log.Printf("Failed to forget viewer count of stream %s: %v", streamID.Hex(), err)
+	}
+}
+
+// Start flushes and samples counts every interval in the background until ctx is done.
+// Each interval only the instance taking the worker lock does so; the lock expires
+// before the next tick so any instance can take over.
+func (t *RedisViewerTracker) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			_, err := t.client.Do(ctx, "SET", redisWorkerLockKey, t.instance, "NX", "PX", (interval / 2).Milliseconds())
+			if errors.Is(err, redis.ErrNil) {
+				continue // Another instance holds the lock
+			}
+			if err != nil {
+				log.Printf("Viewer count worker lock failed: %v", err)
+				continue
+			}
+
+			if err := t.Flush(ctx); err != nil {
+				log.Printf("Viewer count flush failed: %v", err)
+			}
+			if err := t.Sample(ctx); err != nil {
+				log.Printf("Viewer count sampling failed: %v", err)
+			}
+		}
+	}()
+}
+
+// seed loads the stream's count from the database unless Redis already has it. Instances
+// seeding at the same time store the same value, and only the first write sticks.
+func (t *RedisViewerTracker) seed(ctx context.Context, streamID primitive.ObjectID) error {
+	countKey, peakKey := viewerKeys(streamID)
+	exists, err := t.client.Int(ctx, "EXISTS", countKey)
+	if err != nil {
+		return fmt.Errorf("failed to read viewer count from redis: %w", err)
+	}
+	if exists == 1 {
+		return nil
+	}
+
+	count, peak, err := readViewerCount(ctx, t.collection, streamID)
+	if err != nil {
+		return err
+	}
+	if _, err := t.client.Do(ctx, "SET", peakKey, peak, "NX"); err != nil && !errors.Is(err, redis.ErrNil) {
+		return fmt.Errorf("failed to seed viewer count in redis: %w", err)
+	}
+	if _, err := t.client.Do(ctx, "SET", countKey, count, "NX"); err != nil && !errors.Is(err, redis.ErrNil) {
+		return fmt.Errorf("failed to seed viewer count in redis: %w", err)
+	}
+	if _, err := t.client.Do(ctx, "SADD", redisStreamsKey, streamID.Hex()); err != nil {
+		return fmt.Errorf("failed to track stream in redis: %w", err)
+	}
+	return nil
+}
+
+// forget deletes the stream's keys and stops tracking it
+func (t *RedisViewerTracker) forget(ctx context.Context, streamID primitive.ObjectID) error {
+	countKey, peakKey := viewerKeys(streamID)
+	if _, err := t.client.Do(ctx, "DEL", countKey, peakKey); err != nil {
+		return fmt.Errorf("failed to delete viewer count from redis: %w", err)
+	}
+	if _, err := t.client.Do(ctx, "SREM", redisStreamsKey, streamID.Hex()); err != nil {
+		return fmt.Errorf("failed to untrack stream in redis: %w", err)
+	}
+	return nil
+}
+
+// get reads a single counter
+func (t *RedisViewerTracker) get(key string) (int64, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	value, err := t.client.Int(ctx, "GET", key)
+	if errors.Is(err, redis.ErrNil) {
+		return 0, false
+	}
+	if err != nil {
+		log.Printf("Failed to read viewer count from redis: %v", err)
+		return 0, false
+	}
+	return value, true
+}
+
+// redisViewerCount is a count and peak read from Redis
+type redisViewerCount struct {
+	count, peak int64
+}
+
+// counts reads the counts of all tracked streams. Streams whose keys are gone are
+// dropped from the set.
+func (t *RedisViewerTracker) counts(ctx context.Context) (map[primitive.ObjectID]redisViewerCount, error) {
+	members, err := t.client.Strings(ctx, "SMEMBERS", redisStreamsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tracked streams in redis: %w", err)
+	}
+
+	counts := make(map[primitive.ObjectID]redisViewerCount, len(members))
+	for _, member := range members {
+		streamID, err := primitive.ObjectIDFromHex(member)
+		if err != nil {
+			continue
+		}
+		countKey, peakKey := viewerKeys(streamID)
+		reply, err := t.client.Do(ctx, "MGET", countKey, peakKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read viewer count from redis: %w", err)
+		}
+		c, tracked := parseViewerCount(reply)
+		if !tracked {
+			t.client.Do(ctx, "SREM", redisStreamsKey, member)
+			continue
+		}
+		counts[streamID] = c
+	}
+	return counts, nil
+}
+
+// parseViewerCount decodes the MGET reply for a stream's count and peak. tracked is false
+// if the count is missing.
+func parseViewerCount(reply interface{}) (c redisViewerCount, tracked bool) {
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return c, false
+	}
+	count, ok := values[0].([]byte)
+	if !ok {
+		return c, false
+	}
+	c.count, _ = strconv.ParseInt(string(count), 10, 64)
+	if peak, ok := values[1].([]byte); ok {
+		c.peak, _ = strconv.ParseInt(string(peak), 10, 64)
+	}
+	c.peak = max(c.peak, c.count)
+	return c, true
+}
+
+// viewerKeys returns the keys of a stream's count and peak
+func viewerKeys(streamID primitive.ObjectID) (countKey, peakKey string) {
+	prefix := "viewers:" + streamID.Hex()
+	return prefix + ":count", prefix + ":peak"
+}
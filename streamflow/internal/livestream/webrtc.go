@@ -1,108 +1,257 @@
 package livestream
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
+	"strings"
 	"sync"
+	"time"
+
+	"streamflow/internal/config"
 
 	"github.com/pion/webrtc/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// watchGatherTimeout bounds how long a playback offer waits for ICE candidates to be
+// gathered, as they are all sent in the answer
+const watchGatherTimeout = 10 * time.Second
+
+var (
+	// ErrStreamNotPublishing is returned when a viewer asks for a stream that has no media
+	ErrStreamNotPublishing = errors.New("stream is not currently active or does not have media tracks")
+	// ErrInvalidOffer is returned for an SDP offer that can't be answered
+	ErrInvalidOffer = errors.New("invalid SDP offer")
 )
 
 // WebRTCManager manages all active WebRTC peer connections.
 type WebRTCManager struct {
 	api             *webrtc.API
-	peerConnections map[string]*webrtc.PeerConnection // Map of viewerID to PeerConnection
+	iceServers      []webrtc.ICEServer
+	peerConnections map[string]*viewerPeer // Map of viewerID to its connection
 	mu              sync.RWMutex
 	streamManager   *StreamManager
 }
 
-// NewWebRTCManager creates a new WebRTC manager.
-func NewWebRTCManager(sm *StreamManager) (*WebRTCManager, error) {
+// viewerPeer is the connection of a viewer to the tracks of a stream
+type viewerPeer struct {
+	pc        *webrtc.PeerConnection
+	streamKey string
+	joined    bool // Counted as a viewer of the stream; guarded by WebRTCManager.mu
+}
+
+// NewWebRTCManager creates a new WebRTC manager offering the ICE servers of the config.
+// Viewers are disconnected when their stream ends.
+func NewWebRTCManager(sm *StreamManager, cfg config.LivestreamConfig) (*WebRTCManager, error) {
 	m := &webrtc.MediaEngine{}
 	if err := m.RegisterDefaultCodecs(); err != nil {
 		return nil, err
 	}
 
 	api := webrtc.NewAPI(webrtc.WithMediaEngine(m))
-	return &WebRTCManager{
+	wm := &WebRTCManager{
 		api:             api,
-		peerConnections: make(map[string]*webrtc.PeerConnection),
+		iceServers:      iceServers(cfg),
+		peerConnections: make(map[string]*viewerPeer),
 		streamManager:   sm,
-	}, nil
+	}
+	sm.OnStreamEnd(wm.CloseStream)
+	return wm, nil
 }
 
-// HandleOffer processes an SDP offer from a client and returns an answer.
+// iceServers builds the ICE server list. TURN servers get the configured credentials.
+func iceServers(cfg config.LivestreamConfig) []webrtc.ICEServer {
+	var servers []webrtc.ICEServer
+	for _, url := range cfg.ICEServers {
+		server := webrtc.ICEServer{URLs: []string{url}}
+		if strings.HasPrefix(url, "turn:") || strings.HasPrefix(url, "turns:") {
+			server.Username = cfg.ICEUsername
+			server.Credential = cfg.ICECredential
+		}
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+// HandleOffer processes an SDP offer from a client and returns an answer. ICE candidates
+// are exchanged afterwards with HandleICECandidate.
 func (wm *WebRTCManager) HandleOffer(offer webrtc.SessionDescription, viewerID, streamKey string) (*webrtc.SessionDescription, error) {
-	peerConnection, err := wm.api.NewPeerConnection(webrtc.Configuration{})
+	peerConnection, err := wm.connect(offer, viewerID, streamKey)
 	if err != nil {
-		log.Printf("WebRTC: Failed to create PeerConnection: %v", err)
 		return nil, err
 	}
+	return peerConnection.LocalDescription(), nil
+}
 
-	wm.addPeerConnection(viewerID, peerConnection)
+// Watch answers a playback offer for a stream without trickle ICE: the answer holds
+// every candidate, so a single request sets up the connection. It returns the ID of the
+// viewer session along with the answer.
+func (wm *WebRTCManager) Watch(ctx context.Context, offer webrtc.SessionDescription, streamKey string) (string, *webrtc.SessionDescription, error) {
+	viewerID := primitive.NewObjectID().Hex()
+	peerConnection, err := wm.connect(offer, viewerID, streamKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, watchGatherTimeout)
+	defer cancel()
+	select {
+	case <-webrtc.GatheringCompletePromise(peerConnection):
+	case <-ctx.Done():
+		wm.ClosePeerConnection(viewerID)
+		return "", nil, fmt.Errorf("gathering ICE candidates: %w", ctx.Err())
+	}
+	return viewerID, peerConnection.LocalDescription(), nil
+}
 
+// connect creates the viewer's peer connection to the stream's tracks and answers the
+// offer. A viewer's previous connection is closed.
+func (wm *WebRTCManager) connect(offer webrtc.SessionDescription, viewerID, streamKey string) (*webrtc.PeerConnection, error) {
 	// Get the existing tracks from the stream manager.
 	videoTrack, audioTrack := wm.streamManager.GetStreamTracks(streamKey)
 	if videoTrack == nil || audioTrack == nil {
-		return nil, errors.New("stream is not currently active or does not have media tracks")
+		return nil, ErrStreamNotPublishing
 	}
 
-	// Add the video and audio tracks to the peer connection.
-	if _, err := peerConnection.AddTrack(videoTrack); err != nil {
+	peerConnection, err := wm.api.NewPeerConnection(webrtc.Configuration{ICEServers: wm.iceServers})
+	if err != nil {
+		log.Printf("WebRTC: Failed to create PeerConnection: %v", err)
 		return nil, err
 	}
-	if _, err := peerConnection.AddTrack(audioTrack); err != nil {
+	peer := &viewerPeer{pc: peerConnection, streamKey: streamKey}
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		wm.handleStateChange(viewerID, peer, state)
+	})
+
+	if err := wm.answer(peerConnection, offer, videoTrack, audioTrack); err != nil {
+		peerConnection.Close()
 		return nil, err
 	}
 
+	wm.addPeerConnection(viewerID, peer)
+	log.Printf("WebRTC: PeerConnection created for viewer %s, attached to stream %s", viewerID, streamKey)
+	return peerConnection, nil
+}
+
+// answer adds the tracks to the connection and answers the offer
+func (wm *WebRTCManager) answer(peerConnection *webrtc.PeerConnection, offer webrtc.SessionDescription, tracks ...webrtc.TrackLocal) error {
+	for _, track := range tracks {
+		if _, err := peerConnection.AddTrack(track); err != nil {
+			return err
+		}
+	}
+
 	// Set the remote SessionDescription
 	if err := peerConnection.SetRemoteDescription(offer); err != nil {
-		return nil, err
+		return fmt.Errorf("%w: %v", ErrInvalidOffer, err)
 	}
 
 	// Create an answer
 	answer, err := peerConnection.CreateAnswer(nil)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("%w: %v", ErrInvalidOffer, err)
 	}
 
 	// Sets the LocalDescription, and starts our UDP listeners
-	if err := peerConnection.SetLocalDescription(answer); err != nil {
-		return nil, err
+	return peerConnection.SetLocalDescription(answer)
+}
+
+// handleStateChange counts the viewer while their connection is up and forgets the
+// connection once it is closed. Viewers that hang up are only noticed when the connection
+// times out, so a disconnected viewer stops being counted straight away and is counted
+// again if the connection recovers.
+func (wm *WebRTCManager) handleStateChange(viewerID string, peer *viewerPeer, state webrtc.PeerConnectionState) {
+	wm.mu.Lock()
+	wasJoined := peer.joined
+	joined := state == webrtc.PeerConnectionStateConnected
+	peer.joined = joined
+	if state == webrtc.PeerConnectionStateClosed && wm.peerConnections[viewerID] == peer {
+		delete(wm.peerConnections, viewerID)
 	}
+	wm.mu.Unlock()
 
-	log.Printf("WebRTC: PeerConnection created for viewer %s, attached to stream %s", viewerID, streamKey)
-	return &answer, nil
+	switch {
+	case joined && !wasJoined:
+		wm.streamManager.HandleViewerJoin(peer.streamKey)
+	case !joined && wasJoined:
+		wm.streamManager.HandleViewerLeave(peer.streamKey)
+	}
+	if state == webrtc.PeerConnectionStateFailed {
+		peer.pc.Close()
+	}
 }
 
 // HandleICECandidate adds a new ICE candidate from the client.
 func (wm *WebRTCManager) HandleICECandidate(candidate webrtc.ICECandidateInit, viewerID string) error {
 	wm.mu.RLock()
-	pc, exists := wm.peerConnections[viewerID]
+	peer, exists := wm.peerConnections[viewerID]
 	wm.mu.RUnlock()
 
 	if !exists {
 		return nil // Or return an error
 	}
 
-	return pc.AddICECandidate(candidate)
+	return peer.pc.AddICECandidate(candidate)
 }
 
-// addPeerConnection safely adds a new peer connection to the map.
-func (wm *WebRTCManager) addPeerConnection(viewerID string, pc *webrtc.PeerConnection) {
+// addPeerConnection safely adds a new peer connection to the map, closing the one it replaces.
+func (wm *WebRTCManager) addPeerConnection(viewerID string, peer *viewerPeer) {
 	wm.mu.Lock()
-	defer wm.mu.Unlock()
-	wm.peerConnections[viewerID] = pc
+	previous := wm.peerConnections[viewerID]
+	wm.peerConnections[viewerID] = peer
+	wm.mu.Unlock()
+
+	if previous != nil {
+		previous.pc.Close()
+	}
 }
 
 // ClosePeerConnection closes and removes a peer connection.
 func (wm *WebRTCManager) ClosePeerConnection(viewerID string) {
 	wm.mu.Lock()
-	defer wm.mu.Unlock()
+	peer, exists := wm.peerConnections[viewerID]
+	delete(wm.peerConnections, viewerID)
+	wm.mu.Unlock()
 
-	if pc, exists := wm.peerConnections[viewerID]; exists {
-		pc.Close()
-		delete(wm.peerConnections, viewerID)
+	if exists {
+		peer.pc.Close()
 		log.Printf("WebRTC: Closed PeerConnection for viewer %s", viewerID)
 	}
 }
+
+// CloseStream closes the connections of every viewer of a stream. It is called when the
+// stream ends.
+func (wm *WebRTCManager) CloseStream(streamKey string) {
+	wm.mu.Lock()
+	var peers []*viewerPeer
+	for viewerID, peer := range wm.peerConnections {
+		if peer.streamKey == streamKey {
+			peers = append(peers, peer)
+			delete(wm.peerConnections, viewerID)
+		}
+	}
+	wm.mu.Unlock()
+
+	for _, peer := range peers {
+		peer.pc.Close()
+	}
+	if len(peers) > 0 {
+		log.Printf("WebRTC: Closed %d PeerConnections of ended stream %s", len(peers), streamKey)
+	}
+}
+
+// ConnectionCount returns the number of peer connections to a stream not yet closed
+func (wm *WebRTCManager) ConnectionCount(streamKey string) int {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+
+	count := 0
+	for _, peer := range wm.peerConnections {
+		if peer.streamKey == streamKey {
+			count++
+		}
+	}
+	return count
+}
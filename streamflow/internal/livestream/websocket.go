@@ -2,9 +2,10 @@ package livestream
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
-	"sync"
 
+	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
 	"github.com/pion/webrtc/v3"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -16,76 +17,24 @@ type WebSocketMessage struct {
 	Payload json.RawMessage `json:"payload"`
 }
 
-// Client represents a connected WebSocket client.
+// Client represents a connected WebSocket client. userID is NilObjectID for anonymous viewers.
 type Client struct {
 	conn     *websocket.Conn
 	send     chan []byte
 	userID   primitive.ObjectID
+	userName string
 	streamID primitive.ObjectID
 }
 
-// WebSocketHub manages all active clients and broadcasts messages.
-type WebSocketHub struct {
-	clients    map[*Client]bool
-	broadcast  chan []byte
-	register   chan *Client
-	unregister chan *Client
-	mu         sync.RWMutex
-}
-
-// NewWebSocketHub creates a new WebSocketHub.
-func NewWebSocketHub() *WebSocketHub {
-	return &WebSocketHub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-	}
-}
-
-// Run starts the hub's message processing loops.
-func (h *WebSocketHub) Run() {
-	for {
-		select {
-		case client := <-h.register:
-			h.mu.Lock()
-			h.clients[client] = true
-			h.mu.Unlock()
-			log.Printf("WebSocket: Client registered (UserID: %s)", client.userID.Hex())
-
-		case client := <-h.unregister:
-			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-			}
-			h.mu.Unlock()
-			log.Printf("WebSocket: Client unregistered (UserID: %s)", client.userID.Hex())
-
-		case message := <-h.broadcast:
-			h.mu.RLock()
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
-			}
-			h.mu.RUnlock()
-		}
-	}
-}
-
 // WebSocketHandler provides the HTTP handler for WebSocket connections.
 type WebSocketHandler struct {
-	hub               *WebSocketHub
+	hub               *ChatHub
 	livestreamService *LivestreamService
 	webRTCManager     *WebRTCManager
 }
 
 // NewWebSocketHandler creates a new WebSocketHandler.
-func NewWebSocketHandler(hub *WebSocketHub, ls *LivestreamService, wm *WebRTCManager) *WebSocketHandler {
+func NewWebSocketHandler(hub *ChatHub, ls *LivestreamService, wm *WebRTCManager) *WebSocketHandler {
 	return &WebSocketHandler{
 		hub:               hub,
 		livestreamService: ls,
@@ -93,18 +42,18 @@ func NewWebSocketHandler(hub *WebSocketHub, ls *LivestreamService, wm *WebRTCMan
 	}
 }
 
-// ServeHTTP handles the WebSocket upgrade and connection lifecycle.
+// ServeHTTP handles the WebSocket upgrade and connection lifecycle. Clients join the chat
+// of the stream named by the stream_id query parameter. Anyone may watch; sending chat or
+// WebRTC signalling requires an authenticated user.
 func (wh *WebSocketHandler) ServeHTTP(c *websocket.Conn) {
-	userID, ok := c.Locals("user_id").(primitive.ObjectID)
-	if !ok {
-		log.Println("WebSocket: Unauthorized connection attempt.")
+	streamID, err := primitive.ObjectIDFromHex(c.Query("stream_id"))
+	if err != nil {
+		log.Printf("WebSocket: Invalid stream ID: %v", err)
 		c.Close()
 		return
 	}
-
-	streamID, err := primitive.ObjectIDFromHex(c.Params("streamID"))
-	if err != nil {
-		log.Printf("WebSocket: Invalid stream ID: %v", err)
+	if _, err := wh.livestreamService.GetStreamStatus(streamID); err != nil {
+		log.Printf("WebSocket: Stream %s not found", streamID.Hex())
 		c.Close()
 		return
 	}
@@ -112,25 +61,38 @@ func (wh *WebSocketHandler) ServeHTTP(c *websocket.Conn) {
 	client := &Client{
 		conn:     c,
 		send:     make(chan []byte, 256),
-		userID:   userID,
 		streamID: streamID,
 	}
-	wh.hub.register <- client
+	if userIDStr, ok := c.Locals("user_id").(string); ok {
+		client.userID, _ = primitive.ObjectIDFromHex(userIDStr)
+	}
+	client.userName, _ = c.Locals("user_name").(string)
+
+	wh.hub.Subscribe(client)
 
-	go client.writePump()
+	// The connection is released when this handler returns, so wait for the write pump
+	writerDone := make(chan struct{})
+	go func() {
+		client.writePump()
+		close(writerDone)
+	}()
 	client.readPump(wh)
+	<-writerDone
 }
 
-// readPump pumps messages from the WebSocket connection to the hub.
+// readPump reads messages from the WebSocket connection until it closes
 func (c *Client) readPump(wh *WebSocketHandler) {
 	defer func() {
-		wh.hub.unregister <- c
+		// Closing the send channel stops the write pump
+		wh.hub.Unsubscribe(c)
 		c.conn.Close()
 	}()
 	for {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
-			log.Printf("WebSocket: read error: %v", err)
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("WebSocket: read error: %v", err)
+			}
 			break
 		}
 
@@ -140,10 +102,14 @@ func (c *Client) readPump(wh *WebSocketHandler) {
 			continue
 		}
 
+		if c.userID.IsZero() {
+			c.sendError(wh.hub, "authentication required to send messages")
+			continue
+		}
+
 		// Route the message based on its type
 		switch msg.Type {
 		case "chat_message":
-			// Handle chat message payload
 			var chatPayload struct {
 				Message string `json:"message"`
 			}
@@ -151,11 +117,14 @@ func (c *Client) readPump(wh *WebSocketHandler) {
 				log.Printf("WebSocket: error unmarshaling chat payload: %v", err)
 				continue
 			}
-			// In a real app, you'd get the username from a user service
-			wh.livestreamService.SendChatMessage(c.streamID, c.userID, "username", chatPayload.Message)
-			// Broadcast the message to other clients in the same stream.
-			// This part needs more logic to target specific streams.
-			wh.hub.broadcast <- message
+			if err := wh.hub.Publish(c.streamID, c.userID, c.userName, chatPayload.Message); err != nil {
+				if errors.Is(err, ErrInvalidChatMessage) {
+					c.sendError(wh.hub, err.Error())
+					continue
+				}
+				log.Printf("WebSocket: failed to publish chat message: %v", err)
+				c.sendError(wh.hub, "failed to send message")
+			}
 
 		case "webrtc_offer":
 			var offer webrtc.SessionDescription
@@ -163,7 +132,13 @@ func (c *Client) readPump(wh *WebSocketHandler) {
 				log.Printf("WebSocket: error unmarshaling webrtc_offer payload: %v", err)
 				continue
 			}
-			answer, err := wh.webRTCManager.HandleOffer(offer, c.userID.Hex(), c.streamID.Hex())
+			// Tracks are kept by stream key
+			stream, err := wh.livestreamService.GetStreamStatus(c.streamID)
+			if err != nil {
+				log.Printf("WebSocket: error loading stream for webrtc_offer: %v", err)
+				continue
+			}
+			answer, err := wh.webRTCManager.HandleOffer(offer, c.userID.Hex(), stream.StreamKey)
 			if err != nil {
 				log.Printf("WebSocket: error handling webrtc_offer: %v", err)
 				continue
@@ -171,7 +146,7 @@ func (c *Client) readPump(wh *WebSocketHandler) {
 			answerBytes, _ := json.Marshal(answer)
 			response := WebSocketMessage{Type: "webrtc_answer", Payload: answerBytes}
 			responseBytes, _ := json.Marshal(response)
-			c.send <- responseBytes
+			wh.hub.Send(c, responseBytes)
 
 		case "webrtc_ice_candidate":
 			var candidate webrtc.ICECandidateInit
@@ -187,7 +162,7 @@ func (c *Client) readPump(wh *WebSocketHandler) {
 	}
 }
 
-// writePump pumps messages from the hub to the WebSocket connection.
+// writePump writes queued messages to the WebSocket connection until the send channel closes
 func (c *Client) writePump() {
 	defer c.conn.Close()
 	for message := range c.send {
@@ -197,3 +172,10 @@ func (c *Client) writePump() {
 		}
 	}
 }
+
+// sendError reports a problem with one of the client's messages back to it
+func (c *Client) sendError(hub *ChatHub, reason string) {
+	payload, _ := json.Marshal(fiber.Map{"error": reason})
+	message, _ := json.Marshal(WebSocketMessage{Type: "error", Payload: payload})
+	hub.Send(c, message)
+}
@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+type contextKey struct{}
+
+type loggerKey struct{}
+
+// New creates a logger writing JSON lines to w. level is one of debug, info, warn or
+// error; anything else logs at info.
+func New(w io.Writer, level string) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: ParseLevel(level)}))
+}
+
+// Init installs l as the default logger. Output of the standard log package goes through
+// it as well, so existing log.Printf calls become JSON lines at info level.
+func Init(l *slog.Logger) {
+	slog.SetDefault(l)
+	log.SetFlags(0)
+}
+
+// ParseLevel maps a level name to a slog level, defaulting to info
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, requestID)
+}
+
+// RequestID returns the request ID stored in ctx, or "" if there is none
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestID, _ := ctx.Value(contextKey{}).(string)
+	return requestID
+}
+
+// WithLogger returns a copy of ctx carrying l, for FromContext to return
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the logger stored in ctx by the middleware. Without one it returns
+// the default logger, tagged with the request ID when ctx carries one. Services use it so
+// their log lines can be matched to the request that caused them.
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx == nil {
+		return slog.Default()
+	}
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	l := slog.Default()
+	if requestID := RequestID(ctx); requestID != "" {
+		l = l.With("request_id", requestID)
+	}
+	return l
+}
@@ -0,0 +1,118 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	app := fiber.New()
+	app.Use(Middleware(New(&buf, "info")))
+
+	var seenID string
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		seenID = RequestID(c.UserContext())
+		FromContext(c.UserContext()).Info("handling")
+		return c.SendString("ok")
+	})
+	app.Get("/missing", func(c *fiber.Ctx) error {
+		return fiber.ErrNotFound
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/ok", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	requestID := resp.Header.Get(RequestIDHeader)
+	if requestID == "" || requestID != seenID {
+		t.Errorf("Response request ID = %q, handler saw %q", requestID, seenID)
+	}
+
+	// The handler logged through the request's logger, which writes where the middleware does
+	lines := bytes.SplitN(buf.Bytes(), []byte("\n"), 2)
+	var entry map[string]interface{}
+	if err := json.Unmarshal(lines[0], &entry); err != nil {
+		t.Fatalf("Log line is not JSON: %v (%q)", err, buf.String())
+	}
+	if entry["msg"] != "handling" || entry["request_id"] != requestID {
+		t.Errorf("Unexpected handler log entry: %v", entry)
+	}
+
+	entry = nil
+	if err := json.Unmarshal(lines[1], &entry); err != nil {
+		t.Fatalf("Log line is not JSON: %v (%q)", err, buf.String())
+	}
+	if entry["level"] != "INFO" || entry["request_id"] != requestID || entry["method"] != "GET" ||
+		entry["path"] != "/ok" || entry["status"] != float64(200) {
+		t.Errorf("Unexpected log entry: %v", entry)
+	}
+	if _, ok := entry["latency_ms"]; !ok {
+		t.Error("Log entry has no latency_ms")
+	}
+
+	// Errors are logged with the status the error handler sent and a client ID is kept
+	buf.Reset()
+	req := httptest.NewRequest("GET", "/missing", nil)
+	req.Header.Set(RequestIDHeader, "client-id.1")
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("Status = %d, want 404", resp.StatusCode)
+	}
+	entry = nil
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Log line is not JSON: %v", err)
+	}
+	if entry["level"] != "WARN" || entry["status"] != float64(404) || entry["request_id"] != "client-id.1" {
+		t.Errorf("Unexpected log entry: %v", entry)
+	}
+
+	// Invalid client IDs are replaced
+	req = httptest.NewRequest("GET", "/ok", nil)
+	req.Header.Set(RequestIDHeader, "bad id\n")
+	resp, _ = app.Test(req)
+	if got := resp.Header.Get(RequestIDHeader); got == "" || got == "bad id\n" {
+		t.Errorf("Invalid request ID was not replaced: %q", got)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(New(&buf, "debug"))
+	defer slog.SetDefault(previous)
+
+	FromContext(WithRequestID(context.Background(), "abc")).Debug("working")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Log line is not JSON: %v", err)
+	}
+	if entry["request_id"] != "abc" || entry["msg"] != "working" || entry["level"] != "DEBUG" {
+		t.Errorf("Unexpected log entry: %v", entry)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := map[string]slog.Level{
+		"debug":   slog.LevelDebug,
+		"INFO":    slog.LevelInfo,
+		"warning": slog.LevelWarn,
+		"error":   slog.LevelError,
+		"bogus":   slog.LevelInfo,
+	}
+	for input, want := range tests {
+		if got := ParseLevel(input); got != want {
+			t.Errorf("ParseLevel(%q) = %v, want %v", input, got, want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Package media validates and resizes uploaded images, such as avatars and video
+// thumbnails.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	// Register the decoders of the other allowed types with image.Decode
+	_ "image/gif"
+	_ "image/jpeg"
+)
+
+const (
+	// MaxImageSize is the largest image file accepted by default
+	MaxImageSize = 5 * 1024 * 1024 // 5MB
+	// MaxImageDimension bounds the width and height of a decoded image, so a small file
+	// can't expand into a huge bitmap
+	MaxImageDimension = 4096
+)
+
+// AllowedImageTypes are the image formats accepted, detected from the file contents
+var AllowedImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+var (
+	// ErrInvalidImage is returned for a file that isn't an image of an allowed type
+	ErrInvalidImage = errors.New("invalid image")
+	// ErrImageTooLarge is returned for an image file over the size limit
+	ErrImageTooLarge = errors.New("image too large")
+)
+
+// sniffLen is how many leading bytes are inspected to detect a file's real type
+const sniffLen = 512
+
+// ValidateImageFile checks that the uploaded file is at most maxSize bytes and an image
+// of an allowed type, and returns its content type. The client-supplied Content-Type is
+// not trusted.
+func ValidateImageFile(file *multipart.FileHeader, maxSize int64) (string, error) {
+	if file.Size > maxSize {
+		return "", fmt.Errorf("%w: %d bytes exceeds the maximum of %d bytes", ErrImageTooLarge, file.Size, maxSize)
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return "", fmt.Errorf("%w: unable to read uploaded file", ErrInvalidImage)
+	}
+	defer f.Close()
+
+	return DetectImageType(f)
+}
+
+// DetectImageType returns the content type of the image read from r, or an error if it
+// isn't one of AllowedImageTypes
+func DetectImageType(r io.Reader) (string, error) {
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("%w: unable to read uploaded file", ErrInvalidImage)
+	}
+
+	contentType := http.DetectContentType(header[:n])
+	// Drop parameters such as "; charset=utf-8"
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	if !AllowedImageTypes[contentType] {
+		return "", fmt.Errorf("%w: content type %s is not allowed, use JPEG, PNG or GIF", ErrInvalidImage, contentType)
+	}
+	return contentType, nil
+}
+
+// Thumbnail decodes the image in data and scales it down to fit within size by size
+// pixels, keeping its aspect ratio, and encodes the result as PNG. Smaller images keep
+// their size. It also rejects files that only look like images.
+func Thumbnail(data []byte, size int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if config.Width > MaxImageDimension || config.Height > MaxImageDimension {
+		return nil, fmt.Errorf("%w: %dx%d pixels exceeds the maximum of %dx%d",
+			ErrInvalidImage, config.Width, config.Height, MaxImageDimension, MaxImageDimension)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaleDown(src, size)); err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleDown fits src within size by size pixels, averaging the source pixels covered by
+// each destination pixel
+func scaleDown(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return src
+	}
+
+	dw, dh := size, size
+	if w > h {
+		dh = max(1, h*size/w)
+	} else {
+		dw = max(1, w*size/h)
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := bounds.Min.Y+y*h/dh, bounds.Min.Y+(y+1)*h/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := bounds.Min.X+x*w/dw, bounds.Min.X+(x+1)*w/dw
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			// Averaged premultiplied values, converted back to non-premultiplied
+			avg := color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)}
+			dst.Set(x, y, avg)
+		}
+	}
+	return dst
+}
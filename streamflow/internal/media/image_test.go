@@ -0,0 +1,111 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// encodePNG returns a w by h PNG filled with c
+func encodePNG(t *testing.T, w, h int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectImageType(t *testing.T) {
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, image.NewGray(image.Rect(0, 0, 4, 4)), nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{"png", encodePNG(t, 2, 2, color.White), "image/png", false},
+		{"jpeg", jpg.Bytes(), "image/jpeg", false},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00"), "image/gif", false},
+		{"text", []byte("not an image"), "", true},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), "", true},
+		{"empty", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DetectImageType(bytes.NewReader(tt.data))
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidImage) {
+					t.Errorf("DetectImageType() error = %v, want ErrInvalidImage", err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("DetectImageType() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		wantW, wantH  int
+	}{
+		{"landscape", 400, 200, 128, 64},
+		{"portrait", 100, 300, 42, 128},
+		{"small kept", 64, 32, 64, 32},
+		{"thin", 1000, 2, 128, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			red := color.NRGBA{R: 255, A: 255}
+			thumb, err := Thumbnail(encodePNG(t, tt.width, tt.height, red), 128)
+			if err != nil {
+				t.Fatalf("Thumbnail() unexpected error = %v", err)
+			}
+
+			img, err := png.Decode(bytes.NewReader(thumb))
+			if err != nil {
+				t.Fatalf("Thumbnail is not a PNG: %v", err)
+			}
+			if b := img.Bounds(); b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+				t.Errorf("Thumbnail size = %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+			}
+			if got := color.NRGBAModel.Convert(img.At(0, 0)); got != red {
+				t.Errorf("Thumbnail pixel = %v, want %v", got, red)
+			}
+		})
+	}
+
+	t.Run("TooManyPixels", func(t *testing.T) {
+		_, err := Thumbnail(encodePNG(t, MaxImageDimension+1, 1, color.White), 128)
+		if !errors.Is(err, ErrInvalidImage) || !strings.Contains(err.Error(), "pixels") {
+			t.Errorf("Thumbnail() error = %v, want ErrInvalidImage for the dimensions", err)
+		}
+	})
+
+	t.Run("NotAnImage", func(t *testing.T) {
+		// A PNG signature followed by garbage passes sniffing but not decoding
+		data := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 64)...)
+		if _, err := Thumbnail(data, 128); !errors.Is(err, ErrInvalidImage) {
+			t.Errorf("Thumbnail() error = %v, want ErrInvalidImage", err)
+		}
+	})
+}
@@ -0,0 +1,229 @@
+// Package migrate applies ordered schema and data migrations to the database. Applied
+// versions are recorded, so each migration runs once per database.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// lockID is the ID of the lock document runners take before migrating
+const lockID = "migrations"
+
+// Migration changes the database from one version of the schema to the next. Up may run
+// again if the runner stops before recording it, so it should be safe to repeat.
+type Migration interface {
+	// Version orders the migrations; every migration has its own positive version
+	Version() int
+	Up(ctx context.Context, db *mongo.Database) error
+}
+
+// New returns a migration running up as the given version
+func New(version int, description string, up func(ctx context.Context, db *mongo.Database) error) Migration {
+	return &migration{version: version, description: description, up: up}
+}
+
+type migration struct {
+	version     int
+	description string
+	up          func(ctx context.Context, db *mongo.Database) error
+}
+
+func (m *migration) Version() int { return m.version }
+
+func (m *migration) Up(ctx context.Context, db *mongo.Database) error { return m.up(ctx, db) }
+
+func (m *migration) String() string { return m.description }
+
+// AppliedMigration records a migration in the migrations collection
+type AppliedMigration struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description,omitempty"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Runner applies pending migrations in version order. Only one runner migrates a
+// database at a time: it holds a lock document while it runs, which it keeps renewing,
+// and other runners wait for it. A lock whose runner died expires after its lease.
+type Runner struct {
+	db            *mongo.Database
+	applied       *mongo.Collection
+	locks         *mongo.Collection
+	migrations    []Migration
+	owner         string        // Identifies this runner's lock
+	lease         time.Duration // How long the lock lasts without renewal
+	retryInterval time.Duration // How often a waiting runner tries to take the lock
+}
+
+// NewRunner creates a runner applying migrations to db
+func NewRunner(db *mongo.Database, migrations ...Migration) *Runner {
+	return &Runner{
+		db:            db,
+		applied:       db.Collection("migrations"),
+		locks:         db.Collection("migration_locks"),
+		migrations:    migrations,
+		owner:         primitive.NewObjectID().Hex(),
+		lease:         time.Minute,
+		retryInterval: time.Second,
+	}
+}
+
+// Run applies the migrations not applied yet, waiting for another runner first if it
+// holds the lock. It stops at the first migration that fails, leaving it pending.
+func (r *Runner) Run(ctx context.Context) error {
+	migrations, err := sortMigrations(r.migrations)
+	if err != nil {
+		return err
+	}
+
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	renewCtx, stopRenewing := context.WithCancel(ctx)
+	defer stopRenewing()
+	go r.renewLock(renewCtx)
+
+	applied, err := r.Applied(ctx)
+	if err != nil {
+		return err
+	}
+	done := make(map[int]bool, len(applied))
+	for _, a := range applied {
+		done[a.Version] = true
+	}
+
+	for _, m := range migrations {
+		if done[m.Version()] {
+			continue
+		}
+
+		description := describe(m)
+		log.Printf("Applying migration %d: %s", m.Version(), description)
+		if err := m.Up(ctx, r.db); err != nil {
+			return fmt.Errorf("migration %d failed: %w", m.Version(), err)
+		}
+
+		record := AppliedMigration{Version: m.Version(), Description: description, AppliedAt: time.Now()}
+		if _, err := r.applied.InsertOne(ctx, record); err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", m.Version(), err)
+		}
+	}
+	return nil
+}
+
+// Applied returns the migrations applied to the database, in version order
+func (r *Runner) Applied(ctx context.Context) ([]AppliedMigration, error) {
+	cursor, err := r.applied.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list applied migrations: %w", err)
+	}
+	applied := []AppliedMigration{}
+	if err := cursor.All(ctx, &applied); err != nil {
+		return nil, fmt.Errorf("failed to list applied migrations: %w", err)
+	}
+	return applied, nil
+}
+
+// lock takes the lock document, waiting while another runner holds it
+func (r *Runner) lock(ctx context.Context) error {
+	waiting := false
+	for {
+		// Matches only an expired lock. A held one makes the upsert insert a second
+		// document with the lock's ID, which fails with a duplicate key error.
+		now := time.Now()
+		_, err := r.locks.UpdateOne(ctx,
+			bson.M{"_id": lockID, "locked_until": bson.M{"$lt": now}},
+			bson.M{"$set": bson.M{"owner": r.owner, "locked_until": now.Add(r.lease)}},
+			options.Update().SetUpsert(true),
+		)
+		if err == nil {
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("failed to take the migration lock: %w", err)
+		}
+
+		if !waiting {
+			log.Printf("Waiting for another instance to finish migrating the database")
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up waiting for the migration lock: %w", ctx.Err())
+		case <-time.After(r.retryInterval):
+		}
+	}
+}
+
+// renewLock extends the lock's lease until ctx is done
+func (r *Runner) renewLock(ctx context.Context) {
+	ticker := time.NewTicker(r.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		result, err := r.locks.UpdateOne(ctx,
+			bson.M{"_id": lockID, "owner": r.owner},
+			bson.M{"$set": bson.M{"locked_until": time.Now().Add(r.lease)}},
+		)
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				log.Printf("Failed to renew the migration lock: %v", err)
+			}
+			continue
+		}
+		if result.MatchedCount == 0 {
+			log.Printf("Migration lock expired and was taken by another instance")
+			return
+		}
+	}
+}
+
+// unlock releases the lock if this runner still holds it
+func (r *Runner) unlock() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := r.locks.DeleteOne(ctx, bson.M{"_id": lockID, "owner": r.owner}); err != nil {
+		log.Printf("Failed to release the migration lock: %v", err)
+	}
+}
+
+// sortMigrations returns migrations in version order, rejecting invalid and repeated versions
+func sortMigrations(migrations []Migration) ([]Migration, error) {
+	sorted := slices.Clone(migrations)
+	slices.SortFunc(sorted, func(a, b Migration) int {
+		return a.Version() - b.Version()
+	})
+	for i, m := range sorted {
+		if m.Version() <= 0 {
+			return nil, fmt.Errorf("migration version must be positive, got %d", m.Version())
+		}
+		if i > 0 && sorted[i-1].Version() == m.Version() {
+			return nil, fmt.Errorf("migration version %d is used more than once", m.Version())
+		}
+	}
+	return sorted, nil
+}
+
+// describe returns the description of a migration made with New
+func describe(m Migration) string {
+	if s, ok := m.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return ""
+}
@@ -0,0 +1,260 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"streamflow/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var testDbService database.Service
+
+func TestMain(m *testing.M) {
+	log.Printf("=== MIGRATION DATABASE TESTS ===")
+
+	// Set test database name to avoid conflicts with production
+	originalDbName := os.Getenv("DB_NAME")
+	os.Setenv("DB_NAME", "test_streamflow_migrate")
+
+	if os.Getenv("DB_URI") == "" {
+		log.Printf("ERROR: DB_URI not set. Please set DB_URI in your .env file")
+		os.Exit(1)
+	}
+
+	testDbService = database.New()
+
+	code := m.Run()
+
+	// Clean up: Drop the test database to remove all test data
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	testDbService.GetDatabase().Drop(ctx)
+	testDbService.Close()
+
+	if originalDbName != "" {
+		os.Setenv("DB_NAME", originalDbName)
+	}
+
+	os.Exit(code)
+}
+
+// resetMigrations forgets every applied migration and lock
+func resetMigrations(t *testing.T) *mongo.Database {
+	t.Helper()
+	ctx := context.Background()
+	db := testDbService.GetDatabase()
+	if err := db.Collection("migrations").Drop(ctx); err != nil {
+		t.Fatalf("Failed to drop migrations: %v", err)
+	}
+	if err := db.Collection("migration_locks").Drop(ctx); err != nil {
+		t.Fatalf("Failed to drop migration locks: %v", err)
+	}
+	return db
+}
+
+// recorder returns a migration appending its version to ran
+func recorder(version int, mu *sync.Mutex, ran *[]int) Migration {
+	return New(version, "test migration", func(ctx context.Context, db *mongo.Database) error {
+		mu.Lock()
+		defer mu.Unlock()
+		*ran = append(*ran, version)
+		return nil
+	})
+}
+
+func TestRunner_Run(t *testing.T) {
+	ctx := context.Background()
+	db := resetMigrations(t)
+
+	var mu sync.Mutex
+	var ran []int
+	runner := NewRunner(db, recorder(3, &mu, &ran), recorder(1, &mu, &ran), recorder(2, &mu, &ran))
+	if err := runner.Run(ctx); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(ran) != 3 || ran[0] != 1 || ran[1] != 2 || ran[2] != 3 {
+		t.Errorf("Ran migrations %v, want [1 2 3]", ran)
+	}
+
+	applied, err := runner.Applied(ctx)
+	if err != nil {
+		t.Fatalf("Applied() error = %v", err)
+	}
+	if len(applied) != 3 || applied[2].Version != 3 || applied[2].Description != "test migration" {
+		t.Errorf("Applied() = %+v, want versions 1 to 3", applied)
+	}
+
+	t.Run("SkipsApplied", func(t *testing.T) {
+		ran = nil
+		runner := NewRunner(db, recorder(1, &mu, &ran), recorder(2, &mu, &ran), recorder(3, &mu, &ran), recorder(4, &mu, &ran))
+		if err := runner.Run(ctx); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		if len(ran) != 1 || ran[0] != 4 {
+			t.Errorf("Ran migrations %v, want only [4]", ran)
+		}
+	})
+
+	t.Run("StopsAtFailure", func(t *testing.T) {
+		db := resetMigrations(t)
+		ran = nil
+		failure := errors.New("boom")
+		runner := NewRunner(db,
+			recorder(1, &mu, &ran),
+			New(2, "failing", func(ctx context.Context, db *mongo.Database) error { return failure }),
+			recorder(3, &mu, &ran),
+		)
+		if err := runner.Run(ctx); !errors.Is(err, failure) {
+			t.Fatalf("Run() error = %v, want %v", err, failure)
+		}
+		if len(ran) != 1 || ran[0] != 1 {
+			t.Errorf("Ran migrations %v, want only [1]", ran)
+		}
+
+		applied, err := runner.Applied(ctx)
+		if err != nil {
+			t.Fatalf("Applied() error = %v", err)
+		}
+		if len(applied) != 1 || applied[0].Version != 1 {
+			t.Errorf("Applied() = %+v, want only version 1", applied)
+		}
+
+		// The lock was released, so a fixed migration can run
+		count, _ := db.Collection("migration_locks").CountDocuments(ctx, bson.M{})
+		if count != 0 {
+			t.Errorf("Lock documents = %d, want 0", count)
+		}
+	})
+
+	t.Run("RejectsRepeatedVersion", func(t *testing.T) {
+		runner := NewRunner(db, recorder(1, &mu, &ran), recorder(1, &mu, &ran))
+		if err := runner.Run(ctx); err == nil {
+			t.Error("Run() error = nil, want an error for the repeated version")
+		}
+	})
+}
+
+func TestRunner_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	db := resetMigrations(t)
+
+	var calls atomic.Int32
+	slow := New(1, "slow", func(ctx context.Context, db *mongo.Database) error {
+		calls.Add(1)
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runner := NewRunner(db, slow)
+			runner.retryInterval = 20 * time.Millisecond
+			errs <- runner.Run(ctx)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Run() error = %v", err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Migration ran %d times, want once", n)
+	}
+}
+
+func TestRunner_ExpiredLock(t *testing.T) {
+	ctx := context.Background()
+	db := resetMigrations(t)
+
+	// Left behind by an instance that died while migrating
+	_, err := db.Collection("migration_locks").InsertOne(ctx, bson.M{
+		"_id":          lockID,
+		"owner":        "dead-instance",
+		"locked_until": time.Now().Add(-time.Second),
+	})
+	if err != nil {
+		t.Fatalf("Failed to insert lock: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := NewRunner(db).Run(ctx); err != nil {
+		t.Errorf("Run() error = %v, want the expired lock to be taken over", err)
+	}
+}
+
+func TestBuiltinMigrations(t *testing.T) {
+	ctx := context.Background()
+	db := resetMigrations(t)
+
+	users := db.Collection("users")
+	videos := db.Collection("videos")
+	users.Drop(ctx)
+	videos.Drop(ctx)
+	users.InsertMany(ctx, []interface{}{
+		bson.M{"_id": "no-role"},
+		bson.M{"_id": "empty-role", "role": ""},
+		bson.M{"_id": "admin", "role": "admin"},
+	})
+	videos.InsertMany(ctx, []interface{}{
+		bson.M{"_id": "no-visibility"},
+		bson.M{"_id": "private", "visibility": "private"},
+	})
+
+	if err := NewRunner(db, All()...).Run(ctx); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	roles := map[string]string{"no-role": "user", "empty-role": "user", "admin": "admin"}
+	for id, want := range roles {
+		var user bson.M
+		if err := users.FindOne(ctx, bson.M{"_id": id}).Decode(&user); err != nil {
+			t.Fatalf("Failed to read user %s: %v", id, err)
+		}
+		if user["role"] != want {
+			t.Errorf("User %s role = %v, want %s", id, user["role"], want)
+		}
+	}
+
+	visibilities := map[string]string{"no-visibility": "public", "private": "private"}
+	for id, want := range visibilities {
+		var video bson.M
+		if err := videos.FindOne(ctx, bson.M{"_id": id}).Decode(&video); err != nil {
+			t.Fatalf("Failed to read video %s: %v", id, err)
+		}
+		if video["visibility"] != want {
+			t.Errorf("Video %s visibility = %v, want %s", id, video["visibility"], want)
+		}
+	}
+}
+
+func TestSortMigrations(t *testing.T) {
+	noop := func(ctx context.Context, db *mongo.Database) error { return nil }
+
+	sorted, err := sortMigrations([]Migration{New(2, "", noop), New(1, "", noop)})
+	if err != nil || sorted[0].Version() != 1 || sorted[1].Version() != 2 {
+		t.Errorf("sortMigrations() = %v, %v, want versions 1 and 2", sorted, err)
+	}
+	if _, err := sortMigrations([]Migration{New(0, "", noop)}); err == nil {
+		t.Error("sortMigrations() error = nil, want an error for version 0")
+	}
+	if _, err := sortMigrations(All()); err != nil {
+		t.Errorf("sortMigrations(All()) error = %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// All returns the migrations of the schema. New ones are appended with the next version;
+// released ones must not change, as databases record them as applied.
+//
+// Migrations use literal values rather than the constants of the packages owning the
+// collections, so they keep doing what they did when those constants change.
+func All() []Migration {
+	return []Migration{
+		New(1, "give users without a role the user role", addDefaultRole),
+		New(2, "make videos without a visibility public", backfillVisibility),
+		New(3, "index videos by owner and by popularity", createVideoIndexes),
+		New(4, "index usernames case-insensitively", createUserNameIndex),
+	}
+}
+
+// addDefaultRole sets the role of users created before roles existed
+func addDefaultRole(ctx context.Context, db *mongo.Database) error {
+	result, err := db.Collection("users").UpdateMany(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"role": bson.M{"$exists": false}},
+			bson.M{"role": ""},
+		}},
+		bson.M{"$set": bson.M{"role": "user"}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set default roles: %w", err)
+	}
+	log.Printf("Gave %d users the default role", result.ModifiedCount)
+	return nil
+}
+
+// backfillVisibility stores the public visibility on videos uploaded before visibility
+// existed. Queries already treat a missing visibility as public.
+func backfillVisibility(ctx context.Context, db *mongo.Database) error {
+	result, err := db.Collection("videos").UpdateMany(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"visibility": bson.M{"$exists": false}},
+			bson.M{"visibility": ""},
+		}},
+		bson.M{"$set": bson.M{"visibility": "public"}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to backfill video visibility: %w", err)
+	}
+	log.Printf("Made %d videos public", result.ModifiedCount)
+	return nil
+}
+
+// createVideoIndexes creates the indexes of the user video list and the popular list.
+// Creating an existing index is a no-op.
+func createVideoIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("videos").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "view_count", Value: -1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create video indexes: %w", err)
+	}
+	return nil
+}
+
+// createUserNameIndex creates the index of username lookups, which ignore case. Queries
+// only use it with the same collation. It isn't unique, as usernames differing only in
+// case were allowed before.
+func createUserNameIndex(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_name", Value: 1}},
+		Options: options.Index().
+			SetName("user_name_ci").
+			SetCollation(&options.Collation{Locale: "en", Strength: 2}),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create username index: %w", err)
+	}
+	return nil
+}
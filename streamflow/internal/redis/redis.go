@@ -0,0 +1,387 @@
+// Package redis is a small Redis client covering what the app needs to share state
+// between instances: plain commands over a pool of connections, and pub/sub.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// dialTimeout bounds connecting to Redis, including authentication
+	dialTimeout = 5 * time.Second
+	// maxIdleConns is how many connections are kept open between commands
+	maxIdleConns = 8
+)
+
+var (
+	// ErrNil is returned for a nil reply, e.g. GET of a missing key
+	ErrNil = errors.New("redis: nil reply")
+	// ErrClosed is returned when using a closed client or subscription
+	ErrClosed = errors.New("redis: client closed")
+)
+
+// Error is an error reply from the server. The connection stays usable after one. Errors
+// nested in an array reply, such as those of EXEC, are returned as items of the array.
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// Options configures a Client
+type Options struct {
+	Addr     string      // host:port
+	Password string      // Sent with AUTH when set
+	DB       int         // Selected after connecting
+	TLS      *tls.Config // Connects over TLS when set
+}
+
+// Client runs commands on a Redis server. It is safe for concurrent use.
+type Client struct {
+	opts Options
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+// New creates a client for the server in opts. No connection is made until first use.
+func New(opts Options) *Client {
+	return &Client{opts: opts}
+}
+
+// Ping checks that the server is reachable
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.Do(ctx, "PING")
+	return err
+}
+
+// Do runs a command and returns its reply: a string for simple strings, []byte for bulk
+// strings, int64 for integers and []interface{} for arrays. A nil reply is returned as
+// ErrNil and an error reply as Error. Arguments may be strings, []byte or integers. The
+// command is abandoned when ctx is done.
+func (c *Client) Do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := cn.do(ctx, args...)
+	var replyErr Error
+	if err != nil && !errors.Is(err, ErrNil) && !errors.As(err, &replyErr) {
+		// After a network or protocol error, or an abandoned command, the rest of the
+		// reply may still be unread, so the connection can't be reused
+		cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// Close closes the idle connections. Commands running meanwhile finish, after which
+// their connections are closed too.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for _, cn := range c.idle {
+		cn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	return c.dial(ctx)
+}
+
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || len(c.idle) >= maxIdleConns {
+		cn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+// dial connects to the server, authenticating and selecting the database
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+
+	var netConn net.Conn
+	var err error
+	if c.opts.TLS != nil {
+		dialer := tls.Dialer{Config: c.opts.TLS}
+		netConn, err = dialer.DialContext(ctx, "tcp", c.opts.Addr)
+	} else {
+		var dialer net.Dialer
+		netConn, err = dialer.DialContext(ctx, "tcp", c.opts.Addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis: failed to connect to %s: %w", c.opts.Addr, err)
+	}
+	cn := &conn{Conn: netConn, r: bufio.NewReader(netConn), w: bufio.NewWriter(netConn)}
+
+	if c.opts.Password != "" {
+		if _, err := cn.do(ctx, "AUTH", c.opts.Password); err != nil {
+			cn.Close()
+			return nil, fmt.Errorf("redis: authentication failed: %w", err)
+		}
+	}
+	if c.opts.DB != 0 {
+		if _, err := cn.do(ctx, "SELECT", c.opts.DB); err != nil {
+			cn.Close()
+			return nil, fmt.Errorf("redis: failed to select database %d: %w", c.opts.DB, err)
+		}
+	}
+	return cn, nil
+}
+
+// Int runs a command replying with an integer
+func (c *Client) Int(ctx context.Context, args ...interface{}) (int64, error) {
+	reply, err := c.Do(ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case []byte:
+		return strconv.ParseInt(string(v), 10, 64)
+	}
+	return 0, fmt.Errorf("redis: unexpected reply %T, want an integer", reply)
+}
+
+// Strings runs a command replying with an array of strings
+func (c *Client) Strings(ctx context.Context, args ...interface{}) ([]string, error) {
+	reply, err := c.Do(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected reply %T, want an array", reply)
+	}
+	strs := make([]string, 0, len(items))
+	for _, item := range items {
+		b, ok := item.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("redis: unexpected array item %T, want a string", item)
+		}
+		strs = append(strs, string(b))
+	}
+	return strs, nil
+}
+
+// Message is a message received on a subscription
+type Message struct {
+	Channel string
+	Payload []byte
+}
+
+// Subscription receives the messages published on the channels matching a pattern. It
+// holds a connection of its own.
+type Subscription struct {
+	cn *conn
+}
+
+// PSubscribe subscribes to the channels matching pattern
+func (c *Client) PSubscribe(ctx context.Context, pattern string) (*Subscription, error) {
+	cn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The reply confirms the subscription
+	if _, err := cn.do(ctx, "PSUBSCRIBE", pattern); err != nil {
+		cn.Close()
+		return nil, err
+	}
+	return &Subscription{cn: cn}, nil
+}
+
+// Receive waits for the next message. It returns an error once the subscription is
+// closed or its connection fails.
+func (s *Subscription) Receive() (Message, error) {
+	for {
+		reply, err := s.cn.read()
+		if err != nil {
+			return Message{}, err
+		}
+		// Pattern messages are ["pmessage", pattern, channel, payload]
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 4 {
+			continue
+		}
+		kind, _ := items[0].([]byte)
+		channel, _ := items[2].([]byte)
+		payload, _ := items[3].([]byte)
+		if string(kind) != "pmessage" {
+			continue
+		}
+		return Message{Channel: string(channel), Payload: payload}, nil
+	}
+}
+
+// Close ends the subscription, unblocking Receive
+func (s *Subscription) Close() error {
+	return s.cn.Close()
+}
+
+// conn is a single connection speaking RESP
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// do writes a command and reads its reply, within ctx's deadline. Cancelling ctx
+// interrupts the command; ctx's error is returned and the connection must be closed.
+func (cn *conn) do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	cn.SetDeadline(deadline)
+	defer cn.SetDeadline(time.Time{})
+	// Blocked reads and writes return once the deadline is moved into the past
+	stop := context.AfterFunc(ctx, func() { cn.SetDeadline(time.Unix(1, 0)) })
+
+	reply, err := cn.roundTrip(args...)
+	if !stop() {
+		// Interrupted, or cancelled just as the reply arrived; either way the deadline
+		// has been tripped
+		return nil, ctx.Err()
+	}
+	return reply, err
+}
+
+func (cn *conn) roundTrip(args ...interface{}) (interface{}, error) {
+	if err := writeCommand(cn.w, args...); err != nil {
+		return nil, err
+	}
+	if err := cn.w.Flush(); err != nil {
+		return nil, err
+	}
+	return cn.read()
+}
+
+func (cn *conn) read() (interface{}, error) {
+	return readReply(cn.r)
+}
+
+// writeCommand encodes a command as an array of bulk strings
+func writeCommand(w *bufio.Writer, args ...interface{}) error {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		var b []byte
+		switch v := arg.(type) {
+		case string:
+			b = []byte(v)
+		case []byte:
+			b = v
+		case int:
+			b = strconv.AppendInt(nil, int64(v), 10)
+		case int64:
+			b = strconv.AppendInt(nil, v, 10)
+		default:
+			return fmt.Errorf("redis: unsupported argument type %T", arg)
+		}
+		fmt.Fprintf(w, "$%d\r\n", len(b))
+		w.Write(b)
+		w.WriteString("\r\n")
+	}
+	return nil
+}
+
+// readReply decodes a single RESP reply
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(string(line[1:]), 10, 64)
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk length: %w", err)
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid array length: %w", err)
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		// Nested error replies become items, so the whole array is always read and the
+		// connection stays in step with the server
+		items := make([]interface{}, n)
+		for i := range items {
+			item, err := readReply(r)
+			var replyErr Error
+			switch {
+			case errors.As(err, &replyErr):
+				item = replyErr
+			case err != nil && !errors.Is(err, ErrNil):
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+}
+
+// readLine reads a line without its CRLF
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply line")
+	}
+	return line[:len(line)-2], nil
+}
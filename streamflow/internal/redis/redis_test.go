@@ -0,0 +1,285 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadReply(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader(
+		"+OK\r\n" +
+			"-ERR wrong type\r\n" +
+			":42\r\n" +
+			"$5\r\nhello\r\n" +
+			"$-1\r\n" +
+			"*3\r\n$1\r\na\r\n:7\r\n$-1\r\n",
+	))
+
+	reply, err := readReply(r)
+	if err != nil || reply != "OK" {
+		t.Fatalf("simple string: got %v, %v", reply, err)
+	}
+
+	_, err = readReply(r)
+	var replyErr Error
+	if !errors.As(err, &replyErr) || string(replyErr) != "ERR wrong type" {
+		t.Fatalf("error reply: got %v", err)
+	}
+
+	reply, err = readReply(r)
+	if err != nil || reply != int64(42) {
+		t.Fatalf("integer: got %v, %v", reply, err)
+	}
+
+	reply, err = readReply(r)
+	if b, ok := reply.([]byte); err != nil || !ok || string(b) != "hello" {
+		t.Fatalf("bulk string: got %v, %v", reply, err)
+	}
+
+	if _, err = readReply(r); !errors.Is(err, ErrNil) {
+		t.Fatalf("nil bulk string: got %v", err)
+	}
+
+	reply, err = readReply(r)
+	items, ok := reply.([]interface{})
+	if err != nil || !ok || len(items) != 3 {
+		t.Fatalf("array: got %v, %v", reply, err)
+	}
+	if string(items[0].([]byte)) != "a" || items[1] != int64(7) || items[2] != nil {
+		t.Errorf("array items: got %v", items)
+	}
+}
+
+func TestReadReply_NestedError(t *testing.T) {
+	// An EXEC whose second command failed, followed by the next reply
+	r := bufio.NewReader(strings.NewReader("*3\r\n:1\r\n-ERR wrong type\r\n:2\r\n+OK\r\n"))
+
+	reply, err := readReply(r)
+	items, ok := reply.([]interface{})
+	if err != nil || !ok || len(items) != 3 {
+		t.Fatalf("array: got %v, %v", reply, err)
+	}
+	if items[0] != int64(1) || items[1] != Error("ERR wrong type") || items[2] != int64(2) {
+		t.Errorf("array items: got %v", items)
+	}
+
+	// The whole array was read, so the next reply is intact
+	if reply, err := readReply(r); err != nil || reply != "OK" {
+		t.Errorf("next reply: got %v, %v", reply, err)
+	}
+}
+
+func TestWriteCommand(t *testing.T) {
+	var sb strings.Builder
+	w := bufio.NewWriter(&sb)
+	if err := writeCommand(w, "SET", []byte("key"), 10); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+
+	want := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$2\r\n10\r\n"
+	if sb.String() != want {
+		t.Errorf("got %q, want %q", sb.String(), want)
+	}
+
+	if err := writeCommand(w, "SET", 1.5); err == nil {
+		t.Error("expected an error for an unsupported argument")
+	}
+}
+
+// fakeServer answers commands with a handler, one connection per goroutine
+type fakeServer struct {
+	ln      net.Listener
+	handler func(args []string) string
+
+	mu       sync.Mutex
+	commands [][]string
+}
+
+func newFakeServer(t *testing.T, handler func(args []string) string) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s := &fakeServer{ln: ln, handler: handler}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, item := range reply.([]interface{}) {
+			args = append(args, string(item.([]byte)))
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, args)
+		s.mu.Unlock()
+
+		if _, err := conn.Write([]byte(s.handler(args))); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeServer) received() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]string(nil), s.commands...)
+}
+
+func TestClient_Do(t *testing.T) {
+	server := newFakeServer(t, func(args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "AUTH", "SELECT", "PING":
+			return "+OK\r\n"
+		case "INCR":
+			return ":3\r\n"
+		case "GET":
+			return "$-1\r\n"
+		case "SMEMBERS":
+			return "*2\r\n$1\r\na\r\n$1\r\nb\r\n"
+		}
+		return "-ERR unknown command\r\n"
+	})
+
+	client := New(Options{Addr: server.ln.Addr().String(), Password: "secret", DB: 2})
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if n, err := client.Int(ctx, "INCR", "counter"); err != nil || n != 3 {
+		t.Errorf("INCR: got %d, %v", n, err)
+	}
+	if _, err := client.Do(ctx, "GET", "missing"); !errors.Is(err, ErrNil) {
+		t.Errorf("GET: got %v, want ErrNil", err)
+	}
+	if members, err := client.Strings(ctx, "SMEMBERS", "set"); err != nil || len(members) != 2 {
+		t.Errorf("SMEMBERS: got %v, %v", members, err)
+	}
+	var replyErr Error
+	if _, err := client.Do(ctx, "NOPE"); !errors.As(err, &replyErr) {
+		t.Errorf("unknown command: got %v, want an error reply", err)
+	}
+
+	// Authentication and database selection happen once, on the pooled connection
+	commands := server.received()
+	if len(commands) < 2 || commands[0][0] != "AUTH" || commands[0][1] != "secret" ||
+		commands[1][0] != "SELECT" || commands[1][1] != "2" {
+		t.Errorf("expected AUTH and SELECT first, got %v", commands)
+	}
+	auths := 0
+	for _, cmd := range commands {
+		if cmd[0] == "AUTH" {
+			auths++
+		}
+	}
+	if auths != 1 {
+		t.Errorf("expected the connection to be reused, got %d connections", auths)
+	}
+
+	client.Close()
+	if err := client.Ping(ctx); !errors.Is(err, ErrClosed) {
+		t.Errorf("Ping after Close: got %v, want ErrClosed", err)
+	}
+}
+
+func TestClient_DoCancelled(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	server := newFakeServer(t, func(args []string) string {
+		if args[0] == "BLPOP" {
+			<-block
+		}
+		return "+OK\r\n"
+	})
+
+	client := New(Options{Addr: server.ln.Addr().String()})
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := client.Do(ctx, "BLPOP", "queue", 0); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Do: got %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Do returned %v after the cancellation", elapsed)
+	}
+
+	// The interrupted connection was dropped, so the next command gets its own reply
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx); err != nil {
+		t.Errorf("Ping after a cancelled command: %v", err)
+	}
+}
+
+func TestClient_PSubscribe(t *testing.T) {
+	server := newFakeServer(t, func(args []string) string {
+		if args[0] != "PSUBSCRIBE" {
+			return "-ERR unknown command\r\n"
+		}
+		// Confirm the subscription, then deliver a message on it
+		return "*3\r\n$10\r\npsubscribe\r\n$6\r\nchat:*\r\n:1\r\n" +
+			"*4\r\n$8\r\npmessage\r\n$6\r\nchat:*\r\n$6\r\nchat:1\r\n$5\r\nhello\r\n"
+	})
+
+	client := New(Options{Addr: server.ln.Addr().String()})
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sub, err := client.PSubscribe(ctx, "chat:*")
+	if err != nil {
+		t.Fatalf("PSubscribe: %v", err)
+	}
+
+	msg, err := sub.Receive()
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if msg.Channel != "chat:1" || string(msg.Payload) != "hello" {
+		t.Errorf("got message %+v", msg)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := sub.Receive()
+		done <- err
+	}()
+	sub.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected Receive to fail after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Receive didn't return after Close")
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"slices"
+	"strings"
+
+	"streamflow/internal/logger"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+)
+
+// corsMiddleware allows cross-origin requests from the given origins. The request's
+// Origin is echoed back only if it is in the list, and credentials are allowed. A "*"
+// entry allows any origin instead, but without credentials, as browsers reject
+// credentialed responses to a wildcard. With no origins no cross-origin request is allowed.
+func corsMiddleware(origins []string) fiber.Handler {
+	if len(origins) == 0 {
+		return func(c *fiber.Ctx) error {
+			return c.Next()
+		}
+	}
+
+	cfg := cors.Config{
+		AllowMethods:  "GET,POST,PUT,DELETE,OPTIONS,PATCH",
+		AllowHeaders:  "Accept,Authorization,Content-Type,X-CSRF-Token," + logger.RequestIDHeader,
+		ExposeHeaders: logger.RequestIDHeader,
+		MaxAge:        300,
+	}
+	if slices.Contains(origins, "*") {
+		cfg.AllowOrigins = "*"
+	} else {
+		cfg.AllowOrigins = strings.Join(origins, ",")
+		cfg.AllowCredentials = true
+	}
+	return cors.New(cfg)
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"streamflow/internal/database"
+	"streamflow/internal/livestream"
+	"streamflow/internal/version"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// readinessTimeout bounds the database checks behind /readyz
+const readinessTimeout = 5 * time.Second
+
+// requiredCollections must exist for the instance to be ready. The services create them
+// along with their indexes at startup.
+var requiredCollections = []string{"users", "videos", "livestreams"}
+
+// HealthResponse is the body of /readyz and /health
+type HealthResponse struct {
+	Status   string                `json:"status"` // "ready" or "unavailable"
+	Database database.HealthReport `json:"database"`
+	FFmpeg   FFmpegReport          `json:"ffmpeg"`
+	Version  version.Info          `json:"version"`
+}
+
+// FFmpegReport is whether ffmpeg, which transcodes uploads and records streams, was found
+// at startup
+type FFmpegReport struct {
+	Available bool   `json:"available"`
+	Required  bool   `json:"required"` // Missing ffmpeg makes the instance unready
+	Version   string `json:"version,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Ready reports whether ffmpeg is available or can do without it
+func (r FFmpegReport) Ready() bool {
+	return r.Available || !r.Required
+}
+
+// checkFFmpeg looks for ffmpeg once at startup, so a missing install shows up before
+// the first upload or recording fails
+func checkFFmpeg(required bool) FFmpegReport {
+	report := FFmpegReport{Required: required}
+	ffmpeg := livestream.NewFFmpegService()
+	if err := ffmpeg.CheckFFmpegAvailable(); err != nil {
+		report.Error = err.Error()
+		if required {
+			log.Printf("ffmpeg is required but unavailable, the instance won't be ready: %v", err)
+		} else {
+			log.Printf("Warning: ffmpeg unavailable, uploads won't be transcoded and streams won't be recorded: %v", err)
+		}
+		return report
+	}
+
+	report.Available = true
+	report.Version, _ = ffmpeg.TestFFmpegConnection()
+	log.Printf("Found %s", report.Version)
+	return report
+}
+
+// livenessHandler reports that the process is up. It doesn't touch the database, so a
+// database outage doesn't get the instance restarted.
+func (s *FiberServer) livenessHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": "ok"})
+}
+
+// readinessHandler reports whether the instance can serve traffic. It responds 503 when
+// the database is unreachable or incomplete, or required ffmpeg is missing, so load
+// balancers route around the instance.
+func (s *FiberServer) readinessHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), readinessTimeout)
+	defer cancel()
+
+	report := s.db.Check(ctx, requiredCollections)
+	resp := HealthResponse{
+		Status:   "ready",
+		Database: report,
+		FFmpeg:   s.ffmpeg,
+		Version:  version.Get(),
+	}
+	if !report.Ready() || !s.ffmpeg.Ready() {
+		resp.Status = "unavailable"
+		return c.Status(fiber.StatusServiceUnavailable).JSON(resp)
+	}
+	return c.JSON(resp)
+}
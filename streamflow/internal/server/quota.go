@@ -0,0 +1,66 @@
+package server
+
+import (
+	"streamflow/internal/apperr"
+	"streamflow/internal/users"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// QuotaUsage reports current usage against a configured limit. A limit of 0 means unlimited.
+type QuotaUsage struct {
+	Used  int64 `json:"used"`
+	Limit int64 `json:"limit"`
+}
+
+// QuotaResponse aggregates all per-user limits and current usage
+type QuotaResponse struct {
+	Storage       QuotaUsage `json:"storage"`
+	Videos        QuotaUsage `json:"videos"`
+	ActiveStreams QuotaUsage `json:"active_streams"`
+}
+
+// quotaHandler returns the authenticated user's limits and usage
+func (s *FiberServer) quotaHandler(c *fiber.Ctx) error {
+	userID, err := users.GetUserIDFromLocals(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	storageUsed, err := s.videoService.GetUserStorageUsage(c.UserContext(), userID)
+	if err != nil {
+		return apperr.Internal("Failed to get storage usage")
+	}
+
+	videoCount, err := s.videoService.CountUserVideos(c.UserContext(), userID)
+	if err != nil {
+		return apperr.Internal("Failed to get video count")
+	}
+
+	activeStreams, err := s.livestreamService.CountActiveStreams(userID)
+	if err != nil {
+		return apperr.Internal("Failed to get active streams")
+	}
+
+	limits := s.cfg.Limits
+	return c.JSON(QuotaResponse{
+		Storage:       QuotaUsage{Used: storageUsed, Limit: limits.StorageQuotaBytes},
+		Videos:        QuotaUsage{Used: videoCount, Limit: int64(limits.MaxVideos)},
+		ActiveStreams: QuotaUsage{Used: activeStreams, Limit: int64(limits.MaxConcurrentStreams)},
+	})
+}
+
+// storageUsageHandler returns the authenticated user's storage usage against their quota
+func (s *FiberServer) storageUsageHandler(c *fiber.Ctx) error {
+	userID, err := users.GetUserIDFromLocals(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	used, err := s.videoService.GetUserStorageUsage(c.UserContext(), userID)
+	if err != nil {
+		return apperr.Internal("Failed to get storage usage")
+	}
+
+	return c.JSON(QuotaUsage{Used: used, Limit: s.cfg.Limits.StorageQuotaBytes})
+}
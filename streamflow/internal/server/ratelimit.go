@@ -0,0 +1,34 @@
+package server
+
+import (
+	"time"
+
+	"streamflow/internal/apperr"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+)
+
+// rateLimiter allows max requests per client IP in each window. Requests over the limit
+// get a 429 with a Retry-After header. Every call returns a limiter with its own
+// counters, so routes given their own limiter don't share a budget. A max of zero or
+// less disables limiting.
+func rateLimiter(max int, window time.Duration) fiber.Handler {
+	if max <= 0 {
+		return func(c *fiber.Ctx) error {
+			return c.Next()
+		}
+	}
+
+	return limiter.New(limiter.Config{
+		Max:        max,
+		Expiration: window,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			return c.IP() // limit by IP address
+		},
+		LimitReached: func(c *fiber.Ctx) error {
+			// The limiter has already set Retry-After
+			return apperr.New(apperr.ErrRateLimited, "Too many requests, please try again later")
+		},
+	})
+}
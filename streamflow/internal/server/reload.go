@@ -0,0 +1,85 @@
+package server
+
+import (
+	"log"
+	"slices"
+	"sync/atomic"
+
+	"streamflow/internal/config"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// reloadable is a middleware whose handler can be replaced while requests are served
+type reloadable struct {
+	handler atomic.Pointer[fiber.Handler]
+}
+
+func newReloadable(h fiber.Handler) *reloadable {
+	r := &reloadable{}
+	r.swap(h)
+	return r
+}
+
+// Handle runs the current handler
+func (r *reloadable) Handle(c *fiber.Ctx) error {
+	return (*r.handler.Load())(c)
+}
+
+func (r *reloadable) swap(h fiber.Handler) {
+	r.handler.Store(&h)
+}
+
+// authRateLimiter returns the stricter limiter of a login or registration route. Each
+// route gets its own, so they don't share a budget.
+func (s *FiberServer) authRateLimiter() fiber.Handler {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	security := s.securityConfig()
+	limiter := newReloadable(rateLimiter(security.AuthRateLimit, security.AuthRateWindow))
+	s.authLimiters = append(s.authLimiters, limiter)
+	return limiter.Handle
+}
+
+// ApplyConfig applies the settings of cfg that can change while the server runs: the CORS
+// origins and the rate limits. A changed limit starts its counters over. Everything else
+// in cfg is only read at startup and is ignored.
+func (s *FiberServer) ApplyConfig(cfg *config.Config) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	current, next := s.securityConfig(), cfg.Security
+	if !slices.Equal(current.CORSOrigins, next.CORSOrigins) {
+		if s.cors != nil {
+			s.cors.swap(corsMiddleware(next.CORSOrigins))
+		}
+		log.Printf("CORS origins changed to %v", next.CORSOrigins)
+	}
+	if current.RateLimit != next.RateLimit || current.RateWindow != next.RateWindow {
+		if s.rateLimit != nil {
+			s.rateLimit.swap(rateLimiter(next.RateLimit, next.RateWindow))
+		}
+		log.Printf("Rate limit changed to %d per %s", next.RateLimit, next.RateWindow)
+	}
+	if current.AuthRateLimit != next.AuthRateLimit || current.AuthRateWindow != next.AuthRateWindow {
+		for _, limiter := range s.authLimiters {
+			limiter.swap(rateLimiter(next.AuthRateLimit, next.AuthRateWindow))
+		}
+		log.Printf("Auth rate limit changed to %d per %s", next.AuthRateLimit, next.AuthRateWindow)
+	}
+
+	// Copied, so later changes to cfg don't reach the server without a reload
+	next.CORSOrigins = slices.Clone(next.CORSOrigins)
+	s.security = &next
+}
+
+// securityConfig returns the security settings in effect, which start out as those the
+// server was created with. The caller must hold reloadMu.
+func (s *FiberServer) securityConfig() config.SecurityConfig {
+	if s.security == nil {
+		security := s.cfg.Security
+		s.security = &security
+	}
+	return *s.security
+}
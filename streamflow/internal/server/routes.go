@@ -2,9 +2,12 @@ package server
 
 import (
 	"log"
+	"strings"
+	"streamflow/internal/audit"
 	"streamflow/internal/livestream"
 	"streamflow/internal/users"
 	"streamflow/internal/video"
+	"streamflow/internal/video/uploadlimit"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
@@ -12,52 +15,152 @@ import (
 
 func (s *FiberServer) RegisterFiberRoutes() {
 	s.App.Get("/", s.HelloWorldHandler)
-	s.App.Get("/health", s.healthHandler)
+	s.App.Get("/livez", s.livenessHandler)
+	s.App.Get("/readyz", s.readinessHandler)
+	s.App.Get("/health", s.readinessHandler) // Kept for existing monitors
 
 	// User routes (public routes)
 	userHandler := users.NewUserHandler(s.userService, s.jwtService)
-	s.App.Post("/user/register", userHandler.CreateUser)
-	s.App.Post("/user/login", userHandler.LoginUser)
+	userHandler.SetAuditLog(s.audit)
+	userHandler.SetSessionCookie(s.cfg.JWT.SessionCookie)
+	s.App.Post("/user/register", s.authRateLimiter(), userHandler.CreateUser)
+	s.App.Post("/user/login", s.authRateLimiter(), userHandler.LoginUser)
+	s.App.Post("/user/login/2fa", s.authRateLimiter(), userHandler.LoginTwoFactor)
+	s.App.Post("/user/refresh", s.authRateLimiter(), userHandler.RefreshToken)
+	s.App.Post("/user/logout", userHandler.Logout)
+	s.App.Get("/user/by-username/:name", userHandler.GetUserByUsername)
+	s.App.Get("/user/:id/avatar", userHandler.GetAvatar)
+	s.App.Get("/user/:id/profile", userHandler.GetPublicProfile)
 
 	// Protected routes
 	api := s.App.Group("/api", s.authMiddleware)
 	api.Get("/user/me", userHandler.GetUser)
+	api.Get("/user/me/quota", s.quotaHandler)
+	api.Get("/user/usage", s.storageUsageHandler)
+	api.Post("/user/me/avatar", userHandler.UploadAvatar)
+	api.Get("/user/me/sessions", userHandler.ListSessions)
+	api.Delete("/user/me/sessions/:id", userHandler.RevokeSession)
+	api.Post("/user/2fa/enable", userHandler.EnableTOTP)
+	api.Post("/user/2fa/verify", userHandler.VerifyTOTPSetup)
+	api.Get("/user/:id/followers", userHandler.GetFollowers)
+	api.Post("/user/:id/follow", userHandler.FollowUser)
+	api.Delete("/user/:id/follow", userHandler.UnfollowUser)
 
 	// Video routes
 	videoHandler := video.NewVideoHandler(s.videoService)
-	api.Post("/video/upload", videoHandler.UploadVideo)
+	videoHandler.SetAuditLog(s.audit)
+	videoHandler.SetUploadLimiter(uploadlimit.New(s.cfg.Video.MaxConcurrentUploads, s.cfg.Video.UploadQueueTimeout))
+	api.Post("/video/upload", videoHandler.LimitUploads, videoHandler.UploadVideo)
+	api.Post("/video/upload/init", videoHandler.InitUpload)
+	api.Post("/video/upload/validate", videoHandler.ValidateUpload)
+	api.Get("/video/upload/:uploadID", videoHandler.GetUploadStatus)
+	api.Put("/video/upload/:uploadID/chunk", videoHandler.LimitUploads, videoHandler.UploadChunk)
+	api.Post("/video/upload/:uploadID/complete", videoHandler.LimitUploads, videoHandler.CompleteUpload)
 	api.Get("/video/list", videoHandler.ListVideos)
 	api.Get("/video/popular", videoHandler.GetPopularVideos)
 	api.Get("/video/trending", videoHandler.GetTrendingVideos)
+	api.Get("/video/search", videoHandler.SearchVideos)
+	api.Post("/video/batch-delete", videoHandler.DeleteVideos)
 	api.Get("/video/:id", videoHandler.GetVideo)
+	api.Get("/video/:id/related", videoHandler.GetRelatedVideos)
+	api.Post("/video/:id/share", videoHandler.CreateShareLink)
+	api.Delete("/video/:id/share/:linkId", videoHandler.RevokeShareLink)
 	api.Put("/video/:id", videoHandler.UpdateVideo)
-	api.Patch("/video/:id/status", videoHandler.UpdateVideoStatus)
+	api.Put("/video/:id/file", videoHandler.LimitUploads, videoHandler.ReplaceVideoFile)
 	api.Delete("/video/:id", videoHandler.DeleteVideo)
+	api.Post("/video/:id/restore", videoHandler.RestoreVideo)
+	api.Put("/video/:id/chapters", videoHandler.SetChapters)
+	api.Get("/video/:id/download", videoHandler.DownloadVideo)
+	api.Get("/video/:id/processing", videoHandler.GetProcessingStatus)
+	api.Get("/video/:id/processing/stream", videoHandler.StreamProcessingStatus)
+	api.Get("/video/:id/like", videoHandler.GetLikeStatus)
+	api.Post("/video/:id/like", videoHandler.LikeVideo)
+	api.Delete("/video/:id/like", videoHandler.UnlikeVideo)
+	api.Post("/video/:id/progress", videoHandler.RecordWatchProgress)
+	api.Get("/video/:id/thumbnails", videoHandler.ListThumbnails)
+	api.Put("/video/:id/thumbnail", videoHandler.SetThumbnail)
+	api.Get("/user/history", videoHandler.GetWatchHistory)
+	api.Get("/user/videos", videoHandler.GetUserVideos)
 	api.Post("/video/reprocess", videoHandler.ReprocessVideos)
 	api.Post("/video/migrate", videoHandler.MigrateVideoFields)
 
-	// Public routes (no auth needed)
-	s.App.Get("/stream/:id/playlist.m3u8", videoHandler.StreamVideo)
-	s.App.Get("/stream/:id/segments/:segment", videoHandler.ServeVideoSegment)
-	s.App.Get("/thumbnail/:id", videoHandler.GetVideoThumbnail)
-	s.App.Get("/video/:id/timestamp", videoHandler.GetVideoTimestamp)
+	// Playlist routes
+	api.Post("/playlist", videoHandler.CreatePlaylist)
+	api.Get("/playlist", videoHandler.ListPlaylists)
+	api.Get("/playlist/:id", videoHandler.GetPlaylist)
+	api.Post("/playlist/:id/videos", videoHandler.AddToPlaylist)
+	api.Delete("/playlist/:id/videos/:videoId", videoHandler.RemoveFromPlaylist)
+
+	// Admin routes
+	admin := api.Group("/admin", s.adminMiddleware, s.auditAdminMiddleware)
+	admin.Post("/videos/bulk-status", videoHandler.AdminBulkSetStatus)
+	admin.Post("/video/reprobe", videoHandler.AdminReprobeMetadata)
+	admin.Get("/video/reprobe", videoHandler.AdminReprobeStatus)
+	admin.Get("/video/status/:status", videoHandler.AdminListVideosByStatus)
+	admin.Patch("/video/:id/status", videoHandler.UpdateVideoStatus)
+	admin.Post("/video/:id/retry", videoHandler.AdminRetryProcessing)
+	admin.Get("/storage", videoHandler.AdminStorageStats)
+	admin.Post("/storage/cleanup", videoHandler.AdminCleanupStorage)
+	admin.Get("/audit", audit.NewAuditHandler(s.audit).ListRecords)
+	admin.Get("/stats", s.statsHandler)
+
+	// Public routes (no auth needed). A token is still read when sent, so owners can
+	// watch their private videos, and a ?share= link token opens the video it was made for.
+	optionalAuth := s.jwtService.OptionalMiddleware()
+	s.App.Get("/stream/:id", optionalAuth, videoHandler.StreamVideoFile)
+	s.App.Get("/stream/:id/playlist.m3u8", optionalAuth, videoHandler.StreamVideo)
+	s.App.Get("/stream/:id/segments/:segment", optionalAuth, videoHandler.ServeVideoSegment)
+	s.App.Get("/thumbnail/:id", optionalAuth, videoHandler.GetVideoThumbnail)
+	s.App.Get("/video/:id/timestamp", optionalAuth, videoHandler.GetVideoTimestamp)
 
 	// Livestream routes
 	livestreamHandler := livestream.NewLivestreamHandler(s.livestreamService)
+	livestreamHandler.SetAuditLog(s.audit)
+	livestreamHandler.SetStreamManager(s.streamManager)
+	webRTCManager, err := livestream.NewWebRTCManager(s.streamManager, s.cfg.Livestream)
+	if err != nil {
+		log.Printf("Failed to create WebRTC manager: %v", err)
+	} else {
+		livestreamHandler.SetWebRTCManager(webRTCManager)
+	}
 	api.Post("/livestream/start", livestreamHandler.StartStream)
 	api.Post("/livestream/stop", livestreamHandler.StopStream)
+	api.Post("/livestream/schedule", livestreamHandler.ScheduleStream)
+	api.Get("/livestream/upcoming", livestreamHandler.GetUpcomingStreams)
+	api.Get("/livestream/following", livestreamHandler.GetFollowedLiveStreams)
+	api.Get("/livestream/active", livestreamHandler.GetActiveStream)
 	api.Get("/livestream/status/:id", livestreamHandler.GetStreamStatus)
 	api.Get("/livestream/streams", livestreamHandler.ListStreams)
 	api.Get("/livestream/popular", livestreamHandler.GetPopularStreams)
+	api.Get("/livestream/featured", livestreamHandler.GetFeaturedStreams)
 	api.Get("/livestream/search", livestreamHandler.SearchStreams)
+	api.Get("/livestream/tag/:tag", livestreamHandler.ListStreamsByTag)
+	api.Get("/livestream/category/:category", livestreamHandler.GetStreamsByCategory)
+	api.Get("/livestream/categories", livestreamHandler.ListCategories)
+	api.Put("/livestream/:id/tags", livestreamHandler.SetStreamTags)
+	api.Get("/livestream/:id/messages", livestreamHandler.GetMessages)
+	api.Get("/livestream/:id/messages/export", livestreamHandler.ExportMessages)
+	api.Get("/livestream/:id/messages/user/:userID", livestreamHandler.GetMessagesByUser)
+	api.Delete("/livestream/:id/messages/user/:userID", livestreamHandler.DeleteMessagesByUser)
+	api.Get("/livestream/:id/recording", livestreamHandler.GetStreamRecording)
+	api.Get("/livestream/:id/preview", livestreamHandler.GetStreamPreview)
+	api.Get("/livestream/:id/analytics", livestreamHandler.GetStreamAnalytics)
+	api.Get("/livestream/:id/timeline", livestreamHandler.GetViewerTimeline)
+	api.Post("/livestream/:id/rotate-key", livestreamHandler.RotateStreamKey)
+	api.Post("/livestream/:id/watch/offer", livestreamHandler.WatchStream)
+	api.Get("/livestream/:id", livestreamHandler.GetStream)
+	api.Delete("/livestream/:id", livestreamHandler.DeleteStream)
+	admin.Post("/livestream/stop-all", livestreamHandler.AdminStopAllStreams)
+	admin.Post("/livestream/:id/feature", livestreamHandler.AdminFeatureStream)
+	admin.Delete("/livestream/:id/feature", livestreamHandler.AdminUnfeatureStream)
 
-	// WebSocket route for livestreaming
-	hub := livestream.NewWebSocketHub()
-	go hub.Run()
-	streamManager := livestream.NewStreamManager(s.livestreamService)
-	webRTCManager, err := livestream.NewWebRTCManager(streamManager)
-	if err != nil {
-		log.Printf("Failed to create WebRTC manager: %v", err)
+	// WebSocket route for livestream chat and WebRTC signalling
+	hub := livestream.NewChatHub(s.livestreamService)
+	if s.chatBroker != nil {
+		hub.SetBroker(s.chatBroker)
+	}
+	s.chatHub = hub
+	if webRTCManager == nil {
 		return
 	}
 	wsHandler := livestream.NewWebSocketHandler(hub, s.livestreamService, webRTCManager)
@@ -65,6 +168,7 @@ func (s *FiberServer) RegisterFiberRoutes() {
 	s.App.Use("/ws", func(c *fiber.Ctx) error {
 		if websocket.IsWebSocketUpgrade(c) {
 			c.Locals("allowed", true)
+			s.identifyWebSocketUser(c)
 			return c.Next()
 		}
 		return fiber.ErrUpgradeRequired
@@ -72,6 +176,35 @@ func (s *FiberServer) RegisterFiberRoutes() {
 	s.App.Get("/ws", websocket.New(wsHandler.ServeHTTP))
 }
 
+// identifyWebSocketUser authenticates a WebSocket upgrade when a session token is supplied.
+// Browsers can't set headers on WebSocket requests, so the token may also come from the
+// token query parameter or the session cookie. Connections without a valid token continue
+// as anonymous viewers.
+func (s *FiberServer) identifyWebSocketUser(c *fiber.Ctx) {
+	token := c.Query("token")
+	if token == "" {
+		token = strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+	}
+	if token == "" {
+		token = c.Cookies(users.SessionCookie)
+	}
+	if token == "" {
+		return
+	}
+
+	userID, err := s.jwtService.VerifySessionToken(token)
+	if err != nil {
+		return
+	}
+	user, err := s.userService.GetUserByID(c.UserContext(), userID)
+	if err != nil {
+		return
+	}
+
+	c.Locals("user_id", userID.Hex())
+	c.Locals("user_name", user.UserName)
+}
+
 func (s *FiberServer) HelloWorldHandler(c *fiber.Ctx) error {
 	resp := fiber.Map{
 		"message": "Hello World",
@@ -79,7 +212,3 @@ func (s *FiberServer) HelloWorldHandler(c *fiber.Ctx) error {
 
 	return c.JSON(resp)
 }
-
-func (s *FiberServer) healthHandler(c *fiber.Ctx) error {
-	return c.JSON(s.db.Health())
-}
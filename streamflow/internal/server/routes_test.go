@@ -10,6 +10,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"streamflow/internal/audit"
 	"streamflow/internal/config"
 	"streamflow/internal/database"
 	"streamflow/internal/livestream"
@@ -17,12 +19,14 @@ import (
 	"streamflow/internal/video"
 	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -81,6 +85,7 @@ func setupTestServer() {
 			SecretKey:         "test-secret-key-for-testing-only",
 			Expiration:        24 * time.Hour,
 			RefreshExpiration: 7 * 24 * time.Hour,
+			SessionCookie:     true,
 		},
 		Video: config.VideoConfig{
 			UploadPath:    "test_uploads",
@@ -93,14 +98,20 @@ func setupTestServer() {
 			RateLimit:   100,
 			RateWindow:  1 * time.Minute,
 		},
+		Limits: config.LimitsConfig{
+			StorageQuotaBytes:    1024 * 1024 * 1024, // 1GB
+			MaxVideos:            50,
+			MaxConcurrentStreams: 2,
+		},
 	}
 
 	// Initialize services
 	testDB = database.New()
-	testUserService = users.NewUserService(testDB.GetDatabase())
+	testUserService = users.NewUserService(testDB.GetDatabase(), testConfig.TwoFactor)
 	testJWTService = users.NewJWTService(testConfig.JWT.SecretKey)
-	testVideoService = video.NewVideoService(testDB.GetDatabase())
-	testLivestreamService = livestream.NewLiveStreamService(testDB.GetDatabase())
+	testJWTService.SetSessionStore(testUserService)
+	testVideoService = video.NewVideoService(testDB.GetDatabase(), testConfig.Video)
+	testLivestreamService = livestream.NewLiveStreamService(testDB.GetDatabase(), testVideoService, testUserService, testConfig.Livestream)
 
 	// Create test server
 	testServer = &FiberServer{
@@ -110,10 +121,13 @@ func setupTestServer() {
 		jwtService:        testJWTService,
 		videoService:      testVideoService,
 		livestreamService: testLivestreamService,
+		streamManager:     livestream.NewStreamManager(testLivestreamService, nil),
+		audit:             audit.NewAuditService(testDB.GetDatabase()),
 		cfg:               testConfig,
 	}
 
 	// Register routes
+	testServer.App.Use(corsMiddleware(testConfig.Security.CORSOrigins))
 	testServer.RegisterFiberRoutes()
 
 	// Create test directories
@@ -238,6 +252,80 @@ func TestHealthEndpoint(t *testing.T) {
 	assert.Contains(t, healthResponse, "status")
 }
 
+func TestLivenessAndReadiness(t *testing.T) {
+	resp, err := makeRequest("GET", "/livez", nil, nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = makeRequest("GET", "/readyz", nil, nil)
+	require.NoError(t, err)
+	body, err := readResponseBody(resp)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var health HealthResponse
+	require.NoError(t, json.Unmarshal(body, &health))
+	assert.Equal(t, "ready", health.Status)
+	assert.Equal(t, database.StatusConnected, health.Database.Status)
+	assert.NotEmpty(t, health.Version.Version)
+	assert.NotEmpty(t, health.Version.GoVersion)
+
+	// An unreachable database makes the instance unready, but it stays alive
+	unready := &FiberServer{App: fiber.New(), db: unreachableDB{testDB}, cfg: testConfig}
+	unready.App.Get("/livez", unready.livenessHandler)
+	unready.App.Get("/readyz", unready.readinessHandler)
+
+	resp, err = unready.App.Test(httptest.NewRequest("GET", "/readyz", nil))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	resp, err = unready.App.Test(httptest.NewRequest("GET", "/livez", nil))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Missing ffmpeg only makes the instance unready when it is required
+	missing := FFmpegReport{Error: "ffmpeg not found"}
+	for _, required := range []bool{false, true} {
+		missing.Required = required
+		noFFmpeg := &FiberServer{App: fiber.New(), db: testDB, cfg: testConfig, ffmpeg: missing}
+		noFFmpeg.App.Get("/livez", noFFmpeg.livenessHandler)
+		noFFmpeg.App.Get("/readyz", noFFmpeg.readinessHandler)
+
+		resp, err = noFFmpeg.App.Test(httptest.NewRequest("GET", "/readyz", nil))
+		require.NoError(t, err)
+		body, err = readResponseBody(resp)
+		require.NoError(t, err)
+		health = HealthResponse{}
+		require.NoError(t, json.Unmarshal(body, &health))
+		assert.False(t, health.FFmpeg.Available)
+		assert.Equal(t, required, health.FFmpeg.Required)
+		if required {
+			assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+			assert.Equal(t, "unavailable", health.Status)
+		} else {
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "ready", health.Status)
+		}
+
+		resp, err = noFFmpeg.App.Test(httptest.NewRequest("GET", "/livez", nil))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+}
+
+// unreachableDB fails every health check
+type unreachableDB struct {
+	database.Service
+}
+
+func (unreachableDB) Check(ctx context.Context, requiredCollections []string) database.HealthReport {
+	return database.HealthReport{Status: database.StatusUnreachable, Error: "connection refused"}
+}
+
 func TestCORSHeaders(t *testing.T) {
 	testCases := []struct {
 		name   string
@@ -267,6 +355,58 @@ func TestCORSHeaders(t *testing.T) {
 	}
 }
 
+func TestCORSOrigins(t *testing.T) {
+	newApp := func(origins []string) *fiber.App {
+		app := fiber.New()
+		app.Use(corsMiddleware(origins))
+		app.Get("/", func(c *fiber.Ctx) error {
+			return c.SendString("ok")
+		})
+		return app
+	}
+	request := func(app *fiber.App, method, origin string) *http.Response {
+		req := httptest.NewRequest(method, "/", nil)
+		req.Header.Set("Origin", origin)
+		if method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "GET")
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	allowList := newApp([]string{"https://app.example.com", "http://localhost:3000"})
+
+	t.Run("Allowed origin is echoed with credentials", func(t *testing.T) {
+		for _, method := range []string{"GET", "OPTIONS"} {
+			resp := request(allowList, method, "http://localhost:3000")
+			assert.Equal(t, "http://localhost:3000", resp.Header.Get("Access-Control-Allow-Origin"), method)
+			assert.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Credentials"), method)
+			assert.Contains(t, resp.Header.Get("Vary"), "Origin", method)
+		}
+	})
+
+	t.Run("Disallowed origin gets no CORS headers", func(t *testing.T) {
+		for _, method := range []string{"GET", "OPTIONS"} {
+			resp := request(allowList, method, "https://evil.example.com")
+			assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"), method)
+			assert.Empty(t, resp.Header.Get("Access-Control-Allow-Credentials"), method)
+		}
+	})
+
+	t.Run("Wildcard allows any origin without credentials", func(t *testing.T) {
+		resp := request(newApp([]string{"*"}), "GET", "https://anywhere.example.com")
+		assert.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Credentials"))
+	})
+
+	t.Run("No origins allows no cross-origin requests", func(t *testing.T) {
+		resp := request(newApp(nil), "GET", "http://localhost:3000")
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+	})
+}
+
 // =============================================================================
 // User Authentication and Registration Testing
 // =============================================================================
@@ -438,6 +578,122 @@ func TestUserLogin(t *testing.T) {
 	}
 }
 
+func TestAuditLog(t *testing.T) {
+	ctx := context.Background()
+	since := time.Now().Add(-time.Second).Format(time.RFC3339Nano)
+
+	body, err := json.Marshal(users.LoginUserRequest{Email: testUser.Email, Password: testUser.Password})
+	require.NoError(t, err)
+	resp, err := makeRequest("POST", "/user/login", bytes.NewReader(body), map[string]string{
+		"Content-Type": "application/json",
+	})
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Only admins can read the log
+	query := "/api/admin/audit?actor=" + testUserID.Hex() + "&action=" + audit.ActionLogin + "&since=" + since
+	resp, err = makeAuthenticatedRequest("GET", query, nil, nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	usersCollection := testDB.GetDatabase().Collection("users")
+	_, err = usersCollection.UpdateByID(ctx, testUserID, bson.M{"$set": bson.M{"role": users.RoleAdmin}})
+	require.NoError(t, err)
+	defer usersCollection.UpdateByID(ctx, testUserID, bson.M{"$set": bson.M{"role": users.RoleUser}})
+
+	resp, err = makeAuthenticatedRequest("GET", query, nil, nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var page audit.RecordPage
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	require.NotEmpty(t, page.Records)
+	for _, record := range page.Records {
+		assert.Equal(t, testUserID, record.Actor)
+		assert.Equal(t, audit.ActionLogin, record.Action)
+		assert.Equal(t, testUserID.Hex(), record.TargetID)
+		assert.NotEmpty(t, record.IP)
+	}
+
+	resp, err = makeAuthenticatedRequest("GET", "/api/admin/audit?since=yesterday", nil, nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestAdminStats(t *testing.T) {
+	ctx := context.Background()
+
+	resp, err := makeAuthenticatedRequest("GET", "/api/admin/stats", nil, nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	usersCollection := testDB.GetDatabase().Collection("users")
+	_, err = usersCollection.UpdateByID(ctx, testUserID, bson.M{"$set": bson.M{"role": users.RoleAdmin}})
+	require.NoError(t, err)
+	defer usersCollection.UpdateByID(ctx, testUserID, bson.M{"$set": bson.M{"role": users.RoleUser}})
+
+	// The test server doesn't cache the stats, so the fixtures show up straight away
+	getStats := func() AdminStats {
+		resp, err := makeAuthenticatedRequest("GET", "/api/admin/stats", nil, nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var stats AdminStats
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+		return stats
+	}
+	before := getStats()
+
+	suffix := primitive.NewObjectID().Hex()
+	_, err = testUserService.CreateUser(ctx, users.CreateUserRequest{
+		UserName: "stats_" + suffix,
+		Email:    "stats_" + suffix + "@example.com",
+		Password: "password123",
+	})
+	require.NoError(t, err)
+
+	videos := testDB.GetDatabase().Collection("videos")
+	deletedAt := time.Now()
+	for _, v := range []video.Video{
+		{Status: video.StatusCompleted},
+		{Status: video.StatusCompleted},
+		{Status: video.StatusFailed},
+		{Status: video.StatusPending, DeletedAt: &deletedAt}, // Not counted
+	} {
+		v.ID = primitive.NewObjectID()
+		v.Title = "Stats Test Video"
+		v.UserID = testUserID
+		v.CreatedAt = time.Now()
+		v.UpdatedAt = time.Now()
+		_, err := videos.InsertOne(ctx, v)
+		require.NoError(t, err)
+		defer videos.DeleteOne(ctx, bson.M{"_id": v.ID})
+	}
+
+	live, err := testLivestreamService.StartStream(testUserID, livestream.StartStreamRequest{Title: "Stats Test Stream"})
+	require.NoError(t, err)
+	scheduled, err := testLivestreamService.ScheduleStream(testUserID, livestream.StartStreamRequest{Title: "Stats Test Schedule"}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	streams := testDB.GetDatabase().Collection("livestreams")
+	defer streams.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": bson.A{live.ID, scheduled.ID}}})
+
+	after := getStats()
+	assert.Equal(t, before.Users+1, after.Users)
+	assert.Equal(t, before.Videos.Total+3, after.Videos.Total)
+	assert.Equal(t, before.Videos.ByStatus[video.StatusCompleted]+2, after.Videos.ByStatus[video.StatusCompleted])
+	assert.Equal(t, before.Videos.ByStatus[video.StatusFailed]+1, after.Videos.ByStatus[video.StatusFailed])
+	assert.Equal(t, before.Videos.ByStatus[video.StatusPending], after.Videos.ByStatus[video.StatusPending])
+	assert.Contains(t, after.Videos.ByStatus, video.StatusProcessing)
+	assert.Equal(t, before.Streams.Live+1, after.Streams.Live)
+	assert.Equal(t, before.Streams.Total+2, after.Streams.Total)
+}
+
 // =============================================================================
 // Authentication Integration Testing
 // =============================================================================
@@ -504,6 +760,86 @@ func TestAuthenticationMiddleware(t *testing.T) {
 	}
 }
 
+func TestSessionCookie(t *testing.T) {
+	body, err := json.Marshal(users.LoginUserRequest{Email: testUser.Email, Password: testUser.Password})
+	require.NoError(t, err)
+	resp, err := makeRequest("POST", "/user/login", bytes.NewReader(body), map[string]string{
+		"Content-Type": "application/json",
+	})
+	require.NoError(t, err)
+	responseBody, err := readResponseBody(resp)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// The token is still in the body for API clients, and in the cookie for browsers
+	var login struct {
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal(responseBody, &login))
+	var cookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == users.SessionCookie {
+			cookie = c
+		}
+	}
+	require.NotNil(t, cookie, "login should set the session cookie")
+	assert.Equal(t, login.Token, cookie.Value)
+	assert.True(t, cookie.HttpOnly)
+	assert.True(t, cookie.Secure)
+	assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+	assert.Equal(t, "/", cookie.Path)
+	assert.True(t, cookie.Expires.After(time.Now()))
+
+	testCases := []struct {
+		name           string
+		header         string
+		cookie         string
+		expectedStatus int
+	}{
+		{"Header only", "Bearer " + testToken, "", http.StatusOK},
+		{"Cookie only", "", cookie.Value, http.StatusOK},
+		{"Invalid cookie", "", "invalid.token.here", http.StatusUnauthorized},
+		// The header wins, so a stale cookie doesn't override an explicit token
+		{"Invalid header with valid cookie", "Bearer invalid.token.here", cookie.Value, http.StatusUnauthorized},
+		{"Valid header with invalid cookie", "Bearer " + testToken, "invalid.token.here", http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/user/me", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			if tc.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: users.SessionCookie, Value: tc.cookie})
+			}
+			resp, err := testServer.App.Test(req, -1)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+		})
+	}
+
+	t.Run("Logout clears the cookie", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/user/logout", nil)
+		req.AddCookie(cookie)
+		resp, err := testServer.App.Test(req, -1)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var cleared *http.Cookie
+		for _, c := range resp.Cookies() {
+			if c.Name == users.SessionCookie {
+				cleared = c
+			}
+		}
+		require.NotNil(t, cleared, "logout should overwrite the session cookie")
+		assert.Empty(t, cleared.Value)
+		assert.Equal(t, "/", cleared.Path)
+		assert.True(t, cleared.Expires.Before(time.Now()))
+	})
+}
+
 func TestGetUserProfile(t *testing.T) {
 	resp, err := makeAuthenticatedRequest("GET", "/api/user/me", nil, nil)
 	require.NoError(t, err)
@@ -526,6 +862,136 @@ func TestGetUserProfile(t *testing.T) {
 	assert.Equal(t, testUser.UserName, user["user_name"])
 }
 
+func TestGetPublicProfile(t *testing.T) {
+	resp, err := makeRequest("GET", "/user/"+testUserID.Hex()+"/profile", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	responseBody, err := readResponseBody(resp)
+	require.NoError(t, err)
+
+	var profile map[string]interface{}
+	require.NoError(t, json.Unmarshal(responseBody, &profile))
+	assert.Equal(t, testUser.UserName, profile["user_name"])
+	assert.NotContains(t, profile, "email")
+	assert.NotContains(t, profile, "password")
+	assert.NotContains(t, string(responseBody), testUser.Email)
+
+	resp, err = makeRequest("GET", "/user/"+primitive.NewObjectID().Hex()+"/profile", nil, nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestGetUserByUsername(t *testing.T) {
+	resp, err := makeRequest("GET", "/user/by-username/"+strings.ToUpper(testUser.UserName), nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	responseBody, err := readResponseBody(resp)
+	require.NoError(t, err)
+
+	var profile map[string]interface{}
+	require.NoError(t, json.Unmarshal(responseBody, &profile))
+	assert.Equal(t, testUserID.Hex(), profile["id"])
+	assert.Equal(t, testUser.UserName, profile["user_name"])
+	assert.NotContains(t, profile, "email")
+	assert.NotContains(t, profile, "password")
+
+	resp, err = makeRequest("GET", "/user/by-username/nobody_"+primitive.NewObjectID().Hex(), nil, nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestGetPublicStream(t *testing.T) {
+	ctx := context.Background()
+
+	stream, err := testLivestreamService.StartStream(testUserID, livestream.StartStreamRequest{Title: "Public Stream"})
+	require.NoError(t, err)
+	defer testDB.GetDatabase().Collection("livestreams").DeleteOne(ctx, bson.M{"_id": stream.ID})
+
+	for _, url := range []string{"/api/livestream/" + stream.ID.Hex(), "/api/livestream/status/" + stream.ID.Hex()} {
+		resp, err := makeAuthenticatedRequest("GET", url, nil, nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, url)
+
+		responseBody, err := readResponseBody(resp)
+		require.NoError(t, err)
+
+		var public map[string]interface{}
+		require.NoError(t, json.Unmarshal(responseBody, &public))
+		assert.Equal(t, "Public Stream", public["Title"], url)
+		assert.NotContains(t, public, "StreamKey", url)
+		assert.NotContains(t, string(responseBody), stream.StreamKey, url)
+	}
+
+	resp, err := makeAuthenticatedRequest("GET", "/api/livestream/"+primitive.NewObjectID().Hex(), nil, nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestUserQuota(t *testing.T) {
+	ctx := context.Background()
+
+	// Seed videos with known sizes for the test user
+	videos := testDB.GetDatabase().Collection("videos")
+	for _, size := range []int64{1500, 2500} {
+		_, err := videos.InsertOne(ctx, video.Video{
+			ID:        primitive.NewObjectID(),
+			Title:     "Quota Test Video",
+			Status:    video.StatusCompleted,
+			UserID:    testUserID,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+			Metadata:  video.VideoMetadata{FileSize: size},
+		})
+		require.NoError(t, err)
+	}
+
+	stream, err := testLivestreamService.StartStream(testUserID, livestream.StartStreamRequest{Title: "Quota Test Stream"})
+	require.NoError(t, err)
+	defer testDB.GetDatabase().Collection("livestreams").DeleteOne(ctx, bson.M{"_id": stream.ID})
+
+	// Compute the expected usage straight from the database
+	cursor, err := videos.Find(ctx, bson.M{"user_id": testUserID, "deleted_at": nil})
+	require.NoError(t, err)
+	var userVideos []video.Video
+	require.NoError(t, cursor.All(ctx, &userVideos))
+	var expectedStorage int64
+	for _, v := range userVideos {
+		expectedStorage += v.Metadata.FileSize
+	}
+	expectedStreams, err := testDB.GetDatabase().Collection("livestreams").CountDocuments(ctx,
+		bson.M{"user_id": testUserID, "status": livestream.StreamStatusLive})
+	require.NoError(t, err)
+
+	resp, err := makeAuthenticatedRequest("GET", "/api/user/me/quota", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	responseBody, err := readResponseBody(resp)
+	require.NoError(t, err)
+
+	var quota QuotaResponse
+	require.NoError(t, json.Unmarshal(responseBody, &quota))
+
+	assert.Equal(t, expectedStorage, quota.Storage.Used)
+	assert.Equal(t, testConfig.Limits.StorageQuotaBytes, quota.Storage.Limit)
+	assert.Equal(t, int64(len(userVideos)), quota.Videos.Used)
+	assert.Equal(t, int64(testConfig.Limits.MaxVideos), quota.Videos.Limit)
+	assert.Equal(t, expectedStreams, quota.ActiveStreams.Used)
+	assert.GreaterOrEqual(t, quota.ActiveStreams.Used, int64(1))
+	assert.Equal(t, int64(testConfig.Limits.MaxConcurrentStreams), quota.ActiveStreams.Limit)
+
+	// Unauthenticated requests are rejected
+	resp, err = makeRequest("GET", "/api/user/me/quota", nil, nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
 // =============================================================================
 // Video API Testing
 // =============================================================================
@@ -723,7 +1189,7 @@ func TestVideoOperations(t *testing.T) {
 				"title":       "Updated Title",
 				"description": "Updated Description",
 			},
-			expectedStatus: http.StatusInternalServerError, // Will fail since video doesn't exist
+			expectedStatus: http.StatusNotFound, // Will be 404 since video doesn't exist
 			useAuth:        true,
 		},
 		{
@@ -731,7 +1197,15 @@ func TestVideoOperations(t *testing.T) {
 			method:         "DELETE",
 			url:            "/api/video/" + testVideoID.Hex(),
 			body:           nil,
-			expectedStatus: http.StatusInternalServerError, // Will fail since video doesn't exist
+			expectedStatus: http.StatusNotFound, // Will be 404 since video doesn't exist
+			useAuth:        true,
+		},
+		{
+			name:           "Set video status as a non-admin",
+			method:         "PATCH",
+			url:            "/api/admin/video/" + testVideoID.Hex() + "/status",
+			body:           map[string]interface{}{"status": "COMPLETED"},
+			expectedStatus: http.StatusForbidden,
 			useAuth:        true,
 		},
 		{
@@ -1565,6 +2039,102 @@ func TestRateLimiting(t *testing.T) {
 	t.Logf("Successful requests: %d, Rate limited: %d", successCount, rateLimitedCount)
 }
 
+func TestLoginRateLimit(t *testing.T) {
+	// A server of its own so the strict limit doesn't affect other tests
+	cfg := *testConfig
+	cfg.Security.AuthRateLimit = 3
+	cfg.Security.AuthRateWindow = time.Minute
+	limited := &FiberServer{
+		App:               fiber.New(fiber.Config{ErrorHandler: testServer.customErrorHandler}),
+		db:                testDB,
+		userService:       testUserService,
+		jwtService:        testJWTService,
+		videoService:      testVideoService,
+		livestreamService: testLivestreamService,
+		streamManager:     livestream.NewStreamManager(testLivestreamService, nil),
+		cfg:               &cfg,
+	}
+	limited.RegisterFiberRoutes()
+
+	body, err := json.Marshal(users.LoginUserRequest{Email: testUser.Email, Password: "wrong-password"})
+	require.NoError(t, err)
+
+	login := func() *http.Response {
+		req := httptest.NewRequest("POST", "/user/login", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := limited.App.Test(req, -1)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	for i := 0; i < cfg.Security.AuthRateLimit; i++ {
+		resp := login()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "attempt %d should reach the handler", i+1)
+	}
+
+	resp := login()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+}
+
+func TestConfigReloadRateLimit(t *testing.T) {
+	cfg := *testConfig
+	cfg.Security.AuthRateLimit = 5
+	cfg.Security.AuthRateWindow = time.Minute
+	limited := &FiberServer{
+		App:               fiber.New(fiber.Config{ErrorHandler: testServer.customErrorHandler}),
+		db:                testDB,
+		userService:       testUserService,
+		jwtService:        testJWTService,
+		videoService:      testVideoService,
+		livestreamService: testLivestreamService,
+		streamManager:     livestream.NewStreamManager(testLivestreamService, nil),
+		cfg:               &cfg,
+	}
+	limited.RegisterFiberRoutes()
+
+	// The reload sets these in the environment; t.Setenv restores them afterwards
+	t.Setenv("AUTH_RATE_LIMIT", os.Getenv("AUTH_RATE_LIMIT"))
+	t.Setenv("JWT_SECRET", os.Getenv("JWT_SECRET"))
+	envFile := filepath.Join(t.TempDir(), ".env")
+	err := os.WriteFile(envFile, []byte("AUTH_RATE_LIMIT=2\nJWT_SECRET=changed-secret\n"), 0o600)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloaded := make(chan *config.Config, 1)
+	config.Watch(ctx, envFile, &cfg, func(next *config.Config) {
+		limited.ApplyConfig(next)
+		reloaded <- next
+	})
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+
+	select {
+	case next := <-reloaded:
+		assert.Equal(t, 2, next.Security.AuthRateLimit)
+		assert.Equal(t, cfg.JWT.SecretKey, next.JWT.SecretKey, "secrets are not reloaded")
+	case <-time.After(5 * time.Second):
+		t.Fatal("config was not reloaded")
+	}
+
+	body, err := json.Marshal(users.LoginUserRequest{Email: testUser.Email, Password: "wrong-password"})
+	require.NoError(t, err)
+	login := func() int {
+		req := httptest.NewRequest("POST", "/user/login", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := limited.App.Test(req, -1)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusUnauthorized, login(), "attempt %d should reach the handler", i+1)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, login())
+}
+
 func TestMaliciousInputs(t *testing.T) {
 	maliciousInputs := []string{
 		"<script>alert('xss')</script>",
@@ -1596,3 +2166,109 @@ func TestMaliciousInputs(t *testing.T) {
 		})
 	}
 }
+
+func TestUserSessions(t *testing.T) {
+	login := func(userAgent string) string {
+		body, err := json.Marshal(users.LoginUserRequest{Email: testUser.Email, Password: testUser.Password})
+		require.NoError(t, err)
+		resp, err := makeRequest("POST", "/user/login", bytes.NewReader(body), map[string]string{
+			"Content-Type": "application/json",
+			"User-Agent":   userAgent,
+		})
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		require.NotEmpty(t, result.RefreshToken)
+		return result.RefreshToken
+	}
+	refresh := func(refreshToken string) int {
+		body, err := json.Marshal(users.RefreshTokenRequest{RefreshToken: refreshToken})
+		require.NoError(t, err)
+		resp, err := makeRequest("POST", "/user/refresh", bytes.NewReader(body), map[string]string{
+			"Content-Type": "application/json",
+		})
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			var result struct {
+				Token string `json:"token"`
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+			_, err := testJWTService.VerifySessionToken(result.Token)
+			assert.NoError(t, err, "refresh should return a valid session token")
+		}
+		return resp.StatusCode
+	}
+	listSessions := func() []users.Session {
+		resp, err := makeAuthenticatedRequest("GET", "/api/user/me/sessions", nil, nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result struct {
+			Sessions []users.Session `json:"sessions"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return result.Sessions
+	}
+	findSession := func(sessions []users.Session, device string) *users.Session {
+		for i := range sessions {
+			if sessions[i].Device == device {
+				return &sessions[i]
+			}
+		}
+		return nil
+	}
+
+	// Other tests log in as the same user, so sessions are told apart by device
+	desktop := "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0"
+	tablet := "Mozilla/5.0 (iPad; CPU OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1"
+	desktopToken := login(desktop)
+	tabletToken := login(tablet)
+	time.Sleep(10 * time.Millisecond) // Times are stored to the millisecond
+	assert.Equal(t, http.StatusOK, refresh(desktopToken))
+
+	sessions := listSessions()
+	desktopSession := findSession(sessions, "Firefox on Linux")
+	tabletSession := findSession(sessions, "Safari on iPadOS")
+	require.NotNil(t, desktopSession)
+	require.NotNil(t, tabletSession)
+	assert.Equal(t, desktop, desktopSession.UserAgent)
+	assert.NotEmpty(t, desktopSession.IP)
+	assert.True(t, desktopSession.LastUsedAt.After(desktopSession.CreatedAt), "refresh should update last used")
+
+	resp, err := makeAuthenticatedRequest("DELETE", "/api/user/me/sessions/"+tabletSession.ID.Hex(), nil, nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// Only the revoked session's refresh token stops working
+	assert.Equal(t, http.StatusUnauthorized, refresh(tabletToken))
+	assert.Equal(t, http.StatusOK, refresh(desktopToken))
+	assert.Nil(t, findSession(listSessions(), "Safari on iPadOS"))
+
+	testCases := []struct {
+		name           string
+		id             string
+		expectedStatus int
+	}{
+		{"Already revoked", tabletSession.ID.Hex(), http.StatusNotFound},
+		{"Invalid ID", "not-an-id", http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := makeAuthenticatedRequest("DELETE", "/api/user/me/sessions/"+tc.id, nil, nil)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+		})
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, refresh("not-a-token"))
+	assert.Equal(t, http.StatusBadRequest, refresh(""))
+}
@@ -2,17 +2,30 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
+	"streamflow/internal/apperr"
+	"streamflow/internal/audit"
+	"streamflow/internal/cache"
 	"streamflow/internal/config"
 	"streamflow/internal/database"
+	"streamflow/internal/lifecycle"
 	"streamflow/internal/livestream"
+	"streamflow/internal/livestream/rtmp"
+	"streamflow/internal/logger"
+	"streamflow/internal/migrate"
+	"streamflow/internal/redis"
 	"streamflow/internal/users"
 	"streamflow/internal/video"
+	"streamflow/internal/webhooks"
+	"sync"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/limiter"
 )
 
 type FiberServer struct {
@@ -22,8 +35,24 @@ type FiberServer struct {
 	jwtService        *users.JWTService
 	videoService      *video.VideoService
 	livestreamService *livestream.LivestreamService
+	streamManager     *livestream.StreamManager
+	rtmpServer        *rtmp.Server
+	webhooks          *webhooks.WebhookDispatcher
+	audit             *audit.AuditService
+	chatHub           *livestream.ChatHub
+	redis             *redis.Client         // Shares viewer counts and chat between instances; nil when not configured
+	chatBroker        livestream.ChatBroker // Set on the chat hub when Redis is configured
+	lifecycle         *lifecycle.Manager
+	stopWorkers       context.CancelFunc // Stops the periodic background jobs
 	cfg               *config.Config
-	maxFileSize       int64 // Store for error messages
+	reloadMu          sync.Mutex // Guards the settings and middleware below, which ApplyConfig replaces
+	security          *config.SecurityConfig
+	cors              *reloadable
+	rateLimit         *reloadable
+	authLimiters      []*reloadable
+	maxFileSize       int64         // Store for error messages
+	ffmpeg            FFmpegReport  // Found at startup, reported by /readyz
+	stats             *cache.Loader // Caches the admin stats; nil disables caching
 }
 
 func New(cfg *config.Config) *FiberServer {
@@ -33,6 +62,8 @@ func New(cfg *config.Config) *FiberServer {
 	server := &FiberServer{
 		cfg:         cfg,
 		maxFileSize: cfg.Video.MaxFileSize,
+		ffmpeg:      checkFFmpeg(cfg.Video.RequireFFmpeg),
+		stats:       newStatsCache(),
 	}
 
 	app := fiber.New(fiber.Config{
@@ -43,11 +74,32 @@ func New(cfg *config.Config) *FiberServer {
 		IdleTimeout:  cfg.Server.IdleTimeout,
 	})
 
-	db := database.New()
-	userService := users.NewUserService(db.GetDatabase())
+	db := database.NewWithConfig(cfg.Database)
+	migrateDatabase(db)
+	userService := users.NewUserService(db.GetDatabase(), cfg.TwoFactor)
+	userService.SetLoginLockout(cfg.Security.LoginMaxAttempts, cfg.Security.LoginAttemptWindow, cfg.Security.LoginLockout)
+	userService.SetPasswordCost(cfg.Security.BcryptCost)
+	userService.SetRefreshTokenTTL(cfg.JWT.RefreshExpiration)
 	jwtService := users.NewJWTService(cfg.JWT.SecretKey)
-	videoService := video.NewVideoService(db.GetDatabase())
-	livestreamService := livestream.NewLiveStreamService(db.GetDatabase())
+	jwtService.SetTokenTTL(cfg.JWT.Expiration)
+	jwtService.SetSessionStore(userService)
+	videoService := video.NewVideoService(db.GetDatabase(), cfg.Video)
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	server.stopWorkers = stopWorkers
+	if cfg.Video.DeletedRetention > 0 {
+		videoService.StartPurgeJanitor(workerCtx, cfg.Video.DeletedRetention)
+	}
+	videoService.StartProcessing(workerCtx)
+	userService.SetAvatarStorage(videoService.Storage())
+	videoService.SetShareLinkKey(cfg.JWT.SecretKey)
+	videoService.SetStorageQuota(cfg.Limits.StorageQuotaBytes)
+	videoService.SetMaxVideos(cfg.Limits.MaxVideos)
+	livestreamService := livestream.NewLiveStreamService(db.GetDatabase(), videoService, userService, cfg.Livestream)
+	livestreamService.SetMaxConcurrentStreams(cfg.Limits.MaxConcurrentStreams)
+	results := cache.NewLoader(cache.NewMemory(), cfg.Cache.TTL)
+	videoService.SetResultCache(results)
+	livestreamService.SetResultCache(results)
+	server.connectRedis(db, livestreamService)
 
 	// Complete the server initialization
 	server.App = app
@@ -56,6 +108,17 @@ func New(cfg *config.Config) *FiberServer {
 	server.jwtService = jwtService
 	server.videoService = videoService
 	server.livestreamService = livestreamService
+	server.audit = audit.NewAuditService(db.GetDatabase())
+	if len(cfg.Webhook.URLs) > 0 {
+		client := webhooks.NewClient(cfg.Webhook.URLs, cfg.Webhook.Secret, cfg.Webhook.MaxRetries+1)
+		server.webhooks = webhooks.NewWebhookDispatcher(client, cfg.Webhook.DeadLetterPath)
+		userService.SetWebhookDispatcher(server.webhooks)
+		videoService.SetWebhookDispatcher(server.webhooks)
+	}
+	server.streamManager = livestream.NewStreamManager(livestreamService, server.webhooks)
+	livestream.NewScheduledStreamPromoter(livestreamService, server.streamManager).Start(workerCtx)
+	server.rtmpServer = rtmp.NewServer(livestreamService, server.streamManager, livestream.NewCodecValidator(cfg.Livestream))
+	server.registerShutdownHooks()
 
 	// Apply middleware
 	server.applyMiddleware()
@@ -63,40 +126,120 @@ func New(cfg *config.Config) *FiberServer {
 	return server
 }
 
+// migrationTimeout bounds how long startup waits for migrations, including those of
+// another instance holding the migration lock
+const migrationTimeout = 5 * time.Minute
+
+// migrateDatabase applies pending migrations before the services start using the database
+func migrateDatabase(db database.Service) {
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+	if err := migrate.NewRunner(db.GetDatabase(), migrate.All()...).Run(ctx); err != nil {
+		log.Fatalf("Failed to migrate the database: %v", err)
+	}
+}
+
+// redisConnectTimeout bounds the check that Redis is reachable at startup
+const redisConnectTimeout = 10 * time.Second
+
+// connectRedis moves viewer counts and chat to Redis when it is configured, so they are
+// shared by every instance. Without it both stay in the memory of this instance, which
+// is only correct when a single instance runs.
+func (s *FiberServer) connectRedis(db database.Service, livestreamService *livestream.LivestreamService) {
+	if s.cfg.Redis.Addr == "" {
+		log.Printf("REDIS_ADDR not set: viewer counts and chat are local to this instance")
+		return
+	}
+
+	opts := redis.Options{
+		Addr:     s.cfg.Redis.Addr,
+		Password: s.cfg.Redis.Password,
+		DB:       s.cfg.Redis.DB,
+	}
+	if s.cfg.Redis.TLS {
+		host, _, _ := net.SplitHostPort(s.cfg.Redis.Addr)
+		opts.TLS = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	}
+	client := redis.New(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), redisConnectTimeout)
+	defer cancel()
+	if err := client.Ping(ctx); err != nil {
+		log.Fatalf("Failed to connect to redis at %s: %v", s.cfg.Redis.Addr, err)
+	}
+
+	s.redis = client
+	s.chatBroker = livestream.NewRedisChatBroker(client)
+	livestreamService.SetViewerCounts(livestream.NewRedisViewerTracker(client,
+		db.GetDatabase().Collection("livestreams"), db.GetDatabase().Collection("stream_samples")))
+	log.Printf("Sharing viewer counts and chat through redis at %s", s.cfg.Redis.Addr)
+}
+
 func (s *FiberServer) Listen(addr string) error {
 	return s.App.Listen(addr)
 }
 
+// ListenRTMP runs the RTMP ingest server on addr until the server shuts down
+func (s *FiberServer) ListenRTMP(addr string) error {
+	return s.rtmpServer.ListenAndServe(addr)
+}
+
+// ShutdownWithContext stops every subsystem in dependency order, giving up on those that
+// are still running when ctx is done
 func (s *FiberServer) ShutdownWithContext(ctx context.Context) error {
-	// Close database connection first
-	if err := s.db.Close(); err != nil {
-		log.Printf("Error closing database connection: %v", err)
-	} else {
-		log.Println("Database connection closed successfully")
-	}
+	return s.lifecycle.Shutdown(ctx)
+}
+
+// registerShutdownHooks sets the shutdown order: first stop accepting work, then let
+// in-flight work finish, and close the database last as everything before it may use it
+func (s *FiberServer) registerShutdownHooks() {
+	s.lifecycle = lifecycle.NewManager()
 
-	// Then shutdown the Fiber app
-	return s.App.ShutdownWithContext(ctx)
+	// Ending the published streams finalizes their recordings
+	s.lifecycle.Register("rtmp ingest", s.rtmpServer.Shutdown)
+	// WebSocket connections would otherwise keep the HTTP server from shutting down
+	s.lifecycle.Register("chat", func(ctx context.Context) error {
+		s.chatHub.Close()
+		return nil
+	})
+	// Likewise for clients following video processing
+	s.lifecycle.Register("processing events", func(ctx context.Context) error {
+		s.videoService.CloseProcessingSubscriptions()
+		return nil
+	})
+	s.lifecycle.Register("http server", s.App.ShutdownWithContext)
+	s.lifecycle.Register("background jobs", func(ctx context.Context) error {
+		s.stopWorkers()
+		return nil
+	})
+	s.lifecycle.Register("livestreams", s.livestreamService.Shutdown)
+	if s.redis != nil {
+		// After the livestreams, whose final viewer counts are read from Redis
+		s.lifecycle.Register("redis", func(ctx context.Context) error {
+			return s.redis.Close()
+		})
+	}
+	s.lifecycle.Register("video processing", s.videoService.Shutdown)
+	// After the services above, as they fire webhooks while stopping
+	s.lifecycle.Register("webhooks", s.webhooks.Shutdown)
+	s.lifecycle.Register("database", func(ctx context.Context) error {
+		return s.db.Close()
+	})
 }
 
 func (s *FiberServer) applyMiddleware() {
-	s.App.Use(cors.New(cors.Config{
-		AllowOriginsFunc: func(origin string) bool {
-			return true // Allow all origins for development
-		},
-		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS,PATCH",
-		AllowHeaders:     "Accept,Authorization,Content-Type,X-CSRF-Token",
-		AllowCredentials: true,
-		MaxAge:           300,
-	}))
-
-	s.App.Use(limiter.New(limiter.Config{
-		Max:        s.cfg.Security.RateLimit,
-		Expiration: s.cfg.Security.RateWindow,
-		KeyGenerator: func(c *fiber.Ctx) string {
-			return c.IP() // limit by IP address
-		},
-	}))
+	// First, so the request ID is set for everything after it and rejected requests are logged too
+	s.App.Use(logger.Middleware(slog.Default()))
+
+	// Both can be replaced by ApplyConfig
+	s.reloadMu.Lock()
+	security := s.securityConfig()
+	s.cors = newReloadable(corsMiddleware(security.CORSOrigins))
+	s.rateLimit = newReloadable(rateLimiter(security.RateLimit, security.RateWindow))
+	s.reloadMu.Unlock()
+
+	s.App.Use(s.cors.Handle)
+
+	s.App.Use(s.rateLimit.Handle)
 }
 
 // AuthMiddleware returns the authentication middleware
@@ -109,27 +252,56 @@ func (s *FiberServer) authMiddleware(c *fiber.Ctx) error {
 	return nil
 }
 
-// Custom error handler (now a method of FiberServer)
-func (s *FiberServer) customErrorHandler(c *fiber.Ctx, err error) error {
-	code := fiber.StatusInternalServerError
+// adminMiddleware restricts a route group to authenticated users with the admin role.
+// It must run after authMiddleware.
+func (s *FiberServer) adminMiddleware(c *fiber.Ctx) error {
+	userID, err := users.GetUserIDFromLocals(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
 
-	if e, ok := err.(*fiber.Error); ok {
-		code = e.Code
+	user, err := s.userService.GetUserByID(c.UserContext(), userID)
+	if err != nil || !user.IsAdmin() {
+		log.Printf("Admin access denied for user %s on %s %s", userID.Hex(), c.Method(), c.Path())
+		return apperr.Forbidden("Admin access required")
 	}
 
+	return c.Next()
+}
+
+// auditAdminMiddleware records every admin request that changes something and succeeds.
+// It must run after adminMiddleware.
+func (s *FiberServer) auditAdminMiddleware(c *fiber.Ctx) error {
+	if c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead {
+		return c.Next()
+	}
+	if err := c.Next(); err != nil {
+		return err
+	}
+	if c.Response().StatusCode() < fiber.StatusBadRequest {
+		route := c.Method() + " " + c.Path()
+		s.audit.TryRecord(c.UserContext(), audit.RequestEntry(c, audit.ActionAdmin, audit.TargetRoute, route))
+	}
+	return nil
+}
+
+// customErrorHandler sends every error, including those of unknown routes, as an
+// apperr.ErrorResponse with the status and code of its kind
+func (s *FiberServer) customErrorHandler(c *fiber.Ctx, err error) error {
+	code, _ := apperr.Describe(err)
+
 	// Log important errors only
 	if code >= 500 || code == fiber.StatusRequestEntityTooLarge {
-		log.Printf("Error %d on %s %s: %v", code, c.Method(), c.Path(), err)
+		logger.FromContext(c.UserContext()).Error("request failed",
+			"status", code, "method", c.Method(), "path", c.Path(), "error", err)
 	}
 
 	// Provide more helpful error messages for common issues
-	errorMsg := err.Error()
-	if code == fiber.StatusRequestEntityTooLarge {
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) && fiberErr.Code == fiber.StatusRequestEntityTooLarge {
 		maxSizeMB := s.maxFileSize / (1024 * 1024)
-		errorMsg = fmt.Sprintf("File too large. Maximum allowed size is %dMB for video uploads.", maxSizeMB)
+		err = apperr.TooLarge(fmt.Sprintf("File too large. Maximum allowed size is %dMB for video uploads.", maxSizeMB))
 	}
 
-	return c.Status(code).JSON(fiber.Map{
-		"error": errorMsg,
-	})
+	return apperr.Handler(c, err)
 }
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"streamflow/internal/apperr"
+	"streamflow/internal/cache"
+	"streamflow/internal/livestream"
+	"streamflow/internal/video"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// statsCacheTTL is how long the admin stats are reused, so a dashboard polling them
+// doesn't count the collections on every request
+const statsCacheTTL = 30 * time.Second
+
+// AdminStats counts the users, videos and streams of the platform
+type AdminStats struct {
+	Users   int64                   `json:"users"`
+	Videos  VideoStats              `json:"videos"`
+	Streams livestream.StreamCounts `json:"streams"`
+}
+
+// VideoStats is the number of videos, in all and in each status. Deleted videos are not
+// counted.
+type VideoStats struct {
+	Total    int64                       `json:"total"`
+	ByStatus map[video.VideoStatus]int64 `json:"by_status"`
+}
+
+// newStatsCache creates the cache the admin stats are kept in
+func newStatsCache() *cache.Loader {
+	return cache.NewLoader(cache.NewMemory(), statsCacheTTL)
+}
+
+// statsHandler returns the counts of users, videos and streams (admin only). They are
+// cached for statsCacheTTL.
+func (s *FiberServer) statsHandler(c *fiber.Ctx) error {
+	stats, err := cache.Fetch(s.stats, "admin:stats", func() (*AdminStats, error) {
+		return s.countStats(c)
+	})
+	if err != nil {
+		log.Printf("Failed to get admin stats: %v", err)
+		return apperr.Internal("Failed to get stats")
+	}
+	return c.JSON(stats)
+}
+
+// countStats counts the users, videos and streams in the database
+func (s *FiberServer) countStats(c *fiber.Ctx) (*AdminStats, error) {
+	userCount, err := s.userService.CountUsers(c.UserContext())
+	if err != nil {
+		return nil, err
+	}
+
+	byStatus, err := s.videoService.CountVideosByStatus(c.UserContext())
+	if err != nil {
+		return nil, err
+	}
+	videos := VideoStats{ByStatus: byStatus}
+	for _, count := range byStatus {
+		videos.Total += count
+	}
+
+	streams, err := s.livestreamService.CountStreams(c.UserContext())
+	if err != nil {
+		return nil, err
+	}
+	return &AdminStats{Users: userCount, Videos: videos, Streams: *streams}, nil
+}
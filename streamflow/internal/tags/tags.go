@@ -0,0 +1,55 @@
+// Package tags normalizes the free-form tags carried by videos and streams
+package tags
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// MaxCount caps how many tags a video or stream can carry
+	MaxCount = 10
+	// MaxLength caps the length of a single tag, in characters
+	MaxLength = 32
+)
+
+// ErrTooMany is returned by Parse for more than MaxCount distinct tags
+var ErrTooMany = fmt.Errorf("at most %d tags are allowed", MaxCount)
+
+// Parse normalizes tags with NormalizeAll. ErrTooMany is returned if more than MaxCount
+// are left, rather than dropping the extra ones.
+func Parse(tags []string) ([]string, error) {
+	normalized := NormalizeAll(tags)
+	if len(normalized) > MaxCount {
+		return nil, fmt.Errorf("%w: got %d", ErrTooMany, len(normalized))
+	}
+	return normalized, nil
+}
+
+// NormalizeAll normalizes each tag, dropping empty ones and duplicates. The order of
+// first appearance is kept.
+func NormalizeAll(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+
+	for _, tag := range tags {
+		tag = Normalize(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+
+	return normalized
+}
+
+// Normalize lowercases a tag, strips a leading '#' and truncates it to MaxLength
+func Normalize(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	tag = strings.TrimSpace(strings.TrimPrefix(tag, "#"))
+	if runes := []rune(tag); len(runes) > MaxLength {
+		tag = string(runes[:MaxLength])
+	}
+	return tag
+}
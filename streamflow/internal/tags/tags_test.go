@@ -0,0 +1,53 @@
+package tags
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"Speedrun":              "speedrun",
+		"  #Retro ":             "retro",
+		"# spaced":              "spaced",
+		"":                      "",
+		strings.Repeat("é", 40): strings.Repeat("é", MaxLength),
+	}
+	for input, want := range tests {
+		if got := Normalize(input); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	got, err := Parse([]string{"Speedrun", " #speedrun ", "retro", ""})
+	if err != nil {
+		t.Fatalf("Parse() unexpected error = %v", err)
+	}
+	if want := []string{"speedrun", "retro"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() = %v, want %v", got, want)
+	}
+
+	// Duplicates don't count towards the limit
+	atLimit := make([]string, 0, MaxCount+1)
+	for i := 0; i < MaxCount; i++ {
+		atLimit = append(atLimit, fmt.Sprintf("tag%d", i))
+	}
+	atLimit = append(atLimit, "TAG0")
+	if got, err := Parse(atLimit); err != nil || len(got) != MaxCount {
+		t.Errorf("Parse() of %d distinct tags = %v, %v, want them all", MaxCount, got, err)
+	}
+
+	overLimit := append(atLimit, "one-more")
+	if _, err := Parse(overLimit); !errors.Is(err, ErrTooMany) {
+		t.Errorf("Parse() over the limit error = %v, want ErrTooMany", err)
+	}
+
+	if got := NormalizeAll(overLimit); len(got) != MaxCount+1 {
+		t.Errorf("NormalizeAll() kept %d tags, want %d", len(got), MaxCount+1)
+	}
+}
@@ -0,0 +1,123 @@
+package users
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"streamflow/internal/logger"
+	"streamflow/internal/media"
+	"streamflow/internal/video"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AvatarThumbnailSize is the width and height the avatar thumbnail is scaled down to fit
+const AvatarThumbnailSize = 128
+
+var (
+	// ErrNoAvatar is returned when a user has not uploaded an avatar
+	ErrNoAvatar = errors.New("user has no avatar")
+	// ErrAvatarsUnavailable is returned when no avatar storage was set
+	ErrAvatarsUnavailable = errors.New("avatar storage not configured")
+)
+
+// SetAvatarStorage makes the service store avatars in storage
+func (s *UserService) SetAvatarStorage(storage video.Storage) {
+	s.avatars = storage
+}
+
+// UpdateAvatar stores image as the user's avatar along with a thumbnail of it, and
+// removes the files of the avatar it replaces. image must be a validated image file.
+func (s *UserService) UpdateAvatar(ctx context.Context, userID primitive.ObjectID, image []byte) (*User, error) {
+	if s.avatars == nil {
+		return nil, ErrAvatarsUnavailable
+	}
+
+	thumbnail, err := media.Thumbnail(image, AvatarThumbnailSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// A new key for every upload, so a failed update leaves the current files alone
+	prefix := fmt.Sprintf("avatar_%s_%s", userID.Hex(), primitive.NewObjectID().Hex())
+	avatarPath, thumbnailPath := prefix, prefix+"_thumb.png"
+	if err := s.avatars.Save(ctx, avatarPath, bytes.NewReader(image)); err != nil {
+		return nil, fmt.Errorf("failed to save avatar: %w", err)
+	}
+	if err := s.avatars.Save(ctx, thumbnailPath, bytes.NewReader(thumbnail)); err != nil {
+		s.deleteAvatarFiles(ctx, avatarPath)
+		return nil, fmt.Errorf("failed to save avatar thumbnail: %w", err)
+	}
+
+	var previous User
+	err = s.userCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{
+			"avatar_path":           avatarPath,
+			"avatar_thumbnail_path": thumbnailPath,
+			"updated_at":            time.Now(),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&previous)
+	if err != nil {
+		s.deleteAvatarFiles(ctx, avatarPath, thumbnailPath)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to update avatar: %w", err)
+	}
+
+	s.deleteAvatarFiles(ctx, previous.AvatarPath, previous.AvatarThumbnailPath)
+
+	updated := previous
+	updated.AvatarPath, updated.AvatarThumbnailPath = avatarPath, thumbnailPath
+	return &updated, nil
+}
+
+// OpenAvatar returns the contents of the user's avatar, or of its thumbnail
+func (s *UserService) OpenAvatar(ctx context.Context, userID primitive.ObjectID, thumbnail bool) ([]byte, error) {
+	if s.avatars == nil {
+		return nil, ErrAvatarsUnavailable
+	}
+
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	path := user.AvatarPath
+	if thumbnail {
+		path = user.AvatarThumbnailPath
+	}
+	if path == "" {
+		return nil, ErrNoAvatar
+	}
+
+	file, err := s.avatars.Open(ctx, path)
+	if err != nil {
+		if errors.Is(err, video.ErrFileNotFound) {
+			return nil, ErrNoAvatar
+		}
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// deleteAvatarFiles removes stored avatar files, logging failures
+func (s *UserService) deleteAvatarFiles(ctx context.Context, paths ...string) {
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if err := s.avatars.Delete(ctx, path); err != nil && !errors.Is(err, video.ErrFileNotFound) {
+			logger.FromContext(ctx).Warn("failed to delete avatar file", "path", path, "error", err)
+		}
+	}
+}
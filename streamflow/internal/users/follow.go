@@ -0,0 +1,121 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrUserNotFound     = errors.New("user not found")
+	ErrCannotFollowSelf = errors.New("cannot follow yourself")
+	ErrAlreadyFollowing = errors.New("already following user")
+	ErrNotFollowing     = errors.New("not following user")
+)
+
+// Follow records that FollowerID follows TargetID
+type Follow struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	FollowerID primitive.ObjectID `bson:"follower_id" json:"follower_id"`
+	TargetID   primitive.ObjectID `bson:"target_id" json:"target_id"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// FollowUser makes followerID follow targetID and bumps the target's follower count
+func (s *UserService) FollowUser(ctx context.Context, followerID, targetID primitive.ObjectID) (*Follow, error) {
+	if followerID == targetID {
+		return nil, ErrCannotFollowSelf
+	}
+
+	count, err := s.userCollection.CountDocuments(ctx, bson.M{"_id": targetID})
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	follow := &Follow{
+		ID:         primitive.NewObjectID(),
+		FollowerID: followerID,
+		TargetID:   targetID,
+		CreatedAt:  time.Now(),
+	}
+	// The unique (follower, target) index turns a repeated follow into a duplicate key
+	// error, so the count is only bumped once per relationship
+	if _, err := s.followCollection.InsertOne(ctx, follow); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrAlreadyFollowing
+		}
+		return nil, fmt.Errorf("failed to follow user: %w", err)
+	}
+
+	if err := s.incFollowerCount(ctx, targetID, 1); err != nil {
+		return nil, err
+	}
+
+	return follow, nil
+}
+
+// UnfollowUser removes the follow relationship and decrements the target's follower count
+func (s *UserService) UnfollowUser(ctx context.Context, followerID, targetID primitive.ObjectID) error {
+	result, err := s.followCollection.DeleteOne(ctx, bson.M{"follower_id": followerID, "target_id": targetID})
+	if err != nil {
+		return fmt.Errorf("failed to unfollow user: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFollowing
+	}
+
+	return s.incFollowerCount(ctx, targetID, -1)
+}
+
+// IsFollowing reports whether followerID follows targetID
+func (s *UserService) IsFollowing(ctx context.Context, followerID, targetID primitive.ObjectID) (bool, error) {
+	count, err := s.followCollection.CountDocuments(ctx, bson.M{"follower_id": followerID, "target_id": targetID})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// GetFollowers returns the users following userID, newest first
+func (s *UserService) GetFollowers(ctx context.Context, userID primitive.ObjectID) ([]Follow, error) {
+	return s.findFollows(ctx, bson.M{"target_id": userID})
+}
+
+// GetFollowing returns the users userID follows, newest first
+func (s *UserService) GetFollowing(ctx context.Context, userID primitive.ObjectID) ([]Follow, error) {
+	return s.findFollows(ctx, bson.M{"follower_id": userID})
+}
+
+func (s *UserService) findFollows(ctx context.Context, filter bson.M) ([]Follow, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := s.followCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	follows := []Follow{}
+	if err := cursor.All(ctx, &follows); err != nil {
+		return nil, err
+	}
+	return follows, nil
+}
+
+func (s *UserService) incFollowerCount(ctx context.Context, userID primitive.ObjectID, delta int) error {
+	_, err := s.userCollection.UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$inc": bson.M{"follower_count": delta}})
+	if err != nil {
+		return fmt.Errorf("failed to update follower count: %w", err)
+	}
+	return nil
+}
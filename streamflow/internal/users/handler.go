@@ -2,17 +2,31 @@ package users
 
 import (
 	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"streamflow/internal/apperr"
+	"streamflow/internal/audit"
+	"streamflow/internal/media"
 
 	"github.com/go-playground/validator/v10"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type UserHandler struct {
 	userService *UserService
 
-	jwtService *JWTService
+	jwtService    *JWTService
+	audit         *audit.AuditService
+	sessionCookie bool // Also hand out session tokens as cookies
 }
 
 // This is a constructor that injects dependencies
@@ -23,43 +37,140 @@ func NewUserHandler(userService *UserService, jwtService *JWTService) *UserHandl
 	}
 }
 
+// SetAuditLog makes the handler record logins in the audit log
+func (h *UserHandler) SetAuditLog(a *audit.AuditService) {
+	h.audit = a
+}
+
+// SetSessionCookie makes logins and registrations also set the session token as an
+// HttpOnly cookie, which the auth middleware accepts in place of the Authorization header
+func (h *UserHandler) SetSessionCookie(enabled bool) {
+	h.sessionCookie = enabled
+}
+
+// setSessionCookie gives the client the session token as a cookie, if enabled. The
+// cookie can't be read by scripts or sent with requests started by other sites.
+func (h *UserHandler) setSessionCookie(c *fiber.Ctx, token string) {
+	if !h.sessionCookie {
+		return
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(h.jwtService.TokenTTL()),
+		Secure:   true,
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteStrictMode,
+	})
+}
+
+// Logout revokes the session of the request's session token, or of the refresh token in
+// the body, and clears the session cookie
+func (h *UserHandler) Logout(c *fiber.Ctx) error {
+	h.revokeCurrentSession(c)
+	c.Cookie(&fiber.Cookie{
+		Name:     SessionCookie,
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		Secure:   true,
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteStrictMode,
+	})
+	return c.JSON(fiber.Map{"message": "Logged out"})
+}
+
+// revokeCurrentSession revokes the session a logout is for. Logging out works without a
+// valid token, so failures are only logged.
+func (h *UserHandler) revokeCurrentSession(c *fiber.Ctx) {
+	if tokenString, ok := sessionToken(c); ok {
+		claims, err := h.jwtService.verifyToken(tokenString)
+		if err == nil && claims.SessionID != "" {
+			userID, userErr := primitive.ObjectIDFromHex(claims.UserID)
+			sessionID, sessionErr := primitive.ObjectIDFromHex(claims.SessionID)
+			if userErr == nil && sessionErr == nil {
+				err := h.userService.RevokeSession(c.UserContext(), userID, sessionID)
+				if err != nil && !errors.Is(err, ErrSessionNotFound) {
+					log.Printf("Failed to revoke session %s on logout: %v", claims.SessionID, err)
+				}
+			}
+		}
+	}
+
+	// The session token may have expired, so clients can name the session by its refresh token
+	var req RefreshTokenRequest
+	if err := c.BodyParser(&req); err == nil && req.RefreshToken != "" {
+		if err := h.userService.RevokeRefreshToken(c.UserContext(), req.RefreshToken); err != nil {
+			log.Printf("Failed to revoke session on logout: %v", err)
+		}
+	}
+}
+
+// startSession issues a session token and the refresh token of a new session to a user
+// who just logged in or registered
+func (h *UserHandler) startSession(c *fiber.Ctx, userID primitive.ObjectID) (token, refreshToken string, err error) {
+	refreshToken, session, err := h.userService.CreateSession(c.UserContext(), userID, sessionClient(c))
+	if err != nil {
+		return "", "", apperr.Internal("Failed to create session")
+	}
+	token, err = h.jwtService.GenerateSessionToken(userID, session.ID)
+	if err != nil {
+		return "", "", apperr.Internal("Failed to generate token")
+	}
+	h.setSessionCookie(c, token)
+	return token, refreshToken, nil
+}
+
+// sessionClient describes the client of a request for its session
+func sessionClient(c *fiber.Ctx) SessionClient {
+	return SessionClient{UserAgent: c.Get(fiber.HeaderUserAgent), IP: c.IP()}
+}
+
+// recordLogin records a login or failed login. Failed logins are keyed by the email
+// tried, as it may not belong to a user.
+func (h *UserHandler) recordLogin(c *fiber.Ctx, user *User, email string) {
+	entry := audit.RequestEntry(c, audit.ActionLoginFailed, audit.TargetEmail, email)
+	if user != nil {
+		entry.Actor = user.ID
+		entry.Action = audit.ActionLogin
+		entry.TargetType = audit.TargetUser
+		entry.TargetID = user.ID.Hex()
+	}
+	h.audit.TryRecord(c.UserContext(), entry)
+}
+
 func (h *UserHandler) CreateUser(c *fiber.Ctx) error {
 	var user CreateUserRequest
 
 	if err := c.BodyParser(&user); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
+		return apperr.Validation("Invalid request body")
 	}
 
 	//call service to create user
-    createdUser, err := h.userService.CreateUser(c.Context(), user)
+    createdUser, err := h.userService.CreateUser(c.UserContext(), user)
     if err != nil {
         // Map validation errors to 400, duplicate to 409, others 500
         var vErr validator.ValidationErrors
         if errors.As(err, &vErr) || err.Error() == "email is required" {
-            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+            return apperr.Validation(err.Error())
         }
-        if err.Error() == "user already exists" {
-            return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+        if errors.Is(err, ErrUserExists) {
+            return apperr.Conflict(err.Error())
         }
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-            "error": "Failed to create user",
-        })
+        return apperr.Internal("Failed to create user")
     }
 
 	//generate JWT token
-	token, err := h.jwtService.GenerateToken(createdUser.ID)
+	token, refreshToken, err := h.startSession(c, createdUser.ID)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to generate token",
-		})
+		return err
 	}
 
     return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"message": "User created successfully",
-		"token":   token,
-		"user":    *createdUser,
+		"message":       "User created successfully",
+		"token":         token,
+		"refresh_token": refreshToken,
+		"user":          createdUser.SanitizedUser(),
 	})
 }
 
@@ -67,56 +178,408 @@ func (h *UserHandler) LoginUser(c *fiber.Ctx) error {
 	var req LoginUserRequest
 
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
+		return apperr.Validation("Invalid request body")
 	}
 
 	//authenticate user
-	user, err := h.userService.AuthenticateUser(c.Context(), req.Email, req.Password)
-	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid credentials",
+	user, err := h.userService.AuthenticateUser(c.UserContext(), req.Email, req.Password)
+	if errors.Is(err, ErrTwoFactorRequired) {
+		// Password was correct; the client must now complete the TOTP step
+		challenge, err := h.jwtService.GenerateChallengeToken(user.ID)
+		if err != nil {
+			return apperr.Internal("Failed to generate challenge")
+		}
+		return c.JSON(fiber.Map{
+			"message":             "Two-factor authentication required",
+			"two_factor_required": true,
+			"challenge_token":     challenge,
 		})
 	}
+	var locked *LockedError
+	if errors.As(err, &locked) {
+		h.recordLogin(c, nil, req.Email)
+		return lockedResponse(c, locked)
+	}
+	if err != nil {
+		h.recordLogin(c, nil, req.Email)
+		return apperr.Unauthorized("Invalid credentials")
+	}
+	h.recordLogin(c, user, req.Email)
 
 	//generate JWT token for the authenticated user
-	token, err := h.jwtService.GenerateToken(user.ID)
+	token, refreshToken, err := h.startSession(c, user.ID)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to generate token",
-		})
+		return err
 	}
 
 	return c.JSON(fiber.Map{
-		"message": "Login successful",
-		"token":   token,
-		"user":    *user,
+		"message":       "Login successful",
+		"token":         token,
+		"refresh_token": refreshToken,
+		"user":          user.SanitizedUser(),
 	})
 }
 
+// lockedResponse refuses a login while it is locked, telling the client when to retry
+func lockedResponse(c *fiber.Ctx, locked *LockedError) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+	return apperr.New(apperr.ErrRateLimited, "Too many failed login attempts, please try again later")
+}
+
 func (h *UserHandler) GetUser(c *fiber.Ctx) error {
 	userIDStr := c.Locals("user_id").(string)
 	userID, err := primitive.ObjectIDFromHex(userIDStr)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid user ID",
-		})
+		return apperr.Validation("Invalid user ID")
 	}
 
-	user, err := h.userService.GetUserByID(c.Context(), userID)
+	user, err := h.userService.GetUserByID(c.UserContext(), userID)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to get user",
-		})
+		return apperr.Internal("Failed to get user")
 	}
 
 	return c.JSON(fiber.Map{
 		"message": "User retrieved successfully",
-		"user": *user,
+		"user": user.SanitizedUser(),
 	})
 }
 
+// LoginTwoFactor completes a login challenge with a TOTP code
+func (h *UserHandler) LoginTwoFactor(c *fiber.Ctx) error {
+	var req TwoFactorLoginRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.Validation("Invalid request body")
+	}
+
+	userID, err := h.jwtService.VerifyChallengeToken(req.ChallengeToken)
+	if err != nil {
+		return apperr.Unauthorized("Invalid or expired challenge")
+	}
+
+	user, err := h.userService.VerifyTwoFactorLogin(c.UserContext(), userID, req.Code)
+	if err != nil {
+		h.audit.TryRecord(c.UserContext(), audit.Entry{
+			Actor:      userID,
+			Action:     audit.ActionLoginFailed,
+			TargetType: audit.TargetUser,
+			TargetID:   userID.Hex(),
+			IP:         c.IP(),
+		})
+		var locked *LockedError
+		if errors.As(err, &locked) {
+			return lockedResponse(c, locked)
+		}
+		return apperr.Unauthorized("Invalid two-factor code")
+	}
+	h.recordLogin(c, user, "")
+
+	token, refreshToken, err := h.startSession(c, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"message":       "Login successful",
+		"token":         token,
+		"refresh_token": refreshToken,
+		"user":          user.SanitizedUser(),
+	})
+}
+
+// EnableTOTP starts two-factor enrollment for the authenticated user
+func (h *UserHandler) EnableTOTP(c *fiber.Ctx) error {
+	userID, err := GetUserIDFromLocals(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	enrollment, err := h.userService.EnableTOTP(c.UserContext(), userID)
+	if err != nil {
+		if errors.Is(err, ErrTwoFactorEnabled) {
+			return apperr.Conflict(err.Error())
+		}
+		return apperr.Internal("Failed to start two-factor enrollment")
+	}
+
+	return c.JSON(enrollment)
+}
+
+// VerifyTOTPSetup confirms two-factor enrollment with a code from the authenticator app
+func (h *UserHandler) VerifyTOTPSetup(c *fiber.Ctx) error {
+	userID, err := GetUserIDFromLocals(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	var req TOTPCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.Validation("Invalid request body")
+	}
+
+	err = h.userService.VerifyTOTPSetup(c.UserContext(), userID, req.Code)
+	switch {
+	case err == nil:
+		return c.JSON(fiber.Map{"message": "Two-factor authentication enabled"})
+	case errors.Is(err, ErrInvalidTOTPCode):
+		return apperr.Validation(err.Error())
+	case errors.Is(err, ErrTwoFactorEnabled):
+		return apperr.Conflict(err.Error())
+	case errors.Is(err, ErrTwoFactorNotSetUp):
+		return apperr.Validation(err.Error())
+	}
+	return apperr.Internal("Failed to verify two-factor code")
+}
+
+// FollowUser makes the authenticated user follow the user in the path
+func (h *UserHandler) FollowUser(c *fiber.Ctx) error {
+	followerID, err := GetUserIDFromLocals(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	targetID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid user ID")
+	}
+
+	follow, err := h.userService.FollowUser(c.UserContext(), followerID, targetID)
+	switch {
+	case err == nil:
+		return c.Status(fiber.StatusCreated).JSON(follow)
+	case errors.Is(err, ErrCannotFollowSelf):
+		return apperr.Validation(err.Error())
+	case errors.Is(err, ErrUserNotFound):
+		return apperr.NotFound(err.Error())
+	case errors.Is(err, ErrAlreadyFollowing):
+		return apperr.Conflict(err.Error())
+	}
+	return apperr.Internal("Failed to follow user")
+}
+
+// UnfollowUser makes the authenticated user stop following the user in the path
+func (h *UserHandler) UnfollowUser(c *fiber.Ctx) error {
+	followerID, err := GetUserIDFromLocals(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	targetID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid user ID")
+	}
+
+	err = h.userService.UnfollowUser(c.UserContext(), followerID, targetID)
+	if errors.Is(err, ErrNotFollowing) {
+		return apperr.NotFound(err.Error())
+	}
+	if err != nil {
+		return apperr.Internal("Failed to unfollow user")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetUserByUsername returns the public profile of the user named in the path, ignoring case
+func (h *UserHandler) GetUserByUsername(c *fiber.Ctx) error {
+	name, err := url.PathUnescape(c.Params("name"))
+	if err != nil {
+		return apperr.Validation("Invalid username")
+	}
+
+	profile, err := h.userService.GetUserByUsername(c.UserContext(), name)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return apperr.NotFound("User not found")
+		}
+		return apperr.Internal("Failed to get user profile")
+	}
+
+	return c.JSON(profile)
+}
+
+// GetPublicProfile returns the public profile of the user in the path
+func (h *UserHandler) GetPublicProfile(c *fiber.Ctx) error {
+	userID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid user ID")
+	}
+
+	profile, err := h.userService.GetPublicProfile(c.UserContext(), userID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return apperr.NotFound("User not found")
+		}
+		return apperr.Internal("Failed to get user profile")
+	}
+
+	return c.JSON(profile)
+}
+
+// GetFollowers lists the users following the user in the path
+func (h *UserHandler) GetFollowers(c *fiber.Ctx) error {
+	userID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid user ID")
+	}
+
+	followers, err := h.userService.GetFollowers(c.UserContext(), userID)
+	if err != nil {
+		return apperr.Internal("Failed to get followers")
+	}
+
+	return c.JSON(fiber.Map{"followers": followers})
+}
+
 // func (h *UserHandler) DeleteUser(c *fiber.Ctx) error {
 	
-// }
\ No newline at end of file
+// }
+
+// UploadAvatar replaces the requester's avatar with the image in the "avatar" form field
+func (h *UserHandler) UploadAvatar(c *fiber.Ctx) error {
+	userID, err := GetUserIDFromLocals(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	header, err := c.FormFile("avatar")
+	if err != nil {
+		return apperr.Validation("An avatar image is required")
+	}
+	if _, err := media.ValidateImageFile(header, media.MaxImageSize); err != nil {
+		return imageError(err)
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return apperr.Internal("Failed to open avatar file")
+	}
+	defer file.Close()
+	image, err := io.ReadAll(file)
+	if err != nil {
+		return apperr.Internal("Failed to read avatar file")
+	}
+
+	user, err := h.userService.UpdateAvatar(c.UserContext(), userID, image)
+	if err != nil {
+		switch {
+		case errors.Is(err, media.ErrInvalidImage):
+			return imageError(err)
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return apperr.NotFound("User not found")
+		}
+		log.Printf("Failed to update avatar of user %s: %v", userID.Hex(), err)
+		return apperr.Internal("Failed to update avatar")
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Avatar updated successfully",
+		"user":    user.SanitizedUser(),
+	})
+}
+
+// GetAvatar serves a user's avatar, or its thumbnail with ?size=thumbnail
+func (h *UserHandler) GetAvatar(c *fiber.Ctx) error {
+	userID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid user ID")
+	}
+
+	image, err := h.userService.OpenAvatar(c.UserContext(), userID, c.Query("size") == "thumbnail")
+	if err != nil {
+		if errors.Is(err, ErrNoAvatar) || errors.Is(err, mongo.ErrNoDocuments) {
+			return apperr.NotFound("Avatar not found")
+		}
+		return apperr.Internal("Failed to load avatar")
+	}
+
+	// Kept short, as the URL stays the same when the avatar is replaced
+	c.Set("Cache-Control", "public, max-age=300")
+	c.Set("Content-Type", http.DetectContentType(image))
+	return c.Send(image)
+}
+
+// imageError maps a media validation error to its API error
+func imageError(err error) error {
+	if errors.Is(err, media.ErrImageTooLarge) {
+		return apperr.TooLarge(err.Error())
+	}
+	return apperr.Validation(err.Error())
+}
+
+// RefreshToken exchanges the refresh token of a session for a new session token and a
+// new refresh token, recording when and where the session was last used. The old
+// refresh token can't be used again.
+func (h *UserHandler) RefreshToken(c *fiber.Ctx) error {
+	var req RefreshTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.Validation("Invalid request body")
+	}
+	if req.RefreshToken == "" {
+		return apperr.Validation("refresh_token is required")
+	}
+
+	refreshToken, session, err := h.userService.RefreshSession(c.UserContext(), req.RefreshToken, sessionClient(c))
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		return apperr.Unauthorized("Invalid or expired refresh token")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to refresh session")
+	}
+
+	token, err := h.jwtService.GenerateSessionToken(session.UserID, session.ID)
+	if err != nil {
+		return apperr.Internal("Failed to generate token")
+	}
+	h.setSessionCookie(c, token)
+
+	return c.JSON(fiber.Map{
+		"message":       "Token refreshed",
+		"token":         token,
+		"refresh_token": refreshToken,
+	})
+}
+
+// ListSessions returns the sessions of the authenticated user
+func (h *UserHandler) ListSessions(c *fiber.Ctx) error {
+	userID, err := GetUserIDFromLocals(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	sessions, err := h.userService.ListSessions(c.UserContext(), userID)
+	if err != nil {
+		return apperr.Internal("Failed to list sessions")
+	}
+	if current, ok := GetSessionIDFromLocals(c); ok {
+		for _, session := range sessions {
+			session.Current = session.ID == current
+		}
+	}
+	return c.JSON(fiber.Map{"sessions": sessions})
+}
+
+// RevokeSession ends one session of the authenticated user, invalidating its refresh
+// token and the session tokens issued for it
+func (h *UserHandler) RevokeSession(c *fiber.Ctx) error {
+	userID, err := GetUserIDFromLocals(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	sessionID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid session ID")
+	}
+
+	err = h.userService.RevokeSession(c.UserContext(), userID, sessionID)
+	if errors.Is(err, ErrSessionNotFound) {
+		return apperr.NotFound(err.Error())
+	}
+	if err != nil {
+		return apperr.Internal("Failed to revoke session")
+	}
+	h.audit.TryRecord(c.UserContext(), audit.RequestEntry(c, audit.ActionSessionRevoke, audit.TargetSession, sessionID.Hex()))
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -1,68 +1,238 @@
 package users
 
 import (
+	"context"
 	"errors"
 	"strings"
 	"time"
 
+	"streamflow/internal/apperr"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// TwoFactorChallengeTTL is how long a user has to complete a 2FA login challenge
+const TwoFactorChallengeTTL = 5 * time.Minute
+
+// purposeTwoFactor marks a token that only authorizes completing a 2FA login
+const purposeTwoFactor = "2fa"
+
+// SessionTokenTTL is how long a session token is valid unless set otherwise with
+// SetTokenTTL
+const SessionTokenTTL = 72 * time.Hour
+
+// SessionCookie names the cookie browser clients can be given their session token in,
+// instead of storing it themselves
+const SessionCookie = "streamflow_session"
+
 type JWTClaims struct {
-	UserID string `json:"user_id"`
+	UserID    string `json:"user_id"`
+	Purpose   string `json:"purpose,omitempty"` // Empty for session tokens
+	SessionID string `json:"sid,omitempty"`     // Session the token was issued for, see GenerateSessionToken
 	jwt.RegisteredClaims
 }
 
+// SessionStore reports whether a session is still active, so the tokens of a revoked
+// session stop working before they expire. UserService implements it.
+type SessionStore interface {
+	SessionActive(ctx context.Context, userID, sessionID primitive.ObjectID) (bool, error)
+}
+
 type JWTService struct {
 	secretKey string
+	tokenTTL  time.Duration
+	sessions  SessionStore // Checked for tokens with a session ID; nil checks nothing
 }
 
 func NewJWTService(secretKey string) *JWTService {
-	return &JWTService{secretKey: secretKey}
+	return &JWTService{secretKey: secretKey, tokenTTL: SessionTokenTTL}
+}
+
+// SetTokenTTL sets how long new session tokens are valid. Clients with a refresh token
+// get a new one when it expires, so it can be kept short.
+func (s *JWTService) SetTokenTTL(ttl time.Duration) {
+	if ttl > 0 {
+		s.tokenTTL = ttl
+	}
 }
 
+// TokenTTL returns how long new session tokens are valid
+func (s *JWTService) TokenTTL() time.Duration {
+	return s.tokenTTL
+}
+
+// SetSessionStore makes the service reject tokens whose session was revoked or has
+// expired. Tokens issued without a session are not affected.
+func (s *JWTService) SetSessionStore(store SessionStore) {
+	s.sessions = store
+}
+
+// GenerateToken issues a session token that isn't tied to a session, so it can't be
+// revoked and is only limited by its expiry
 func (s *JWTService) GenerateToken(userID primitive.ObjectID) (string, error) {
+	return s.generateToken(userID, primitive.NilObjectID)
+}
+
+// GenerateSessionToken issues a session token for a session created with
+// UserService.CreateSession. It stops working once the session is revoked.
+func (s *JWTService) GenerateSessionToken(userID, sessionID primitive.ObjectID) (string, error) {
+	return s.generateToken(userID, sessionID)
+}
+
+func (s *JWTService) generateToken(userID, sessionID primitive.ObjectID) (string, error) {
 	claims := &JWTClaims{
 		UserID: userID.Hex(), // Store as hex string
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 72)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(s.tokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
+	if !sessionID.IsZero() {
+		claims.SessionID = sessionID.Hex()
+	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(s.secretKey))
 }
 
+// GenerateChallengeToken issues a short-lived token that can only be exchanged for a
+// session token by completing the 2FA login step
+func (s *JWTService) GenerateChallengeToken(userID primitive.ObjectID) (string, error) {
+	claims := &JWTClaims{
+		UserID:  userID.Hex(),
+		Purpose: purposeTwoFactor,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TwoFactorChallengeTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(s.secretKey))
+}
+
+// VerifyChallengeToken validates a 2FA challenge token and returns the user it was issued for
+func (s *JWTService) VerifyChallengeToken(tokenString string) (primitive.ObjectID, error) {
+	claims, err := s.parseToken(tokenString)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if claims.Purpose != purposeTwoFactor {
+		return primitive.NilObjectID, errors.New("not a challenge token")
+	}
+	return primitive.ObjectIDFromHex(claims.UserID)
+}
+
+// Middleware authenticates requests by the session token in their Authorization header
+// or, for browser clients, their session cookie
 func (s *JWTService) Middleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		authHeader := c.Get("Authorization")
-		if authHeader == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "missing or malformed JWT"})
+		tokenString, ok := sessionToken(c)
+		if !ok {
+			return apperr.Unauthorized("missing or malformed JWT")
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "missing or malformed JWT"})
-		}
-
-		tokenString := parts[1]
-
-		claims, err := s.verifyToken(tokenString)
+		claims, err := s.authenticate(c.UserContext(), tokenString)
 		if err != nil {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid or expired JWT"})
+			return apperr.Unauthorized("invalid or expired JWT")
 		}
 
 		// Store the UserID as a string
 		c.Locals("user_id", claims.UserID)
+		c.Locals("session_id", claims.SessionID)
 
 		return c.Next()
 	}
 }
 
+// OptionalMiddleware identifies the user like Middleware when the request carries a valid
+// session token, and lets the request through anonymously otherwise. It is for public
+// routes whose response depends on who is asking.
+func (s *JWTService) OptionalMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tokenString, ok := sessionToken(c)
+		if !ok {
+			return c.Next()
+		}
+		if claims, err := s.authenticate(c.UserContext(), tokenString); err == nil {
+			c.Locals("user_id", claims.UserID)
+			c.Locals("session_id", claims.SessionID)
+		}
+		return c.Next()
+	}
+}
+
+// sessionToken reads the session token of a request from the Authorization header, or
+// from the session cookie when there is no header. ok is false if there is neither or
+// the header isn't a bearer token.
+func sessionToken(c *fiber.Ctx) (token string, ok bool) {
+	authHeader := c.Get(fiber.HeaderAuthorization)
+	if authHeader == "" {
+		token = c.Cookies(SessionCookie)
+		return token, token != ""
+	}
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// VerifySessionToken validates a session token and returns the user it was issued for.
+// It is for connections that can't go through Middleware, such as WebSocket upgrades.
+func (s *JWTService) VerifySessionToken(tokenString string) (primitive.ObjectID, error) {
+	claims, err := s.authenticate(context.Background(), tokenString)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return primitive.ObjectIDFromHex(claims.UserID)
+}
+
+// authenticate validates a session token like verifyToken and also rejects it if its
+// session is no longer active
+func (s *JWTService) authenticate(ctx context.Context, tokenString string) (*JWTClaims, error) {
+	claims, err := s.verifyToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.SessionID == "" || s.sessions == nil {
+		return claims, nil
+	}
+
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return nil, errors.New("invalid token")
+	}
+	sessionID, err := primitive.ObjectIDFromHex(claims.SessionID)
+	if err != nil {
+		return nil, errors.New("invalid token")
+	}
+	active, err := s.sessions.SessionActive(ctx, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, ErrSessionNotFound
+	}
+	return claims, nil
+}
+
+// verifyToken validates a session token. Challenge tokens are rejected.
 func (s *JWTService) verifyToken(tokenString string) (*JWTClaims, error) {
+	claims, err := s.parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+func (s *JWTService) parseToken(tokenString string) (*JWTClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("unexpected signing method")
@@ -81,6 +251,14 @@ func (s *JWTService) verifyToken(tokenString string) (*JWTClaims, error) {
 	return nil, errors.New("invalid token")
 }
 
+// GetSessionIDFromLocals returns the session of the authenticated request's token, or
+// false if the token wasn't issued for a session
+func GetSessionIDFromLocals(c *fiber.Ctx) (primitive.ObjectID, bool) {
+	sessionID, _ := c.Locals("session_id").(string)
+	id, err := primitive.ObjectIDFromHex(sessionID)
+	return id, err == nil
+}
+
 // GetUserIDFromLocals retrieves the user ID from context and converts it to primitive.ObjectID
 func GetUserIDFromLocals(c *fiber.Ctx) (primitive.ObjectID, error) {
 	userIDStr, ok := c.Locals("user_id").(string)
@@ -0,0 +1,101 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"streamflow/internal/logger"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrAccountLocked is returned by AuthenticateUser and VerifyTwoFactorLogin while logins
+// to an email are locked after too many failed attempts. The error is a *LockedError
+// telling when to retry.
+var ErrAccountLocked = errors.New("too many failed login attempts")
+
+// LockedError reports a locked login and how long until it unlocks
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%v, retry in %s", ErrAccountLocked, e.RetryAfter.Round(time.Second))
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrAccountLocked
+}
+
+// SetLoginLockout locks logins to an email for lockout after maxAttempts consecutive
+// failures within window. Attempts are counted per email whether or not a user has it,
+// so a lockout doesn't reveal which emails are registered. A maxAttempts of zero, the
+// default, disables lockouts.
+func (s *UserService) SetLoginLockout(maxAttempts int, window, lockout time.Duration) {
+	s.loginMaxAttempts = maxAttempts
+	s.loginAttemptWindow = window
+	s.loginLockout = lockout
+}
+
+// checkLoginLockout returns a *LockedError if logins to the email are locked
+func (s *UserService) checkLoginLockout(ctx context.Context, email string) error {
+	if s.loginMaxAttempts <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	var attempts struct {
+		LockedUntil time.Time `bson:"locked_until"`
+	}
+	err := s.loginAttemptCollection.FindOne(ctx, bson.M{"_id": email, "locked_until": bson.M{"$gt": now}}).Decode(&attempts)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check login lockout: %w", err)
+	}
+	return &LockedError{RetryAfter: attempts.LockedUntil.Sub(now)}
+}
+
+// recordFailedLogin counts a failed login to the email, locking it once the failures in
+// the current window reach the limit. The count starts over after a lockout.
+func (s *UserService) recordFailedLogin(ctx context.Context, email string) {
+	if s.loginMaxAttempts <= 0 {
+		return
+	}
+
+	now := time.Now()
+	inWindow := bson.M{"$gt": bson.A{"$window_start", now.Add(-s.loginAttemptWindow)}}
+	reachedLimit := bson.M{"$gte": bson.A{"$failures", s.loginMaxAttempts}}
+	// A pipeline update, so concurrent failures are all counted
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"failures":     bson.M{"$cond": bson.A{inWindow, bson.M{"$add": bson.A{"$failures", 1}}, 1}},
+			"window_start": bson.M{"$cond": bson.A{inWindow, "$window_start", now}},
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"failures":     bson.M{"$cond": bson.A{reachedLimit, 0, "$failures"}},
+			"window_start": bson.M{"$cond": bson.A{reachedLimit, nil, "$window_start"}},
+			"locked_until": bson.M{"$cond": bson.A{reachedLimit, now.Add(s.loginLockout), "$locked_until"}},
+			"expires_at":   now.Add(s.loginAttemptWindow + s.loginLockout),
+		}}},
+	}
+	_, err := s.loginAttemptCollection.UpdateOne(ctx, bson.M{"_id": email}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to record failed login", "error", err)
+	}
+}
+
+// resetFailedLogins clears the failed logins to the email after a successful one
+func (s *UserService) resetFailedLogins(ctx context.Context, email string) {
+	if s.loginMaxAttempts <= 0 {
+		return
+	}
+	if _, err := s.loginAttemptCollection.DeleteOne(ctx, bson.M{"_id": email}); err != nil {
+		logger.FromContext(ctx).Error("failed to reset failed logins", "error", err)
+	}
+}
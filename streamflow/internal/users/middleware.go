@@ -1,7 +1,7 @@
 package users
 
 import (
-	"strings"
+	"streamflow/internal/apperr"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -10,32 +10,24 @@ import (
 // for protected routes
 func AuthMiddleware( jwtService *JWTService) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		authHeader := c.Get("Authorization")
-		if authHeader == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error":"Unauthorized header required",
-			})
+		//extract token from header or session cookie if it exists
+		token, ok := sessionToken(c)
+		if !ok && c.Get("Authorization") == "" {
+			return apperr.Unauthorized("Unauthorized header required")
 		}
-
-		if !strings.HasPrefix(authHeader, "Bearer ") {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Invalid authorization header format",
-			})
+		if !ok {
+			return apperr.Unauthorized("Invalid authorization header format")
 		}
-		
-		//extract token from header if it exists
-		token := strings.TrimPrefix(authHeader, "Bearer ")
 
 		//verify token
-		claims, err := jwtService.verifyToken(token)
+		claims, err := jwtService.authenticate(c.UserContext(), token)
 		if err != nil {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error":"Invalid token",
-			})
+			return apperr.Unauthorized("Invalid token")
 		}
 
 		//set user_id in context for future use
 		c.Locals("user_id", claims.UserID)
+		c.Locals("session_id", claims.SessionID)
 		// c.Locals("userEmail", claims.Email)
 
 		return c.Next()
@@ -0,0 +1,54 @@
+package users
+
+import (
+	"context"
+	"time"
+
+	"streamflow/internal/logger"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SetPasswordCost sets the bcrypt cost new password hashes are made with. Hashes made
+// with a lower cost are upgraded the next time their user logs in. Costs outside
+// bcrypt's range are ignored.
+func (s *UserService) SetPasswordCost(cost int) {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return
+	}
+	s.passwordCost = cost
+}
+
+// hashPassword hashes a password with the configured cost
+func (s *UserService) hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.passwordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// upgradePasswordHash rehashes the password of a user who just logged in if their hash
+// was made with a lower cost than the configured one. Failures are only logged; the old
+// hash still works and the upgrade is retried on the next login.
+func (s *UserService) upgradePasswordHash(ctx context.Context, user *User, password string) {
+	cost, err := bcrypt.Cost([]byte(user.Password))
+	if err != nil || cost >= s.passwordCost {
+		return
+	}
+
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to rehash password", "user_id", user.ID.Hex(), "error", err)
+		return
+	}
+	// Only replace the hash that was checked, in case the password changed meanwhile
+	filter := bson.M{"_id": user.ID, "password": user.Password}
+	update := bson.M{"$set": bson.M{"password": hash, "updated_at": time.Now()}}
+	if _, err := s.userCollection.UpdateOne(ctx, filter, update); err != nil {
+		logger.FromContext(ctx).Error("failed to store rehashed password", "user_id", user.ID.Hex(), "error", err)
+		return
+	}
+	user.Password = hash
+}
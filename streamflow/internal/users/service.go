@@ -3,9 +3,15 @@ package users
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log"
 	"strings"
 	"time"
 
+	"streamflow/internal/config"
+	"streamflow/internal/video"
+	"streamflow/internal/webhooks"
+
 	"github.com/go-playground/validator/v10"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -14,15 +20,72 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	// ErrTwoFactorRequired is returned by AuthenticateUser when the password is correct
+	// but the user must still complete a TOTP challenge
+	ErrTwoFactorRequired = errors.New("2FA required")
+	ErrInvalidTOTPCode   = errors.New("invalid two-factor code")
+	ErrTwoFactorEnabled  = errors.New("two-factor authentication already enabled")
+	ErrTwoFactorNotSetUp = errors.New("two-factor authentication not set up")
+
+	// ErrUserExists is returned by CreateUser for a duplicate it can't attribute to a
+	// field; ErrDuplicateEmail and ErrDuplicateUsername wrap it
+	ErrUserExists        = errors.New("user already exists")
+	ErrDuplicateEmail    = fmt.Errorf("%w: email is already registered", ErrUserExists)
+	ErrDuplicateUsername = fmt.Errorf("%w: username is already taken", ErrUserExists)
+)
+
+// Names of the unique indexes, which CreateUser reads from duplicate key errors. They
+// are the names MongoDB gives these indexes by default.
+const (
+	emailIndexName    = "email_1"
+	userNameIndexName = "user_name_1"
+)
+
+// userNameCollation compares usernames ignoring case. It must match the collation of the
+// user_name_ci index created by the migrations, or lookups can't use that index.
+var userNameCollation = &options.Collation{Locale: "en", Strength: 2}
+
 type UserService struct {
-	userCollection *mongo.Collection
-	validator      *validator.Validate
+	userCollection         *mongo.Collection
+	followCollection       *mongo.Collection
+	loginAttemptCollection *mongo.Collection
+	sessionCollection      *mongo.Collection
+	validator              *validator.Validate
+	totpIssuer             string
+	totpSkew               int
+	secretCipher           *secretCipher
+	webhooks               *webhooks.WebhookDispatcher
+	avatars                video.Storage // Where avatar images are kept
+	loginMaxAttempts       int           // Failed logins before a lockout; zero disables lockouts
+	loginAttemptWindow     time.Duration // Failures further apart than this aren't consecutive
+	loginLockout           time.Duration // How long a lockout lasts
+	passwordCost           int           // bcrypt cost of new password hashes
+	refreshTokenTTL        time.Duration // How long a new session's refresh token is valid
 }
 
-func NewUserService(db *mongo.Database) *UserService {
+func NewUserService(db *mongo.Database, cfg config.TwoFactorConfig) *UserService {
+	secrets, err := newSecretCipher(cfg.EncryptionKey)
+	if err != nil {
+		log.Fatalf("Failed to create TOTP secret cipher: %v", err)
+	}
+
+	issuer := cfg.Issuer
+	if issuer == "" {
+		issuer = "StreamFlow"
+	}
+
 	service := &UserService{
-		userCollection: db.Collection("users"),
-		validator:      validator.New(),
+		userCollection:         db.Collection("users"),
+		followCollection:       db.Collection("follows"),
+		loginAttemptCollection: db.Collection("login_attempts"),
+		sessionCollection:      db.Collection("sessions"),
+		validator:              validator.New(),
+		totpIssuer:             issuer,
+		totpSkew:               cfg.Skew,
+		secretCipher:           secrets,
+		passwordCost:           bcrypt.DefaultCost,
+		refreshTokenTTL:        DefaultRefreshTokenTTL,
 	}
 	
 	// Create unique indexes for email and username to handle race conditions
@@ -46,7 +109,13 @@ func (s *UserService) CreateUser(ctx context.Context, req CreateUserRequest) (*U
 	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
 	req.UserName = strings.TrimSpace(req.UserName)
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	// Fast path that skips hashing for most duplicates. It is racy, so the unique
+	// indexes checked on insert below are what keep users unique.
+	if err := s.checkNotTaken(ctx, req.Email, req.UserName); err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := s.hashPassword(req.Password)
 	if err != nil {
 		return nil, err
 	}
@@ -54,35 +123,90 @@ func (s *UserService) CreateUser(ctx context.Context, req CreateUserRequest) (*U
 	user := User{
 		ID:        primitive.NewObjectID(),
 		Email:     req.Email,
-		Password:  string(hashedPassword),
+		Password:  hashedPassword,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 		UserName:  req.UserName,
+		Role:      RoleUser,
 	}
 
-	// Use InsertOne which will fail if unique constraints are violated
-	// This handles race conditions better than FindOne + InsertOne
+	// The unique indexes reject the insert of a concurrently registered duplicate
 	_, err = s.userCollection.InsertOne(ctx, user)
 	if err != nil {
-		// Check if it's a duplicate key error
 		if mongo.IsDuplicateKeyError(err) {
-			return nil, errors.New("user already exists")
+			return nil, duplicateUserError(err)
 		}
 		return nil, err
 	}
 
+	s.webhooks.Dispatch(webhooks.Event{
+		Event:  webhooks.EventUserRegistered,
+		UserID: user.ID.Hex(),
+	})
+
 	return &user, nil
 }
 
+// checkNotTaken returns ErrDuplicateEmail or ErrDuplicateUsername if a user already has
+// the email or username
+func (s *UserService) checkNotTaken(ctx context.Context, email, userName string) error {
+	var existing User
+	err := s.userCollection.FindOne(ctx,
+		bson.M{"$or": bson.A{bson.M{"email": email}, bson.M{"user_name": userName}}},
+		options.FindOne().SetProjection(bson.M{"email": 1, "user_name": 1}),
+	).Decode(&existing)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing.Email == email {
+		return ErrDuplicateEmail
+	}
+	return ErrDuplicateUsername
+}
+
+// duplicateUserError maps a duplicate key error from inserting a user to the error of
+// the field whose unique index rejected it
+func duplicateUserError(err error) error {
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		for _, e := range writeErr.WriteErrors {
+			if e.Code != 11000 {
+				continue
+			}
+			switch {
+			case strings.Contains(e.Message, emailIndexName):
+				return ErrDuplicateEmail
+			case strings.Contains(e.Message, userNameIndexName):
+				return ErrDuplicateUsername
+			}
+		}
+	}
+	return ErrUserExists
+}
+
+// SetWebhookDispatcher makes the service fire user.registered webhooks
+func (s *UserService) SetWebhookDispatcher(d *webhooks.WebhookDispatcher) {
+	s.webhooks = d
+}
+
 func (s *UserService) AuthenticateUser(ctx context.Context, email, password string) (*User, error) {
 	// Normalize email to match creation logic
 	email = strings.ToLower(strings.TrimSpace(email))
+
+	// Locked emails are refused before the password is checked, even a correct one
+	if err := s.checkLoginLockout(ctx, email); err != nil {
+		return nil, err
+	}
 	
 	var user User
 	// Find user by email (email is unique)
 	err := s.userCollection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
 	if err != nil {
 		// Don't specify whether email or password is wrong for security
+		s.recordFailedLogin(ctx, email)
 		return nil, errors.New("invalid credentials")
 	}
 
@@ -91,12 +215,143 @@ func (s *UserService) AuthenticateUser(ctx context.Context, email, password stri
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if err != nil {
 		// Password doesn't match
+		s.recordFailedLogin(ctx, email)
 		return nil, errors.New("invalid credentials")
 	}
+	s.upgradePasswordHash(ctx, &user, password)
+
+	// The caller must complete the TOTP challenge before issuing a session. Failed logins
+	// are only reset once it is, so the code can't be guessed without running into the
+	// lockout.
+	if user.TwoFactorEnabled {
+		return &user, ErrTwoFactorRequired
+	}
+	s.resetFailedLogins(ctx, email)
 
 	return &user, nil
 }
 
+// EnableTOTP starts two-factor enrollment by generating a new secret. 2FA is only
+// turned on once the user confirms a code with VerifyTOTPSetup.
+func (s *UserService) EnableTOTP(ctx context.Context, userID primitive.ObjectID) (*TOTPEnrollment, error) {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+
+	encrypted, err := s.secretCipher.encrypt(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt TOTP secret: %w", err)
+	}
+
+	_, err = s.userCollection.UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{
+			"$set":   bson.M{"totp_secret": encrypted, "updated_at": time.Now()},
+			"$unset": bson.M{"totp_last_step": ""},
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+
+	return &TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totpProvisioningURI(s.totpIssuer, user.Email, secret),
+	}, nil
+}
+
+// VerifyTOTPSetup confirms enrollment with a code from the authenticator app and enables 2FA
+func (s *UserService) VerifyTOTPSetup(ctx context.Context, userID primitive.ObjectID, code string) error {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.TwoFactorEnabled {
+		return ErrTwoFactorEnabled
+	}
+
+	if err := s.checkTOTP(ctx, user, code); err != nil {
+		return err
+	}
+
+	_, err = s.userCollection.UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"two_factor_enabled": true, "updated_at": time.Now()}})
+	if err != nil {
+		return fmt.Errorf("failed to enable two-factor authentication: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyTwoFactorLogin completes a login challenge by validating the user's TOTP code.
+// Wrong codes count as failed logins to the user's email, so they lead to the same
+// lockout as wrong passwords.
+func (s *UserService) VerifyTwoFactorLogin(ctx context.Context, userID primitive.ObjectID, code string) (*User, error) {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, errors.New("invalid credentials")
+	}
+	if !user.TwoFactorEnabled {
+		return nil, ErrTwoFactorNotSetUp
+	}
+	if err := s.checkLoginLockout(ctx, user.Email); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkTOTP(ctx, user, code); err != nil {
+		if errors.Is(err, ErrInvalidTOTPCode) {
+			s.recordFailedLogin(ctx, user.Email)
+		}
+		return nil, err
+	}
+	s.resetFailedLogins(ctx, user.Email)
+
+	return user, nil
+}
+
+// checkTOTP validates a code against the user's secret and records the accepted time
+// step so the same code cannot be used twice
+func (s *UserService) checkTOTP(ctx context.Context, user *User, code string) error {
+	if user.TOTPSecret == "" {
+		return ErrTwoFactorNotSetUp
+	}
+
+	secret, err := s.secretCipher.decrypt(user.TOTPSecret)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt TOTP secret: %w", err)
+	}
+
+	step, ok := matchTOTP(secret, code, time.Now(), s.totpSkew)
+	if !ok || step <= user.TOTPLastStep {
+		return ErrInvalidTOTPCode
+	}
+
+	// Conditional update so concurrent requests can't both accept the same code
+	result, err := s.userCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "$or": []bson.M{
+			{"totp_last_step": bson.M{"$exists": false}},
+			{"totp_last_step": bson.M{"$lt": step}},
+		}},
+		bson.M{"$set": bson.M{"totp_last_step": step}})
+	if err != nil {
+		return fmt.Errorf("failed to record TOTP use: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidTOTPCode
+	}
+
+	return nil
+}
+
 // get user
 func (s *UserService) GetUserByID(ctx context.Context, userID primitive.ObjectID) (*User, error) {
 	var user User
@@ -108,22 +363,107 @@ func (s *UserService) GetUserByID(ctx context.Context, userID primitive.ObjectID
 	return &user, nil
 }
 
+// CountUsers returns the number of registered users
+func (s *UserService) CountUsers(ctx context.Context) (int64, error) {
+	return s.userCollection.CountDocuments(ctx, bson.M{})
+}
+
+// publicProfileFields are the only fields read for a public profile, so the email,
+// password hash and other private fields never are
+var publicProfileFields = bson.M{
+	"user_name":      1,
+	"avatar_path":    1,
+	"follower_count": 1,
+	"created_at":     1,
+}
+
+// GetPublicProfile returns the public profile of a user. The email, password hash and
+// other private fields are never read.
+func (s *UserService) GetPublicProfile(ctx context.Context, userID primitive.ObjectID) (*PublicUser, error) {
+	var user User
+	opts := options.FindOne().SetProjection(publicProfileFields)
+	if err := s.userCollection.FindOne(ctx, bson.M{"_id": userID}, opts).Decode(&user); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	profile := user.Public()
+	return &profile, nil
+}
+
+// GetUserByUsername returns the public profile of the user with the username, ignoring
+// case. An exact match wins over users whose names differ only in case.
+func (s *UserService) GetUserByUsername(ctx context.Context, username string) (*PublicUser, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, ErrUserNotFound
+	}
+
+	var user User
+	opts := options.FindOne().SetProjection(publicProfileFields)
+	err := s.userCollection.FindOne(ctx, bson.M{"user_name": username}, opts).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Oldest first, so the same user is picked every time
+		opts.SetCollation(userNameCollation).SetSort(bson.D{{Key: "_id", Value: 1}})
+		err = s.userCollection.FindOne(ctx, bson.M{"user_name": username}, opts).Decode(&user)
+	}
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	profile := user.Public()
+	return &profile, nil
+}
+
 // createIndexes creates unique indexes for email and username to prevent duplicates
 func (s *UserService) createIndexes() {
 	ctx := context.Background()
 	
 	// Create unique index for email
 	emailIndex := mongo.IndexModel{
-		Keys:    bson.D{{"email", 1}},
-		Options: options.Index().SetUnique(true),
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName(emailIndexName),
 	}
 	
 	// Create unique index for username
 	usernameIndex := mongo.IndexModel{
-		Keys:    bson.D{{"user_name", 1}},
-		Options: options.Index().SetUnique(true),
+		Keys:    bson.D{{Key: "user_name", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName(userNameIndexName),
 	}
 	
 	// Create the indexes (ignore errors as they might already exist)
 	s.userCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{emailIndex, usernameIndex})
+
+	// A user can follow another user only once
+	followIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "follower_id", Value: 1}, {Key: "target_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+
+	// Follower lists are read newest first
+	followersIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "target_id", Value: 1}, {Key: "created_at", Value: -1}},
+	}
+
+	s.followCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{followIndex, followersIndex})
+
+	// Failed login counts are dropped once they can no longer lead to or extend a lockout
+	s.loginAttemptCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+
+	// Refresh tokens are looked up by hash, sessions are listed per user, and expired
+	// sessions are dropped
+	s.sessionCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "previous_token_hash", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_used_at", Value: -1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
 }
@@ -1,19 +1,28 @@
 package users
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	"image/png"
 	"log"
 	"os"
 	"strings"
 	"testing"
 	"time"
 
+	"streamflow/internal/config"
 	"streamflow/internal/database"
+	"streamflow/internal/media"
+	"streamflow/internal/video"
 
 	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var testUserService *UserService
@@ -38,7 +47,7 @@ func TestMain(m *testing.M) {
 
 	// Initialize test database service
 	testDbService = database.New()
-	testUserService = NewUserService(testDbService.GetDatabase())
+	testUserService = NewUserService(testDbService.GetDatabase(), config.TwoFactorConfig{EncryptionKey: "test-totp-key", Skew: 1})
 
 	code := m.Run()
 
@@ -1286,6 +1295,61 @@ func TestUserService_AuthenticationBruteForce(t *testing.T) {
 	}
 }
 
+func TestUserService_LoginLockout(t *testing.T) {
+	ctx := context.Background()
+	const lockout = 500 * time.Millisecond
+	testUserService.SetLoginLockout(3, time.Minute, lockout)
+	defer testUserService.SetLoginLockout(0, 0, 0)
+
+	user, err := testUserService.CreateUser(ctx, CreateUserRequest{
+		UserName: "lockout_" + generateTestSuffix(),
+		Email:    "lockout_" + generateTestSuffix() + "@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	unknown := "nobody_" + generateTestSuffix() + "@example.com"
+	defer testUserService.loginAttemptCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": []string{user.Email, unknown}}})
+
+	// Registered and unknown emails lock the same way
+	for _, email := range []string{user.Email, unknown} {
+		for i := 0; i < 3; i++ {
+			if _, err := testUserService.AuthenticateUser(ctx, email, "wrongpass"); err == nil || err.Error() != "invalid credentials" {
+				t.Fatalf("AuthenticateUser(%s) attempt %d error = %v, want invalid credentials", email, i+1, err)
+			}
+		}
+		_, err := testUserService.AuthenticateUser(ctx, email, "password123")
+		var locked *LockedError
+		if !errors.Is(err, ErrAccountLocked) || !errors.As(err, &locked) {
+			t.Fatalf("AuthenticateUser(%s) after 3 failures error = %v, want ErrAccountLocked", email, err)
+		}
+		if locked.RetryAfter <= 0 || locked.RetryAfter > lockout {
+			t.Errorf("RetryAfter = %v, want up to %v", locked.RetryAfter, lockout)
+		}
+	}
+
+	// The lockout ends by itself
+	time.Sleep(lockout + 100*time.Millisecond)
+	if _, err := testUserService.AuthenticateUser(ctx, user.Email, "password123"); err != nil {
+		t.Fatalf("AuthenticateUser() after the lockout error = %v", err)
+	}
+
+	// A successful login starts the count over
+	for i := 0; i < 2; i++ {
+		testUserService.AuthenticateUser(ctx, user.Email, "wrongpass")
+	}
+	if _, err := testUserService.AuthenticateUser(ctx, user.Email, "password123"); err != nil {
+		t.Fatalf("AuthenticateUser() below the limit error = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		testUserService.AuthenticateUser(ctx, user.Email, "wrongpass")
+	}
+	if _, err := testUserService.AuthenticateUser(ctx, user.Email, "password123"); err != nil {
+		t.Errorf("AuthenticateUser() after a reset and 2 failures error = %v, want success", err)
+	}
+}
+
 // TestUserService_InputSanitization tests input sanitization
 func TestUserService_InputSanitization(t *testing.T) {
 	ctx := context.Background()
@@ -1971,3 +2035,786 @@ func TestUserService_SecurityHeaders(t *testing.T) {
 	})
 }
 
+
+// TestUserService_TwoFactor tests TOTP enrollment, 2FA login and code rejection
+func TestUserService_TwoFactor(t *testing.T) {
+	ctx := context.Background()
+
+	req := CreateUserRequest{
+		UserName: "totp_" + generateTestSuffix(),
+		Email:    "totp_" + generateTestSuffix() + "@example.com",
+		Password: "password123",
+	}
+	user, err := testUserService.CreateUser(ctx, req)
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	t.Run("code generation matches RFC 6238", func(t *testing.T) {
+		// Test vector from RFC 6238 appendix B (SHA1, T=59), truncated to 6 digits
+		secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+		code, err := totpCode(secret, totpStep(time.Unix(59, 0)))
+		if err != nil {
+			t.Fatalf("totpCode() unexpected error = %v", err)
+		}
+		if code != "287082" {
+			t.Errorf("totpCode() = %s, want 287082", code)
+		}
+	})
+
+	enrollment, err := testUserService.EnableTOTP(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("EnableTOTP() unexpected error = %v", err)
+	}
+	now := time.Now()
+
+	t.Run("enrollment confirmation", func(t *testing.T) {
+		if !strings.HasPrefix(enrollment.ProvisioningURI, "otpauth://totp/") ||
+			!strings.Contains(enrollment.ProvisioningURI, "secret="+enrollment.Secret) {
+			t.Errorf("Unexpected provisioning URI: %s", enrollment.ProvisioningURI)
+		}
+
+		stored, err := testUserService.GetUserByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if stored.TOTPSecret == "" || stored.TOTPSecret == enrollment.Secret {
+			t.Error("TOTP secret should be stored encrypted")
+		}
+		if stored.TwoFactorEnabled {
+			t.Error("2FA should not be enabled before the setup code is confirmed")
+		}
+
+		if err := testUserService.VerifyTOTPSetup(ctx, user.ID, "000000"); !errors.Is(err, ErrInvalidTOTPCode) {
+			t.Errorf("VerifyTOTPSetup() with wrong code error = %v, want ErrInvalidTOTPCode", err)
+		}
+
+		code, _ := totpCode(enrollment.Secret, totpStep(now))
+		if err := testUserService.VerifyTOTPSetup(ctx, user.ID, code); err != nil {
+			t.Fatalf("VerifyTOTPSetup() unexpected error = %v", err)
+		}
+
+		stored, err = testUserService.GetUserByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if !stored.TwoFactorEnabled {
+			t.Error("2FA should be enabled after confirming the setup code")
+		}
+	})
+
+	t.Run("successful 2FA login", func(t *testing.T) {
+		authed, err := testUserService.AuthenticateUser(ctx, req.Email, req.Password)
+		if !errors.Is(err, ErrTwoFactorRequired) {
+			t.Fatalf("AuthenticateUser() error = %v, want ErrTwoFactorRequired", err)
+		}
+
+		challenge, err := testJWTService.GenerateChallengeToken(authed.ID)
+		if err != nil {
+			t.Fatalf("GenerateChallengeToken() unexpected error = %v", err)
+		}
+		if _, err := testJWTService.verifyToken(challenge); err == nil {
+			t.Error("Challenge token must not be accepted as a session token")
+		}
+		challengeUserID, err := testJWTService.VerifyChallengeToken(challenge)
+		if err != nil || challengeUserID != user.ID {
+			t.Fatalf("VerifyChallengeToken() = %v, %v, want %v", challengeUserID, err, user.ID)
+		}
+
+		// The setup code's time step has been used, so log in with the next one (within skew)
+		code, _ := totpCode(enrollment.Secret, totpStep(now)+1)
+		loggedIn, err := testUserService.VerifyTwoFactorLogin(ctx, challengeUserID, code)
+		if err != nil {
+			t.Fatalf("VerifyTwoFactorLogin() unexpected error = %v", err)
+		}
+		if loggedIn.ID != user.ID {
+			t.Errorf("VerifyTwoFactorLogin() user = %v, want %v", loggedIn.ID, user.ID)
+		}
+	})
+
+	t.Run("wrong, reused and expired codes are rejected", func(t *testing.T) {
+		reused, _ := totpCode(enrollment.Secret, totpStep(now)+1)
+		expired, _ := totpCode(enrollment.Secret, totpStep(now.Add(-5*time.Minute)))
+
+		for name, code := range map[string]string{
+			"wrong":     "123456",
+			"reused":    reused,
+			"expired":   expired,
+			"malformed": "12ab",
+		} {
+			if _, err := testUserService.VerifyTwoFactorLogin(ctx, user.ID, code); !errors.Is(err, ErrInvalidTOTPCode) {
+				t.Errorf("VerifyTwoFactorLogin() with %s code error = %v, want ErrInvalidTOTPCode", name, err)
+			}
+		}
+	})
+
+	t.Run("wrong codes lead to a lockout", func(t *testing.T) {
+		testUserService.SetLoginLockout(3, time.Minute, time.Minute)
+		defer testUserService.SetLoginLockout(0, 0, 0)
+		defer testUserService.loginAttemptCollection.DeleteOne(ctx, bson.M{"_id": user.Email})
+
+		// A correct password alone doesn't reset the count
+		testUserService.AuthenticateUser(ctx, req.Email, "wrongpass")
+		if _, err := testUserService.AuthenticateUser(ctx, req.Email, req.Password); !errors.Is(err, ErrTwoFactorRequired) {
+			t.Fatalf("AuthenticateUser() error = %v, want ErrTwoFactorRequired", err)
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := testUserService.VerifyTwoFactorLogin(ctx, user.ID, "000000"); !errors.Is(err, ErrInvalidTOTPCode) {
+				t.Fatalf("VerifyTwoFactorLogin() attempt %d error = %v, want ErrInvalidTOTPCode", i+1, err)
+			}
+		}
+
+		code, _ := totpCode(enrollment.Secret, totpStep(time.Now()))
+		if _, err := testUserService.VerifyTwoFactorLogin(ctx, user.ID, code); !errors.Is(err, ErrAccountLocked) {
+			t.Errorf("VerifyTwoFactorLogin() after 3 failures error = %v, want ErrAccountLocked", err)
+		}
+		if _, err := testUserService.AuthenticateUser(ctx, req.Email, req.Password); !errors.Is(err, ErrAccountLocked) {
+			t.Errorf("AuthenticateUser() after 3 failures error = %v, want ErrAccountLocked", err)
+		}
+	})
+}
+
+func TestUserService_Follow(t *testing.T) {
+	ctx := context.Background()
+
+	newUser := func(prefix string) *User {
+		suffix := generateTestSuffix()
+		user, err := testUserService.CreateUser(ctx, CreateUserRequest{
+			UserName: prefix + "_" + suffix,
+			Email:    prefix + "_" + suffix + "@example.com",
+			Password: "password123",
+		})
+		if err != nil {
+			t.Fatalf("Failed to create test user: %v", err)
+		}
+		return user
+	}
+	alice := newUser("alice")
+	bob := newUser("bob")
+	carol := newUser("carol")
+
+	followerCount := func(userID primitive.ObjectID) int64 {
+		user, err := testUserService.GetUserByID(ctx, userID)
+		if err != nil {
+			t.Fatalf("GetUserByID() unexpected error = %v", err)
+		}
+		return user.FollowerCount
+	}
+
+	t.Run("rejects self-follows and unknown users", func(t *testing.T) {
+		if _, err := testUserService.FollowUser(ctx, alice.ID, alice.ID); !errors.Is(err, ErrCannotFollowSelf) {
+			t.Errorf("FollowUser() self error = %v, want ErrCannotFollowSelf", err)
+		}
+		if _, err := testUserService.FollowUser(ctx, alice.ID, primitive.NewObjectID()); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("FollowUser() unknown target error = %v, want ErrUserNotFound", err)
+		}
+	})
+
+	t.Run("following updates lists and counts", func(t *testing.T) {
+		if _, err := testUserService.FollowUser(ctx, alice.ID, carol.ID); err != nil {
+			t.Fatalf("FollowUser() unexpected error = %v", err)
+		}
+		// created_at is stored with millisecond precision
+		time.Sleep(2 * time.Millisecond)
+		if _, err := testUserService.FollowUser(ctx, bob.ID, carol.ID); err != nil {
+			t.Fatalf("FollowUser() unexpected error = %v", err)
+		}
+		if _, err := testUserService.FollowUser(ctx, alice.ID, carol.ID); !errors.Is(err, ErrAlreadyFollowing) {
+			t.Errorf("FollowUser() repeat error = %v, want ErrAlreadyFollowing", err)
+		}
+
+		if got := followerCount(carol.ID); got != 2 {
+			t.Errorf("FollowerCount = %d, want 2", got)
+		}
+
+		followers, err := testUserService.GetFollowers(ctx, carol.ID)
+		if err != nil {
+			t.Fatalf("GetFollowers() unexpected error = %v", err)
+		}
+		if len(followers) != 2 || followers[0].FollowerID != bob.ID {
+			t.Errorf("GetFollowers() = %v, want bob then alice", followers)
+		}
+
+		following, err := testUserService.GetFollowing(ctx, alice.ID)
+		if err != nil {
+			t.Fatalf("GetFollowing() unexpected error = %v", err)
+		}
+		if len(following) != 1 || following[0].TargetID != carol.ID {
+			t.Errorf("GetFollowing() = %v, want carol", following)
+		}
+
+		if ok, err := testUserService.IsFollowing(ctx, alice.ID, carol.ID); err != nil || !ok {
+			t.Errorf("IsFollowing(alice, carol) = %v, %v, want true", ok, err)
+		}
+		if ok, err := testUserService.IsFollowing(ctx, carol.ID, alice.ID); err != nil || ok {
+			t.Errorf("IsFollowing(carol, alice) = %v, %v, want false", ok, err)
+		}
+	})
+
+	t.Run("unfollowing updates lists and counts", func(t *testing.T) {
+		if err := testUserService.UnfollowUser(ctx, alice.ID, carol.ID); err != nil {
+			t.Fatalf("UnfollowUser() unexpected error = %v", err)
+		}
+		if err := testUserService.UnfollowUser(ctx, alice.ID, carol.ID); !errors.Is(err, ErrNotFollowing) {
+			t.Errorf("UnfollowUser() repeat error = %v, want ErrNotFollowing", err)
+		}
+
+		if got := followerCount(carol.ID); got != 1 {
+			t.Errorf("FollowerCount = %d, want 1", got)
+		}
+		if ok, _ := testUserService.IsFollowing(ctx, alice.ID, carol.ID); ok {
+			t.Error("IsFollowing() should be false after unfollowing")
+		}
+	})
+}
+
+func TestUserService_Avatar(t *testing.T) {
+	ctx := context.Background()
+	suffix := generateTestSuffix()
+	user, err := testUserService.CreateUser(ctx, CreateUserRequest{
+		UserName: "avatar_" + suffix,
+		Email:    "avatar_" + suffix + "@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	if _, err := testUserService.OpenAvatar(ctx, user.ID, false); !errors.Is(err, ErrAvatarsUnavailable) {
+		t.Errorf("OpenAvatar() without storage error = %v, want ErrAvatarsUnavailable", err)
+	}
+
+	storage, err := video.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	testUserService.SetAvatarStorage(storage)
+	defer testUserService.SetAvatarStorage(nil)
+
+	if _, err := testUserService.OpenAvatar(ctx, user.ID, false); !errors.Is(err, ErrNoAvatar) {
+		t.Errorf("OpenAvatar() before upload error = %v, want ErrNoAvatar", err)
+	}
+
+	encode := func(w, h int) []byte {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+			t.Fatalf("Failed to encode PNG: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	first := encode(512, 256)
+	updated, err := testUserService.UpdateAvatar(ctx, user.ID, first)
+	if err != nil {
+		t.Fatalf("UpdateAvatar() unexpected error = %v", err)
+	}
+	if updated.AvatarPath == "" || updated.AvatarThumbnailPath == "" {
+		t.Fatalf("UpdateAvatar() = %+v, want avatar paths set", updated)
+	}
+
+	original, err := testUserService.OpenAvatar(ctx, user.ID, false)
+	if err != nil || !bytes.Equal(original, first) {
+		t.Errorf("OpenAvatar() = %d bytes, %v, want the uploaded image", len(original), err)
+	}
+	thumbnail, err := testUserService.OpenAvatar(ctx, user.ID, true)
+	if err != nil {
+		t.Fatalf("OpenAvatar() thumbnail error = %v", err)
+	}
+	config, err := png.DecodeConfig(bytes.NewReader(thumbnail))
+	if err != nil || config.Width != AvatarThumbnailSize || config.Height != AvatarThumbnailSize/2 {
+		t.Errorf("Thumbnail = %+v, %v, want %dx%d", config, err, AvatarThumbnailSize, AvatarThumbnailSize/2)
+	}
+
+	t.Run("ReplacingRemovesOldFiles", func(t *testing.T) {
+		replaced, err := testUserService.UpdateAvatar(ctx, user.ID, encode(64, 64))
+		if err != nil {
+			t.Fatalf("UpdateAvatar() unexpected error = %v", err)
+		}
+		if replaced.AvatarPath == updated.AvatarPath {
+			t.Error("Replaced avatar should be stored under a new path")
+		}
+		for _, path := range []string{updated.AvatarPath, updated.AvatarThumbnailPath} {
+			if _, err := storage.Open(ctx, path); !errors.Is(err, video.ErrFileNotFound) {
+				t.Errorf("Old avatar file %s should be removed, got error = %v", path, err)
+			}
+		}
+	})
+
+	t.Run("RejectsNonImage", func(t *testing.T) {
+		_, err := testUserService.UpdateAvatar(ctx, user.ID, []byte("\x89PNG\r\n\x1a\nnot really"))
+		if !errors.Is(err, media.ErrInvalidImage) {
+			t.Errorf("UpdateAvatar() error = %v, want media.ErrInvalidImage", err)
+		}
+	})
+}
+
+func TestUserService_GetPublicProfile(t *testing.T) {
+	ctx := context.Background()
+	suffix := generateTestSuffix()
+	user, err := testUserService.CreateUser(ctx, CreateUserRequest{
+		UserName: "profile_" + suffix,
+		Email:    "profile_" + suffix + "@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	profile, err := testUserService.GetPublicProfile(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetPublicProfile() unexpected error = %v", err)
+	}
+	if profile.ID != user.ID || profile.UserName != user.UserName {
+		t.Errorf("GetPublicProfile() = %+v, want the profile of %s", profile, user.UserName)
+	}
+
+	if _, err := testUserService.GetPublicProfile(ctx, primitive.NewObjectID()); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("GetPublicProfile() unknown user error = %v, want ErrUserNotFound", err)
+	}
+
+	t.Run("NoPrivateFieldsInJSON", func(t *testing.T) {
+		for name, v := range map[string]interface{}{"User": user, "PublicUser": profile} {
+			data, err := json.Marshal(v)
+			if err != nil {
+				t.Fatalf("Failed to marshal %s: %v", name, err)
+			}
+			for _, secret := range []string{user.Email, user.Password, `"password"`, `"email"`} {
+				if strings.Contains(string(data), secret) {
+					t.Errorf("%s JSON %s contains %q", name, data, secret)
+				}
+			}
+		}
+
+		// The user sees their own email
+		data, err := json.Marshal(user.SanitizedUser())
+		if err != nil {
+			t.Fatalf("Failed to marshal account: %v", err)
+		}
+		if !strings.Contains(string(data), user.Email) || strings.Contains(string(data), user.Password) {
+			t.Errorf("SanitizedUser JSON %s should contain the email and not the password hash", data)
+		}
+	})
+}
+
+func TestUserService_GetUserByUsername(t *testing.T) {
+	ctx := context.Background()
+	suffix := generateTestSuffix()
+	user, err := testUserService.CreateUser(ctx, CreateUserRequest{
+		UserName: "Named_" + suffix,
+		Email:    "named_" + suffix + "@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	for _, name := range []string{user.UserName, strings.ToLower(user.UserName), strings.ToUpper(user.UserName), " " + user.UserName + " "} {
+		profile, err := testUserService.GetUserByUsername(ctx, name)
+		if err != nil {
+			t.Fatalf("GetUserByUsername(%q) unexpected error = %v", name, err)
+		}
+		if profile.ID != user.ID || profile.UserName != user.UserName {
+			t.Errorf("GetUserByUsername(%q) = %+v, want the profile of %s", name, profile, user.UserName)
+		}
+		data, err := json.Marshal(profile)
+		if err != nil {
+			t.Fatalf("Failed to marshal profile: %v", err)
+		}
+		if strings.Contains(string(data), user.Email) || strings.Contains(string(data), user.Password) {
+			t.Errorf("GetUserByUsername(%q) JSON %s leaks private fields", name, data)
+		}
+	}
+
+	for _, name := range []string{"", "missing_" + suffix} {
+		if _, err := testUserService.GetUserByUsername(ctx, name); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("GetUserByUsername(%q) error = %v, want ErrUserNotFound", name, err)
+		}
+	}
+
+	t.Run("ExactMatchWins", func(t *testing.T) {
+		// Registered before usernames were compared ignoring case
+		lower := User{
+			ID:        primitive.NewObjectID(),
+			Email:     "named_lower_" + suffix + "@example.com",
+			UserName:  strings.ToLower(user.UserName),
+			Role:      RoleUser,
+			CreatedAt: time.Now(),
+		}
+		if _, err := testUserService.userCollection.InsertOne(ctx, lower); err != nil {
+			t.Fatalf("Failed to insert user: %v", err)
+		}
+
+		for name, want := range map[string]primitive.ObjectID{user.UserName: user.ID, lower.UserName: lower.ID} {
+			profile, err := testUserService.GetUserByUsername(ctx, name)
+			if err != nil {
+				t.Fatalf("GetUserByUsername(%q) unexpected error = %v", name, err)
+			}
+			if profile.ID != want {
+				t.Errorf("GetUserByUsername(%q) = user %s, want %s", name, profile.ID.Hex(), want.Hex())
+			}
+		}
+	})
+}
+
+func TestUser_JSONOmitsSecrets(t *testing.T) {
+	user := &User{
+		ID:                  primitive.NewObjectID(),
+		Email:               "secret@example.com",
+		Password:            "$2a$10$hashedpasswordvalue",
+		UserName:            "json_user",
+		TOTPSecret:          "encrypted-totp-secret",
+		TOTPLastStep:        12345,
+		AvatarThumbnailPath: "avatar_thumb.png",
+	}
+	private := []string{`"password"`, user.Password, `"totp_secret"`, user.TOTPSecret, `"totp_last_step"`, user.AvatarThumbnailPath}
+
+	views := map[string]interface{}{
+		"User":          user,
+		"SanitizedUser": user.SanitizedUser(),
+		"PublicUser":    user.Public(),
+		"AuthResponse":  AuthResponse{Token: "token", User: user.SanitizedUser()},
+	}
+	for name, v := range views {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("Failed to marshal %s: %v", name, err)
+		}
+		for _, field := range private {
+			if strings.Contains(string(data), field) {
+				t.Errorf("%s JSON %s contains %q", name, data, field)
+			}
+		}
+	}
+}
+
+func TestUserService_DuplicateErrors(t *testing.T) {
+	ctx := context.Background()
+	suffix := generateTestSuffix()
+	existing, err := testUserService.CreateUser(ctx, CreateUserRequest{
+		UserName: "dup_" + suffix,
+		Email:    "dup_" + suffix + "@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		req     CreateUserRequest
+		wantErr error
+	}{
+		{"same email", CreateUserRequest{UserName: "other_" + suffix, Email: strings.ToUpper(existing.Email), Password: "password123"}, ErrDuplicateEmail},
+		{"same username", CreateUserRequest{UserName: existing.UserName, Email: "other_" + suffix + "@example.com", Password: "password123"}, ErrDuplicateUsername},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := testUserService.CreateUser(ctx, tt.req)
+			if !errors.Is(err, tt.wantErr) || !errors.Is(err, ErrUserExists) {
+				t.Errorf("CreateUser() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Inserts that get past the racy pre-check are told apart by the index that fired
+	t.Run("unique index", func(t *testing.T) {
+		insert := func(email, userName string) error {
+			_, err := testUserService.userCollection.InsertOne(ctx, User{
+				ID:       primitive.NewObjectID(),
+				Email:    email,
+				UserName: userName,
+			})
+			return err
+		}
+
+		err := insert(existing.Email, "index_"+suffix)
+		if got := duplicateUserError(err); !errors.Is(got, ErrDuplicateEmail) {
+			t.Errorf("duplicateUserError(%v) = %v, want ErrDuplicateEmail", err, got)
+		}
+		err = insert("index_"+suffix+"@example.com", existing.UserName)
+		if got := duplicateUserError(err); !errors.Is(got, ErrDuplicateUsername) {
+			t.Errorf("duplicateUserError(%v) = %v, want ErrDuplicateUsername", err, got)
+		}
+	})
+}
+
+func TestUserService_PasswordCostUpgrade(t *testing.T) {
+	ctx := context.Background()
+	testUserService.SetPasswordCost(bcrypt.MinCost)
+	defer testUserService.SetPasswordCost(bcrypt.DefaultCost)
+
+	user, err := testUserService.CreateUser(ctx, CreateUserRequest{
+		UserName: "rehash_" + generateTestSuffix(),
+		Email:    "rehash_" + generateTestSuffix() + "@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	defer testUserService.userCollection.DeleteOne(ctx, bson.M{"_id": user.ID})
+
+	storedCost := func() int {
+		var stored User
+		if err := testUserService.userCollection.FindOne(ctx, bson.M{"_id": user.ID}).Decode(&stored); err != nil {
+			t.Fatalf("Failed to read user: %v", err)
+		}
+		cost, err := bcrypt.Cost([]byte(stored.Password))
+		if err != nil {
+			t.Fatalf("Stored password is not a bcrypt hash: %v", err)
+		}
+		return cost
+	}
+	if cost := storedCost(); cost != bcrypt.MinCost {
+		t.Fatalf("Stored cost = %d, want %d", cost, bcrypt.MinCost)
+	}
+
+	// A failed login leaves the hash alone
+	testUserService.SetPasswordCost(bcrypt.MinCost + 1)
+	if _, err := testUserService.AuthenticateUser(ctx, user.Email, "wrongpass"); err == nil {
+		t.Fatal("AuthenticateUser with a wrong password succeeded")
+	}
+	if cost := storedCost(); cost != bcrypt.MinCost {
+		t.Fatalf("Stored cost after a failed login = %d, want %d", cost, bcrypt.MinCost)
+	}
+
+	if _, err := testUserService.AuthenticateUser(ctx, user.Email, "password123"); err != nil {
+		t.Fatalf("AuthenticateUser failed: %v", err)
+	}
+	if cost := storedCost(); cost != bcrypt.MinCost+1 {
+		t.Fatalf("Stored cost after login = %d, want %d", cost, bcrypt.MinCost+1)
+	}
+	// The upgraded hash still matches the password
+	if _, err := testUserService.AuthenticateUser(ctx, user.Email, "password123"); err != nil {
+		t.Fatalf("AuthenticateUser after the upgrade failed: %v", err)
+	}
+
+	// Lowering the cost doesn't downgrade existing hashes
+	testUserService.SetPasswordCost(bcrypt.MinCost)
+	if _, err := testUserService.AuthenticateUser(ctx, user.Email, "password123"); err != nil {
+		t.Fatalf("AuthenticateUser failed: %v", err)
+	}
+	if cost := storedCost(); cost != bcrypt.MinCost+1 {
+		t.Fatalf("Stored cost after lowering the setting = %d, want %d", cost, bcrypt.MinCost+1)
+	}
+}
+
+func TestUserService_Sessions(t *testing.T) {
+	ctx := context.Background()
+	user, err := testUserService.CreateUser(ctx, CreateUserRequest{
+		UserName: "sessions_" + generateTestSuffix(),
+		Email:    "sessions_" + generateTestSuffix() + "@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	defer testUserService.userCollection.DeleteOne(ctx, bson.M{"_id": user.ID})
+	defer testUserService.sessionCollection.DeleteMany(ctx, bson.M{"user_id": user.ID})
+
+	laptop := SessionClient{UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:128.0) Gecko/20100101 Firefox/128.0", IP: "10.0.0.1"}
+	phone := SessionClient{UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1", IP: "10.0.0.2"}
+
+	laptopToken, laptopSession, err := testUserService.CreateSession(ctx, user.ID, laptop)
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	phoneToken, phoneSession, err := testUserService.CreateSession(ctx, user.ID, phone)
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	if laptopToken == "" || laptopToken == phoneToken {
+		t.Fatalf("Refresh tokens should be non-empty and distinct, got %q and %q", laptopToken, phoneToken)
+	}
+	if laptopSession.Device != "Firefox on Windows" || phoneSession.Device != "Safari on iOS" {
+		t.Errorf("Devices = %q, %q, want Firefox on Windows, Safari on iOS", laptopSession.Device, phoneSession.Device)
+	}
+
+	// Only a hash of the token is stored
+	var stored Session
+	if err := testUserService.sessionCollection.FindOne(ctx, bson.M{"_id": laptopSession.ID}).Decode(&stored); err != nil {
+		t.Fatalf("Failed to read session: %v", err)
+	}
+	if stored.TokenHash == laptopToken || strings.Contains(stored.TokenHash, laptopToken) {
+		t.Error("Session should not store the refresh token itself")
+	}
+
+	t.Run("Refresh records the last use", func(t *testing.T) {
+		time.Sleep(10 * time.Millisecond)
+		moved := SessionClient{UserAgent: laptop.UserAgent, IP: "10.0.0.3"}
+		newToken, session, err := testUserService.RefreshSession(ctx, laptopToken, moved)
+		if err != nil {
+			t.Fatalf("RefreshSession failed: %v", err)
+		}
+		if newToken == "" || newToken == laptopToken {
+			t.Errorf("RefreshSession should replace the refresh token, got %q", newToken)
+		}
+		laptopToken = newToken
+		if session.ID != laptopSession.ID || session.UserID != user.ID {
+			t.Errorf("RefreshSession returned session %s of user %s, want %s of %s", session.ID.Hex(), session.UserID.Hex(), laptopSession.ID.Hex(), user.ID.Hex())
+		}
+		if !session.LastUsedAt.After(laptopSession.LastUsedAt) || session.IP != "10.0.0.3" {
+			t.Errorf("Refreshed session last used %v from %s, want after %v from 10.0.0.3", session.LastUsedAt, session.IP, laptopSession.LastUsedAt)
+		}
+
+		if _, _, err := testUserService.RefreshSession(ctx, "not-a-token", moved); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("RefreshSession with an unknown token error = %v, want ErrInvalidRefreshToken", err)
+		}
+	})
+
+	t.Run("List is most recently used first", func(t *testing.T) {
+		sessions, err := testUserService.ListSessions(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("ListSessions failed: %v", err)
+		}
+		if len(sessions) != 2 || sessions[0].ID != laptopSession.ID || sessions[1].ID != phoneSession.ID {
+			t.Fatalf("ListSessions = %v, want the laptop then the phone session", sessions)
+		}
+	})
+
+	t.Run("Revoking one session keeps the others", func(t *testing.T) {
+		other := primitive.NewObjectID()
+		if err := testUserService.RevokeSession(ctx, other, phoneSession.ID); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("RevokeSession by another user error = %v, want ErrSessionNotFound", err)
+		}
+
+		if err := testUserService.RevokeSession(ctx, user.ID, phoneSession.ID); err != nil {
+			t.Fatalf("RevokeSession failed: %v", err)
+		}
+		if _, _, err := testUserService.RefreshSession(ctx, phoneToken, phone); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("RefreshSession of a revoked session error = %v, want ErrInvalidRefreshToken", err)
+		}
+		if active, err := testUserService.SessionActive(ctx, user.ID, phoneSession.ID); err != nil || active {
+			t.Errorf("SessionActive of a revoked session = %v, %v, want false", active, err)
+		}
+		newToken, _, err := testUserService.RefreshSession(ctx, laptopToken, laptop)
+		if err != nil {
+			t.Fatalf("RefreshSession of the other session failed: %v", err)
+		}
+		laptopToken = newToken
+		if active, err := testUserService.SessionActive(ctx, user.ID, laptopSession.ID); err != nil || !active {
+			t.Errorf("SessionActive of the other session = %v, %v, want true", active, err)
+		}
+		if err := testUserService.RevokeSession(ctx, user.ID, phoneSession.ID); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("Revoking twice error = %v, want ErrSessionNotFound", err)
+		}
+
+		sessions, err := testUserService.ListSessions(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("ListSessions failed: %v", err)
+		}
+		if len(sessions) != 1 || sessions[0].ID != laptopSession.ID {
+			t.Errorf("ListSessions after revoking = %v, want only the laptop session", sessions)
+		}
+	})
+
+	t.Run("Expired sessions can't be refreshed", func(t *testing.T) {
+		testUserService.SetRefreshTokenTTL(time.Millisecond)
+		defer testUserService.SetRefreshTokenTTL(DefaultRefreshTokenTTL)
+
+		token, _, err := testUserService.CreateSession(ctx, user.ID, laptop)
+		if err != nil {
+			t.Fatalf("CreateSession failed: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		if _, _, err := testUserService.RefreshSession(ctx, token, laptop); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("RefreshSession of an expired session error = %v, want ErrInvalidRefreshToken", err)
+		}
+		sessions, err := testUserService.ListSessions(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("ListSessions failed: %v", err)
+		}
+		if len(sessions) != 1 {
+			t.Errorf("ListSessions returned %d sessions, want the expired one left out", len(sessions))
+		}
+	})
+
+	t.Run("Reusing an exchanged token revokes the session", func(t *testing.T) {
+		stolen := laptopToken
+		newToken, _, err := testUserService.RefreshSession(ctx, stolen, laptop)
+		if err != nil {
+			t.Fatalf("RefreshSession failed: %v", err)
+		}
+		if _, _, err := testUserService.RefreshSession(ctx, stolen, laptop); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Fatalf("RefreshSession with an exchanged token error = %v, want ErrInvalidRefreshToken", err)
+		}
+		// Neither holder keeps the session
+		if _, _, err := testUserService.RefreshSession(ctx, newToken, laptop); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("RefreshSession after a reuse error = %v, want ErrInvalidRefreshToken", err)
+		}
+		if active, err := testUserService.SessionActive(ctx, user.ID, laptopSession.ID); err != nil || active {
+			t.Errorf("SessionActive after a reuse = %v, %v, want false", active, err)
+		}
+	})
+}
+
+func TestJWTService_SessionTokens(t *testing.T) {
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+	defer testUserService.sessionCollection.DeleteMany(ctx, bson.M{"user_id": userID})
+
+	jwtService := NewJWTService("test-secret-key-for-testing-purposes")
+	jwtService.SetSessionStore(testUserService)
+
+	_, session, err := testUserService.CreateSession(ctx, userID, SessionClient{IP: "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	token, err := jwtService.GenerateSessionToken(userID, session.ID)
+	if err != nil {
+		t.Fatalf("GenerateSessionToken failed: %v", err)
+	}
+	unbound, err := jwtService.GenerateToken(userID)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+
+	if got, err := jwtService.VerifySessionToken(token); err != nil || got != userID {
+		t.Fatalf("VerifySessionToken() = %s, %v, want %s", got.Hex(), err, userID.Hex())
+	}
+
+	if err := testUserService.RevokeSession(ctx, userID, session.ID); err != nil {
+		t.Fatalf("RevokeSession failed: %v", err)
+	}
+	if _, err := jwtService.VerifySessionToken(token); err == nil {
+		t.Error("VerifySessionToken() should reject the token of a revoked session")
+	}
+	// Tokens not tied to a session are only limited by their expiry
+	if _, err := jwtService.VerifySessionToken(unbound); err != nil {
+		t.Errorf("VerifySessionToken() of a token without a session error = %v", err)
+	}
+
+	jwtService.SetTokenTTL(time.Minute)
+	short, err := jwtService.GenerateToken(userID)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	claims, err := jwtService.verifyToken(short)
+	if err != nil {
+		t.Fatalf("verifyToken failed: %v", err)
+	}
+	if ttl := time.Until(claims.ExpiresAt.Time); ttl > time.Minute || ttl < 50*time.Second {
+		t.Errorf("Token expires in %v, want about a minute", ttl)
+	}
+}
+
+func TestDescribeDevice(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36 Edg/126.0.0.0", "Edge on Windows"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36", "Chrome on macOS"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Safari/605.1.15", "Safari on macOS"},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0", "Firefox on Linux"},
+		{"Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36", "Chrome on ChromeOS"},
+		{"curl/8.5.0", "curl"},
+		{"StreamFlowTV/1.0", "Unknown device"},
+		{"", "Unknown device"},
+	}
+	for _, tt := range tests {
+		if got := describeDevice(tt.userAgent); got != tt.want {
+			t.Errorf("describeDevice(%q) = %q, want %q", tt.userAgent, got, tt.want)
+		}
+	}
+}
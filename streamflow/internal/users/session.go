@@ -0,0 +1,270 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"streamflow/internal/logger"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// DefaultRefreshTokenTTL is how long a refresh token is valid unless set otherwise
+	// with SetRefreshTokenTTL
+	DefaultRefreshTokenTTL = 7 * 24 * time.Hour
+	// refreshTokenSize is the number of random bytes in a refresh token
+	refreshTokenSize = 32
+	// maxUserAgentLength caps the user agent kept for a session
+	maxUserAgentLength = 512
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrInvalidRefreshToken is returned for a refresh token that is unknown, expired,
+	// already exchanged or belongs to a revoked session
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+)
+
+// Session is a login of a user on one device, kept alive by its refresh token. Only a
+// hash of the token is stored. The token is replaced each time it is used.
+type Session struct {
+	ID                primitive.ObjectID `bson:"_id" json:"id"`
+	UserID            primitive.ObjectID `bson:"user_id" json:"-"`
+	TokenHash         string             `bson:"token_hash" json:"-"`
+	PreviousTokenHash string             `bson:"previous_token_hash,omitempty" json:"-"` // Of the token last exchanged, to detect its reuse
+	Current           bool               `bson:"-" json:"current"`                       // Whether the listing request was made with this session
+	Device            string             `bson:"device" json:"device"`                   // Browser and OS guessed from the user agent
+	UserAgent         string             `bson:"user_agent" json:"user_agent"`
+	IP                string             `bson:"ip" json:"ip"` // Of the last login or refresh
+	CreatedAt         time.Time          `bson:"created_at" json:"created_at"`
+	LastUsedAt        time.Time          `bson:"last_used_at" json:"last_used_at"`
+	ExpiresAt         time.Time          `bson:"expires_at" json:"expires_at"`
+}
+
+// SessionClient describes the client a session is used from
+type SessionClient struct {
+	UserAgent string
+	IP        string
+}
+
+// SetRefreshTokenTTL sets how long refresh tokens are valid. It doesn't change the
+// expiry of sessions already created.
+func (s *UserService) SetRefreshTokenTTL(ttl time.Duration) {
+	if ttl > 0 {
+		s.refreshTokenTTL = ttl
+	}
+}
+
+// CreateSession starts a session for a user who just logged in and returns its refresh
+// token. The token is only ever returned here.
+func (s *UserService) CreateSession(ctx context.Context, userID primitive.ObjectID, client SessionClient) (string, *Session, error) {
+	token, err := generateRefreshToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	userAgent := truncateUserAgent(client.UserAgent)
+	session := &Session{
+		ID:         primitive.NewObjectID(),
+		UserID:     userID,
+		TokenHash:  hashRefreshToken(token),
+		Device:     describeDevice(userAgent),
+		UserAgent:  userAgent,
+		IP:         client.IP,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(s.refreshTokenTTL),
+	}
+	if _, err := s.sessionCollection.InsertOne(ctx, session); err != nil {
+		return "", nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	return token, session, nil
+}
+
+// RefreshSession exchanges a refresh token for a new one, recording that the session was
+// just used by client, and returns the new token and the session. Each token can be
+// exchanged once: presenting one that was already exchanged means it was copied, so the
+// session is revoked. ErrInvalidRefreshToken is returned if the token is unknown, expired,
+// already exchanged or was revoked.
+func (s *UserService) RefreshSession(ctx context.Context, token string, client SessionClient) (string, *Session, error) {
+	newToken, err := generateRefreshToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	tokenHash := hashRefreshToken(token)
+	filter := bson.M{"token_hash": tokenHash, "expires_at": bson.M{"$gt": now}}
+	update := bson.M{"$set": bson.M{
+		"token_hash":          hashRefreshToken(newToken),
+		"previous_token_hash": tokenHash,
+		"last_used_at":        now,
+		"ip":                  client.IP,
+		"user_agent":          truncateUserAgent(client.UserAgent),
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var session Session
+	err = s.sessionCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&session)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		s.revokeReusedToken(ctx, tokenHash)
+		return "", nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to refresh session: %w", err)
+	}
+	return newToken, &session, nil
+}
+
+// revokeReusedToken revokes the session a refresh token was exchanged for, if any. Either
+// the client or whoever copied the token already holds its replacement, and there is no
+// telling which, so neither may keep the session.
+func (s *UserService) revokeReusedToken(ctx context.Context, tokenHash string) {
+	result, err := s.sessionCollection.DeleteOne(ctx, bson.M{"previous_token_hash": tokenHash})
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to revoke session of a reused refresh token", "error", err)
+		return
+	}
+	if result.DeletedCount > 0 {
+		logger.FromContext(ctx).Warn("revoked a session after its refresh token was reused")
+	}
+}
+
+// RevokeRefreshToken revokes the session of a refresh token, as when its client logs
+// out. An unknown token is ignored.
+func (s *UserService) RevokeRefreshToken(ctx context.Context, token string) error {
+	if _, err := s.sessionCollection.DeleteOne(ctx, bson.M{"token_hash": hashRefreshToken(token)}); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return nil
+}
+
+// SessionActive reports whether a session of the user exists and hasn't expired. It
+// implements SessionStore.
+func (s *UserService) SessionActive(ctx context.Context, userID, sessionID primitive.ObjectID) (bool, error) {
+	filter := bson.M{"_id": sessionID, "user_id": userID, "expires_at": bson.M{"$gt": time.Now()}}
+	count, err := s.sessionCollection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, fmt.Errorf("failed to look up session: %w", err)
+	}
+	return count > 0, nil
+}
+
+// ListSessions returns the unexpired sessions of a user, most recently used first
+func (s *UserService) ListSessions(ctx context.Context, userID primitive.ObjectID) ([]*Session, error) {
+	filter := bson.M{"user_id": userID, "expires_at": bson.M{"$gt": time.Now()}}
+	opts := options.Find().SetSort(bson.D{{Key: "last_used_at", Value: -1}, {Key: "_id", Value: -1}})
+	cursor, err := s.sessionCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []*Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, fmt.Errorf("failed to decode sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one session of a user, so neither its refresh token nor the session
+// tokens issued for it can be used any more. The user's other sessions are left alone.
+// ErrSessionNotFound is returned if the user has no such session.
+func (s *UserService) RevokeSession(ctx context.Context, userID, sessionID primitive.ObjectID) error {
+	result, err := s.sessionCollection.DeleteOne(ctx, bson.M{"_id": sessionID, "user_id": userID})
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// generateRefreshToken returns refreshTokenSize random bytes from crypto/rand, base64url
+// encoded
+func generateRefreshToken() (string, error) {
+	token := make([]byte, refreshTokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// hashRefreshToken returns the hash a refresh token is stored and looked up by. The token
+// is random, so a plain SHA-256 is enough.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// truncateUserAgent caps a user agent at maxUserAgentLength bytes
+func truncateUserAgent(userAgent string) string {
+	if len(userAgent) <= maxUserAgentLength {
+		return userAgent
+	}
+	return strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+}
+
+// describeDevice names the browser and operating system of a user agent for a session
+// list, such as "Firefox on Windows". Parts it doesn't recognize are left out, and an
+// unrecognized user agent is described as "Unknown device".
+func describeDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	// Checked in order, as browsers mention the ones they are derived from
+	var browser string
+	for _, b := range []struct{ token, name string }{
+		{"edg/", "Edge"},
+		{"opr/", "Opera"},
+		{"firefox/", "Firefox"},
+		{"chrome/", "Chrome"},
+		{"crios/", "Chrome"},
+		{"safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	// iOS and Android user agents also mention Mac OS X and Linux
+	var system string
+	for _, o := range []struct{ token, name string }{
+		{"iphone", "iOS"},
+		{"ipad", "iPadOS"},
+		{"android", "Android"},
+		{"windows", "Windows"},
+		{"mac os x", "macOS"},
+		{"cros ", "ChromeOS"},
+		{"linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			system = o.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	}
+	return "Unknown device"
+}
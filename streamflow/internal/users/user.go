@@ -6,13 +6,80 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
 	ID primitive.ObjectID `bson:"_id" json:"id"`
-	Email string `bson:"email" json:"email"`
+	Email string `bson:"email" json:"-"` // Only shown to the user, see SanitizedUser
 	Password string `bson:"password" json:"-"`
 	CreatedAt time.Time `bson:"created_at" json:"created_at"`
 	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
 	UserName string `bson:"user_name" json:"user_name"`
+	Role string `bson:"role" json:"role"`
+	TwoFactorEnabled bool `bson:"two_factor_enabled" json:"two_factor_enabled"`
+	TOTPSecret string `bson:"totp_secret,omitempty" json:"-"` // Encrypted
+	TOTPLastStep int64 `bson:"totp_last_step,omitempty" json:"-"` // Last accepted time step, prevents code reuse
+	FollowerCount int64 `bson:"follower_count" json:"follower_count"` // Denormalized from the follows collection
+	AvatarPath string `bson:"avatar_path,omitempty" json:"avatar_path,omitempty"` // Storage key of the uploaded image
+	AvatarThumbnailPath string `bson:"avatar_thumbnail_path,omitempty" json:"-"`
+}
+
+// IsAdmin reports whether the user has the admin role
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// SanitizedUser is the user as shown to themselves. Fields are listed one by one rather
+// than copied from User, so a field added to User stays private until it is added here.
+type SanitizedUser struct {
+	ID               primitive.ObjectID `json:"id"`
+	Email            string             `json:"email"`
+	UserName         string             `json:"user_name"`
+	Role             string             `json:"role"`
+	TwoFactorEnabled bool               `json:"two_factor_enabled"`
+	FollowerCount    int64              `json:"follower_count"`
+	AvatarPath       string             `json:"avatar_path,omitempty"`
+	CreatedAt        time.Time          `json:"created_at"`
+	UpdatedAt        time.Time          `json:"updated_at"`
+}
+
+// SanitizedUser returns the user without the password hash and other secrets. Handlers
+// returning a user to themselves send this rather than the User.
+func (u *User) SanitizedUser() SanitizedUser {
+	return SanitizedUser{
+		ID:               u.ID,
+		Email:            u.Email,
+		UserName:         u.UserName,
+		Role:             u.Role,
+		TwoFactorEnabled: u.TwoFactorEnabled,
+		FollowerCount:    u.FollowerCount,
+		AvatarPath:       u.AvatarPath,
+		CreatedAt:        u.CreatedAt,
+		UpdatedAt:        u.UpdatedAt,
+	}
+}
+
+// PublicUser is the profile of a user anyone can see
+type PublicUser struct {
+	ID            primitive.ObjectID `json:"id"`
+	UserName      string             `json:"user_name"`
+	AvatarPath    string             `json:"avatar_path,omitempty"`
+	FollowerCount int64              `json:"follower_count"`
+	CreatedAt     time.Time          `json:"created_at"`
+}
+
+// Public returns the public profile of the user
+func (u *User) Public() PublicUser {
+	return PublicUser{
+		ID:            u.ID,
+		UserName:      u.UserName,
+		AvatarPath:    u.AvatarPath,
+		FollowerCount: u.FollowerCount,
+		CreatedAt:     u.CreatedAt,
+	}
 }
 
 type CreateUserRequest struct {
@@ -27,7 +94,22 @@ type LoginUserRequest struct {
 
 }
 
+type TOTPCodeRequest struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+// RefreshTokenRequest exchanges the refresh token of a session for a new session token
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
 type AuthResponse struct {
 	Token string `json:"token"`
-	User User `json:"user"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	User SanitizedUser `json:"user"`
 }
\ No newline at end of file
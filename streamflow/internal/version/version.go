@@ -0,0 +1,49 @@
+// Package version reports the build the server is running. The values are set at build
+// time with -ldflags, e.g.
+//
+//	go build -ldflags "-X streamflow/internal/version.Version=v1.2.0" ./cmd/api
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build info. Without a commit from -ldflags, the VCS revision Go
+// embeds in binaries built from a checkout is used.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+
+	if info.Commit == "" || info.BuildTime == "" {
+		if build, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range build.Settings {
+				switch {
+				case setting.Key == "vcs.revision" && info.Commit == "":
+					info.Commit = setting.Value
+				case setting.Key == "vcs.time" && info.BuildTime == "":
+					info.BuildTime = setting.Value
+				}
+			}
+		}
+	}
+	return info
+}
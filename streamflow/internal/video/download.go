@@ -0,0 +1,108 @@
+package video
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxDownloadNameLength caps the length of a download's filename, without its extension
+const maxDownloadNameLength = 100
+
+// Download describes how a video's original file is saved by clients
+type Download struct {
+	Filename    string
+	ContentType string
+}
+
+// Disposition returns the Content-Disposition header telling clients to save the file
+// as Filename
+func (d Download) Disposition() string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": d.Filename})
+}
+
+// DownloadOf returns the download of a video's original file. The filename is derived
+// from the title and the content type from the container and codecs ffprobe found.
+func DownloadOf(video *Video) Download {
+	contentType, ext := downloadContentType(video.Metadata)
+	return Download{
+		Filename:    downloadName(video) + ext,
+		ContentType: contentType,
+	}
+}
+
+// DownloadURL returns a URL clients can download the original upload from directly,
+// saving it under the download's filename, or "" if it has to be served through the API
+func (s *VideoService) DownloadURL(ctx context.Context, video *Video, download Download) (string, error) {
+	return s.storage.DownloadURL(ctx, video.FilePath, download.Disposition(), download.ContentType)
+}
+
+// RecordDownload counts a download of the video. Downloads are counted apart from views.
+func (s *VideoService) RecordDownload(ctx context.Context, videoID primitive.ObjectID) error {
+	result, err := s.videoCollection.UpdateOne(ctx, bson.M{"_id": videoID}, bson.M{"$inc": bson.M{"download_count": 1}})
+	if err != nil {
+		return fmt.Errorf("failed to record download: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// downloadContentType picks the MIME type and file extension of the original upload.
+// Videos probed before the container was recorded are guessed from their video codec.
+func downloadContentType(metadata VideoMetadata) (string, string) {
+	container := strings.ToLower(metadata.Container)
+	codec := strings.ToLower(metadata.Codec)
+	webCodec := codec == "vp8" || codec == "vp9" || codec == "av1"
+	switch {
+	case strings.Contains(container, "webm") && webCodec:
+		return "video/webm", ".webm"
+	case strings.Contains(container, "matroska"):
+		return "video/x-matroska", ".mkv"
+	case strings.Contains(container, "avi"):
+		return "video/x-msvideo", ".avi"
+	case strings.Contains(container, "mp4"), strings.Contains(container, "mov"):
+		return "video/mp4", ".mp4"
+	case container == "" && (codec == "vp8" || codec == "vp9"):
+		return "video/webm", ".webm"
+	case container == "":
+		return "video/mp4", ".mp4"
+	}
+	return "application/octet-stream", ""
+}
+
+// downloadName turns the title into a filename safe to put in a header on any platform:
+// letters and digits are kept, anything else becomes a single dash. Untitled videos are
+// named after their ID.
+func downloadName(video *Video) string {
+	var name strings.Builder
+	dash := false
+	for _, r := range video.Title {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && name.Len() > 0 {
+				name.WriteByte('-')
+			}
+			dash = false
+			name.WriteRune(r)
+			continue
+		}
+		dash = true
+	}
+
+	filename := name.String()
+	for len(filename) > maxDownloadNameLength {
+		_, size := utf8.DecodeLastRuneInString(filename)
+		filename = strings.TrimRight(filename[:len(filename)-size], "-")
+	}
+	if filename == "" {
+		return "video-" + video.ID.Hex()
+	}
+	return filename
+}
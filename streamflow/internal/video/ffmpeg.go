@@ -0,0 +1,452 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"streamflow/internal/config"
+)
+
+// ErrFFprobeUnavailable is returned when the ffprobe binary cannot be found
+var ErrFFprobeUnavailable = errors.New("ffprobe is not installed or not in PATH")
+
+// HLSMasterPlaylist is the name of the master playlist written by TranscodeToHLS
+const HLSMasterPlaylist = "playlist.m3u8"
+
+// HLSRendition is one rung of the adaptive bitrate ladder
+type HLSRendition struct {
+	Name         string // Used for the variant playlist and segment file names
+	Height       int
+	VideoBitrate string
+	AudioBitrate string
+	Bandwidth    int // Peak bits per second advertised in the master playlist
+}
+
+// DefaultHLSLadder is the rendition ladder produced for uploaded videos
+var DefaultHLSLadder = []HLSRendition{
+	{Name: "480p", Height: 480, VideoBitrate: "1400k", AudioBitrate: "128k", Bandwidth: 1528000},
+	{Name: "720p", Height: 720, VideoBitrate: "2800k", AudioBitrate: "128k", Bandwidth: 2928000},
+	{Name: "1080p", Height: 1080, VideoBitrate: "5000k", AudioBitrate: "192k", Bandwidth: 5192000},
+}
+
+// NewHLSLadder builds a rendition ladder from configured transcode profiles, ordered from
+// the lowest rung up. Audio is encoded at 192k from 1080p up and at 128k below.
+func NewHLSLadder(profiles []config.TranscodeProfile) []HLSRendition {
+	ladder := make([]HLSRendition, 0, len(profiles))
+	for _, profile := range profiles {
+		audioKbps := 128
+		if profile.Height >= 1080 {
+			audioKbps = 192
+		}
+		ladder = append(ladder, HLSRendition{
+			Name:         profile.Name,
+			Height:       profile.Height,
+			VideoBitrate: fmt.Sprintf("%dk", profile.Bitrate),
+			AudioBitrate: fmt.Sprintf("%dk", audioKbps),
+			Bandwidth:    (profile.Bitrate + audioKbps) * 1000,
+		})
+	}
+	sort.SliceStable(ladder, func(i, j int) bool { return ladder[i].Height < ladder[j].Height })
+	return ladder
+}
+
+// hlsSegmentSeconds is the target duration of each HLS segment
+const hlsSegmentSeconds = 6
+
+// FFmpegService handles FFmpeg operations for uploaded videos
+type FFmpegService struct {
+	ffmpegPath  string
+	ffprobePath string
+	ladder      []HLSRendition
+}
+
+// NewFFmpegService creates a new FFmpeg service transcoding to DefaultHLSLadder
+func NewFFmpegService() *FFmpegService {
+	return &FFmpegService{
+		ffmpegPath:  "ffmpeg",  // Assumes ffmpeg is in PATH
+		ffprobePath: "ffprobe", // Ships alongside ffmpeg
+		ladder:      DefaultHLSLadder,
+	}
+}
+
+// ProbeMetadata reads the duration, resolution, codecs, bitrate and frame rate of a
+// media file with ffprobe. ErrFFprobeUnavailable is returned if ffprobe is missing.
+func (f *FFmpegService) ProbeMetadata(ctx context.Context, path string) (*VideoMetadata, error) {
+	cmd := exec.CommandContext(ctx, f.ffprobePath,
+		"-v", "quiet",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		path,
+	)
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, ErrFFprobeUnavailable
+		}
+		return nil, fmt.Errorf("failed to extract metadata: %w", err)
+	}
+
+	metadata, err := parseProbeOutput(out.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	if fileInfo, err := os.Stat(path); err == nil {
+		metadata.FileSize = fileInfo.Size()
+	}
+
+	return metadata, nil
+}
+
+// probeOutput is the subset of ffprobe's JSON output that we use
+type probeOutput struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType  string `json:"codec_type"`
+		CodecName  string `json:"codec_name"`
+		Width      int    `json:"width,omitempty"`
+		Height     int    `json:"height,omitempty"`
+		Duration   string `json:"duration,omitempty"`
+		BitRate    string `json:"bit_rate,omitempty"`
+		RFrameRate string `json:"r_frame_rate,omitempty"`
+	} `json:"streams"`
+}
+
+// parseProbeOutput converts ffprobe JSON into VideoMetadata. Container values are
+// preferred, falling back to the video stream when the container omits them.
+func parseProbeOutput(data []byte) (*VideoMetadata, error) {
+	var result probeOutput
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	metadata := &VideoMetadata{Container: result.Format.FormatName}
+	duration, _ := strconv.ParseFloat(result.Format.Duration, 64)
+	bitrate, _ := strconv.Atoi(result.Format.BitRate)
+
+	for _, stream := range result.Streams {
+		switch stream.CodecType {
+		case "video":
+			// Only the first video stream describes the video
+			if metadata.Codec != "" {
+				continue
+			}
+			metadata.Width = stream.Width
+			metadata.Height = stream.Height
+			metadata.Codec = stream.CodecName
+			metadata.FrameRate = parseFrameRate(stream.RFrameRate)
+			if duration == 0 {
+				duration, _ = strconv.ParseFloat(stream.Duration, 64)
+			}
+			if bitrate == 0 {
+				bitrate, _ = strconv.Atoi(stream.BitRate)
+			}
+		case "audio":
+			if metadata.AudioCodec == "" {
+				metadata.AudioCodec = stream.CodecName
+			}
+		}
+	}
+
+	metadata.Duration = duration
+	metadata.Bitrate = bitrate / 1000 // Convert to kbps
+
+	return metadata, nil
+}
+
+// parseFrameRate parses an ffprobe rational frame rate such as "30000/1001"
+func parseFrameRate(rate string) float64 {
+	numStr, denStr, ok := strings.Cut(rate, "/")
+	if !ok {
+		fps, _ := strconv.ParseFloat(rate, 64)
+		return fps
+	}
+	num, err := strconv.ParseFloat(numStr, 64)
+	if err != nil {
+		return 0
+	}
+	den, err := strconv.ParseFloat(denStr, 64)
+	if err != nil || den == 0 {
+		return 0
+	}
+	return num / den
+}
+
+// ProgressFunc receives the percentage of a transcode that is done
+type ProgressFunc func(percent int)
+
+// TranscodeToHLS transcodes the input into outputDir as an HLS master playlist with one
+// variant per ladder rung, skipping rungs taller than the source; see renditionsFor.
+// Variants are written as <name>.m3u8 with <name>_NNN.ts segments. The renditions produced
+// are returned. The output directory is removed if transcoding fails.
+func (f *FFmpegService) TranscodeToHLS(ctx context.Context, inputPath, outputDir string, sourceHeight int) ([]HLSRendition, error) {
+	return f.TranscodeToHLSWithProgress(ctx, inputPath, outputDir, sourceHeight, 0, nil)
+}
+
+// TranscodeToHLSWithProgress is TranscodeToHLS reporting its progress to progress, which
+// may be nil. Percentages are computed from the input's duration in seconds and only ever
+// increase; 100 is reported once the master playlist is written. Without a duration only
+// finished renditions are reported.
+func (f *FFmpegService) TranscodeToHLSWithProgress(ctx context.Context, inputPath, outputDir string, sourceHeight int, duration float64, progress ProgressFunc) (renditions []HLSRendition, err error) {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// Don't leave partial output behind
+	defer func() {
+		if err != nil {
+			os.RemoveAll(outputDir)
+		}
+	}()
+
+	tracker := &progressTracker{report: progress, last: -1}
+	tracker.set(0)
+	renditions = f.renditionsFor(sourceHeight)
+	for i, rendition := range renditions {
+		// Each rendition is an equal share of the work; the last percent waits for the master playlist
+		parser := &progressParser{duration: duration, report: func(done float64) {
+			tracker.set(min(int((float64(i)+done)/float64(len(renditions))*100), 99))
+		}}
+		if err := f.transcodeRendition(ctx, inputPath, outputDir, rendition, parser); err != nil {
+			return nil, err
+		}
+	}
+
+	masterPath := filepath.Join(outputDir, HLSMasterPlaylist)
+	if err := os.WriteFile(masterPath, []byte(buildMasterPlaylist(renditions)), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write master playlist: %w", err)
+	}
+	tracker.set(100)
+
+	return renditions, nil
+}
+
+// renditionsFor returns the rungs of the ladder no taller than the source, as upscaling
+// only wastes bandwidth. A source smaller than every rung gets the lowest one, and one of
+// unknown height, zero, gets them all.
+func (f *FFmpegService) renditionsFor(sourceHeight int) []HLSRendition {
+	if sourceHeight <= 0 {
+		return f.ladder
+	}
+
+	var renditions []HLSRendition
+	lowest := f.ladder[0]
+	for _, rendition := range f.ladder {
+		if rendition.Height <= sourceHeight {
+			renditions = append(renditions, rendition)
+		}
+		if rendition.Height < lowest.Height {
+			lowest = rendition
+		}
+	}
+	if len(renditions) == 0 {
+		renditions = []HLSRendition{lowest}
+	}
+	return renditions
+}
+
+// transcodeRendition produces the variant playlist and segments for a single rung,
+// feeding ffmpeg's progress output to progress
+func (f *FFmpegService) transcodeRendition(ctx context.Context, inputPath, outputDir string, rendition HLSRendition, progress *progressParser) error {
+	cmd := exec.CommandContext(ctx, f.ffmpegPath,
+		"-progress", "pipe:1",
+		"-nostats",
+		"-i", inputPath,
+		"-vf", fmt.Sprintf("scale=-2:%d", rendition.Height),
+		"-c:v", "libx264",
+		"-b:v", rendition.VideoBitrate,
+		"-maxrate", rendition.VideoBitrate,
+		"-bufsize", rendition.VideoBitrate,
+		"-c:a", "aac",
+		"-b:a", rendition.AudioBitrate,
+		"-f", "hls",
+		"-hls_time", fmt.Sprint(hlsSegmentSeconds),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(outputDir, rendition.Name+"_%03d.ts"),
+		"-y",
+		filepath.Join(outputDir, rendition.Name+".m3u8"),
+	)
+
+	// Capture stderr for better error logging
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Stdout = progress
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to transcode %s rendition: %w - %s", rendition.Name, err, stderr.String())
+	}
+
+	return nil
+}
+
+// progressParser reads the key=value lines ffmpeg writes with -progress and reports the
+// fraction of duration that has been written
+type progressParser struct {
+	duration float64 // Input duration in seconds; 0 reports only the end
+	report   func(done float64)
+	partial  []byte // Incomplete last line
+}
+
+func (p *progressParser) Write(b []byte) (int, error) {
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			break
+		}
+		p.parseLine(strings.TrimSpace(string(p.partial[:i])))
+		p.partial = p.partial[i+1:]
+	}
+	return len(b), nil
+}
+
+func (p *progressParser) parseLine(line string) {
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return
+	}
+
+	switch key {
+	// out_time_ms is in microseconds as well, despite its name
+	case "out_time_us", "out_time_ms":
+		micros, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || micros < 0 || p.duration <= 0 {
+			return // "N/A" until the first frame is written
+		}
+		p.report(min(float64(micros)/1e6/p.duration, 1))
+	case "progress":
+		if value == "end" {
+			p.report(1)
+		}
+	}
+}
+
+// progressTracker reports whole percentages, skipping any that aren't higher than the
+// last one reported
+type progressTracker struct {
+	report ProgressFunc
+	last   int
+}
+
+func (t *progressTracker) set(percent int) {
+	if t.report == nil || percent <= t.last {
+		return
+	}
+	t.last = percent
+	t.report(percent)
+}
+
+// GenerateThumbnail extracts a single frame at atSeconds into outputPath, scaled to 320px wide.
+// The image format follows the output file extension.
+func (f *FFmpegService) GenerateThumbnail(ctx context.Context, inputPath string, atSeconds float64, outputPath string) error {
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return fmt.Errorf("failed to create thumbnail directory: %w", err)
+	}
+
+	// Input seeking avoids decoding everything before the timestamp
+	cmd := exec.CommandContext(ctx, f.ffmpegPath,
+		"-ss", formatSeekSeconds(atSeconds),
+		"-i", inputPath,
+		"-frames:v", "1",
+		"-vf", "scale=320:-2",
+		"-y",
+		outputPath,
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		os.Remove(outputPath)
+		return fmt.Errorf("failed to generate thumbnail: %w - %s", err, stderr.String())
+	}
+
+	return nil
+}
+
+// GenerateThumbnailGrid extracts count evenly spaced frames into outputDir and returns
+// their paths in timeline order. Nothing is left behind if any extraction fails.
+func (f *FFmpegService) GenerateThumbnailGrid(ctx context.Context, inputPath string, count int, outputDir string) ([]string, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("thumbnail count must be at least 1")
+	}
+
+	metadata, err := f.ProbeMetadata(ctx, inputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, count)
+	for i, at := range thumbnailGridPositions(metadata.Duration, count) {
+		outputPath := filepath.Join(outputDir, fmt.Sprintf("candidate_%02d.jpg", i))
+		if err := f.GenerateThumbnail(ctx, inputPath, at, outputPath); err != nil {
+			for _, path := range paths {
+				os.Remove(path)
+			}
+			return nil, err
+		}
+		paths = append(paths, outputPath)
+	}
+
+	return paths, nil
+}
+
+// thumbnailGridPositions spaces count timestamps evenly through the video, skipping the very
+// start and end which are often black frames
+func thumbnailGridPositions(duration float64, count int) []float64 {
+	positions := make([]float64, count)
+	for i := range positions {
+		positions[i] = duration * float64(i+1) / float64(count+1)
+	}
+	return positions
+}
+
+// buildMasterPlaylist renders the master playlist referencing each variant playlist
+func buildMasterPlaylist(ladder []HLSRendition) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	for _, rendition := range ladder {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,NAME=\"%s\"\n", rendition.Bandwidth, rendition.Name)
+		fmt.Fprintf(&b, "%s.m3u8\n", rendition.Name)
+	}
+	return b.String()
+}
+
+// thumbnailContentType sniffs the MIME type of a stored thumbnail image, defaulting to JPEG
+func thumbnailContentType(data []byte) string {
+	switch contentType := http.DetectContentType(data); contentType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return contentType
+	}
+	return "image/jpeg"
+}
+
+// hlsContentType returns the MIME type for an HLS playlist or segment file
+func hlsContentType(name string) (string, bool) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl", true
+	case ".ts":
+		return "video/MP2T", true
+	}
+	return "", false
+}
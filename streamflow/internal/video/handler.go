@@ -1,23 +1,93 @@
 package video
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"net/url"
+	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"streamflow/internal/apperr"
+	"streamflow/internal/audit"
+	"streamflow/internal/media"
+	"streamflow/internal/tags"
+	"streamflow/internal/video/uploadlimit"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type VideoHandler struct {
-	videoService *VideoService
+	videoService  *VideoService
+	uploads       *UploadSessionStore
+	audit         *audit.AuditService
+	uploadLimiter *uploadlimit.Limiter // Nil allows any number of uploads at once
 }
 
 // constructor
 func NewVideoHandler(videoService *VideoService) *VideoHandler {
-	return &VideoHandler{videoService: videoService}
+	return &VideoHandler{
+		videoService: videoService,
+		uploads:      NewUploadSessionStore(uploadSessionsDir),
+	}
+}
+
+// SetAuditLog makes the handler record video deletions in the audit log
+func (h *VideoHandler) SetAuditLog(a *audit.AuditService) {
+	h.audit = a
+}
+
+// getUserID reads the authenticated user's ID stored by the JWT middleware
+func getUserID(c *fiber.Ctx) (primitive.ObjectID, error) {
+	userIDStr, ok := c.Locals("user_id").(string)
+	if !ok {
+		return primitive.NilObjectID, fmt.Errorf("user_id not found in context")
+	}
+	return primitive.ObjectIDFromHex(userIDStr)
+}
+
+// requesterID is the authenticated user's ID, or primitive.NilObjectID for anonymous
+// requests. It decides which videos the request may see.
+func requesterID(c *fiber.Ctx) primitive.ObjectID {
+	userID, err := getUserID(c)
+	if err != nil {
+		return primitive.NilObjectID
+	}
+	return userID
+}
+
+// playableVideo loads a video for the public playback routes. A valid ?share= token for
+// the video grants access whatever its visibility; otherwise visibility is enforced for
+// the requester.
+func (h *VideoHandler) playableVideo(c *fiber.Ctx, videoID primitive.ObjectID) (*Video, error) {
+	if token := c.Query("share"); token != "" {
+		if err := h.videoService.VerifyShareToken(c.UserContext(), token, videoID); err != nil {
+			return nil, err
+		}
+		return h.videoService.GetVideoByID(c.UserContext(), videoID)
+	}
+	return h.videoService.GetVideoByID(c.UserContext(), videoID, requesterID(c))
+}
+
+// viewerKey identifies a viewer for view deduplication: the user ID when authenticated,
+// otherwise a hash of the client IP and User-Agent so raw addresses are never stored
+func viewerKey(c *fiber.Ctx) string {
+	if userID, err := getUserID(c); err == nil {
+		return "user:" + userID.Hex()
+	}
+	sum := sha256.Sum256([]byte(c.IP() + "|" + c.Get(fiber.HeaderUserAgent)))
+	return "anon:" + hex.EncodeToString(sum[:])
 }
 
 func (h *VideoHandler) UploadVideo(c *fiber.Ctx) error {
@@ -25,28 +95,29 @@ func (h *VideoHandler) UploadVideo(c *fiber.Ctx) error {
 	userIDStr, ok := c.Locals("user_id").(string)
 	if !ok {
 		log.Println("Authentication failed: user_id not found in context")
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+		return apperr.Unauthorized("Unauthorized")
 	}
 	
 	// Convert string to ObjectID
 	userID, err := primitive.ObjectIDFromHex(userIDStr)
 	if err != nil {
 		log.Printf("Invalid user ID format: %s", userIDStr)
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid user ID"})
+		return apperr.Unauthorized("Invalid user ID")
 	}
 
 	title := c.FormValue("title")
 	description := c.FormValue("description")
+	visibility := Visibility(c.FormValue("visibility"))
 	log.Printf("Processing video upload: '%s' for user %s", title, userID.Hex())
 
 	if title == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Title is required"})
+		return apperr.Validation("Title is required")
 	}
 
 	fileHeader, err := c.FormFile("video")
 	if err != nil {
 		log.Printf("Error getting video file: %v", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Video file is required"})
+		return apperr.Validation("Video file is required")
 	}
 
 	// Handle optional thumbnail upload
@@ -54,10 +125,16 @@ func (h *VideoHandler) UploadVideo(c *fiber.Ctx) error {
 	var thumbnailCloser io.Closer
 	thumbnailHeader, err := c.FormFile("thumbnail")
 	if err == nil {
+		if _, err := media.ValidateImageFile(thumbnailHeader, media.MaxImageSize); err != nil {
+			if errors.Is(err, media.ErrImageTooLarge) {
+				return apperr.TooLarge(err.Error())
+			}
+			return apperr.Validation(err.Error())
+		}
 		thumbFile, err := thumbnailHeader.Open()
 		if err != nil {
 			log.Printf("Error opening thumbnail file: %v", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to open thumbnail file"})
+			return apperr.Internal("Failed to open thumbnail file")
 		}
 		thumbnail = thumbFile
 		thumbnailCloser = thumbFile
@@ -66,362 +143,1023 @@ func (h *VideoHandler) UploadVideo(c *fiber.Ctx) error {
 	// Validate the uploaded file
 	if err := ValidateVideoFile(fileHeader); err != nil {
 		log.Printf("Video file validation failed: %v", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+		return apperr.Validation(err.Error())
 	}
-
-	file, err := fileHeader.Open()
-	if err != nil {
-		log.Printf("Error opening video file: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to open file"})
+	if thumbnailCloser != nil {
+		defer thumbnailCloser.Close()
 	}
-	defer file.Close()
 
-	video, err := h.videoService.CreateVideo(c.Context(), file, title, description, userID, thumbnail)
-	if err != nil {
-		if thumbnailCloser != nil {
-			thumbnailCloser.Close()
+	create := func(ctx context.Context) (*Video, error) {
+		if err := h.videoService.CheckUploadLimits(ctx, userID, fileHeader.Size); err != nil {
+			return nil, err
 		}
-		log.Printf("Error creating video: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		file, err := fileHeader.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open video file: %w", err)
+		}
+		defer file.Close()
+		return h.videoService.CreateVideo(ctx, file, title, description, visibility, userID, thumbnail)
 	}
 
-	if thumbnailCloser != nil {
-		thumbnailCloser.Close()
+	// With an Idempotency-Key a retried upload gets the video the first attempt created
+	var video *Video
+	if key := c.Get("Idempotency-Key"); key != "" {
+		var replayed bool
+		video, replayed, err = h.videoService.CreateVideoIdempotent(c.UserContext(), userID, key, create)
+		if replayed {
+			c.Set("Idempotent-Replayed", "true")
+		}
+	} else {
+		video, err = create(c.UserContext())
+	}
+	if err != nil {
+		log.Printf("Error creating video: %v", err)
+		return createVideoError(err)
 	}
 
 	log.Printf("Video uploaded successfully: %s", video.Title)
 	return c.Status(fiber.StatusCreated).JSON(video)
 }
 
-func (h *VideoHandler) ListVideos(c *fiber.Ctx) error {
-	page,_ := strconv.Atoi(c.Query("page", "1"))
-	limit,_ := strconv.Atoi(c.Query("limit", "10"))
+// InitUpload starts a resumable chunked upload and returns its upload ID
+func (h *VideoHandler) InitUpload(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	var req InitUploadRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.Validation("Invalid request body")
+	}
+	if req.Title == "" {
+		return apperr.Validation("Title is required")
+	}
 
-	video, err := h.videoService.ListVideos(c.Context(), page, limit)
+	session, err := h.uploads.Create(userID, req)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to list videos"})
+		return apperr.Validation(err.Error())
 	}
 
-	return c.Status(fiber.StatusOK).JSON(video)
+	return c.Status(fiber.StatusCreated).JSON(session)
 }
 
-func (h *VideoHandler) GetVideo(c *fiber.Ctx) error {
-	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+// GetUploadStatus reports which chunks of an upload have been received so a client can resume
+func (h *VideoHandler) GetUploadStatus(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid video ID"})
+		return apperr.Unauthorized("Unauthorized")
 	}
 
-	video, err := h.videoService.GetVideoByID(c.Context(), videoID)
+	status, err := h.uploads.Status(c.Params("uploadID"), userID)
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Video not found"})
+		return apperr.NotFound("Upload session not found")
 	}
 
-	return c.Status(fiber.StatusOK).JSON(video)
+	return c.JSON(status)
 }
 
-func (h *VideoHandler) UpdateVideo(c *fiber.Ctx) error {
-	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+// UploadChunk stores one chunk of a resumable upload. Re-sending a chunk replaces it.
+func (h *VideoHandler) UploadChunk(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid video ID"})
-	}
-	var req UpdateVideoRequest
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		return apperr.Unauthorized("Unauthorized")
 	}
-	updatedVideo, err := h.videoService.UpdateVideo(c.Context(), videoID, req)
+
+	index, err := strconv.Atoi(c.Query("index"))
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update video"})
+		return apperr.Validation("Chunk index is required")
 	}
-	return c.JSON(updatedVideo)
-}
 
-func (h *VideoHandler) DeleteVideo(c *fiber.Ctx) error {
-	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	err = h.uploads.WriteChunk(c.Params("uploadID"), userID, index, bytes.NewReader(c.Body()))
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid video ID",
-		})
+		return uploadError(err)
 	}
-	if err := h.videoService.DeleteVideo(c.Context(), videoID); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete video"})
+
+	status, err := h.uploads.Status(c.Params("uploadID"), userID)
+	if err != nil {
+		return uploadError(err)
 	}
-	return c.SendStatus(fiber.StatusNoContent)
+
+	return c.JSON(status)
 }
 
-// StreamVideo serves the HLS playlist for video streaming with seeking support
-func (h *VideoHandler) StreamVideo(c *fiber.Ctx) error {
-	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid video ID"})
+// ValidateUpload checks a file description against the upload rules without receiving
+// the file, so clients can avoid sending files that would be rejected
+func (h *VideoHandler) ValidateUpload(c *fiber.Ctx) error {
+	var req ValidateUploadRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.Validation("Invalid request body")
+	}
+	if req.Size < 0 {
+		return apperr.Validation("Size must not be negative")
 	}
 
-	video, err := h.videoService.GetVideoByID(c.Context(), videoID)
+	userID, err := getUserID(c)
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Video not found"})
+		return apperr.Unauthorized("Unauthorized")
 	}
 
-	if video.Status != StatusCompleted {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Video is not ready for streaming"})
+	result := UploadValidation{Accepted: true}
+	var validationErr ValidationError
+	err = h.videoService.ValidateUpload(c.UserContext(), userID, req.Filename, req.Size, req.ContentType)
+	switch {
+	case err == nil:
+	case errors.As(err, &validationErr):
+		result = UploadValidation{Field: validationErr.Field, Reason: validationErr.Message}
+	case errors.Is(err, ErrUploadTooLarge), errors.Is(err, ErrQuotaExceeded):
+		result = UploadValidation{Field: "size", Reason: err.Error()}
+	case errors.Is(err, ErrVideoLimitReached):
+		result = UploadValidation{Field: "videos", Reason: err.Error()}
+	default:
+		log.Printf("Upload validation failed: %v", err)
+		return apperr.Internal("Failed to validate upload")
 	}
 
-	if video.HLSPath == "" {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Video stream not available"})
-	}
+	return c.Status(fiber.StatusOK).JSON(result)
+}
 
-	// Increment view count when someone starts watching (async to not block streaming)
-	go func() {
-		if err := h.videoService.IncrementViewCount(c.Context(), videoID); err != nil {
-			log.Printf("Failed to increment view count for video %s: %v", videoID.Hex(), err)
-		}
-	}()
+// CompleteUpload assembles the chunks and creates the video from them
+func (h *VideoHandler) CompleteUpload(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
 
-	// Get seek time from query parameter (in seconds)
-	seekTimeStr := c.Query("t", "")
-	var seekTime float64
-	if seekTimeStr != "" {
-		seekTime, err = strconv.ParseFloat(seekTimeStr, 64)
-		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid seek time format"})
-		}
-		if seekTime < 0 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Seek time cannot be negative"})
-		}
-		if seekTime > video.Metadata.Duration {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Seek time exceeds video duration"})
-		}
+	uploadID := c.Params("uploadID")
+	session, assembledPath, err := h.uploads.Assemble(uploadID, userID)
+	if err != nil {
+		return uploadError(err)
 	}
+	// The session is finished whether or not the video is accepted
+	defer h.uploads.Delete(uploadID)
 
-	// Set proper headers for HLS streaming
-	c.Set("Content-Type", "application/vnd.apple.mpegurl")
-	c.Set("Cache-Control", "public, max-age=10")
-	
-	// Add seeking information to response headers
-	if seekTime > 0 {
-		c.Set("X-Seek-Time", strconv.FormatFloat(seekTime, 'f', 2, 64))
-		c.Set("X-Video-Duration", strconv.FormatFloat(video.Metadata.Duration, 'f', 2, 64))
+	file, err := os.Open(assembledPath)
+	if err != nil {
+		return apperr.Internal("Failed to open assembled file")
 	}
+	defer file.Close()
 
-	// Get the request scheme and host to construct absolute URLs
-	scheme := "http"
-	if c.Protocol() == "https" {
-		scheme = "https"
+	if err := ValidateVideoContent(file); err != nil {
+		return apperr.Validation(err.Error())
 	}
-	baseURL := fmt.Sprintf("%s://%s", scheme, c.Get("Host"))
-	if c.Get("Host") == "" {
-		// Fallback if Host header is not present
-		baseURL = fmt.Sprintf("%s://localhost:%s", scheme, c.Port())
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return apperr.Internal("Failed to read assembled file")
 	}
-
-	// Serve the HLS playlist file from GridFS
-	playlistName := fmt.Sprintf("%s/playlist.m3u8", video.ID.Hex())
-	
-	downloadStream, err := h.videoService.DownloadFromGridFS(c.Context(), playlistName)
+	info, err := file.Stat()
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Playlist not found"})
+		return apperr.Internal("Failed to read assembled file")
 	}
-	defer downloadStream.Close()
-
-	// Read the content to debug what we're actually serving
-	buffer := make([]byte, 512) // Read first 512 bytes
-	_, readErr := downloadStream.Read(buffer)
-	if readErr != nil && readErr.Error() != "EOF" {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to read playlist"})
+	if err := h.videoService.CheckUploadLimits(c.UserContext(), userID, info.Size()); err != nil {
+		return quotaError(err)
 	}
-	
-	// Reset stream position (create new stream since we can't seek)
-	downloadStream.Close()
-	downloadStream, err = h.videoService.DownloadFromGridFS(c.Context(), playlistName)
+
+	video, err := h.videoService.CreateVideo(c.UserContext(), file, session.Title, session.Description, session.Visibility, userID, nil)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to re-open playlist"})
+		log.Printf("Error creating video from upload %s: %v", uploadID, err)
+		return createVideoError(err)
 	}
-	defer downloadStream.Close()
 
-	// Alternative approach: Read full content and send directly (more reliable than SendStream)
-	// Read all content from GridFS
-	fullContent := make([]byte, 0)
-	buffer = make([]byte, 1024) // Reuse buffer variable
-	
-	for {
-		n, readErr := downloadStream.Read(buffer)
-		if n > 0 {
-			fullContent = append(fullContent, buffer[:n]...)
-		}
-		if readErr != nil {
-			if readErr.Error() == "EOF" {
-				break
-			}
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to read playlist"})
-		}
-	}
-	
-	if len(fullContent) == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Empty playlist file"})
+	return c.Status(fiber.StatusCreated).JSON(video)
+}
+
+// createVideoError maps a CreateVideo failure to the handler's error.
+// Videos rejected for their content are the client's fault.
+func createVideoError(err error) error {
+	switch {
+	case errors.Is(err, ErrVideoTooLong), errors.Is(err, ErrInvalidVisibility), errors.Is(err, ErrInvalidIdempotencyKey):
+		return apperr.Validation(err.Error())
+	case errors.Is(err, ErrQuotaExceeded), errors.Is(err, ErrUploadTooLarge):
+		return apperr.TooLarge(err.Error())
+	case errors.Is(err, ErrVideoLimitReached):
+		return apperr.Forbidden(err.Error())
+	case errors.Is(err, ErrIdempotencyKeyInUse):
+		return apperr.Conflict(err.Error())
 	}
-	
-	// Process playlist content to make segment URLs absolute
-	playlistContent := string(fullContent)
-	processedContent := h.processPlaylistForAbsoluteURLs(playlistContent, baseURL, video.ID.Hex())
-	processedBytes := []byte(processedContent)
-	
-	// Send the processed content directly
-	c.Set("Content-Length", strconv.Itoa(len(processedBytes)))
-	err = c.Send(processedBytes)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to send playlist"})
+	return apperr.Internal("Failed to create video")
+}
+
+// quotaError maps an upload limit check failure to the handler's error
+func quotaError(err error) error {
+	switch {
+	case errors.Is(err, ErrQuotaExceeded), errors.Is(err, ErrUploadTooLarge):
+		return apperr.TooLarge(err.Error())
+	case errors.Is(err, ErrVideoLimitReached):
+		return apperr.Forbidden(err.Error())
 	}
-	
-	return nil
+	log.Printf("Upload limit check failed: %v", err)
+	return apperr.Internal("Failed to check upload limits")
 }
 
-// processPlaylistForAbsoluteURLs converts relative segment URLs in HLS playlist to absolute URLs
-func (h *VideoHandler) processPlaylistForAbsoluteURLs(playlistContent, baseURL, videoID string) string {
-	lines := strings.Split(playlistContent, "\n")
-	
-	for i, line := range lines {
-		// Skip empty lines and HLS directives (lines starting with #)
-		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
-			continue
-		}
-		
-		// Process segment file references (usually .ts files)
-		trimmedLine := strings.TrimSpace(line)
-		if strings.HasSuffix(trimmedLine, ".ts") && !strings.HasPrefix(trimmedLine, "http") {
-			// Convert relative path to absolute URL
-			absoluteURL := fmt.Sprintf("%s/stream/%s/segments/%s", baseURL, videoID, trimmedLine)
-			lines[i] = absoluteURL
-		}
+// uploadError maps upload session errors to the handler's error
+func uploadError(err error) error {
+	switch {
+	case errors.Is(err, ErrUploadSessionNotFound):
+		return apperr.NotFound("Upload session not found")
+	case errors.Is(err, ErrUploadTooLarge):
+		return apperr.TooLarge(fmt.Sprintf("Upload exceeds maximum file size of %d bytes", MaxFileSize))
+	case errors.Is(err, ErrChunkIndexOutOfRange), errors.Is(err, ErrUploadIncomplete):
+		return apperr.Validation(err.Error())
+	case errors.Is(err, ErrUploadCompleting):
+		return apperr.Conflict(err.Error())
 	}
-	
-	return strings.Join(lines, "\n")
+	log.Printf("Upload error: %v", err)
+	return apperr.Internal("Failed to process upload")
 }
 
-// ServeVideoSegment serves individual video segments for HLS streaming with timestamp support
-func (h *VideoHandler) ServeVideoSegment(c *fiber.Ctx) error {
-	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+func (h *VideoHandler) ListVideos(c *fiber.Ctx) error {
+	page,_ := strconv.Atoi(c.Query("page", "1"))
+	limit,_ := strconv.Atoi(c.Query("limit", "10"))
+
+	video, err := h.videoService.ListVideos(c.UserContext(), page, limit)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid video ID"})
+		return apperr.Internal("Failed to list videos")
 	}
 
-	segmentName := c.Params("segment")
-	if segmentName == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Segment name required"})
-	}
+	return c.Status(fiber.StatusOK).JSON(video)
+}
 
-	video, err := h.videoService.GetVideoByID(c.Context(), videoID)
+// GetUserVideos lists a page of the requester's own videos, optionally only those in
+// the ?status= given
+func (h *VideoHandler) GetUserVideos(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Video not found"})
+		return apperr.Unauthorized("Unauthorized")
 	}
 
-	if video.Status != StatusCompleted {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Video is not ready for streaming"})
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "10"))
+	status := VideoStatus(strings.ToUpper(c.Query("status")))
+	if status != "" && !status.IsValid() {
+		return apperr.Validation("Invalid status. Must be PENDING, PROCESSING, COMPLETED, or FAILED")
 	}
 
-	// Construct segment filename for GridFS lookup
-	segmentFilename := fmt.Sprintf("%s/%s", video.ID.Hex(), segmentName)
+	videos, err := h.videoService.GetUserVideosPaginated(c.UserContext(), userID, page, limit, status)
+	if err != nil {
+		return apperr.Internal("Failed to list videos")
+	}
 
-	// Set proper headers for video segments
-	c.Set("Content-Type", "video/MP2T")
-	c.Set("Cache-Control", "public, max-age=3600") // Cache segments for 1 hour
-	
-	// Add timestamp information to response headers
-	c.Set("X-Video-Duration", strconv.FormatFloat(video.Metadata.Duration, 'f', 2, 64))
+	return c.JSON(videos)
+}
 
-	// Serve the video segment file from GridFS
-	downloadStream, err := h.videoService.DownloadFromGridFS(c.Context(), segmentFilename)
-	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Segment not found"})
+// SearchVideos handles full-text search over completed videos
+func (h *VideoHandler) SearchVideos(c *fiber.Ctx) error {
+	query := c.Query("q")
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "10"))
+	if limit > 50 {
+		limit = 50 // Cap at 50 to prevent abuse
 	}
-	defer downloadStream.Close()
 
-	// Buffer the entire segment into memory before sending to avoid streaming issues
-	segmentData, err := io.ReadAll(downloadStream)
+	videos, err := h.videoService.SearchVideos(c.UserContext(), query, page, limit)
 	if err != nil {
-		log.Printf("❌ [VIDEO] Failed to read segment %s from GridFS: %v", segmentFilename, err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to read segment"})
+		return apperr.Internal("could not perform search")
 	}
 
-	c.Set("Content-Length", strconv.Itoa(len(segmentData)))
-	return c.Send(segmentData)
+	return c.Status(fiber.StatusOK).JSON(videos)
 }
 
-// GetVideoThumbnail serves the video thumbnail
-func (h *VideoHandler) GetVideoThumbnail(c *fiber.Ctx) error {
-	videoIDParam := c.Params("id")
-	videoID, err := primitive.ObjectIDFromHex(videoIDParam)
+func (h *VideoHandler) GetVideo(c *fiber.Ctx) error {
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid video ID"})
+		return apperr.Validation("Invalid video ID")
 	}
 
-	video, err := h.videoService.GetVideoByID(c.Context(), videoID)
+	video, err := h.videoService.GetVideoWithUploader(c.UserContext(), videoID, requesterID(c))
+	if errors.Is(err, ErrNotFound) {
+		return apperr.NotFound("Video not found")
+	}
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Video not found"})
+		return apperr.Internal("Failed to get video")
 	}
 
-	if video.ThumbnailPath == "" {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Thumbnail not available"})
+	// Clients revalidate every time, as the response depends on who asks and has counters
+	// that change without UpdatedAt
+	c.Set(fiber.HeaderETag, video.ETag())
+	c.Set(fiber.HeaderLastModified, video.UpdatedAt.UTC().Format(http.TimeFormat))
+	c.Set(fiber.HeaderCacheControl, "private, no-cache")
+	c.Vary(fiber.HeaderAuthorization)
+	if notModified(c, video.ETag(), video.UpdatedAt) {
+		return c.SendStatus(fiber.StatusNotModified)
 	}
 
-	// Set proper headers for image
-	c.Set("Content-Type", "image/jpeg")
-	c.Set("Cache-Control", "public, max-age=86400")
+	return c.Status(fiber.StatusOK).JSON(video)
+}
 
-	// Try GridFS ObjectID first (newer format)
-	thumbnailID, err := primitive.ObjectIDFromHex(video.ThumbnailPath)
-	if err == nil {
-		downloadStream, err := h.videoService.DownloadFromGridFSByID(c.Context(), thumbnailID)
-		if err != nil {
-			log.Printf("GridFS thumbnail error for %s: %v", thumbnailID.Hex(), err)
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Thumbnail not found in storage"})
-		}
-		defer downloadStream.Close()
-		
-		// Read the stream into memory to avoid SendStream issues
-		thumbnailData, err := io.ReadAll(downloadStream)
-		if err != nil {
-			log.Printf("Failed to read thumbnail data for %s: %v", thumbnailID.Hex(), err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to read thumbnail"})
+// notModified reports whether the client's copy, validated by If-None-Match or else by
+// If-Modified-Since, is still current. Entity tags are compared weakly.
+func notModified(c *fiber.Ctx, etag string, modified time.Time) bool {
+	if noneMatch := c.Get(fiber.HeaderIfNoneMatch); noneMatch != "" {
+		for _, tag := range strings.Split(noneMatch, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
 		}
-		
-		c.Set("Content-Length", strconv.Itoa(len(thumbnailData)))
-		return c.Send(thumbnailData)
+		return false
 	}
 
-	// Not a GridFS ID, treat as file path
-	return c.SendFile(video.ThumbnailPath)
+	since, err := http.ParseTime(c.Get(fiber.HeaderIfModifiedSince))
+	if err != nil {
+		return false
+	}
+	// Last-Modified only has whole seconds
+	return !modified.Truncate(time.Second).After(since)
 }
 
-// GetVideoTimestamp returns the current timestamp and duration information
-func (h *VideoHandler) GetVideoTimestamp(c *fiber.Ctx) error {
-	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+func (h *VideoHandler) UpdateVideo(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid video ID"})
+		return apperr.Unauthorized("Unauthorized")
 	}
 
-	video, err := h.videoService.GetVideoByID(c.Context(), videoID)
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Video not found"})
+		return apperr.Validation("Invalid video ID")
 	}
-
-	if video.Status != StatusCompleted {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Video is not ready for streaming"})
+	var req UpdateVideoRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.Validation("Invalid request body")
 	}
-
-	// Get current time from query parameter (in seconds)
-	currentTimeStr := c.Query("current", "0")
-	currentTime, err := strconv.ParseFloat(currentTimeStr, 64)
+	updatedVideo, err := h.videoService.UpdateVideo(c.UserContext(), videoID, userID, req)
 	if err != nil {
-		currentTime = 0
+		switch {
+		case errors.Is(err, ErrNotFound):
+			return apperr.NotFound("Video not found")
+		case errors.Is(err, ErrForbidden):
+			return apperr.Forbidden("You can only update your own videos")
+		case errors.Is(err, ErrInvalidVisibility), errors.Is(err, tags.ErrTooMany):
+			return apperr.Validation(err.Error())
+		}
+		return apperr.Internal("Failed to update video")
 	}
+	return c.JSON(updatedVideo)
+}
 
-	// Ensure current time doesn't exceed video duration
-	if currentTime > video.Metadata.Duration {
-		currentTime = video.Metadata.Duration
+// ReplaceVideoFile swaps the file of one of the requester's videos for the uploaded
+// "video" form file. The video keeps its ID, views, likes and comments and is processed
+// again.
+func (h *VideoHandler) ReplaceVideoFile(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
 	}
 
-	return c.JSON(fiber.Map{
-		"video_id": video.ID.Hex(),
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+	fileHeader, err := c.FormFile("video")
+	if err != nil {
+		return apperr.Validation("Video file is required")
+	}
+	if err := ValidateVideoFile(fileHeader); err != nil {
+		return apperr.Validation(err.Error())
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		log.Printf("Error opening video file: %v", err)
+		return apperr.Internal("Failed to open video file")
+	}
+	defer file.Close()
+
+	video, err := h.videoService.ReplaceVideoFile(c.UserContext(), videoID, userID, file)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			return apperr.NotFound("Video not found")
+		case errors.Is(err, ErrForbidden):
+			return apperr.Forbidden("You can only replace your own videos")
+		case errors.Is(err, ErrProcessingActive):
+			return apperr.Conflict(err.Error())
+		case errors.Is(err, ErrInvalidVideoFile), errors.Is(err, ErrVideoTooLong):
+			return apperr.Validation(err.Error())
+		case errors.Is(err, ErrQuotaExceeded):
+			return apperr.TooLarge(err.Error())
+		case errors.Is(err, ErrVideoLimitReached):
+			return apperr.Forbidden(err.Error())
+		}
+		log.Printf("Error replacing file of video %s: %v", videoID.Hex(), err)
+		return apperr.Internal("Failed to replace video file")
+	}
+
+	h.audit.TryRecord(c.UserContext(), audit.RequestEntry(c, audit.ActionVideoReplace, audit.TargetVideo, videoID.Hex()))
+	return c.JSON(video)
+}
+
+func (h *VideoHandler) DeleteVideo(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+	// Deleted videos can be restored until the purge janitor removes them
+	if err := h.videoService.DeleteVideo(c.UserContext(), videoID, userID); err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			return apperr.NotFound("Video not found")
+		case errors.Is(err, ErrForbidden):
+			return apperr.Forbidden("You can only delete your own videos")
+		}
+		return apperr.Internal("Failed to delete video")
+	}
+	h.audit.TryRecord(c.UserContext(), audit.RequestEntry(c, audit.ActionVideoDelete, audit.TargetVideo, videoID.Hex()))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// DeleteVideos permanently deletes the requester's videos listed in the body. Videos
+// that don't exist or belong to someone else are skipped and counted.
+func (h *VideoHandler) DeleteVideos(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	var req BatchDeleteRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.Validation("Invalid request body")
+	}
+	if len(req.IDs) == 0 {
+		return apperr.Validation("At least one video ID is required")
+	}
+	ids := make([]primitive.ObjectID, len(req.IDs))
+	for i, hex := range req.IDs {
+		if ids[i], err = primitive.ObjectIDFromHex(hex); err != nil {
+			return apperr.Validation(fmt.Sprintf("Invalid video ID: %s", hex))
+		}
+	}
+
+	result, err := h.videoService.DeleteVideos(c.UserContext(), ids, userID)
+	if err != nil {
+		if errors.Is(err, ErrBatchTooLarge) {
+			return apperr.Validation(err.Error())
+		}
+		return apperr.Internal("Failed to delete videos")
+	}
+	for _, id := range result.DeletedIDs {
+		h.audit.TryRecord(c.UserContext(), audit.RequestEntry(c, audit.ActionVideoDelete, audit.TargetVideo, id.Hex()))
+	}
+	return c.JSON(result)
+}
+
+// SetChapters replaces the chapter markers of the requester's video
+func (h *VideoHandler) SetChapters(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+
+	var req SetChaptersRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.Validation("Invalid request body")
+	}
+
+	video, err := h.videoService.SetChapters(c.UserContext(), videoID, userID, req.Chapters)
+	if err != nil {
+		var vErr ValidationError
+		switch {
+		case errors.As(err, &vErr):
+			return apperr.Validation(vErr.Message)
+		case errors.Is(err, ErrNotFound):
+			return apperr.NotFound("Video not found")
+		case errors.Is(err, ErrForbidden):
+			return apperr.Forbidden("You can only edit chapters of your own videos")
+		}
+		return apperr.Internal("Failed to set chapters")
+	}
+
+	return c.JSON(video)
+}
+
+// GetProcessingStatus returns the processing progress and latest processing job of the requester's video
+func (h *VideoHandler) GetProcessingStatus(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+
+	status, err := h.videoService.GetVideoProcessingStatus(c.UserContext(), videoID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			return apperr.NotFound("Video not found")
+		case errors.Is(err, ErrForbidden):
+			return apperr.Forbidden("You can only view processing of your own videos")
+		}
+		return apperr.Internal("Failed to get processing status")
+	}
+
+	return c.JSON(status)
+}
+
+// processingHeartbeat is how often an idle processing stream is checked. The status is
+// read again then too, which catches jobs run by other instances.
+const processingHeartbeat = 15 * time.Second
+
+// StreamProcessingStatus pushes the processing status of a video as server-sent events:
+// the current status first, then every update, until the video is completed or failed
+func (h *VideoHandler) StreamProcessingStatus(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+
+	status, updates, unsubscribe, err := h.videoService.SubscribeProcessing(c.UserContext(), videoID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			return apperr.NotFound("Video not found")
+		case errors.Is(err, ErrForbidden):
+			return apperr.Forbidden("You can only view processing of your own videos")
+		}
+		return apperr.Internal("Failed to get processing status")
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no") // Stop proxies from buffering the events
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// The stream ends when a write fails because the client is gone; the heartbeat
+		// makes sure an idle stream notices too
+		defer unsubscribe()
+		ticker := time.NewTicker(processingHeartbeat)
+		defer ticker.Stop()
+
+		last := *status
+		if writeProcessingEvent(w, last) != nil || processingDone(last) {
+			return
+		}
+		for {
+			select {
+			case update, ok := <-updates:
+				if !ok {
+					return // Shutting down
+				}
+				last = update
+				if writeProcessingEvent(w, last) != nil || processingDone(last) {
+					return
+				}
+			case <-ticker.C:
+				current, err := h.videoService.GetVideoProcessingStatus(context.Background(), videoID, userID)
+				if err == nil && (current.Status != last.Status || current.Progress != last.Progress) {
+					last = *current
+					if writeProcessingEvent(w, last) != nil || processingDone(last) {
+						return
+					}
+					continue
+				}
+				fmt.Fprint(w, ": keepalive\n\n")
+				if w.Flush() != nil {
+					return
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// writeProcessingEvent writes a processing status as a server-sent event and flushes it
+func writeProcessingEvent(w *bufio.Writer, status ProcessingStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "event: processing\ndata: %s\n\n", data)
+	return w.Flush()
+}
+
+// processingDone reports whether processing has finished for good
+func processingDone(status ProcessingStatus) bool {
+	return status.Status == StatusCompleted || status.Status == StatusFailed
+}
+
+// RestoreVideo brings back a soft-deleted video owned by the requester
+func (h *VideoHandler) RestoreVideo(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+
+	video, err := h.videoService.GetVideo(c.UserContext(), videoID, true)
+	if err != nil || video.DeletedAt == nil {
+		return apperr.NotFound("Deleted video not found")
+	}
+	if video.UserID != userID {
+		return apperr.Forbidden("You can only restore your own videos")
+	}
+
+	if err := h.videoService.RestoreVideo(c.UserContext(), videoID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return apperr.NotFound("Deleted video not found")
+		}
+		return apperr.Internal("Failed to restore video")
+	}
+
+	restored, err := h.videoService.GetVideoByID(c.UserContext(), videoID, requesterID(c))
+	if err != nil {
+		return apperr.Internal("Failed to load restored video")
+	}
+	return c.JSON(restored)
+}
+
+// StreamVideo serves the HLS playlist for video streaming with seeking support
+func (h *VideoHandler) StreamVideo(c *fiber.Ctx) error {
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+
+	video, err := h.playableVideo(c, videoID)
+	if err != nil {
+		return apperr.NotFound("Video not found")
+	}
+
+	if video.Status != StatusCompleted {
+		return apperr.Validation("Video is not ready for streaming")
+	}
+
+	if video.HLSPath == "" {
+		return apperr.NotFound("Video stream not available")
+	}
+
+	// Count the view when someone starts watching (async to not block streaming).
+	// The request context is recycled once the handler returns, so it can't be used here.
+	viewer := viewerKey(c)
+	go func() {
+		if _, err := h.videoService.RecordView(context.Background(), videoID, viewer); err != nil {
+			log.Printf("Failed to record view for video %s: %v", videoID.Hex(), err)
+		}
+	}()
+
+	// Get seek time from query parameter (in seconds)
+	seekTimeStr := c.Query("t", "")
+	var seekTime float64
+	if seekTimeStr != "" {
+		seekTime, err = strconv.ParseFloat(seekTimeStr, 64)
+		if err != nil {
+			return apperr.Validation("Invalid seek time format")
+		}
+		if seekTime < 0 {
+			return apperr.Validation("Seek time cannot be negative")
+		}
+		if seekTime > video.Metadata.Duration {
+			return apperr.Validation("Seek time exceeds video duration")
+		}
+	}
+
+	// Set proper headers for HLS streaming
+	c.Set("Content-Type", "application/vnd.apple.mpegurl")
+	c.Set("Cache-Control", "public, max-age=10")
+	
+	// Add seeking information to response headers
+	if seekTime > 0 {
+		c.Set("X-Seek-Time", strconv.FormatFloat(seekTime, 'f', 2, 64))
+		c.Set("X-Video-Duration", strconv.FormatFloat(video.Metadata.Duration, 'f', 2, 64))
+	}
+
+	// Serve the HLS playlist file from GridFS
+	playlistName := fmt.Sprintf("%s/%s", video.ID.Hex(), HLSMasterPlaylist)
+	
+	downloadStream, err := h.videoService.DownloadFromGridFS(c.UserContext(), playlistName)
+	if err != nil {
+		return apperr.NotFound("Playlist not found")
+	}
+	defer downloadStream.Close()
+
+	// Read the content to debug what we're actually serving
+	buffer := make([]byte, 512) // Read first 512 bytes
+	_, readErr := downloadStream.Read(buffer)
+	if readErr != nil && readErr.Error() != "EOF" {
+		return apperr.Internal("Failed to read playlist")
+	}
+	
+	// Reset stream position (create new stream since we can't seek)
+	downloadStream.Close()
+	downloadStream, err = h.videoService.DownloadFromGridFS(c.UserContext(), playlistName)
+	if err != nil {
+		return apperr.Internal("Failed to re-open playlist")
+	}
+	defer downloadStream.Close()
+
+	// Alternative approach: Read full content and send directly (more reliable than SendStream)
+	// Read all content from GridFS
+	fullContent := make([]byte, 0)
+	buffer = make([]byte, 1024) // Reuse buffer variable
+	
+	for {
+		n, readErr := downloadStream.Read(buffer)
+		if n > 0 {
+			fullContent = append(fullContent, buffer[:n]...)
+		}
+		if readErr != nil {
+			if readErr.Error() == "EOF" {
+				break
+			}
+			return apperr.Internal("Failed to read playlist")
+		}
+	}
+	
+	if len(fullContent) == 0 {
+		return apperr.NotFound("Empty playlist file")
+	}
+	
+	// Process playlist content to make segment URLs absolute
+	playlistContent := string(fullContent)
+	processedContent := h.processPlaylistForAbsoluteURLs(playlistContent, requestBaseURL(c), video.ID.Hex(), c.Query("share"))
+	processedBytes := []byte(processedContent)
+	
+	// Send the processed content directly
+	c.Set("Content-Length", strconv.Itoa(len(processedBytes)))
+	err = c.Send(processedBytes)
+	if err != nil {
+		return apperr.Internal("Failed to send playlist")
+	}
+	
+	return nil
+}
+
+// StreamVideoFile serves the original uploaded video with HTTP Range support for seeking
+func (h *VideoHandler) StreamVideoFile(c *fiber.Ctx) error {
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+
+	video, err := h.playableVideo(c, videoID)
+	if err != nil {
+		return apperr.NotFound("Video not found")
+	}
+
+	// Storage that can hand out URLs (S3) serves the file and its byte ranges itself
+	url, err := h.videoService.VideoFileURL(c.UserContext(), video)
+	if err != nil {
+		return apperr.Internal("Failed to locate video file")
+	}
+	if url != "" {
+		return c.Redirect(url, fiber.StatusFound)
+	}
+
+	file, err := h.videoService.OpenVideoFile(c.UserContext(), video)
+	if err != nil {
+		return apperr.NotFound("Video file not found")
+	}
+
+	c.Set("Cache-Control", "public, max-age=3600")
+	return serveContent(c, file, file.Size(), "video/mp4")
+}
+
+// DownloadVideo serves the original upload as an attachment named after the video's
+// title. Access follows the same rules as streaming. A download is counted once per
+// request for the file from its start, so resumed downloads aren't counted again.
+func (h *VideoHandler) DownloadVideo(c *fiber.Ctx) error {
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+
+	video, err := h.playableVideo(c, videoID)
+	if err != nil {
+		return apperr.NotFound("Video not found")
+	}
+	download := DownloadOf(video)
+
+	url, err := h.videoService.DownloadURL(c.UserContext(), video, download)
+	if err != nil {
+		return apperr.Internal("Failed to locate video file")
+	}
+	var file StoredFile
+	if url == "" {
+		if file, err = h.videoService.OpenVideoFile(c.UserContext(), video); err != nil {
+			return apperr.NotFound("Video file not found")
+		}
+	}
+
+	if rangeHeader := c.Get(fiber.HeaderRange); rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-") {
+		// The request context is recycled once the handler returns, so it can't be used here.
+		go func() {
+			if err := h.videoService.RecordDownload(context.Background(), videoID); err != nil {
+				log.Printf("Failed to record download of video %s: %v", videoID.Hex(), err)
+			}
+		}()
+	}
+
+	if url != "" {
+		return c.Redirect(url, fiber.StatusFound)
+	}
+	c.Set(fiber.HeaderContentDisposition, download.Disposition())
+	c.Set("Cache-Control", "private, max-age=3600")
+	return serveContent(c, file, file.Size(), download.ContentType)
+}
+
+// requestBaseURL returns the scheme and host of the request, to construct absolute URLs
+func requestBaseURL(c *fiber.Ctx) string {
+	scheme := "http"
+	if c.Protocol() == "https" {
+		scheme = "https"
+	}
+	if c.Get("Host") == "" {
+		// Fallback if Host header is not present
+		return fmt.Sprintf("%s://localhost:%s", scheme, c.Port())
+	}
+	return fmt.Sprintf("%s://%s", scheme, c.Get("Host"))
+}
+
+// processPlaylistForAbsoluteURLs converts relative segment URLs in HLS playlist to absolute URLs.
+// A share token is carried over to them, so players can fetch a shared video's segments.
+func (h *VideoHandler) processPlaylistForAbsoluteURLs(playlistContent, baseURL, videoID, shareToken string) string {
+	lines := strings.Split(playlistContent, "\n")
+	
+	for i, line := range lines {
+		// Skip empty lines and HLS directives (lines starting with #)
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		
+		// Process segment and variant playlist references
+		trimmedLine := strings.TrimSpace(line)
+		if _, ok := hlsContentType(trimmedLine); ok && !strings.HasPrefix(trimmedLine, "http") {
+			// Convert relative path to absolute URL
+			absoluteURL := fmt.Sprintf("%s/stream/%s/segments/%s", baseURL, videoID, trimmedLine)
+			if shareToken != "" {
+				absoluteURL += "?share=" + url.QueryEscape(shareToken)
+			}
+			lines[i] = absoluteURL
+		}
+	}
+	
+	return strings.Join(lines, "\n")
+}
+
+// ServeVideoSegment serves individual video segments and variant playlists for HLS streaming with timestamp support
+func (h *VideoHandler) ServeVideoSegment(c *fiber.Ctx) error {
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+
+	segmentName := c.Params("segment")
+	if segmentName == "" {
+		return apperr.Validation("Segment name required")
+	}
+
+	video, err := h.playableVideo(c, videoID)
+	if err != nil {
+		return apperr.NotFound("Video not found")
+	}
+
+	if video.Status != StatusCompleted {
+		return apperr.Validation("Video is not ready for streaming")
+	}
+
+	// Construct segment filename for GridFS lookup
+	segmentFilename := fmt.Sprintf("%s/%s", video.ID.Hex(), segmentName)
+
+	// Segments and variant playlists share this route
+	contentType, ok := hlsContentType(segmentName)
+	if !ok {
+		return apperr.Validation("Invalid segment name")
+	}
+
+	c.Set("Content-Type", contentType)
+	c.Set("Cache-Control", "public, max-age=3600") // Cache segments for 1 hour
+	
+	// Add timestamp information to response headers
+	c.Set("X-Video-Duration", strconv.FormatFloat(video.Metadata.Duration, 'f', 2, 64))
+
+	// Serve the video segment file from GridFS
+	downloadStream, err := h.videoService.DownloadFromGridFS(c.UserContext(), segmentFilename)
+	if err != nil {
+		return apperr.NotFound("Segment not found")
+	}
+	defer downloadStream.Close()
+
+	// Buffer the entire segment into memory before sending to avoid streaming issues
+	segmentData, err := io.ReadAll(downloadStream)
+	if err != nil {
+		log.Printf("❌ [VIDEO] Failed to read segment %s from GridFS: %v", segmentFilename, err)
+		return apperr.Internal("Failed to read segment")
+	}
+
+	// Variant playlists reference their segments relatively, which would drop the share token
+	if shareToken := c.Query("share"); shareToken != "" && strings.HasSuffix(segmentName, ".m3u8") {
+		segmentData = []byte(h.processPlaylistForAbsoluteURLs(string(segmentData), requestBaseURL(c), video.ID.Hex(), shareToken))
+	}
+
+	c.Set("Content-Length", strconv.Itoa(len(segmentData)))
+	return c.Send(segmentData)
+}
+
+// GetVideoThumbnail serves the video thumbnail
+func (h *VideoHandler) GetVideoThumbnail(c *fiber.Ctx) error {
+	videoIDParam := c.Params("id")
+	videoID, err := primitive.ObjectIDFromHex(videoIDParam)
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+
+	video, err := h.playableVideo(c, videoID)
+	if err != nil {
+		return apperr.NotFound("Video not found")
+	}
+
+	// ?candidate=N serves one of the selectable thumbnails instead of the active one
+	thumbnailPath := video.ThumbnailPath
+	if candidate := c.Query("candidate"); candidate != "" {
+		index, err := strconv.Atoi(candidate)
+		if err != nil || index < 0 || index >= len(video.ThumbnailCandidates) {
+			return apperr.NotFound("Thumbnail candidate not found")
+		}
+		thumbnailPath = video.ThumbnailCandidates[index]
+	}
+
+	if thumbnailPath == "" {
+		return apperr.NotFound("Thumbnail not available")
+	}
+
+	c.Set("Cache-Control", "public, max-age=86400")
+
+	// Try GridFS ObjectID first (newer format)
+	thumbnailID, err := primitive.ObjectIDFromHex(thumbnailPath)
+	if err == nil {
+		downloadStream, err := h.videoService.DownloadFromGridFSByID(c.UserContext(), thumbnailID)
+		if err != nil {
+			log.Printf("GridFS thumbnail error for %s: %v", thumbnailID.Hex(), err)
+			return apperr.NotFound("Thumbnail not found in storage")
+		}
+		defer downloadStream.Close()
+		
+		// Read the stream into memory to avoid SendStream issues
+		thumbnailData, err := io.ReadAll(downloadStream)
+		if err != nil {
+			log.Printf("Failed to read thumbnail data for %s: %v", thumbnailID.Hex(), err)
+			return apperr.Internal("Failed to read thumbnail")
+		}
+		
+		c.Set("Content-Type", thumbnailContentType(thumbnailData))
+		c.Set("Content-Length", strconv.Itoa(len(thumbnailData)))
+		return c.Send(thumbnailData)
+	}
+
+	// Not a GridFS ID, treat as file path (content type follows the extension)
+	return c.SendFile(thumbnailPath)
+}
+
+// GetVideoTimestamp returns the current timestamp and duration information
+func (h *VideoHandler) GetVideoTimestamp(c *fiber.Ctx) error {
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+
+	video, err := h.videoService.GetVideoByID(c.UserContext(), videoID, requesterID(c))
+	if err != nil {
+		return apperr.NotFound("Video not found")
+	}
+
+	if video.Status != StatusCompleted {
+		return apperr.Validation("Video is not ready for streaming")
+	}
+
+	// Get current time from query parameter (in seconds)
+	currentTimeStr := c.Query("current", "0")
+	currentTime, err := strconv.ParseFloat(currentTimeStr, 64)
+	if err != nil {
+		currentTime = 0
+	}
+
+	// Ensure current time doesn't exceed video duration
+	if currentTime > video.Metadata.Duration {
+		currentTime = video.Metadata.Duration
+	}
+
+	return c.JSON(fiber.Map{
+		"video_id": video.ID.Hex(),
 		"current_time": currentTime,
 		"duration": video.Metadata.Duration,
 		"remaining": video.Metadata.Duration - currentTime,
@@ -429,33 +1167,121 @@ func (h *VideoHandler) GetVideoTimestamp(c *fiber.Ctx) error {
 	})
 }
 
-// GetPopularVideos returns the most viewed videos
-func (h *VideoHandler) GetPopularVideos(c *fiber.Ctx) error {
+// GetPopularVideos returns the most viewed videos
+func (h *VideoHandler) GetPopularVideos(c *fiber.Ctx) error {
+	limit, _ := strconv.Atoi(c.Query("limit", "10"))
+	if limit > 50 {
+		limit = 50 // Cap at 50 to prevent abuse
+	}
+	
+	videos, err := h.videoService.GetPopularVideos(c.UserContext(), limit)
+	if err != nil {
+		return apperr.Internal("Failed to get popular videos")
+	}
+	
+	return c.Status(fiber.StatusOK).JSON(videos)
+}
+
+// CreateShareLink creates a link to one of the user's videos that works for anyone, even
+// when the video is private. expires_in is the link's lifetime in seconds.
+func (h *VideoHandler) CreateShareLink(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+
+	var req struct {
+		ExpiresIn int64 `json:"expires_in"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return apperr.Validation("Invalid request body")
+		}
+	}
+	if req.ExpiresIn < 0 {
+		return apperr.Validation("expires_in must not be negative")
+	}
+
+	link, err := h.videoService.CreateShareLink(c.UserContext(), videoID, userID, time.Duration(req.ExpiresIn)*time.Second)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return apperr.NotFound("Video not found")
+	case errors.Is(err, ErrForbidden):
+		return apperr.Forbidden("You can only share your own videos")
+	case errors.Is(err, ErrShareLinkTTL):
+		return apperr.Validation(err.Error())
+	case err != nil:
+		log.Printf("Failed to create share link for video %s: %v", videoID.Hex(), err)
+		return apperr.Internal("Failed to create share link")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(link)
+}
+
+// RevokeShareLink disables one of the user's share links
+func (h *VideoHandler) RevokeShareLink(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+	linkID, err := primitive.ObjectIDFromHex(c.Params("linkId"))
+	if err != nil {
+		return apperr.Validation("Invalid share link ID")
+	}
+
+	err = h.videoService.RevokeShareLink(c.UserContext(), videoID, linkID, userID)
+	if errors.Is(err, ErrShareLinkNotFound) {
+		return apperr.NotFound("Share link not found")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to revoke share link")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetRelatedVideos suggests videos to watch after the given one. Set exclude_uploader
+// to leave out the uploader's other videos.
+func (h *VideoHandler) GetRelatedVideos(c *fiber.Ctx) error {
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
 	limit, _ := strconv.Atoi(c.Query("limit", "10"))
-	if limit > 50 {
-		limit = 50 // Cap at 50 to prevent abuse
+	excludeUploader, _ := strconv.ParseBool(c.Query("exclude_uploader", "false"))
+
+	videos, err := h.videoService.GetRelatedVideos(c.UserContext(), videoID, limit, excludeUploader, requesterID(c))
+	if errors.Is(err, ErrNotFound) {
+		return apperr.NotFound("Video not found")
 	}
-	
-	videos, err := h.videoService.GetPopularVideos(c.Context(), limit)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to get popular videos"})
+		return apperr.Internal("Failed to get related videos")
 	}
-	
+
 	return c.Status(fiber.StatusOK).JSON(videos)
 }
 
-// UpdateVideoStatus manually updates a video's status (for debugging/admin purposes)
+// UpdateVideoStatus manually updates a video's status. It is an admin route, as it can set
+// the status of any user's video.
 func (h *VideoHandler) UpdateVideoStatus(c *fiber.Ctx) error {
 	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid video ID"})
+		return apperr.Validation("Invalid video ID")
 	}
 
 	var req struct {
 		Status string `json:"status"`
 	}
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		return apperr.Validation("Invalid request body")
 	}
 
 	// Validate status
@@ -470,23 +1296,166 @@ func (h *VideoHandler) UpdateVideoStatus(c *fiber.Ctx) error {
 	case "FAILED":
 		status = StatusFailed
 	default:
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid status. Must be PENDING, PROCESSING, COMPLETED, or FAILED"})
+		return apperr.Validation("Invalid status. Must be PENDING, PROCESSING, COMPLETED, or FAILED")
 	}
 
-	err = h.videoService.UpdateVideoStatus(c.Context(), videoID, status)
+	err = h.videoService.UpdateVideoStatus(c.UserContext(), videoID, status)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update video status"})
+		return apperr.Internal("Failed to update video status")
 	}
 
 	// Return updated video
-	video, err := h.videoService.GetVideoByID(c.Context(), videoID)
+	video, err := h.videoService.GetVideoByID(c.UserContext(), videoID, requesterID(c))
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to get updated video"})
+		return apperr.Internal("Failed to get updated video")
 	}
 
 	return c.JSON(video)
 }
 
+// AdminBulkSetStatus updates the status of many videos at once (admin only)
+func (h *VideoHandler) AdminBulkSetStatus(c *fiber.Ctx) error {
+	var req struct {
+		IDs    []string `json:"ids"`
+		Status string   `json:"status"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.Validation("Invalid request body")
+	}
+
+	status := VideoStatus(req.Status)
+	if !status.IsValid() {
+		return apperr.Validation("Invalid status. Must be PENDING, PROCESSING, COMPLETED, or FAILED")
+	}
+
+	if len(req.IDs) == 0 {
+		return apperr.Validation("At least one video ID is required")
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(req.IDs))
+	for _, idStr := range req.IDs {
+		id, err := primitive.ObjectIDFromHex(idStr)
+		if err != nil {
+			return apperr.Validation(fmt.Sprintf("Invalid video ID: %s", idStr))
+		}
+		ids = append(ids, id)
+	}
+
+	updated, err := h.videoService.AdminBulkSetStatus(c.UserContext(), ids, status)
+	if err != nil {
+		return apperr.Internal("Failed to update video status")
+	}
+
+	return c.JSON(fiber.Map{
+		"updated": updated,
+		"status":  status,
+	})
+}
+
+// AdminReprobeMetadata starts re-probing the metadata of videos that still carry the
+// placeholder values (admin only)
+func (h *VideoHandler) AdminReprobeMetadata(c *fiber.Ctx) error {
+	job, err := h.videoService.StartMetadataReprobe()
+	if errors.Is(err, ErrReprobeRunning) {
+		return apperr.Conflict("A metadata re-probe is already running")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to start metadata re-probe")
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(job)
+}
+
+// AdminListVideosByStatus lists a page of the videos of every user in the :status given,
+// those left untouched the longest first (admin only)
+func (h *VideoHandler) AdminListVideosByStatus(c *fiber.Ctx) error {
+	status := VideoStatus(strings.ToUpper(c.Params("status")))
+	if !status.IsValid() {
+		return apperr.Validation("Invalid status. Must be PENDING, PROCESSING, COMPLETED, or FAILED")
+	}
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+
+	videos, err := h.videoService.GetVideosByStatus(c.UserContext(), status, page, limit)
+	if err != nil {
+		return apperr.Internal("Failed to list videos")
+	}
+
+	return c.JSON(videos)
+}
+
+// AdminRetryProcessing queues a failed or stuck video to be processed again (admin only)
+func (h *VideoHandler) AdminRetryProcessing(c *fiber.Ctx) error {
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+
+	job, err := h.videoService.RetryProcessing(c.UserContext(), videoID)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return apperr.NotFound("Video not found")
+	case errors.Is(err, ErrNotRetryable), errors.Is(err, ErrProcessingActive):
+		return apperr.Conflict(err.Error())
+	case err != nil:
+		log.Printf("Failed to retry processing of video %s: %v", videoID.Hex(), err)
+		return apperr.Internal("Failed to retry processing")
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(job)
+}
+
+// AdminReprobeStatus reports the progress of the latest metadata re-probe (admin only)
+func (h *VideoHandler) AdminReprobeStatus(c *fiber.Ctx) error {
+	job, err := h.videoService.MetadataReprobeStatus()
+	if errors.Is(err, ErrJobNotFound) {
+		return apperr.NotFound("No metadata re-probe has been started")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to get metadata re-probe status")
+	}
+
+	return c.JSON(job)
+}
+
+// AdminStorageStats reports the disk usage of the video directories and the orphaned
+// files in them (admin only)
+func (h *VideoHandler) AdminStorageStats(c *fiber.Ctx) error {
+	stats, err := h.videoService.GetStorageStats(c.UserContext())
+	if err != nil {
+		log.Printf("Failed to get storage stats: %v", err)
+		return apperr.Internal("Failed to get storage stats")
+	}
+
+	return c.JSON(stats)
+}
+
+// CleanupStorageRequest lists orphaned files, as reported by AdminStorageStats, to remove
+type CleanupStorageRequest struct {
+	Paths []string `json:"paths"`
+}
+
+// AdminCleanupStorage removes the given orphaned files, skipping any that are no longer
+// orphaned (admin only)
+func (h *VideoHandler) AdminCleanupStorage(c *fiber.Ctx) error {
+	var req CleanupStorageRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.Validation("Invalid request body")
+	}
+
+	result, err := h.videoService.RemoveOrphanedFiles(c.UserContext(), req.Paths)
+	if errors.Is(err, ErrNoOrphansGiven) {
+		return apperr.Validation(err.Error())
+	}
+	if err != nil {
+		log.Printf("Failed to clean up storage: %v", err)
+		return apperr.Internal("Failed to clean up storage")
+	}
+
+	log.Printf("Removed %d orphaned files (%d bytes)", len(result.Removed), result.FreedBytes)
+	return c.JSON(result)
+}
+
 // GetTrendingVideos returns trending videos (recent + high views)
 func (h *VideoHandler) GetTrendingVideos(c *fiber.Ctx) error {
 	limit, _ := strconv.Atoi(c.Query("limit", "10"))
@@ -499,9 +1468,9 @@ func (h *VideoHandler) GetTrendingVideos(c *fiber.Ctx) error {
 		daysBack = 30 // Cap at 30 days
 	}
 	
-	videos, err := h.videoService.GetTrendingVideos(c.Context(), limit, daysBack)
+	videos, err := h.videoService.GetTrendingVideos(c.UserContext(), limit, daysBack)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to get trending videos"})
+		return apperr.Internal("Failed to get trending videos")
 	}
 	
 	return c.Status(fiber.StatusOK).JSON(videos)
@@ -509,9 +1478,9 @@ func (h *VideoHandler) GetTrendingVideos(c *fiber.Ctx) error {
 
 // ReprocessVideos manually triggers reprocessing of videos that failed GridFS upload
 func (h *VideoHandler) ReprocessVideos(c *fiber.Ctx) error {
-	err := h.videoService.ReprocessFailedVideos(c.Context())
+	err := h.videoService.ReprocessFailedVideos(c.UserContext())
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to reprocess videos"})
+		return apperr.Internal("Failed to reprocess videos")
 	}
 	
 	return c.JSON(fiber.Map{"message": "Video reprocessing completed"})
@@ -519,10 +1488,347 @@ func (h *VideoHandler) ReprocessVideos(c *fiber.Ctx) error {
 
 // MigrateVideoFields fixes database field naming inconsistencies
 func (h *VideoHandler) MigrateVideoFields(c *fiber.Ctx) error {
-	err := h.videoService.MigrateVideoFieldNames(c.Context())
+	err := h.videoService.MigrateVideoFieldNames(c.UserContext())
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to migrate video fields"})
+		return apperr.Internal("Failed to migrate video fields")
 	}
 	
 	return c.JSON(fiber.Map{"message": "Video field migration completed"})
-}
\ No newline at end of file
+}
+// LikeVideo records a like for the authenticated user
+func (h *VideoHandler) LikeVideo(c *fiber.Ctx) error {
+	return h.setLike(c, true)
+}
+
+// UnlikeVideo removes the authenticated user's like
+func (h *VideoHandler) UnlikeVideo(c *fiber.Ctx) error {
+	return h.setLike(c, false)
+}
+
+func (h *VideoHandler) setLike(c *fiber.Ctx, liked bool) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+
+	if liked {
+		err = h.videoService.LikeVideo(c.UserContext(), videoID, userID)
+	} else {
+		err = h.videoService.UnlikeVideo(c.UserContext(), videoID, userID)
+	}
+	if err != nil {
+		if err.Error() == "video not found" {
+			return apperr.NotFound("Video not found")
+		}
+		return apperr.Internal("Failed to update like")
+	}
+
+	video, err := h.videoService.GetVideoByID(c.UserContext(), videoID, requesterID(c))
+	if err != nil {
+		return apperr.NotFound("Video not found")
+	}
+
+	return c.JSON(fiber.Map{
+		"liked":      liked,
+		"like_count": video.LikeCount,
+	})
+}
+
+// GetLikeStatus reports whether the authenticated user has liked the video
+func (h *VideoHandler) GetLikeStatus(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+
+	video, err := h.videoService.GetVideoByID(c.UserContext(), videoID, requesterID(c))
+	if err != nil {
+		return apperr.NotFound("Video not found")
+	}
+
+	liked, err := h.videoService.HasUserLiked(c.UserContext(), videoID, userID)
+	if err != nil {
+		return apperr.Internal("Failed to get like status")
+	}
+
+	return c.JSON(fiber.Map{
+		"liked":      liked,
+		"like_count": video.LikeCount,
+	})
+}
+
+// CreatePlaylistRequest defines the body for creating a playlist
+type CreatePlaylistRequest struct {
+	Name string `json:"name"`
+}
+
+// AddToPlaylistRequest defines the body for adding a video to a playlist
+type AddToPlaylistRequest struct {
+	VideoID string `json:"video_id"`
+}
+
+// CreatePlaylist creates a playlist for the authenticated user
+func (h *VideoHandler) CreatePlaylist(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	var req CreatePlaylistRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.Validation("Invalid request body")
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		return apperr.Validation("Playlist name is required")
+	}
+
+	playlist, err := h.videoService.CreatePlaylist(c.UserContext(), userID, req.Name)
+	if err != nil {
+		return apperr.Internal("Failed to create playlist")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(playlist)
+}
+
+// ListPlaylists lists the authenticated user's playlists
+func (h *VideoHandler) ListPlaylists(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	playlists, err := h.videoService.ListUserPlaylists(c.UserContext(), userID)
+	if err != nil {
+		return apperr.Internal("Failed to list playlists")
+	}
+
+	return c.JSON(playlists)
+}
+
+// GetPlaylist returns a playlist with its videos
+func (h *VideoHandler) GetPlaylist(c *fiber.Ctx) error {
+	playlistID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid playlist ID")
+	}
+
+	playlist, err := h.videoService.GetPlaylist(c.UserContext(), playlistID, requesterID(c))
+	if err != nil {
+		return playlistError(c, err)
+	}
+
+	return c.JSON(playlist)
+}
+
+// AddToPlaylist adds a video to one of the authenticated user's playlists
+func (h *VideoHandler) AddToPlaylist(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	playlistID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid playlist ID")
+	}
+
+	var req AddToPlaylistRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.Validation("Invalid request body")
+	}
+	videoID, err := primitive.ObjectIDFromHex(req.VideoID)
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+
+	if err := h.videoService.AddToPlaylist(c.UserContext(), playlistID, userID, videoID); err != nil {
+		return playlistError(c, err)
+	}
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// RemoveFromPlaylist removes a video from one of the authenticated user's playlists
+func (h *VideoHandler) RemoveFromPlaylist(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	playlistID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid playlist ID")
+	}
+	videoID, err := primitive.ObjectIDFromHex(c.Params("videoId"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+
+	if err := h.videoService.RemoveFromPlaylist(c.UserContext(), playlistID, userID, videoID); err != nil {
+		return playlistError(c, err)
+	}
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// playlistError maps playlist service errors to HTTP responses
+func playlistError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, ErrPlaylistNotFound):
+		return apperr.NotFound("Playlist not found")
+	case errors.Is(err, ErrPlaylistForbidden):
+		return apperr.Forbidden("You can only modify your own playlists")
+	case errors.Is(err, ErrVideoAlreadyInPlaylist):
+		return apperr.Conflict("Video is already in the playlist")
+	case err.Error() == "video not found":
+		return apperr.NotFound("Video not found")
+	}
+	return apperr.Internal("Failed to update playlist")
+}
+
+// WatchProgressRequest defines the body for recording playback progress
+type WatchProgressRequest struct {
+	Position float64 `json:"position"`
+}
+
+// RecordWatchProgress stores the authenticated user's playback position for a video
+func (h *VideoHandler) RecordWatchProgress(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+
+	var req WatchProgressRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.Validation("Invalid request body")
+	}
+
+	entry, err := h.videoService.RecordWatchProgress(c.UserContext(), userID, videoID, req.Position)
+	if err != nil {
+		if err.Error() == "video not found" {
+			return apperr.NotFound("Video not found")
+		}
+		return apperr.Internal("Failed to record progress")
+	}
+
+	return c.JSON(entry)
+}
+
+// GetWatchHistory returns the authenticated user's watch history
+func (h *VideoHandler) GetWatchHistory(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	history, err := h.videoService.GetWatchHistory(c.UserContext(), userID, limit)
+	if err != nil {
+		return apperr.Internal("Failed to get watch history")
+	}
+
+	return c.JSON(history)
+}
+
+// ThumbnailCandidate describes a selectable thumbnail
+type ThumbnailCandidate struct {
+	Index  int    `json:"index"`
+	URL    string `json:"url"`
+	Active bool   `json:"active"`
+}
+
+// SetThumbnailRequest defines the body for selecting a thumbnail candidate
+type SetThumbnailRequest struct {
+	Index *int `json:"index"`
+}
+
+// ListThumbnails lists the thumbnail candidates for a video
+func (h *VideoHandler) ListThumbnails(c *fiber.Ctx) error {
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+
+	video, err := h.videoService.GetVideoByID(c.UserContext(), videoID, requesterID(c))
+	if err != nil {
+		return apperr.NotFound("Video not found")
+	}
+
+	candidates := make([]ThumbnailCandidate, 0, len(video.ThumbnailCandidates))
+	for i, path := range video.ThumbnailCandidates {
+		candidates = append(candidates, ThumbnailCandidate{
+			Index:  i,
+			URL:    fmt.Sprintf("/thumbnail/%s?candidate=%d", video.ID.Hex(), i),
+			Active: path == video.ThumbnailPath,
+		})
+	}
+
+	return c.JSON(fiber.Map{"thumbnails": candidates})
+}
+
+// SetThumbnail selects one of the thumbnail candidates as the video's thumbnail
+func (h *VideoHandler) SetThumbnail(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	videoID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return apperr.Validation("Invalid video ID")
+	}
+
+	var req SetThumbnailRequest
+	if err := c.BodyParser(&req); err != nil || req.Index == nil {
+		return apperr.Validation("Thumbnail index is required")
+	}
+
+	video, err := h.videoService.GetVideoByID(c.UserContext(), videoID, requesterID(c))
+	if err != nil {
+		return apperr.NotFound("Video not found")
+	}
+	if video.UserID != userID {
+		return apperr.Forbidden("You can only change thumbnails of your own videos")
+	}
+
+	updated, err := h.videoService.SetThumbnail(c.UserContext(), videoID, *req.Index)
+	if err != nil {
+		if errors.Is(err, ErrThumbnailIndexOutOfRange) {
+			return apperr.Validation("Thumbnail index out of range")
+		}
+		return apperr.Internal("Failed to set thumbnail")
+	}
+
+	return c.JSON(updated)
+}
+
+// SetUploadLimiter caps the uploads the handler receives at once
+func (h *VideoHandler) SetUploadLimiter(l *uploadlimit.Limiter) {
+	h.uploadLimiter = l
+}
+
+// LimitUploads runs the upload handler after it only while a slot of the upload limiter
+// is free, responding 503 with a Retry-After header otherwise. The body has already been
+// read by then, so this bounds the work uploads cause, not the memory they take.
+func (h *VideoHandler) LimitUploads(c *fiber.Ctx) error {
+	return h.uploadLimiter.Handler(c)
+}
@@ -0,0 +1,126 @@
+package video
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"streamflow/internal/logger"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// DefaultIdempotencyWindow is how long an idempotency key is remembered when the
+	// configuration doesn't say
+	DefaultIdempotencyWindow = 24 * time.Hour
+	// MaxIdempotencyKeyLength bounds the length of an Idempotency-Key header
+	MaxIdempotencyKeyLength = 255
+	// pendingIdempotencyTTL is how long a key stays reserved while its first request is
+	// still creating the video. It is short so a request that died without releasing the
+	// key, such as on a crash, blocks retries only briefly. The full idempotency window
+	// starts once the video exists.
+	pendingIdempotencyTTL = 15 * time.Minute
+)
+
+var (
+	// ErrInvalidIdempotencyKey is returned for an empty or overlong idempotency key
+	ErrInvalidIdempotencyKey = fmt.Errorf("idempotency key must be 1 to %d characters", MaxIdempotencyKeyLength)
+	// ErrIdempotencyKeyInUse is returned while another request with the same key is still
+	// creating its video
+	ErrIdempotencyKeyInUse = errors.New("a request with this idempotency key is still in progress")
+)
+
+// idempotencyRecord remembers which video a user's idempotency key created. VideoID is
+// unset while the first request with the key is still running.
+type idempotencyRecord struct {
+	ID        primitive.ObjectID  `bson:"_id"`
+	UserID    primitive.ObjectID  `bson:"user_id"`
+	Key       string              `bson:"key"`
+	VideoID   *primitive.ObjectID `bson:"video_id"`
+	ExpiresAt time.Time           `bson:"expires_at"`
+	CreatedAt time.Time           `bson:"created_at"`
+}
+
+// CreateVideoIdempotent runs create once per user and key within the idempotency window.
+// A repeated key returns the video the first call created, with replayed set, rather than
+// calling create again. If create fails the key is released so the request can be retried.
+func (s *VideoService) CreateVideoIdempotent(ctx context.Context, userID primitive.ObjectID, key string, create func(context.Context) (*Video, error)) (video *Video, replayed bool, err error) {
+	if key == "" || len(key) > MaxIdempotencyKeyLength {
+		return nil, false, ErrInvalidIdempotencyKey
+	}
+
+	existing, err := s.reserveIdempotencyKey(ctx, userID, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing != nil {
+		if existing.VideoID == nil {
+			return nil, false, ErrIdempotencyKeyInUse
+		}
+		video, err := s.GetVideo(ctx, *existing.VideoID, true)
+		if err != nil {
+			return nil, false, err
+		}
+		return video, true, nil
+	}
+
+	filter := bson.M{"user_id": userID, "key": key}
+	video, err = create(ctx)
+	if err != nil {
+		// Release the key with a fresh context, the request's may be what failed
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, delErr := s.idempotencyCollection.DeleteOne(releaseCtx, filter); delErr != nil {
+			logger.FromContext(ctx).Error("failed to release idempotency key", "user_id", userID.Hex(), "error", delErr)
+		}
+		return nil, false, err
+	}
+
+	update := bson.M{"$set": bson.M{"video_id": video.ID, "expires_at": time.Now().Add(s.idempotencyWindow)}}
+	if _, err := s.idempotencyCollection.UpdateOne(ctx, filter, update); err != nil {
+		return nil, false, fmt.Errorf("failed to record idempotency key: %w", err)
+	}
+	return video, false, nil
+}
+
+// reserveIdempotencyKey claims the key for a new request until pendingIdempotencyTTL
+// passes. If the key is already taken the existing record is returned instead. Records
+// past their expiry that MongoDB hasn't removed yet are replaced.
+func (s *VideoService) reserveIdempotencyKey(ctx context.Context, userID primitive.ObjectID, key string) (*idempotencyRecord, error) {
+	now := time.Now()
+	record := idempotencyRecord{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Key:       key,
+		ExpiresAt: now.Add(min(pendingIdempotencyTTL, s.idempotencyWindow)),
+		CreatedAt: now,
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		_, err := s.idempotencyCollection.InsertOne(ctx, record)
+		if err == nil {
+			return nil, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+		}
+
+		var existing idempotencyRecord
+		err = s.idempotencyCollection.FindOne(ctx, bson.M{"user_id": userID, "key": key}).Decode(&existing)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue // Released or expired in the meantime
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up idempotency key: %w", err)
+		}
+		if now.Before(existing.ExpiresAt) {
+			return &existing, nil
+		}
+		s.idempotencyCollection.DeleteOne(ctx, bson.M{"_id": existing.ID})
+	}
+	return nil, ErrIdempotencyKeyInUse
+}
@@ -0,0 +1,440 @@
+package video
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"streamflow/internal/config"
+	"streamflow/internal/lifecycle"
+	"streamflow/internal/logger"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// JobStatus is the state of a processing job
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "QUEUED"
+	JobRunning   JobStatus = "RUNNING"
+	JobCompleted JobStatus = "COMPLETED"
+	JobFailed    JobStatus = "FAILED"
+)
+
+var (
+	// ErrJobNotFound is returned when a video has no processing job, e.g. a livestream recording
+	ErrJobNotFound = errors.New("processing job not found")
+	// ErrProcessingActive is returned when retrying a video whose job is queued or running
+	ErrProcessingActive = errors.New("video is already queued for processing")
+	// ErrNotRetryable is returned when retrying the processing of a completed video
+	ErrNotRetryable = errors.New("only failed or stuck videos can be processed again")
+
+	// errLeaseLost is returned when a worker updates a job that was claimed again after its
+	// lease ran out
+	errLeaseLost = errors.New("lease of processing job was lost")
+)
+
+// ProcessingJob turns an uploaded video into a playable one. Jobs are stored so they
+// survive restarts and can be picked up by any instance.
+type ProcessingJob struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"ID"`
+	VideoID     primitive.ObjectID `bson:"video_id" json:"VideoID"`
+	UserID      primitive.ObjectID `bson:"user_id" json:"UserID"`
+	SourcePath  string             `bson:"source_path" json:"-"` // Local copy of the upload; fetched from storage again if missing
+	Status      JobStatus          `bson:"status" json:"Status"`
+	Attempts    int                `bson:"attempts" json:"Attempts"`
+	MaxAttempts int                `bson:"max_attempts" json:"MaxAttempts"`
+	LastError   string             `bson:"last_error,omitempty" json:"LastError,omitempty"`
+	RunAt       time.Time          `bson:"run_at" json:"RunAt"`             // Not claimed before this time
+	LockedUntil *time.Time         `bson:"locked_until,omitempty" json:"-"` // Lease of the worker running the job
+	LeaseID     primitive.ObjectID `bson:"lease_id,omitempty" json:"-"`     // Identifies the claim holding the lease
+	CreatedAt   time.Time          `bson:"created_at" json:"CreatedAt"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"UpdatedAt"`
+	CompletedAt *time.Time         `bson:"completed_at,omitempty" json:"CompletedAt,omitempty"`
+}
+
+// LastAttempt reports whether a failure of the current attempt fails the job for good
+func (j *ProcessingJob) LastAttempt() bool {
+	return j.Attempts >= j.MaxAttempts
+}
+
+// active reports whether the job is still to be run by the queue. A running job whose
+// worker died is claimed again once its lease runs out, so it counts as active.
+func (j *ProcessingJob) active() bool {
+	return j.Status == JobQueued || j.Status == JobRunning
+}
+
+// ProcessFunc runs a claimed job. An error schedules a retry unless it was the last attempt.
+type ProcessFunc func(ctx context.Context, job *ProcessingJob) error
+
+// FailFunc is called once a job has failed its last attempt
+type FailFunc func(ctx context.Context, job *ProcessingJob, err error)
+
+// ProcessingQueue runs jobs stored in a collection on a pool of workers. Jobs are claimed
+// atomically, so several instances can share one queue. A claimed job is leased to its
+// worker, which keeps renewing the lease while it runs; a job whose lease ran out, because
+// its worker died, is claimed again.
+//
+// The queue also relays the progress of the videos its workers process to subscribers on
+// the same instance.
+type ProcessingQueue struct {
+	jobs    *mongo.Collection
+	cfg     config.ProcessingConfig
+	process ProcessFunc
+	failed  FailFunc
+	wake    chan struct{}
+	workers sync.WaitGroup
+
+	subscribers map[primitive.ObjectID]map[chan ProcessingStatus]struct{} // Guarded by subsMu
+	subsClosed  bool                                                      // Set once subscriptions are closed
+	subsMu      sync.Mutex
+}
+
+// NewProcessingQueue creates a queue over the jobs collection. Zero settings in cfg fall
+// back to defaults.
+func NewProcessingQueue(jobs *mongo.Collection, cfg config.ProcessingConfig, process ProcessFunc, failed FailFunc) *ProcessingQueue {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 3
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = 30 * time.Second
+	}
+	if cfg.MaxRetryBackoff < cfg.RetryBackoff {
+		cfg.MaxRetryBackoff = max(10*time.Minute, cfg.RetryBackoff)
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 5 * time.Second
+	}
+	if cfg.JobLease <= 0 {
+		cfg.JobLease = 2 * time.Minute
+	}
+
+	q := &ProcessingQueue{
+		jobs:    jobs,
+		cfg:     cfg,
+		process: process,
+		failed:  failed,
+		wake:    make(chan struct{}, 1),
+
+		subscribers: make(map[primitive.ObjectID]map[chan ProcessingStatus]struct{}),
+	}
+	q.createIndexes()
+	return q
+}
+
+// createIndexes creates the indexes used to claim jobs and look them up by video
+func (q *ProcessingQueue) createIndexes() {
+	q.jobs.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "run_at", Value: 1}}},
+		{Keys: bson.D{{Key: "video_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+}
+
+// Enqueue queues a job processing the video from sourcePath and wakes an idle worker
+func (q *ProcessingQueue) Enqueue(ctx context.Context, videoID, userID primitive.ObjectID, sourcePath string) (*ProcessingJob, error) {
+	now := time.Now()
+	job := &ProcessingJob{
+		ID:          primitive.NewObjectID(),
+		VideoID:     videoID,
+		UserID:      userID,
+		SourcePath:  sourcePath,
+		Status:      JobQueued,
+		MaxAttempts: q.cfg.MaxAttempts,
+		RunAt:       now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if _, err := q.jobs.InsertOne(ctx, job); err != nil {
+		return nil, fmt.Errorf("failed to queue processing job: %w", err)
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// GetJob returns the most recent job of a video
+func (q *ProcessingQueue) GetJob(ctx context.Context, videoID primitive.ObjectID) (*ProcessingJob, error) {
+	var job ProcessingJob
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if err := q.jobs.FindOne(ctx, bson.M{"video_id": videoID}, opts).Decode(&job); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Start runs the configured number of workers until ctx is done. A job that is running
+// then is not interrupted; Wait waits for it.
+func (q *ProcessingQueue) Start(ctx context.Context) {
+	for i := 0; i < q.cfg.Workers; i++ {
+		q.workers.Add(1)
+		go func() {
+			defer q.workers.Done()
+			q.work(ctx)
+		}()
+	}
+}
+
+// Wait waits for the workers to stop, until ctx is done. Jobs still running then keep
+// their lease until it runs out and are picked up again by the next worker.
+func (q *ProcessingQueue) Wait(ctx context.Context) error {
+	return lifecycle.Wait(ctx, &q.workers)
+}
+
+// work claims and runs jobs until ctx is done, sleeping while the queue is empty
+func (q *ProcessingQueue) work(ctx context.Context) {
+	ticker := time.NewTicker(q.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Drain the queue before waiting again
+		for ctx.Err() == nil {
+			job, err := q.claim(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.FromContext(ctx).Error("failed to claim processing job", "error", err)
+				}
+				break
+			}
+			if job == nil {
+				break
+			}
+			q.run(ctx, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-q.wake:
+		}
+	}
+}
+
+// claim leases the next due job to the caller, returning nil if there is none
+func (q *ProcessingQueue) claim(ctx context.Context) (*ProcessingJob, error) {
+	now := time.Now()
+	filter := bson.M{"$or": []bson.M{
+		{"status": JobQueued, "run_at": bson.M{"$lte": now}},
+		{"status": JobRunning, "locked_until": bson.M{"$lt": now}},
+	}}
+	update := bson.M{
+		"$set": bson.M{
+			"status":       JobRunning,
+			"locked_until": now.Add(q.cfg.JobLease),
+			"lease_id":     primitive.NewObjectID(),
+			"updated_at":   now,
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "run_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var job ProcessingJob
+	if err := q.jobs.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// run processes a claimed job and records the outcome. The job isn't cancelled when ctx
+// is done, so shutting down lets it finish.
+func (q *ProcessingQueue) run(ctx context.Context, job *ProcessingJob) {
+	jobCtx, stop := context.WithCancel(context.WithoutCancel(ctx))
+	defer stop()
+
+	var err error
+	if job.Attempts > job.MaxAttempts {
+		// Claimed again after the worker of its last attempt died
+		err = errors.New("worker stopped during the last attempt")
+	} else {
+		go q.renewLease(jobCtx, job)
+		err = q.process(jobCtx, job)
+		stop()
+	}
+
+	// Record the outcome even though ctx may be done
+	ctx = context.WithoutCancel(ctx)
+	if err == nil {
+		if err := q.complete(ctx, job); err != nil {
+			logger.FromContext(ctx).Error("failed to complete processing job", "job_id", job.ID.Hex(), "error", err)
+		}
+		return
+	}
+
+	logger.FromContext(ctx).Warn("processing job failed", "job_id", job.ID.Hex(), "video_id", job.VideoID.Hex(),
+		"attempt", job.Attempts, "max_attempts", job.MaxAttempts, "error", err)
+	if err := q.retryOrFail(ctx, job, err); err != nil {
+		logger.FromContext(ctx).Error("failed to record failure of processing job", "job_id", job.ID.Hex(), "error", err)
+	}
+}
+
+// renewLease extends the job's lease until ctx is done or the lease is lost
+func (q *ProcessingQueue) renewLease(ctx context.Context, job *ProcessingJob) {
+	ticker := time.NewTicker(q.cfg.JobLease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		result, err := q.jobs.UpdateOne(ctx, q.leaseFilter(job),
+			bson.M{"$set": bson.M{"locked_until": time.Now().Add(q.cfg.JobLease)}})
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.FromContext(ctx).Error("failed to renew lease of processing job", "job_id", job.ID.Hex(), "error", err)
+			}
+			continue
+		}
+		if result.MatchedCount == 0 {
+			logger.FromContext(ctx).Warn("lost lease of processing job", "job_id", job.ID.Hex())
+			return
+		}
+	}
+}
+
+// leaseFilter matches the job only while the caller's claim still holds its lease. A job
+// claimed again after its lease ran out belongs to the new claim, so updates of the old
+// worker must not touch it.
+func (q *ProcessingQueue) leaseFilter(job *ProcessingJob) bson.M {
+	return bson.M{"_id": job.ID, "status": JobRunning, "lease_id": job.LeaseID}
+}
+
+// complete marks the job completed
+func (q *ProcessingQueue) complete(ctx context.Context, job *ProcessingJob) error {
+	now := time.Now()
+	result, err := q.jobs.UpdateOne(ctx, q.leaseFilter(job), bson.M{
+		"$set": bson.M{
+			"status":       JobCompleted,
+			"completed_at": now,
+			"updated_at":   now,
+			"last_error":   "",
+		},
+		"$unset": bson.M{"locked_until": "", "lease_id": ""},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errLeaseLost
+	}
+	return nil
+}
+
+// retryOrFail queues the job again after a backoff, or fails it for good after its last attempt
+func (q *ProcessingQueue) retryOrFail(ctx context.Context, job *ProcessingJob, cause error) error {
+	set := bson.M{
+		"last_error": cause.Error(),
+		"updated_at": time.Now(),
+	}
+	if job.LastAttempt() {
+		set["status"] = JobFailed
+	} else {
+		set["status"] = JobQueued
+		set["run_at"] = time.Now().Add(q.retryDelay(job.Attempts))
+	}
+
+	result, err := q.jobs.UpdateOne(ctx, q.leaseFilter(job), bson.M{
+		"$set":   set,
+		"$unset": bson.M{"locked_until": "", "lease_id": ""},
+	})
+	if err == nil && result.MatchedCount == 0 {
+		// The job's next claim records the outcome instead
+		return errLeaseLost
+	}
+	if job.LastAttempt() && q.failed != nil {
+		q.failed(ctx, job, cause)
+	}
+	return err
+}
+
+// retryDelay is the backoff after the given failed attempt, doubling from RetryBackoff
+// up to MaxRetryBackoff
+func (q *ProcessingQueue) retryDelay(attempt int) time.Duration {
+	delay := q.cfg.RetryBackoff
+	for i := 1; i < attempt && delay < q.cfg.MaxRetryBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, q.cfg.MaxRetryBackoff)
+}
+
+// Subscribe returns a channel receiving the processing updates of a video and a function
+// ending the subscription. Each update carries the full status, so a subscriber that falls
+// behind only gets the latest one. The channel is closed when the subscription ends or
+// the queue's subscriptions are closed.
+func (q *ProcessingQueue) Subscribe(videoID primitive.ObjectID) (<-chan ProcessingStatus, func()) {
+	ch := make(chan ProcessingStatus, 1)
+
+	q.subsMu.Lock()
+	defer q.subsMu.Unlock()
+	if q.subsClosed {
+		close(ch)
+		return ch, func() {}
+	}
+	if q.subscribers[videoID] == nil {
+		q.subscribers[videoID] = make(map[chan ProcessingStatus]struct{})
+	}
+	q.subscribers[videoID][ch] = struct{}{}
+
+	unsubscribe := func() {
+		q.subsMu.Lock()
+		defer q.subsMu.Unlock()
+		if _, ok := q.subscribers[videoID][ch]; !ok {
+			return // Already closed
+		}
+		delete(q.subscribers[videoID], ch)
+		if len(q.subscribers[videoID]) == 0 {
+			delete(q.subscribers, videoID)
+		}
+		close(ch)
+	}
+	return ch, unsubscribe
+}
+
+// Publish sends a processing update to the subscribers of its video without blocking,
+// replacing any update they haven't received yet
+func (q *ProcessingQueue) Publish(status ProcessingStatus) {
+	q.subsMu.Lock()
+	defer q.subsMu.Unlock()
+
+	for ch := range q.subscribers[status.VideoID] {
+		select {
+		case <-ch: // Drop the stale update
+		default:
+		}
+		ch <- status
+	}
+}
+
+// CloseSubscriptions ends every subscription and refuses new ones, so long-lived
+// connections waiting for updates don't hold up shutdown
+func (q *ProcessingQueue) CloseSubscriptions() {
+	q.subsMu.Lock()
+	defer q.subsMu.Unlock()
+
+	q.subsClosed = true
+	for videoID, subs := range q.subscribers {
+		for ch := range subs {
+			close(ch)
+		}
+		delete(q.subscribers, videoID)
+	}
+}
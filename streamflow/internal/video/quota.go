@@ -0,0 +1,117 @@
+package video
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrQuotaExceeded is returned when an upload would take a user over their storage quota
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// ErrVideoLimitReached is returned when a user already has as many videos as allowed
+var ErrVideoLimitReached = errors.New("video limit reached")
+
+// SetStorageQuota limits how many bytes of video each user may store. Zero, the default,
+// means no limit.
+func (s *VideoService) SetStorageQuota(bytes int64) {
+	s.storageQuota = bytes
+}
+
+// SetMaxVideos limits how many videos each user may have. Zero, the default, means no
+// limit.
+func (s *VideoService) SetMaxVideos(max int) {
+	s.maxVideos = max
+}
+
+// ValidateUpload checks the description of a file the user intends to upload the way
+// the upload itself would be checked, including the configured limits
+func (s *VideoService) ValidateUpload(ctx context.Context, userID primitive.ObjectID, filename string, size int64, contentType string) error {
+	if err := ValidateVideoUpload(filename, size, contentType); err != nil {
+		return err
+	}
+	return s.CheckUploadLimits(ctx, userID, size)
+}
+
+// CheckUploadLimits checks a new upload of size bytes against the configured file size
+// limit, the user's storage quota and how many videos they may have. Uploads and their
+// dry run both go through it, so they accept the same files.
+func (s *VideoService) CheckUploadLimits(ctx context.Context, userID primitive.ObjectID, size int64) error {
+	if s.maxFileSize > 0 && size > s.maxFileSize {
+		return fmt.Errorf("%w: file is %d bytes, the limit is %d bytes", ErrUploadTooLarge, size, s.maxFileSize)
+	}
+	if err := s.CheckStorageQuota(ctx, userID, size); err != nil {
+		return err
+	}
+	return s.checkVideoCount(ctx, userID, primitive.NilObjectID)
+}
+
+// checkVideoCount returns ErrVideoLimitReached if the user can't have another video.
+// A video being replaced is passed as replacing, so it doesn't count against its own
+// replacement.
+func (s *VideoService) checkVideoCount(ctx context.Context, userID, replacing primitive.ObjectID) error {
+	if s.maxVideos <= 0 {
+		return nil
+	}
+
+	filter := bson.M{"user_id": userID, "deleted_at": nil}
+	if !replacing.IsZero() {
+		filter["_id"] = bson.M{"$ne": replacing}
+	}
+	count, err := s.videoCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("failed to count videos: %w", err)
+	}
+	if count >= int64(s.maxVideos) {
+		return fmt.Errorf("%w: %d of %d videos", ErrVideoLimitReached, count, s.maxVideos)
+	}
+	return nil
+}
+
+// GetUserStorageUsage returns the total size in bytes of the user's videos. Deleted videos
+// don't count, so deleting a video frees its space straight away.
+func (s *VideoService) GetUserStorageUsage(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": userID, "deleted_at": nil}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$metadata.file_size"}}}},
+	}
+
+	cursor, err := s.videoCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute storage usage: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Total int64 `bson:"total"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return 0, err
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+
+	return results[0].Total, nil
+}
+
+// CheckStorageQuota returns ErrQuotaExceeded if storing size more bytes would take the
+// user over their quota. Filling the quota exactly is allowed.
+func (s *VideoService) CheckStorageQuota(ctx context.Context, userID primitive.ObjectID, size int64) error {
+	if s.storageQuota <= 0 {
+		return nil
+	}
+
+	used, err := s.GetUserStorageUsage(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if used+size > s.storageQuota {
+		return fmt.Errorf("%w: %d of %d bytes used, upload is %d bytes", ErrQuotaExceeded, used, s.storageQuota, size)
+	}
+	return nil
+}
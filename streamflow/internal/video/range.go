@@ -0,0 +1,142 @@
+package video
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"streamflow/internal/apperr"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+)
+
+var errInvalidRange = errors.New("invalid range")
+
+// byteRange is an inclusive byte window within a file
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+func (r byteRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+// parseRange parses a "bytes=" Range header against the content size. Multi-range
+// requests fall back to their first range.
+func parseRange(header string, size int64) (byteRange, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok {
+		return byteRange{}, errInvalidRange
+	}
+
+	// Only the first range of a multi-range request is served
+	first, _, _ := strings.Cut(spec, ",")
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(first), "-")
+	if !ok {
+		return byteRange{}, errInvalidRange
+	}
+
+	if startStr == "" {
+		// Suffix range: the last N bytes
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return byteRange{}, errInvalidRange
+		}
+		if n > size {
+			n = size
+		}
+		return byteRange{start: size - n, end: size - 1}, nil
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return byteRange{}, errInvalidRange
+	}
+
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return byteRange{}, errInvalidRange
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return byteRange{start: start, end: end}, nil
+}
+
+// serveContent sends content honouring the request's Range header. It takes
+// ownership of content and closes it once the response body has been written.
+func serveContent(c *fiber.Ctx, content io.ReadSeekCloser, size int64, contentType string) error {
+	c.Set("Accept-Ranges", "bytes")
+	c.Set("Content-Type", contentType)
+
+	header := c.Get(fiber.HeaderRange)
+	if header == "" {
+		return c.SendStream(content, int(size))
+	}
+
+	r, err := parseRange(header, size)
+	if err != nil {
+		content.Close()
+		c.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		return apperr.New(apperr.ErrRangeUnsatisfied, "Requested range not satisfiable")
+	}
+
+	if _, err := content.Seek(r.start, io.SeekStart); err != nil {
+		content.Close()
+		return apperr.Internal("Failed to seek video")
+	}
+
+	c.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size))
+	c.Status(fiber.StatusPartialContent)
+
+	// fasthttp closes the body stream once it has been sent
+	body := struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(content, r.length()), content}
+	return c.SendStream(body, int(r.length()))
+}
+
+// gridFSSeeker adapts a GridFS download stream to io.ReadSeekCloser. GridFS
+// streams only move forward, so seeking backwards is not supported.
+type gridFSSeeker struct {
+	stream *gridfs.DownloadStream
+	pos    int64
+}
+
+func (g *gridFSSeeker) Read(p []byte) (int, error) {
+	n, err := g.stream.Read(p)
+	g.pos += int64(n)
+	return n, err
+}
+
+func (g *gridFSSeeker) Seek(offset int64, whence int) (int64, error) {
+	target := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		target = g.pos + offset
+	default:
+		return g.pos, fmt.Errorf("unsupported seek whence %d", whence)
+	}
+
+	if target < g.pos {
+		return g.pos, fmt.Errorf("cannot seek backwards in GridFS stream")
+	}
+
+	skipped, err := g.stream.Skip(target - g.pos)
+	g.pos += skipped
+	return g.pos, err
+}
+
+func (g *gridFSSeeker) Close() error {
+	return g.stream.Close()
+}
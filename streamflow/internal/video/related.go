@@ -0,0 +1,94 @@
+package video
+
+import (
+	"context"
+	"fmt"
+
+	"streamflow/internal/tags"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// MaxVideoTags caps how many tags a video can carry
+	MaxVideoTags = tags.MaxCount
+	// MaxVideoTagLength caps the length of a single tag
+	MaxVideoTagLength = tags.MaxLength
+	// MaxRelatedLimit caps how many related videos can be requested at once
+	MaxRelatedLimit = 50
+)
+
+// GetRelatedVideos suggests videos to watch after the given one: other completed public
+// videos sharing its tags, those sharing the most first and ties broken by view count.
+// When the video has no tags the most viewed videos are suggested instead. The
+// uploader's own videos are left out when excludeUploader is set. Visibility of the
+// source video is enforced for requesterID as in GetVideoByID.
+func (s *VideoService) GetRelatedVideos(ctx context.Context, videoID primitive.ObjectID, limit int, excludeUploader bool, requesterID ...primitive.ObjectID) ([]*Video, error) {
+	if limit < 1 {
+		limit = 10
+	}
+	if limit > MaxRelatedLimit {
+		limit = MaxRelatedLimit
+	}
+
+	source, err := s.GetVideoByID(ctx, videoID, requesterID...)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := publicOnly(bson.M{
+		"_id":        bson.M{"$ne": source.ID},
+		"status":     StatusCompleted,
+		"deleted_at": nil,
+	})
+	if excludeUploader {
+		filter["user_id"] = bson.M{"$ne": source.UserID}
+	}
+
+	if len(source.Tags) == 0 {
+		return s.findVideos(ctx, filter, options.Find().
+			SetSort(bson.D{{Key: "view_count", Value: -1}}).
+			SetLimit(int64(limit)))
+	}
+
+	filter["tags"] = bson.M{"$in": source.Tags}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$addFields", Value: bson.M{
+			"shared_tags": bson.M{"$size": bson.M{"$setIntersection": bson.A{"$tags", source.Tags}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "shared_tags", Value: -1}, {Key: "view_count", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$project", Value: bson.M{"shared_tags": 0}}},
+	}
+
+	cursor, err := s.videoCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find related videos: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	videos := []*Video{}
+	if err := cursor.All(ctx, &videos); err != nil {
+		return nil, err
+	}
+	return videos, nil
+}
+
+// findVideos runs a find query, returning an empty list rather than nil when nothing matches
+func (s *VideoService) findVideos(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]*Video, error) {
+	cursor, err := s.videoCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find videos: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	videos := []*Video{}
+	if err := cursor.All(ctx, &videos); err != nil {
+		return nil, err
+	}
+	return videos, nil
+}
@@ -0,0 +1,154 @@
+package video
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"streamflow/internal/logger"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidVideoFile is returned when a replacement file can't be read as a video. The
+// video keeps its current file.
+var ErrInvalidVideoFile = errors.New("invalid video file")
+
+// ReplaceVideoFile swaps the file of a video owned by ownerID for a new upload, keeping
+// its ID, views, likes and comments. The new file is probed and validated before the
+// stored one is touched, so a bad replacement leaves the video as it was. The old
+// thumbnails and HLS output are dropped and the video is processed again from the new
+// file. ErrProcessingActive is returned while the video is queued or being processed.
+func (s *VideoService) ReplaceVideoFile(ctx context.Context, videoID, ownerID primitive.ObjectID, file io.Reader) (*Video, error) {
+	video, err := s.getOwnedVideo(ctx, videoID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	job, err := s.queue.GetJob(ctx, videoID)
+	if err != nil && !errors.Is(err, ErrJobNotFound) {
+		return nil, fmt.Errorf("failed to look up processing job: %w", err)
+	}
+	if job != nil && job.active() {
+		return nil, ErrProcessingActive
+	}
+
+	// Nothing is processing the video, so its temporary copy is free to hold the new file
+	tempFilePath := fmt.Sprintf("%s/%s_temp.mp4", uploadDir, videoID.Hex())
+	metadata, err := s.receiveReplacement(ctx, file, tempFilePath)
+	if err != nil {
+		CleanupFailedUpload(tempFilePath)
+		return nil, err
+	}
+	if err := s.CheckStorageQuota(ctx, ownerID, metadata.FileSize-video.Metadata.FileSize); err != nil {
+		CleanupFailedUpload(tempFilePath)
+		return nil, err
+	}
+	if err := s.checkVideoCount(ctx, ownerID, videoID); err != nil {
+		CleanupFailedUpload(tempFilePath)
+		return nil, err
+	}
+
+	// The new file gets a key of its own, so the old one survives until the video points
+	// at the new file
+	key, err := s.storeReplacement(ctx, videoID, tempFilePath)
+	if err != nil {
+		CleanupFailedUpload(tempFilePath)
+		return nil, err
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"file_path":           key,
+			"metadata":            metadata,
+			"status":              StatusProcessing,
+			"processing_progress": 0,
+			"hls_path":            "",
+			"thumbnail_path":      "",
+			"updated_at":          time.Now(),
+		},
+		"$unset": bson.M{"error": "", "renditions": "", "thumbnail_candidates": ""},
+	}
+	var replaced Video
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := s.videoCollection.FindOneAndUpdate(ctx, bson.M{"_id": videoID}, update, opts).Decode(&replaced); err != nil {
+		CleanupFailedUpload(tempFilePath)
+		if err := s.storage.Delete(ctx, key); err != nil {
+			logger.FromContext(ctx).Error("failed to delete unused replacement file", "key", key, "error", err)
+		}
+		return nil, fmt.Errorf("failed to update video: %w", err)
+	}
+
+	// The record no longer points at the old file and output, so they can go
+	if err := s.storage.Delete(ctx, video.FilePath); err != nil && !errors.Is(err, ErrFileNotFound) {
+		logger.FromContext(ctx).Error("failed to delete replaced file", "video_id", videoID.Hex(), "key", video.FilePath, "error", err)
+	}
+	s.deleteThumbnails(video)
+	s.deleteHLSFiles(ctx, videoID)
+
+	if _, err := s.queue.Enqueue(ctx, videoID, ownerID, tempFilePath); err != nil {
+		return nil, s.failUpload(ctx, &replaced, tempFilePath, err)
+	}
+	logger.FromContext(ctx).Info("replaced video file, queued for processing", "video_id", videoID.Hex())
+	return &replaced, nil
+}
+
+// receiveReplacement writes a replacement upload to path and probes and validates it
+func (s *VideoService) receiveReplacement(ctx context.Context, file io.Reader, path string) (*VideoMetadata, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	tempFile, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	_, err = io.Copy(tempFile, file)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	metadata, err := s.ffmpeg.ProbeMetadata(ctx, path)
+	if err != nil {
+		if errors.Is(err, ErrFFprobeUnavailable) {
+			return nil, fmt.Errorf("cannot read video metadata: %w", err)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidVideoFile, err)
+	}
+	if err := DetectCorruptVideo(path); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidVideoFile, err)
+	}
+	if err := ValidateVideoMetadata(metadata); err != nil {
+		var vErr ValidationError
+		if errors.As(err, &vErr) && vErr.Field == "duration" {
+			return nil, fmt.Errorf("%w: %s", ErrVideoTooLong, vErr.Message)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidVideoFile, err)
+	}
+	return metadata, nil
+}
+
+// storeReplacement saves the validated file at path under a new storage key of the video
+// and returns the key. The video's current file is left alone.
+func (s *VideoService) storeReplacement(ctx context.Context, videoID primitive.ObjectID, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open temporary file: %w", err)
+	}
+	defer file.Close()
+
+	key := fmt.Sprintf("%s_%s.mp4", videoID.Hex(), primitive.NewObjectID().Hex())
+	if err := s.storage.Save(ctx, key, file); err != nil {
+		// A partly written file may be left behind
+		s.storage.Delete(ctx, key)
+		return "", fmt.Errorf("failed to save file to storage: %w", err)
+	}
+	return key, nil
+}
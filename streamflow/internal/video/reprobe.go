@@ -0,0 +1,167 @@
+package video
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"streamflow/internal/logger"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Metadata stored for videos created before uploads were probed with ffprobe. Videos
+// still carrying it are picked up by a metadata re-probe.
+const (
+	placeholderDuration = 120.0
+	placeholderFileSize = 50000000
+)
+
+// ErrReprobeRunning is returned when a metadata re-probe is started while one is running
+var ErrReprobeRunning = errors.New("metadata re-probe already running")
+
+// ReprobeJob reports the progress of a metadata re-probe
+type ReprobeJob struct {
+	ID          primitive.ObjectID `json:"ID"`
+	Status      JobStatus          `json:"Status"`
+	Matched     int                `json:"Matched"`     // Videos found with placeholder metadata
+	Reprocessed int                `json:"Reprocessed"` // Videos whose metadata was replaced
+	Missing     int                `json:"Missing"`     // Videos whose original file is gone from storage
+	Failed      int                `json:"Failed"`      // Videos whose file couldn't be probed
+	Error       string             `json:"Error,omitempty"`
+	StartedAt   time.Time          `json:"StartedAt"`
+	CompletedAt *time.Time         `json:"CompletedAt,omitempty"`
+}
+
+// StartMetadataReprobe re-probes, in the background, the original files of all videos
+// that still have placeholder metadata and stores the real metadata. It returns the job,
+// whose progress MetadataReprobeStatus reports. Only one re-probe runs at a time.
+func (s *VideoService) StartMetadataReprobe() (*ReprobeJob, error) {
+	s.reprobeMu.Lock()
+	defer s.reprobeMu.Unlock()
+
+	if s.reprobe != nil && s.reprobe.Status == JobRunning {
+		return nil, ErrReprobeRunning
+	}
+
+	job := &ReprobeJob{ID: primitive.NewObjectID(), Status: JobRunning, StartedAt: time.Now()}
+	s.reprobe = job
+	snapshot := *job
+
+	go func() {
+		err := s.reprobeMetadata(context.Background(), job)
+
+		s.reprobeMu.Lock()
+		defer s.reprobeMu.Unlock()
+		now := time.Now()
+		job.CompletedAt = &now
+		job.Status = JobCompleted
+		if err != nil {
+			job.Status = JobFailed
+			job.Error = err.Error()
+		}
+		slog.Info("metadata re-probe finished", "status", job.Status, "reprocessed", job.Reprocessed,
+			"missing", job.Missing, "failed", job.Failed, "matched", job.Matched)
+	}()
+
+	return &snapshot, nil
+}
+
+// MetadataReprobeStatus returns the progress of the latest metadata re-probe.
+// ErrJobNotFound is returned if none was started.
+func (s *VideoService) MetadataReprobeStatus() (*ReprobeJob, error) {
+	s.reprobeMu.Lock()
+	defer s.reprobeMu.Unlock()
+
+	if s.reprobe == nil {
+		return nil, ErrJobNotFound
+	}
+	snapshot := *s.reprobe
+	return &snapshot, nil
+}
+
+// reprobeMetadata runs a re-probe, counting the outcome of each video on job. Failures of
+// single videos are counted and skipped; an error is returned only when the videos
+// can't be listed.
+func (s *VideoService) reprobeMetadata(ctx context.Context, job *ReprobeJob) error {
+	filter := bson.M{
+		"metadata.duration":  placeholderDuration,
+		"metadata.file_size": placeholderFileSize,
+	}
+	cursor, err := s.videoCollection.Find(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("failed to find videos to re-probe: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var video Video
+		if err := cursor.Decode(&video); err != nil {
+			return fmt.Errorf("failed to decode video: %w", err)
+		}
+
+		err := s.reprobeVideo(ctx, &video)
+
+		s.reprobeMu.Lock()
+		job.Matched++
+		switch {
+		case err == nil:
+			job.Reprocessed++
+		case errors.Is(err, ErrFileNotFound):
+			job.Missing++
+		default:
+			job.Failed++
+			logger.FromContext(ctx).Error("failed to re-probe metadata", "video_id", video.ID.Hex(), "error", err)
+		}
+		s.reprobeMu.Unlock()
+	}
+	return cursor.Err()
+}
+
+// reprobeVideo probes a copy of the video's original file and replaces its metadata
+func (s *VideoService) reprobeVideo(ctx context.Context, video *Video) error {
+	if video.FilePath == "" {
+		return ErrFileNotFound
+	}
+
+	stored, err := s.storage.Open(ctx, video.FilePath)
+	if err != nil {
+		return err
+	}
+	defer stored.Close()
+
+	// ffprobe needs a seekable file, and the storage may be remote
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("reprobe_%s%s", video.ID.Hex(), filepath.Ext(video.FilePath)))
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(path)
+	if _, err := io.Copy(file, stored); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to download original video: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	metadata, err := s.ffmpeg.ProbeMetadata(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.videoCollection.UpdateOne(ctx,
+		bson.M{"_id": video.ID},
+		bson.M{"$set": bson.M{"metadata": metadata, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	return nil
+}
@@ -2,17 +2,22 @@ package video
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
-	"bytes"
+	"streamflow/internal/cache"
+	"streamflow/internal/config"
+	"streamflow/internal/logger"
+	"streamflow/internal/tags"
+	"streamflow/internal/webhooks"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -23,45 +28,248 @@ import (
 
 // UpdateVideoRequest defines the structure for a request to update a video.
 type UpdateVideoRequest struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Visibility  Visibility `json:"visibility"`
+	Tags        []string   `json:"tags"` // Replaces the tags when present; an empty list clears them
 }
 
+// ViewDedupWindow is the time bucket within which repeat views by the same viewer count once
+const ViewDedupWindow = 24 * time.Hour
+
+// MaxTrendingLimit caps how many trending videos can be requested at once
+const MaxTrendingLimit = 50
+
+// MaxTrendingDays caps how many days back trending views are counted
+const MaxTrendingDays = 30
+
+// MaxPopularLimit caps how many popular videos can be requested at once
+const MaxPopularLimit = 50
+
+// ThumbnailCandidateCount is how many selectable thumbnails are generated per upload
+const ThumbnailCandidateCount = 4
+
+var (
+	ErrNotFound  = errors.New("video not found")
+	ErrForbidden = errors.New("video belongs to another user")
+
+	// ErrVideoTooLong is recorded as the failure reason of uploads longer than MaxDuration
+	ErrVideoTooLong = errors.New("exceeds maximum duration")
+
+	ErrThumbnailIndexOutOfRange = errors.New("thumbnail index out of range")
+
+	// ErrBatchTooLarge is returned when a batch delete names more than MaxBatchDelete videos
+	ErrBatchTooLarge = fmt.Errorf("at most %d videos can be deleted at once", MaxBatchDelete)
+
+	// ErrInvalidVisibility is returned for a visibility other than public, unlisted or private
+	ErrInvalidVisibility = errors.New("visibility must be public, unlisted or private")
+
+	ErrPlaylistNotFound       = errors.New("playlist not found")
+	ErrPlaylistForbidden      = errors.New("playlist belongs to another user")
+	ErrVideoAlreadyInPlaylist = errors.New("video already in playlist")
+)
+
 type VideoService struct {
-	videoCollection *mongo.Collection
-	fs              *gridfs.Bucket
+	videoCollection       *mongo.Collection
+	likeCollection        *mongo.Collection
+	playlistCollection    *mongo.Collection
+	historyCollection     *mongo.Collection
+	viewCollection        *mongo.Collection
+	shareCollection       *mongo.Collection
+	idempotencyCollection *mongo.Collection
+	fs                    *gridfs.Bucket
+	ffmpeg                *FFmpegService
+	thumbnailAt           ThumbnailAt
+	storage               Storage
+	webhooks              *webhooks.WebhookDispatcher
+	queue                 *ProcessingQueue // Processes uploads in the background
+	reprobe               *ReprobeJob      // Latest metadata re-probe, guarded by reprobeMu
+	results               *cache.Loader    // Caches popular and trending listings; nil disables caching
+	shareKey              []byte           // Signs share link tokens; nil disables share links
+	storageQuota          int64            // Bytes each user may store; zero means unlimited
+	maxFileSize           int64            // Largest upload in bytes, from the configuration
+	maxVideos             int              // Videos each user may have; zero means unlimited
+	idempotencyWindow     time.Duration    // How long upload idempotency keys are remembered
+	reprobeMu             sync.Mutex
 }
 
-func NewVideoService(db *mongo.Database) *VideoService {
+func NewVideoService(db *mongo.Database, cfg config.VideoConfig) *VideoService {
 	fs, err := gridfs.NewBucket(db)
 	if err != nil {
 		log.Fatalf("Failed to create GridFS bucket: %v", err)
 	}
 
-	return &VideoService{
-		videoCollection: db.Collection("videos"),
-		fs:              fs,
+	thumbnailAt, err := ParseThumbnailAt(cfg.ThumbnailAt)
+	if err != nil {
+		log.Fatalf("Invalid thumbnail timestamp: %v", err)
+	}
+
+	storage, err := NewStorage(cfg.Storage, fs)
+	if err != nil {
+		log.Fatalf("Failed to create video storage: %v", err)
+	}
+
+	idempotencyWindow := cfg.IdempotencyWindow
+	if idempotencyWindow <= 0 {
+		idempotencyWindow = DefaultIdempotencyWindow
+	}
+
+	ffmpeg := NewFFmpegService()
+	if len(cfg.TranscodeProfiles) > 0 {
+		ffmpeg.ladder = NewHLSLadder(cfg.TranscodeProfiles)
+	}
+
+	service := &VideoService{
+		videoCollection:       db.Collection("videos"),
+		likeCollection:        db.Collection("likes"),
+		playlistCollection:    db.Collection("playlists"),
+		historyCollection:     db.Collection("watch_history"),
+		viewCollection:        db.Collection("views"),
+		shareCollection:       db.Collection("share_links"),
+		idempotencyCollection: db.Collection("idempotency_keys"),
+		fs:                    fs,
+		ffmpeg:                ffmpeg,
+		thumbnailAt:           thumbnailAt,
+		storage:               storage,
+		maxFileSize:           cfg.MaxFileSize,
+		idempotencyWindow:     idempotencyWindow,
+	}
+	service.queue = NewProcessingQueue(db.Collection("processing_jobs"), cfg.Processing, service.processUpload, service.failProcessing)
+
+	// Create the indexes backing search and listing queries
+	service.createIndexes()
+
+	return service
+}
+
+// SetResultCache makes the service cache its popular and trending listings in results
+func (s *VideoService) SetResultCache(results *cache.Loader) {
+	s.results = results
+}
+
+// SetWebhookDispatcher makes the service fire video.uploaded and video.completed webhooks
+func (s *VideoService) SetWebhookDispatcher(d *webhooks.WebhookDispatcher) {
+	s.webhooks = d
+}
+
+// createIndexes creates the indexes used by video queries
+func (s *VideoService) createIndexes() {
+	ctx := context.Background()
+
+	// Text index over title and description for full-text search
+	textIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "title", Value: "text"},
+			{Key: "description", Value: "text"},
+		},
+		Options: options.Index().SetName("video_text_search"),
+	}
+
+	// Livestream recordings are looked up by the stream they came from
+	sourceStreamIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "source_stream_id", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	}
+
+	// Related videos are found by shared tags
+	tagIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "tags", Value: 1}, {Key: "status", Value: 1}},
+	}
+
+	// Admins look for videos stuck in a status, oldest first
+	statusIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "updated_at", Value: 1}},
 	}
+
+	// Create the indexes (ignore errors as they might already exist)
+	s.videoCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{textIndex, sourceStreamIndex, tagIndex, statusIndex})
+
+	// A user can like a video only once
+	likeIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "video_id", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	s.likeCollection.Indexes().CreateOne(ctx, likeIndex)
+
+	// Expired share links are removed by MongoDB; their tokens are rejected before then
+	s.shareCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+
+	// An idempotency key is used once per user until it expires
+	s.idempotencyCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+
+	// Playlists are listed per user
+	s.playlistCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+
+	// One watch-history entry per user and video, listed by recency
+	s.historyCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "video_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "updated_at", Value: -1}},
+		},
+		// Trending counts recent views across all users
+		{
+			Keys: bson.D{{Key: "updated_at", Value: -1}},
+		},
+	})
+
+	// One counted view per viewer and video in each dedup bucket. Views only matter
+	// while their bucket is current, so they expire soon after.
+	s.viewCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "video_id", Value: 1}, {Key: "viewer_key", Value: 1}, {Key: "bucket", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(2 * ViewDedupWindow / time.Second)),
+		},
+	})
 }
 
 // CreateVideo now accepts a primitive.ObjectID for the userID and includes it in the new video document.
-func (s *VideoService) CreateVideo(ctx context.Context, file io.Reader, title, description string, userID primitive.ObjectID, thumbnail io.Reader) (*Video, error) {
-	log.Printf("CreateVideo called for user %s with title '%s'", userID.Hex(), title)
+// An empty visibility makes the video public.
+func (s *VideoService) CreateVideo(ctx context.Context, file io.Reader, title, description string, visibility Visibility, userID primitive.ObjectID, thumbnail io.Reader) (*Video, error) {
+	if visibility == "" {
+		visibility = VisibilityPublic
+	}
+	if !visibility.IsValid() {
+		return nil, ErrInvalidVisibility
+	}
+
+	logger.FromContext(ctx).Debug("creating video", "user_id", userID.Hex(), "title", title)
 	videoID := primitive.NewObjectID()
-	log.Printf("Generated new video ID: %s", videoID.Hex())
+	logger.FromContext(ctx).Debug("generated video ID", "video_id", videoID.Hex())
 	newVideo := &Video{
 		ID:          videoID,
 		Title:       title,
 		Description: description,
 		Status:      StatusPending,
+		Visibility:  visibility,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 		UserID:      userID,
-		FilePath:    fmt.Sprintf("%s.mp4", videoID.Hex()), // GridFS filename
+		FilePath:    fmt.Sprintf("%s.mp4", videoID.Hex()), // Storage key
 	}
 
 	// TeeReader to write to both GridFS and a temporary local file
-	tempFilePath := fmt.Sprintf("storage/uploads/%s_temp.mp4", videoID.Hex())
+	tempFilePath := fmt.Sprintf("%s/%s_temp.mp4", uploadDir, videoID.Hex())
 	if err := os.MkdirAll(filepath.Dir(tempFilePath), 0755); err != nil {
 		return nil, fmt.Errorf("failed to create upload directory: %w", err)
 	}
@@ -72,124 +280,284 @@ func (s *VideoService) CreateVideo(ctx context.Context, file io.Reader, title, d
 	}
 	defer tempFile.Close()
 
-	// Setup GridFS upload stream
-	uploadStream, err := s.fs.OpenUploadStreamWithID(videoID, newVideo.FilePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open upload stream: %w", err)
-	}
-	defer uploadStream.Close()
-
-	// Use a TeeReader to write to both GridFS and the local file simultaneously
+	// Use a TeeReader to write to both the storage and the local file simultaneously
 	teeReader := io.TeeReader(file, tempFile)
 
-	if _, err := io.Copy(uploadStream, teeReader); err != nil {
+	if err := s.storage.Save(ctx, newVideo.FilePath, teeReader); err != nil {
 		CleanupFailedUpload(tempFilePath)
-		return nil, fmt.Errorf("failed to save file to GridFS and temp file: %w", err)
+		return nil, fmt.Errorf("failed to save file to storage and temp file: %w", err)
 	}
-	log.Println("Finished writing video to GridFS and temp file")
+	logger.FromContext(ctx).Debug("wrote video to storage and temporary file", "video_id", videoID.Hex())
 
-	// Detect corrupt video file from the temporary file
-	log.Println("Detecting corrupt video...")
-	if err := DetectCorruptVideo(tempFilePath); err != nil {
+	// Record the upload before probing so a failed probe leaves a FAILED video rather than fake metadata
+	if _, err := s.videoCollection.InsertOne(ctx, newVideo); err != nil {
 		CleanupFailedUpload(tempFilePath)
-		return nil, fmt.Errorf("video file validation failed: %w", err)
+		return nil, fmt.Errorf("failed to save video to database: %w", err)
 	}
 
-	// Extract video metadata from the temporary file
-	log.Println("Extracting video metadata...")
-	metadata, err := ExtractVideoMetadata(tempFilePath)
+	// Probe the real metadata from the temporary file
+	logger.FromContext(ctx).Debug("probing video metadata", "video_id", videoID.Hex())
+	metadata, err := s.ffmpeg.ProbeMetadata(ctx, tempFilePath)
 	if err != nil {
-		CleanupFailedUpload(tempFilePath)
-		return nil, fmt.Errorf("failed to extract video metadata: %w", err)
+		if errors.Is(err, ErrFFprobeUnavailable) {
+			// The file may be fine, so it is kept for RetryProcessing
+			return nil, s.failUpload(ctx, newVideo, tempFilePath, fmt.Errorf("cannot read video metadata: %w", err))
+		}
+		return nil, s.rejectUpload(ctx, newVideo, tempFilePath, err)
+	}
+
+	// Detect corrupt video file from the temporary file
+	logger.FromContext(ctx).Debug("detecting corrupt video", "video_id", videoID.Hex())
+	if err := DetectCorruptVideo(tempFilePath); err != nil {
+		return nil, s.rejectUpload(ctx, newVideo, tempFilePath, fmt.Errorf("video file validation failed: %w", err))
 	}
 
 	// Validate extracted metadata
-	log.Println("Validating video metadata...")
+	logger.FromContext(ctx).Debug("validating video metadata", "video_id", videoID.Hex())
 	if err := ValidateVideoMetadata(metadata); err != nil {
-		CleanupFailedUpload(tempFilePath)
-		return nil, fmt.Errorf("video metadata validation failed: %w", err)
+		var vErr ValidationError
+		if errors.As(err, &vErr) && vErr.Field == "duration" {
+			s.rejectUpload(ctx, newVideo, tempFilePath, ErrVideoTooLong)
+			return nil, fmt.Errorf("%w: %s", ErrVideoTooLong, vErr.Message)
+		}
+		return nil, s.rejectUpload(ctx, newVideo, tempFilePath, fmt.Errorf("video metadata validation failed: %w", err))
+	}
+
+	if err := s.UpdateVideoMetadata(ctx, videoID, *metadata); err != nil {
+		return nil, s.failUpload(ctx, newVideo, tempFilePath, fmt.Errorf("failed to store video metadata: %w", err))
 	}
+	newVideo.Metadata = *metadata
 
-	// Handle thumbnail
-	var thumbnailGridFSID primitive.ObjectID
+	// A provided thumbnail has to be read now; otherwise one is generated while processing
 	if thumbnail != nil {
-		// Upload provided thumbnail
-		var err error
-		thumbnailGridFSID, err = s.uploadThumbnail(thumbnail, videoID)
+		thumbnailGridFSID, err := s.uploadThumbnail(thumbnail, videoID)
 		if err != nil {
-			log.Printf("Failed to upload thumbnail for video %s: %v", videoID.Hex(), err)
+			logger.FromContext(ctx).Error("failed to upload thumbnail", "video_id", videoID.Hex(), "error", err)
+		} else {
+			newVideo.ThumbnailPath = thumbnailGridFSID.Hex() // Store GridFS ID
+			_, err = s.videoCollection.UpdateOne(ctx, bson.M{"_id": videoID}, bson.M{
+				"$set": bson.M{"thumbnail_path": newVideo.ThumbnailPath, "updated_at": time.Now()},
+			})
+			if err != nil {
+				logger.FromContext(ctx).Error("failed to save thumbnail", "video_id", videoID.Hex(), "error", err)
+			}
 		}
+	}
+
+	// Thumbnails and transcoding run on the processing queue using the temporary file
+	if _, err := s.queue.Enqueue(ctx, videoID, userID, tempFilePath); err != nil {
+		return nil, s.failUpload(ctx, newVideo, tempFilePath, err)
+	}
+
+	s.webhooks.Dispatch(webhooks.Event{
+		Event:   webhooks.EventVideoUploaded,
+		VideoID: videoID.Hex(),
+		UserID:  userID.Hex(),
+	})
+
+	return newVideo, nil
+}
+
+// CreateVideoFromRecording stores a finished livestream recording as a completed video
+// owned by the streamer. The recording is served as-is, so no transcoding is started,
+// and the duration limit for uploads does not apply.
+func (s *VideoService) CreateVideoFromRecording(ctx context.Context, recordingPath, title, description string, userID, streamID primitive.ObjectID) (*Video, error) {
+	newVideo, err := s.StoreRecording(ctx, recordingPath, title, description, userID, streamID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.InsertRecordingVideo(ctx, newVideo); err != nil {
+		s.storage.Delete(ctx, newVideo.FilePath)
+		return nil, err
+	}
+	s.NotifyRecordingPublished(newVideo)
+	return newVideo, nil
+}
+
+// StoreRecording probes a finished recording and stores it and its thumbnail as the
+// files of a new completed video, which it returns without saving. It is the slow part
+// of publishing a recording, so callers writing the video in a transaction run it
+// first and then save the video with InsertRecordingVideo. DiscardVideo removes the
+// files if the video is never saved.
+func (s *VideoService) StoreRecording(ctx context.Context, recordingPath, title, description string, userID, streamID primitive.ObjectID) (*Video, error) {
+	metadata, err := s.ffmpeg.ProbeMetadata(ctx, recordingPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe recording: %w", err)
+	}
+
+	file, err := os.Open(recordingPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	defer file.Close()
+
+	videoID := primitive.NewObjectID()
+	now := time.Now()
+	newVideo := &Video{
+		ID:                 videoID,
+		Title:              title,
+		Description:        description,
+		Status:             StatusCompleted,
+		Visibility:         VisibilityPublic,
+		ProcessingProgress: 100,
+		CreatedAt:          now,
+		UpdatedAt:          now,
+		UserID:             userID,
+		FilePath:           fmt.Sprintf("%s.mp4", videoID.Hex()), // Storage key
+		Metadata:           *metadata,
+		SourceStreamID:     &streamID,
+	}
+
+	if err := s.storage.Save(ctx, newVideo.FilePath, file); err != nil {
+		return nil, fmt.Errorf("failed to save recording to storage: %w", err)
+	}
+
+	thumbnailID, err := s.generateAndUploadThumbnail(ctx, recordingPath, videoID, metadata.Duration)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to generate recording thumbnail", "stream_id", streamID.Hex(), "error", err)
 	} else {
-		// Generate thumbnail from video
-		var err error
-		thumbnailGridFSID, err = s.generateAndUploadThumbnail(tempFilePath, videoID)
-		if err != nil {
-			log.Printf("Failed to generate thumbnail for video %s: %v", videoID.Hex(), err)
+		newVideo.ThumbnailPath = thumbnailID.Hex()
+	}
+	return newVideo, nil
+}
+
+// InsertRecordingVideo saves a video returned by StoreRecording
+func (s *VideoService) InsertRecordingVideo(ctx context.Context, video *Video) error {
+	if _, err := s.videoCollection.InsertOne(ctx, video); err != nil {
+		return fmt.Errorf("failed to save video to database: %w", err)
+	}
+	return nil
+}
+
+// NotifyRecordingPublished tells webhooks that a recording saved with
+// InsertRecordingVideo is available. Recordings need no processing, so they are
+// complete as soon as they are saved. Callers saving the video in a transaction call it
+// once the transaction has committed.
+func (s *VideoService) NotifyRecordingPublished(video *Video) {
+	s.webhooks.Dispatch(webhooks.Event{
+		Event:   webhooks.EventVideoCompleted,
+		VideoID: video.ID.Hex(),
+		UserID:  video.UserID.Hex(),
+	})
+}
+
+// GetVideoBySourceStream returns the video recorded from a livestream
+func (s *VideoService) GetVideoBySourceStream(ctx context.Context, streamID primitive.ObjectID) (*Video, error) {
+	var video Video
+	err := s.videoCollection.FindOne(ctx, bson.M{"source_stream_id": streamID, "deleted_at": nil}).Decode(&video)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
 		}
+		return nil, err
 	}
+	return &video, nil
+}
 
-	if thumbnailGridFSID != primitive.NilObjectID {
-		newVideo.ThumbnailPath = thumbnailGridFSID.Hex() // Store GridFS ID
+// rejectUpload fails an upload whose file was found invalid. The stored file is deleted
+// too, as processing it again can't succeed; only the FAILED record explaining why is
+// kept. Save has already closed its upload, so nothing writes the file back.
+func (s *VideoService) rejectUpload(ctx context.Context, video *Video, tempFilePath string, err error) error {
+	if delErr := s.storage.Delete(ctx, video.FilePath); delErr != nil && !errors.Is(delErr, ErrFileNotFound) {
+		logger.FromContext(ctx).Error("failed to delete rejected upload", "video_id", video.ID.Hex(), "error", delErr)
 	}
+	return s.failUpload(ctx, video, tempFilePath, err)
+}
 
-	// Store metadata in video document
-	newVideo.Metadata = *metadata
+// failUpload marks an upload FAILED with the reason, removes the temporary file and returns err.
+// The stored file is kept so RetryProcessing can process it again.
+func (s *VideoService) failUpload(ctx context.Context, video *Video, tempFilePath string, err error) error {
+	CleanupFailedUpload(tempFilePath)
+	s.updateVideoStatus(ctx, video.ID, StatusFailed, err.Error())
+	video.Status = StatusFailed
+	video.Error = err.Error()
+	return err
+}
+
+// UpdateVideoMetadata replaces the stored metadata of a video
+func (s *VideoService) UpdateVideoMetadata(ctx context.Context, videoID primitive.ObjectID, metadata VideoMetadata) error {
+	update := bson.M{
+		"$set": bson.M{
+			"metadata":   metadata,
+			"updated_at": time.Now(),
+		},
+	}
 
-	// Insert video document into database
-	_, err = s.videoCollection.InsertOne(ctx, newVideo)
+	result, err := s.videoCollection.UpdateOne(ctx, bson.M{"_id": videoID}, update)
 	if err != nil {
-		CleanupFailedUpload(tempFilePath)
-		return nil, fmt.Errorf("failed to save video to database: %w", err)
+		return err
 	}
 
-	// Start transcoding in the background using the temporary file
-	go s.startTranscoding(videoID, tempFilePath)
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
 
-	return newVideo, nil
+	return nil
 }
 
-func (s *VideoService) generateAndUploadThumbnail(videoPath string, videoID primitive.ObjectID) (primitive.ObjectID, error) {
-	thumbnailID := primitive.NewObjectID()
+// generateAndUploadThumbnail extracts a frame at the configured thumbnail timestamp and stores it in GridFS
+func (s *VideoService) generateAndUploadThumbnail(ctx context.Context, videoPath string, videoID primitive.ObjectID, duration float64) (primitive.ObjectID, error) {
 	thumbnailPath := fmt.Sprintf("storage/cache/thumbnails/%s.jpg", videoID.Hex())
 
-	// Create thumbnail directory if it doesn't exist
-	if err := os.MkdirAll(filepath.Dir(thumbnailPath), 0755); err != nil {
-		return primitive.NilObjectID, fmt.Errorf("failed to create thumbnail directory: %w", err)
+	if err := s.ffmpeg.GenerateThumbnail(ctx, videoPath, s.thumbnailAt.Seconds(duration), thumbnailPath); err != nil {
+		return primitive.NilObjectID, err
 	}
 
-	// Use ffmpeg to generate thumbnail
-	cmd := exec.Command("ffmpeg",
-		"-i", videoPath,
-		"-ss", "00:00:05",
-		"-vframes", "1",
-		"-vf", "scale=320:-1",
-		"-y",
-		thumbnailPath)
+	defer func() {
+		// Clean up local thumbnail file
+		if err := os.Remove(thumbnailPath); err != nil {
+			logger.FromContext(ctx).Warn("failed to remove temporary thumbnail file", "error", err)
+		}
+	}()
+
+	return s.uploadThumbnailFile(thumbnailPath, fmt.Sprintf("%s_thumbnail.jpg", videoID.Hex()))
+}
+
+// generateThumbnailCandidates extracts ThumbnailCandidateCount frames and stores them in GridFS,
+// returning their GridFS IDs
+func (s *VideoService) generateThumbnailCandidates(ctx context.Context, videoPath string, videoID primitive.ObjectID) ([]string, error) {
+	outputDir := fmt.Sprintf("storage/cache/thumbnails/%s_candidates", videoID.Hex())
+	defer os.RemoveAll(outputDir)
+
+	paths, err := s.ffmpeg.GenerateThumbnailGrid(ctx, videoPath, ThumbnailCandidateCount, outputDir)
+	if err != nil {
+		return nil, err
+	}
 
-	if err := cmd.Run(); err != nil {
-		return primitive.NilObjectID, fmt.Errorf("failed to generate thumbnail: %w", err)
+	candidates := make([]string, 0, len(paths))
+	for i, path := range paths {
+		id, err := s.uploadThumbnailFile(path, fmt.Sprintf("%s_thumbnail_%02d.jpg", videoID.Hex(), i))
+		if err != nil {
+			return candidates, err
+		}
+		candidates = append(candidates, id.Hex())
 	}
 
-	// Upload to GridFS
-	file, err := os.Open(thumbnailPath)
+	return candidates, nil
+}
+
+// uploadThumbnailFile stores a local thumbnail image in GridFS
+func (s *VideoService) uploadThumbnailFile(path, filename string) (primitive.ObjectID, error) {
+	thumbnailID := primitive.NewObjectID()
+
+	file, err := os.Open(path)
 	if err != nil {
 		return primitive.NilObjectID, fmt.Errorf("failed to open thumbnail file for upload: %w", err)
 	}
 	defer file.Close()
 
-	uploadStream, err := s.fs.OpenUploadStreamWithID(thumbnailID, fmt.Sprintf("%s_thumbnail.jpg", videoID.Hex()))
+	uploadStream, err := s.fs.OpenUploadStreamWithID(thumbnailID, filename)
 	if err != nil {
 		return primitive.NilObjectID, fmt.Errorf("failed to open GridFS upload stream for thumbnail: %w", err)
 	}
-	defer uploadStream.Close()
 
 	if _, err := io.Copy(uploadStream, file); err != nil {
+		uploadStream.Close()
 		return primitive.NilObjectID, fmt.Errorf("failed to upload thumbnail to GridFS: %w", err)
 	}
 
-	// Clean up local thumbnail file
-	if err := os.Remove(thumbnailPath); err != nil {
-		log.Printf("Failed to remove temporary thumbnail file: %v", err)
+	if err := uploadStream.Close(); err != nil {
+		return primitive.NilObjectID, fmt.Errorf("failed to close thumbnail upload stream: %w", err)
 	}
 
 	return thumbnailID, nil
@@ -220,80 +588,240 @@ func (s *VideoService) uploadThumbnail(thumbnail io.Reader, videoID primitive.Ob
 	return thumbnailID, nil
 }
 
-func (s *VideoService) startTranscoding(videoID primitive.ObjectID, rawFile string) {
-	ctx := context.Background()
+// processUpload runs a processing job: it generates the thumbnails the upload is missing,
+// transcodes it to HLS and marks the video completed. Every step can be repeated, so a
+// failed attempt is simply run again.
+func (s *VideoService) processUpload(ctx context.Context, job *ProcessingJob) error {
+	video, err := s.GetVideoByID(ctx, job.VideoID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			// Deleted while queued, so there is nothing left to process
+			CleanupFailedUpload(job.SourcePath)
+			return nil
+		}
+		return fmt.Errorf("failed to load video: %w", err)
+	}
 
-	// Update video status to processing
-	_, err := s.videoCollection.UpdateOne(ctx, bson.M{"_id": videoID}, bson.M{"$set": bson.M{"status": StatusProcessing}})
+	// Every attempt starts over, so its progress does too
+	_, err = s.videoCollection.UpdateOne(ctx, bson.M{"_id": video.ID}, bson.M{
+		"$set": bson.M{
+			"status":              StatusProcessing,
+			"processing_progress": 0,
+			"updated_at":          time.Now(),
+		},
+	})
 	if err != nil {
-		log.Printf("Error updating video status to processing: %v", err)
-		return
+		return fmt.Errorf("failed to mark video processing: %w", err)
 	}
+	s.queue.Publish(ProcessingStatus{VideoID: video.ID, Status: StatusProcessing})
 
-	outputDir := fmt.Sprintf("storage/processed/%s", videoID.Hex())
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		log.Printf("Error creating output directory: %v", err)
-		s.updateVideoStatus(ctx, videoID, StatusFailed, "Failed to create output directory")
-		return
+	if err := s.fetchSource(ctx, video, job.SourcePath); err != nil {
+		return err
 	}
 
-	hlsPlaylistPath := filepath.Join(outputDir, "playlist.m3u8")
+	// Uploads are probed when they are received; this covers jobs queued without metadata
+	if video.Metadata.Duration == 0 {
+		metadata, err := s.ffmpeg.ProbeMetadata(ctx, job.SourcePath)
+		if err != nil {
+			return fmt.Errorf("failed to probe video: %w", err)
+		}
+		if err := s.UpdateVideoMetadata(ctx, video.ID, *metadata); err != nil {
+			return fmt.Errorf("failed to store video metadata: %w", err)
+		}
+		video.Metadata = *metadata
+	}
 
-	// Use the segment muxer to create HLS segments in a temporary directory
-	cmd := exec.Command("ffmpeg",
-		"-i", rawFile,
-		"-c:v", "libx264",
-		"-c:a", "aac",
-		"-f", "segment",
-		"-segment_time", "10",
-		"-segment_list", hlsPlaylistPath,
-		"-segment_format", "mpegts",
-		filepath.Join(outputDir, "segment%03d.ts"),
-	)
+	s.generateMissingThumbnails(ctx, video, job.SourcePath)
 
-	// Capture stderr for better error logging
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	outputDir := fmt.Sprintf("%s/%s", processedDir, video.ID.Hex())
 
-	if err := cmd.Run(); err != nil {
-		log.Printf("Error transcoding video: %v, stderr: %s", err, stderr.String())
-		s.updateVideoStatus(ctx, videoID, StatusFailed, fmt.Sprintf("Transcoding failed: %v - %s", err, stderr.String()))
-		return
+	// Transcode into an adaptive HLS ladder; partial output is cleaned up on failure
+	lastPercent := 0
+	progress := func(percent int) {
+		// 100 is stored with the completed status, once the output is uploaded
+		percent = min(percent, 99)
+		s.setProcessingProgress(ctx, video.ID, percent)
+		if percent > lastPercent {
+			lastPercent = percent
+			s.queue.Publish(ProcessingStatus{VideoID: video.ID, Status: StatusProcessing, Progress: percent})
+		}
 	}
-
-	// After transcoding, upload the playlist and segments to GridFS
-	if err := uploadHLSToGridFS(s.fs, outputDir, videoID); err != nil {
-		log.Printf("Failed to upload HLS files to GridFS: %v", err)
-		s.updateVideoStatus(ctx, videoID, StatusFailed, "Failed to upload HLS files")
-		return
+	renditions, err := s.ffmpeg.TranscodeToHLSWithProgress(ctx, job.SourcePath, outputDir, video.Metadata.Height, video.Metadata.Duration, progress)
+	if err != nil {
+		return fmt.Errorf("transcoding failed: %w", err)
 	}
-
-	// Clean up the temporary directory
-	if err := os.RemoveAll(outputDir); err != nil {
-		log.Printf("Failed to remove temporary processing directory: %v", err)
+	produced := make([]Rendition, len(renditions))
+	for i, rendition := range renditions {
+		produced[i] = Rendition{Name: rendition.Name, Height: rendition.Height, Bandwidth: rendition.Bandwidth}
 	}
 
-	// Clean up the temporary raw file
-	if err := os.Remove(rawFile); err != nil {
-		log.Printf("Failed to remove temporary raw file: %v", err)
+	// Replace the output of an earlier attempt, then upload the playlist and segments to GridFS
+	s.deleteHLSFiles(ctx, video.ID)
+	err = uploadHLSToGridFS(s.fs, outputDir, video.ID)
+	if removeErr := os.RemoveAll(outputDir); removeErr != nil {
+		logger.FromContext(ctx).Warn("failed to remove temporary processing directory", "error", removeErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload HLS files: %w", err)
 	}
 
 	// Update video with HLS path and completed status
 	update := bson.M{
 		"$set": bson.M{
-			"status":     StatusCompleted,
-			"hls_path":   fmt.Sprintf("%s/playlist.m3u8", videoID.Hex()), // GridFS path
-			"updated_at": time.Now(),
+			"status":              StatusCompleted,
+			"hls_path":            fmt.Sprintf("%s/%s", video.ID.Hex(), HLSMasterPlaylist), // GridFS path
+			"renditions":          produced,
+			"processing_progress": 100,
+			"error":               "",
+			"updated_at":          time.Now(),
 		},
 	}
+	if _, err := s.videoCollection.UpdateOne(ctx, bson.M{"_id": video.ID}, update); err != nil {
+		return fmt.Errorf("failed to mark video completed: %w", err)
+	}
+	s.queue.Publish(ProcessingStatus{VideoID: video.ID, Status: StatusCompleted, Progress: 100})
+
+	// Clean up the temporary raw file
+	CleanupFailedUpload(job.SourcePath)
+
+	logger.FromContext(ctx).Info("video transcoded", "video_id", video.ID.Hex())
+
+	s.webhooks.Dispatch(webhooks.Event{
+		Event:   webhooks.EventVideoCompleted,
+		VideoID: video.ID.Hex(),
+		UserID:  job.UserID.Hex(),
+	})
+	return nil
+}
+
+// setProcessingProgress stores the progress of a video being processed. Lower values than
+// the stored one are ignored, so progress never goes backwards.
+func (s *VideoService) setProcessingProgress(ctx context.Context, videoID primitive.ObjectID, percent int) {
+	_, err := s.videoCollection.UpdateOne(ctx,
+		bson.M{"_id": videoID, "processing_progress": bson.M{"$lt": percent}},
+		bson.M{"$set": bson.M{"processing_progress": percent, "updated_at": time.Now()}})
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to update processing progress", "video_id", videoID.Hex(), "error", err)
+	}
+}
+
+// failProcessing marks the video of a job that failed its last attempt FAILED
+func (s *VideoService) failProcessing(ctx context.Context, job *ProcessingJob, err error) {
+	CleanupFailedUpload(job.SourcePath)
+	message := fmt.Sprintf("Processing failed: %v", err)
+	s.updateVideoStatus(ctx, job.VideoID, StatusFailed, message)
+	s.queue.Publish(ProcessingStatus{VideoID: job.VideoID, Status: StatusFailed, Error: message})
+}
+
+// fetchSource makes sure the original upload is at path, downloading it from storage if
+// the temporary copy is gone, e.g. when the job runs on another instance
+func (s *VideoService) fetchSource(ctx context.Context, video *Video, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	stored, err := s.storage.Open(ctx, video.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open original video: %w", err)
+	}
+	defer stored.Close()
 
-	_, err = s.videoCollection.UpdateOne(ctx, bson.M{"_id": videoID}, update)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	file, err := os.Create(path)
 	if err != nil {
-		log.Printf("Error updating video status to completed: %v", err)
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	if _, err := io.Copy(file, stored); err != nil {
+		file.Close()
+		CleanupFailedUpload(path)
+		return fmt.Errorf("failed to download original video: %w", err)
+	}
+	return file.Close()
+}
+
+// generateMissingThumbnails generates the thumbnail and the selectable candidates a video
+// doesn't have yet. Failures are logged, as a video plays fine without thumbnails.
+func (s *VideoService) generateMissingThumbnails(ctx context.Context, video *Video, sourcePath string) {
+	set := bson.M{}
+
+	if video.ThumbnailPath == "" {
+		thumbnailID, err := s.generateAndUploadThumbnail(ctx, sourcePath, video.ID, video.Metadata.Duration)
+		if err != nil {
+			logger.FromContext(ctx).Error("failed to generate thumbnail", "video_id", video.ID.Hex(), "error", err)
+		} else {
+			video.ThumbnailPath = thumbnailID.Hex() // Store GridFS ID
+			set["thumbnail_path"] = video.ThumbnailPath
+		}
+	}
+
+	// Generate alternative thumbnails the owner can pick from
+	if len(video.ThumbnailCandidates) == 0 {
+		candidates, err := s.generateThumbnailCandidates(ctx, sourcePath, video.ID)
+		if err != nil {
+			logger.FromContext(ctx).Error("failed to generate thumbnail candidates", "video_id", video.ID.Hex(), "error", err)
+		}
+		if len(candidates) > 0 {
+			video.ThumbnailCandidates = candidates
+			set["thumbnail_candidates"] = candidates
+		}
+	}
+
+	if len(set) == 0 {
 		return
 	}
+	set["updated_at"] = time.Now()
+	if _, err := s.videoCollection.UpdateOne(ctx, bson.M{"_id": video.ID}, bson.M{"$set": set}); err != nil {
+		logger.FromContext(ctx).Error("failed to save thumbnails", "video_id", video.ID.Hex(), "error", err)
+	}
+}
+
+// GetVideoProcessingStatus reports the processing progress and latest processing job of
+// a video owned by ownerID
+func (s *VideoService) GetVideoProcessingStatus(ctx context.Context, videoID, ownerID primitive.ObjectID) (*ProcessingStatus, error) {
+	video, err := s.getOwnedVideo(ctx, videoID, ownerID)
+	if err != nil {
+		return nil, err
+	}
 
-	log.Printf("Video transcoded successfully: %s", videoID.Hex())
+	status := &ProcessingStatus{
+		VideoID:  video.ID,
+		Status:   video.Status,
+		Progress: video.ProcessingProgress,
+		Error:    video.Error,
+	}
+	// Videos completed before progress was tracked have none stored
+	if video.Status == StatusCompleted {
+		status.Progress = 100
+	}
+
+	job, err := s.queue.GetJob(ctx, videoID)
+	if err != nil && !errors.Is(err, ErrJobNotFound) {
+		return nil, err
+	}
+	status.Job = job
+	return status, nil
+}
+
+// SubscribeProcessing returns the processing status of a video owned by ownerID along with
+// a channel receiving its updates and a function ending the subscription. Only updates of
+// jobs run by this instance's workers are received.
+func (s *VideoService) SubscribeProcessing(ctx context.Context, videoID, ownerID primitive.ObjectID) (*ProcessingStatus, <-chan ProcessingStatus, func(), error) {
+	// Subscribe before reading the status so no update in between is missed
+	updates, unsubscribe := s.queue.Subscribe(videoID)
+	status, err := s.GetVideoProcessingStatus(ctx, videoID, ownerID)
+	if err != nil {
+		unsubscribe()
+		return nil, nil, nil, err
+	}
+	return status, updates, unsubscribe, nil
+}
+
+// CloseProcessingSubscriptions ends every processing subscription. It is called on
+// shutdown, before the HTTP server waits for open connections.
+func (s *VideoService) CloseProcessingSubscriptions() {
+	s.queue.CloseSubscriptions()
 }
 
 // uploadHLSToGridFS reads all HLS files from a directory and uploads them to GridFS.
@@ -312,7 +840,7 @@ func uploadHLSToGridFS(fs *gridfs.Bucket, dirPath string, videoID primitive.Obje
 
 		fileReader, err := os.Open(filePath)
 		if err != nil {
-			log.Printf("Could not open file %s for GridFS upload: %v", filePath, err)
+			slog.Error("failed to open file for GridFS upload", "path", filePath, "error", err)
 			uploadErrors = append(uploadErrors, fmt.Sprintf("failed to open %s: %v", file.Name(), err))
 			continue
 		}
@@ -320,7 +848,7 @@ func uploadHLSToGridFS(fs *gridfs.Bucket, dirPath string, videoID primitive.Obje
 		uploadStream, err := fs.OpenUploadStream(gridFSFilename)
 		if err != nil {
 			fileReader.Close()
-			log.Printf("Could not open GridFS upload stream for %s: %v", gridFSFilename, err)
+			slog.Error("failed to open GridFS upload stream", "filename", gridFSFilename, "error", err)
 			uploadErrors = append(uploadErrors, fmt.Sprintf("failed to create upload stream for %s: %v", file.Name(), err))
 			continue
 		}
@@ -330,11 +858,11 @@ func uploadHLSToGridFS(fs *gridfs.Bucket, dirPath string, videoID primitive.Obje
 		uploadStream.Close()
 
 		if copyErr != nil {
-			log.Printf("Could not copy file %s to GridFS: %v", filePath, copyErr)
+			slog.Error("failed to copy file to GridFS", "path", filePath, "error", copyErr)
 			uploadErrors = append(uploadErrors, fmt.Sprintf("failed to upload %s: %v", file.Name(), copyErr))
 		} else {
-			log.Printf("Successfully uploaded %s to GridFS", gridFSFilename)
-			if file.Name() == "playlist.m3u8" {
+			slog.Debug("uploaded file to GridFS", "filename", gridFSFilename)
+			if file.Name() == HLSMasterPlaylist {
 				playlistUploaded = true
 			}
 		}
@@ -347,7 +875,7 @@ func uploadHLSToGridFS(fs *gridfs.Bucket, dirPath string, videoID primitive.Obje
 
 	// If we have upload errors, log them but don't fail if playlist is uploaded
 	if len(uploadErrors) > 0 {
-		log.Printf("Some files failed to upload to GridFS: %v", uploadErrors)
+		slog.Error("some files failed to upload to GridFS", "errors", uploadErrors)
 	}
 
 	return nil
@@ -365,7 +893,7 @@ func (s *VideoService) updateVideoStatus(ctx context.Context, videoID primitive.
 
 	_, err := s.videoCollection.UpdateOne(ctx, bson.M{"_id": videoID}, update)
 	if err != nil {
-		log.Printf("Error updating video status: %v", err)
+		logger.FromContext(ctx).Error("failed to update video status", "video_id", videoID.Hex(), "error", err)
 	}
 }
 
@@ -390,39 +918,194 @@ func (s *VideoService) UpdateVideoStatus(ctx context.Context, videoID primitive.
 	return nil
 }
 
-// GridFSHLSWriter implements io.Writer to upload HLS segments to GridFS
-type GridFSHLSWriter struct {
-	fs        *gridfs.Bucket
-	videoID   primitive.ObjectID
-	outputDir string
-	wg        *sync.WaitGroup
-}
+// AdminBulkSetStatus sets the status of many videos at once and returns how many were updated.
+// Resetting videos to pending also clears any previous processing error and queues them
+// to be processed again from their original upload, unless a job for them is still queued
+// or running. A video that fails to be queued doesn't keep the others from being queued.
+func (s *VideoService) AdminBulkSetStatus(ctx context.Context, ids []primitive.ObjectID, status VideoStatus) (int, error) {
+	if !status.IsValid() {
+		return 0, fmt.Errorf("invalid status: %s", status)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
 
-func (w *GridFSHLSWriter) Write(p []byte) (int, error) {
-	segmentName := strings.TrimSpace(string(p))
-	segmentPath := filepath.Join(w.outputDir, segmentName)
-	gridfsFilename := fmt.Sprintf("%s/%s", w.videoID.Hex(), segmentName)
+	update := bson.M{
+		"$set": bson.M{
+			"status":     status,
+			"updated_at": time.Now(),
+		},
+	}
+	if status == StatusPending {
+		update["$set"].(bson.M)["processing_progress"] = 0
+		update["$unset"] = bson.M{"error": ""}
+	}
 
-	w.wg.Add(1)
+	result, err := s.videoCollection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to bulk update video status: %w", err)
+	}
+	if status == StatusPending {
+		if err := s.enqueuePending(ctx, ids); err != nil {
+			return int(result.ModifiedCount), err
+		}
+	}
+
+	return int(result.ModifiedCount), nil
+}
+
+// enqueuePending queues the videos reset to pending by AdminBulkSetStatus for processing
+func (s *VideoService) enqueuePending(ctx context.Context, ids []primitive.ObjectID) error {
+	opts := options.Find().SetProjection(bson.M{"user_id": 1})
+	cursor, err := s.videoCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return fmt.Errorf("failed to find videos to queue: %w", err)
+	}
+	var videos []Video
+	if err := cursor.All(ctx, &videos); err != nil {
+		return fmt.Errorf("failed to find videos to queue: %w", err)
+	}
+
+	var errs []error
+	for _, video := range videos {
+		job, err := s.queue.GetJob(ctx, video.ID)
+		if err != nil && !errors.Is(err, ErrJobNotFound) {
+			errs = append(errs, fmt.Errorf("failed to look up processing job of video %s: %w", video.ID.Hex(), err))
+			continue
+		}
+		if job != nil && job.active() {
+			continue
+		}
+		if _, err := s.enqueueReprocessing(ctx, video.ID, video.UserID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to queue video %s: %w", video.ID.Hex(), err))
+			continue
+		}
+		logger.FromContext(ctx).Info("queued video to be processed again", "video_id", video.ID.Hex())
+	}
+	return errors.Join(errs...)
+}
+
+// enqueueReprocessing queues a video to be processed again from its original upload
+func (s *VideoService) enqueueReprocessing(ctx context.Context, videoID, userID primitive.ObjectID) (*ProcessingJob, error) {
+	// The temporary copy of the upload is long gone, so the job fetches it from storage
+	sourcePath := fmt.Sprintf("%s/%s_temp.mp4", uploadDir, videoID.Hex())
+	return s.queue.Enqueue(ctx, videoID, userID, sourcePath)
+}
+
+// MaxAdminVideosLimit caps the page size of GetVideosByStatus
+const MaxAdminVideosLimit = 100
+
+// GetVideosByStatus returns a page of the videos of every user in a status, those left
+// untouched the longest first, so videos stuck processing show up at the top. page starts
+// at 1 and limit is capped at MaxAdminVideosLimit.
+func (s *VideoService) GetVideosByStatus(ctx context.Context, status VideoStatus, page, limit int) (*PaginatedVideos, error) {
+	if !status.IsValid() {
+		return nil, fmt.Errorf("invalid status: %s", status)
+	}
+	page = max(page, 1)
+	limit = min(max(limit, 1), MaxAdminVideosLimit)
+
+	filter := bson.M{"status": status, "deleted_at": nil}
+	total, err := s.videoCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count videos: %w", err)
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "updated_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := s.videoCollection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list videos: %w", err)
+	}
+	videos := []*Video{}
+	if err := cursor.All(ctx, &videos); err != nil {
+		return nil, fmt.Errorf("failed to decode videos: %w", err)
+	}
+
+	return &PaginatedVideos{
+		Videos:     videos,
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		TotalPages: int((total + int64(limit) - 1) / int64(limit)),
+	}, nil
+}
+
+// RetryProcessing queues a failed video, or one stuck pending or processing, to be
+// processed again from its original upload. ErrNotRetryable is returned for a completed
+// video and ErrProcessingActive while a job for the video is queued or being run.
+func (s *VideoService) RetryProcessing(ctx context.Context, videoID primitive.ObjectID) (*ProcessingJob, error) {
+	video, err := s.GetVideoByID(ctx, videoID)
+	if err != nil {
+		return nil, err
+	}
+	if video.Status == StatusCompleted {
+		return nil, ErrNotRetryable
+	}
+
+	job, err := s.queue.GetJob(ctx, videoID)
+	if err != nil && !errors.Is(err, ErrJobNotFound) {
+		return nil, fmt.Errorf("failed to look up processing job: %w", err)
+	}
+	if job != nil && job.active() {
+		return nil, ErrProcessingActive
+	}
+
+	_, err = s.videoCollection.UpdateOne(ctx, bson.M{"_id": videoID}, bson.M{
+		"$set": bson.M{
+			"status":              StatusPending,
+			"processing_progress": 0,
+			"updated_at":          time.Now(),
+		},
+		"$unset": bson.M{"error": ""},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to reset video status: %w", err)
+	}
+
+	job, err = s.enqueueReprocessing(ctx, videoID, video.UserID)
+	if err != nil {
+		return nil, err
+	}
+	logger.FromContext(ctx).Info("queued video to be processed again", "video_id", videoID.Hex())
+	return job, nil
+}
+
+// GridFSHLSWriter implements io.Writer to upload HLS segments to GridFS
+type GridFSHLSWriter struct {
+	fs        *gridfs.Bucket
+	videoID   primitive.ObjectID
+	outputDir string
+	wg        *sync.WaitGroup
+}
+
+func (w *GridFSHLSWriter) Write(p []byte) (int, error) {
+	segmentName := strings.TrimSpace(string(p))
+	segmentPath := filepath.Join(w.outputDir, segmentName)
+	gridfsFilename := fmt.Sprintf("%s/%s", w.videoID.Hex(), segmentName)
+
+	w.wg.Add(1)
 	go func() {
 		defer w.wg.Done()
 
 		file, err := os.Open(segmentPath)
 		if err != nil {
-			log.Printf("Error opening segment file for upload: %v", err)
+			slog.Error("failed to open segment file for upload", "error", err)
 			return
 		}
 		defer file.Close()
 
 		uploadStream, err := w.fs.OpenUploadStream(gridfsFilename)
 		if err != nil {
-			log.Printf("Error opening GridFS upload stream for segment: %v", err)
+			slog.Error("failed to open GridFS upload stream for segment", "error", err)
 			return
 		}
 		defer uploadStream.Close()
 
 		if _, err := io.Copy(uploadStream, file); err != nil {
-			log.Printf("Error uploading segment to GridFS: %v", err)
+			slog.Error("failed to upload segment to GridFS", "error", err)
 		}
 
 		// Clean up the local segment file after upload
@@ -432,6 +1115,22 @@ func (w *GridFSHLSWriter) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
+// Storage returns the storage original video files are kept in
+func (s *VideoService) Storage() Storage {
+	return s.storage
+}
+
+// OpenVideoFile opens the original upload of the video
+func (s *VideoService) OpenVideoFile(ctx context.Context, video *Video) (StoredFile, error) {
+	return s.storage.Open(ctx, video.FilePath)
+}
+
+// VideoFileURL returns a URL clients can download the original upload from directly,
+// or "" if it has to be served through the API
+func (s *VideoService) VideoFileURL(ctx context.Context, video *Video) (string, error) {
+	return s.storage.URL(ctx, video.FilePath)
+}
+
 // DownloadFromGridFS downloads a file from GridFS by its filename
 func (s *VideoService) DownloadFromGridFS(ctx context.Context, filename string) (*gridfs.DownloadStream, error) {
 	downloadStream, err := s.fs.OpenDownloadStreamByName(filename)
@@ -450,13 +1149,81 @@ func (s *VideoService) DownloadFromGridFSByID(ctx context.Context, id primitive.
 }
 
 
-// GetVideoByID retrieves a single video by its ID.
-func (s *VideoService) GetVideoByID(ctx context.Context, id primitive.ObjectID) (*Video, error) {
+// GetVideoByID retrieves a single video by its ID. When a requester is given, the video's
+// visibility is enforced: another user's private video is reported as ErrNotFound, so its
+// existence isn't revealed. Pass primitive.NilObjectID for anonymous requests. Without a
+// requester the video is returned whatever its visibility, for the service's own use.
+func (s *VideoService) GetVideoByID(ctx context.Context, id primitive.ObjectID, requesterID ...primitive.ObjectID) (*Video, error) {
+	video, err := s.GetVideo(ctx, id, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(requesterID) > 0 && !video.VisibleTo(requesterID[0]) {
+		return nil, ErrNotFound
+	}
+	return video, nil
+}
+
+// GetVideoWithUploader retrieves a video along with its uploader's public profile in a
+// single query. Visibility is enforced for requesterID as in GetVideoByID.
+func (s *VideoService) GetVideoWithUploader(ctx context.Context, id primitive.ObjectID, requesterID ...primitive.ObjectID) (*VideoWithUploader, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": id, "deleted_at": nil}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from": "users",
+			"let":  bson.M{"user_id": "$user_id"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$_id", "$$user_id"}}}},
+				// Only public fields leave the users collection; never the password or email
+				bson.M{"$project": bson.M{"_id": 1, "user_name": 1, "avatar_path": 1}},
+			},
+			"as": "uploader",
+		}}},
+		{{Key: "$unwind", Value: bson.M{"path": "$uploader", "preserveNullAndEmptyArrays": true}}},
+	}
+
+	cursor, err := s.videoCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch video with uploader: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	if !cursor.Next(ctx) {
+		if err := cursor.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrNotFound
+	}
+	var video VideoWithUploader
+	if err := cursor.Decode(&video); err != nil {
+		return nil, err
+	}
+	if len(requesterID) > 0 && !video.VisibleTo(requesterID[0]) {
+		return nil, ErrNotFound
+	}
+	return &video, nil
+}
+
+// publicOnly restricts a video filter to public videos, which are the only ones listed.
+// Videos from before visibility levels have no visibility and count as public.
+func publicOnly(filter bson.M) bson.M {
+	filter["visibility"] = bson.M{"$nin": bson.A{VisibilityUnlisted, VisibilityPrivate}}
+	return filter
+}
+
+// GetVideo fetches a video by ID. Soft-deleted videos are reported as ErrNotFound
+// unless includeDeleted is set.
+func (s *VideoService) GetVideo(ctx context.Context, id primitive.ObjectID, includeDeleted bool) (*Video, error) {
+	filter := bson.M{"_id": id}
+	if !includeDeleted {
+		filter["deleted_at"] = nil
+	}
+
 	var video Video
-	err := s.videoCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&video)
+	err := s.videoCollection.FindOne(ctx, filter).Decode(&video)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("video not found")
+			return nil, ErrNotFound
 		}
 		return nil, err
 	}
@@ -470,7 +1237,7 @@ func (s *VideoService) ListVideos(ctx context.Context, page, limit int) ([]*Vide
 	findOptions.SetLimit(int64(limit))
 	findOptions.SetSort(bson.D{{Key: "createdAt", Value: -1}}) // Sort by newest first
 
-	cursor, err := s.videoCollection.Find(ctx, bson.M{}, findOptions)
+	cursor, err := s.videoCollection.Find(ctx, publicOnly(bson.M{"deleted_at": nil}), findOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -483,8 +1250,99 @@ func (s *VideoService) ListVideos(ctx context.Context, page, limit int) ([]*Vide
 	return videos, nil
 }
 
+// MaxUserVideosLimit caps the page size of GetUserVideosPaginated
+const MaxUserVideosLimit = 50
+
+// GetUserVideosPaginated returns a page of the user's videos, newest first, including
+// unlisted and private ones. A non-empty statusFilter only lists videos in that status.
+// page starts at 1 and limit is capped at MaxUserVideosLimit.
+func (s *VideoService) GetUserVideosPaginated(ctx context.Context, userID primitive.ObjectID, page, limit int, statusFilter VideoStatus) (*PaginatedVideos, error) {
+	if statusFilter != "" && !statusFilter.IsValid() {
+		return nil, fmt.Errorf("invalid status: %s", statusFilter)
+	}
+	page = max(page, 1)
+	limit = min(max(limit, 1), MaxUserVideosLimit)
+
+	filter := bson.M{"user_id": userID, "deleted_at": nil}
+	if statusFilter != "" {
+		filter["status"] = statusFilter
+	}
+
+	total, err := s.videoCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count videos: %w", err)
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := s.videoCollection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list videos: %w", err)
+	}
+	videos := []*Video{}
+	if err := cursor.All(ctx, &videos); err != nil {
+		return nil, fmt.Errorf("failed to decode videos: %w", err)
+	}
+
+	return &PaginatedVideos{
+		Videos:     videos,
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		TotalPages: int((total + int64(limit) - 1) / int64(limit)),
+	}, nil
+}
+
+// SearchVideos performs a full-text search over completed videos' titles and descriptions.
+// Results are ordered by relevance. An empty query returns an empty slice.
+func (s *VideoService) SearchVideos(ctx context.Context, query string, page, limit int) ([]*Video, error) {
+	videos := []*Video{}
+
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return videos, nil
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+
+	filter := publicOnly(bson.M{
+		"$text":      bson.M{"$search": query},
+		"status":     StatusCompleted,
+		"deleted_at": nil,
+	})
+
+	findOptions := options.Find().
+		SetProjection(bson.M{"score": bson.M{"$meta": "textScore"}}).
+		SetSort(bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+
+	cursor, err := s.videoCollection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search videos: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, &videos); err != nil {
+		return nil, err
+	}
+	return videos, nil
+}
+
 // UpdateVideo updates a video's metadata based on the provided request.
-func (s *VideoService) UpdateVideo(ctx context.Context, id primitive.ObjectID, req UpdateVideoRequest) (*Video, error) {
+func (s *VideoService) UpdateVideo(ctx context.Context, id, ownerID primitive.ObjectID, req UpdateVideoRequest) (*Video, error) {
+	video, err := s.getOwnedVideo(ctx, id, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
 	updateFields := bson.M{}
 	if req.Title != "" {
 		updateFields["title"] = req.Title
@@ -492,17 +1350,33 @@ func (s *VideoService) UpdateVideo(ctx context.Context, id primitive.ObjectID, r
 	if req.Description != "" {
 		updateFields["description"] = req.Description
 	}
+	if req.Visibility != "" {
+		if !req.Visibility.IsValid() {
+			return nil, ErrInvalidVisibility
+		}
+		updateFields["visibility"] = req.Visibility
+	}
+	if req.Tags != nil {
+		normalized, err := tags.Parse(req.Tags)
+		if err != nil {
+			return nil, err
+		}
+		updateFields["tags"] = normalized
+	}
 
 	if len(updateFields) == 0 {
-		return s.GetVideoByID(ctx, id) // Nothing to update, return current data.
+		return video, nil // Nothing to update, return current data.
 	}
 
 	updateFields["updated_at"] = time.Now()
 	update := bson.M{"$set": updateFields}
 
 	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
-	result := s.videoCollection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts)
+	result := s.videoCollection.FindOneAndUpdate(ctx, bson.M{"_id": id, "user_id": ownerID, "deleted_at": nil}, update, opts)
 	if result.Err() != nil {
+		if result.Err() == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
 		return nil, result.Err()
 	}
 
@@ -513,60 +1387,360 @@ func (s *VideoService) UpdateVideo(ctx context.Context, id primitive.ObjectID, r
 	return &updatedVideo, nil
 }
 
-// DeleteVideo removes a video record and its associated files from storage.
-func (s *VideoService) DeleteVideo(ctx context.Context, id primitive.ObjectID) error {
+// getOwnedVideo loads a video and checks that ownerID owns it
+func (s *VideoService) getOwnedVideo(ctx context.Context, id, ownerID primitive.ObjectID) (*Video, error) {
 	video, err := s.GetVideoByID(ctx, id)
 	if err != nil {
-		if err.Error() == "video not found" {
+		return nil, err
+	}
+	if video.UserID != ownerID {
+		return nil, ErrForbidden
+	}
+	return video, nil
+}
+
+// DeleteVideo soft-deletes a video on behalf of its owner. The files are kept until
+// PurgeDeletedVideos removes them, so the owner can still restore it.
+func (s *VideoService) DeleteVideo(ctx context.Context, id, ownerID primitive.ObjectID) error {
+	if _, err := s.getOwnedVideo(ctx, id, ownerID); err != nil {
+		return err
+	}
+	return s.SoftDeleteVideo(ctx, id)
+}
+
+// purgeVideo permanently removes a video document and all of its GridFS files
+func (s *VideoService) purgeVideo(ctx context.Context, id primitive.ObjectID) error {
+	video, err := s.GetVideo(ctx, id, true)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
 			return nil // Video doesn't exist, so we consider it deleted.
 		}
 		return err
 	}
 
-	// Delete the original video file from GridFS
-	if fileID, err := primitive.ObjectIDFromHex(video.ID.Hex()); err == nil {
-		if err := s.fs.Delete(fileID); err != nil {
-			log.Printf("Failed to delete original video file from GridFS %s: %v", video.ID.Hex(), err)
+	s.deleteVideoFiles(ctx, video)
+
+	// Delete the video record from the database
+	_, err = s.videoCollection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("failed to delete video record: %w", err)
+	}
+
+	return nil
+}
+
+// DiscardVideo deletes a video and its files. Unlike purging, it works from the given
+// video rather than the stored record, so it also cleans up the files of a video whose
+// insert was rolled back.
+func (s *VideoService) DiscardVideo(ctx context.Context, video *Video) error {
+	s.deleteVideoFiles(ctx, video)
+
+	if _, err := s.videoCollection.DeleteOne(ctx, bson.M{"_id": video.ID}); err != nil {
+		return fmt.Errorf("failed to delete video record: %w", err)
+	}
+	return nil
+}
+
+// MaxBatchDelete is the most videos DeleteVideos deletes in one call
+const MaxBatchDelete = 100
+
+// DeleteVideos permanently deletes the videos in ids owned by ownerID, including those
+// soft-deleted, and their files. Unlike DeleteVideo they can't be restored. IDs of
+// videos that don't exist or belong to someone else are skipped, as are repeated IDs.
+// A file that can't be removed is logged and doesn't fail the batch.
+func (s *VideoService) DeleteVideos(ctx context.Context, ids []primitive.ObjectID, ownerID primitive.ObjectID) (*BatchDeleteResult, error) {
+	if len(ids) > MaxBatchDelete {
+		return nil, ErrBatchTooLarge
+	}
+	unique := make([]primitive.ObjectID, 0, len(ids))
+	seen := make(map[primitive.ObjectID]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	if len(unique) == 0 {
+		return &BatchDeleteResult{}, nil
+	}
+
+	filter := bson.M{"_id": bson.M{"$in": unique}, "user_id": ownerID}
+	cursor, err := s.videoCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find videos: %w", err)
+	}
+	var owned []*Video
+	if err := cursor.All(ctx, &owned); err != nil {
+		return nil, fmt.Errorf("failed to decode videos: %w", err)
+	}
+	if len(owned) == 0 {
+		return &BatchDeleteResult{Skipped: len(unique)}, nil
+	}
+
+	ownedIDs := make([]primitive.ObjectID, len(owned))
+	for i, video := range owned {
+		ownedIDs[i] = video.ID
+	}
+	result, err := s.videoCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ownedIDs}, "user_id": ownerID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete videos: %w", err)
+	}
+
+	// The records are gone first, so a failed file removal leaves an orphaned file
+	// rather than a video that can't be played
+	for _, video := range owned {
+		s.deleteVideoFiles(ctx, video)
+	}
+
+	deleted := int(result.DeletedCount)
+	return &BatchDeleteResult{Deleted: deleted, Skipped: len(unique) - deleted, DeletedIDs: ownedIDs}, nil
+}
+
+// deleteVideoFiles deletes the original, thumbnails and HLS output of a video, logging failures
+func (s *VideoService) deleteVideoFiles(ctx context.Context, video *Video) {
+	// Delete the original video file from storage
+	if video.FilePath != "" {
+		if err := s.storage.Delete(ctx, video.FilePath); err != nil && !errors.Is(err, ErrFileNotFound) {
+			logger.FromContext(ctx).Error("failed to delete original video file", "video_id", video.ID.Hex(), "error", err)
 		}
 	}
 
-	// Delete the thumbnail file from GridFS
-	if video.ThumbnailPath != "" {
-		if thumbnailID, err := primitive.ObjectIDFromHex(video.ThumbnailPath); err == nil {
+	s.deleteThumbnails(video)
+
+	// Delete HLS segments and playlist from GridFS
+	if video.HLSPath != "" {
+		s.deleteHLSFiles(ctx, video.ID)
+	}
+}
+
+// deleteThumbnails deletes the thumbnail and thumbnail candidates of a video from GridFS,
+// logging failures
+func (s *VideoService) deleteThumbnails(video *Video) {
+	// The active thumbnail may be one of the candidates
+	thumbnails := map[string]bool{}
+	for _, path := range append([]string{video.ThumbnailPath}, video.ThumbnailCandidates...) {
+		if path == "" || thumbnails[path] {
+			continue
+		}
+		thumbnails[path] = true
+		if thumbnailID, err := primitive.ObjectIDFromHex(path); err == nil {
 			if err := s.fs.Delete(thumbnailID); err != nil {
-				log.Printf("Failed to delete thumbnail file from GridFS %s: %v", video.ThumbnailPath, err)
+				slog.Error("failed to delete thumbnail file from GridFS", "path", path, "error", err)
 			}
 		}
 	}
+}
 
-	// Delete HLS segments and playlist from GridFS
-	if video.HLSPath != "" {
-		// Find all files related to the videoID in GridFS and delete them
-		prefix := fmt.Sprintf("%s/", video.ID.Hex())
-		cursor, err := s.fs.Find(bson.M{"filename": bson.M{"$regex": prefix}})
-		if err == nil {
-			for cursor.Next(ctx) {
-				var file bson.M
-				if err := cursor.Decode(&file); err == nil {
-					fileID := file["_id"].(primitive.ObjectID)
-					if err := s.fs.Delete(fileID); err != nil {
-						log.Printf("Failed to delete HLS file %s from GridFS: %v", file["filename"], err)
-					}
-				}
+// deleteHLSFiles deletes the HLS playlists and segments of a video from GridFS, logging failures
+func (s *VideoService) deleteHLSFiles(ctx context.Context, videoID primitive.ObjectID) {
+	// Find all files related to the videoID in GridFS and delete them
+	prefix := fmt.Sprintf("%s/", videoID.Hex())
+	cursor, err := s.fs.Find(bson.M{"filename": bson.M{"$regex": prefix}})
+	if err != nil {
+		return
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var file bson.M
+		if err := cursor.Decode(&file); err == nil {
+			fileID := file["_id"].(primitive.ObjectID)
+			if err := s.fs.Delete(fileID); err != nil {
+				logger.FromContext(ctx).Error("failed to delete HLS file from GridFS", "filename", file["filename"], "error", err)
 			}
-			cursor.Close(ctx)
 		}
 	}
+}
 
-	// Delete the video record from the database
-	_, err = s.videoCollection.DeleteOne(ctx, bson.M{"_id": id})
+// SetChapters replaces the chapters of a video owned by ownerID. Titles are trimmed and
+// the chapters are validated against the video's duration.
+func (s *VideoService) SetChapters(ctx context.Context, videoID, ownerID primitive.ObjectID, chapters []Chapter) (*Video, error) {
+	video, err := s.getOwnedVideo(ctx, videoID, ownerID)
 	if err != nil {
-		return fmt.Errorf("failed to delete video record: %w", err)
+		return nil, err
+	}
+
+	cleaned := make([]Chapter, len(chapters))
+	for i, chapter := range chapters {
+		cleaned[i] = Chapter{StartSeconds: chapter.StartSeconds, Title: strings.TrimSpace(chapter.Title)}
+	}
+	if err := ValidateChapters(cleaned, video.Metadata.Duration); err != nil {
+		return nil, err
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	result := s.videoCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": videoID, "deleted_at": nil},
+		bson.M{"$set": bson.M{"chapters": cleaned, "updated_at": time.Now()}},
+		opts,
+	)
+	if result.Err() != nil {
+		if result.Err() == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, result.Err()
+	}
+
+	var updated Video
+	if err := result.Decode(&updated); err != nil {
+		return nil, err
 	}
+	return &updated, nil
+}
 
+// SoftDeleteVideo hides a video from listings and lookups until it is restored or purged
+func (s *VideoService) SoftDeleteVideo(ctx context.Context, id primitive.ObjectID) error {
+	now := time.Now()
+	result, err := s.videoCollection.UpdateOne(ctx,
+		bson.M{"_id": id, "deleted_at": nil},
+		bson.M{"$set": bson.M{"deleted_at": now, "updated_at": now}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete video: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
 	return nil
 }
 
+// RestoreVideo undoes a soft delete
+func (s *VideoService) RestoreVideo(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.videoCollection.UpdateOne(ctx,
+		bson.M{"_id": id, "deleted_at": bson.M{"$ne": nil}},
+		bson.M{
+			"$unset": bson.M{"deleted_at": ""},
+			"$set":   bson.M{"updated_at": time.Now()},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to restore video: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// PurgeDeletedVideos permanently removes videos soft-deleted more than olderThan ago,
+// including their files, and returns how many were purged
+func (s *VideoService) PurgeDeletedVideos(ctx context.Context, olderThan time.Duration) (int, error) {
+	cutoff := time.Now().Add(-olderThan)
+	cursor, err := s.videoCollection.Find(ctx,
+		bson.M{"deleted_at": bson.M{"$lte": cutoff}},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find deleted videos: %w", err)
+	}
+
+	var expired []Video
+	if err := cursor.All(ctx, &expired); err != nil {
+		return 0, fmt.Errorf("failed to decode deleted videos: %w", err)
+	}
+
+	purged := 0
+	for _, video := range expired {
+		if err := s.purgeVideo(ctx, video.ID); err != nil {
+			logger.FromContext(ctx).Error("failed to purge deleted video", "video_id", video.ID.Hex(), "error", err)
+			continue
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// StartPurgeJanitor purges videos soft-deleted longer than retention, once an hour,
+// until ctx is done
+func (s *VideoService) StartPurgeJanitor(ctx context.Context, retention time.Duration) {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			purged, err := s.PurgeDeletedVideos(ctx, retention)
+			if err != nil {
+				logger.FromContext(ctx).Error("deleted video purge failed", "error", err)
+				continue
+			}
+			if purged > 0 {
+				logger.FromContext(ctx).Info("purged deleted videos", "count", purged)
+			}
+		}
+	}()
+}
+
+// StartProcessing runs the processing queue's workers until ctx is done
+func (s *VideoService) StartProcessing(ctx context.Context) {
+	s.queue.Start(ctx)
+}
+
+// Shutdown waits for running processing jobs to finish, until ctx is done. Jobs still
+// running then are abandoned and retried once their lease runs out.
+func (s *VideoService) Shutdown(ctx context.Context) error {
+	return s.queue.Wait(ctx)
+}
+
+// SetThumbnail makes the candidate at index the video's active thumbnail
+func (s *VideoService) SetThumbnail(ctx context.Context, videoID primitive.ObjectID, index int) (*Video, error) {
+	video, err := s.GetVideoByID(ctx, videoID)
+	if err != nil {
+		return nil, err
+	}
+
+	if index < 0 || index >= len(video.ThumbnailCandidates) {
+		return nil, ErrThumbnailIndexOutOfRange
+	}
+
+	video.ThumbnailPath = video.ThumbnailCandidates[index]
+	video.UpdatedAt = time.Now()
+
+	_, err = s.videoCollection.UpdateOne(ctx,
+		bson.M{"_id": videoID},
+		bson.M{"$set": bson.M{"thumbnail_path": video.ThumbnailPath, "updated_at": video.UpdatedAt}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set thumbnail: %w", err)
+	}
+
+	return video, nil
+}
+
+// RecordView counts a view of the video unless the same viewer was already counted in
+// the current dedup bucket. It reports whether the view was counted.
+func (s *VideoService) RecordView(ctx context.Context, videoID primitive.ObjectID, viewerKey string) (bool, error) {
+	now := time.Now()
+	view := View{
+		VideoID:   videoID,
+		ViewerKey: viewerKey,
+		Bucket:    viewBucket(now),
+		CreatedAt: now,
+	}
+
+	if _, err := s.viewCollection.InsertOne(ctx, view); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to record view: %w", err)
+	}
+
+	if err := s.IncrementViewCount(ctx, videoID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// viewBucket returns the start of the dedup bucket containing t
+func viewBucket(t time.Time) time.Time {
+	return t.UTC().Truncate(ViewDedupWindow)
+}
+
 // IncrementViewCount increments the view count for a video when it's watched
 func (s *VideoService) IncrementViewCount(ctx context.Context, videoID primitive.ObjectID) error {
 	update := bson.M{"$inc": bson.M{"view_count": 1}}
@@ -583,13 +1757,326 @@ func (s *VideoService) IncrementViewCount(ctx context.Context, videoID primitive
 	return nil
 }
 
-// GetPopularVideos returns videos ordered by view count (most viewed first)
+// LikeVideo records a like from the user and increments the video's like count.
+// Liking a video that the user already liked is a no-op.
+func (s *VideoService) LikeVideo(ctx context.Context, videoID, userID primitive.ObjectID) error {
+	if _, err := s.GetVideoByID(ctx, videoID, userID); err != nil {
+		return err
+	}
+
+	like := Like{
+		ID:        primitive.NewObjectID(),
+		VideoID:   videoID,
+		UserID:    userID,
+		CreatedAt: time.Now(),
+	}
+
+	if _, err := s.likeCollection.InsertOne(ctx, like); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil // Already liked
+		}
+		return fmt.Errorf("failed to like video: %w", err)
+	}
+
+	_, err := s.videoCollection.UpdateOne(ctx, bson.M{"_id": videoID}, bson.M{"$inc": bson.M{"like_count": 1}})
+	if err != nil {
+		return fmt.Errorf("failed to increment like count: %w", err)
+	}
+
+	return nil
+}
+
+// UnlikeVideo removes the user's like and decrements the video's like count.
+// Unliking a video that the user has not liked is a no-op.
+func (s *VideoService) UnlikeVideo(ctx context.Context, videoID, userID primitive.ObjectID) error {
+	result, err := s.likeCollection.DeleteOne(ctx, bson.M{"video_id": videoID, "user_id": userID})
+	if err != nil {
+		return fmt.Errorf("failed to unlike video: %w", err)
+	}
+
+	if result.DeletedCount == 0 {
+		return nil // Not liked
+	}
+
+	_, err = s.videoCollection.UpdateOne(ctx,
+		bson.M{"_id": videoID, "like_count": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"like_count": -1}})
+	if err != nil {
+		return fmt.Errorf("failed to decrement like count: %w", err)
+	}
+
+	return nil
+}
+
+// HasUserLiked reports whether the user has liked the video
+func (s *VideoService) HasUserLiked(ctx context.Context, videoID, userID primitive.ObjectID) (bool, error) {
+	count, err := s.likeCollection.CountDocuments(ctx, bson.M{"video_id": videoID, "user_id": userID}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// RecordWatchProgress stores the user's playback position for a video. The position
+// is clamped to the video's duration.
+func (s *VideoService) RecordWatchProgress(ctx context.Context, userID, videoID primitive.ObjectID, position float64) (*WatchHistory, error) {
+	video, err := s.GetVideoByID(ctx, videoID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if position < 0 {
+		position = 0
+	}
+	if duration := video.Metadata.Duration; duration > 0 && position > duration {
+		position = duration
+	}
+
+	now := time.Now()
+	filter := bson.M{"user_id": userID, "video_id": videoID}
+	update := bson.M{
+		"$set": bson.M{
+			"position_seconds": position,
+			"updated_at":       now,
+		},
+	}
+
+	_, err = s.historyCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record watch progress: %w", err)
+	}
+
+	return &WatchHistory{
+		UserID:          userID,
+		VideoID:         videoID,
+		PositionSeconds: position,
+		UpdatedAt:       now,
+	}, nil
+}
+
+// GetWatchHistory returns the user's watch history, most recently watched first
+func (s *VideoService) GetWatchHistory(ctx context.Context, userID primitive.ObjectID, limit int) ([]*WatchHistory, error) {
+	findOptions := options.Find().SetSort(bson.D{{Key: "updated_at", Value: -1}})
+	if limit > 0 {
+		findOptions.SetLimit(int64(limit))
+	}
+
+	cursor, err := s.historyCollection.Find(ctx, bson.M{"user_id": userID}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	history := []*WatchHistory{}
+	if err = cursor.All(ctx, &history); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// CountUserVideos returns the number of videos owned by the user, leaving out deleted videos
+func (s *VideoService) CountUserVideos(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return s.videoCollection.CountDocuments(ctx, bson.M{"user_id": userID, "deleted_at": nil})
+}
+
+// CountVideosByStatus returns the number of videos in each status, leaving out deleted
+// videos. Every status is listed, with 0 if no video has it.
+func (s *VideoService) CountVideosByStatus(ctx context.Context) (map[VideoStatus]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"deleted_at": nil}}},
+		{{Key: "$group", Value: bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}}},
+	}
+	cursor, err := s.videoCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count videos: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	counts := map[VideoStatus]int64{StatusPending: 0, StatusProcessing: 0, StatusCompleted: 0, StatusFailed: 0}
+	for cursor.Next(ctx) {
+		var group struct {
+			Status VideoStatus `bson:"_id"`
+			Count  int64       `bson:"count"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return nil, fmt.Errorf("failed to decode video counts: %w", err)
+		}
+		counts[group.Status] = group.Count
+	}
+	return counts, cursor.Err()
+}
+
+// CreatePlaylist creates an empty playlist owned by the user
+func (s *VideoService) CreatePlaylist(ctx context.Context, userID primitive.ObjectID, name string) (*Playlist, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("playlist name is required")
+	}
+
+	playlist := &Playlist{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Name:      name,
+		VideoIDs:  []primitive.ObjectID{},
+		CreatedAt: time.Now(),
+	}
+
+	if _, err := s.playlistCollection.InsertOne(ctx, playlist); err != nil {
+		return nil, fmt.Errorf("failed to create playlist: %w", err)
+	}
+
+	return playlist, nil
+}
+
+// AddToPlaylist appends a video to the user's playlist. The video must exist and
+// must not already be in the playlist.
+func (s *VideoService) AddToPlaylist(ctx context.Context, playlistID, userID, videoID primitive.ObjectID) error {
+	if _, err := s.GetVideoByID(ctx, videoID, userID); err != nil {
+		return err
+	}
+
+	// The $ne guard makes the duplicate check atomic with the push
+	result, err := s.playlistCollection.UpdateOne(ctx,
+		bson.M{"_id": playlistID, "user_id": userID, "video_ids": bson.M{"$ne": videoID}},
+		bson.M{"$push": bson.M{"video_ids": videoID}})
+	if err != nil {
+		return fmt.Errorf("failed to add video to playlist: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		playlist, err := s.getOwnedPlaylist(ctx, playlistID, userID)
+		if err != nil {
+			return err
+		}
+		for _, id := range playlist.VideoIDs {
+			if id == videoID {
+				return ErrVideoAlreadyInPlaylist
+			}
+		}
+		return ErrPlaylistNotFound
+	}
+
+	return nil
+}
+
+// RemoveFromPlaylist removes a video from the user's playlist. Removing a video
+// that is not in the playlist is a no-op.
+func (s *VideoService) RemoveFromPlaylist(ctx context.Context, playlistID, userID, videoID primitive.ObjectID) error {
+	result, err := s.playlistCollection.UpdateOne(ctx,
+		bson.M{"_id": playlistID, "user_id": userID},
+		bson.M{"$pull": bson.M{"video_ids": videoID}})
+	if err != nil {
+		return fmt.Errorf("failed to remove video from playlist: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		_, err := s.getOwnedPlaylist(ctx, playlistID, userID)
+		return err
+	}
+
+	return nil
+}
+
+// GetPlaylist retrieves a playlist with its videos loaded. Videos that no longer
+// exist, or that are private to someone other than requesterID, are left out.
+func (s *VideoService) GetPlaylist(ctx context.Context, playlistID, requesterID primitive.ObjectID) (*PlaylistWithVideos, error) {
+	var playlist Playlist
+	err := s.playlistCollection.FindOne(ctx, bson.M{"_id": playlistID}).Decode(&playlist)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrPlaylistNotFound
+		}
+		return nil, err
+	}
+
+	result := &PlaylistWithVideos{Playlist: playlist, Videos: []*Video{}}
+	if len(playlist.VideoIDs) == 0 {
+		return result, nil
+	}
+
+	cursor, err := s.videoCollection.Find(ctx, bson.M{"_id": bson.M{"$in": playlist.VideoIDs}, "deleted_at": nil})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load playlist videos: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var videos []*Video
+	if err = cursor.All(ctx, &videos); err != nil {
+		return nil, err
+	}
+
+	// Restore playlist order
+	byID := make(map[primitive.ObjectID]*Video, len(videos))
+	for _, v := range videos {
+		byID[v.ID] = v
+	}
+	for _, id := range playlist.VideoIDs {
+		if v, ok := byID[id]; ok && v.VisibleTo(requesterID) {
+			result.Videos = append(result.Videos, v)
+		}
+	}
+
+	return result, nil
+}
+
+// ListUserPlaylists returns the user's playlists, newest first
+func (s *VideoService) ListUserPlaylists(ctx context.Context, userID primitive.ObjectID) ([]*Playlist, error) {
+	findOptions := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	cursor, err := s.playlistCollection.Find(ctx, bson.M{"user_id": userID}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	playlists := []*Playlist{}
+	if err = cursor.All(ctx, &playlists); err != nil {
+		return nil, err
+	}
+
+	return playlists, nil
+}
+
+// getOwnedPlaylist loads a playlist and checks that it belongs to the user
+func (s *VideoService) getOwnedPlaylist(ctx context.Context, playlistID, userID primitive.ObjectID) (*Playlist, error) {
+	var playlist Playlist
+	err := s.playlistCollection.FindOne(ctx, bson.M{"_id": playlistID}).Decode(&playlist)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrPlaylistNotFound
+		}
+		return nil, err
+	}
+	if playlist.UserID != userID {
+		return nil, ErrPlaylistForbidden
+	}
+	return &playlist, nil
+}
+
+// GetPopularVideos returns videos ordered by view count (most viewed first). Results
+// are served from the result cache when one is set.
 func (s *VideoService) GetPopularVideos(ctx context.Context, limit int) ([]*Video, error) {
+	// Clamped before building the cache key, so arbitrary limits can't fill the cache
+	if limit < 1 {
+		limit = 10
+	}
+	if limit > MaxPopularLimit {
+		limit = MaxPopularLimit
+	}
+
+	return cache.Fetch(s.results, fmt.Sprintf("videos:popular:%d", limit), func() ([]*Video, error) {
+		return s.popularVideos(ctx, limit)
+	})
+}
+
+// popularVideos queries the videos for GetPopularVideos
+func (s *VideoService) popularVideos(ctx context.Context, limit int) ([]*Video, error) {
 	opts := options.Find().
 		SetSort(bson.D{{Key: "view_count", Value: -1}}).
 		SetLimit(int64(limit))
 	
-	cursor, err := s.videoCollection.Find(ctx, bson.M{"status": StatusCompleted}, opts)
+	cursor, err := s.videoCollection.Find(ctx, publicOnly(bson.M{"status": StatusCompleted, "deleted_at": nil}), opts)
 	if err != nil {
 		return nil, err
 	}
@@ -602,30 +2089,70 @@ func (s *VideoService) GetPopularVideos(ctx context.Context, limit int) ([]*Vide
 	return videos, nil
 }
 
-// GetTrendingVideos returns recently uploaded videos with high view counts
+// GetTrendingVideos returns completed videos ranked by view velocity: views within the
+// last daysBack days divided by the video's age. Results are served from the result
+// cache when one is set.
 func (s *VideoService) GetTrendingVideos(ctx context.Context, limit int, daysBack int) ([]*Video, error) {
-	// Calculate date threshold (e.g., videos from last 7 days)
-	threshold := time.Now().AddDate(0, 0, -daysBack)
-	
-	opts := options.Find().
-		SetSort(bson.D{
-			{Key: "view_count", Value: -1},
-			{Key: "created_at", Value: -1},
-		}).
-		SetLimit(int64(limit))
-	
-	filter := bson.M{
-		"status": StatusCompleted,
-		"created_at": bson.M{"$gte": threshold},
+	if limit < 1 {
+		limit = 10
 	}
-	
-	cursor, err := s.videoCollection.Find(ctx, filter, opts)
+	if limit > MaxTrendingLimit {
+		limit = MaxTrendingLimit
+	}
+	if daysBack < 1 {
+		daysBack = 7
+	}
+	if daysBack > MaxTrendingDays {
+		daysBack = MaxTrendingDays
+	}
+
+	key := fmt.Sprintf("videos:trending:%d:%d", limit, daysBack)
+	return cache.Fetch(s.results, key, func() ([]*Video, error) {
+		return s.trendingVideos(ctx, limit, daysBack)
+	})
+}
+
+// trendingVideos runs the aggregation for GetTrendingVideos
+func (s *VideoService) trendingVideos(ctx context.Context, limit int, daysBack int) ([]*Video, error) {
+	now := time.Now()
+	threshold := now.AddDate(0, 0, -daysBack)
+
+	// Views are watch-history entries touched within the window. Dividing by the video's
+	// age in hours (at least one) favours videos gaining views quickly over old favourites.
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"updated_at": bson.M{"$gte": threshold}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$video_id", "views": bson.M{"$sum": 1}}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         s.videoCollection.Name(),
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "video",
+		}}},
+		{{Key: "$unwind", Value: "$video"}},
+		{{Key: "$match", Value: bson.M{
+			"video.status":     StatusCompleted,
+			"video.deleted_at": nil,
+			"video.visibility": bson.M{"$nin": bson.A{VisibilityUnlisted, VisibilityPrivate}},
+		}}},
+		{{Key: "$addFields", Value: bson.M{
+			"age_hours": bson.M{"$max": bson.A{
+				1,
+				bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{now, "$video.created_at"}}, time.Hour.Milliseconds()}},
+			}},
+		}}},
+		{{Key: "$addFields", Value: bson.M{"score": bson.M{"$divide": bson.A{"$views", "$age_hours"}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "score", Value: -1}, {Key: "video.view_count", Value: -1}}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$video"}}},
+	}
+
+	cursor, err := s.historyCollection.Aggregate(ctx, pipeline)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to compute trending videos: %w", err)
 	}
 	defer cursor.Close(ctx)
-	
-	var videos []*Video
+
+	videos := []*Video{}
 	if err = cursor.All(ctx, &videos); err != nil {
 		return nil, err
 	}
@@ -655,21 +2182,21 @@ func (s *VideoService) ReprocessFailedVideos(ctx context.Context) error {
 		return fmt.Errorf("failed to decode videos for reprocessing: %w", err)
 	}
 
-	log.Printf("Found %d videos needing HLS file upload to GridFS", len(videos))
+	logger.FromContext(ctx).Info("found videos needing HLS file upload to GridFS", "count", len(videos))
 
 	for _, video := range videos {
-		log.Printf("Reprocessing video %s (%s)", video.ID.Hex(), video.Title)
+		logger.FromContext(ctx).Info("reprocessing video", "video_id", video.ID.Hex(), "title", video.Title)
 		
 		// Check if local processed files exist
-		processedDir := fmt.Sprintf("storage/processed/%s", video.ID.Hex())
+		processedDir := fmt.Sprintf("%s/%s", processedDir, video.ID.Hex())
 		if _, err := os.Stat(processedDir); os.IsNotExist(err) {
-			log.Printf("No processed files found for video %s, skipping", video.ID.Hex())
+			logger.FromContext(ctx).Warn("no processed files found for video, skipping", "video_id", video.ID.Hex())
 			continue
 		}
 
 		// Upload HLS files to GridFS
 		if err := uploadHLSToGridFS(s.fs, processedDir, video.ID); err != nil {
-			log.Printf("Failed to upload HLS files for video %s: %v", video.ID.Hex(), err)
+			logger.FromContext(ctx).Error("failed to upload HLS files", "video_id", video.ID.Hex(), "error", err)
 			continue
 		}
 
@@ -683,16 +2210,16 @@ func (s *VideoService) ReprocessFailedVideos(ctx context.Context) error {
 
 		_, err = s.videoCollection.UpdateOne(ctx, bson.M{"_id": video.ID}, update)
 		if err != nil {
-			log.Printf("Failed to update video %s with HLS path: %v", video.ID.Hex(), err)
+			logger.FromContext(ctx).Error("failed to save HLS path", "video_id", video.ID.Hex(), "error", err)
 			continue
 		}
 
 		// Clean up local files after successful upload
 		if err := os.RemoveAll(processedDir); err != nil {
-			log.Printf("Failed to clean up processed directory for video %s: %v", video.ID.Hex(), err)
+			logger.FromContext(ctx).Warn("failed to clean up processed directory", "video_id", video.ID.Hex(), "error", err)
 		}
 
-		log.Printf("Successfully reprocessed video %s", video.ID.Hex())
+		logger.FromContext(ctx).Info("reprocessed video", "video_id", video.ID.Hex())
 	}
 
 	return nil
@@ -720,11 +2247,11 @@ func (s *VideoService) MigrateVideoFieldNames(ctx context.Context) error {
 		return fmt.Errorf("failed to decode videos for field migration: %w", err)
 	}
 
-	log.Printf("Found %d videos needing field name migration", len(videos))
+	logger.FromContext(ctx).Info("found videos needing field name migration", "count", len(videos))
 
 	for _, video := range videos {
 		videoID := video["_id"].(primitive.ObjectID)
-		log.Printf("Migrating field names for video %s", videoID.Hex())
+		logger.FromContext(ctx).Info("migrating field names", "video_id", videoID.Hex())
 
 		updateFields := bson.M{}
 		unsetFields := bson.M{}
@@ -757,11 +2284,11 @@ func (s *VideoService) MigrateVideoFieldNames(ctx context.Context) error {
 
 			_, err = s.videoCollection.UpdateOne(ctx, bson.M{"_id": videoID}, update)
 			if err != nil {
-				log.Printf("Failed to migrate field names for video %s: %v", videoID.Hex(), err)
+				logger.FromContext(ctx).Error("failed to migrate field names", "video_id", videoID.Hex(), "error", err)
 				continue
 			}
 
-			log.Printf("Successfully migrated field names for video %s", videoID.Hex())
+			logger.FromContext(ctx).Info("migrated field names", "video_id", videoID.Hex())
 		}
 	}
 
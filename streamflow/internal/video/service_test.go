@@ -1,21 +1,35 @@
 package video
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"streamflow/internal/apperr"
+	"streamflow/internal/config"
 	"streamflow/internal/database"
+	"streamflow/internal/tags"
 
+	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var testVideoService *VideoService
@@ -41,7 +55,7 @@ func TestMain(m *testing.M) {
 
 	// Initialize test database service
 	testDbService = database.New()
-	testVideoService = NewVideoService(testDbService.GetDatabase())
+	testVideoService = NewVideoService(testDbService.GetDatabase(), config.VideoConfig{})
 	testUserID = primitive.NewObjectID()
 
 	code := m.Run()
@@ -93,30 +107,9 @@ func (s *VideoService) CreateVideoSimple(ctx context.Context, userID primitive.O
 	return newVideo, nil
 }
 
-// Add UpdateVideoMetadata method for testing
-func (s *VideoService) UpdateVideoMetadata(ctx context.Context, videoID primitive.ObjectID, metadata VideoMetadata) error {
-	update := bson.M{
-		"$set": bson.M{
-			"metadata":  metadata,
-			"updatedAt": time.Now(),
-		},
-	}
-
-	result, err := s.videoCollection.UpdateOne(ctx, bson.M{"_id": videoID}, update)
-	if err != nil {
-		return err
-	}
-
-	if result.MatchedCount == 0 {
-		return mongo.ErrNoDocuments
-	}
-
-	return nil
-}
-
 // Add GetUserVideos method for testing
 func (s *VideoService) GetUserVideos(ctx context.Context, userID primitive.ObjectID) ([]*Video, error) {
-	cursor, err := s.videoCollection.Find(ctx, bson.M{"user_id": userID})
+	cursor, err := s.videoCollection.Find(ctx, bson.M{"user_id": userID, "deleted_at": nil})
 	if err != nil {
 		return nil, err
 	}
@@ -480,6 +473,35 @@ func generateTestSuffix() string {
 	return time.Now().Format("20060102150405")
 }
 
+// testMP4Header is the leading ftyp box of an MP4 file, enough for content sniffing
+var testMP4Header = []byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom")
+
+// newTestFileHeader builds a multipart file header with real content, then overrides its
+// reported size so size limits can be tested without large files
+func newTestFileHeader(t *testing.T, filename string, size int64, contentType string, content []byte) *multipart.FileHeader {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	partHeader := make(textproto.MIMEHeader)
+	partHeader.Set("Content-Disposition", fmt.Sprintf(`form-data; name="video"; filename="%s"`, filename))
+	partHeader.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(partHeader)
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	part.Write(content)
+	writer.Close()
+
+	form, err := multipart.NewReader(body, writer.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatalf("Failed to read form: %v", err)
+	}
+	fileHeader := form.File["video"][0]
+	fileHeader.Size = size
+	return fileHeader
+}
+
 // ==================== COMPREHENSIVE ADDITIONAL TESTS ====================
 
 // Test Video Upload Workflows
@@ -490,6 +512,7 @@ func TestVideoService_VideoUploadWorkflow_FileValidation(t *testing.T) {
 		fileSize    int64
 		contentType string
 		filename    string
+		content     []byte
 		expectError bool
 		errorMsg    string
 	}{
@@ -509,10 +532,11 @@ func TestVideoService_VideoUploadWorkflow_FileValidation(t *testing.T) {
 			errorMsg:    "exceeds maximum allowed size",
 		},
 		{
-			name:        "invalid content type",
+			name:        "plaintext disguised as mp4",
 			fileSize:    50000000,
-			contentType: "video/quicktime",
-			filename:    "test.mov",
+			contentType: "video/mp4",
+			filename:    "test.mp4",
+			content:     []byte("this is just a text file pretending to be a video"),
 			expectError: true,
 			errorMsg:    "not allowed",
 		},
@@ -528,13 +552,12 @@ func TestVideoService_VideoUploadWorkflow_FileValidation(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Create mock file header
-			fileHeader := &multipart.FileHeader{
-				Filename: tt.filename,
-				Size:     tt.fileSize,
-				Header:   make(map[string][]string),
+			// Create file header; content defaults to a valid MP4 header
+			content := tt.content
+			if content == nil {
+				content = testMP4Header
 			}
-			fileHeader.Header.Set("Content-Type", tt.contentType)
+			fileHeader := newTestFileHeader(t, tt.filename, tt.fileSize, tt.contentType, content)
 
 			err := ValidateVideoFile(fileHeader)
 			
@@ -943,7 +966,7 @@ func TestVideoService_StorageManagement_CleanupProcedures(t *testing.T) {
 	}
 	
 	// Perform cleanup by deleting the video
-	err = testVideoService.DeleteVideo(ctx, video.ID)
+	err = testVideoService.DeleteVideo(ctx, video.ID, video.UserID)
 	if err != nil {
 		t.Errorf("Failed to delete video during cleanup: %v", err)
 		return
@@ -967,41 +990,38 @@ func TestVideoService_StorageManagement_CleanupProcedures(t *testing.T) {
 // Test Video Validation
 func TestVideoService_VideoValidation_ContentTypeValidation(t *testing.T) {
 	
+	// The declared Content-Type is always video/mp4; only the bytes decide
 	contentTypes := []struct {
-		contentType string
-		valid       bool
+		name    string
+		content []byte
+		valid   bool
 	}{
-		{"video/mp4", true},
-		{"video/avi", true},
-		{"video/mov", true},
-		{"video/mkv", true},
-		{"video/webm", true},
-		{"video/quicktime", false},
-		{"video/x-msvideo", false},
-		{"application/octet-stream", false},
-		{"image/jpeg", false},
-		{"text/plain", false},
+		{"mp4", testMP4Header, true},
+		{"quicktime", []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x02\x00qt  "), true},
+		{"avi", []byte("RIFF\x00\x00\x00\x00AVI LIST"), true},
+		{"webm", []byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x84webm"), true},
+		{"matroska", []byte("\x1a\x45\xdf\xa3\xa3\x42\x86\x81\x01\x42\x82\x88matroska"), true},
+		{"octet_stream", []byte{0x00, 0x01, 0x02, 0x03, 0xff, 0xfe}, false},
+		{"jpeg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), false},
+		{"text", []byte("plain text pretending to be a video"), false},
+		{"html", []byte("<html><script>alert(1)</script></html>"), false},
+		{"empty", []byte{}, false},
 	}
 	
 	for _, ct := range contentTypes {
-		t.Run("content_type_"+strings.ReplaceAll(ct.contentType, "/", "_"), func(t *testing.T) {
-			fileHeader := &multipart.FileHeader{
-				Filename: "test.mp4",
-				Size:     50000000,
-				Header:   make(map[string][]string),
-			}
-			fileHeader.Header.Set("Content-Type", ct.contentType)
+		t.Run("content_"+ct.name, func(t *testing.T) {
+			fileHeader := newTestFileHeader(t, "test.mp4", 50000000, "video/mp4", ct.content)
 			
 			err := ValidateVideoFile(fileHeader)
 			
 			if ct.valid && err != nil {
-				t.Errorf("Valid content type %s should not fail validation: %v", ct.contentType, err)
+				t.Errorf("Valid %s content should not fail validation: %v", ct.name, err)
 			} else if !ct.valid && err == nil {
-				t.Errorf("Invalid content type %s should fail validation", ct.contentType)
+				t.Errorf("Invalid %s content should fail validation", ct.name)
 			} else if ct.valid {
-				t.Logf("Successfully validated content type: %s", ct.contentType)
+				t.Logf("Successfully validated content: %s", ct.name)
 			} else {
-				t.Logf("Correctly rejected invalid content type: %s - %v", ct.contentType, err)
+				t.Logf("Correctly rejected invalid content: %s - %v", ct.name, err)
 			}
 		})
 	}
@@ -1026,12 +1046,7 @@ func TestVideoService_VideoValidation_MaliciousFileDetection(t *testing.T) {
 	
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			fileHeader := &multipart.FileHeader{
-				Filename: tc.filename,
-				Size:     tc.size,
-				Header:   make(map[string][]string),
-			}
-			fileHeader.Header.Set("Content-Type", "video/mp4")
+			fileHeader := newTestFileHeader(t, tc.filename, tc.size, "video/mp4", testMP4Header)
 			
 			err := ValidateVideoFile(fileHeader)
 			
@@ -2113,3 +2128,2912 @@ func TestVideoService_EdgeCases_BoundaryConditions(t *testing.T) {
 		})
 	}
 }
+
+// Test Video Search
+func TestVideoService_SearchVideos(t *testing.T) {
+	ctx := context.Background()
+
+	keyword := "nebula" + generateTestSuffix()
+
+	completedVideo, err := testVideoService.CreateVideoSimple(ctx, testUserID, "Searchable "+keyword, "A completed video")
+	if err != nil {
+		t.Fatalf("Failed to create completed video: %v", err)
+	}
+	if err := testVideoService.UpdateVideoStatus(ctx, completedVideo.ID, StatusCompleted); err != nil {
+		t.Fatalf("Failed to mark video completed: %v", err)
+	}
+
+	describedVideo, err := testVideoService.CreateVideoSimple(ctx, testUserID, "Another Video", "Description mentions "+strings.ToUpper(keyword))
+	if err != nil {
+		t.Fatalf("Failed to create described video: %v", err)
+	}
+	if err := testVideoService.UpdateVideoStatus(ctx, describedVideo.ID, StatusCompleted); err != nil {
+		t.Fatalf("Failed to mark video completed: %v", err)
+	}
+
+	pendingVideo, err := testVideoService.CreateVideoSimple(ctx, testUserID, "Pending "+keyword, "Still processing")
+	if err != nil {
+		t.Fatalf("Failed to create pending video: %v", err)
+	}
+
+	t.Run("matches title and description case-insensitively", func(t *testing.T) {
+		videos, err := testVideoService.SearchVideos(ctx, keyword, 1, 10)
+		if err != nil {
+			t.Fatalf("SearchVideos() unexpected error = %v", err)
+		}
+
+		found := map[primitive.ObjectID]bool{}
+		for _, v := range videos {
+			found[v.ID] = true
+			if v.Status != StatusCompleted {
+				t.Errorf("SearchVideos() returned video %s with status %s", v.ID.Hex(), v.Status)
+			}
+		}
+		if !found[completedVideo.ID] {
+			t.Error("SearchVideos() should match on title")
+		}
+		if !found[describedVideo.ID] {
+			t.Error("SearchVideos() should match on description regardless of case")
+		}
+		if found[pendingVideo.ID] {
+			t.Error("SearchVideos() should not return videos that are not completed")
+		}
+	})
+
+	t.Run("paginates results", func(t *testing.T) {
+		firstPage, err := testVideoService.SearchVideos(ctx, keyword, 1, 1)
+		if err != nil {
+			t.Fatalf("SearchVideos() unexpected error = %v", err)
+		}
+		secondPage, err := testVideoService.SearchVideos(ctx, keyword, 2, 1)
+		if err != nil {
+			t.Fatalf("SearchVideos() unexpected error = %v", err)
+		}
+		if len(firstPage) != 1 || len(secondPage) != 1 {
+			t.Fatalf("Expected one result per page, got %d and %d", len(firstPage), len(secondPage))
+		}
+		if firstPage[0].ID == secondPage[0].ID {
+			t.Error("Pages should not contain the same video")
+		}
+	})
+
+	t.Run("empty query returns empty slice", func(t *testing.T) {
+		videos, err := testVideoService.SearchVideos(ctx, "   ", 1, 10)
+		if err != nil {
+			t.Fatalf("SearchVideos() unexpected error = %v", err)
+		}
+		if videos == nil || len(videos) != 0 {
+			t.Errorf("Expected empty non-nil slice, got %v", videos)
+		}
+	})
+}
+
+// Test Admin Bulk Status Transitions
+func TestVideoService_AdminBulkSetStatus(t *testing.T) {
+	ctx := context.Background()
+
+	var ids []primitive.ObjectID
+	for i := 0; i < 3; i++ {
+		video, err := testVideoService.CreateVideoSimple(ctx, testUserID, fmt.Sprintf("Stuck Video %d %s", i, generateTestSuffix()), "Failed processing")
+		if err != nil {
+			t.Fatalf("Failed to create video: %v", err)
+		}
+		testVideoService.updateVideoStatus(ctx, video.ID, StatusFailed, "Transcoding failed")
+		ids = append(ids, video.ID)
+	}
+
+	updated, err := testVideoService.AdminBulkSetStatus(ctx, ids, StatusPending)
+	if err != nil {
+		t.Fatalf("AdminBulkSetStatus() unexpected error = %v", err)
+	}
+	if updated != len(ids) {
+		t.Errorf("AdminBulkSetStatus() updated = %d, want %d", updated, len(ids))
+	}
+
+	for _, id := range ids {
+		video, err := testVideoService.GetVideoByID(ctx, id)
+		if err != nil {
+			t.Fatalf("Failed to retrieve video %s: %v", id.Hex(), err)
+		}
+		if video.Status != StatusPending {
+			t.Errorf("Video %s status = %s, want %s", id.Hex(), video.Status, StatusPending)
+		}
+		if video.Error != "" {
+			t.Errorf("Video %s should have its error cleared, got %q", id.Hex(), video.Error)
+		}
+		// Reset videos are queued to be processed again
+		job, err := testVideoService.queue.GetJob(ctx, id)
+		if err != nil {
+			t.Fatalf("GetJob() of video %s unexpected error = %v", id.Hex(), err)
+		}
+		if job.Status != JobQueued || job.UserID != testUserID {
+			t.Errorf("Job of video %s = %+v, want a queued job", id.Hex(), job)
+		}
+	}
+
+	// A video whose job is still queued isn't queued twice
+	first, _ := testVideoService.queue.GetJob(ctx, ids[0])
+	if _, err := testVideoService.AdminBulkSetStatus(ctx, ids[:1], StatusPending); err != nil {
+		t.Fatalf("AdminBulkSetStatus() again unexpected error = %v", err)
+	}
+	if again, err := testVideoService.queue.GetJob(ctx, ids[0]); err != nil || again.ID != first.ID {
+		t.Errorf("GetJob() after a second reset = %+v, %v, want the first job %s", again, err, first.ID.Hex())
+	}
+
+	if _, err := testVideoService.AdminBulkSetStatus(ctx, ids, VideoStatus("CORRUPTED")); err == nil {
+		t.Error("AdminBulkSetStatus() should reject an invalid status")
+	}
+}
+
+// Test Video Likes
+func TestVideoService_LikeVideo(t *testing.T) {
+	ctx := context.Background()
+
+	video, err := testVideoService.CreateVideoSimple(ctx, testUserID, "Like Test "+generateTestSuffix(), "Testing likes")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+
+	liker := primitive.NewObjectID()
+
+	// Liking twice should only count once
+	for i := 0; i < 2; i++ {
+		if err := testVideoService.LikeVideo(ctx, video.ID, liker); err != nil {
+			t.Fatalf("LikeVideo() unexpected error = %v", err)
+		}
+	}
+
+	liked, err := testVideoService.HasUserLiked(ctx, video.ID, liker)
+	if err != nil {
+		t.Fatalf("HasUserLiked() unexpected error = %v", err)
+	}
+	if !liked {
+		t.Error("HasUserLiked() should be true after liking")
+	}
+
+	likedVideo, err := testVideoService.GetVideoByID(ctx, video.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve video: %v", err)
+	}
+	if likedVideo.LikeCount != 1 {
+		t.Errorf("LikeCount = %d, want 1", likedVideo.LikeCount)
+	}
+
+	// Unliking twice should only decrement once
+	for i := 0; i < 2; i++ {
+		if err := testVideoService.UnlikeVideo(ctx, video.ID, liker); err != nil {
+			t.Fatalf("UnlikeVideo() unexpected error = %v", err)
+		}
+	}
+
+	unlikedVideo, err := testVideoService.GetVideoByID(ctx, video.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve video: %v", err)
+	}
+	if unlikedVideo.LikeCount != 0 {
+		t.Errorf("LikeCount = %d, want 0", unlikedVideo.LikeCount)
+	}
+
+	if err := testVideoService.LikeVideo(ctx, primitive.NewObjectID(), liker); err == nil {
+		t.Error("LikeVideo() should fail for a missing video")
+	}
+}
+
+// Test Playlists
+func TestVideoService_Playlists(t *testing.T) {
+	ctx := context.Background()
+
+	owner := primitive.NewObjectID()
+	playlist, err := testVideoService.CreatePlaylist(ctx, owner, "Favourites "+generateTestSuffix())
+	if err != nil {
+		t.Fatalf("CreatePlaylist() unexpected error = %v", err)
+	}
+
+	first, err := testVideoService.CreateVideoSimple(ctx, testUserID, "Playlist Video 1 "+generateTestSuffix(), "First")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+	second, err := testVideoService.CreateVideoSimple(ctx, testUserID, "Playlist Video 2 "+generateTestSuffix(), "Second")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+
+	for _, v := range []*Video{second, first} {
+		if err := testVideoService.AddToPlaylist(ctx, playlist.ID, owner, v.ID); err != nil {
+			t.Fatalf("AddToPlaylist() unexpected error = %v", err)
+		}
+	}
+
+	if err := testVideoService.AddToPlaylist(ctx, playlist.ID, owner, first.ID); !errors.Is(err, ErrVideoAlreadyInPlaylist) {
+		t.Errorf("AddToPlaylist() duplicate error = %v, want ErrVideoAlreadyInPlaylist", err)
+	}
+	if err := testVideoService.AddToPlaylist(ctx, playlist.ID, owner, primitive.NewObjectID()); err == nil {
+		t.Error("AddToPlaylist() should fail for a missing video")
+	}
+	if err := testVideoService.AddToPlaylist(ctx, playlist.ID, primitive.NewObjectID(), first.ID); !errors.Is(err, ErrPlaylistForbidden) {
+		t.Errorf("AddToPlaylist() by another user error = %v, want ErrPlaylistForbidden", err)
+	}
+
+	hydrated, err := testVideoService.GetPlaylist(ctx, playlist.ID, testUserID)
+	if err != nil {
+		t.Fatalf("GetPlaylist() unexpected error = %v", err)
+	}
+	if len(hydrated.Videos) != 2 {
+		t.Fatalf("GetPlaylist() returned %d videos, want 2", len(hydrated.Videos))
+	}
+	if hydrated.Videos[0].ID != second.ID || hydrated.Videos[1].ID != first.ID {
+		t.Error("GetPlaylist() should return videos in playlist order")
+	}
+	if hydrated.Videos[0].Title != second.Title {
+		t.Errorf("GetPlaylist() video title = %v, want %v", hydrated.Videos[0].Title, second.Title)
+	}
+
+	if err := testVideoService.RemoveFromPlaylist(ctx, playlist.ID, owner, second.ID); err != nil {
+		t.Fatalf("RemoveFromPlaylist() unexpected error = %v", err)
+	}
+	hydrated, err = testVideoService.GetPlaylist(ctx, playlist.ID, testUserID)
+	if err != nil {
+		t.Fatalf("GetPlaylist() unexpected error = %v", err)
+	}
+	if len(hydrated.VideoIDs) != 1 || hydrated.VideoIDs[0] != first.ID {
+		t.Errorf("RemoveFromPlaylist() left VideoIDs = %v, want [%v]", hydrated.VideoIDs, first.ID)
+	}
+
+	playlists, err := testVideoService.ListUserPlaylists(ctx, owner)
+	if err != nil {
+		t.Fatalf("ListUserPlaylists() unexpected error = %v", err)
+	}
+	if len(playlists) != 1 || playlists[0].ID != playlist.ID {
+		t.Errorf("ListUserPlaylists() = %d playlists, want the created playlist only", len(playlists))
+	}
+
+	if _, err := testVideoService.GetPlaylist(ctx, primitive.NewObjectID(), testUserID); !errors.Is(err, ErrPlaylistNotFound) {
+		t.Errorf("GetPlaylist() missing error = %v, want ErrPlaylistNotFound", err)
+	}
+}
+
+// Test Watch History
+func TestVideoService_WatchHistory(t *testing.T) {
+	ctx := context.Background()
+
+	viewer := primitive.NewObjectID()
+
+	first, err := testVideoService.CreateVideoSimple(ctx, testUserID, "History Video 1 "+generateTestSuffix(), "First")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+	second, err := testVideoService.CreateVideoSimple(ctx, testUserID, "History Video 2 "+generateTestSuffix(), "Second")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		videoID  primitive.ObjectID
+		position float64
+		want     float64
+	}{
+		{"Position within duration", first.ID, 30, 30},
+		{"Negative position clamps to start", second.ID, -5, 0},
+		{"Position past duration clamps to end", second.ID, 500, second.Metadata.Duration},
+		{"Update existing entry", first.ID, 45.5, 45.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := testVideoService.RecordWatchProgress(ctx, viewer, tt.videoID, tt.position)
+			if err != nil {
+				t.Fatalf("RecordWatchProgress() unexpected error = %v", err)
+			}
+			if entry.PositionSeconds != tt.want {
+				t.Errorf("RecordWatchProgress() position = %v, want %v", entry.PositionSeconds, tt.want)
+			}
+		})
+	}
+
+	history, err := testVideoService.GetWatchHistory(ctx, viewer, 10)
+	if err != nil {
+		t.Fatalf("GetWatchHistory() unexpected error = %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("GetWatchHistory() returned %d entries, want 2 (one per video)", len(history))
+	}
+	if history[0].VideoID != first.ID {
+		t.Error("GetWatchHistory() should list the most recently watched video first")
+	}
+	if history[0].PositionSeconds != 45.5 {
+		t.Errorf("GetWatchHistory() position = %v, want 45.5", history[0].PositionSeconds)
+	}
+
+	limited, err := testVideoService.GetWatchHistory(ctx, viewer, 1)
+	if err != nil {
+		t.Fatalf("GetWatchHistory() unexpected error = %v", err)
+	}
+	if len(limited) != 1 {
+		t.Errorf("GetWatchHistory() with limit 1 returned %d entries", len(limited))
+	}
+
+	if _, err := testVideoService.RecordWatchProgress(ctx, viewer, primitive.NewObjectID(), 10); err == nil {
+		t.Error("RecordWatchProgress() should fail for a missing video")
+	}
+}
+
+// Test Thumbnail Timestamp Configuration
+func TestVideoService_ThumbnailAt(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		duration float64
+		want     float64
+		wantErr  bool
+	}{
+		{"Percentage of duration", "10%", 100, 10, false},
+		{"Fixed seconds", "5s", 100, 5, false},
+		{"Fractional seconds", "2.5s", 100, 2.5, false},
+		{"Default when unset is 10% of duration", "", 100, 10, false},
+		{"Percentage beyond duration clamps to end", "150%", 100, 100 - thumbnailEndMargin, false},
+		{"Seconds beyond duration clamps to end", "200s", 100, 100 - thumbnailEndMargin, false},
+		{"Full duration clamps to last frame", "100%", 100, 100 - thumbnailEndMargin, false},
+		{"Very short video clamps to start", "5s", 0.05, 0, false},
+		{"Missing unit", "10", 100, 0, true},
+		{"Negative value", "-5s", 100, 0, true},
+		{"Not a number", "abc%", 100, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, err := ParseThumbnailAt(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseThumbnailAt(%q) expected error", tt.spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseThumbnailAt(%q) unexpected error = %v", tt.spec, err)
+			}
+
+			got := at.Seconds(tt.duration)
+			if diff := got - tt.want; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("ParseThumbnailAt(%q).Seconds(%v) = %v, want %v", tt.spec, tt.duration, got, tt.want)
+			}
+		})
+	}
+}
+
+// Test HTTP Range Requests
+func TestVideoHandler_RangeRequests(t *testing.T) {
+	content := make([]byte, 1000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	tempFile, err := os.CreateTemp("", "range_test_*.mp4")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err := tempFile.Write(content); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+	tempFile.Close()
+
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Get("/stream", func(c *fiber.Ctx) error {
+		file, err := os.Open(tempFile.Name())
+		if err != nil {
+			return err
+		}
+		return serveContent(c, file, int64(len(content)), "video/mp4")
+	})
+
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		wantStatus   int
+		wantRange    string
+		wantBodyFrom int
+		wantBodyTo   int
+	}{
+		{"No range serves whole file", "", fiber.StatusOK, "", 0, 1000},
+		{"Mid-file range", "bytes=100-199", fiber.StatusPartialContent, "bytes 100-199/1000", 100, 200},
+		{"Open-ended range", "bytes=900-", fiber.StatusPartialContent, "bytes 900-999/1000", 900, 1000},
+		{"Suffix range", "bytes=-50", fiber.StatusPartialContent, "bytes 950-999/1000", 950, 1000},
+		{"End past file size is clamped", "bytes=990-5000", fiber.StatusPartialContent, "bytes 990-999/1000", 990, 1000},
+		{"Multi-range falls back to first range", "bytes=10-19, 500-599", fiber.StatusPartialContent, "bytes 10-19/1000", 10, 20},
+		{"Start past file size", "bytes=1000-1100", fiber.StatusRequestedRangeNotSatisfiable, "bytes */1000", 0, 0},
+		{"Malformed range", "bytes=abc-def", fiber.StatusRequestedRangeNotSatisfiable, "bytes */1000", 0, 0},
+		{"Wrong unit", "items=0-10", fiber.StatusRequestedRangeNotSatisfiable, "bytes */1000", 0, 0},
+		{"Reversed range", "bytes=200-100", fiber.StatusRequestedRangeNotSatisfiable, "bytes */1000", 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/stream", nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("Status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get("Content-Range"); got != tt.wantRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.wantRange)
+			}
+			if tt.wantStatus == fiber.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			if got := resp.Header.Get("Accept-Ranges"); got != "bytes" {
+				t.Errorf("Accept-Ranges = %q, want bytes", got)
+			}
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read body: %v", err)
+			}
+			if !bytes.Equal(body, content[tt.wantBodyFrom:tt.wantBodyTo]) {
+				t.Errorf("Body = %d bytes, want bytes %d-%d of the file", len(body), tt.wantBodyFrom, tt.wantBodyTo-1)
+			}
+		})
+	}
+}
+
+// Test HLS Transcoding Output
+func TestVideoService_HLSTranscoding(t *testing.T) {
+	t.Run("Master playlist references every rendition", func(t *testing.T) {
+		master := buildMasterPlaylist(DefaultHLSLadder)
+		if !strings.HasPrefix(master, "#EXTM3U\n") {
+			t.Error("Master playlist should start with #EXTM3U")
+		}
+		for _, rendition := range DefaultHLSLadder {
+			if !strings.Contains(master, rendition.Name+".m3u8\n") {
+				t.Errorf("Master playlist missing variant %s", rendition.Name)
+			}
+			if !strings.Contains(master, fmt.Sprintf("BANDWIDTH=%d", rendition.Bandwidth)) {
+				t.Errorf("Master playlist missing bandwidth for %s", rendition.Name)
+			}
+		}
+	})
+
+	t.Run("Content types", func(t *testing.T) {
+		tests := []struct {
+			name string
+			want string
+			ok   bool
+		}{
+			{"playlist.m3u8", "application/vnd.apple.mpegurl", true},
+			{"720p.m3u8", "application/vnd.apple.mpegurl", true},
+			{"720p_003.ts", "video/MP2T", true},
+			{"segment000.ts", "video/MP2T", true},
+			{"secrets.txt", "", false},
+		}
+		for _, tt := range tests {
+			got, ok := hlsContentType(tt.name)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("hlsContentType(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+			}
+		}
+	})
+
+	t.Run("Playlist URLs are rewritten to the segment route", func(t *testing.T) {
+		handler := NewVideoHandler(testVideoService)
+		rewritten := handler.processPlaylistForAbsoluteURLs(buildMasterPlaylist(DefaultHLSLadder), "http://localhost", "abc", "")
+		if !strings.Contains(rewritten, "http://localhost/stream/abc/segments/720p.m3u8") {
+			t.Errorf("Variant playlist URL not rewritten:\n%s", rewritten)
+		}
+	})
+
+	t.Run("Partial output is removed on failure", func(t *testing.T) {
+		outputDir := filepath.Join(t.TempDir(), "hls")
+		_, err := NewFFmpegService().TranscodeToHLS(context.Background(), "does/not/exist.mp4", outputDir, 0)
+		if err == nil {
+			t.Fatal("TranscodeToHLS() should fail for a missing input")
+		}
+		if _, statErr := os.Stat(outputDir); !os.IsNotExist(statErr) {
+			t.Errorf("TranscodeToHLS() left output directory behind after failure")
+		}
+	})
+
+	t.Run("Configured profiles build the ladder", func(t *testing.T) {
+		ladder := NewHLSLadder([]config.TranscodeProfile{
+			{Name: "2160p", Height: 2160, Bitrate: 16000},
+			{Name: "360p", Height: 360, Bitrate: 800},
+		})
+		want := []HLSRendition{
+			{Name: "360p", Height: 360, VideoBitrate: "800k", AudioBitrate: "128k", Bandwidth: 928000},
+			{Name: "2160p", Height: 2160, VideoBitrate: "16000k", AudioBitrate: "192k", Bandwidth: 16192000},
+		}
+		if fmt.Sprint(ladder) != fmt.Sprint(want) {
+			t.Errorf("NewHLSLadder() = %+v, want %+v", ladder, want)
+		}
+
+		ffmpeg := &FFmpegService{ladder: ladder}
+		for _, tt := range []struct {
+			sourceHeight int
+			want         []string
+		}{
+			{0, []string{"360p", "2160p"}},
+			{240, []string{"360p"}},
+			{1080, []string{"360p"}},
+			{2160, []string{"360p", "2160p"}},
+		} {
+			var names []string
+			for _, rendition := range ffmpeg.renditionsFor(tt.sourceHeight) {
+				names = append(names, rendition.Name)
+			}
+			if fmt.Sprint(names) != fmt.Sprint(tt.want) {
+				t.Errorf("renditionsFor(%d) = %v, want %v", tt.sourceHeight, names, tt.want)
+			}
+		}
+	})
+
+	t.Run("Only rungs up to the source height are produced", func(t *testing.T) {
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			t.Skip("ffmpeg not available")
+		}
+		sourcePath := filepath.Join(t.TempDir(), "ladder.mp4")
+		cmd := exec.Command("ffmpeg",
+			"-f", "lavfi", "-i", "testsrc=s=320x240:r=10",
+			"-f", "lavfi", "-i", "sine",
+			"-t", "1", "-c:v", "libx264", "-pix_fmt", "yuv420p", "-c:a", "aac",
+			"-y", sourcePath)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Failed to generate test video: %v - %s", err, out)
+		}
+
+		ffmpeg := NewFFmpegService()
+		ffmpeg.ladder = NewHLSLadder([]config.TranscodeProfile{
+			{Name: "180p", Height: 180, Bitrate: 300},
+			{Name: "360p", Height: 360, Bitrate: 800},
+		})
+		outputDir := filepath.Join(t.TempDir(), "hls")
+		renditions, err := ffmpeg.TranscodeToHLS(context.Background(), sourcePath, outputDir, 240)
+		if err != nil {
+			t.Fatalf("TranscodeToHLS() unexpected error = %v", err)
+		}
+		if len(renditions) != 1 || renditions[0].Name != "180p" {
+			t.Fatalf("TranscodeToHLS() produced %+v, want only 180p", renditions)
+		}
+
+		if _, err := os.Stat(filepath.Join(outputDir, "180p.m3u8")); err != nil {
+			t.Errorf("180p variant playlist missing: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(outputDir, "360p.m3u8")); !os.IsNotExist(err) {
+			t.Errorf("360p variant produced for a 240p source")
+		}
+		master, err := os.ReadFile(filepath.Join(outputDir, HLSMasterPlaylist))
+		if err != nil {
+			t.Fatalf("Failed to read master playlist: %v", err)
+		}
+		if strings.Contains(string(master), "360p") {
+			t.Errorf("Master playlist advertises a rung that wasn't produced:\n%s", master)
+		}
+	})
+}
+
+// Test Thumbnail Generation
+func TestVideoService_GenerateThumbnail(t *testing.T) {
+	t.Run("Failed extraction leaves no output", func(t *testing.T) {
+		outputPath := filepath.Join(t.TempDir(), "thumbs", "missing.jpg")
+		err := NewFFmpegService().GenerateThumbnail(context.Background(), "does/not/exist.mp4", 1, outputPath)
+		if err == nil {
+			t.Fatal("GenerateThumbnail() should fail for a missing input")
+		}
+		if _, statErr := os.Stat(outputPath); !os.IsNotExist(statErr) {
+			t.Error("GenerateThumbnail() left a partial thumbnail behind")
+		}
+	})
+
+	t.Run("Content type is sniffed from the image", func(t *testing.T) {
+		tests := []struct {
+			name string
+			data []byte
+			want string
+		}{
+			{"JPEG", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x10, 'J', 'F', 'I', 'F'}, "image/jpeg"},
+			{"PNG", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "image/png"},
+			{"Unknown falls back to JPEG", []byte("not an image"), "image/jpeg"},
+		}
+		for _, tt := range tests {
+			if got := thumbnailContentType(tt.data); got != tt.want {
+				t.Errorf("thumbnailContentType(%s) = %s, want %s", tt.name, got, tt.want)
+			}
+		}
+	})
+}
+
+// Test Thumbnail Candidates
+func TestVideoService_ThumbnailCandidates(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Grid positions are evenly spaced", func(t *testing.T) {
+		positions := thumbnailGridPositions(100, 4)
+		want := []float64{20, 40, 60, 80}
+		if len(positions) != len(want) {
+			t.Fatalf("thumbnailGridPositions() returned %d positions, want %d", len(positions), len(want))
+		}
+		for i := range want {
+			if positions[i] != want[i] {
+				t.Errorf("thumbnailGridPositions()[%d] = %v, want %v", i, positions[i], want[i])
+			}
+		}
+	})
+
+	t.Run("Grid rejects a non-positive count", func(t *testing.T) {
+		if _, err := NewFFmpegService().GenerateThumbnailGrid(ctx, "video.mp4", 0, t.TempDir()); err == nil {
+			t.Error("GenerateThumbnailGrid() should reject a count of 0")
+		}
+	})
+
+	video, err := testVideoService.CreateVideoSimple(ctx, testUserID, "Thumbnail Candidates "+generateTestSuffix(), "Testing thumbnail selection")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+
+	candidates := []string{primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()}
+	_, err = testVideoService.videoCollection.UpdateOne(ctx,
+		bson.M{"_id": video.ID},
+		bson.M{"$set": bson.M{"thumbnail_candidates": candidates, "thumbnail_path": candidates[0]}})
+	if err != nil {
+		t.Fatalf("Failed to seed thumbnail candidates: %v", err)
+	}
+
+	updated, err := testVideoService.SetThumbnail(ctx, video.ID, 2)
+	if err != nil {
+		t.Fatalf("SetThumbnail() unexpected error = %v", err)
+	}
+	if updated.ThumbnailPath != candidates[2] {
+		t.Errorf("SetThumbnail() ThumbnailPath = %s, want %s", updated.ThumbnailPath, candidates[2])
+	}
+
+	stored, err := testVideoService.GetVideoByID(ctx, video.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve video: %v", err)
+	}
+	if stored.ThumbnailPath != candidates[2] {
+		t.Errorf("Stored ThumbnailPath = %s, want %s", stored.ThumbnailPath, candidates[2])
+	}
+
+	for _, index := range []int{-1, 3} {
+		if _, err := testVideoService.SetThumbnail(ctx, video.ID, index); !errors.Is(err, ErrThumbnailIndexOutOfRange) {
+			t.Errorf("SetThumbnail(%d) error = %v, want ErrThumbnailIndexOutOfRange", index, err)
+		}
+	}
+}
+
+// Test ffprobe metadata parsing
+func TestVideoService_ProbeMetadata(t *testing.T) {
+	t.Run("Parses ffprobe output", func(t *testing.T) {
+		output := []byte(`{
+			"streams": [
+				{"codec_type": "video", "codec_name": "h264", "width": 1280, "height": 720, "r_frame_rate": "30000/1001"},
+				{"codec_type": "audio", "codec_name": "aac"}
+			],
+			"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "42.500000", "bit_rate": "2500000"}
+		}`)
+
+		metadata, err := parseProbeOutput(output)
+		if err != nil {
+			t.Fatalf("parseProbeOutput() unexpected error = %v", err)
+		}
+
+		want := VideoMetadata{
+			Duration:   42.5,
+			Width:      1280,
+			Height:     720,
+			Codec:      "h264",
+			AudioCodec: "aac",
+			Bitrate:    2500,
+			FrameRate:  30000.0 / 1001.0,
+			Container:  "mov,mp4,m4a,3gp,3g2,mj2",
+		}
+		if *metadata != want {
+			t.Errorf("parseProbeOutput() = %+v, want %+v", *metadata, want)
+		}
+	})
+
+	t.Run("Falls back to video stream values", func(t *testing.T) {
+		output := []byte(`{
+			"streams": [{"codec_type": "video", "codec_name": "vp9", "width": 640, "height": 360, "duration": "10.0", "bit_rate": "800000", "r_frame_rate": "25/1"}],
+			"format": {}
+		}`)
+
+		metadata, err := parseProbeOutput(output)
+		if err != nil {
+			t.Fatalf("parseProbeOutput() unexpected error = %v", err)
+		}
+		if metadata.Duration != 10 || metadata.Bitrate != 800 || metadata.FrameRate != 25 {
+			t.Errorf("parseProbeOutput() = %+v, want duration 10, bitrate 800, frame rate 25", *metadata)
+		}
+	})
+
+	t.Run("Rejects invalid output", func(t *testing.T) {
+		if _, err := parseProbeOutput([]byte("not json")); err == nil {
+			t.Error("parseProbeOutput() should reject invalid JSON")
+		}
+	})
+
+	t.Run("Missing ffprobe is reported", func(t *testing.T) {
+		ffmpeg := NewFFmpegService()
+		ffmpeg.ffprobePath = "ffprobe-does-not-exist"
+
+		if _, err := ffmpeg.ProbeMetadata(context.Background(), "video.mp4"); !errors.Is(err, ErrFFprobeUnavailable) {
+			t.Errorf("ProbeMetadata() error = %v, want ErrFFprobeUnavailable", err)
+		}
+	})
+}
+
+// Test resumable chunked uploads
+func TestUploadSessionStore(t *testing.T) {
+	store := NewUploadSessionStore(t.TempDir())
+	userID := primitive.NewObjectID()
+
+	t.Run("Rejects invalid sessions", func(t *testing.T) {
+		invalid := []InitUploadRequest{
+			{Title: "No chunks", Filename: "video.mp4", TotalChunks: 0},
+			{Title: "Too many chunks", Filename: "video.mp4", TotalChunks: MaxUploadChunks + 1},
+			{Title: "Bad extension", Filename: "video.txt", TotalChunks: 1},
+		}
+		for _, req := range invalid {
+			if _, err := store.Create(userID, req); err == nil {
+				t.Errorf("Create(%q) should fail", req.Title)
+			}
+		}
+	})
+
+	session, err := store.Create(userID, InitUploadRequest{Title: "Chunked", Filename: "video.mp4", TotalChunks: 3})
+	if err != nil {
+		t.Fatalf("Create() unexpected error = %v", err)
+	}
+
+	// Chunks arrive out of order and chunk 1 is re-sent after a dropped connection
+	chunks := map[int]string{2: "cc", 0: "aaa", 1: "xx"}
+	for _, index := range []int{2, 0, 1} {
+		if err := store.WriteChunk(session.ID, userID, index, strings.NewReader(chunks[index])); err != nil {
+			t.Fatalf("WriteChunk(%d) unexpected error = %v", index, err)
+		}
+	}
+
+	if _, _, err := store.Assemble(session.ID, primitive.NewObjectID()); !errors.Is(err, ErrUploadSessionNotFound) {
+		t.Errorf("Assemble() by another user error = %v, want ErrUploadSessionNotFound", err)
+	}
+	if err := store.WriteChunk(session.ID, userID, 3, strings.NewReader("d")); !errors.Is(err, ErrChunkIndexOutOfRange) {
+		t.Errorf("WriteChunk(3) error = %v, want ErrChunkIndexOutOfRange", err)
+	}
+
+	if err := store.WriteChunk(session.ID, userID, 1, strings.NewReader("bb")); err != nil {
+		t.Fatalf("WriteChunk(1) resend unexpected error = %v", err)
+	}
+
+	status, err := store.Status(session.ID, userID)
+	if err != nil {
+		t.Fatalf("Status() unexpected error = %v", err)
+	}
+	if len(status.ReceivedChunks) != 3 || status.ReceivedBytes != 7 {
+		t.Errorf("Status() = %v chunks / %d bytes, want 3 chunks / 7 bytes", status.ReceivedChunks, status.ReceivedBytes)
+	}
+
+	_, path, err := store.Assemble(session.ID, userID)
+	if err != nil {
+		t.Fatalf("Assemble() unexpected error = %v", err)
+	}
+	// The session is claimed until it is deleted
+	if _, _, err := store.Assemble(session.ID, userID); !errors.Is(err, ErrUploadCompleting) {
+		t.Errorf("Assemble() a second time error = %v, want ErrUploadCompleting", err)
+	}
+	if err := store.WriteChunk(session.ID, userID, 0, strings.NewReader("zzz")); !errors.Is(err, ErrUploadCompleting) {
+		t.Errorf("WriteChunk() while completing error = %v, want ErrUploadCompleting", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read assembled file: %v", err)
+	}
+	if string(data) != "aaabbcc" {
+		t.Errorf("Assembled file = %q, want %q", data, "aaabbcc")
+	}
+
+	store.Delete(session.ID)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Delete() should remove the session files")
+	}
+	if _, err := store.Status(session.ID, userID); !errors.Is(err, ErrUploadSessionNotFound) {
+		t.Errorf("Status() after Delete error = %v, want ErrUploadSessionNotFound", err)
+	}
+
+	t.Run("Incomplete and oversized uploads are rejected", func(t *testing.T) {
+		session, err := store.Create(userID, InitUploadRequest{Title: "Partial", Filename: "video.mp4", TotalChunks: 2})
+		if err != nil {
+			t.Fatalf("Create() unexpected error = %v", err)
+		}
+		defer store.Delete(session.ID)
+
+		if err := store.WriteChunk(session.ID, userID, 0, strings.NewReader("a")); err != nil {
+			t.Fatalf("WriteChunk(0) unexpected error = %v", err)
+		}
+		if _, _, err := store.Assemble(session.ID, userID); !errors.Is(err, ErrUploadIncomplete) {
+			t.Errorf("Assemble() error = %v, want ErrUploadIncomplete", err)
+		}
+
+		// Pretend chunk 0 was nearly the whole allowance so chunk 1 overflows it
+		session.chunkSizes[0] = MaxFileSize - 1
+		if err := store.WriteChunk(session.ID, userID, 1, strings.NewReader("bb")); !errors.Is(err, ErrUploadTooLarge) {
+			t.Errorf("WriteChunk(1) error = %v, want ErrUploadTooLarge", err)
+		}
+	})
+}
+
+// Test that over-length uploads are rejected and cleaned up
+func TestVideoService_MaxDurationUpload(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not available")
+	}
+	ctx := context.Background()
+
+	// A tiny, low frame rate clip that is one second longer than allowed
+	sourcePath := filepath.Join(t.TempDir(), "overlong.mp4")
+	cmd := exec.Command("ffmpeg",
+		"-f", "lavfi", "-i", "color=c=black:s=16x16:r=1",
+		"-t", fmt.Sprint(MaxDuration+1),
+		"-c:v", "libx264", "-pix_fmt", "yuv420p",
+		"-y", sourcePath)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Failed to generate test video: %v - %s", err, out)
+	}
+
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		t.Fatalf("Failed to open test video: %v", err)
+	}
+	defer file.Close()
+
+	title := "Overlong " + generateTestSuffix()
+	_, createErr := testVideoService.CreateVideo(ctx, file, title, "Too long", "", testUserID, nil)
+	if !errors.Is(createErr, ErrVideoTooLong) {
+		t.Fatalf("CreateVideo() error = %v, want ErrVideoTooLong", createErr)
+	}
+
+	var stored Video
+	if err := testVideoService.videoCollection.FindOne(ctx, bson.M{"title": title}).Decode(&stored); err != nil {
+		t.Fatalf("Failed to find failed video record: %v", err)
+	}
+	if stored.Status != StatusFailed {
+		t.Errorf("Video status = %s, want %s", stored.Status, StatusFailed)
+	}
+	if stored.Error != "exceeds maximum duration" {
+		t.Errorf("Video error = %q, want %q", stored.Error, "exceeds maximum duration")
+	}
+
+	// Neither the temporary copy nor the stored original may be left behind
+	tempFilePath := fmt.Sprintf("storage/uploads/%s_temp.mp4", stored.ID.Hex())
+	if _, err := os.Stat(tempFilePath); !os.IsNotExist(err) {
+		t.Errorf("Temporary upload %s should have been removed", tempFilePath)
+	}
+	if _, err := testVideoService.storage.Open(ctx, stored.FilePath); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Open() of the over-length upload error = %v, want ErrFileNotFound", err)
+	}
+
+	t.Run("Handler responds 400", func(t *testing.T) {
+		if status := apperr.StatusOf(createVideoError(createErr)); status != fiber.StatusBadRequest {
+			t.Errorf("createVideoError() status = %d, want %d", status, fiber.StatusBadRequest)
+		}
+	})
+}
+
+// Test that an upload rejected as invalid doesn't leave its file in storage
+func TestVideoService_RejectedUploadDeletesFile(t *testing.T) {
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		t.Skip("ffprobe not available")
+	}
+	ctx := context.Background()
+
+	title := "Rejected " + generateTestSuffix()
+	garbage := bytes.NewReader(append(append([]byte{}, testMP4Header...), bytes.Repeat([]byte{0xAB}, 4096)...))
+	if _, err := testVideoService.CreateVideo(ctx, garbage, title, "Not a video", "", testUserID, nil); err == nil {
+		t.Fatal("CreateVideo() of an invalid file should fail")
+	}
+
+	var stored Video
+	if err := testVideoService.videoCollection.FindOne(ctx, bson.M{"title": title}).Decode(&stored); err != nil {
+		t.Fatalf("Failed to find failed video record: %v", err)
+	}
+	if stored.Status != StatusFailed {
+		t.Errorf("Video status = %s, want %s", stored.Status, StatusFailed)
+	}
+	if _, err := testVideoService.storage.Open(ctx, stored.FilePath); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Open() of the rejected upload error = %v, want ErrFileNotFound", err)
+	}
+}
+
+// Test soft delete, restore and purge
+func TestVideoService_SoftDelete(t *testing.T) {
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	video, err := testVideoService.CreateVideoSimple(ctx, userID, "Soft Delete "+generateTestSuffix(), "Testing soft delete")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+
+	if err := testVideoService.SoftDeleteVideo(ctx, video.ID); err != nil {
+		t.Fatalf("SoftDeleteVideo() unexpected error = %v", err)
+	}
+	if err := testVideoService.SoftDeleteVideo(ctx, video.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SoftDeleteVideo() twice error = %v, want ErrNotFound", err)
+	}
+
+	if _, err := testVideoService.GetVideoByID(ctx, video.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetVideoByID() on deleted video error = %v, want ErrNotFound", err)
+	}
+	deleted, err := testVideoService.GetVideo(ctx, video.ID, true)
+	if err != nil {
+		t.Fatalf("GetVideo(includeDeleted) unexpected error = %v", err)
+	}
+	if deleted.DeletedAt == nil {
+		t.Error("Deleted video should have DeletedAt set")
+	}
+
+	userVideos, err := testVideoService.GetUserVideos(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserVideos() unexpected error = %v", err)
+	}
+	if len(userVideos) != 0 {
+		t.Errorf("GetUserVideos() returned %d videos, want 0", len(userVideos))
+	}
+	listed, err := testVideoService.ListVideos(ctx, 1, 1000)
+	if err != nil {
+		t.Fatalf("ListVideos() unexpected error = %v", err)
+	}
+	for _, v := range listed {
+		if v.ID == video.ID {
+			t.Error("ListVideos() should not include soft-deleted videos")
+		}
+	}
+
+	if err := testVideoService.RestoreVideo(ctx, video.ID); err != nil {
+		t.Fatalf("RestoreVideo() unexpected error = %v", err)
+	}
+	restored, err := testVideoService.GetVideoByID(ctx, video.ID)
+	if err != nil {
+		t.Fatalf("GetVideoByID() after restore unexpected error = %v", err)
+	}
+	if restored.DeletedAt != nil {
+		t.Error("Restored video should not have DeletedAt set")
+	}
+	if err := testVideoService.RestoreVideo(ctx, video.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RestoreVideo() on live video error = %v, want ErrNotFound", err)
+	}
+
+	t.Run("Purge removes only expired deletions", func(t *testing.T) {
+		if err := testVideoService.SoftDeleteVideo(ctx, video.ID); err != nil {
+			t.Fatalf("SoftDeleteVideo() unexpected error = %v", err)
+		}
+
+		if _, err := testVideoService.PurgeDeletedVideos(ctx, time.Hour); err != nil {
+			t.Fatalf("PurgeDeletedVideos() unexpected error = %v", err)
+		}
+		if _, err := testVideoService.GetVideo(ctx, video.ID, true); err != nil {
+			t.Errorf("Recently deleted video should survive the purge: %v", err)
+		}
+
+		purged, err := testVideoService.PurgeDeletedVideos(ctx, 0)
+		if err != nil {
+			t.Fatalf("PurgeDeletedVideos() unexpected error = %v", err)
+		}
+		if purged < 1 {
+			t.Errorf("PurgeDeletedVideos() purged %d videos, want at least 1", purged)
+		}
+		if _, err := testVideoService.GetVideo(ctx, video.ID, true); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetVideo(includeDeleted) after purge error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+// Test that only the owner can update or delete a video
+func TestVideoService_OwnershipChecks(t *testing.T) {
+	ctx := context.Background()
+	ownerID := primitive.NewObjectID()
+	otherID := primitive.NewObjectID()
+
+	video, err := testVideoService.CreateVideoSimple(ctx, ownerID, "Owned "+generateTestSuffix(), "Original description")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+
+	t.Run("Cross-user update is forbidden", func(t *testing.T) {
+		_, err := testVideoService.UpdateVideo(ctx, video.ID, otherID, UpdateVideoRequest{Title: "Hijacked"})
+		if !errors.Is(err, ErrForbidden) {
+			t.Fatalf("UpdateVideo() by another user error = %v, want ErrForbidden", err)
+		}
+
+		stored, err := testVideoService.GetVideoByID(ctx, video.ID)
+		if err != nil {
+			t.Fatalf("Failed to retrieve video: %v", err)
+		}
+		if stored.Title != video.Title {
+			t.Errorf("Title changed to %q by a forbidden update", stored.Title)
+		}
+	})
+
+	t.Run("Cross-user delete is forbidden", func(t *testing.T) {
+		if err := testVideoService.DeleteVideo(ctx, video.ID, otherID); !errors.Is(err, ErrForbidden) {
+			t.Fatalf("DeleteVideo() by another user error = %v, want ErrForbidden", err)
+		}
+		if _, err := testVideoService.GetVideoByID(ctx, video.ID); err != nil {
+			t.Errorf("Video should survive a forbidden delete: %v", err)
+		}
+	})
+
+	t.Run("Owner can update and delete", func(t *testing.T) {
+		updated, err := testVideoService.UpdateVideo(ctx, video.ID, ownerID, UpdateVideoRequest{Title: "Renamed"})
+		if err != nil {
+			t.Fatalf("UpdateVideo() by owner unexpected error = %v", err)
+		}
+		if updated.Title != "Renamed" {
+			t.Errorf("UpdateVideo() title = %q, want %q", updated.Title, "Renamed")
+		}
+
+		if err := testVideoService.DeleteVideo(ctx, video.ID, ownerID); err != nil {
+			t.Fatalf("DeleteVideo() by owner unexpected error = %v", err)
+		}
+		if _, err := testVideoService.UpdateVideo(ctx, video.ID, ownerID, UpdateVideoRequest{Title: "Too late"}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UpdateVideo() on deleted video error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+// Test trending ranking by view velocity
+func TestVideoService_GetTrendingVideos(t *testing.T) {
+	ctx := context.Background()
+	suffix := generateTestSuffix()
+
+	createWithViews := func(title string, status VideoStatus, age time.Duration, views int) *Video {
+		t.Helper()
+		video, err := testVideoService.CreateVideoSimple(ctx, testUserID, title+" "+suffix, "Trending test")
+		if err != nil {
+			t.Fatalf("Failed to create video: %v", err)
+		}
+		_, err = testVideoService.videoCollection.UpdateOne(ctx,
+			bson.M{"_id": video.ID},
+			bson.M{"$set": bson.M{"status": status, "created_at": time.Now().Add(-age)}})
+		if err != nil {
+			t.Fatalf("Failed to age video: %v", err)
+		}
+		for i := 0; i < views; i++ {
+			if _, err := testVideoService.RecordWatchProgress(ctx, primitive.NewObjectID(), video.ID, 1); err != nil {
+				t.Fatalf("Failed to record view: %v", err)
+			}
+		}
+		return video
+	}
+
+	// Fewer views, but gained within a couple of hours
+	rising := createWithViews("Rising", StatusCompleted, 2*time.Hour, 2)
+	// More views spread over ten days
+	steady := createWithViews("Steady", StatusCompleted, 10*24*time.Hour, 5)
+	// Lots of views but not playable yet
+	pending := createWithViews("Pending", StatusPending, time.Hour, 10)
+
+	videos, err := testVideoService.GetTrendingVideos(ctx, MaxTrendingLimit, 7)
+	if err != nil {
+		t.Fatalf("GetTrendingVideos() unexpected error = %v", err)
+	}
+
+	position := map[primitive.ObjectID]int{}
+	for i, v := range videos {
+		position[v.ID] = i
+		if v.Status != StatusCompleted {
+			t.Errorf("GetTrendingVideos() returned %s video %s", v.Status, v.Title)
+		}
+	}
+
+	risingPos, ok := position[rising.ID]
+	if !ok {
+		t.Fatal("Rising video missing from trending")
+	}
+	steadyPos, ok := position[steady.ID]
+	if !ok {
+		t.Fatal("Steady video missing from trending")
+	}
+	if risingPos > steadyPos {
+		t.Errorf("Rising video ranked %d, after steady video at %d", risingPos, steadyPos)
+	}
+	if _, ok := position[pending.ID]; ok {
+		t.Error("Pending video should not be trending")
+	}
+
+	capped, err := testVideoService.GetTrendingVideos(ctx, 500, 7)
+	if err != nil {
+		t.Fatalf("GetTrendingVideos() unexpected error = %v", err)
+	}
+	if len(capped) > MaxTrendingLimit {
+		t.Errorf("GetTrendingVideos() returned %d videos, want at most %d", len(capped), MaxTrendingLimit)
+	}
+}
+
+// Test that repeat views by the same viewer are counted once
+func TestVideoService_RecordView(t *testing.T) {
+	ctx := context.Background()
+
+	video, err := testVideoService.CreateVideoSimple(ctx, testUserID, "Views "+generateTestSuffix(), "Testing view dedup")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+
+	views := []struct {
+		viewer  string
+		counted bool
+	}{
+		{"user:alice", true},
+		{"user:alice", false}, // Refresh
+		{"user:alice", false},
+		{"anon:abc123", true},
+		{"anon:abc123", false},
+		{"user:bob", true},
+	}
+	for i, v := range views {
+		counted, err := testVideoService.RecordView(ctx, video.ID, v.viewer)
+		if err != nil {
+			t.Fatalf("RecordView() #%d unexpected error = %v", i, err)
+		}
+		if counted != v.counted {
+			t.Errorf("RecordView() #%d by %s counted = %v, want %v", i, v.viewer, counted, v.counted)
+		}
+	}
+
+	stored, err := testVideoService.GetVideoByID(ctx, video.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve video: %v", err)
+	}
+	if stored.ViewCount != 3 {
+		t.Errorf("ViewCount = %d, want 3", stored.ViewCount)
+	}
+
+	t.Run("Buckets", func(t *testing.T) {
+		start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+		if !viewBucket(start.Add(ViewDedupWindow - time.Second)).Equal(start) {
+			t.Error("Times within one window should share a bucket")
+		}
+		if viewBucket(start.Add(ViewDedupWindow)).Equal(start) {
+			t.Error("The next window should start a new bucket")
+		}
+	})
+}
+
+// Test chapter markers
+func TestVideoService_SetChapters(t *testing.T) {
+	ctx := context.Background()
+
+	// CreateVideoSimple videos are 120 seconds long
+	video, err := testVideoService.CreateVideoSimple(ctx, testUserID, "Chapters "+generateTestSuffix(), "Testing chapters")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		chapters []Chapter
+		errorMsg string
+	}{
+		{"out of order", []Chapter{{0, "Intro"}, {60, "Middle"}, {30, "Early"}}, "must be in order"},
+		{"overlapping", []Chapter{{0, "Intro"}, {30, "Part 1"}, {30, "Part 2"}}, "overlaps"},
+		{"negative start", []Chapter{{-5, "Before"}}, "negative"},
+		{"beyond duration", []Chapter{{0, "Intro"}, {150, "Credits"}}, "beyond the video duration"},
+		{"missing title", []Chapter{{0, "  "}}, "no title"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := testVideoService.SetChapters(ctx, video.ID, testUserID, tt.chapters)
+			var vErr ValidationError
+			if !errors.As(err, &vErr) {
+				t.Fatalf("SetChapters() error = %v, want ValidationError", err)
+			}
+			if !strings.Contains(vErr.Message, tt.errorMsg) {
+				t.Errorf("SetChapters() error = %q, want it to contain %q", vErr.Message, tt.errorMsg)
+			}
+		})
+	}
+
+	if _, err := testVideoService.SetChapters(ctx, video.ID, primitive.NewObjectID(), []Chapter{{0, "Intro"}}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("SetChapters() by another user error = %v, want ErrForbidden", err)
+	}
+
+	chapters := []Chapter{{0, " Intro "}, {45.5, "Demo"}, {110, "Wrap-up"}}
+	if _, err := testVideoService.SetChapters(ctx, video.ID, testUserID, chapters); err != nil {
+		t.Fatalf("SetChapters() unexpected error = %v", err)
+	}
+
+	stored, err := testVideoService.GetVideoByID(ctx, video.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve video: %v", err)
+	}
+	want := []Chapter{{0, "Intro"}, {45.5, "Demo"}, {110, "Wrap-up"}}
+	if len(stored.Chapters) != len(want) {
+		t.Fatalf("Stored %d chapters, want %d", len(stored.Chapters), len(want))
+	}
+	for i := range want {
+		if stored.Chapters[i] != want[i] {
+			t.Errorf("Chapter %d = %+v, want %+v", i, stored.Chapters[i], want[i])
+		}
+	}
+
+	// An empty list clears the chapters
+	if _, err := testVideoService.SetChapters(ctx, video.ID, testUserID, nil); err != nil {
+		t.Fatalf("SetChapters(nil) unexpected error = %v", err)
+	}
+	stored, _ = testVideoService.GetVideoByID(ctx, video.ID)
+	if len(stored.Chapters) != 0 {
+		t.Errorf("Chapters after clearing = %v, want none", stored.Chapters)
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	ctx := context.Background()
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorage() unexpected error = %v", err)
+	}
+
+	if err := storage.Save(ctx, "video.mp4", strings.NewReader("0123456789")); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+
+	file, err := storage.Open(ctx, "video.mp4")
+	if err != nil {
+		t.Fatalf("Open() unexpected error = %v", err)
+	}
+	if file.Size() != 10 {
+		t.Errorf("Size() = %d, want 10", file.Size())
+	}
+	if _, err := file.Seek(4, io.SeekStart); err != nil {
+		t.Fatalf("Seek() unexpected error = %v", err)
+	}
+	rest, _ := io.ReadAll(file)
+	file.Close()
+	if string(rest) != "456789" {
+		t.Errorf("Read after seek = %q, want %q", rest, "456789")
+	}
+
+	if url, _ := storage.URL(ctx, "video.mp4"); url != "" {
+		t.Errorf("URL() = %q, want empty", url)
+	}
+
+	if err := storage.Delete(ctx, "video.mp4"); err != nil {
+		t.Fatalf("Delete() unexpected error = %v", err)
+	}
+	if _, err := storage.Open(ctx, "video.mp4"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Open() after delete error = %v, want ErrFileNotFound", err)
+	}
+	if err := storage.Delete(ctx, "video.mp4"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Delete() of missing file error = %v, want ErrFileNotFound", err)
+	}
+
+	// Keys can't escape the storage directory
+	for _, key := range []string{"", "../video.mp4", "a/b.mp4", ".hidden"} {
+		if err := storage.Save(ctx, key, strings.NewReader("x")); err == nil {
+			t.Errorf("Save(%q) expected error", key)
+		}
+	}
+}
+
+// Test the processing queue's claiming, retries and worker pool
+func TestProcessingQueue(t *testing.T) {
+	ctx := context.Background()
+	jobs := testDbService.GetDatabase().Collection("processing_jobs_" + generateTestSuffix())
+	defer jobs.Drop(ctx)
+
+	var mu sync.Mutex
+	failures := map[primitive.ObjectID]int{} // Failures left before a job's processing succeeds
+	var failedFinally []primitive.ObjectID
+	process := func(ctx context.Context, job *ProcessingJob) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures[job.VideoID] > 0 {
+			failures[job.VideoID]--
+			return errors.New("transcoder crashed")
+		}
+		return nil
+	}
+	failed := func(ctx context.Context, job *ProcessingJob, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failedFinally = append(failedFinally, job.VideoID)
+	}
+	queue := NewProcessingQueue(jobs, config.ProcessingConfig{MaxAttempts: 2, RetryBackoff: time.Hour}, process, failed)
+
+	// makeDue lets a job scheduled for a retry be claimed right away
+	makeDue := func(job *ProcessingJob) {
+		if _, err := jobs.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": bson.M{"run_at": time.Now().Add(-time.Second)}}); err != nil {
+			t.Fatalf("Failed to make job due: %v", err)
+		}
+	}
+
+	t.Run("Jobs are claimed once", func(t *testing.T) {
+		enqueued, err := queue.Enqueue(ctx, primitive.NewObjectID(), testUserID, "source.mp4")
+		if err != nil {
+			t.Fatalf("Enqueue() unexpected error = %v", err)
+		}
+
+		var wg sync.WaitGroup
+		var claimedMu sync.Mutex
+		var claimed []*ProcessingJob
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				job, err := queue.claim(ctx)
+				if err != nil {
+					t.Errorf("claim() unexpected error = %v", err)
+				}
+				if job != nil {
+					claimedMu.Lock()
+					claimed = append(claimed, job)
+					claimedMu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		if len(claimed) != 1 {
+			t.Fatalf("Job claimed %d times, want once", len(claimed))
+		}
+		if claimed[0].ID != enqueued.ID || claimed[0].Status != JobRunning || claimed[0].Attempts != 1 {
+			t.Errorf("Claimed job = %+v, want the enqueued job running its first attempt", claimed[0])
+		}
+
+		// A job whose worker stopped renewing its lease is claimed again
+		if _, err := jobs.UpdateOne(ctx, bson.M{"_id": enqueued.ID}, bson.M{"$set": bson.M{"locked_until": time.Now().Add(-time.Second)}}); err != nil {
+			t.Fatalf("Failed to expire lease: %v", err)
+		}
+		reclaimed, err := queue.claim(ctx)
+		if err != nil || reclaimed == nil || reclaimed.ID != enqueued.ID || reclaimed.Attempts != 2 {
+			t.Fatalf("claim() after lease expiry = %+v, %v, want the job's second attempt", reclaimed, err)
+		}
+
+		// The worker that lost the lease can't record an outcome over the new claim
+		if err := queue.complete(ctx, claimed[0]); !errors.Is(err, errLeaseLost) {
+			t.Errorf("complete() with a lost lease error = %v, want errLeaseLost", err)
+		}
+		if err := queue.retryOrFail(ctx, claimed[0], errors.New("stale worker")); !errors.Is(err, errLeaseLost) {
+			t.Errorf("retryOrFail() with a lost lease error = %v, want errLeaseLost", err)
+		}
+		if job, _ := queue.GetJob(ctx, enqueued.VideoID); job == nil || job.Status != JobRunning || job.LastError != "" {
+			t.Errorf("Job after stale updates = %+v, want it still running", job)
+		}
+		queue.run(ctx, reclaimed)
+	})
+
+	t.Run("Failed jobs are retried after a backoff", func(t *testing.T) {
+		videoID := primitive.NewObjectID()
+		failures[videoID] = 1
+		if _, err := queue.Enqueue(ctx, videoID, testUserID, "source.mp4"); err != nil {
+			t.Fatalf("Enqueue() unexpected error = %v", err)
+		}
+
+		job, _ := queue.claim(ctx)
+		queue.run(ctx, job)
+
+		retry, err := queue.GetJob(ctx, videoID)
+		if err != nil {
+			t.Fatalf("GetJob() unexpected error = %v", err)
+		}
+		if retry.Status != JobQueued || retry.LastError != "transcoder crashed" || time.Until(retry.RunAt) < 59*time.Minute {
+			t.Errorf("Job after failure = %+v, want it queued an hour later with the error", retry)
+		}
+		if job, _ := queue.claim(ctx); job != nil {
+			t.Errorf("claim() returned job %s before its retry is due", job.ID.Hex())
+		}
+
+		makeDue(retry)
+		job, _ = queue.claim(ctx)
+		if job == nil || job.Attempts != 2 {
+			t.Fatalf("claim() = %+v, want the second attempt", job)
+		}
+		queue.run(ctx, job)
+
+		done, _ := queue.GetJob(ctx, videoID)
+		if done.Status != JobCompleted || done.CompletedAt == nil || done.LastError != "" {
+			t.Errorf("Job after successful retry = %+v, want completed", done)
+		}
+	})
+
+	t.Run("Jobs fail after the last attempt", func(t *testing.T) {
+		videoID := primitive.NewObjectID()
+		failures[videoID] = 5
+		queued, err := queue.Enqueue(ctx, videoID, testUserID, "source.mp4")
+		if err != nil {
+			t.Fatalf("Enqueue() unexpected error = %v", err)
+		}
+
+		for attempt := 1; attempt <= 2; attempt++ {
+			makeDue(queued)
+			job, _ := queue.claim(ctx)
+			if job == nil {
+				t.Fatalf("claim() found no job for attempt %d", attempt)
+			}
+			queue.run(ctx, job)
+		}
+
+		job, _ := queue.GetJob(ctx, videoID)
+		if job.Status != JobFailed || job.Attempts != 2 {
+			t.Errorf("Job = %+v, want failed after 2 attempts", job)
+		}
+		if len(failedFinally) != 1 || failedFinally[0] != videoID {
+			t.Errorf("Final failures = %v, want only %s", failedFinally, videoID.Hex())
+		}
+	})
+
+	t.Run("Retry delay doubles up to the maximum", func(t *testing.T) {
+		q := NewProcessingQueue(jobs, config.ProcessingConfig{RetryBackoff: time.Second, MaxRetryBackoff: 5 * time.Second}, process, failed)
+		for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+			if got := q.retryDelay(attempt); got != want {
+				t.Errorf("retryDelay(%d) = %v, want %v", attempt, got, want)
+			}
+		}
+	})
+
+	t.Run("Workers process queued jobs", func(t *testing.T) {
+		workerCtx, stop := context.WithCancel(ctx)
+		q := NewProcessingQueue(jobs, config.ProcessingConfig{Workers: 2, PollInterval: 50 * time.Millisecond}, process, failed)
+		q.Start(workerCtx)
+
+		var queued []*ProcessingJob
+		for i := 0; i < 3; i++ {
+			job, err := q.Enqueue(ctx, primitive.NewObjectID(), testUserID, "source.mp4")
+			if err != nil {
+				t.Fatalf("Enqueue() unexpected error = %v", err)
+			}
+			queued = append(queued, job)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for _, job := range queued {
+			for {
+				current, err := q.GetJob(ctx, job.VideoID)
+				if err == nil && current.Status == JobCompleted {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("Job %s not completed in time: %+v", job.ID.Hex(), current)
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+		}
+
+		stop()
+		if err := q.Wait(ctx); err != nil {
+			t.Errorf("Wait() unexpected error = %v", err)
+		}
+	})
+
+	t.Run("Subscribers receive the latest update of their video", func(t *testing.T) {
+		q := NewProcessingQueue(jobs, config.ProcessingConfig{}, process, failed)
+		videoID := primitive.NewObjectID()
+		updates, unsubscribe := q.Subscribe(videoID)
+		others, unsubscribeOthers := q.Subscribe(primitive.NewObjectID())
+		defer unsubscribeOthers()
+
+		// A subscriber that falls behind only gets the latest update
+		q.Publish(ProcessingStatus{VideoID: videoID, Status: StatusProcessing, Progress: 10})
+		q.Publish(ProcessingStatus{VideoID: videoID, Status: StatusProcessing, Progress: 20})
+		if update := <-updates; update.Progress != 20 {
+			t.Errorf("Received progress %d, want 20", update.Progress)
+		}
+		select {
+		case update := <-others:
+			t.Errorf("Subscriber of another video received %+v", update)
+		default:
+		}
+
+		unsubscribe()
+		if _, ok := <-updates; ok {
+			t.Error("Channel still open after unsubscribing")
+		}
+		unsubscribe() // Ending a subscription twice is harmless
+		q.Publish(ProcessingStatus{VideoID: videoID, Status: StatusCompleted, Progress: 100})
+
+		q.CloseSubscriptions()
+		if _, ok := <-others; ok {
+			t.Error("Channel still open after closing subscriptions")
+		}
+		late, _ := q.Subscribe(videoID)
+		if _, ok := <-late; ok {
+			t.Error("Subscribe() after closing returned an open channel")
+		}
+	})
+
+	if _, err := queue.GetJob(ctx, primitive.NewObjectID()); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("GetJob() error = %v, want ErrJobNotFound", err)
+	}
+}
+
+// Test parsing of ffmpeg's progress output and the stored processing progress
+func TestVideoService_ProcessingProgress(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Progress output is parsed across writes", func(t *testing.T) {
+		var reported []float64
+		parser := &progressParser{duration: 10, report: func(done float64) {
+			reported = append(reported, done)
+		}}
+		for _, chunk := range []string{
+			"frame=0\nout_time_us=N/A\nprogress=continue\n",
+			"out_time_us=2500", "000\nspeed=1x\n",
+			"out_time_us=12000000\nprogress=end\n",
+		} {
+			parser.Write([]byte(chunk))
+		}
+
+		want := []float64{0.25, 1, 1}
+		if fmt.Sprint(reported) != fmt.Sprint(want) {
+			t.Errorf("Reported %v, want %v", reported, want)
+		}
+	})
+
+	t.Run("Percentages only increase", func(t *testing.T) {
+		var reported []int
+		tracker := &progressTracker{report: func(percent int) {
+			reported = append(reported, percent)
+		}, last: -1}
+		for _, percent := range []int{0, 10, 10, 5, 40, 100} {
+			tracker.set(percent)
+		}
+		if want := []int{0, 10, 40, 100}; fmt.Sprint(reported) != fmt.Sprint(want) {
+			t.Errorf("Reported %v, want %v", reported, want)
+		}
+	})
+
+	t.Run("Transcoding reports 100 only once finished", func(t *testing.T) {
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			t.Skip("ffmpeg not available")
+		}
+		sourcePath := filepath.Join(t.TempDir(), "progress.mp4")
+		cmd := exec.Command("ffmpeg",
+			"-f", "lavfi", "-i", "testsrc=s=64x48:r=10",
+			"-f", "lavfi", "-i", "sine",
+			"-t", "3", "-c:v", "libx264", "-pix_fmt", "yuv420p", "-c:a", "aac",
+			"-y", sourcePath)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Failed to generate test video: %v - %s", err, out)
+		}
+
+		outputDir := filepath.Join(t.TempDir(), "hls")
+		var reported []int
+		_, err := NewFFmpegService().TranscodeToHLSWithProgress(ctx, sourcePath, outputDir, 0, 3, func(percent int) {
+			if _, statErr := os.Stat(filepath.Join(outputDir, HLSMasterPlaylist)); (statErr == nil) != (percent == 100) {
+				t.Errorf("Progress %d reported with master playlist written = %v", percent, statErr == nil)
+			}
+			reported = append(reported, percent)
+		})
+		if err != nil {
+			t.Fatalf("TranscodeToHLSWithProgress() unexpected error = %v", err)
+		}
+		if len(reported) < 3 || reported[len(reported)-1] != 100 {
+			t.Errorf("Reported %v, want several steps ending at 100", reported)
+		}
+		for i := 1; i < len(reported); i++ {
+			if reported[i] <= reported[i-1] {
+				t.Errorf("Progress went from %d to %d", reported[i-1], reported[i])
+			}
+		}
+	})
+
+	video, err := testVideoService.CreateVideoSimple(ctx, testUserID, "Processing Progress "+generateTestSuffix(), "Testing progress")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+	if _, err := testVideoService.queue.Enqueue(ctx, video.ID, testUserID, "source.mp4"); err != nil {
+		t.Fatalf("Enqueue() unexpected error = %v", err)
+	}
+
+	testVideoService.setProcessingProgress(ctx, video.ID, 40)
+	testVideoService.setProcessingProgress(ctx, video.ID, 30)
+
+	status, err := testVideoService.GetVideoProcessingStatus(ctx, video.ID, testUserID)
+	if err != nil {
+		t.Fatalf("GetVideoProcessingStatus() unexpected error = %v", err)
+	}
+	if status.Progress != 40 || status.Status != StatusPending {
+		t.Errorf("Status = %s at %d%%, want %s at 40%%", status.Status, status.Progress, StatusPending)
+	}
+	if status.Job == nil || status.Job.Status != JobQueued {
+		t.Errorf("Job = %+v, want the queued job", status.Job)
+	}
+
+	if _, err := testVideoService.GetVideoProcessingStatus(ctx, video.ID, primitive.NewObjectID()); !errors.Is(err, ErrForbidden) {
+		t.Errorf("GetVideoProcessingStatus() by another user error = %v, want ErrForbidden", err)
+	}
+}
+
+func TestVideoService_Visibility(t *testing.T) {
+	ctx := context.Background()
+	otherUserID := primitive.NewObjectID()
+	term := "visibility" + generateTestSuffix()
+
+	videos := make(map[Visibility]*Video)
+	for _, visibility := range []Visibility{VisibilityPublic, VisibilityUnlisted, VisibilityPrivate} {
+		video, err := testVideoService.CreateVideoSimple(ctx, testUserID, term+" "+string(visibility), "Visibility test")
+		if err != nil {
+			t.Fatalf("Failed to create video: %v", err)
+		}
+		if err := testVideoService.UpdateVideoStatus(ctx, video.ID, StatusCompleted); err != nil {
+			t.Fatalf("Failed to complete video: %v", err)
+		}
+		updated, err := testVideoService.UpdateVideo(ctx, video.ID, testUserID, UpdateVideoRequest{Visibility: visibility})
+		if err != nil {
+			t.Fatalf("UpdateVideo() unexpected error = %v", err)
+		}
+		if updated.Visibility != visibility {
+			t.Fatalf("Visibility = %q, want %q", updated.Visibility, visibility)
+		}
+		videos[visibility] = updated
+	}
+
+	if _, err := testVideoService.UpdateVideo(ctx, videos[VisibilityPublic].ID, testUserID, UpdateVideoRequest{Visibility: "friends"}); !errors.Is(err, ErrInvalidVisibility) {
+		t.Errorf("UpdateVideo() with an unknown visibility error = %v, want ErrInvalidVisibility", err)
+	}
+
+	listed := func(list []*Video) map[Visibility]bool {
+		found := make(map[Visibility]bool)
+		for _, v := range list {
+			for visibility, video := range videos {
+				if v.ID == video.ID {
+					found[visibility] = true
+				}
+			}
+		}
+		return found
+	}
+	wantListed := map[Visibility]bool{VisibilityPublic: true}
+
+	t.Run("Listings", func(t *testing.T) {
+		search, err := testVideoService.SearchVideos(ctx, term, 1, 50)
+		if err != nil {
+			t.Fatalf("SearchVideos() unexpected error = %v", err)
+		}
+		if got := listed(search); !reflect.DeepEqual(got, wantListed) {
+			t.Errorf("SearchVideos() listed %v, want only public", got)
+		}
+
+		all, err := testVideoService.ListVideos(ctx, 1, 10000)
+		if err != nil {
+			t.Fatalf("ListVideos() unexpected error = %v", err)
+		}
+		if got := listed(all); !reflect.DeepEqual(got, wantListed) {
+			t.Errorf("ListVideos() listed %v, want only public", got)
+		}
+
+		// Popular listings are capped, so the test videos are made the most viewed
+		for _, video := range videos {
+			if _, err := testVideoService.videoCollection.UpdateOne(ctx, bson.M{"_id": video.ID}, bson.M{"$set": bson.M{"view_count": int64(1) << 40}}); err != nil {
+				t.Fatalf("Failed to set view count: %v", err)
+			}
+		}
+		popular, err := testVideoService.GetPopularVideos(ctx, 10000)
+		if err != nil {
+			t.Fatalf("GetPopularVideos() unexpected error = %v", err)
+		}
+		if len(popular) > MaxPopularLimit {
+			t.Errorf("GetPopularVideos() returned %d videos, want at most %d", len(popular), MaxPopularLimit)
+		}
+		if got := listed(popular); !reflect.DeepEqual(got, wantListed) {
+			t.Errorf("GetPopularVideos() listed %v, want only public", got)
+		}
+	})
+
+	t.Run("DirectAccess", func(t *testing.T) {
+		tests := []struct {
+			visibility Visibility
+			requester  primitive.ObjectID
+			visible    bool
+		}{
+			{VisibilityPublic, primitive.NilObjectID, true},
+			{VisibilityPublic, otherUserID, true},
+			{VisibilityUnlisted, primitive.NilObjectID, true},
+			{VisibilityUnlisted, otherUserID, true},
+			{VisibilityPrivate, primitive.NilObjectID, false},
+			{VisibilityPrivate, otherUserID, false},
+			{VisibilityPrivate, testUserID, true},
+		}
+		for _, tt := range tests {
+			_, err := testVideoService.GetVideoByID(ctx, videos[tt.visibility].ID, tt.requester)
+			if tt.visible && err != nil {
+				t.Errorf("GetVideoByID(%s) by %s unexpected error = %v", tt.visibility, tt.requester.Hex(), err)
+			}
+			if !tt.visible && !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetVideoByID(%s) by %s error = %v, want ErrNotFound", tt.visibility, tt.requester.Hex(), err)
+			}
+		}
+
+		// The service itself still sees every video
+		if _, err := testVideoService.GetVideoByID(ctx, videos[VisibilityPrivate].ID); err != nil {
+			t.Errorf("GetVideoByID() without a requester unexpected error = %v", err)
+		}
+		if err := testVideoService.LikeVideo(ctx, videos[VisibilityPrivate].ID, otherUserID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("LikeVideo() of another user's private video error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("LegacyVideosArePublic", func(t *testing.T) {
+		legacy, err := testVideoService.CreateVideoSimple(ctx, testUserID, term+" legacy", "No visibility field")
+		if err != nil {
+			t.Fatalf("Failed to create video: %v", err)
+		}
+		if err := testVideoService.UpdateVideoStatus(ctx, legacy.ID, StatusCompleted); err != nil {
+			t.Fatalf("Failed to complete video: %v", err)
+		}
+		search, err := testVideoService.SearchVideos(ctx, term+" legacy", 1, 50)
+		if err != nil {
+			t.Fatalf("SearchVideos() unexpected error = %v", err)
+		}
+		found := false
+		for _, v := range search {
+			found = found || v.ID == legacy.ID
+		}
+		if !found {
+			t.Error("Video without a visibility is not listed")
+		}
+	})
+}
+
+func TestVideoService_DeleteVideos(t *testing.T) {
+	ctx := context.Background()
+	otherUserID := primitive.NewObjectID()
+
+	var mine []primitive.ObjectID
+	for i := 0; i < 3; i++ {
+		video, err := testVideoService.CreateVideoSimple(ctx, testUserID, fmt.Sprintf("Batch delete %d", i), "Batch delete test")
+		if err != nil {
+			t.Fatalf("Failed to create video: %v", err)
+		}
+		mine = append(mine, video.ID)
+	}
+	// A soft-deleted video is deleted for good too
+	if err := testVideoService.DeleteVideo(ctx, mine[1], testUserID); err != nil {
+		t.Fatalf("DeleteVideo() unexpected error = %v", err)
+	}
+	// Its files can't be removed, which must not fail the batch
+	_, err := testVideoService.videoCollection.UpdateOne(ctx, bson.M{"_id": mine[2]}, bson.M{"$set": bson.M{
+		"thumbnail_path": primitive.NewObjectID().Hex(),
+		"hls_path":       "missing/master.m3u8",
+	}})
+	if err != nil {
+		t.Fatalf("Failed to set file paths: %v", err)
+	}
+
+	theirs, err := testVideoService.CreateVideoSimple(ctx, otherUserID, "Batch delete other", "Batch delete test")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+	defer testVideoService.purgeVideo(ctx, theirs.ID)
+
+	ids := append([]primitive.ObjectID{theirs.ID, primitive.NewObjectID(), mine[0]}, mine...)
+	result, err := testVideoService.DeleteVideos(ctx, ids, testUserID)
+	if err != nil {
+		t.Fatalf("DeleteVideos() unexpected error = %v", err)
+	}
+	if result.Deleted != 3 || result.Skipped != 2 {
+		t.Errorf("DeleteVideos() = %+v, want 3 deleted and 2 skipped", result)
+	}
+
+	for _, id := range mine {
+		if _, err := testVideoService.GetVideo(ctx, id, true); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetVideo(%s) error = %v, want ErrNotFound", id.Hex(), err)
+		}
+	}
+	if _, err := testVideoService.GetVideo(ctx, theirs.ID, false); err != nil {
+		t.Errorf("Another user's video should be kept, got error = %v", err)
+	}
+
+	t.Run("TooLarge", func(t *testing.T) {
+		ids := make([]primitive.ObjectID, MaxBatchDelete+1)
+		if _, err := testVideoService.DeleteVideos(ctx, ids, testUserID); !errors.Is(err, ErrBatchTooLarge) {
+			t.Errorf("DeleteVideos() error = %v, want ErrBatchTooLarge", err)
+		}
+	})
+}
+
+func TestVideoService_GetUserVideosPaginated(t *testing.T) {
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	var created []*Video
+	for i := 0; i < 5; i++ {
+		video, err := testVideoService.CreateVideoSimple(ctx, userID, fmt.Sprintf("User video %d", i), "Pagination test")
+		if err != nil {
+			t.Fatalf("Failed to create video: %v", err)
+		}
+		// Distinct creation times so the order is well defined
+		createdAt := time.Now().Add(time.Duration(i) * time.Minute)
+		if _, err := testVideoService.videoCollection.UpdateOne(ctx, bson.M{"_id": video.ID}, bson.M{"$set": bson.M{"created_at": createdAt}}); err != nil {
+			t.Fatalf("Failed to set created_at: %v", err)
+		}
+		defer testVideoService.purgeVideo(ctx, video.ID)
+		created = append(created, video)
+	}
+	for _, video := range created[:2] {
+		if err := testVideoService.UpdateVideoStatus(ctx, video.ID, StatusCompleted); err != nil {
+			t.Fatalf("Failed to complete video: %v", err)
+		}
+	}
+	if err := testVideoService.DeleteVideo(ctx, created[4].ID, userID); err != nil {
+		t.Fatalf("Failed to delete video: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		page, limit    int
+		status         VideoStatus
+		wantIDs        []primitive.ObjectID
+		wantTotal      int64
+		wantTotalPages int
+	}{
+		{"first page newest first", 1, 2, "", []primitive.ObjectID{created[3].ID, created[2].ID}, 4, 2},
+		{"last page", 2, 2, "", []primitive.ObjectID{created[1].ID, created[0].ID}, 4, 2},
+		{"past the end", 3, 2, "", nil, 4, 2},
+		{"status filter", 1, 10, StatusCompleted, []primitive.ObjectID{created[1].ID, created[0].ID}, 2, 1},
+		{"invalid page and limit", 0, 0, "", []primitive.ObjectID{created[3].ID}, 4, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := testVideoService.GetUserVideosPaginated(ctx, userID, tt.page, tt.limit, tt.status)
+			if err != nil {
+				t.Fatalf("GetUserVideosPaginated() unexpected error = %v", err)
+			}
+			if result.Total != tt.wantTotal || result.TotalPages != tt.wantTotalPages {
+				t.Errorf("Total = %d, TotalPages = %d, want %d and %d", result.Total, result.TotalPages, tt.wantTotal, tt.wantTotalPages)
+			}
+			if len(result.Videos) != len(tt.wantIDs) {
+				t.Fatalf("Got %d videos, want %d", len(result.Videos), len(tt.wantIDs))
+			}
+			for i, video := range result.Videos {
+				if video.ID != tt.wantIDs[i] {
+					t.Errorf("Video %d = %s, want %s", i, video.Title, tt.wantIDs[i].Hex())
+				}
+			}
+		})
+	}
+
+	if _, err := testVideoService.GetUserVideosPaginated(ctx, userID, 1, 10, "DONE"); err == nil {
+		t.Error("GetUserVideosPaginated() with an unknown status should fail")
+	}
+}
+
+func TestVideoService_ReprobeMetadata(t *testing.T) {
+	ctx := context.Background()
+
+	// CreateVideoSimple stores placeholder metadata without an original file
+	missing, err := testVideoService.CreateVideoSimple(ctx, testUserID, "Reprobe Missing "+generateTestSuffix(), "No file")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+	defer testVideoService.DeleteVideo(ctx, missing.ID, testUserID)
+
+	broken, err := testVideoService.CreateVideoSimple(ctx, testUserID, "Reprobe Broken "+generateTestSuffix(), "Not a video")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+	defer testVideoService.DeleteVideo(ctx, broken.ID, testUserID)
+	if err := testVideoService.storage.Save(ctx, broken.FilePath, strings.NewReader("not a video")); err != nil {
+		t.Fatalf("Failed to store file: %v", err)
+	}
+	defer testVideoService.storage.Delete(ctx, broken.FilePath)
+
+	var probed *Video
+	if _, err := exec.LookPath("ffmpeg"); err == nil {
+		probed, err = testVideoService.CreateVideoSimple(ctx, testUserID, "Reprobe Real "+generateTestSuffix(), "Real file")
+		if err != nil {
+			t.Fatalf("Failed to create video: %v", err)
+		}
+		defer testVideoService.DeleteVideo(ctx, probed.ID, testUserID)
+
+		sourcePath := filepath.Join(t.TempDir(), "reprobe.mp4")
+		cmd := exec.Command("ffmpeg", "-f", "lavfi", "-i", "testsrc=s=64x48:r=10",
+			"-t", "2", "-c:v", "libx264", "-pix_fmt", "yuv420p", "-y", sourcePath)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Failed to generate test video: %v - %s", err, out)
+		}
+		source, err := os.Open(sourcePath)
+		if err != nil {
+			t.Fatalf("Failed to open test video: %v", err)
+		}
+		defer source.Close()
+		if err := testVideoService.storage.Save(ctx, probed.FilePath, source); err != nil {
+			t.Fatalf("Failed to store file: %v", err)
+		}
+		defer testVideoService.storage.Delete(ctx, probed.FilePath)
+	}
+
+	job := &ReprobeJob{}
+	if err := testVideoService.reprobeMetadata(ctx, job); err != nil {
+		t.Fatalf("reprobeMetadata() unexpected error = %v", err)
+	}
+	// Other tests leave placeholder videos behind too, so the counts are lower bounds
+	if job.Missing < 1 || job.Failed < 1 {
+		t.Errorf("reprobeMetadata() = %+v, want at least one missing and one failed video", job)
+	}
+	if job.Matched != job.Reprocessed+job.Missing+job.Failed {
+		t.Errorf("reprobeMetadata() outcomes don't add up: %+v", job)
+	}
+
+	for _, video := range []*Video{missing, broken} {
+		stored, err := testVideoService.GetVideoByID(ctx, video.ID)
+		if err != nil {
+			t.Fatalf("GetVideoByID() unexpected error = %v", err)
+		}
+		if stored.Metadata.Duration != placeholderDuration {
+			t.Errorf("Metadata of %q changed to %+v", stored.Title, stored.Metadata)
+		}
+	}
+
+	if probed != nil {
+		if job.Reprocessed < 1 {
+			t.Errorf("reprobeMetadata() = %+v, want at least one reprocessed video", job)
+		}
+		stored, err := testVideoService.GetVideoByID(ctx, probed.ID)
+		if err != nil {
+			t.Fatalf("GetVideoByID() unexpected error = %v", err)
+		}
+		if stored.Metadata.Width != 64 || stored.Metadata.Height != 48 || stored.Metadata.FileSize == placeholderFileSize {
+			t.Errorf("Re-probed metadata = %+v, want the real 64x48 file's", stored.Metadata)
+		}
+
+		// Re-probed videos no longer look synthetic, so a second run leaves them alone
+		again := &ReprobeJob{}
+		if err := testVideoService.reprobeMetadata(ctx, again); err != nil {
+			t.Fatalf("reprobeMetadata() unexpected error = %v", err)
+		}
+		if again.Reprocessed != 0 {
+			t.Errorf("Second run reprocessed %d videos, want 0", again.Reprocessed)
+		}
+	}
+}
+
+func TestVideoService_GetVideoWithUploader(t *testing.T) {
+	ctx := context.Background()
+
+	uploaderID := primitive.NewObjectID()
+	usersCollection := testDbService.GetDatabase().Collection("users")
+	_, err := usersCollection.InsertOne(ctx, bson.M{
+		"_id":         uploaderID,
+		"user_name":   "uploader_" + generateTestSuffix(),
+		"email":       "uploader@example.com",
+		"password":    "$2a$10$secrethash",
+		"avatar_path": "avatar_test",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create uploader: %v", err)
+	}
+	defer usersCollection.DeleteOne(ctx, bson.M{"_id": uploaderID})
+
+	video, err := testVideoService.CreateVideoSimple(ctx, uploaderID, "Uploader Join "+generateTestSuffix(), "Joined")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+	defer testVideoService.DeleteVideo(ctx, video.ID, uploaderID)
+
+	got, err := testVideoService.GetVideoWithUploader(ctx, video.ID, primitive.NilObjectID)
+	if err != nil {
+		t.Fatalf("GetVideoWithUploader() unexpected error = %v", err)
+	}
+	if got.ID != video.ID || got.Title != video.Title {
+		t.Errorf("GetVideoWithUploader() video = %s %q, want %s %q", got.ID.Hex(), got.Title, video.ID.Hex(), video.Title)
+	}
+	if got.Uploader == nil || got.Uploader.ID != uploaderID || got.Uploader.AvatarPath != "avatar_test" {
+		t.Fatalf("GetVideoWithUploader() uploader = %+v, want the uploader's profile", got.Uploader)
+	}
+
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("Failed to marshal video: %v", err)
+	}
+	for _, secret := range []string{"uploader@example.com", "secrethash"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("Video JSON contains the uploader's private data %q: %s", secret, data)
+		}
+	}
+
+	// A video whose uploader is gone still loads
+	orphan, err := testVideoService.CreateVideoSimple(ctx, primitive.NewObjectID(), "Orphan "+generateTestSuffix(), "No uploader")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+	defer testVideoService.DeleteVideo(ctx, orphan.ID, orphan.UserID)
+	if got, err := testVideoService.GetVideoWithUploader(ctx, orphan.ID); err != nil || got.Uploader != nil {
+		t.Errorf("GetVideoWithUploader() of orphan = %+v, %v, want no uploader", got, err)
+	}
+
+	if _, err := testVideoService.GetVideoWithUploader(ctx, primitive.NewObjectID()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetVideoWithUploader() of unknown video error = %v, want ErrNotFound", err)
+	}
+}
+
+// Test that related videos are ranked by shared tags, then by views
+func TestVideoService_GetRelatedVideos(t *testing.T) {
+	ctx := context.Background()
+	suffix := generateTestSuffix()
+	tag := func(name string) string { return name + suffix }
+	uploaderID := primitive.NewObjectID()
+	otherID := primitive.NewObjectID()
+
+	create := func(userID primitive.ObjectID, title string, views int64, tags ...string) *Video {
+		video, err := testVideoService.CreateVideoSimple(ctx, userID, title+" "+suffix, "Related")
+		if err != nil {
+			t.Fatalf("Failed to create video: %v", err)
+		}
+		t.Cleanup(func() { testVideoService.DeleteVideo(ctx, video.ID, userID) })
+		if _, err := testVideoService.UpdateVideo(ctx, video.ID, userID, UpdateVideoRequest{Tags: tags}); err != nil {
+			t.Fatalf("Failed to tag video: %v", err)
+		}
+		_, err = testVideoService.videoCollection.UpdateOne(ctx, bson.M{"_id": video.ID},
+			bson.M{"$set": bson.M{"status": StatusCompleted, "view_count": views}})
+		if err != nil {
+			t.Fatalf("Failed to complete video: %v", err)
+		}
+		return video
+	}
+
+	source := create(uploaderID, "Source", 0, "#"+strings.ToUpper(tag("go")), tag("web"), tag("api"))
+	twoShared := create(otherID, "Two shared", 1, tag("go"), tag("web"))
+	oneShared := create(otherID, "One shared", 100, tag("go"))
+	sameUploader := create(uploaderID, "Same uploader", 50, tag("go"), tag("api"))
+	create(otherID, "Unrelated", 1000, tag("cooking"))
+	private := create(otherID, "Private", 1000, tag("go"), tag("web"), tag("api"))
+	if _, err := testVideoService.UpdateVideo(ctx, private.ID, otherID, UpdateVideoRequest{Visibility: VisibilityPrivate}); err != nil {
+		t.Fatalf("Failed to make video private: %v", err)
+	}
+
+	// Too many tags are rejected rather than cut short
+	many := make([]string, 0, MaxVideoTags+1)
+	for i := 0; i <= MaxVideoTags; i++ {
+		many = append(many, fmt.Sprintf("tag%d", i))
+	}
+	if _, err := testVideoService.UpdateVideo(ctx, source.ID, uploaderID, UpdateVideoRequest{Tags: many}); !errors.Is(err, tags.ErrTooMany) {
+		t.Errorf("UpdateVideo() with %d tags error = %v, want tags.ErrTooMany", len(many), err)
+	}
+
+	ids := func(videos []*Video) []primitive.ObjectID {
+		var list []primitive.ObjectID
+		for _, v := range videos {
+			list = append(list, v.ID)
+		}
+		return list
+	}
+
+	related, err := testVideoService.GetRelatedVideos(ctx, source.ID, 10, false)
+	if err != nil {
+		t.Fatalf("GetRelatedVideos() unexpected error = %v", err)
+	}
+	want := []primitive.ObjectID{sameUploader.ID, twoShared.ID, oneShared.ID}
+	if got := ids(related); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetRelatedVideos() = %v, want %v", got, want)
+	}
+
+	related, err = testVideoService.GetRelatedVideos(ctx, source.ID, 10, true)
+	if err != nil {
+		t.Fatalf("GetRelatedVideos() excluding uploader unexpected error = %v", err)
+	}
+	want = []primitive.ObjectID{twoShared.ID, oneShared.ID}
+	if got := ids(related); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetRelatedVideos() excluding uploader = %v, want %v", got, want)
+	}
+
+	// Without tags, the most viewed videos are suggested
+	untagged := create(uploaderID, "Untagged", 0)
+	related, err = testVideoService.GetRelatedVideos(ctx, untagged.ID, 5, false)
+	if err != nil {
+		t.Fatalf("GetRelatedVideos() of untagged video unexpected error = %v", err)
+	}
+	for i, v := range related {
+		if v.ID == untagged.ID || v.ID == private.ID {
+			t.Errorf("GetRelatedVideos() of untagged video suggested %s", v.Title)
+		}
+		if i > 0 && v.ViewCount > related[i-1].ViewCount {
+			t.Errorf("GetRelatedVideos() of untagged video not sorted by views: %d after %d", v.ViewCount, related[i-1].ViewCount)
+		}
+	}
+
+	if _, err := testVideoService.GetRelatedVideos(ctx, private.ID, 10, false, uploaderID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRelatedVideos() of another user's private video error = %v, want ErrNotFound", err)
+	}
+}
+
+// Test that a described upload is judged as the uploaded file would be
+func TestValidateVideoUpload(t *testing.T) {
+	testCases := []struct {
+		name        string
+		filename    string
+		size        int64
+		contentType string
+		field       string // Empty when accepted
+	}{
+		{"mp4", "video.mp4", 50000000, "video/mp4", ""},
+		{"mkv with parameters", "Video.MKV", 1000, "video/x-matroska; codecs=avc1", ""},
+		{"at limit", "video.webm", MaxFileSize, "video/webm", ""},
+		{"over limit", "video.mp4", MaxFileSize + 1, "video/mp4", "file"},
+		{"image", "video.mp4", 1000, "image/jpeg", "file"},
+		{"bad extension", "video.exe", 1000, "video/mp4", "file"},
+		{"missing content type", "video.mp4", 1000, "", "content_type"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateVideoUpload(tc.filename, tc.size, tc.contentType)
+			if tc.field == "" {
+				if err != nil {
+					t.Errorf("ValidateVideoUpload() unexpected error = %v", err)
+				}
+				return
+			}
+			var validationErr ValidationError
+			if !errors.As(err, &validationErr) || validationErr.Field != tc.field {
+				t.Errorf("ValidateVideoUpload() error = %v, want a ValidationError on %q", err, tc.field)
+			}
+		})
+	}
+
+	// The dry run and the upload reject an oversized file with the same reason
+	fileHeader := newTestFileHeader(t, "video.mp4", MaxFileSize+1, "video/mp4", testMP4Header)
+	uploadErr := ValidateVideoFile(fileHeader)
+	dryRunErr := ValidateVideoUpload("video.mp4", MaxFileSize+1, "video/mp4")
+	if uploadErr == nil || dryRunErr == nil || uploadErr.Error() != dryRunErr.Error() {
+		t.Errorf("ValidateVideoUpload() error = %v, want %v as for the upload", dryRunErr, uploadErr)
+	}
+}
+
+// Test that share links open a private video, and only that video, until revoked or expired
+func TestVideoService_ShareLinks(t *testing.T) {
+	ctx := context.Background()
+	testVideoService.SetShareLinkKey("share-test-secret")
+	ownerID := primitive.NewObjectID()
+
+	video, err := testVideoService.CreateVideoSimple(ctx, ownerID, "Shared "+generateTestSuffix(), "Private")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+	defer testVideoService.DeleteVideo(ctx, video.ID, ownerID)
+	other, err := testVideoService.CreateVideoSimple(ctx, ownerID, "Not shared "+generateTestSuffix(), "Private")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+	defer testVideoService.DeleteVideo(ctx, other.ID, ownerID)
+
+	if _, err := testVideoService.CreateShareLink(ctx, video.ID, primitive.NewObjectID(), 0); !errors.Is(err, ErrForbidden) {
+		t.Errorf("CreateShareLink() by another user error = %v, want ErrForbidden", err)
+	}
+	if _, err := testVideoService.CreateShareLink(ctx, video.ID, ownerID, MaxShareLinkTTL+time.Hour); !errors.Is(err, ErrShareLinkTTL) {
+		t.Errorf("CreateShareLink() with a long lifetime error = %v, want ErrShareLinkTTL", err)
+	}
+
+	link, err := testVideoService.CreateShareLink(ctx, video.ID, ownerID, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateShareLink() unexpected error = %v", err)
+	}
+	if link.Token == "" || link.VideoID != video.ID {
+		t.Fatalf("CreateShareLink() = %+v, want a token for the video", link)
+	}
+
+	if err := testVideoService.VerifyShareToken(ctx, link.Token, video.ID); err != nil {
+		t.Errorf("VerifyShareToken() unexpected error = %v", err)
+	}
+	if err := testVideoService.VerifyShareToken(ctx, link.Token, other.ID); !errors.Is(err, ErrInvalidShareToken) {
+		t.Errorf("VerifyShareToken() for another video error = %v, want ErrInvalidShareToken", err)
+	}
+
+	// Changing any byte breaks the signature
+	tampered := []byte(link.Token)
+	tampered[len(tampered)/2] ^= 1
+	if err := testVideoService.VerifyShareToken(ctx, string(tampered), video.ID); !errors.Is(err, ErrInvalidShareToken) {
+		t.Errorf("VerifyShareToken() of a tampered token error = %v, want ErrInvalidShareToken", err)
+	}
+
+	expired := testVideoService.signShareToken(link.ID, video.ID, time.Now().Add(-time.Second))
+	if err := testVideoService.VerifyShareToken(ctx, expired, video.ID); !errors.Is(err, ErrInvalidShareToken) {
+		t.Errorf("VerifyShareToken() of an expired token error = %v, want ErrInvalidShareToken", err)
+	}
+
+	if err := testVideoService.RevokeShareLink(ctx, video.ID, link.ID, primitive.NewObjectID()); !errors.Is(err, ErrShareLinkNotFound) {
+		t.Errorf("RevokeShareLink() by another user error = %v, want ErrShareLinkNotFound", err)
+	}
+	if err := testVideoService.RevokeShareLink(ctx, video.ID, link.ID, ownerID); err != nil {
+		t.Fatalf("RevokeShareLink() unexpected error = %v", err)
+	}
+	if err := testVideoService.VerifyShareToken(ctx, link.Token, video.ID); !errors.Is(err, ErrInvalidShareToken) {
+		t.Errorf("VerifyShareToken() after revoking error = %v, want ErrInvalidShareToken", err)
+	}
+}
+
+func TestVideoService_StorageQuota(t *testing.T) {
+	ctx := context.Background()
+	ownerID := primitive.NewObjectID()
+
+	first, err := testVideoService.CreateVideoSimple(ctx, ownerID, "Quota "+generateTestSuffix(), "First")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+	defer testVideoService.DeleteVideo(ctx, first.ID, ownerID)
+	second, err := testVideoService.CreateVideoSimple(ctx, ownerID, "Quota "+generateTestSuffix(), "Second")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+	defer testVideoService.DeleteVideo(ctx, second.ID, ownerID)
+
+	size := first.Metadata.FileSize
+	used, err := testVideoService.GetUserStorageUsage(ctx, ownerID)
+	if err != nil {
+		t.Fatalf("GetUserStorageUsage() unexpected error = %v", err)
+	}
+	if used != 2*size {
+		t.Fatalf("GetUserStorageUsage() = %d, want %d", used, 2*size)
+	}
+
+	testVideoService.SetStorageQuota(3 * size)
+	defer testVideoService.SetStorageQuota(0)
+
+	if err := testVideoService.CheckStorageQuota(ctx, ownerID, size); err != nil {
+		t.Errorf("CheckStorageQuota() filling the quota exactly error = %v, want nil", err)
+	}
+	if err := testVideoService.CheckStorageQuota(ctx, ownerID, size+1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("CheckStorageQuota() one byte over error = %v, want ErrQuotaExceeded", err)
+	}
+
+	// Deleted videos stop counting
+	if err := testVideoService.DeleteVideo(ctx, second.ID, ownerID); err != nil {
+		t.Fatalf("DeleteVideo() unexpected error = %v", err)
+	}
+	used, err = testVideoService.GetUserStorageUsage(ctx, ownerID)
+	if err != nil {
+		t.Fatalf("GetUserStorageUsage() unexpected error = %v", err)
+	}
+	if used != size {
+		t.Errorf("GetUserStorageUsage() after deleting = %d, want %d", used, size)
+	}
+	if err := testVideoService.CheckStorageQuota(ctx, ownerID, size+1); err != nil {
+		t.Errorf("CheckStorageQuota() after deleting error = %v, want nil", err)
+	}
+
+	testVideoService.SetStorageQuota(0)
+	if err := testVideoService.CheckStorageQuota(ctx, ownerID, 100*size); err != nil {
+		t.Errorf("CheckStorageQuota() without a quota error = %v, want nil", err)
+	}
+}
+
+// Test that the dry run applies the configured size limit, quota and video count as the
+// upload does
+func TestVideoService_ValidateUpload(t *testing.T) {
+	ctx := context.Background()
+	ownerID := primitive.NewObjectID()
+
+	existing, err := testVideoService.CreateVideoSimple(ctx, ownerID, "Limits "+generateTestSuffix(), "Existing")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+	defer testVideoService.DeleteVideo(ctx, existing.ID, ownerID)
+	size := existing.Metadata.FileSize
+
+	maxFileSize := testVideoService.maxFileSize
+	testVideoService.maxFileSize = 10 * 1024 * 1024
+	defer func() { testVideoService.maxFileSize = maxFileSize }()
+
+	if err := testVideoService.ValidateUpload(ctx, ownerID, "video.mp4", 10*1024*1024, "video/mp4"); err != nil {
+		t.Errorf("ValidateUpload() at the configured limit error = %v, want nil", err)
+	}
+	if err := testVideoService.ValidateUpload(ctx, ownerID, "video.mp4", 10*1024*1024+1, "video/mp4"); !errors.Is(err, ErrUploadTooLarge) {
+		t.Errorf("ValidateUpload() over the configured limit error = %v, want ErrUploadTooLarge", err)
+	}
+	var validationErr ValidationError
+	if err := testVideoService.ValidateUpload(ctx, ownerID, "video.exe", 1000, "video/mp4"); !errors.As(err, &validationErr) {
+		t.Errorf("ValidateUpload() with a bad extension error = %v, want a ValidationError", err)
+	}
+
+	testVideoService.SetStorageQuota(2 * size)
+	if err := testVideoService.ValidateUpload(ctx, ownerID, "video.mp4", size+1, "video/mp4"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("ValidateUpload() over the quota error = %v, want ErrQuotaExceeded", err)
+	}
+	testVideoService.SetStorageQuota(0)
+
+	testVideoService.SetMaxVideos(1)
+	defer testVideoService.SetMaxVideos(0)
+	if err := testVideoService.ValidateUpload(ctx, ownerID, "video.mp4", 1000, "video/mp4"); !errors.Is(err, ErrVideoLimitReached) {
+		t.Errorf("ValidateUpload() at the video limit error = %v, want ErrVideoLimitReached", err)
+	}
+	if err := testVideoService.CheckUploadLimits(ctx, ownerID, 1000); !errors.Is(err, ErrVideoLimitReached) {
+		t.Errorf("CheckUploadLimits() at the video limit error = %v, want ErrVideoLimitReached", err)
+	}
+	if err := testVideoService.ValidateUpload(ctx, primitive.NewObjectID(), "video.mp4", 1000, "video/mp4"); err != nil {
+		t.Errorf("ValidateUpload() for another user error = %v, want nil", err)
+	}
+}
+
+// Test that deleted videos don't count against the video limit, and that a video doesn't
+// count against its own replacement
+func TestVideoService_MaxVideos(t *testing.T) {
+	ctx := context.Background()
+	ownerID := primitive.NewObjectID()
+	testVideoService.SetMaxVideos(1)
+	defer testVideoService.SetMaxVideos(0)
+
+	kept, err := testVideoService.CreateVideoSimple(ctx, ownerID, "Max Videos "+generateTestSuffix(), "Kept")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+	defer testVideoService.DeleteVideo(ctx, kept.ID, ownerID)
+
+	if err := testVideoService.CheckUploadLimits(ctx, ownerID, 1000); !errors.Is(err, ErrVideoLimitReached) {
+		t.Errorf("CheckUploadLimits() at the limit error = %v, want ErrVideoLimitReached", err)
+	}
+	if err := testVideoService.checkVideoCount(ctx, ownerID, kept.ID); err != nil {
+		t.Errorf("checkVideoCount() replacing the only video error = %v, want nil", err)
+	}
+
+	if err := testVideoService.DeleteVideo(ctx, kept.ID, ownerID); err != nil {
+		t.Fatalf("DeleteVideo() unexpected error = %v", err)
+	}
+	count, err := testVideoService.CountUserVideos(ctx, ownerID)
+	if err != nil {
+		t.Fatalf("CountUserVideos() unexpected error = %v", err)
+	}
+	if count != 0 {
+		t.Errorf("CountUserVideos() after deleting = %d, want 0", count)
+	}
+	if err := testVideoService.CheckUploadLimits(ctx, ownerID, 1000); err != nil {
+		t.Errorf("CheckUploadLimits() after deleting error = %v, want nil", err)
+	}
+}
+
+func TestVideoService_CreateVideoIdempotent(t *testing.T) {
+	ctx := context.Background()
+	ownerID := primitive.NewObjectID()
+	key := "upload-" + generateTestSuffix()
+	defer testVideoService.videoCollection.DeleteMany(ctx, bson.M{"user_id": ownerID})
+	defer testVideoService.idempotencyCollection.DeleteMany(ctx, bson.M{"user_id": ownerID})
+
+	calls := 0
+	create := func(ctx context.Context) (*Video, error) {
+		calls++
+		return testVideoService.CreateVideoSimple(ctx, ownerID, "Idempotent "+generateTestSuffix(), "Retried upload")
+	}
+
+	first, replayed, err := testVideoService.CreateVideoIdempotent(ctx, ownerID, key, create)
+	if err != nil {
+		t.Fatalf("CreateVideoIdempotent() unexpected error = %v", err)
+	}
+	if replayed {
+		t.Error("CreateVideoIdempotent() replayed the first request")
+	}
+
+	second, replayed, err := testVideoService.CreateVideoIdempotent(ctx, ownerID, key, create)
+	if err != nil {
+		t.Fatalf("CreateVideoIdempotent() repeated unexpected error = %v", err)
+	}
+	if !replayed || second.ID != first.ID {
+		t.Errorf("CreateVideoIdempotent() repeated = %s (replayed %v), want %s replayed", second.ID.Hex(), replayed, first.ID.Hex())
+	}
+	if calls != 1 {
+		t.Errorf("create called %d times, want 1", calls)
+	}
+
+	count, err := testVideoService.videoCollection.CountDocuments(ctx, bson.M{"user_id": ownerID})
+	if err != nil {
+		t.Fatalf("Failed to count videos: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Found %d videos after repeating the key, want 1", count)
+	}
+
+	// Keys are per user
+	if _, replayed, err := testVideoService.CreateVideoIdempotent(ctx, primitive.NewObjectID(), key, func(context.Context) (*Video, error) {
+		return &Video{ID: primitive.NewObjectID()}, nil
+	}); err != nil || replayed {
+		t.Errorf("CreateVideoIdempotent() by another user = replayed %v, %v, want a new video", replayed, err)
+	}
+
+	// A failed attempt releases its key for the retry
+	failedKey := "failed-" + generateTestSuffix()
+	createErr := errors.New("upload failed")
+	if _, _, err := testVideoService.CreateVideoIdempotent(ctx, ownerID, failedKey, func(context.Context) (*Video, error) {
+		return nil, createErr
+	}); !errors.Is(err, createErr) {
+		t.Fatalf("CreateVideoIdempotent() failing error = %v, want %v", err, createErr)
+	}
+	if _, replayed, err := testVideoService.CreateVideoIdempotent(ctx, ownerID, failedKey, create); err != nil || replayed {
+		t.Errorf("CreateVideoIdempotent() after a failure = replayed %v, %v, want a new video", replayed, err)
+	}
+
+	if _, _, err := testVideoService.CreateVideoIdempotent(ctx, ownerID, "", create); !errors.Is(err, ErrInvalidIdempotencyKey) {
+		t.Errorf("CreateVideoIdempotent() with an empty key error = %v, want ErrInvalidIdempotencyKey", err)
+	}
+
+	// A reservation left pending by a request that died expires soon, not after the window
+	pendingKey := "pending-" + generateTestSuffix()
+	if existing, err := testVideoService.reserveIdempotencyKey(ctx, ownerID, pendingKey); err != nil || existing != nil {
+		t.Fatalf("reserveIdempotencyKey() = %v, %v, want a new reservation", existing, err)
+	}
+	var pending idempotencyRecord
+	if err := testVideoService.idempotencyCollection.FindOne(ctx, bson.M{"user_id": ownerID, "key": pendingKey}).Decode(&pending); err != nil {
+		t.Fatalf("Failed to find reservation: %v", err)
+	}
+	if time.Until(pending.ExpiresAt) > pendingIdempotencyTTL {
+		t.Errorf("Pending reservation expires at %v, want within %v", pending.ExpiresAt, pendingIdempotencyTTL)
+	}
+	_, err = testVideoService.idempotencyCollection.UpdateOne(ctx, bson.M{"_id": pending.ID},
+		bson.M{"$set": bson.M{"expires_at": time.Now().Add(-time.Second)}})
+	if err != nil {
+		t.Fatalf("Failed to expire reservation: %v", err)
+	}
+	if _, replayed, err := testVideoService.CreateVideoIdempotent(ctx, ownerID, pendingKey, create); err != nil || replayed {
+		t.Errorf("CreateVideoIdempotent() after an abandoned reservation = replayed %v, %v, want a new video", replayed, err)
+	}
+
+	// Once the video exists the key is remembered for the whole window
+	var done idempotencyRecord
+	if err := testVideoService.idempotencyCollection.FindOne(ctx, bson.M{"user_id": ownerID, "key": pendingKey}).Decode(&done); err != nil {
+		t.Fatalf("Failed to find idempotency record: %v", err)
+	}
+	if done.VideoID == nil || time.Until(done.ExpiresAt) <= pendingIdempotencyTTL {
+		t.Errorf("Idempotency record = %+v, want the video and the full window", done)
+	}
+}
+
+func TestVideoService_StorageStats(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	roots := storageRoots{
+		Uploads:   filepath.Join(dir, "uploads"),
+		Processed: filepath.Join(dir, "processed"),
+		Originals: filepath.Join(dir, "videos"),
+	}
+
+	video, err := testVideoService.CreateVideoSimple(ctx, testUserID, "Storage Stats "+generateTestSuffix(), "Pending upload")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+	defer testVideoService.DeleteVideo(ctx, video.ID, testUserID)
+	gone := primitive.NewObjectID().Hex()
+
+	files := map[string]bool{ // Path below dir and whether it is orphaned
+		"uploads/" + video.ID.Hex() + "_temp.mp4":    false, // Still waiting to be processed
+		"uploads/" + gone + "_temp.mp4":              true,
+		"uploads/sessions/abc/chunk_00000":           false, // Upload in progress
+		"processed/" + video.ID.Hex() + "/720p.m3u8": true,  // The video isn't processing
+		"videos/" + video.ID.Hex() + ".mp4":          false,
+		"videos/" + gone + ".mp4":                    true,
+		"videos/" + gone + "_" + gone + ".mp4":       true,
+		"videos/avatar_" + gone + "_thumb.png":       false, // Not a video file
+		"videos/.upload-123":                         true,  // Crashed write
+	}
+	var totalBytes int64
+	for name := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		totalBytes += int64(len(name))
+	}
+
+	// Fresh files are left alone, as their uploads may still be running
+	stats, err := testVideoService.scanStorage(ctx, roots, time.Now())
+	if err != nil {
+		t.Fatalf("scanStorage() unexpected error = %v", err)
+	}
+	if stats.FileCount != len(files) || stats.TotalBytes != totalBytes {
+		t.Errorf("scanStorage() counted %d files of %d bytes, want %d of %d", stats.FileCount, stats.TotalBytes, len(files), totalBytes)
+	}
+	if len(stats.Orphans) != 0 {
+		t.Errorf("scanStorage() reported fresh files as orphans: %+v", stats.Orphans)
+	}
+
+	later := time.Now().Add(OrphanGracePeriod + time.Minute)
+	stats, err = testVideoService.scanStorage(ctx, roots, later)
+	if err != nil {
+		t.Fatalf("scanStorage() unexpected error = %v", err)
+	}
+	var orphans []string
+	for _, orphan := range stats.Orphans {
+		orphans = append(orphans, orphan.Path)
+	}
+	var want []string
+	for name, orphaned := range files {
+		if orphaned {
+			want = append(want, filepath.ToSlash(filepath.Join(dir, name)))
+		}
+	}
+	sort.Strings(orphans)
+	sort.Strings(want)
+	if fmt.Sprint(orphans) != fmt.Sprint(want) {
+		t.Errorf("scanStorage() orphans = %v, want %v", orphans, want)
+	}
+
+	// Only paths that are still orphaned are removed
+	kept := filepath.ToSlash(filepath.Join(dir, "uploads", video.ID.Hex()+"_temp.mp4"))
+	result, err := testVideoService.removeOrphans(ctx, roots, append([]string{kept}, want...), later)
+	if err != nil {
+		t.Fatalf("removeOrphans() unexpected error = %v", err)
+	}
+	if len(result.Removed) != len(want) || len(result.Skipped) != 1 || result.Skipped[0] != kept {
+		t.Errorf("removeOrphans() = %+v, want %d removed and %s skipped", result, len(want), kept)
+	}
+	for _, path := range want {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Orphan %s still exists", path)
+		}
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Errorf("File still needed was removed: %v", err)
+	}
+
+	if _, err := testVideoService.removeOrphans(ctx, roots, nil, later); !errors.Is(err, ErrNoOrphansGiven) {
+		t.Errorf("removeOrphans() without paths error = %v, want ErrNoOrphansGiven", err)
+	}
+}
+
+func TestVideoService_Download(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Content type follows the container", func(t *testing.T) {
+		tests := []struct {
+			metadata    VideoMetadata
+			contentType string
+			ext         string
+		}{
+			{VideoMetadata{Container: "mov,mp4,m4a,3gp,3g2,mj2", Codec: "h264"}, "video/mp4", ".mp4"},
+			{VideoMetadata{Container: "matroska,webm", Codec: "vp9"}, "video/webm", ".webm"},
+			{VideoMetadata{Container: "matroska,webm", Codec: "h264"}, "video/x-matroska", ".mkv"},
+			{VideoMetadata{Container: "avi", Codec: "mpeg4"}, "video/x-msvideo", ".avi"},
+			{VideoMetadata{Codec: "vp8"}, "video/webm", ".webm"},
+			{VideoMetadata{Codec: "h264"}, "video/mp4", ".mp4"},
+			{VideoMetadata{Container: "flv", Codec: "flv1"}, "application/octet-stream", ""},
+		}
+		for _, tt := range tests {
+			contentType, ext := downloadContentType(tt.metadata)
+			if contentType != tt.contentType || ext != tt.ext {
+				t.Errorf("downloadContentType(%+v) = %s, %s, want %s, %s", tt.metadata, contentType, ext, tt.contentType, tt.ext)
+			}
+		}
+	})
+
+	t.Run("Filename is safe for headers", func(t *testing.T) {
+		id := primitive.NewObjectID()
+		tests := []struct {
+			title string
+			want  string
+		}{
+			{"My Holiday: Day 1", "My-Holiday-Day-1.mp4"},
+			{"evil\"\r\nSet-Cookie: a=b", "evil-Set-Cookie-a-b.mp4"},
+			{"../../etc/passwd", "etc-passwd.mp4"},
+			{"Café 東京", "Café-東京.mp4"},
+			{"!!!", "video-" + id.Hex() + ".mp4"},
+			{strings.Repeat("a", 150), strings.Repeat("a", maxDownloadNameLength) + ".mp4"},
+		}
+		for _, tt := range tests {
+			download := DownloadOf(&Video{ID: id, Title: tt.title})
+			if download.Filename != tt.want {
+				t.Errorf("DownloadOf(%q).Filename = %q, want %q", tt.title, download.Filename, tt.want)
+			}
+			if strings.ContainsAny(download.Disposition(), "\r\n") || !strings.HasPrefix(download.Disposition(), "attachment;") {
+				t.Errorf("Disposition() = %q", download.Disposition())
+			}
+		}
+	})
+
+	t.Run("Downloads are counted apart from views", func(t *testing.T) {
+		video, err := testVideoService.CreateVideoSimple(ctx, testUserID, "Download Count "+generateTestSuffix(), "")
+		if err != nil {
+			t.Fatalf("Failed to create video: %v", err)
+		}
+		defer testVideoService.DeleteVideo(ctx, video.ID, testUserID)
+
+		for i := 0; i < 2; i++ {
+			if err := testVideoService.RecordDownload(ctx, video.ID); err != nil {
+				t.Fatalf("RecordDownload() unexpected error = %v", err)
+			}
+		}
+		stored, err := testVideoService.GetVideoByID(ctx, video.ID)
+		if err != nil {
+			t.Fatalf("Failed to retrieve video: %v", err)
+		}
+		if stored.DownloadCount != 2 || stored.ViewCount != 0 {
+			t.Errorf("Counts after two downloads: %d downloads, %d views, want 2 and 0", stored.DownloadCount, stored.ViewCount)
+		}
+
+		if err := testVideoService.RecordDownload(ctx, primitive.NewObjectID()); !errors.Is(err, ErrNotFound) {
+			t.Errorf("RecordDownload() of a missing video error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestVideoService_RetryStuckVideos(t *testing.T) {
+	ctx := context.Background()
+
+	var stuck []*Video
+	for i := 0; i < 2; i++ {
+		video, err := testVideoService.CreateVideoSimple(ctx, primitive.NewObjectID(), "Stuck Video "+generateTestSuffix(), "")
+		if err != nil {
+			t.Fatalf("Failed to create video: %v", err)
+		}
+		defer testVideoService.DeleteVideo(ctx, video.ID, video.UserID)
+		stuck = append(stuck, video)
+
+		// Older than anything other tests leave behind, so they head the listing
+		_, err = testVideoService.videoCollection.UpdateByID(ctx, video.ID, bson.M{"$set": bson.M{
+			"status":     StatusFailed,
+			"error":      "Processing failed: transcoding failed",
+			"updated_at": time.Date(2000, 1, 1+i, 0, 0, 0, 0, time.UTC),
+		}})
+		if err != nil {
+			t.Fatalf("Failed to fail video: %v", err)
+		}
+	}
+
+	t.Run("Lists videos of every user by status", func(t *testing.T) {
+		page, err := testVideoService.GetVideosByStatus(ctx, StatusFailed, 1, 2)
+		if err != nil {
+			t.Fatalf("GetVideosByStatus() unexpected error = %v", err)
+		}
+		if len(page.Videos) != 2 || page.Videos[0].ID != stuck[0].ID || page.Videos[1].ID != stuck[1].ID {
+			t.Fatalf("GetVideosByStatus() = %+v, want the stuck videos oldest first", page.Videos)
+		}
+		if page.Total < 2 || page.Limit != 2 {
+			t.Errorf("GetVideosByStatus() total %d, limit %d", page.Total, page.Limit)
+		}
+
+		if _, err := testVideoService.GetVideosByStatus(ctx, "STUCK", 1, 10); err == nil {
+			t.Error("GetVideosByStatus() should reject an unknown status")
+		}
+	})
+
+	t.Run("Retries failed videos once", func(t *testing.T) {
+		job, err := testVideoService.RetryProcessing(ctx, stuck[0].ID)
+		if err != nil {
+			t.Fatalf("RetryProcessing() unexpected error = %v", err)
+		}
+		if job.Status != JobQueued || job.VideoID != stuck[0].ID || job.UserID != stuck[0].UserID {
+			t.Errorf("RetryProcessing() job = %+v, want a queued job for the video's owner", job)
+		}
+
+		stored, err := testVideoService.GetVideoByID(ctx, stuck[0].ID)
+		if err != nil {
+			t.Fatalf("Failed to retrieve video: %v", err)
+		}
+		if stored.Status != StatusPending || stored.Error != "" {
+			t.Errorf("Retried video status = %s, error = %q, want pending without an error", stored.Status, stored.Error)
+		}
+
+		if _, err := testVideoService.RetryProcessing(ctx, stuck[0].ID); !errors.Is(err, ErrProcessingActive) {
+			t.Errorf("RetryProcessing() while queued error = %v, want ErrProcessingActive", err)
+		}
+	})
+
+	t.Run("Refuses completed and missing videos", func(t *testing.T) {
+		if _, err := testVideoService.AdminBulkSetStatus(ctx, []primitive.ObjectID{stuck[1].ID}, StatusCompleted); err != nil {
+			t.Fatalf("Failed to complete video: %v", err)
+		}
+		if _, err := testVideoService.RetryProcessing(ctx, stuck[1].ID); !errors.Is(err, ErrNotRetryable) {
+			t.Errorf("RetryProcessing() of a completed video error = %v, want ErrNotRetryable", err)
+		}
+		if _, err := testVideoService.RetryProcessing(ctx, primitive.NewObjectID()); !errors.Is(err, ErrNotFound) {
+			t.Errorf("RetryProcessing() of a missing video error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestVideoHandler_GetVideoConditional(t *testing.T) {
+	ctx := context.Background()
+	video, err := testVideoService.CreateVideoSimple(ctx, testUserID, "Cached Video "+generateTestSuffix(), "Testing conditional requests")
+	if err != nil {
+		t.Fatalf("Failed to create test video: %v", err)
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Get("/video/:id", NewVideoHandler(testVideoService).GetVideo)
+	get := func(t *testing.T, header, value string) (int, string, string) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/video/"+video.ID.Hex(), nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("GET /video/:id error = %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode == fiber.StatusNotModified && len(body) > 0 {
+			t.Errorf("304 response has a body: %s", body)
+		}
+		return resp.StatusCode, resp.Header.Get(fiber.HeaderETag), resp.Header.Get(fiber.HeaderLastModified)
+	}
+
+	status, etag, lastModified := get(t, "", "")
+	if status != fiber.StatusOK || etag == "" || lastModified == "" {
+		t.Fatalf("GET /video/:id = %d with ETag %q and Last-Modified %q", status, etag, lastModified)
+	}
+
+	t.Run("Unchanged videos are not sent again", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			header string
+			value  string
+			want   int
+		}{
+			{"Matching ETag", fiber.HeaderIfNoneMatch, etag, fiber.StatusNotModified},
+			{"Matching ETag in a list", fiber.HeaderIfNoneMatch, `"other", ` + etag, fiber.StatusNotModified},
+			{"Strong form of the ETag", fiber.HeaderIfNoneMatch, strings.TrimPrefix(etag, "W/"), fiber.StatusNotModified},
+			{"Other ETag", fiber.HeaderIfNoneMatch, `W/"other"`, fiber.StatusOK},
+			{"Modified since Last-Modified", fiber.HeaderIfModifiedSince, lastModified, fiber.StatusNotModified},
+			{"Modified since later", fiber.HeaderIfModifiedSince, time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), fiber.StatusNotModified},
+			{"Modified since earlier", fiber.HeaderIfModifiedSince, video.UpdatedAt.Add(-time.Hour).UTC().Format(http.TimeFormat), fiber.StatusOK},
+			{"Invalid date", fiber.HeaderIfModifiedSince, "yesterday", fiber.StatusOK},
+		}
+		for _, tt := range tests {
+			if status, _, _ := get(t, tt.header, tt.value); status != tt.want {
+				t.Errorf("%s: GET /video/:id with %s %q = %d, want %d", tt.name, tt.header, tt.value, status, tt.want)
+			}
+		}
+	})
+
+	t.Run("ETag changes with the video", func(t *testing.T) {
+		changes := []struct {
+			name   string
+			change func() error
+		}{
+			{"title", func() error {
+				_, err := testVideoService.UpdateVideo(ctx, video.ID, testUserID, UpdateVideoRequest{Title: "Retitled " + generateTestSuffix()})
+				return err
+			}},
+			{"status", func() error {
+				return testVideoService.UpdateVideoStatus(ctx, video.ID, StatusProcessing)
+			}},
+			{"metadata", func() error {
+				return testVideoService.UpdateVideoMetadata(ctx, video.ID, VideoMetadata{Duration: 42, Width: 1280, Height: 720})
+			}},
+		}
+		for _, change := range changes {
+			previous := etag
+			time.Sleep(5 * time.Millisecond) // UpdatedAt is stored in milliseconds
+			if err := change.change(); err != nil {
+				t.Fatalf("Changing the %s failed: %v", change.name, err)
+			}
+
+			status, etag, _ = get(t, fiber.HeaderIfNoneMatch, previous)
+			if status != fiber.StatusOK || etag == previous {
+				t.Errorf("After changing the %s, GET /video/:id = %d with ETag %q, want 200 with a new ETag", change.name, status, etag)
+			}
+		}
+	})
+}
+
+func TestVideoService_ReplaceVideoFile(t *testing.T) {
+	ctx := context.Background()
+	video, err := testVideoService.CreateVideoSimple(ctx, testUserID, "Replace "+generateTestSuffix(), "Testing replacement")
+	if err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+	if err := testVideoService.storage.Save(ctx, video.FilePath, strings.NewReader("original upload")); err != nil {
+		t.Fatalf("Failed to store original: %v", err)
+	}
+	defer testVideoService.storage.Delete(ctx, video.FilePath)
+	defer CleanupFailedUpload(fmt.Sprintf("%s/%s_temp.mp4", uploadDir, video.ID.Hex()))
+
+	thumbnailID, err := testVideoService.uploadThumbnail(strings.NewReader("thumbnail"), video.ID)
+	if err != nil {
+		t.Fatalf("Failed to upload thumbnail: %v", err)
+	}
+	_, err = testVideoService.videoCollection.UpdateByID(ctx, video.ID, bson.M{"$set": bson.M{
+		"status":         StatusCompleted,
+		"view_count":     42,
+		"like_count":     7,
+		"thumbnail_path": thumbnailID.Hex(),
+	}})
+	if err != nil {
+		t.Fatalf("Failed to set up video: %v", err)
+	}
+
+	storedContent := func(key string) string {
+		stored, err := testVideoService.storage.Open(ctx, key)
+		if err != nil {
+			t.Fatalf("Failed to open stored file: %v", err)
+		}
+		defer stored.Close()
+		content, err := io.ReadAll(stored)
+		if err != nil {
+			t.Fatalf("Failed to read stored file: %v", err)
+		}
+		return string(content)
+	}
+
+	t.Run("Only the owner can replace the file", func(t *testing.T) {
+		_, err := testVideoService.ReplaceVideoFile(ctx, video.ID, primitive.NewObjectID(), strings.NewReader("other"))
+		if !errors.Is(err, ErrForbidden) {
+			t.Errorf("ReplaceVideoFile() by another user error = %v, want ErrForbidden", err)
+		}
+	})
+
+	t.Run("An invalid file keeps the old one", func(t *testing.T) {
+		if _, err := exec.LookPath("ffprobe"); err != nil {
+			t.Skip("ffprobe not available")
+		}
+		_, err := testVideoService.ReplaceVideoFile(ctx, video.ID, testUserID, strings.NewReader("not a video"))
+		if !errors.Is(err, ErrInvalidVideoFile) {
+			t.Fatalf("ReplaceVideoFile() error = %v, want ErrInvalidVideoFile", err)
+		}
+		if got := storedContent(video.FilePath); got != "original upload" {
+			t.Errorf("Stored file = %q, want the original", got)
+		}
+		unchanged, err := testVideoService.GetVideoByID(ctx, video.ID)
+		if err != nil {
+			t.Fatalf("GetVideoByID() unexpected error = %v", err)
+		}
+		if unchanged.Status != StatusCompleted || unchanged.ThumbnailPath != thumbnailID.Hex() {
+			t.Errorf("Video = %s with thumbnail %q, want it unchanged", unchanged.Status, unchanged.ThumbnailPath)
+		}
+	})
+
+	t.Run("A valid file is swapped in", func(t *testing.T) {
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			t.Skip("ffmpeg not available")
+		}
+		sourcePath := filepath.Join(t.TempDir(), "replacement.mp4")
+		cmd := exec.Command("ffmpeg",
+			"-f", "lavfi", "-i", "testsrc=s=64x48:r=10",
+			"-f", "lavfi", "-i", "sine",
+			"-t", "2", "-c:v", "libx264", "-pix_fmt", "yuv420p", "-c:a", "aac",
+			"-y", sourcePath)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Failed to generate test video: %v - %s", err, out)
+		}
+		replacement, err := os.ReadFile(sourcePath)
+		if err != nil {
+			t.Fatalf("Failed to read test video: %v", err)
+		}
+
+		replaced, err := testVideoService.ReplaceVideoFile(ctx, video.ID, testUserID, bytes.NewReader(replacement))
+		if err != nil {
+			t.Fatalf("ReplaceVideoFile() unexpected error = %v", err)
+		}
+		defer testVideoService.storage.Delete(ctx, replaced.FilePath)
+		if replaced.ID != video.ID || replaced.ViewCount != 42 || replaced.LikeCount != 7 {
+			t.Errorf("Replaced video %s has %d views and %d likes, want %s with 42 and 7",
+				replaced.ID.Hex(), replaced.ViewCount, replaced.LikeCount, video.ID.Hex())
+		}
+		if replaced.Status != StatusProcessing || replaced.ThumbnailPath != "" || replaced.HLSPath != "" {
+			t.Errorf("Replaced video = %s with thumbnail %q and HLS %q, want processing from scratch",
+				replaced.Status, replaced.ThumbnailPath, replaced.HLSPath)
+		}
+		if replaced.Metadata.Width != 64 || replaced.Metadata.FileSize != int64(len(replacement)) {
+			t.Errorf("Metadata = %+v, want that of the replacement", replaced.Metadata)
+		}
+		if replaced.FilePath == video.FilePath {
+			t.Errorf("Replacement was stored under the old key %s", video.FilePath)
+		}
+		if got := storedContent(replaced.FilePath); got != string(replacement) {
+			t.Errorf("Stored file has %d bytes, want the %d of the replacement", len(got), len(replacement))
+		}
+		if _, err := testVideoService.storage.Open(ctx, video.FilePath); !errors.Is(err, ErrFileNotFound) {
+			t.Errorf("Opening the old file error = %v, want ErrFileNotFound", err)
+		}
+		if _, err := testVideoService.DownloadFromGridFSByID(ctx, thumbnailID); err == nil {
+			t.Errorf("Old thumbnail %s was kept", thumbnailID.Hex())
+		}
+
+		job, err := testVideoService.queue.GetJob(ctx, video.ID)
+		if err != nil || job.Status != JobQueued {
+			t.Fatalf("GetJob() = %+v, %v, want a queued job", job, err)
+		}
+
+		// The queued job has to run before the file can be replaced again
+		_, err = testVideoService.ReplaceVideoFile(ctx, video.ID, testUserID, bytes.NewReader(replacement))
+		if !errors.Is(err, ErrProcessingActive) {
+			t.Errorf("ReplaceVideoFile() while queued error = %v, want ErrProcessingActive", err)
+		}
+	})
+}
+
@@ -0,0 +1,152 @@
+package video
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// DefaultShareLinkTTL is how long a share link lasts when no lifetime is given
+	DefaultShareLinkTTL = 7 * 24 * time.Hour
+	// MaxShareLinkTTL caps the lifetime of a share link
+	MaxShareLinkTTL = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrInvalidShareToken is returned for a share token that is malformed, forged,
+	// expired, revoked or issued for another video
+	ErrInvalidShareToken = errors.New("invalid or expired share link")
+	// ErrShareLinkNotFound is returned when revoking a link that doesn't exist or belongs
+	// to another user
+	ErrShareLinkNotFound = errors.New("share link not found")
+	// ErrShareLinkTTL is returned for a share link lifetime above MaxShareLinkTTL
+	ErrShareLinkTTL = fmt.Errorf("share links can last at most %s", MaxShareLinkTTL)
+	// ErrShareLinksDisabled is returned when no share link key is set
+	ErrShareLinksDisabled = errors.New("share links are not configured")
+)
+
+// ShareLink grants anyone holding its token read access to one video until it expires
+// or its owner revokes it. The token is only returned when the link is created.
+type ShareLink struct {
+	ID        primitive.ObjectID `bson:"_id" json:"ID"`
+	VideoID   primitive.ObjectID `bson:"video_id" json:"VideoID"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"-"`
+	Token     string             `bson:"-" json:"Token,omitempty"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"ExpiresAt"`
+	CreatedAt time.Time          `bson:"created_at" json:"CreatedAt"`
+}
+
+// shareTokenPayloadLen is the signed part of a token: the link ID, the video ID and the
+// expiry in Unix seconds
+const shareTokenPayloadLen = 12 + 12 + 8
+
+// SetShareLinkKey sets the secret share tokens are signed with. A key for share links is
+// derived from it, so it can be shared with other signers such as JWTs.
+func (s *VideoService) SetShareLinkKey(secret string) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("streamflow video share links"))
+	s.shareKey = mac.Sum(nil)
+}
+
+// CreateShareLink creates a link giving read access to one of the owner's videos, whatever
+// its visibility, for ttl. A ttl of zero uses DefaultShareLinkTTL.
+func (s *VideoService) CreateShareLink(ctx context.Context, videoID, ownerID primitive.ObjectID, ttl time.Duration) (*ShareLink, error) {
+	if s.shareKey == nil {
+		return nil, ErrShareLinksDisabled
+	}
+	if ttl <= 0 {
+		ttl = DefaultShareLinkTTL
+	}
+	if ttl > MaxShareLinkTTL {
+		return nil, ErrShareLinkTTL
+	}
+	if _, err := s.getOwnedVideo(ctx, videoID, ownerID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	link := &ShareLink{
+		ID:        primitive.NewObjectID(),
+		VideoID:   videoID,
+		UserID:    ownerID,
+		ExpiresAt: now.Add(ttl).Truncate(time.Second),
+		CreatedAt: now,
+	}
+	if _, err := s.shareCollection.InsertOne(ctx, link); err != nil {
+		return nil, fmt.Errorf("failed to create share link: %w", err)
+	}
+	link.Token = s.signShareToken(link.ID, link.VideoID, link.ExpiresAt)
+	return link, nil
+}
+
+// RevokeShareLink deletes one of the owner's share links to a video, so its token stops working
+func (s *VideoService) RevokeShareLink(ctx context.Context, videoID, linkID, ownerID primitive.ObjectID) error {
+	result, err := s.shareCollection.DeleteOne(ctx, bson.M{"_id": linkID, "video_id": videoID, "user_id": ownerID})
+	if err != nil {
+		return fmt.Errorf("failed to revoke share link: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrShareLinkNotFound
+	}
+	return nil
+}
+
+// VerifyShareToken checks that token is a live share link for videoID. Tokens for other
+// videos are rejected with ErrInvalidShareToken like forged ones.
+func (s *VideoService) VerifyShareToken(ctx context.Context, token string, videoID primitive.ObjectID) error {
+	if s.shareKey == nil {
+		return ErrInvalidShareToken
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) != shareTokenPayloadLen+sha256.Size {
+		return ErrInvalidShareToken
+	}
+	payload, signature := data[:shareTokenPayloadLen], data[shareTokenPayloadLen:]
+	if !hmac.Equal(signature, s.shareSignature(payload)) {
+		return ErrInvalidShareToken
+	}
+
+	var linkID, tokenVideoID primitive.ObjectID
+	copy(linkID[:], payload[:12])
+	copy(tokenVideoID[:], payload[12:24])
+	expiresAt := time.Unix(int64(binary.BigEndian.Uint64(payload[24:])), 0)
+	if tokenVideoID != videoID || !time.Now().Before(expiresAt) {
+		return ErrInvalidShareToken
+	}
+
+	// Revoked links are deleted
+	err = s.shareCollection.FindOne(ctx, bson.M{"_id": linkID, "video_id": videoID}).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrInvalidShareToken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up share link: %w", err)
+	}
+	return nil
+}
+
+// signShareToken encodes and signs a share token
+func (s *VideoService) signShareToken(linkID, videoID primitive.ObjectID, expiresAt time.Time) string {
+	payload := make([]byte, 0, shareTokenPayloadLen+sha256.Size)
+	payload = append(payload, linkID[:]...)
+	payload = append(payload, videoID[:]...)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(expiresAt.Unix()))
+	return base64.RawURLEncoding.EncodeToString(append(payload, s.shareSignature(payload)...))
+}
+
+func (s *VideoService) shareSignature(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.shareKey)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
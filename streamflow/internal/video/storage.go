@@ -0,0 +1,272 @@
+package video
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"streamflow/internal/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+)
+
+// Storage backends selectable with config.StorageConfig.Backend
+const (
+	StorageBackendGridFS = "gridfs"
+	StorageBackendLocal  = "local"
+	StorageBackendS3     = "s3"
+)
+
+// ErrFileNotFound is returned when a stored file does not exist
+var ErrFileNotFound = errors.New("stored file not found")
+
+// Storage holds original video files, addressed by the video's FilePath
+type Storage interface {
+	// Save stores the contents of r under key, replacing any existing file
+	Save(ctx context.Context, key string, r io.Reader) error
+	// Open returns the file stored under key
+	Open(ctx context.Context, key string) (StoredFile, error)
+	// Delete removes the file stored under key
+	Delete(ctx context.Context, key string) error
+	// URL returns a time-limited URL clients can fetch the file from directly, or ""
+	// if the backend can't hand out URLs and the file has to be served by us
+	URL(ctx context.Context, key string) (string, error)
+	// DownloadURL is URL for a download: clients fetching it are sent the given
+	// Content-Disposition and Content-Type
+	DownloadURL(ctx context.Context, key, disposition, contentType string) (string, error)
+}
+
+// StoredFile is an open stored file. Seeking lets handlers serve byte ranges.
+type StoredFile interface {
+	io.ReadSeekCloser
+	Size() int64
+}
+
+// NewStorage creates the storage backend selected in cfg. GridFS is the default and
+// keeps files in the given bucket.
+func NewStorage(cfg config.StorageConfig, fs *gridfs.Bucket) (Storage, error) {
+	switch cfg.Backend {
+	case "", StorageBackendGridFS:
+		return NewGridFSStorage(fs), nil
+	case StorageBackendLocal:
+		return NewLocalStorage(cfg.LocalPath)
+	case StorageBackendS3:
+		return NewS3Storage(context.Background(), cfg)
+	}
+	return nil, fmt.Errorf("unknown storage backend: %s", cfg.Backend)
+}
+
+// GridFSStorage keeps files in a GridFS bucket, named by their key
+type GridFSStorage struct {
+	fs *gridfs.Bucket
+}
+
+// NewGridFSStorage creates a storage backed by the GridFS bucket
+func NewGridFSStorage(fs *gridfs.Bucket) *GridFSStorage {
+	return &GridFSStorage{fs: fs}
+}
+
+func (g *GridFSStorage) Save(ctx context.Context, key string, r io.Reader) error {
+	// The stream is closed or aborted before returning, so a caller deleting the file
+	// afterwards, such as for a rejected upload, can't have it written back by a late Close
+	stream, err := g.fs.OpenUploadStream(key)
+	if err != nil {
+		return fmt.Errorf("failed to save %s to GridFS: %w", key, err)
+	}
+	if _, err := io.Copy(stream, r); err != nil {
+		stream.Abort()
+		return fmt.Errorf("failed to save %s to GridFS: %w", key, err)
+	}
+	if err := stream.Close(); err != nil {
+		stream.Abort()
+		return fmt.Errorf("failed to save %s to GridFS: %w", key, err)
+	}
+
+	// Previous revisions are only dropped once the new one is complete, so a failed
+	// upload leaves the existing file in place
+	if _, err := g.deleteRevisions(ctx, key, stream.FileID); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (g *GridFSStorage) Open(ctx context.Context, key string) (StoredFile, error) {
+	stream, err := g.fs.OpenDownloadStreamByName(key)
+	if err != nil {
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			return nil, ErrFileNotFound
+		}
+		return nil, err
+	}
+	return &gridFSFile{gridFSSeeker: gridFSSeeker{stream: stream}}, nil
+}
+
+func (g *GridFSStorage) Delete(ctx context.Context, key string) error {
+	found, err := g.deleteRevisions(ctx, key, nil)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrFileNotFound
+	}
+	return nil
+}
+
+// deleteRevisions deletes the files named key other than the one with ID keep, and
+// reports whether there were any
+func (g *GridFSStorage) deleteRevisions(ctx context.Context, key string, keep interface{}) (bool, error) {
+	filter := bson.M{"filename": key}
+	if keep != nil {
+		filter["_id"] = bson.M{"$ne": keep}
+	}
+	cursor, err := g.fs.Find(filter)
+	if err != nil {
+		return false, err
+	}
+	defer cursor.Close(ctx)
+
+	found := false
+	for cursor.Next(ctx) {
+		var file struct {
+			ID interface{} `bson:"_id"`
+		}
+		if err := cursor.Decode(&file); err != nil {
+			return false, err
+		}
+		if err := g.fs.Delete(file.ID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return false, fmt.Errorf("failed to delete %s from GridFS: %w", key, err)
+		}
+		found = true
+	}
+	return found, cursor.Err()
+}
+
+// URL returns "" as GridFS files are always served through the API
+func (g *GridFSStorage) URL(ctx context.Context, key string) (string, error) {
+	return "", nil
+}
+
+func (g *GridFSStorage) DownloadURL(ctx context.Context, key, disposition, contentType string) (string, error) {
+	return "", nil
+}
+
+// gridFSFile is a GridFS download stream. Like gridFSSeeker it can only seek forwards,
+// which is all serving a single byte range needs.
+type gridFSFile struct {
+	gridFSSeeker
+}
+
+func (f *gridFSFile) Size() int64 {
+	return f.stream.GetFile().Length
+}
+
+// LocalStorage keeps files in a directory on the local disk
+type LocalStorage struct {
+	baseDir string
+}
+
+// NewLocalStorage creates a storage in baseDir, creating the directory if needed
+func NewLocalStorage(baseDir string) (*LocalStorage, error) {
+	if baseDir == "" {
+		return nil, fmt.Errorf("local storage path is required")
+	}
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &LocalStorage{baseDir: baseDir}, nil
+}
+
+func (l *LocalStorage) Save(ctx context.Context, key string, r io.Reader) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see a partial file
+	tmp, err := os.CreateTemp(l.baseDir, ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	closeErr := tmp.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to write file: %w", closeErr)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store file: %w", err)
+	}
+	return nil
+}
+
+func (l *LocalStorage) Open(ctx context.Context, key string) (StoredFile, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrFileNotFound
+		}
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &localFile{File: f, size: info.Size()}, nil
+}
+
+func (l *LocalStorage) Delete(ctx context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return ErrFileNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// URL returns "" as local files are always served through the API
+func (l *LocalStorage) URL(ctx context.Context, key string) (string, error) {
+	return "", nil
+}
+
+func (l *LocalStorage) DownloadURL(ctx context.Context, key, disposition, contentType string) (string, error) {
+	return "", nil
+}
+
+// path maps a key to a file in the storage directory, rejecting keys that would
+// escape it
+func (l *LocalStorage) path(key string) (string, error) {
+	if key == "" || key != filepath.Base(key) || strings.HasPrefix(key, ".") {
+		return "", fmt.Errorf("invalid storage key: %q", key)
+	}
+	return filepath.Join(l.baseDir, key), nil
+}
+
+type localFile struct {
+	*os.File
+	size int64
+}
+
+func (f *localFile) Size() int64 {
+	return f.size
+}
@@ -0,0 +1,248 @@
+package video
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"time"
+
+	"streamflow/internal/config"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// defaultPresignExpiry is how long presigned URLs stay valid when not configured
+const defaultPresignExpiry = 15 * time.Minute
+
+// S3Storage keeps files in an S3-compatible bucket. Clients are redirected to presigned
+// URLs, so S3 serves byte ranges itself.
+type S3Storage struct {
+	client        *s3.Client
+	presign       *s3.PresignClient
+	bucket        string
+	presignExpiry time.Duration
+}
+
+// NewS3Storage creates a storage for cfg.S3Bucket. Credentials come from cfg when set and
+// from the default AWS credential chain otherwise. cfg.S3Endpoint points the client at
+// an S3-compatible service such as MinIO.
+func NewS3Storage(ctx context.Context, cfg config.StorageConfig) (*S3Storage, error) {
+	if cfg.S3Bucket == "" {
+		return nil, fmt.Errorf("S3 bucket is required")
+	}
+
+	opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(cfg.S3Region)}
+	if cfg.S3AccessKey != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.S3AccessKey, cfg.S3SecretKey, "")))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.S3Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.S3Endpoint)
+		}
+		o.UsePathStyle = cfg.S3UsePathStyle
+	})
+
+	expiry := cfg.S3PresignExpiry
+	if expiry <= 0 {
+		expiry = defaultPresignExpiry
+	}
+
+	return &S3Storage{
+		client:        client,
+		presign:       s3.NewPresignClient(client),
+		bucket:        cfg.S3Bucket,
+		presignExpiry: expiry,
+	}, nil
+}
+
+func (s *S3Storage) Save(ctx context.Context, key string, r io.Reader) error {
+	// PutObject needs a seekable body of known length, so spool streams to disk first
+	body, ok := r.(io.ReadSeeker)
+	if !ok {
+		tmp, err := os.CreateTemp("", "s3-upload-*")
+		if err != nil {
+			return fmt.Errorf("failed to create temporary file: %w", err)
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		if _, err := io.Copy(tmp, r); err != nil {
+			return fmt.Errorf("failed to buffer upload: %w", err)
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		body = tmp
+	}
+
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentTypeForKey(key)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s to S3: %w", key, err)
+	}
+	return nil
+}
+
+func (s *S3Storage) Open(ctx context.Context, key string) (StoredFile, error) {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+
+	return &s3File{
+		storage: s,
+		key:     key,
+		size:    aws.ToInt64(head.ContentLength),
+	}, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return s3Error(err)
+	}
+	return nil
+}
+
+// URL returns a presigned GET URL for the object
+func (s *S3Storage) URL(ctx context.Context, key string) (string, error) {
+	req, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(s.presignExpiry))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign %s: %w", key, err)
+	}
+	return req.URL, nil
+}
+
+// DownloadURL returns a presigned GET URL for the object that overrides the headers S3
+// responds with
+func (s *S3Storage) DownloadURL(ctx context.Context, key, disposition, contentType string) (string, error) {
+	req, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket:                     aws.String(s.bucket),
+		Key:                        aws.String(key),
+		ResponseContentDisposition: aws.String(disposition),
+		ResponseContentType:        aws.String(contentType),
+	}, s3.WithPresignExpires(s.presignExpiry))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign %s: %w", key, err)
+	}
+	return req.URL, nil
+}
+
+// s3Error maps missing objects to ErrFileNotFound
+func s3Error(err error) error {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+		return ErrFileNotFound
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotFound" {
+		return ErrFileNotFound
+	}
+	return err
+}
+
+// s3File reads an object lazily. Seeking closes the current body and the next read
+// fetches the rest of the object from the new offset with a ranged GET. Reads usually
+// happen after the handler that opened the file has returned, so they don't use the
+// request context.
+type s3File struct {
+	storage *S3Storage
+	key     string
+	size    int64
+	pos     int64
+	body    io.ReadCloser
+}
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.pos >= f.size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		out, err := f.storage.client.GetObject(context.Background(), &s3.GetObjectInput{
+			Bucket: aws.String(f.storage.bucket),
+			Key:    aws.String(f.key),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-", f.pos)),
+		})
+		if err != nil {
+			return 0, s3Error(err)
+		}
+		f.body = out.Body
+	}
+
+	n, err := f.body.Read(p)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	target := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		target = f.pos + offset
+	case io.SeekEnd:
+		target = f.size + offset
+	default:
+		return f.pos, fmt.Errorf("unsupported seek whence %d", whence)
+	}
+	if target < 0 {
+		return f.pos, fmt.Errorf("negative seek position")
+	}
+
+	if target != f.pos && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.pos = target
+	return f.pos, nil
+}
+
+func (f *s3File) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	err := f.body.Close()
+	f.body = nil
+	return err
+}
+
+func (f *s3File) Size() int64 {
+	return f.size
+}
+
+// contentTypeForKey returns the MIME type presigned downloads are served with
+func contentTypeForKey(key string) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(key)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,294 @@
+package video
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Local directories uploads pass through before they are processed
+const (
+	uploadDir         = "storage/uploads"          // Temporary copies of uploads waiting to be processed
+	uploadSessionsDir = "storage/uploads/sessions" // Chunks of resumable uploads
+	processedDir      = "storage/processed"        // HLS output of running transcodes
+)
+
+// OrphanGracePeriod is how long a file is left alone before it can count as orphaned, so
+// files of uploads and transcodes still in progress are never reported
+const OrphanGracePeriod = time.Hour
+
+// MaxOrphanCleanup bounds the number of paths one cleanup request may name
+const MaxOrphanCleanup = 1000
+
+// ErrNoOrphansGiven is returned by RemoveOrphanedFiles when no paths are given
+var ErrNoOrphansGiven = fmt.Errorf("between 1 and %d paths are required", MaxOrphanCleanup)
+
+// StorageStats reports the disk usage of the directories video files are kept in
+type StorageStats struct {
+	TotalBytes  int64            `json:"TotalBytes"`
+	FileCount   int              `json:"FileCount"`
+	Directories []DirectoryUsage `json:"Directories"`
+	Orphans     []OrphanFile     `json:"Orphans"`
+	OrphanBytes int64            `json:"OrphanBytes"`
+}
+
+// DirectoryUsage is the disk usage of one directory, including its subdirectories
+type DirectoryUsage struct {
+	Path      string `json:"Path"`
+	Bytes     int64  `json:"Bytes"`
+	FileCount int    `json:"FileCount"`
+}
+
+// OrphanFile is a file on disk no video needs anymore
+type OrphanFile struct {
+	Path       string    `json:"Path"`
+	Size       int64     `json:"Size"`
+	ModifiedAt time.Time `json:"ModifiedAt"`
+}
+
+// OrphanCleanup reports which of the requested paths were removed. Paths that aren't
+// orphans, or no longer are, are skipped.
+type OrphanCleanup struct {
+	Removed    []string `json:"Removed"`
+	Skipped    []string `json:"Skipped"`
+	FreedBytes int64    `json:"FreedBytes"`
+}
+
+// storageRoots are the directories scanned for storage stats. Originals is empty unless
+// original files are kept on the local disk.
+type storageRoots struct {
+	Uploads   string // Upload sessions below it are counted but never orphans
+	Processed string
+	Originals string
+}
+
+// orphanCandidate is a file named after the video it belongs to
+type orphanCandidate struct {
+	file    OrphanFile
+	videoID primitive.ObjectID
+	needs   []VideoStatus // Statuses in which the video still needs the file; none means any
+	key     string        // Storage key of an original, which must be the video's current file
+}
+
+// GetStorageStats walks the local video directories and reports their usage along with
+// the orphaned files in them
+func (s *VideoService) GetStorageStats(ctx context.Context) (*StorageStats, error) {
+	return s.scanStorage(ctx, s.storageRoots(), time.Now())
+}
+
+// RemoveOrphanedFiles deletes the given files if a fresh scan still finds them orphaned,
+// so only orphans confirmed from GetStorageStats are removed
+func (s *VideoService) RemoveOrphanedFiles(ctx context.Context, paths []string) (*OrphanCleanup, error) {
+	return s.removeOrphans(ctx, s.storageRoots(), paths, time.Now())
+}
+
+// removeOrphans deletes the paths a scan of roots at now finds orphaned
+func (s *VideoService) removeOrphans(ctx context.Context, roots storageRoots, paths []string, now time.Time) (*OrphanCleanup, error) {
+	if len(paths) == 0 || len(paths) > MaxOrphanCleanup {
+		return nil, ErrNoOrphansGiven
+	}
+
+	stats, err := s.scanStorage(ctx, roots, now)
+	if err != nil {
+		return nil, err
+	}
+	orphans := make(map[string]OrphanFile, len(stats.Orphans))
+	for _, orphan := range stats.Orphans {
+		orphans[orphan.Path] = orphan
+	}
+
+	result := &OrphanCleanup{Removed: []string{}, Skipped: []string{}}
+	for _, path := range paths {
+		orphan, ok := orphans[path]
+		if !ok {
+			result.Skipped = append(result.Skipped, path)
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			result.Skipped = append(result.Skipped, path)
+			continue
+		}
+		delete(orphans, path) // Listed twice
+		// Transcode output sits in a directory per video, which goes once it's empty
+		if dir := filepath.Dir(path); filepath.Dir(dir) == filepath.Clean(roots.Processed) {
+			os.Remove(dir)
+		}
+		result.Removed = append(result.Removed, path)
+		result.FreedBytes += orphan.Size
+	}
+	return result, nil
+}
+
+// storageRoots returns the directories this instance keeps video files in
+func (s *VideoService) storageRoots() storageRoots {
+	roots := storageRoots{Uploads: uploadDir, Processed: processedDir}
+	if local, ok := s.storage.(*LocalStorage); ok {
+		roots.Originals = local.baseDir
+	}
+	return roots
+}
+
+// scanStorage walks roots, counting every file and collecting those older than
+// OrphanGracePeriod whose video doesn't need them. Only files named after a video are
+// considered, so other files such as avatars are never reported. Upload sessions are
+// counted but not considered, as they belong to uploads in progress.
+func (s *VideoService) scanStorage(ctx context.Context, roots storageRoots, now time.Time) (*StorageStats, error) {
+	stats := &StorageStats{Directories: []DirectoryUsage{}, Orphans: []OrphanFile{}}
+	var candidates []orphanCandidate
+	cutoff := now.Add(-OrphanGracePeriod)
+
+	for _, root := range []string{roots.Uploads, roots.Processed, roots.Originals} {
+		if root == "" {
+			continue
+		}
+		usage := DirectoryUsage{Path: filepath.ToSlash(root)}
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil // Not created yet, or removed while walking
+				}
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			usage.Bytes += info.Size()
+			usage.FileCount++
+
+			if !info.Mode().IsRegular() || info.ModTime().After(cutoff) {
+				return nil
+			}
+			file := OrphanFile{Path: filepath.ToSlash(path), Size: info.Size(), ModifiedAt: info.ModTime()}
+			if candidate, ok := classifyStoredFile(roots, root, path, file); ok {
+				candidates = append(candidates, candidate)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", root, err)
+		}
+		stats.Directories = append(stats.Directories, usage)
+		stats.TotalBytes += usage.Bytes
+		stats.FileCount += usage.FileCount
+	}
+
+	orphans, err := s.findOrphans(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+	for _, orphan := range orphans {
+		stats.Orphans = append(stats.Orphans, orphan)
+		stats.OrphanBytes += orphan.Size
+	}
+	return stats, nil
+}
+
+// classifyStoredFile works out which video a file under root belongs to and what the video
+// needs it for. ok is false for files that are never orphans. Video IDs of zero mark files
+// no video needs at all, e.g. the leftovers of a storage write that crashed.
+func classifyStoredFile(roots storageRoots, root, path string, file OrphanFile) (orphanCandidate, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return orphanCandidate{}, false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	name := parts[len(parts)-1]
+
+	switch root {
+	case roots.Uploads:
+		// Uploads are copied here and removed once processing finishes
+		if len(parts) != 1 || !strings.HasSuffix(name, "_temp.mp4") {
+			return orphanCandidate{}, false
+		}
+		videoID, err := primitive.ObjectIDFromHex(strings.TrimSuffix(name, "_temp.mp4"))
+		if err != nil {
+			return orphanCandidate{}, false
+		}
+		return orphanCandidate{file: file, videoID: videoID, needs: []VideoStatus{StatusPending, StatusProcessing}}, true
+
+	case roots.Processed:
+		// Transcodes write here and the output is removed once it's uploaded
+		if len(parts) != 2 {
+			return orphanCandidate{}, false
+		}
+		videoID, err := primitive.ObjectIDFromHex(parts[0])
+		if err != nil {
+			return orphanCandidate{}, false
+		}
+		return orphanCandidate{file: file, videoID: videoID, needs: []VideoStatus{StatusProcessing}}, true
+
+	case roots.Originals:
+		if len(parts) != 1 {
+			return orphanCandidate{}, false
+		}
+		// Left behind by a LocalStorage.Save that never finished
+		if strings.HasPrefix(name, ".upload-") {
+			return orphanCandidate{file: file}, true
+		}
+		// Named <video ID>.mp4, or <video ID>_<revision>.mp4 once the file was replaced
+		id, _, _ := strings.Cut(strings.TrimSuffix(name, ".mp4"), "_")
+		videoID, err := primitive.ObjectIDFromHex(id)
+		if err != nil || !strings.HasSuffix(name, ".mp4") {
+			return orphanCandidate{}, false
+		}
+		// Kept while the video exists, even soft-deleted, as it can be restored
+		return orphanCandidate{file: file, videoID: videoID, key: name}, true
+	}
+	return orphanCandidate{}, false
+}
+
+// findOrphans returns the candidates whose video is gone or no longer needs them
+func (s *VideoService) findOrphans(ctx context.Context, candidates []orphanCandidate) ([]OrphanFile, error) {
+	ids := make([]primitive.ObjectID, 0, len(candidates))
+	for _, candidate := range candidates {
+		if !candidate.videoID.IsZero() {
+			ids = append(ids, candidate.videoID)
+		}
+	}
+
+	stored := make(map[primitive.ObjectID]Video, len(ids))
+	if len(ids) > 0 {
+		opts := options.Find().SetProjection(bson.M{"status": 1, "file_path": 1})
+		cursor, err := s.videoCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up videos of stored files: %w", err)
+		}
+		var videos []Video
+		if err := cursor.All(ctx, &videos); err != nil {
+			return nil, fmt.Errorf("failed to decode videos of stored files: %w", err)
+		}
+		for _, video := range videos {
+			stored[video.ID] = video
+		}
+	}
+
+	var orphans []OrphanFile
+	for _, candidate := range candidates {
+		video, exists := stored[candidate.videoID]
+		switch {
+		case !exists,
+			len(candidate.needs) > 0 && !slices.Contains(candidate.needs, video.Status),
+			// An earlier file of a video whose file was replaced
+			candidate.key != "" && video.FilePath != "" && video.FilePath != candidate.key:
+			orphans = append(orphans, candidate.file)
+		}
+	}
+	return orphans, nil
+}
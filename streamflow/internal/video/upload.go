@@ -0,0 +1,328 @@
+package video
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// MaxUploadChunks bounds the number of chunks a single upload session may declare
+	MaxUploadChunks = 10000
+	// uploadSessionTTL is how long an unfinished upload session can be resumed
+	uploadSessionTTL = 24 * time.Hour
+)
+
+var (
+	ErrUploadSessionNotFound = errors.New("upload session not found")
+	ErrChunkIndexOutOfRange  = errors.New("chunk index out of range")
+	ErrUploadIncomplete      = errors.New("upload is missing chunks")
+	ErrUploadTooLarge        = errors.New("upload exceeds maximum file size")
+	// ErrUploadCompleting is returned while another request is completing the upload
+	ErrUploadCompleting = errors.New("upload is already being completed")
+)
+
+// UploadSession tracks a resumable chunked upload
+type UploadSession struct {
+	ID          string             `json:"upload_id"`
+	UserID      primitive.ObjectID `json:"user_id"`
+	Title       string             `json:"title"`
+	Description string             `json:"description"`
+	Visibility  Visibility         `json:"visibility"`
+	Filename    string             `json:"filename"`
+	TotalChunks int                `json:"total_chunks"`
+	CreatedAt   time.Time          `json:"created_at"`
+
+	dir        string
+	chunkSizes map[int]int64
+	completing bool // Claimed by Assemble; no more chunks are accepted
+}
+
+// UploadSessionStatus reports which chunks of a session have been received
+type UploadSessionStatus struct {
+	*UploadSession
+	ReceivedChunks []int `json:"received_chunks"`
+	ReceivedBytes  int64 `json:"received_bytes"`
+}
+
+// InitUploadRequest defines the body for starting a chunked upload
+type InitUploadRequest struct {
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Visibility  Visibility `json:"visibility"` // Defaults to public
+	Filename    string     `json:"filename"`
+	TotalChunks int        `json:"total_chunks"`
+}
+
+// UploadSessionStore keeps chunked upload sessions in memory and their chunks on disk.
+// Each chunk is stored as its own file so chunks can be retried or sent out of order.
+type UploadSessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*UploadSession
+	baseDir  string
+}
+
+// NewUploadSessionStore creates a store that keeps chunks under baseDir
+func NewUploadSessionStore(baseDir string) *UploadSessionStore {
+	return &UploadSessionStore{
+		sessions: make(map[string]*UploadSession),
+		baseDir:  baseDir,
+	}
+}
+
+// Create starts a new upload session
+func (s *UploadSessionStore) Create(userID primitive.ObjectID, req InitUploadRequest) (*UploadSession, error) {
+	if req.TotalChunks < 1 || req.TotalChunks > MaxUploadChunks {
+		return nil, fmt.Errorf("total_chunks must be between 1 and %d", MaxUploadChunks)
+	}
+	if err := validateVideoExtension(req.Filename); err != nil {
+		return nil, err
+	}
+	if req.Visibility != "" && !req.Visibility.IsValid() {
+		return nil, ErrInvalidVisibility
+	}
+
+	id, err := newUploadID()
+	if err != nil {
+		return nil, err
+	}
+
+	session := &UploadSession{
+		ID:          id,
+		UserID:      userID,
+		Title:       req.Title,
+		Description: req.Description,
+		Visibility:  req.Visibility,
+		Filename:    req.Filename,
+		TotalChunks: req.TotalChunks,
+		CreatedAt:   time.Now(),
+		dir:         filepath.Join(s.baseDir, id),
+		chunkSizes:  make(map[int]int64),
+	}
+	if err := os.MkdirAll(session.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeExpiredLocked()
+	s.sessions[id] = session
+
+	return session, nil
+}
+
+// Status returns the session with the chunk indices received so far
+func (s *UploadSessionStore) Status(id string, userID primitive.ObjectID) (*UploadSessionStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, err := s.getLocked(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &UploadSessionStatus{UploadSession: session, ReceivedChunks: []int{}}
+	for index, size := range session.chunkSizes {
+		status.ReceivedChunks = append(status.ReceivedChunks, index)
+		status.ReceivedBytes += size
+	}
+	sort.Ints(status.ReceivedChunks)
+
+	return status, nil
+}
+
+// WriteChunk stores chunk index of the session, replacing any earlier copy of it.
+// The chunk is rejected if it would take the upload past MaxFileSize.
+func (s *UploadSessionStore) WriteChunk(id string, userID primitive.ObjectID, index int, r io.Reader) error {
+	s.mu.Lock()
+	session, err := s.getLocked(id, userID)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if session.completing {
+		s.mu.Unlock()
+		return ErrUploadCompleting
+	}
+	if index < 0 || index >= session.TotalChunks {
+		s.mu.Unlock()
+		return ErrChunkIndexOutOfRange
+	}
+	// Bytes stored in the other chunks limit how large this one may be
+	remaining := int64(MaxFileSize)
+	for i, size := range session.chunkSizes {
+		if i != index {
+			remaining -= size
+		}
+	}
+	s.mu.Unlock()
+
+	// Write to a temporary file first so a dropped connection never leaves a partial chunk
+	chunkPath := session.chunkPath(index)
+	tmp, err := os.CreateTemp(session.dir, "chunk-*")
+	if err != nil {
+		return fmt.Errorf("failed to create chunk file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, io.LimitReader(r, remaining+1))
+	closeErr := tmp.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to write chunk: %w", closeErr)
+	}
+	if written > remaining {
+		return ErrUploadTooLarge
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The session may have been completed or cancelled while the chunk was uploading
+	if _, err := s.getLocked(id, userID); err != nil {
+		return err
+	}
+	if session.completing {
+		return ErrUploadCompleting
+	}
+	if err := os.Rename(tmp.Name(), chunkPath); err != nil {
+		return fmt.Errorf("failed to store chunk: %w", err)
+	}
+	session.chunkSizes[index] = written
+
+	return nil
+}
+
+// Assemble concatenates the chunks in order into a single file and returns its path.
+// The caller owns the returned file and should remove the session with Delete afterwards.
+// Only one caller can assemble a session; others get ErrUploadCompleting.
+func (s *UploadSessionStore) Assemble(id string, userID primitive.ObjectID) (*UploadSession, string, error) {
+	session, err := s.claim(id, userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The claim keeps chunks and other completions out, so the copy runs unlocked
+	assembledPath := filepath.Join(session.dir, "assembled"+filepath.Ext(session.Filename))
+	if err := assembleChunks(session, assembledPath); err != nil {
+		os.Remove(assembledPath)
+		s.mu.Lock()
+		session.completing = false
+		s.mu.Unlock()
+		return nil, "", err
+	}
+
+	return session, assembledPath, nil
+}
+
+// claim marks a session with all of its chunks as being completed
+func (s *UploadSessionStore) claim(id string, userID primitive.ObjectID) (*UploadSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, err := s.getLocked(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if session.completing {
+		return nil, ErrUploadCompleting
+	}
+
+	var total int64
+	for index := 0; index < session.TotalChunks; index++ {
+		size, ok := session.chunkSizes[index]
+		if !ok {
+			return nil, fmt.Errorf("%w: chunk %d has not been received", ErrUploadIncomplete, index)
+		}
+		total += size
+	}
+	if total > MaxFileSize {
+		return nil, ErrUploadTooLarge
+	}
+
+	session.completing = true
+	return session, nil
+}
+
+// assembleChunks concatenates the chunks of a session in order into path
+func assembleChunks(session *UploadSession, path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create assembled file: %w", err)
+	}
+	for index := 0; index < session.TotalChunks; index++ {
+		if err := appendFile(out, session.chunkPath(index)); err != nil {
+			out.Close()
+			return fmt.Errorf("failed to assemble chunk %d: %w", index, err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write assembled file: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the session and all of its files
+func (s *UploadSessionStore) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteLocked(id)
+}
+
+func (s *UploadSessionStore) getLocked(id string, userID primitive.ObjectID) (*UploadSession, error) {
+	session, ok := s.sessions[id]
+	// Other users' sessions are reported as missing rather than forbidden
+	if !ok || session.UserID != userID || time.Since(session.CreatedAt) > uploadSessionTTL {
+		return nil, ErrUploadSessionNotFound
+	}
+	return session, nil
+}
+
+func (s *UploadSessionStore) deleteLocked(id string) {
+	if session, ok := s.sessions[id]; ok {
+		os.RemoveAll(session.dir)
+		delete(s.sessions, id)
+	}
+}
+
+// purgeExpiredLocked drops sessions that were abandoned past their TTL
+func (s *UploadSessionStore) purgeExpiredLocked() {
+	for id, session := range s.sessions {
+		if time.Since(session.CreatedAt) > uploadSessionTTL {
+			s.deleteLocked(id)
+		}
+	}
+}
+
+func (u *UploadSession) chunkPath(index int) string {
+	return filepath.Join(u.dir, fmt.Sprintf("%06d.part", index))
+}
+
+func appendFile(dst io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(dst, f)
+	return err
+}
+
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate upload ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,75 @@
+// Package uploadlimit caps how many uploads an instance receives at once
+package uploadlimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"streamflow/internal/apperr"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// retryAfter is how long clients are told to wait before retrying a rejected upload
+const retryAfter = 10 * time.Second
+
+// Limiter caps how many uploads an instance handles at once, which bounds the disk writes,
+// probing and storage transfers they cause. It is separate from the processing workers,
+// which bound transcoding. It doesn't bound memory: Fiber reads a request body, up to
+// the configured body limit, before any handler runs. A nil limiter lets every upload
+// through.
+type Limiter struct {
+	slots chan struct{}
+	wait  time.Duration
+}
+
+// New allows limit uploads at once. An upload over the limit waits up to wait for a
+// slot, or is rejected at once if wait is 0. It returns nil, allowing any number of
+// uploads, if limit is 0.
+func New(limit int, wait time.Duration) *Limiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &Limiter{slots: make(chan struct{}, limit), wait: wait}
+}
+
+// Acquire takes a slot, waiting for one as configured. ok is false if none freed up in
+// time; otherwise release must be called once the upload is done.
+func (l *Limiter) Acquire(ctx context.Context) (release func(), ok bool) {
+	if l == nil {
+		return func() {}, true
+	}
+	release = func() { <-l.slots }
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, true
+	default:
+	}
+	if l.wait <= 0 {
+		return nil, false
+	}
+
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return release, true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return nil, false
+}
+
+// Handler runs the handler after it only while a slot is free, responding 503 with a
+// Retry-After header otherwise
+func (l *Limiter) Handler(c *fiber.Ctx) error {
+	release, ok := l.Acquire(c.Context())
+	if !ok {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(retryAfter.Seconds())))
+		return apperr.Unavailable("Too many uploads in progress, try again later")
+	}
+	defer release()
+	return c.Next()
+}
@@ -0,0 +1,117 @@
+package uploadlimit
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"streamflow/internal/apperr"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLimiter_Handler(t *testing.T) {
+	const limit = 2
+
+	// newApp serves an upload route that holds its slot until release is closed
+	newApp := func(limiter *Limiter) (*fiber.App, chan struct{}, chan struct{}) {
+		entered, release := make(chan struct{}, 10), make(chan struct{})
+		app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+		app.Post("/upload", limiter.Handler, func(c *fiber.Ctx) error {
+			entered <- struct{}{}
+			<-release
+			return c.SendStatus(fiber.StatusCreated)
+		})
+		return app, entered, release
+	}
+	type result struct {
+		status     int
+		retryAfter string
+	}
+	upload := func(app *fiber.App, results chan<- result) {
+		resp, err := app.Test(httptest.NewRequest("POST", "/upload", nil), -1)
+		if err != nil {
+			results <- result{}
+			return
+		}
+		results <- result{resp.StatusCode, resp.Header.Get(fiber.HeaderRetryAfter)}
+	}
+
+	t.Run("Uploads over the limit are rejected", func(t *testing.T) {
+		app, entered, release := newApp(New(limit, 0))
+		admitted := make(chan result, limit)
+		for i := 0; i < limit; i++ {
+			go upload(app, admitted)
+		}
+		for i := 0; i < limit; i++ {
+			<-entered
+		}
+
+		// Every slot is taken, so each further upload fails straight away
+		const overflow = 3
+		rejected := make(chan result, overflow)
+		var wg sync.WaitGroup
+		for i := 0; i < overflow; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				upload(app, rejected)
+			}()
+		}
+		wg.Wait()
+		close(rejected)
+		for resp := range rejected {
+			if resp.status != fiber.StatusServiceUnavailable || resp.retryAfter != "10" {
+				t.Errorf("Upload over the limit got %d with Retry-After %q, want 503 with Retry-After 10", resp.status, resp.retryAfter)
+			}
+		}
+
+		close(release)
+		for i := 0; i < limit; i++ {
+			if resp := <-admitted; resp.status != fiber.StatusCreated {
+				t.Errorf("Upload within the limit got %d, want 201", resp.status)
+			}
+		}
+
+		// Finished uploads free their slots
+		statuses := make(chan result, 1)
+		upload(app, statuses)
+		if resp := <-statuses; resp.status != fiber.StatusCreated {
+			t.Errorf("Upload after the others finished got %d, want 201", resp.status)
+		}
+	})
+
+	t.Run("Uploads over the limit can queue", func(t *testing.T) {
+		app, entered, release := newApp(New(1, 5*time.Second))
+		statuses := make(chan result, 2)
+		go upload(app, statuses)
+		<-entered
+		go upload(app, statuses)
+
+		select {
+		case <-entered:
+			t.Fatal("Queued upload ran while the limit was reached")
+		case <-time.After(100 * time.Millisecond):
+		}
+		close(release)
+		for i := 0; i < 2; i++ {
+			if resp := <-statuses; resp.status != fiber.StatusCreated {
+				t.Errorf("Upload got %d, want 201", resp.status)
+			}
+		}
+	})
+
+	t.Run("No limit", func(t *testing.T) {
+		if New(0, 0) != nil {
+			t.Error("New(0) should allow any number of uploads")
+		}
+		app, _, release := newApp(nil)
+		close(release)
+		statuses := make(chan result, 1)
+		upload(app, statuses)
+		if resp := <-statuses; resp.status != fiber.StatusCreated {
+			t.Errorf("Upload without a limiter got %d, want 201", resp.status)
+		}
+	})
+}
@@ -2,10 +2,13 @@ package video
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"mime/multipart"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -16,6 +19,8 @@ import (
 const (
 	MaxFileSize = 500 * 1024 * 1024 // 500MB
 	MaxDuration = 3600               // 1 hour in seconds
+	MaxChapters = 100
+	MaxChapterTitleLength = 100
 )
 
 var AllowedVideoTypes = map[string]bool{
@@ -42,125 +47,153 @@ func (e ValidationError) Error() string {
 // ValidateVideoFile performs comprehensive validation of uploaded video files
 func ValidateVideoFile(file *multipart.FileHeader) error {
 	// Check file size
-	if file.Size > MaxFileSize {
-		return ValidationError{
-			Field:   "file",
-			Message: fmt.Sprintf("File size %d bytes exceeds maximum allowed size of %d bytes", file.Size, MaxFileSize),
-		}
+	if err := validateVideoSize(file.Size); err != nil {
+		return err
 	}
 
-	// Check file type
-	contentType := file.Header.Get("Content-Type")
-	if !AllowedVideoTypes[contentType] {
+	// Check file type from the content itself; the client-supplied Content-Type is not trusted
+	f, err := file.Open()
+	if err != nil {
 		return ValidationError{
 			Field:   "file",
-			Message: fmt.Sprintf("File type %s is not allowed. Allowed types: %v", contentType, getAllowedTypes()),
+			Message: "Unable to read uploaded file",
 		}
 	}
+	defer f.Close()
+
+	if err := ValidateVideoContent(f); err != nil {
+		return err
+	}
 
 	// Check file extension
-	ext := strings.ToLower(filepath.Ext(file.Filename))
-	allowedExts := []string{".mp4", ".avi", ".mov", ".mkv", ".webm"}
-	allowed := false
-	for _, allowedExt := range allowedExts {
-		if ext == allowedExt {
-			allowed = true
-			break
+	return validateVideoExtension(file.Filename)
+}
+
+// ValidateUploadRequest describes a file a client intends to upload
+type ValidateUploadRequest struct {
+	Filename    string `json:"filename"`
+	Size        int64  `json:"size"` // In bytes
+	ContentType string `json:"content_type"`
+}
+
+// UploadValidation reports whether a described upload would be accepted, and if not why
+type UploadValidation struct {
+	Accepted bool   `json:"Accepted"`
+	Field    string `json:"Field,omitempty"`
+	Reason   string `json:"Reason,omitempty"`
+}
+
+// ValidateVideoUpload runs the checks of ValidateVideoFile on the description of a file
+// instead of its bytes. contentType is the one the client declares; the upload itself is
+// still checked against the type sniffed from its content. VideoService.ValidateUpload
+// adds the configured limits.
+func ValidateVideoUpload(filename string, size int64, contentType string) error {
+	if err := validateVideoSize(size); err != nil {
+		return err
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ValidationError{
+			Field:   "content_type",
+			Message: fmt.Sprintf("Invalid content type %q", contentType),
 		}
 	}
-	if !allowed {
+	if err := validateVideoContentType(mediaType); err != nil {
+		return err
+	}
+
+	return validateVideoExtension(filename)
+}
+
+// validateVideoSize checks the file isn't larger than MaxFileSize
+func validateVideoSize(size int64) error {
+	if size > MaxFileSize {
 		return ValidationError{
 			Field:   "file",
-			Message: fmt.Sprintf("File extension %s is not allowed. Allowed extensions: %v", ext, allowedExts),
+			Message: fmt.Sprintf("File size %d bytes exceeds maximum allowed size of %d bytes", size, MaxFileSize),
 		}
 	}
-
 	return nil
 }
 
-// ExtractVideoMetadata extracts video metadata using ffprobe
-func ExtractVideoMetadata(filePath string) (*VideoMetadata, error) {
-	// Use ffprobe to get video information
-	cmd := exec.Command("ffprobe",
-		"-v", "quiet",
-		"-print_format", "json",
-		"-show_format",
-		"-show_streams",
-		filePath)
+// sniffLen is how many leading bytes are inspected to detect a file's real type
+const sniffLen = 512
 
-	var out bytes.Buffer
-	cmd.Stdout = &out
-
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("failed to extract metadata: %w", err)
+// ValidateVideoContent checks that the leading bytes of r are one of the allowed video containers
+func ValidateVideoContent(r io.Reader) error {
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return ValidationError{
+			Field:   "file",
+			Message: "Unable to read uploaded file",
+		}
 	}
 
-	var result struct {
-		Format  map[string]interface{} `json:"format"`
-		Streams []struct {
-			CodecType string  `json:"codec_type"`
-			CodecName string  `json:"codec_name"`
-			Width     int     `json:"width,omitempty"`
-			Height    int     `json:"height,omitempty"`
-			Duration  string  `json:"duration,omitempty"`
-			BitRate   string  `json:"bit_rate,omitempty"`
-			RFrameRate string `json:"r_frame_rate,omitempty"`
-		} `json:"streams"`
-	}
+	return validateVideoContentType(detectVideoContentType(header[:n]))
+}
 
-	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
-		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+// validateVideoContentType checks the MIME type is one of AllowedVideoTypes
+func validateVideoContentType(contentType string) error {
+	if !AllowedVideoTypes[contentType] {
+		return ValidationError{
+			Field:   "file",
+			Message: fmt.Sprintf("File content type %s is not allowed. Allowed types: %v", contentType, getAllowedTypes()),
+		}
 	}
+	return nil
+}
 
-	metadata := &VideoMetadata{}
+// detectVideoContentType identifies a file's MIME type from its magic bytes. ISO base media
+// and Matroska files are told apart by their brand and doctype, which http.DetectContentType
+// does not distinguish reliably.
+func detectVideoContentType(header []byte) string {
+	// ISO base media: [size]["ftyp"][major brand]...
+	if len(header) >= 12 && string(header[4:8]) == "ftyp" {
+		if string(header[8:12]) == "qt  " {
+			return "video/quicktime"
+		}
+		return "video/mp4"
+	}
 
-	// Get file size
-	if fileInfo, err := os.Stat(filePath); err == nil {
-		metadata.FileSize = fileInfo.Size()
+	// EBML header shared by Matroska and WebM; the doctype names which one it is
+	if bytes.HasPrefix(header, []byte{0x1A, 0x45, 0xDF, 0xA3}) && bytes.Contains(header, []byte("matroska")) {
+		return "video/x-matroska"
 	}
 
-	// Extract format information
-	if format, ok := result.Format["duration"]; ok {
-		if durationStr, ok := format.(string); ok {
-			if duration, err := strconv.ParseFloat(durationStr, 64); err == nil {
-				metadata.Duration = duration
-			}
-		}
+	contentType := http.DetectContentType(header)
+	// Drop parameters such as "; charset=utf-8"
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
 	}
+	return contentType
+}
 
-	// Extract stream information
-	for _, stream := range result.Streams {
-		if stream.CodecType == "video" {
-			metadata.Width = stream.Width
-			metadata.Height = stream.Height
-			metadata.Codec = stream.CodecName
-			
-			// Parse frame rate
-			if stream.RFrameRate != "" {
-				parts := strings.Split(stream.RFrameRate, "/")
-				if len(parts) == 2 {
-					if num, err := strconv.ParseFloat(parts[0], 64); err == nil {
-						if den, err := strconv.ParseFloat(parts[1], 64); err == nil && den > 0 {
-							metadata.FrameRate = num / den
-						}
-					}
-				}
-			}
-		} else if stream.CodecType == "audio" {
-			metadata.AudioCodec = stream.CodecName
+// validateVideoExtension checks the filename has one of the allowed video extensions
+func validateVideoExtension(filename string) error {
+	ext := strings.ToLower(filepath.Ext(filename))
+	allowedExts := []string{".mp4", ".avi", ".mov", ".mkv", ".webm"}
+	allowed := false
+	for _, allowedExt := range allowedExts {
+		if ext == allowedExt {
+			allowed = true
+			break
 		}
 	}
-
-	// Calculate bitrate
-	if format, ok := result.Format["bit_rate"]; ok {
-		if bitrateStr, ok := format.(string); ok {
-			if bitrate, err := strconv.Atoi(bitrateStr); err == nil {
-				metadata.Bitrate = bitrate / 1000 // Convert to kbps
-			}
+	if !allowed {
+		return ValidationError{
+			Field:   "file",
+			Message: fmt.Sprintf("File extension %s is not allowed. Allowed extensions: %v", ext, allowedExts),
 		}
 	}
 
-	return metadata, nil
+	return nil
+}
+
+// ExtractVideoMetadata extracts video metadata using ffprobe
+func ExtractVideoMetadata(filePath string) (*VideoMetadata, error) {
+	return NewFFmpegService().ProbeMetadata(context.Background(), filePath)
 }
 
 // DetectCorruptVideo checks if the video file is corrupted
@@ -188,28 +221,75 @@ func DetectCorruptVideo(filePath string) error {
 	return nil
 }
 
-// GenerateThumbnail creates a thumbnail from the video file
-func GenerateThumbnail(videoPath, thumbnailPath string) error {
-	// Create thumbnail directory if it doesn't exist
-	thumbnailDir := filepath.Dir(thumbnailPath)
-	if err := os.MkdirAll(thumbnailDir, 0755); err != nil {
-		return fmt.Errorf("failed to create thumbnail directory: %w", err)
+// DefaultThumbnailAt is used when no thumbnail timestamp is configured
+const DefaultThumbnailAt = "10%"
+
+// thumbnailEndMargin keeps the seek position before the last frame so ffmpeg still has a frame to extract
+const thumbnailEndMargin = 0.1
+
+// ThumbnailAt is a thumbnail timestamp, either a fixed offset ("5s") or a percentage of the duration ("10%")
+type ThumbnailAt struct {
+	value   float64
+	percent bool
+}
+
+// ParseThumbnailAt parses a "10%" or "5s" thumbnail timestamp. An empty spec yields DefaultThumbnailAt.
+func ParseThumbnailAt(spec string) (ThumbnailAt, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		spec = DefaultThumbnailAt
 	}
 
-	// Use ffmpeg to generate thumbnail at 5 seconds into the video
-	cmd := exec.Command("ffmpeg",
-		"-i", videoPath,
-		"-ss", "00:00:05", // Seek to 5 seconds
-		"-vframes", "1",   // Extract 1 frame
-		"-vf", "scale=320:240", // Scale to 320x240
-		"-y", // Overwrite output file
-		thumbnailPath)
+	var at ThumbnailAt
+	var number string
+	switch {
+	case strings.HasSuffix(spec, "%"):
+		at.percent = true
+		number = strings.TrimSuffix(spec, "%")
+	case strings.HasSuffix(spec, "s"):
+		number = strings.TrimSuffix(spec, "s")
+	default:
+		return ThumbnailAt{}, fmt.Errorf("invalid thumbnail timestamp %q: must end in %% or s", spec)
+	}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to generate thumbnail: %w", err)
+	value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || value < 0 {
+		return ThumbnailAt{}, fmt.Errorf("invalid thumbnail timestamp %q: must be a non-negative number", spec)
 	}
+	at.value = value
 
-	return nil
+	return at, nil
+}
+
+// Seconds resolves the timestamp against the video duration, clamped to the last valid position.
+// A non-positive duration leaves fixed offsets unclamped.
+func (t ThumbnailAt) Seconds(duration float64) float64 {
+	seconds := t.value
+	if t.percent {
+		seconds = duration * t.value / 100
+	}
+
+	if duration > 0 {
+		last := duration - thumbnailEndMargin
+		if last < 0 {
+			last = 0
+		}
+		if seconds > last {
+			seconds = last
+		}
+	}
+
+	return seconds
+}
+
+// GenerateThumbnail creates a thumbnail from the video file at the given timestamp
+func GenerateThumbnail(videoPath, thumbnailPath string, at ThumbnailAt, duration float64) error {
+	return NewFFmpegService().GenerateThumbnail(context.Background(), videoPath, at.Seconds(duration), thumbnailPath)
+}
+
+// formatSeekSeconds formats a position for ffmpeg's -ss option
+func formatSeekSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
 }
 
 // CleanupFailedUpload removes files created during failed upload process
@@ -255,4 +335,59 @@ func getAllowedTypes() []string {
 		types = append(types, t)
 	}
 	return types
-} 
\ No newline at end of file
+} 
+// ValidateChapters checks that chapters are in strictly increasing order, start within the
+// video and have a title. duration of 0 means the length is unknown and is not checked.
+func ValidateChapters(chapters []Chapter, duration float64) error {
+	if len(chapters) > MaxChapters {
+		return ValidationError{
+			Field:   "chapters",
+			Message: fmt.Sprintf("A video can have at most %d chapters", MaxChapters),
+		}
+	}
+
+	for i, chapter := range chapters {
+		title := strings.TrimSpace(chapter.Title)
+		if title == "" {
+			return ValidationError{
+				Field:   "chapters",
+				Message: fmt.Sprintf("Chapter %d has no title", i+1),
+			}
+		}
+		if len(title) > MaxChapterTitleLength {
+			return ValidationError{
+				Field:   "chapters",
+				Message: fmt.Sprintf("Chapter %d title is longer than %d characters", i+1, MaxChapterTitleLength),
+			}
+		}
+		if chapter.StartSeconds < 0 {
+			return ValidationError{
+				Field:   "chapters",
+				Message: fmt.Sprintf("Chapter %d (%s) starts at a negative time", i+1, title),
+			}
+		}
+		if duration > 0 && chapter.StartSeconds >= duration {
+			return ValidationError{
+				Field:   "chapters",
+				Message: fmt.Sprintf("Chapter %d (%s) starts at %.2fs, beyond the video duration of %.2fs", i+1, title, chapter.StartSeconds, duration),
+			}
+		}
+		if i > 0 {
+			prev := chapters[i-1]
+			if chapter.StartSeconds == prev.StartSeconds {
+				return ValidationError{
+					Field:   "chapters",
+					Message: fmt.Sprintf("Chapter %d (%s) overlaps chapter %d: both start at %.2fs", i+1, title, i, chapter.StartSeconds),
+				}
+			}
+			if chapter.StartSeconds < prev.StartSeconds {
+				return ValidationError{
+					Field:   "chapters",
+					Message: fmt.Sprintf("Chapter %d (%s) starts at %.2fs, before chapter %d at %.2fs; chapters must be in order", i+1, title, chapter.StartSeconds, i, prev.StartSeconds),
+				}
+			}
+		}
+	}
+
+	return nil
+}
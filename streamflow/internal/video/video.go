@@ -1,6 +1,7 @@
 package video
 
 import (
+	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -15,6 +16,36 @@ const (
 	StatusFailed VideoStatus = "FAILED"
 )
 
+// IsValid reports whether the status is one of the known video states
+func (s VideoStatus) IsValid() bool {
+	switch s {
+	case StatusPending, StatusProcessing, StatusCompleted, StatusFailed:
+		return true
+	}
+	return false
+}
+
+// Visibility controls who can find and watch a video
+type Visibility string
+
+const (
+	// VisibilityPublic videos are listed, searchable and watchable by anyone
+	VisibilityPublic Visibility = "public"
+	// VisibilityUnlisted videos are watchable by anyone with the video ID but never listed
+	VisibilityUnlisted Visibility = "unlisted"
+	// VisibilityPrivate videos are only visible to their owner
+	VisibilityPrivate Visibility = "private"
+)
+
+// IsValid reports whether the visibility is one of the known levels
+func (v Visibility) IsValid() bool {
+	switch v {
+	case VisibilityPublic, VisibilityUnlisted, VisibilityPrivate:
+		return true
+	}
+	return false
+}
+
 type VideoMetadata struct {
 	Duration    float64 `bson:"duration" json:"Duration"`         // Duration in seconds
 	Width       int     `bson:"width" json:"Width"`               // Video width in pixels
@@ -24,6 +55,7 @@ type VideoMetadata struct {
 	Bitrate     int     `bson:"bitrate" json:"Bitrate"`           // Video bitrate in kbps
 	FrameRate   float64 `bson:"frame_rate" json:"FrameRate"`      // Frames per second
 	FileSize    int64   `bson:"file_size" json:"FileSize"`        // Original file size in bytes
+	Container   string  `bson:"container,omitempty" json:"Container,omitempty"` // ffprobe format names (e.g., "mov,mp4,m4a,3gp,3g2,mj2")
 }
 
 type Video struct {
@@ -31,13 +63,141 @@ type Video struct {
 	Title       string             `bson:"title" json:"Title"`
 	Description string             `bson:"description" json:"Description"`
 	Status      VideoStatus        `bson:"status" json:"Status"`
+	Visibility  Visibility         `bson:"visibility,omitempty" json:"Visibility"` // Empty on videos from before visibility levels, which are public
 	CreatedAt   time.Time          `bson:"created_at" json:"CreatedAt"`
 	UpdatedAt   time.Time          `bson:"updated_at" json:"UpdatedAt"`
 	UserID      primitive.ObjectID `bson:"user_id" json:"UserID"`
 	ViewCount   int64              `bson:"view_count" json:"ViewCount"`
+	LikeCount   int64              `bson:"like_count" json:"LikeCount"`
+	DownloadCount int64            `bson:"download_count" json:"DownloadCount"` // Counted apart from views
 	FilePath    string             `bson:"file_path" json:"FilePath"`         // Path to original uploaded file
 	HLSPath     string             `bson:"hls_path" json:"HLSPath"`           // Path to HLS playlist
+	Renditions  []Rendition        `bson:"renditions,omitempty" json:"Renditions,omitempty"` // HLS variants produced, lowest first
 	ThumbnailPath string           `bson:"thumbnail_path" json:"ThumbnailPath"` // Path to thumbnail image
+	ThumbnailCandidates []string   `bson:"thumbnail_candidates,omitempty" json:"ThumbnailCandidates,omitempty"` // GridFS IDs of selectable thumbnails
 	Metadata    VideoMetadata      `bson:"metadata" json:"Metadata"`          // Video metadata
 	Error       string             `bson:"error,omitempty" json:"Error,omitempty"` // Error message if processing failed
+	ProcessingProgress int         `bson:"processing_progress" json:"ProcessingProgress"` // Percent of processing done, 100 once completed
+	DeletedAt   *time.Time         `bson:"deleted_at,omitempty" json:"DeletedAt,omitempty"` // Set while the video is soft-deleted
+	Chapters    []Chapter          `bson:"chapters,omitempty" json:"Chapters"`                // Chapter markers in playback order
+	Tags        []string           `bson:"tags,omitempty" json:"Tags"`                        // Normalized by tags.Parse, used to find related videos
+	SourceStreamID *primitive.ObjectID `bson:"source_stream_id,omitempty" json:"SourceStreamID,omitempty"` // Livestream this video was recorded from
+}
+
+// Rendition is an HLS variant a video was transcoded to
+type Rendition struct {
+	Name      string `bson:"name" json:"Name"`
+	Height    int    `bson:"height" json:"Height"`
+	Bandwidth int    `bson:"bandwidth" json:"Bandwidth"` // Peak bits per second
+}
+
+// VisibleTo reports whether requesterID may see the video. A zero requesterID is an
+// anonymous viewer.
+func (v *Video) VisibleTo(requesterID primitive.ObjectID) bool {
+	return v.Visibility != VisibilityPrivate || (!requesterID.IsZero() && v.UserID == requesterID)
+}
+
+// ETag returns a weak entity tag of the video for conditional requests. It changes
+// whenever UpdatedAt does; counters such as views are updated without it, so a cached
+// copy may show stale counts.
+func (v *Video) ETag() string {
+	return fmt.Sprintf(`W/"%s-%x"`, v.ID.Hex(), v.UpdatedAt.UnixMilli())
+}
+
+// ProcessingStatus reports how far a video has been processed
+type ProcessingStatus struct {
+	VideoID  primitive.ObjectID `json:"VideoID"`
+	Status   VideoStatus        `json:"Status"`
+	Progress int                `json:"Progress"`      // Percent done, 0-100
+	Error    string             `json:"Error,omitempty"`
+	Job      *ProcessingJob     `json:"Job,omitempty"` // Latest processing job; recordings have none
+}
+
+// Chapter marks a named section of a video starting at StartSeconds
+type Chapter struct {
+	StartSeconds float64 `bson:"start_seconds" json:"StartSeconds"`
+	Title        string  `bson:"title" json:"Title"`
+}
+
+// SetChaptersRequest defines the body for replacing a video's chapters
+type SetChaptersRequest struct {
+	Chapters []Chapter `json:"chapters"`
+}
+
+// BatchDeleteRequest defines the body for deleting several videos at once
+type BatchDeleteRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// BatchDeleteResult counts the outcome of a batch delete. Videos not found or owned by
+// another user are skipped.
+type BatchDeleteResult struct {
+	Deleted    int                  `json:"Deleted"`
+	Skipped    int                  `json:"Skipped"`
+	DeletedIDs []primitive.ObjectID `json:"-"` // For the audit log
+}
+
+// PaginatedVideos is one page of a video listing, with the totals needed to page through it
+type PaginatedVideos struct {
+	Videos     []*Video `json:"Videos"`
+	Page       int      `json:"Page"`
+	Limit      int      `json:"Limit"`
+	Total      int64    `json:"Total"`
+	TotalPages int      `json:"TotalPages"`
+}
+
+// Uploader is the public profile of the user who uploaded a video
+type Uploader struct {
+	ID         primitive.ObjectID `bson:"_id" json:"ID"`
+	UserName   string             `bson:"user_name" json:"UserName"`
+	AvatarPath string             `bson:"avatar_path,omitempty" json:"AvatarPath,omitempty"`
+}
+
+// VideoWithUploader is a video along with its uploader's profile. Uploader is nil when
+// the uploader's account no longer exists.
+type VideoWithUploader struct {
+	Video    `bson:",inline"`
+	Uploader *Uploader `bson:"uploader,omitempty" json:"Uploader"`
+}
+
+// Like records that a user liked a video. The (video_id, user_id) pair is unique.
+type Like struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"ID"`
+	VideoID   primitive.ObjectID `bson:"video_id" json:"VideoID"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"UserID"`
+	CreatedAt time.Time          `bson:"created_at" json:"CreatedAt"`
+}
+
+// Playlist is a user-created, ordered collection of videos
+type Playlist struct {
+	ID        primitive.ObjectID   `bson:"_id,omitempty" json:"ID"`
+	UserID    primitive.ObjectID   `bson:"user_id" json:"UserID"`
+	Name      string               `bson:"name" json:"Name"`
+	VideoIDs  []primitive.ObjectID `bson:"video_ids" json:"VideoIDs"`
+	CreatedAt time.Time            `bson:"created_at" json:"CreatedAt"`
+}
+
+// PlaylistWithVideos is a playlist with its video documents loaded, in playlist order
+type PlaylistWithVideos struct {
+	Playlist
+	Videos []*Video `json:"Videos"`
+}
+
+// WatchHistory records how far a user has watched a video. The (user_id, video_id) pair is unique.
+type WatchHistory struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"ID"`
+	UserID          primitive.ObjectID `bson:"user_id" json:"UserID"`
+	VideoID         primitive.ObjectID `bson:"video_id" json:"VideoID"`
+	PositionSeconds float64            `bson:"position_seconds" json:"PositionSeconds"`
+	UpdatedAt       time.Time          `bson:"updated_at" json:"UpdatedAt"`
+}
+
+// View records that a viewer watched a video within one dedup time bucket.
+// The (video_id, viewer_key, bucket) triple is unique.
+type View struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"ID"`
+	VideoID   primitive.ObjectID `bson:"video_id" json:"VideoID"`
+	ViewerKey string             `bson:"viewer_key" json:"-"`
+	Bucket    time.Time          `bson:"bucket" json:"Bucket"`
+	CreatedAt time.Time          `bson:"created_at" json:"CreatedAt"`
 }
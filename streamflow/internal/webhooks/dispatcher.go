@@ -0,0 +1,116 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Event names delivered in the "event" field of every payload
+const (
+	EventVideoUploaded  = "video.uploaded"
+	EventVideoCompleted = "video.completed"
+	EventStreamStarted  = "stream.started"
+	EventStreamEnded    = "stream.ended"
+	EventUserRegistered = "user.registered"
+)
+
+// Event is the JSON payload of a webhook. Only the IDs relevant to the event are set.
+type Event struct {
+	Event    string    `json:"event"`
+	VideoID  string    `json:"video_id,omitempty"`
+	StreamID string    `json:"stream_id,omitempty"`
+	UserID   string    `json:"user_id,omitempty"`
+	Ts       time.Time `json:"ts"`
+}
+
+// deadLetter is a line of the dead-letter log: a delivery that failed every attempt
+type deadLetter struct {
+	URL      string          `json:"url"`
+	Payload  json.RawMessage `json:"payload"`
+	Error    string          `json:"error"`
+	FailedAt time.Time       `json:"failed_at"`
+}
+
+// WebhookDispatcher fires events through a Client and records deliveries that run out of
+// retries in a dead-letter log, one JSON object per line, so they can be replayed.
+// A nil dispatcher drops every event, so callers don't need to check whether webhooks
+// are configured.
+type WebhookDispatcher struct {
+	client         *Client
+	deadLetterPath string
+	mu             sync.Mutex // Serializes writes to the dead-letter log
+}
+
+// NewWebhookDispatcher creates a dispatcher. An empty deadLetterPath only logs failed deliveries.
+func NewWebhookDispatcher(client *Client, deadLetterPath string) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		client:         client,
+		deadLetterPath: deadLetterPath,
+	}
+}
+
+// Dispatch delivers the event in the background. Ts is filled in if unset.
+func (d *WebhookDispatcher) Dispatch(event Event) {
+	if d == nil {
+		return
+	}
+	if event.Ts.IsZero() {
+		event.Ts = time.Now()
+	}
+	d.client.send(event, d.recordDeadLetter)
+}
+
+// Shutdown waits for pending deliveries, including their retries, until ctx is done.
+// Deliveries still pending then are abandoned without a dead letter.
+func (d *WebhookDispatcher) Shutdown(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	return d.client.Wait(ctx)
+}
+
+// recordDeadLetter appends a failed delivery to the dead-letter log
+func (d *WebhookDispatcher) recordDeadLetter(url string, body []byte, deliveryErr error) {
+	log.Printf("Webhook: giving up on %s: %v", url, deliveryErr)
+	if d.deadLetterPath == "" {
+		return
+	}
+
+	line, err := json.Marshal(deadLetter{
+		URL:      url,
+		Payload:  body,
+		Error:    deliveryErr.Error(),
+		FailedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("Webhook: failed to encode dead letter: %v", err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := appendLine(d.deadLetterPath, line); err != nil {
+		log.Printf("Webhook: failed to write dead letter: %v", err)
+	}
+}
+
+func appendLine(path string, line []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create dead-letter directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
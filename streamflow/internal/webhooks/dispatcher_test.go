@@ -0,0 +1,133 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookDispatcher_Dispatch(t *testing.T) {
+	const secret = "test-webhook-secret"
+
+	received := make(chan Event, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !Verify(secret, body, r.Header.Get(SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var event Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- event
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	dispatcher := NewWebhookDispatcher(NewClient([]string{receiver.URL}, secret, 1), "")
+	dispatcher.Dispatch(Event{Event: EventVideoUploaded, VideoID: "v1", UserID: "u1"})
+
+	select {
+	case event := <-received:
+		if event.Event != EventVideoUploaded || event.VideoID != "v1" || event.UserID != "u1" {
+			t.Errorf("Received event = %+v", event)
+		}
+		if event.Ts.IsZero() {
+			t.Error("Expected ts to be filled in")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for webhook")
+	}
+}
+
+func TestWebhookDispatcher_DeadLetter(t *testing.T) {
+	var attempts atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	client := NewClient([]string{receiver.URL}, "secret", 3)
+	client.initialBackoff = time.Millisecond
+	deadLetterPath := filepath.Join(t.TempDir(), "webhooks", "dead_letter.log")
+	dispatcher := NewWebhookDispatcher(client, deadLetterPath)
+
+	dispatcher.Dispatch(Event{Event: EventUserRegistered, UserID: "u1"})
+
+	var data []byte
+	deadline := time.Now().Add(5 * time.Second)
+	for len(data) == 0 && time.Now().Before(deadline) {
+		data, _ = os.ReadFile(deadLetterPath)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(data) == 0 {
+		t.Fatal("Failed delivery was not written to the dead-letter log")
+	}
+
+	if got := attempts.Load(); got != 3 {
+		t.Errorf("Delivery attempts = %d, want 3", got)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Dead-letter log has %d lines, want 1", len(lines))
+	}
+	var letter deadLetter
+	if err := json.Unmarshal([]byte(lines[0]), &letter); err != nil {
+		t.Fatalf("Failed to decode dead letter: %v", err)
+	}
+	if letter.URL != receiver.URL {
+		t.Errorf("Dead letter URL = %s, want %s", letter.URL, receiver.URL)
+	}
+	var event Event
+	if err := json.Unmarshal(letter.Payload, &event); err != nil || event.Event != EventUserRegistered {
+		t.Errorf("Dead letter payload = %s, want the %s event", letter.Payload, EventUserRegistered)
+	}
+	if letter.Error == "" {
+		t.Error("Dead letter should record the delivery error")
+	}
+}
+
+func TestWebhookDispatcher_Nil(t *testing.T) {
+	var dispatcher *WebhookDispatcher
+	// Must not panic when webhooks aren't configured
+	dispatcher.Dispatch(Event{Event: EventStreamStarted})
+}
+
+func TestWebhookDispatcher_Shutdown(t *testing.T) {
+	var delivered atomic.Bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		delivered.Store(true)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	dispatcher := NewWebhookDispatcher(NewClient([]string{receiver.URL}, "secret", 1), "")
+	dispatcher.Dispatch(Event{Event: EventStreamEnded, StreamID: "s1"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := dispatcher.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() unexpected error = %v", err)
+	}
+	if !delivered.Load() {
+		t.Error("Shutdown() returned before the pending delivery finished")
+	}
+
+	var nilDispatcher *WebhookDispatcher
+	if err := nilDispatcher.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown() on nil dispatcher error = %v", err)
+	}
+}
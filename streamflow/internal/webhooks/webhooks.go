@@ -0,0 +1,140 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"streamflow/internal/lifecycle"
+)
+
+// SignatureHeader carries the HMAC-SHA256 signature of the request body
+const SignatureHeader = "X-Signature"
+
+// Client delivers signed JSON payloads to the configured webhook URLs
+type Client struct {
+	urls           []string
+	secret         string
+	maxAttempts    int
+	initialBackoff time.Duration
+	httpClient     *http.Client
+	inflight       sync.WaitGroup // Deliveries still being attempted
+}
+
+// NewClient creates a webhook client. maxAttempts below 1 means a single attempt.
+func NewClient(urls []string, secret string, maxAttempts int) *Client {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &Client{
+		urls:           urls,
+		secret:         secret,
+		maxAttempts:    maxAttempts,
+		initialBackoff: 500 * time.Millisecond,
+		httpClient:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Send delivers the payload to every configured URL in the background.
+// Delivery is fire-and-forget: failures are retried with backoff and then logged.
+func (c *Client) Send(payload interface{}) {
+	c.send(payload, func(url string, _ []byte, err error) {
+		log.Printf("Webhook: giving up on %s: %v", url, err)
+	})
+}
+
+// send delivers the payload to every configured URL in the background and calls
+// onFailure for each URL that still fails after the last retry
+func (c *Client) send(payload interface{}, onFailure func(url string, body []byte, err error)) {
+	if c == nil || len(c.urls) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Webhook: failed to marshal payload: %v", err)
+		return
+	}
+
+	for _, url := range c.urls {
+		c.inflight.Add(1)
+		go func(url string) {
+			defer c.inflight.Done()
+			if err := c.deliver(url, body); err != nil {
+				onFailure(url, body, err)
+			}
+		}(url)
+	}
+}
+
+// Wait blocks until every delivery started so far has succeeded or given up, or ctx is done
+func (c *Client) Wait(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+	return lifecycle.Wait(ctx, &c.inflight)
+}
+
+// deliver POSTs the body to url, retrying with exponential backoff
+func (c *Client) deliver(url string, body []byte) error {
+	backoff := c.initialBackoff
+	var lastErr error
+
+	for attempt := 1; attempt <= c.maxAttempts; attempt++ {
+		if lastErr = c.post(url, body); lastErr == nil {
+			return nil
+		}
+
+		log.Printf("Webhook: attempt %d/%d to %s failed: %v", attempt, c.maxAttempts, url, lastErr)
+		if attempt < c.maxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	return lastErr
+}
+
+func (c *Client) post(url string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(c.secret, body))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the signature header value for body: "sha256=" followed by the hex HMAC
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is a valid signature of body
+func Verify(secret string, body []byte, signature string) bool {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"log"
+	"streamflow/internal/config"
 	"streamflow/internal/database"
 	"streamflow/internal/video"
 )
@@ -15,7 +16,7 @@ func main() {
 	defer db.Close()
 	
 	// Create video service
-	videoService := video.NewVideoService(db.GetDatabase())
+	videoService := video.NewVideoService(db.GetDatabase(), config.VideoConfig{})
 	
 	// Run field migration
 	ctx := context.Background()